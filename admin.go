@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"expvar"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"runtime/debug"
+	"time"
+)
+
+// newAdminHandler builds the handler for the internal admin listener.
+// It serves probes, metrics, profiling, and runtime diagnostics, keeping them
+// off the public port so they never compete with user traffic and are never
+// routed through the Ingress.
+func newAdminHandler(deps handlerDeps) http.Handler {
+	mux := http.NewServeMux()
+	requireAdmin := func(next http.HandlerFunc) http.HandlerFunc {
+		return adminAuth(deps.adminToken, deps.oidc, next)
+	}
+
+	// Kubernetes probes
+	mux.Handle("/healthz", deps.health)
+	mux.Handle("/readyz", deps.ready)
+
+	// Prometheus metrics
+	mux.Handle("/metrics", deps.metrics.Handler())
+
+	// Runtime feature flags
+	mux.HandleFunc("GET /flags", requireAdmin(deps.flags.handleList))
+	mux.HandleFunc("PUT /flags/{name}", requireAdmin(deps.audit.record("flag.update", deps.flags.handleToggle)))
+
+	// OIDC login for the admin endpoints and web UI
+	if deps.oidc != nil {
+		mux.HandleFunc("GET /auth/login", deps.oidc.handleLogin)
+		mux.HandleFunc("GET /auth/callback", deps.oidc.handleCallback)
+		mux.HandleFunc("POST /auth/logout", deps.oidc.handleLogout)
+		mux.HandleFunc("GET /auth/me", deps.oidc.handleMe)
+	}
+
+	// Runtime log level
+	logLevel := logLevelHandler{level: deps.logLevel}
+	mux.HandleFunc("GET /admin/loglevel", logLevel.handleGet)
+	mux.HandleFunc("PUT /admin/loglevel", requireAdmin(deps.audit.record("loglevel.update", logLevel.handlePut)))
+
+	// API key management
+	mux.HandleFunc("GET /admin/apikeys", requireAdmin(deps.apiKeys.handleList))
+	mux.HandleFunc("POST /admin/apikeys", requireAdmin(deps.audit.record("apikey.create", deps.apiKeys.handleCreate)))
+	mux.HandleFunc("PUT /admin/apikeys/{name}", requireAdmin(deps.audit.record("apikey.update", deps.apiKeys.handleToggle)))
+	mux.HandleFunc("DELETE /admin/apikeys/{name}", requireAdmin(deps.audit.record("apikey.revoke", deps.apiKeys.handleRevoke)))
+
+	// Audit log of mutating operations
+	if deps.audit != nil {
+		mux.HandleFunc("GET /admin/audit", requireAdmin(deps.audit.handleQuery))
+		mux.HandleFunc("GET /admin/audit/export", requireAdmin(deps.audit.handleExport))
+	}
+
+	// Recurring batches
+	if deps.schedules != nil {
+		mux.HandleFunc("GET /admin/schedules", requireAdmin(deps.schedules.handleList))
+		mux.HandleFunc("POST /admin/schedules/{name}/run", requireAdmin(deps.audit.record("schedule.run", deps.schedules.handleRun)))
+	}
+
+	// Dashboard for operators, and the cache and render queue stats it shows
+	mux.HandleFunc("GET /admin", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/admin/", http.StatusMovedPermanently)
+	})
+	mux.HandleFunc("GET /admin/", handleDashboard(deps.oidc))
+	mux.HandleFunc("GET /admin/stats", requireAdmin(handleStats(deps)))
+
+	// pprof profiles (heap, goroutine, CPU profile, execution trace, ...)
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+	// expvar variables (includes memstats and cmdline)
+	mux.Handle("/debug/vars", expvar.Handler())
+
+	// Garbage collector statistics
+	mux.HandleFunc("/debug/gcstats", handleGCStats)
+
+	return deps.adminAllowlist.middleware(mux)
+}
+
+// gcStatsResponse summarizes garbage collector and heap statistics
+type gcStatsResponse struct {
+	NumGC          int64         `json:"num_gc"`
+	LastGC         time.Time     `json:"last_gc"`
+	PauseTotal     time.Duration `json:"pause_total_ns"`
+	RecentPauses   []int64       `json:"recent_pauses_ns"`
+	HeapAlloc      uint64        `json:"heap_alloc_bytes"`
+	HeapInuse      uint64        `json:"heap_inuse_bytes"`
+	HeapObjects    uint64        `json:"heap_objects"`
+	NextGC         uint64        `json:"next_gc_bytes"`
+	TotalAlloc     uint64        `json:"total_alloc_bytes"`
+	Sys            uint64        `json:"sys_bytes"`
+	NumGoroutine   int           `json:"num_goroutine"`
+	GOMAXPROCS     int           `json:"gomaxprocs"`
+	MemoryLimit    int64         `json:"memory_limit_bytes"`
+	GCCPUFraction  float64       `json:"gc_cpu_fraction"`
+	PauseQuantiles []int64       `json:"pause_quantiles_ns"`
+}
+
+// handleGCStats reports garbage collector and heap statistics as JSON
+func handleGCStats(w http.ResponseWriter, r *http.Request) {
+	stats := debug.GCStats{PauseQuantiles: make([]time.Duration, 5)}
+	debug.ReadGCStats(&stats)
+
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	resp := gcStatsResponse{
+		NumGC:         stats.NumGC,
+		LastGC:        stats.LastGC,
+		PauseTotal:    stats.PauseTotal,
+		HeapAlloc:     mem.HeapAlloc,
+		HeapInuse:     mem.HeapInuse,
+		HeapObjects:   mem.HeapObjects,
+		NextGC:        mem.NextGC,
+		TotalAlloc:    mem.TotalAlloc,
+		Sys:           mem.Sys,
+		NumGoroutine:  runtime.NumGoroutine(),
+		GOMAXPROCS:    runtime.GOMAXPROCS(0),
+		MemoryLimit:   debug.SetMemoryLimit(-1), // a negative input only reads the current limit
+		GCCPUFraction: mem.GCCPUFraction,
+	}
+	for i, pause := range stats.Pause {
+		if i == 10 {
+			break
+		}
+		resp.RecentPauses = append(resp.RecentPauses, pause.Nanoseconds())
+	}
+	for _, q := range stats.PauseQuantiles {
+		resp.PauseQuantiles = append(resp.PauseQuantiles, q.Nanoseconds())
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
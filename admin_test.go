@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAdminHandler_Routes(t *testing.T) {
+	deps := newTestDeps()
+	deps.ready.SetReady(true)
+	handler := newAdminHandler(deps)
+
+	for _, path := range []string{"/healthz", "/readyz", "/metrics", "/debug/pprof/", "/debug/pprof/heap", "/debug/vars", "/debug/gcstats"} {
+		t.Run(path, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+
+			if rec.Code != http.StatusOK {
+				t.Errorf("GET %s status = %d, want %d", path, rec.Code, http.StatusOK)
+			}
+		})
+	}
+}
+
+func TestAdminHandler_GCStats(t *testing.T) {
+	rec := httptest.NewRecorder()
+	newAdminHandler(newTestDeps()).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/debug/gcstats", nil))
+
+	var stats gcStatsResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &stats); err != nil {
+		t.Fatalf("gcstats is not valid JSON: %v", err)
+	}
+	if stats.HeapAlloc == 0 || stats.NumGoroutine == 0 || stats.GOMAXPROCS == 0 {
+		t.Errorf("gcstats missing runtime values: %+v", stats)
+	}
+}
+
+func TestPublicHandler_DoesNotExposeAdminEndpoints(t *testing.T) {
+	handler := newTestHandler()
+
+	for _, path := range []string{"/debug/pprof/", "/metrics", "/healthz", "/readyz"} {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+
+		if rec.Code != http.StatusNotFound {
+			t.Errorf("public %s status = %d, want %d", path, rec.Code, http.StatusNotFound)
+		}
+	}
+}
+
+func TestAdminHandler_Metrics(t *testing.T) {
+	deps := newTestDeps()
+	newHandler(deps).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/api/v1/qr/generate?text=hello", nil))
+
+	rec := httptest.NewRecorder()
+	newAdminHandler(deps).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	body := rec.Body.String()
+	for _, want := range []string{
+		`qr_http_requests_total{code="200",method="POST",route="/api/v1/qr/generate"} 1`,
+		`qr_codes_generated_total 1`,
+		`qr_http_request_duration_seconds_bucket`,
+		`go_goroutines`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("metrics output missing %q", want)
+		}
+	}
+}
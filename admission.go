@@ -0,0 +1,130 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// errCodeOverloaded is returned for requests shed under overload
+const errCodeOverloaded = "overloaded"
+
+// Request priorities for admission control
+const (
+	priorityLow  = "low"
+	priorityHigh = "high"
+)
+
+// admissionController admits requests into a fixed number of slots and
+// measures how long they queue for one. When the average queue wait exceeds
+// the target, low-priority (anonymous) requests are shed immediately, so
+// authenticated clients keep their latency during overload. High-priority
+// requests wait for a slot until their context is done. A nil controller
+// admits everything.
+type admissionController struct {
+	slots  chan struct{}
+	target time.Duration
+	shed   *prometheus.CounterVec
+
+	mu sync.Mutex
+	// avgWait is an exponentially weighted moving average of queue waits
+	avgWait time.Duration
+}
+
+// newAdmissionController creates a controller with capacity slots. It returns
+// nil when capacity is not positive.
+func newAdmissionController(capacity int, target time.Duration, m *metrics) *admissionController {
+	if capacity <= 0 {
+		return nil
+	}
+	return &admissionController{slots: make(chan struct{}, capacity), target: target, shed: m.requestsShed}
+}
+
+// requestPriority ranks requests that carry credentials above anonymous ones.
+// Credentials are only verified later by requireAuth: a forged header gains
+// a place in the queue, not access.
+func requestPriority(r *http.Request) string {
+	if r.Header.Get("Authorization") != "" || r.Header.Get(apiKeyHeader) != "" || r.Header.Get(signatureHeader) != "" {
+		return priorityHigh
+	}
+	return priorityLow
+}
+
+// observe folds a queue wait into the moving average
+func (a *admissionController) observe(wait time.Duration) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.avgWait = (a.avgWait*4 + wait) / 5
+}
+
+// overloaded reports whether queue waits are above the target
+func (a *admissionController) overloaded() bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.avgWait > a.target
+}
+
+// admit waits for a slot. Low-priority requests are refused when the service
+// is overloaded or after waiting for the target; high-priority requests wait
+// until the request is done. It reports whether a slot was taken.
+func (a *admissionController) admit(r *http.Request, priority string) bool {
+	select {
+	case a.slots <- struct{}{}:
+		a.observe(0)
+		return true
+	default:
+	}
+	if priority == priorityLow && a.overloaded() {
+		return false
+	}
+
+	start := time.Now()
+	var timeout <-chan time.Time
+	if priority == priorityLow {
+		timer := time.NewTimer(a.target)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case a.slots <- struct{}{}:
+		a.observe(time.Since(start))
+		return true
+	case <-timeout:
+		a.observe(time.Since(start))
+		return false
+	case <-r.Context().Done():
+		a.observe(time.Since(start))
+		return false
+	}
+}
+
+// middleware applies admission control to all but probe endpoints. Shed
+// requests get 503 with Retry-After.
+func (a *admissionController) middleware(next http.Handler) http.Handler {
+	if a == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if probePaths[r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		priority := requestPriority(r)
+		if !a.admit(r, priority) {
+			a.shed.WithLabelValues(priority).Inc()
+			addLogAttrs(r.Context(), slog.String("shed_priority", priority))
+			w.Header().Set("Retry-After", "1")
+			writeAPIError(w, r, http.StatusServiceUnavailable, apiError{
+				Code:    errCodeOverloaded,
+				Message: "The service is overloaded, retry later",
+			})
+			return
+		}
+		defer func() { <-a.slots }()
+		next.ServeHTTP(w, r)
+	})
+}
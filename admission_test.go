@@ -0,0 +1,91 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestRequestPriority(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		want   string
+	}{
+		{name: "anonymous", want: priorityLow},
+		{name: "api key", header: apiKeyHeader, want: priorityHigh},
+		{name: "bearer token", header: "Authorization", want: priorityHigh},
+		{name: "signed request", header: signatureHeader, want: priorityHigh},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/api/v1/qr/generate", nil)
+			if tt.header != "" {
+				r.Header.Set(tt.header, "credential")
+			}
+			if got := requestPriority(r); got != tt.want {
+				t.Errorf("requestPriority() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAdmissionController(t *testing.T) {
+	tests := []struct {
+		name       string
+		avgWait    time.Duration
+		header     string
+		path       string
+		releaseIn  time.Duration
+		wantStatus int
+	}{
+		{name: "anonymous shed when overloaded", avgWait: time.Second, path: "/api/v1/qr/generate", wantStatus: http.StatusServiceUnavailable},
+		{name: "authenticated waits when overloaded", avgWait: time.Second, header: apiKeyHeader, path: "/api/v1/qr/generate", releaseIn: 100 * time.Millisecond, wantStatus: http.StatusOK},
+		{name: "anonymous queues briefly when healthy", path: "/api/v1/qr/generate", releaseIn: 5 * time.Millisecond, wantStatus: http.StatusOK},
+		{name: "anonymous shed after the target wait", path: "/api/v1/qr/generate", releaseIn: time.Second, wantStatus: http.StatusServiceUnavailable},
+		{name: "probes are exempt", avgWait: time.Second, path: "/health", wantStatus: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newMetrics()
+			a := newAdmissionController(1, 20*time.Millisecond, m)
+			a.avgWait = tt.avgWait
+			a.slots <- struct{}{} // the only slot is busy
+			if tt.releaseIn > 0 {
+				timer := time.AfterFunc(tt.releaseIn, func() { <-a.slots })
+				defer timer.Stop()
+			}
+
+			r := httptest.NewRequest(http.MethodPost, tt.path, nil)
+			if tt.header != "" {
+				r.Header.Set(tt.header, "credential")
+			}
+			rec := httptest.NewRecorder()
+			a.middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(rec, r)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			shed := testutil.ToFloat64(m.requestsShed.WithLabelValues(requestPriority(r)))
+			if wantShed := tt.wantStatus == http.StatusServiceUnavailable; (shed == 1) != wantShed {
+				t.Errorf("shed counter = %v, want shed %v", shed, wantShed)
+			}
+		})
+	}
+}
+
+func TestAdmissionController_Disabled(t *testing.T) {
+	if a := newAdmissionController(0, time.Second, newMetrics()); a != nil {
+		t.Fatalf("newAdmissionController(0) = %v, want nil", a)
+	}
+	var a *admissionController
+	rec := httptest.NewRecorder()
+	a.middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// amqpChannel is the part of *amqp.Channel the worker uses
+type amqpChannel interface {
+	Qos(prefetchCount, prefetchSize int, global bool) error
+	Consume(queue, consumer string, autoAck, exclusive, noLocal, noWait bool, args amqp.Table) (<-chan amqp.Delivery, error)
+	PublishWithContext(ctx context.Context, exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error
+	Close() error
+}
+
+// amqpWorker consumes generation jobs from a RabbitMQ queue. Up to prefetch
+// unacknowledged jobs are processed concurrently. A job is acknowledged once
+// its completion event is published, to the delivery's reply-to queue or to
+// the result routing key. Invalid jobs are rejected without requeueing, so the
+// queue's dead-letter exchange receives them; jobs failing transiently are
+// requeued or rejected the same way depending on requeueOnFailure.
+type amqpWorker struct {
+	// connect opens a channel and the connection to close with it
+	connect          func() (amqpChannel, io.Closer, error)
+	queue            string
+	resultExchange   string
+	resultRoutingKey string // empty disables completion events except to reply-to queues
+	prefetch         int
+	requeueOnFailure bool
+	processor        *jobProcessor
+	// retryInterval is the wait before reconnecting
+	retryInterval time.Duration
+	connected     atomic.Bool
+}
+
+// newAMQPWorker creates a worker consuming queue on the broker at url. A
+// prefetch of zero uses the render pool size.
+func newAMQPWorker(url, queue, resultExchange, resultRoutingKey string, prefetch int, requeueOnFailure bool, processor *jobProcessor) *amqpWorker {
+	if prefetch <= 0 {
+		prefetch = processor.deps.renderPool.size()
+	}
+	return &amqpWorker{
+		connect: func() (amqpChannel, io.Closer, error) {
+			conn, err := amqp.DialConfig(url, amqp.Config{Properties: amqp.Table{"connection_name": "qr-generator"}})
+			if err != nil {
+				return nil, nil, err
+			}
+			ch, err := conn.Channel()
+			if err != nil {
+				conn.Close()
+				return nil, nil, err
+			}
+			return ch, conn, nil
+		},
+		queue:            queue,
+		resultExchange:   resultExchange,
+		resultRoutingKey: resultRoutingKey,
+		prefetch:         prefetch,
+		requeueOnFailure: requeueOnFailure,
+		processor:        processor,
+		retryInterval:    time.Second,
+	}
+}
+
+// run consumes until ctx is done, reconnecting whenever the connection is
+// lost. Unacknowledged deliveries of a lost connection are redelivered by
+// the broker.
+func (a *amqpWorker) run(ctx context.Context) {
+	for {
+		if err := a.consume(ctx); err != nil {
+			slog.ErrorContext(ctx, "AMQP consumer failed", "error", err)
+		}
+		if ctx.Err() != nil || !sleepContext(ctx, a.retryInterval) {
+			return
+		}
+	}
+}
+
+// consume processes deliveries on one connection until it is lost or ctx is
+// done, waiting for the jobs in progress before closing it
+func (a *amqpWorker) consume(ctx context.Context) error {
+	ch, conn, err := a.connect()
+	if err != nil {
+		return fmt.Errorf("connect: %w", err)
+	}
+	defer conn.Close()
+	defer ch.Close()
+
+	if err := ch.Qos(a.prefetch, 0, false); err != nil {
+		return fmt.Errorf("set prefetch: %w", err)
+	}
+	deliveries, err := ch.Consume(a.queue, "", false, false, false, false, nil)
+	if err != nil {
+		return fmt.Errorf("consume %s: %w", a.queue, err)
+	}
+	a.connected.Store(true)
+	defer a.connected.Store(false)
+	slog.InfoContext(ctx, "consuming AMQP queue", "queue", a.queue, "prefetch", a.prefetch)
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case delivery, ok := <-deliveries:
+			if !ok {
+				return errors.New("delivery channel closed")
+			}
+			// The broker sends at most prefetch unacknowledged deliveries, which
+			// bounds the goroutines
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				a.handle(ctx, ch, delivery)
+			}()
+		}
+	}
+}
+
+// handle processes one delivery and acknowledges, requeues, or rejects it
+func (a *amqpWorker) handle(ctx context.Context, ch amqpChannel, delivery amqp.Delivery) {
+	result, err := a.processor.process(ctx, delivery.Body)
+	if err != nil {
+		// Shutting down: hand the job to another consumer
+		a.settle(ctx, delivery, delivery.Nack(false, true))
+		return
+	}
+	// The job is done: settle the delivery even if shutdown begins meanwhile
+	ctx = context.WithoutCancel(ctx)
+
+	if err := a.publish(ctx, ch, delivery, result); err != nil {
+		slog.ErrorContext(ctx, "failed to publish job result", "job_id", result.ID, "error", err)
+		a.settle(ctx, delivery, delivery.Nack(false, true))
+		return
+	}
+	switch {
+	case result.Status == jobSucceeded:
+		a.settle(ctx, delivery, delivery.Ack(false))
+	case result.retryable() && a.requeueOnFailure:
+		a.settle(ctx, delivery, delivery.Nack(false, true))
+	default:
+		a.settle(ctx, delivery, delivery.Nack(false, false))
+	}
+}
+
+// publish sends the completion event to the delivery's reply-to queue, as in
+// RabbitMQ's RPC pattern, or else to the result routing key
+func (a *amqpWorker) publish(ctx context.Context, ch amqpChannel, delivery amqp.Delivery, result jobResult) error {
+	exchange, key := a.resultExchange, a.resultRoutingKey
+	if delivery.ReplyTo != "" {
+		exchange, key = "", delivery.ReplyTo
+	}
+	if key == "" {
+		return nil
+	}
+	body, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+	return ch.PublishWithContext(ctx, exchange, key, false, false, amqp.Publishing{
+		ContentType:   "application/json",
+		DeliveryMode:  amqp.Persistent,
+		CorrelationId: delivery.CorrelationId,
+		Body:          body,
+	})
+}
+
+// settle logs a failed acknowledgement; the broker redelivers the job once
+// the channel closes
+func (a *amqpWorker) settle(ctx context.Context, delivery amqp.Delivery, err error) {
+	if err != nil {
+		slog.WarnContext(ctx, "failed to acknowledge AMQP delivery", "delivery_tag", delivery.DeliveryTag, "error", err)
+	}
+}
+
+// check reports whether the worker is consuming, for the health endpoint
+func (a *amqpWorker) check(ctx context.Context) error {
+	if !a.connected.Load() {
+		return errors.New("not connected to the AMQP broker")
+	}
+	return nil
+}
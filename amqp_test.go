@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// fakeAMQP is an in-memory channel that records publications and implements
+// amqp.Acknowledger for its deliveries
+type fakeAMQP struct {
+	deliveries chan amqp.Delivery
+
+	mu         sync.Mutex
+	prefetch   int
+	published  []amqp.Publishing
+	keys       []string
+	settled    []string // "ack", "requeue", or "reject" per delivery
+	publishErr error
+}
+
+func (f *fakeAMQP) Qos(prefetchCount, prefetchSize int, global bool) error {
+	f.prefetch = prefetchCount
+	return nil
+}
+
+func (f *fakeAMQP) Consume(queue, consumer string, autoAck, exclusive, noLocal, noWait bool, args amqp.Table) (<-chan amqp.Delivery, error) {
+	return f.deliveries, nil
+}
+
+func (f *fakeAMQP) PublishWithContext(ctx context.Context, exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.publishErr != nil {
+		return f.publishErr
+	}
+	f.published = append(f.published, msg)
+	f.keys = append(f.keys, key)
+	return nil
+}
+
+func (f *fakeAMQP) Close() error { return nil }
+
+func (f *fakeAMQP) Ack(tag uint64, multiple bool) error { return f.settle("ack") }
+
+func (f *fakeAMQP) Nack(tag uint64, multiple, requeue bool) error {
+	if requeue {
+		return f.settle("requeue")
+	}
+	return f.settle("reject")
+}
+
+func (f *fakeAMQP) Reject(tag uint64, requeue bool) error { return f.Nack(tag, false, requeue) }
+
+func (f *fakeAMQP) settle(outcome string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.settled = append(f.settled, outcome)
+	return nil
+}
+
+func newTestAMQPWorker(fake *fakeAMQP, store objectStore, requeueOnFailure bool) *amqpWorker {
+	return &amqpWorker{
+		connect:          func() (amqpChannel, io.Closer, error) { return fake, fake, nil },
+		queue:            "qr-generate",
+		resultRoutingKey: "qr-generated",
+		prefetch:         4,
+		requeueOnFailure: requeueOnFailure,
+		processor:        newTestJobProcessor(store),
+		retryInterval:    time.Millisecond,
+	}
+}
+
+func TestAMQPWorker_Handle(t *testing.T) {
+	tests := []struct {
+		name             string
+		body             string
+		replyTo          string
+		storeFails       int
+		requeueOnFailure bool
+		publishErr       error
+		wantSettled      string
+		wantKey          string
+		wantStatus       string
+	}{
+		{name: "success", body: `{"id": "a", "text": "hello"}`, wantSettled: "ack", wantKey: "qr-generated", wantStatus: jobSucceeded},
+		{name: "reply-to queue", body: `{"id": "a", "text": "hello"}`, replyTo: "amq.rabbitmq.reply-to", wantSettled: "ack", wantKey: "amq.rabbitmq.reply-to", wantStatus: jobSucceeded},
+		{name: "poison message rejected", body: `not json`, requeueOnFailure: true, wantSettled: "reject", wantKey: "qr-generated", wantStatus: jobFailed},
+		{name: "transient failure requeued", body: `{"id": "a", "text": "hello"}`, storeFails: -1, requeueOnFailure: true, wantSettled: "requeue", wantKey: "qr-generated", wantStatus: jobFailed},
+		{name: "transient failure rejected", body: `{"id": "a", "text": "hello"}`, storeFails: -1, wantSettled: "reject", wantKey: "qr-generated", wantStatus: jobFailed},
+		{name: "unpublished result requeued", body: `{"id": "a", "text": "hello"}`, publishErr: errors.New("channel closed"), wantSettled: "requeue"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeAMQP{publishErr: tt.publishErr}
+			worker := newTestAMQPWorker(fake, &memoryStore{failures: tt.storeFails}, tt.requeueOnFailure)
+			worker.handle(context.Background(), fake, amqp.Delivery{Acknowledger: fake, Body: []byte(tt.body), ReplyTo: tt.replyTo, CorrelationId: "c1"})
+
+			if len(fake.settled) != 1 || fake.settled[0] != tt.wantSettled {
+				t.Fatalf("settled = %v, want [%s]", fake.settled, tt.wantSettled)
+			}
+			if tt.wantKey == "" {
+				return
+			}
+			if len(fake.published) != 1 || fake.keys[0] != tt.wantKey {
+				t.Fatalf("published to %v, want [%s]", fake.keys, tt.wantKey)
+			}
+			var result jobResult
+			if err := json.Unmarshal(fake.published[0].Body, &result); err != nil || result.Status != tt.wantStatus {
+				t.Errorf("result = %+v (%v), want status %q", result, err, tt.wantStatus)
+			}
+			if fake.published[0].CorrelationId != "c1" {
+				t.Errorf("correlation id = %q, want the delivery's", fake.published[0].CorrelationId)
+			}
+		})
+	}
+}
+
+func TestAMQPWorker_Run(t *testing.T) {
+	fake := &fakeAMQP{deliveries: make(chan amqp.Delivery, 3)}
+	worker := newTestAMQPWorker(fake, &memoryStore{}, true)
+	for _, body := range []string{`{"id": "a", "text": "one"}`, `{"id": "b", "text": "two"}`, `{"id": "c", "text": "three"}`} {
+		fake.deliveries <- amqp.Delivery{Acknowledger: fake, Body: []byte(body)}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		worker.run(ctx)
+		close(done)
+	}()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		fake.mu.Lock()
+		n := len(fake.settled)
+		fake.mu.Unlock()
+		if n == 3 || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if err := worker.check(ctx); err != nil {
+		t.Errorf("check while consuming = %v, want nil", err)
+	}
+	cancel()
+	<-done
+
+	if fake.prefetch != 4 {
+		t.Errorf("prefetch = %d, want 4", fake.prefetch)
+	}
+	for i, outcome := range fake.settled {
+		if outcome != "ack" {
+			t.Errorf("delivery %d settled with %s, want ack", i, outcome)
+		}
+	}
+	if len(fake.settled) != 3 {
+		t.Errorf("%d deliveries settled, want 3", len(fake.settled))
+	}
+	if err := worker.check(ctx); err == nil {
+		t.Error("check after shutdown = nil, want an error")
+	}
+}
+
+func TestAMQPWorker_ShutdownRequeues(t *testing.T) {
+	fake := &fakeAMQP{deliveries: make(chan amqp.Delivery, 1)}
+	fake.deliveries <- amqp.Delivery{Acknowledger: fake, Body: []byte(`{"id": "a", "text": "hello"}`)}
+	worker := newTestAMQPWorker(fake, &memoryStore{failures: -1}, false)
+	worker.processor.backoff = time.Hour
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	worker.run(ctx)
+
+	if len(fake.settled) != 1 || fake.settled[0] != "requeue" || len(fake.published) != 0 {
+		t.Errorf("settled = %v with %d results, want the interrupted job requeued without a result", fake.settled, len(fake.published))
+	}
+}
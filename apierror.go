@@ -0,0 +1,46 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// Machine-readable API error codes
+const (
+	errCodeInvalidPayload  = "invalid_payload"
+	errCodeInvalidParam    = "invalid_parameter"
+	errCodePayloadTooLarge = "payload_too_large"
+	errCodeBodyTooLarge    = "body_too_large"
+)
+
+// apiError is the structured JSON error body returned by API endpoints
+type apiError struct {
+	Code    string         `json:"code"`
+	Message string         `json:"message"`
+	Details map[string]any `json:"details,omitempty"`
+
+	// format and args of a formatted Message, which is translated before
+	// formatting
+	format string
+	args   []any
+}
+
+// apiErrorf builds an error whose message is formatted from format and args
+func apiErrorf(code string, details map[string]any, format string, args ...any) *apiError {
+	return &apiError{Code: code, Message: fmt.Sprintf(format, args...), Details: details, format: format, args: args}
+}
+
+// writeAPIError writes err as {"error": {...}} with the given status. The
+// message is translated into the language of r's Accept-Language header.
+func writeAPIError(w http.ResponseWriter, r *http.Request, status int, err apiError) {
+	lang := negotiateLanguage(r.Header.Get("Accept-Language"))
+	err = err.localize(lang)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Language", lang)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(struct {
+		Error apiError `json:"error"`
+	}{err})
+}
@@ -0,0 +1,337 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// apiKeyHeader is the header clients authenticate with
+const apiKeyHeader = "X-API-Key"
+
+// apiKeyPrefix marks generated keys so leaked keys are easy to recognise
+const apiKeyPrefix = "qrk_"
+
+// validAPIKeyName restricts key names to something safe to log and use in URLs
+var validAPIKeyName = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+var (
+	errAPIKeyExists   = errors.New("api key already exists")
+	errAPIKeyNotFound = errors.New("api key not found")
+)
+
+// apiKey is a stored API key. Only the SHA-256 hash of the secret is kept in memory.
+type apiKey struct {
+	Name      string    `json:"name"`
+	Tenant    string    `json:"tenant,omitempty"`
+	Enabled   bool      `json:"enabled"`
+	Source    string    `json:"source"`
+	CreatedAt time.Time `json:"created_at"`
+
+	hash [sha256.Size]byte
+}
+
+// apiKeyStore holds the API keys accepted on the public API, keyed by name
+type apiKeyStore struct {
+	// required enables X-API-Key authentication on the API routes (see requireAuth)
+	required bool
+
+	mu   sync.RWMutex
+	keys map[string]*apiKey
+	// tenants assigns configured keys to tenants by key name (API_KEY_TENANTS)
+	tenants map[string]string
+}
+
+// newAPIKeyStore creates a store from a "name=key" list separated by commas or
+// newlines (e.g. API_KEYS or the contents of API_KEYS_FILE)
+func newAPIKeyStore(required bool, spec string) (*apiKeyStore, error) {
+	keys, err := parseNamedSecrets(spec)
+	if err != nil {
+		return nil, fmt.Errorf("invalid api keys: %w", err)
+	}
+
+	s := &apiKeyStore{required: required, keys: make(map[string]*apiKey)}
+	for name, secret := range keys {
+		if err := s.add(name, secret, "config"); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// parseNamedSecrets parses "name=secret" entries separated by commas or
+// newlines; blank lines and "#" comments are ignored
+func parseNamedSecrets(spec string) (map[string]string, error) {
+	secrets := make(map[string]string)
+	for _, entry := range strings.FieldsFunc(spec, func(r rune) bool { return r == ',' || r == '\n' }) {
+		entry = strings.TrimSpace(entry)
+		if entry == "" || strings.HasPrefix(entry, "#") {
+			continue
+		}
+		name, secret, ok := strings.Cut(entry, "=")
+		name, secret = strings.TrimSpace(name), strings.TrimSpace(secret)
+		if !ok || name == "" || secret == "" {
+			return nil, fmt.Errorf("invalid entry for %q: expected name=secret", name)
+		}
+		if _, exists := secrets[name]; exists {
+			return nil, fmt.Errorf("duplicate entry for %q", name)
+		}
+		secrets[name] = secret
+	}
+	return secrets, nil
+}
+
+// loadSecretSpec combines an inline "name=secret" list with the contents of
+// secretsFile (typically a mounted Kubernetes Secret)
+func loadSecretSpec(inline, secretsFile string) (string, error) {
+	if secretsFile == "" {
+		return inline, nil
+	}
+	data, err := os.ReadFile(secretsFile)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s: %w", secretsFile, err)
+	}
+	return inline + "\n" + string(data), nil
+}
+
+// SetTenants assigns keys to tenants from a "name=tenant" list. Keys created
+// at runtime carry their own tenant and are not affected.
+func (s *apiKeyStore) SetTenants(spec string) error {
+	tenants, err := parseNamedSecrets(spec)
+	if err != nil {
+		return fmt.Errorf("invalid api key tenants: %w", err)
+	}
+	for _, tenant := range tenants {
+		if !validAPIKeyName.MatchString(tenant) {
+			return fmt.Errorf("invalid tenant name %q", tenant)
+		}
+	}
+	s.mu.Lock()
+	s.tenants = tenants
+	s.mu.Unlock()
+	return nil
+}
+
+// add stores a key under name, failing if the name is invalid or taken
+func (s *apiKeyStore) add(name, secret, source string) error {
+	if !validAPIKeyName.MatchString(name) {
+		return fmt.Errorf("invalid api key name %q", name)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, exists := s.keys[name]; exists {
+		return fmt.Errorf("%w: %s", errAPIKeyExists, name)
+	}
+	s.keys[name] = &apiKey{
+		Name:      name,
+		Enabled:   true,
+		Source:    source,
+		CreatedAt: time.Now().UTC(),
+		hash:      sha256.Sum256([]byte(secret)),
+	}
+	return nil
+}
+
+// Reload replaces the keys loaded from configuration with those in spec (e.g.
+// after rotation in Vault). Keys created at runtime are kept, as is the
+// enabled state of configured keys whose secret did not change. Nothing is
+// changed when spec is invalid or reuses the name of a runtime key.
+func (s *apiKeyStore) Reload(spec string) error {
+	secrets, err := parseNamedSecrets(spec)
+	if err != nil {
+		return fmt.Errorf("invalid api keys: %w", err)
+	}
+	for name := range secrets {
+		if !validAPIKeyName.MatchString(name) {
+			return fmt.Errorf("invalid api key name %q", name)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for name := range secrets {
+		if key, exists := s.keys[name]; exists && key.Source != "config" {
+			return fmt.Errorf("%w: %s", errAPIKeyExists, name)
+		}
+	}
+
+	for name, key := range s.keys {
+		if _, keep := secrets[name]; key.Source == "config" && !keep {
+			delete(s.keys, name)
+		}
+	}
+	for name, secret := range secrets {
+		hash := sha256.Sum256([]byte(secret))
+		if key, exists := s.keys[name]; exists && key.hash == hash {
+			continue
+		}
+		s.keys[name] = &apiKey{Name: name, Enabled: true, Source: "config", CreatedAt: time.Now().UTC(), hash: hash}
+	}
+	return nil
+}
+
+// Create generates a new random key under name, optionally assigned to a
+// tenant, and returns its secret, which is not retrievable afterwards
+func (s *apiKeyStore) Create(name, tenant string) (string, error) {
+	if tenant != "" && !validAPIKeyName.MatchString(tenant) {
+		return "", fmt.Errorf("invalid tenant name %q", tenant)
+	}
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", fmt.Errorf("failed to generate api key: %w", err)
+	}
+	secret := apiKeyPrefix + base64.RawURLEncoding.EncodeToString(raw)
+	if err := s.add(name, secret, "admin"); err != nil {
+		return "", err
+	}
+	if tenant != "" {
+		s.mu.Lock()
+		s.keys[name].Tenant = tenant
+		s.mu.Unlock()
+	}
+	return secret, nil
+}
+
+// Revoke deletes the key stored under name
+func (s *apiKeyStore) Revoke(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, exists := s.keys[name]; !exists {
+		return fmt.Errorf("%w: %s", errAPIKeyNotFound, name)
+	}
+	delete(s.keys, name)
+	return nil
+}
+
+// SetEnabled enables or disables the key stored under name
+func (s *apiKeyStore) SetEnabled(name string, enabled bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key, exists := s.keys[name]
+	if !exists {
+		return fmt.Errorf("%w: %s", errAPIKeyNotFound, name)
+	}
+	key.Enabled = enabled
+	return nil
+}
+
+// List returns all keys (without secrets) sorted by name
+func (s *apiKeyStore) List() []apiKey {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	keys := make([]apiKey, 0, len(s.keys))
+	for _, key := range s.keys {
+		keys = append(keys, s.withTenant(key))
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].Name < keys[j].Name })
+	return keys
+}
+
+// withTenant returns a copy of key with its configured tenant filled in.
+// The caller must hold s.mu.
+func (s *apiKeyStore) withTenant(key *apiKey) apiKey {
+	copied := *key
+	if copied.Tenant == "" {
+		copied.Tenant = s.tenants[key.Name]
+	}
+	return copied
+}
+
+// Authenticate returns the enabled key matching secret. Every stored hash is
+// compared in constant time so timing reveals nothing about which key (if
+// any) matched.
+func (s *apiKeyStore) Authenticate(secret string) (apiKey, bool) {
+	hash := sha256.Sum256([]byte(secret))
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var matched *apiKey
+	for _, key := range s.keys {
+		if subtle.ConstantTimeCompare(hash[:], key.hash[:]) == 1 {
+			matched = key
+		}
+	}
+	if matched == nil || !matched.Enabled {
+		return apiKey{}, false
+	}
+	return s.withTenant(matched), true
+}
+
+// handleList returns all keys as JSON (admin listener)
+func (s *apiKeyStore) handleList(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.List())
+}
+
+// handleCreate creates a key from a {"name": "..."} body and returns its secret once (admin listener)
+func (s *apiKeyStore) handleCreate(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Name   string `json:"name"`
+		Tenant string `json:"tenant"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Name == "" {
+		http.Error(w, `Invalid body. Usage: POST /admin/apikeys with {"name": "client-name", "tenant": "optional-tenant"}`, http.StatusBadRequest)
+		return
+	}
+
+	secret, err := s.Create(body.Name, body.Tenant)
+	switch {
+	case errors.Is(err, errAPIKeyExists):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	slog.InfoContext(r.Context(), "api key created", "api_key", body.Name, "tenant", body.Tenant)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]string{"name": body.Name, "tenant": body.Tenant, "key": secret})
+}
+
+// handleToggle enables or disables a key from a {"enabled": bool} body (admin listener)
+func (s *apiKeyStore) handleToggle(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+
+	var body struct {
+		Enabled *bool `json:"enabled"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Enabled == nil {
+		http.Error(w, `Invalid body. Usage: PUT /admin/apikeys/{name} with {"enabled": true|false}`, http.StatusBadRequest)
+		return
+	}
+
+	if err := s.SetEnabled(name, *body.Enabled); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	slog.InfoContext(r.Context(), "api key changed", "api_key", name, "enabled", *body.Enabled)
+	s.handleList(w, r)
+}
+
+// handleRevoke deletes a key (admin listener)
+func (s *apiKeyStore) handleRevoke(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if err := s.Revoke(name); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	slog.InfoContext(r.Context(), "api key revoked", "api_key", name)
+	w.WriteHeader(http.StatusNoContent)
+}
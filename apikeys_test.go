@@ -0,0 +1,236 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestNewAPIKeyStore(t *testing.T) {
+	tests := []struct {
+		name      string
+		spec      string
+		wantNames []string
+		wantErr   bool
+	}{
+		{name: "empty", spec: "", wantNames: nil},
+		{name: "comma separated", spec: "ci=secret1, partner=secret2", wantNames: []string{"ci", "partner"}},
+		{name: "newlines and comments", spec: "# keys\nci=secret1\n\npartner=secret2\n", wantNames: []string{"ci", "partner"}},
+		{name: "missing secret", spec: "ci=", wantErr: true},
+		{name: "missing separator", spec: "ci", wantErr: true},
+		{name: "invalid name", spec: "bad name=secret", wantErr: true},
+		{name: "duplicate", spec: "ci=a,ci=b", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store, err := newAPIKeyStore(true, tt.spec)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("newAPIKeyStore() expected error but got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("newAPIKeyStore() unexpected error: %v", err)
+			}
+
+			var names []string
+			for _, key := range store.List() {
+				names = append(names, key.Name)
+			}
+			if strings.Join(names, ",") != strings.Join(tt.wantNames, ",") {
+				t.Errorf("keys = %v, want %v", names, tt.wantNames)
+			}
+		})
+	}
+}
+
+func TestLoadSecretSpec(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys")
+	if err := os.WriteFile(path, []byte("partner=secret2\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	spec, err := loadSecretSpec("ci=secret1", path)
+	if err != nil {
+		t.Fatalf("loadSecretSpec() unexpected error: %v", err)
+	}
+	store, err := newAPIKeyStore(true, spec)
+	if err != nil {
+		t.Fatalf("newAPIKeyStore() unexpected error: %v", err)
+	}
+	if len(store.List()) != 2 {
+		t.Errorf("loaded %d keys, want 2", len(store.List()))
+	}
+
+	if _, err := loadSecretSpec("", filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("loadSecretSpec() expected error for missing file")
+	}
+}
+
+func TestRequireAPIKey(t *testing.T) {
+	tests := []struct {
+		name     string
+		required bool
+		key      string
+		disable  bool
+		wantCode int
+	}{
+		{name: "not required", required: false, wantCode: http.StatusOK},
+		{name: "valid key", required: true, key: "secret1", wantCode: http.StatusOK},
+		{name: "missing key", required: true, wantCode: http.StatusUnauthorized},
+		{name: "wrong key", required: true, key: "nope", wantCode: http.StatusUnauthorized},
+		{name: "disabled key", required: true, key: "secret1", disable: true, wantCode: http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store, err := newAPIKeyStore(tt.required, "ci=secret1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.disable {
+				if err := store.SetEnabled("ci", false); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			deps := newTestDeps()
+			deps.apiKeys = store
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/qr/generate?text=hello", nil)
+			if tt.key != "" {
+				req.Header.Set(apiKeyHeader, tt.key)
+			}
+			rec := httptest.NewRecorder()
+			newHandler(deps).ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantCode {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestAdminAPIKeys(t *testing.T) {
+	deps := newTestDeps()
+	store, err := newAPIKeyStore(true, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	deps.apiKeys = store
+	admin := newAdminHandler(deps)
+	api := newHandler(deps)
+
+	do := func(handler http.Handler, method, path, body string, header map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+	auth := map[string]string{"Authorization": "Bearer test-admin-token"}
+
+	if rec := do(admin, http.MethodPost, "/admin/apikeys", `{"name": "ci"}`, nil); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("create without token status = %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+
+	rec := do(admin, http.MethodPost, "/admin/apikeys", `{"name": "ci"}`, auth)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("create status = %d, want %d", rec.Code, http.StatusCreated)
+	}
+	var created struct {
+		Key string `json:"key"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&created); err != nil || !strings.HasPrefix(created.Key, apiKeyPrefix) {
+		t.Fatalf("create returned key %q (err %v), want %s prefix", created.Key, err, apiKeyPrefix)
+	}
+
+	if rec := do(admin, http.MethodPost, "/admin/apikeys", `{"name": "ci"}`, auth); rec.Code != http.StatusConflict {
+		t.Errorf("duplicate create status = %d, want %d", rec.Code, http.StatusConflict)
+	}
+
+	generate := func() int {
+		return do(api, http.MethodPost, "/api/v1/qr/generate?text=hello", "", map[string]string{apiKeyHeader: created.Key}).Code
+	}
+	if code := generate(); code != http.StatusOK {
+		t.Errorf("generate with new key status = %d, want %d", code, http.StatusOK)
+	}
+
+	if rec := do(admin, http.MethodPut, "/admin/apikeys/ci", `{"enabled": false}`, auth); rec.Code != http.StatusOK {
+		t.Errorf("disable status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	if code := generate(); code != http.StatusUnauthorized {
+		t.Errorf("generate with disabled key status = %d, want %d", code, http.StatusUnauthorized)
+	}
+
+	if rec := do(admin, http.MethodDelete, "/admin/apikeys/ci", "", auth); rec.Code != http.StatusNoContent {
+		t.Errorf("revoke status = %d, want %d", rec.Code, http.StatusNoContent)
+	}
+	if rec := do(admin, http.MethodDelete, "/admin/apikeys/ci", "", auth); rec.Code != http.StatusNotFound {
+		t.Errorf("second revoke status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+
+	rec = do(admin, http.MethodGet, "/admin/apikeys", "", auth)
+	if rec.Code != http.StatusOK || strings.TrimSpace(rec.Body.String()) != "[]" {
+		t.Errorf("list = %d %q, want 200 []", rec.Code, rec.Body.String())
+	}
+}
+
+func TestAPIKeyStore_Reload(t *testing.T) {
+	tests := []struct {
+		name      string
+		spec      string
+		wantErr   bool
+		wantNames []string
+		wantAuth  map[string]bool
+	}{
+		{name: "rotate secret", spec: "ci=rotated,partner=secret2", wantNames: []string{"ci", "partner", "runtime"},
+			wantAuth: map[string]bool{"secret1": false, "rotated": true, "secret2": true}},
+		{name: "unchanged key keeps disabled state", spec: "ci=secret1", wantNames: []string{"ci", "runtime"},
+			wantAuth: map[string]bool{"secret1": false, "secret2": false}},
+		{name: "add key", spec: "ci=secret1,partner=secret2,new=secret3", wantNames: []string{"ci", "new", "partner", "runtime"},
+			wantAuth: map[string]bool{"secret3": true}},
+		{name: "runtime key name clash", spec: "runtime=secret4", wantErr: true, wantNames: []string{"ci", "partner", "runtime"}},
+		{name: "invalid spec", spec: "ci=", wantErr: true, wantNames: []string{"ci", "partner", "runtime"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store, err := newAPIKeyStore(true, "ci=secret1,partner=secret2")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := store.Create("runtime", ""); err != nil {
+				t.Fatal(err)
+			}
+			if err := store.SetEnabled("ci", false); err != nil {
+				t.Fatal(err)
+			}
+
+			err = store.Reload(tt.spec)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Reload() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			var names []string
+			for _, key := range store.List() {
+				names = append(names, key.Name)
+			}
+			if strings.Join(names, ",") != strings.Join(tt.wantNames, ",") {
+				t.Errorf("keys = %v, want %v", names, tt.wantNames)
+			}
+			for secret, want := range tt.wantAuth {
+				if _, ok := store.Authenticate(secret); ok != want {
+					t.Errorf("Authenticate(%q) = %v, want %v", secret, ok, want)
+				}
+			}
+		})
+	}
+}
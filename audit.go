@@ -0,0 +1,261 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// auditEvent records who did what, and when, for one mutating operation
+type auditEvent struct {
+	Time          time.Time         `json:"time"`
+	Actor         string            `json:"actor"`
+	AuthMethod    string            `json:"auth_method,omitempty"`
+	Tenant        string            `json:"tenant,omitempty"`
+	Action        string            `json:"action"`
+	Endpoint      string            `json:"endpoint"`
+	Target        string            `json:"target,omitempty"`
+	PayloadSHA256 string            `json:"payload_sha256,omitempty"`
+	Options       map[string]string `json:"options,omitempty"`
+	Status        int               `json:"status"`
+	RequestID     string            `json:"request_id,omitempty"`
+	ClientIP      string            `json:"client_ip,omitempty"`
+}
+
+// auditLog keeps the most recent events in memory for querying and optionally
+// appends every event as a JSON line to a file for long-term retention
+type auditLog struct {
+	mu     sync.Mutex
+	events []auditEvent
+	next   int
+	full   bool
+	sink   io.WriteCloser
+}
+
+// newAuditLog creates an audit log retaining up to size events in memory.
+// When path is set, events are also appended to that file.
+func newAuditLog(size int, path string) (*auditLog, error) {
+	a := &auditLog{events: make([]auditEvent, max(size, 1))}
+	if path != "" {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open audit log file: %w", err)
+		}
+		a.sink = f
+	}
+	return a, nil
+}
+
+// Record stores an event
+func (a *auditLog) Record(event auditEvent) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.events[a.next] = event
+	a.next = (a.next + 1) % len(a.events)
+	if a.next == 0 {
+		a.full = true
+	}
+
+	if a.sink != nil {
+		line, _ := json.Marshal(event)
+		if _, err := a.sink.Write(append(line, '\n')); err != nil {
+			slog.Error("failed to write audit event", "error", err, "action", event.Action)
+		}
+	}
+}
+
+// auditFilter selects events in Query
+type auditFilter struct {
+	Actor  string
+	Action string
+	Tenant string
+	Since  time.Time
+	Until  time.Time
+	Limit  int
+}
+
+// Query returns matching events, newest first
+func (a *auditLog) Query(filter auditFilter) []auditEvent {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	count := a.next
+	if a.full {
+		count = len(a.events)
+	}
+	matched := []auditEvent{}
+	for i := 1; i <= count; i++ {
+		event := a.events[(a.next-i+len(a.events))%len(a.events)]
+		switch {
+		case filter.Actor != "" && event.Actor != filter.Actor,
+			filter.Action != "" && event.Action != filter.Action,
+			filter.Tenant != "" && event.Tenant != filter.Tenant,
+			!filter.Since.IsZero() && event.Time.Before(filter.Since),
+			!filter.Until.IsZero() && event.Time.After(filter.Until):
+			continue
+		}
+		matched = append(matched, event)
+		if filter.Limit > 0 && len(matched) == filter.Limit {
+			break
+		}
+	}
+	return matched
+}
+
+// Close closes the file sink, if any
+func (a *auditLog) Close() error {
+	if a == nil || a.sink == nil {
+		return nil
+	}
+	return a.sink.Close()
+}
+
+// auditDetailsKey is the context key for the event being recorded for a request
+type auditDetailsKey struct{}
+
+// setAuditPayload attaches the SHA-256 of the encoded payload and the
+// generation options to the request's audit event. It is a no-op outside of record.
+func setAuditPayload(ctx context.Context, payload string, options map[string]string) {
+	if event, ok := ctx.Value(auditDetailsKey{}).(*auditEvent); ok {
+		sum := sha256.Sum256([]byte(payload))
+		event.PayloadSHA256 = hex.EncodeToString(sum[:])
+		event.Options = options
+	}
+}
+
+// record wraps a mutating handler so every call is audited with the
+// authenticated principal, the endpoint, the path target, and the response
+// status. It must run inside authentication so the principal is known.
+// A nil audit log disables auditing.
+func (a *auditLog) record(action string, next http.HandlerFunc) http.HandlerFunc {
+	if a == nil {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		event := &auditEvent{Action: action, Endpoint: r.Method + " " + r.URL.Path, Actor: "anonymous"}
+		rec := &statusRecorder{ResponseWriter: w}
+		next(rec, r.WithContext(context.WithValue(r.Context(), auditDetailsKey{}, event)))
+
+		// Tenants own API operations; admin operations belong to no tenant
+		if strings.HasPrefix(r.URL.Path, "/api/") {
+			event.Tenant = tenantFromContext(r.Context())
+		}
+		event.Status = rec.status
+		if event.Status == 0 {
+			event.Status = http.StatusOK
+		}
+		a.recordRequest(r, event)
+	}
+}
+
+// recordMessage audits one message of a long-lived connection, such as a
+// render on the preview WebSocket, as an API operation of the tenant that
+// authenticated the handshake request r. A nil audit log disables auditing.
+func (a *auditLog) recordMessage(r *http.Request, action, payload string, options map[string]string, status int) {
+	if a == nil {
+		return
+	}
+	event := &auditEvent{Action: action, Endpoint: r.Method + " " + r.URL.Path, Actor: "anonymous", Status: status}
+	sum := sha256.Sum256([]byte(payload))
+	event.PayloadSHA256 = hex.EncodeToString(sum[:])
+	event.Options = options
+	event.Tenant = tenantFromContext(r.Context())
+	a.recordRequest(r, event)
+}
+
+// recordRequest fills in the principal, time, target, and request details
+// of event from r and records it
+func (a *auditLog) recordRequest(r *http.Request, event *auditEvent) {
+	if p, ok := principalFromContext(r.Context()); ok {
+		event.Actor, event.AuthMethod = p.Subject, p.Method
+	}
+	event.Time = time.Now().UTC()
+	event.Target = r.PathValue("name")
+	event.RequestID = requestIDFromContext(r.Context())
+	event.ClientIP = clientIPFromContext(r.Context())
+	a.Record(*event)
+}
+
+// handleQuery returns audit events as JSON, filtered by the actor, action,
+// tenant, since, and until (RFC 3339) query parameters and capped by limit (default 100)
+func (a *auditLog) handleQuery(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseAuditFilter(r, 100)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(a.Query(filter))
+}
+
+// handleExport downloads matching audit events as JSON lines (default) or CSV
+func (a *auditLog) handleExport(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseAuditFilter(r, 0)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	events := a.Query(filter)
+
+	switch format := r.URL.Query().Get("format"); format {
+	case "", "jsonl":
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Header().Set("Content-Disposition", `attachment; filename="audit.jsonl"`)
+		enc := json.NewEncoder(w)
+		for _, event := range events {
+			enc.Encode(event)
+		}
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", `attachment; filename="audit.csv"`)
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"time", "actor", "auth_method", "tenant", "action", "endpoint", "target", "payload_sha256", "options", "status", "request_id", "client_ip"})
+		for _, e := range events {
+			options, _ := json.Marshal(e.Options)
+			if e.Options == nil {
+				options = nil
+			}
+			cw.Write([]string{e.Time.Format(time.RFC3339Nano), e.Actor, e.AuthMethod, e.Tenant, e.Action, e.Endpoint, e.Target,
+				e.PayloadSHA256, string(options), strconv.Itoa(e.Status), e.RequestID, e.ClientIP})
+		}
+		cw.Flush()
+	default:
+		http.Error(w, "Unknown format "+format+". Use jsonl or csv", http.StatusBadRequest)
+	}
+}
+
+// parseAuditFilter reads audit filters from query parameters
+func parseAuditFilter(r *http.Request, defaultLimit int) (auditFilter, error) {
+	query := r.URL.Query()
+	filter := auditFilter{Actor: query.Get("actor"), Action: query.Get("action"), Tenant: query.Get("tenant"), Limit: defaultLimit}
+
+	for name, dst := range map[string]*time.Time{"since": &filter.Since, "until": &filter.Until} {
+		if value := query.Get(name); value != "" {
+			t, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return auditFilter{}, fmt.Errorf("invalid %s %q: use RFC 3339, e.g. 2024-01-02T15:04:05Z", name, value)
+			}
+			*dst = t
+		}
+	}
+	if value := query.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 0 {
+			return auditFilter{}, fmt.Errorf("invalid limit %q", strings.TrimSpace(value))
+		}
+		filter.Limit = limit
+	}
+	return filter, nil
+}
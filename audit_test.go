@@ -0,0 +1,158 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAuditLog_Query(t *testing.T) {
+	audit, err := newAuditLog(3, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, actor := range []string{"a", "b", "a", "b"} {
+		audit.Record(auditEvent{Time: base.Add(time.Duration(i) * time.Hour), Actor: actor, Action: "qr.generate", Status: i})
+	}
+
+	tests := []struct {
+		name       string
+		filter     auditFilter
+		wantStatus []int
+	}{
+		{name: "all, newest first, oldest evicted", filter: auditFilter{}, wantStatus: []int{3, 2, 1}},
+		{name: "by actor", filter: auditFilter{Actor: "b"}, wantStatus: []int{3, 1}},
+		{name: "by action", filter: auditFilter{Action: "apikey.create"}, wantStatus: []int{}},
+		{name: "since", filter: auditFilter{Since: base.Add(2 * time.Hour)}, wantStatus: []int{3, 2}},
+		{name: "until", filter: auditFilter{Until: base.Add(time.Hour)}, wantStatus: []int{1}},
+		{name: "limit", filter: auditFilter{Limit: 1}, wantStatus: []int{3}},
+	}
+
+	fmtInts := func(values []int) string {
+		b, _ := json.Marshal(values)
+		return string(b)
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			events := audit.Query(tt.filter)
+			got := []int{}
+			for _, e := range events {
+				got = append(got, e.Status)
+			}
+			if fmtInts(got) != fmtInts(tt.wantStatus) {
+				t.Errorf("Query() statuses = %v, want %v", got, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestAudit_RecordsGenerationsAndAdminChanges(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	audit, err := newAuditLog(100, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer audit.Close()
+
+	keys, err := newAPIKeyStore(true, "ci=secret1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	deps := newTestDeps()
+	deps.audit = audit
+	deps.apiKeys = keys
+	api, admin := newHandler(deps), newAdminHandler(deps)
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/qr/generate?text=hello", nil)
+	req.Header.Set(apiKeyHeader, "secret1")
+	api.ServeHTTP(httptest.NewRecorder(), req)
+
+	req = httptest.NewRequest(http.MethodDelete, "/admin/apikeys/ci", nil)
+	req.Header.Set("Authorization", "Bearer test-admin-token")
+	admin.ServeHTTP(httptest.NewRecorder(), req)
+
+	req = httptest.NewRequest(http.MethodGet, "/admin/audit", nil)
+	req.Header.Set("Authorization", "Bearer test-admin-token")
+	rec := httptest.NewRecorder()
+	admin.ServeHTTP(rec, req)
+
+	var events []auditEvent
+	if err := json.NewDecoder(rec.Body).Decode(&events); err != nil || len(events) != 2 {
+		t.Fatalf("GET /admin/audit = %d %d events (err %v), want 2", rec.Code, len(events), err)
+	}
+
+	sum := sha256.Sum256([]byte("hello"))
+	revoke, generate := events[0], events[1]
+	if generate.Action != "qr.generate" || generate.Actor != "ci" || generate.AuthMethod != authMethodAPIKey ||
+		generate.PayloadSHA256 != hex.EncodeToString(sum[:]) || generate.Status != http.StatusOK {
+		t.Errorf("generate event = %+v", generate)
+	}
+	if revoke.Action != "apikey.revoke" || revoke.Actor != "admin-token" || revoke.Target != "ci" || revoke.Status != http.StatusNoContent {
+		t.Errorf("revoke event = %+v", revoke)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Count(string(data), "\n"); lines != 2 {
+		t.Errorf("audit file has %d lines, want 2", lines)
+	}
+}
+
+func TestAudit_Export(t *testing.T) {
+	deps := newTestDeps()
+	deps.audit.Record(auditEvent{Time: time.Now(), Actor: "ci", Action: "qr.generate", Options: map[string]string{"size": "256"}, Status: 200})
+	admin := newAdminHandler(deps)
+
+	tests := []struct {
+		format     string
+		wantStatus int
+		wantType   string
+		wantRows   int
+	}{
+		{format: "", wantStatus: http.StatusOK, wantType: "application/x-ndjson", wantRows: 1},
+		{format: "csv", wantStatus: http.StatusOK, wantType: "text/csv", wantRows: 2},
+		{format: "xml", wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/admin/audit/export?format="+tt.format, nil)
+			req.Header.Set("Authorization", "Bearer test-admin-token")
+			rec := httptest.NewRecorder()
+			admin.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			if got := rec.Header().Get("Content-Type"); got != tt.wantType {
+				t.Errorf("Content-Type = %q, want %q", got, tt.wantType)
+			}
+			rows := strings.Count(rec.Body.String(), "\n")
+			if tt.format == "csv" {
+				records, err := csv.NewReader(rec.Body).ReadAll()
+				if err != nil {
+					t.Fatal(err)
+				}
+				rows = len(records)
+			}
+			if rows != tt.wantRows {
+				t.Errorf("exported %d rows, want %d", rows, tt.wantRows)
+			}
+		})
+	}
+}
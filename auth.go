@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Authentication methods recorded on the principal
+const (
+	authMethodAPIKey = "api_key"
+	authMethodJWT    = "jwt"
+	authMethodHMAC   = "hmac"
+	// Admin endpoint methods
+	authMethodAdminToken = "admin_token"
+	authMethodOIDC       = "oidc"
+)
+
+// principal identifies the authenticated caller of a request
+type principal struct {
+	Subject string
+	Tenant  string
+	Method  string
+}
+
+// principalKey is the context key for the authenticated principal
+type principalKey struct{}
+
+// withPrincipal returns a copy of ctx carrying the authenticated principal
+func withPrincipal(ctx context.Context, p principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// principalFromContext returns the authenticated principal, if any
+func principalFromContext(ctx context.Context) (principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(principal)
+	return p, ok
+}
+
+// apiKeyName returns the name of the API key that authenticated the request, if any
+func apiKeyName(ctx context.Context) string {
+	if p, ok := principalFromContext(ctx); ok && p.Method == authMethodAPIKey {
+		return p.Subject
+	}
+	return ""
+}
+
+// requireAuth authenticates API requests with whichever methods are enabled in
+// deps: a JWT bearer token, an HMAC request signature, and an X-API-Key header
+// when API key authentication is required. When none is enabled requests pass
+// through unauthenticated. The principal is stored in the request context and
+// attached to the access log and trace span.
+func requireAuth(deps handlerDeps, next http.HandlerFunc) http.HandlerFunc {
+	keys, verifier, signatures := deps.apiKeys, deps.jwt, deps.hmac
+	return func(w http.ResponseWriter, r *http.Request) {
+		apiKeysEnabled := keys != nil && keys.required
+		if !apiKeysEnabled && verifier == nil && signatures == nil {
+			next(w, r)
+			return
+		}
+
+		var p principal
+		bearer, hasBearer := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		apiKey := r.Header.Get(apiKeyHeader)
+		switch {
+		case verifier != nil && hasBearer:
+			var err error
+			if p, err = verifier.Verify(r.Context(), bearer); err != nil {
+				slog.InfoContext(r.Context(), "bearer token rejected", "error", err)
+				w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+				http.Error(w, "Invalid bearer token", http.StatusUnauthorized)
+				return
+			}
+		case signatures != nil && r.Header.Get(signatureHeader) != "":
+			var err error
+			if p, err = signatures.Verify(r); err != nil {
+				slog.InfoContext(r.Context(), "request signature rejected", "error", err, "client", r.Header.Get(signatureClientHeader))
+				http.Error(w, "Invalid request signature", http.StatusUnauthorized)
+				return
+			}
+		case apiKeysEnabled && apiKey != "":
+			key, ok := keys.Authenticate(apiKey)
+			if !ok {
+				http.Error(w, "Invalid or disabled API key", http.StatusUnauthorized)
+				return
+			}
+			p = principal{Subject: key.Name, Tenant: key.Tenant, Method: authMethodAPIKey}
+		default:
+			if verifier != nil {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+			}
+			http.Error(w, "Missing credentials. "+authUsage(apiKeysEnabled, verifier != nil, signatures != nil), http.StatusUnauthorized)
+			return
+		}
+
+		attrs := []slog.Attr{slog.String("auth_method", p.Method), slog.String("subject", p.Subject)}
+		spanAttrs := []attribute.KeyValue{attribute.String("enduser.id", p.Subject)}
+		if p.Tenant != "" {
+			attrs = append(attrs, slog.String("tenant", p.Tenant))
+			spanAttrs = append(spanAttrs, attribute.String("tenant.id", p.Tenant))
+		}
+		addLogAttrs(r.Context(), attrs...)
+		trace.SpanFromContext(r.Context()).SetAttributes(spanAttrs...)
+
+		next(w, r.WithContext(withPrincipal(r.Context(), p)))
+	}
+}
+
+// adminAuth protects admin handlers with a static bearer token or, when sso is
+// set, an OIDC session with the admin role. When neither is configured the
+// protected endpoints are disabled rather than left open.
+func adminAuth(token string, sso *oidcAuth, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if token == "" && sso == nil {
+			http.Error(w, "Admin endpoint disabled: ADMIN_TOKEN is not configured", http.StatusForbidden)
+			return
+		}
+
+		provided, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if ok && token != "" && subtle.ConstantTimeCompare([]byte(provided), []byte(token)) == 1 {
+			next(w, r.WithContext(withPrincipal(r.Context(), principal{Subject: "admin-token", Method: authMethodAdminToken})))
+			return
+		}
+
+		if sso != nil {
+			if sess, ok := sso.Session(r); ok {
+				if !sess.HasRole(roleAdmin) {
+					http.Error(w, "Forbidden: admin role required", http.StatusForbidden)
+					return
+				}
+				addLogAttrs(r.Context(), slog.String("subject", sess.Subject))
+				next(w, r.WithContext(withPrincipal(r.Context(), principal{Subject: sess.Subject, Method: authMethodOIDC})))
+				return
+			}
+		}
+
+		w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	}
+}
+
+// authUsage describes how to authenticate given the enabled methods
+func authUsage(apiKeys, jwt, hmac bool) string {
+	var methods []string
+	if apiKeys {
+		methods = append(methods, "an "+apiKeyHeader+" header")
+	}
+	if jwt {
+		methods = append(methods, "an Authorization: Bearer token")
+	}
+	if hmac {
+		methods = append(methods, "an "+signatureHeader+" request signature")
+	}
+	return "Provide " + strings.Join(methods, " or ")
+}
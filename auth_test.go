@@ -0,0 +1,80 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestRequireAuth(t *testing.T) {
+	issuer := newTestIssuer(t)
+	verifier := newJWTVerifier(issuer.server.URL, "https://issuer.example", "qr-generator", "tenant")
+	keys, err := newAPIKeyStore(true, "ci=secret1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	optionalKeys, err := newAPIKeyStore(false, "ci=secret1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := issuer.sign(t, jwt.SigningMethodRS256, "rsa-1", validClaims())
+
+	tests := []struct {
+		name        string
+		keys        *apiKeyStore
+		verifier    *jwtVerifier
+		header      map[string]string
+		wantCode    int
+		wantSubject string
+		wantTenant  string
+	}{
+		{name: "auth disabled", keys: optionalKeys, wantCode: http.StatusOK},
+		{name: "jwt", verifier: verifier, header: map[string]string{"Authorization": "Bearer " + token}, wantCode: http.StatusOK, wantSubject: "user-123", wantTenant: "acme"},
+		{name: "invalid jwt", verifier: verifier, header: map[string]string{"Authorization": "Bearer not-a-token"}, wantCode: http.StatusUnauthorized},
+		{name: "jwt missing", verifier: verifier, wantCode: http.StatusUnauthorized},
+		{name: "api key with jwt enabled", keys: keys, verifier: verifier, header: map[string]string{apiKeyHeader: "secret1"}, wantCode: http.StatusOK, wantSubject: "ci"},
+		{name: "jwt with api keys enabled", keys: keys, verifier: verifier, header: map[string]string{"Authorization": "Bearer " + token}, wantCode: http.StatusOK, wantSubject: "user-123", wantTenant: "acme"},
+		{name: "api key only, jwt sent", keys: keys, header: map[string]string{"Authorization": "Bearer " + token}, wantCode: http.StatusUnauthorized},
+		{name: "api key not required", keys: optionalKeys, verifier: verifier, header: map[string]string{apiKeyHeader: "secret1"}, wantCode: http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got principal
+			handler := requireAuth(handlerDeps{apiKeys: tt.keys, jwt: tt.verifier}, func(w http.ResponseWriter, r *http.Request) {
+				got, _ = principalFromContext(r.Context())
+			})
+
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/qr/generate?text=hello", nil)
+			for k, v := range tt.header {
+				req.Header.Set(k, v)
+			}
+			rec := httptest.NewRecorder()
+			handler(rec, req)
+
+			if rec.Code != tt.wantCode {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantCode)
+			}
+			if got.Subject != tt.wantSubject || got.Tenant != tt.wantTenant {
+				t.Errorf("principal = %+v, want subject %q tenant %q", got, tt.wantSubject, tt.wantTenant)
+			}
+		})
+	}
+}
+
+func TestAdminAuth_DisabledWithoutToken(t *testing.T) {
+	deps := newTestDeps()
+	deps.adminToken = ""
+
+	req := httptest.NewRequest(http.MethodPut, "/admin/loglevel", strings.NewReader(`{"level": "debug"}`))
+	req.Header.Set("Authorization", "Bearer ")
+	rec := httptest.NewRecorder()
+	newAdminHandler(deps).ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusForbidden)
+	}
+}
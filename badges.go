@@ -0,0 +1,519 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/skip2/go-qrcode"
+)
+
+// maxTemplateBytes bounds badge templates; exported designs with embedded
+// logos stay well below it
+const maxTemplateBytes = 512 << 10
+
+// qrPlaceholder is the href of the template image replaced by the code of
+// the row's qr field
+const qrPlaceholder = "{{qr}}"
+
+// Namespaces of SVG templates
+const (
+	svgNamespace   = "http://www.w3.org/2000/svg"
+	xlinkNamespace = "http://www.w3.org/1999/xlink"
+)
+
+// placeholderPattern matches the {{field}} placeholders of templates
+var placeholderPattern = regexp.MustCompile(`\{\{\s*([A-Za-z0-9_]+)\s*\}\}`)
+
+// svgTransformPattern matches the functions of transform attributes
+var svgTransformPattern = regexp.MustCompile(`\s*(\w+)\s*\(([^)]*)\)\s*,?`)
+
+// svgUnits are the points per unit of SVG lengths; plain numbers are
+// pixels
+var svgUnits = map[string]float64{
+	"":   0.75,
+	"px": 0.75,
+	"pt": 1,
+	"mm": pointsPerMM,
+	"cm": 10 * pointsPerMM,
+	"in": pointsPerInch,
+}
+
+// Elements of SVG templates: those drawn, and those skipped along with
+// their content as they don't change what is drawn
+var (
+	badgeElements        = []string{"g", "image", "rect", "text"}
+	badgeSkippedElements = []string{"defs", "desc", "metadata", "title"}
+)
+
+// svgNode is an element of a parsed template with its attributes by local
+// name. Text holds the character data of text elements, tspans included.
+type svgNode struct {
+	name     string
+	attrs    map[string]string
+	text     string
+	children []*svgNode
+}
+
+// svgTransform maps user coordinates of a template to points from the top
+// left corner of the page
+type svgTransform struct{ sx, sy, tx, ty float64 }
+
+func (t svgTransform) apply(x, y float64) (float64, float64) {
+	return t.sx*x + t.tx, t.sy*y + t.ty
+}
+
+// then returns the transform of local applied within t
+func (t svgTransform) then(local svgTransform) svgTransform {
+	return svgTransform{sx: t.sx * local.sx, sy: t.sy * local.sy, tx: t.sx*local.tx + t.tx, ty: t.sy*local.ty + t.ty}
+}
+
+// badgeTemplate is a parsed SVG template: its page size in points, the
+// transform of its viewBox, and its elements
+type badgeTemplate struct {
+	width, height float64
+	transform     svgTransform
+	root          *svgNode
+	// placeholders are the fields the template refers to, qr included
+	placeholders []string
+}
+
+// parseSVGLength reads a length such as 85mm in points
+func parseSVGLength(s string) (float64, bool) {
+	s = strings.TrimSpace(s)
+	number := strings.TrimRight(s, "abcdefghijklmnopqrstuvwxyz")
+	unit, ok := svgUnits[s[len(number):]]
+	v, err := strconv.ParseFloat(number, 64)
+	if !ok || err != nil {
+		return 0, false
+	}
+	return v * unit, true
+}
+
+// parseSVGNumbers reads the numbers of an attribute separated by spaces or
+// commas
+func parseSVGNumbers(s string) ([]float64, bool) {
+	var numbers []float64
+	for _, field := range strings.FieldsFunc(s, func(r rune) bool { return r == ' ' || r == ',' }) {
+		v, err := strconv.ParseFloat(field, 64)
+		if err != nil {
+			return nil, false
+		}
+		numbers = append(numbers, v)
+	}
+	return numbers, true
+}
+
+// parseSVGTransform reads a transform attribute of translate and scale
+// functions, the ones layout tools emit for positioned groups
+func parseSVGTransform(s string) (svgTransform, bool) {
+	t := svgTransform{sx: 1, sy: 1}
+	if strings.TrimSpace(svgTransformPattern.ReplaceAllString(s, "")) != "" {
+		return t, false
+	}
+	for _, m := range svgTransformPattern.FindAllStringSubmatch(s, -1) {
+		args, ok := parseSVGNumbers(m[2])
+		if !ok || len(args) == 0 || len(args) > 2 {
+			return t, false
+		}
+		switch m[1] {
+		case "translate":
+			// A missing y translation is zero
+			args = append(args, 0)
+			t = t.then(svgTransform{sx: 1, sy: 1, tx: args[0], ty: args[1]})
+		case "scale":
+			// A missing y scale equals the x scale
+			args = append(args, args[0])
+			t = t.then(svgTransform{sx: args[0], sy: args[1]})
+		default:
+			return t, false
+		}
+	}
+	return t, true
+}
+
+// parseSVGColor reads a fill: a hex color, black, white, or none
+func parseSVGColor(s string) (c color.RGBA, none bool, ok bool) {
+	switch s = strings.TrimSpace(strings.ToLower(s)); s {
+	case "none", "transparent":
+		return c, true, true
+	case "black":
+		return color.RGBA{A: 0xff}, false, true
+	case "white":
+		return color.RGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff}, false, true
+	}
+	if len(s) == 4 && s[0] == '#' {
+		s = "#" + strings.Repeat(s[1:2], 2) + strings.Repeat(s[2:3], 2) + strings.Repeat(s[3:4], 2)
+	}
+	if !strings.HasPrefix(s, "#") {
+		return c, false, false
+	}
+	c, ok = parseHexColor(s)
+	return c, false, ok
+}
+
+// parseBadgeTemplate parses an SVG template. The root sets the page size
+// with width and height in px, pt, mm, cm, or in, and optionally a
+// viewBox. Only elements that print the same on every renderer are
+// accepted: groups, rectangles, text, and images.
+func parseBadgeTemplate(src string) (*badgeTemplate, *apiError) {
+	d := xml.NewDecoder(strings.NewReader(src))
+	var stack []*svgNode
+	var root *svgNode
+	skip := 0
+	for {
+		token, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, &apiError{Code: errCodeInvalidParam, Message: "Field 'template' must be an SVG document"}
+		}
+		switch token := token.(type) {
+		case xml.StartElement:
+			if skip > 0 {
+				skip++
+				continue
+			}
+			// Editor metadata of other namespaces, such as Inkscape's, and
+			// elements without a visual effect are left out
+			if token.Name.Space != svgNamespace || slices.Contains(badgeSkippedElements, token.Name.Local) {
+				skip = 1
+				continue
+			}
+			node := &svgNode{name: token.Name.Local, attrs: map[string]string{}}
+			for _, attr := range token.Attr {
+				if attr.Name.Space == "" || (attr.Name.Space == xlinkNamespace && attr.Name.Local == "href") {
+					node.attrs[attr.Name.Local] = attr.Value
+				}
+			}
+			switch {
+			case root == nil:
+				if node.name != "svg" {
+					return nil, &apiError{Code: errCodeInvalidParam, Message: "Field 'template' must be an SVG document"}
+				}
+				root = node
+			case len(stack) == 0:
+				return nil, &apiError{Code: errCodeInvalidParam, Message: "Field 'template' must be an SVG document"}
+			case stack[len(stack)-1].name == "text":
+				// Spans add their text to the text element
+				if node.name != "tspan" {
+					return nil, apiErrorf(errCodeInvalidParam, map[string]any{"supported": badgeElements}, "Template element <%s> is not supported", node.name)
+				}
+				node = stack[len(stack)-1]
+			case !slices.Contains(badgeElements, node.name):
+				return nil, apiErrorf(errCodeInvalidParam, map[string]any{"supported": badgeElements}, "Template element <%s> is not supported", node.name)
+			default:
+				stack[len(stack)-1].children = append(stack[len(stack)-1].children, node)
+			}
+			stack = append(stack, node)
+		case xml.EndElement:
+			if skip > 0 {
+				skip--
+				continue
+			}
+			stack = stack[:len(stack)-1]
+		case xml.CharData:
+			if skip == 0 && len(stack) > 0 && stack[len(stack)-1].name == "text" {
+				stack[len(stack)-1].text += string(token)
+			}
+		}
+	}
+	if root == nil {
+		return nil, &apiError{Code: errCodeInvalidParam, Message: "Field 'template' must be an SVG document"}
+	}
+
+	width, widthOK := parseSVGLength(root.attrs["width"])
+	height, heightOK := parseSVGLength(root.attrs["height"])
+	t := &badgeTemplate{width: width, height: height, root: root}
+	if !widthOK || !heightOK || min(t.width, t.height) < minGridPageMM*pointsPerMM || max(t.width, t.height) > maxGridPageMM*pointsPerMM {
+		return nil, apiErrorf(errCodeInvalidParam, nil, "The template needs a width and height from %g to %g mm", minGridPageMM, maxGridPageMM)
+	}
+	t.transform = svgTransform{sx: 0.75, sy: 0.75}
+	if viewBox, ok := root.attrs["viewBox"]; ok {
+		box, ok := parseSVGNumbers(viewBox)
+		if !ok || len(box) != 4 || box[2] <= 0 || box[3] <= 0 {
+			return nil, &apiError{Code: errCodeInvalidParam, Message: "The viewBox of the template must be four numbers"}
+		}
+		sx, sy := t.width/box[2], t.height/box[3]
+		t.transform = svgTransform{sx: sx, sy: sy, tx: -box[0] * sx, ty: -box[1] * sy}
+	}
+	if apiErr := t.check(root); apiErr != nil {
+		return nil, apiErr
+	}
+	return t, nil
+}
+
+// check validates the attributes of node and its children, and collects
+// the placeholders of text and image links
+func (t *badgeTemplate) check(node *svgNode) *apiError {
+	invalid := func(attr string) *apiError {
+		return apiErrorf(errCodeInvalidParam, nil, "Attribute '%s' of template element <%s> is invalid", attr, node.name)
+	}
+	numbers := map[string][]string{
+		"rect":  {"x", "y", "width", "height"},
+		"image": {"x", "y", "width", "height"},
+		"text":  {"x", "y", "font-size"},
+		"g":     {"font-size"},
+		"svg":   {"font-size"},
+	}[node.name]
+	for _, attr := range numbers {
+		if v, ok := node.attrs[attr]; ok {
+			if _, err := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(v), "px"), 64); err != nil {
+				return invalid(attr)
+			}
+		}
+	}
+	if v, ok := node.attrs["fill"]; ok {
+		if _, _, ok := parseSVGColor(v); !ok {
+			return invalid("fill")
+		}
+	}
+	if v, ok := node.attrs["transform"]; ok {
+		if _, ok := parseSVGTransform(v); !ok {
+			return invalid("transform")
+		}
+	}
+	collect := func(s string) {
+		for _, m := range placeholderPattern.FindAllStringSubmatch(s, -1) {
+			if !slices.Contains(t.placeholders, m[1]) {
+				t.placeholders = append(t.placeholders, m[1])
+			}
+		}
+	}
+	switch node.name {
+	case "text":
+		collect(node.text)
+	case "image":
+		// Images are the code or embedded PNGs and JPEGs; linked images
+		// would be fetched from anywhere
+		href := node.attrs["href"]
+		if href == qrPlaceholder {
+			collect(href)
+			break
+		}
+		kind, data, _ := strings.Cut(href, ",")
+		if kind != "data:image/png;base64" && kind != "data:image/jpeg;base64" {
+			return invalid("href")
+		}
+		decoded, err := base64.StdEncoding.DecodeString(data)
+		if err != nil {
+			return invalid("href")
+		}
+		config, _, err := image.DecodeConfig(bytes.NewReader(decoded))
+		if err != nil || config.Width > maxLogoPixels || config.Height > maxLogoPixels {
+			return invalid("href")
+		}
+	}
+	for _, child := range node.children {
+		if apiErr := t.check(child); apiErr != nil {
+			return apiErr
+		}
+	}
+	return nil
+}
+
+// badgeStyle are the inherited properties of template elements
+type badgeStyle struct {
+	fill     color.RGBA
+	noFill   bool
+	fontSize float64
+	anchor   string
+}
+
+// draw draws the template on c with the placeholders of text replaced by
+// fields, and the {{qr}} image replaced by bitmap, centered in its box
+func (t *badgeTemplate) draw(c canvas, fields map[string]string, bitmap [][]bool) {
+	t.drawNode(c, t.root, t.transform, badgeStyle{fill: color.RGBA{A: 0xff}, fontSize: 16, anchor: "start"}, fields, bitmap)
+}
+
+func (t *badgeTemplate) drawNode(c canvas, node *svgNode, transform svgTransform, style badgeStyle, fields map[string]string, bitmap [][]bool) {
+	number := func(attr string) float64 {
+		v, _ := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(node.attrs[attr]), "px"), 64)
+		return v
+	}
+	if v, ok := node.attrs["transform"]; ok && node.name != "svg" {
+		local, _ := parseSVGTransform(v)
+		transform = transform.then(local)
+	}
+	if v, ok := node.attrs["fill"]; ok {
+		style.fill, style.noFill, _ = parseSVGColor(v)
+	}
+	if _, ok := node.attrs["font-size"]; ok {
+		style.fontSize = number("font-size")
+	}
+	if v, ok := node.attrs["text-anchor"]; ok {
+		style.anchor = v
+	}
+	// box is the rectangle of rects and images in points from the bottom
+	// left corner
+	box := func() (x, y, width, height float64) {
+		left, top := transform.apply(number("x"), number("y"))
+		width, height = number("width")*transform.sx, number("height")*transform.sy
+		return left, t.height - top - height, width, height
+	}
+
+	switch node.name {
+	case "rect":
+		if !style.noFill {
+			c.setColor(style.fill)
+			c.fillRect(box())
+		}
+	case "text":
+		text := strings.Join(strings.Fields(placeholderPattern.ReplaceAllStringFunc(node.text, func(s string) string {
+			return fields[placeholderPattern.FindStringSubmatch(s)[1]]
+		})), " ")
+		if style.noFill || text == "" {
+			return
+		}
+		x, y := transform.apply(number("x"), number("y"))
+		size := style.fontSize * transform.sy
+		switch style.anchor {
+		case "middle":
+			x -= c.textWidth(text, size) / 2
+		case "end":
+			x -= c.textWidth(text, size)
+		}
+		c.setColor(style.fill)
+		c.drawText(text, x, t.height-y, size)
+	case "image":
+		x, y, width, height := box()
+		if node.attrs["href"] == qrPlaceholder {
+			side := min(width, height)
+			c.setColor(color.RGBA{A: 0xff})
+			c.fillBitmap(bitmap, x+(width-side)/2, y+height-(height-side)/2, side/float64(len(bitmap)))
+			return
+		}
+		_, data, _ := strings.Cut(node.attrs["href"], ",")
+		decoded, err := base64.StdEncoding.DecodeString(data)
+		if err != nil {
+			return
+		}
+		if img, _, err := image.Decode(bytes.NewReader(decoded)); err == nil {
+			c.drawImage(img, x, y, width, height)
+		}
+	}
+	for _, child := range node.children {
+		t.drawNode(c, child, transform, style, fields, bitmap)
+	}
+}
+
+// badgesRequest is the body of POST /api/v1/qr/badges
+type badgesRequest struct {
+	// Template is an SVG document with {{field}} placeholders in its text
+	// and an image linking to {{qr}}
+	Template string `json:"template"`
+	// Rows hold the fields of each badge; qr is the encoded text
+	Rows []map[string]string `json:"rows"`
+	// Level is the error correction level, as the ec parameter
+	Level string `json:"ec,omitempty"`
+	printOptions
+}
+
+// handleBadges fills an SVG template once per row, replacing {{field}}
+// placeholders with the row's fields and the {{qr}} image with the code of
+// its qr field, and answers with a PDF of one page per row. Like label
+// sheets, rows fail together.
+func handleBadges(deps handlerDeps) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var body badgesRequest
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Template == "" || len(body.Rows) == 0 {
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				writeAPIError(w, r, http.StatusRequestEntityTooLarge, *apiErrorf(errCodeBodyTooLarge,
+					map[string]any{"max_bytes": maxBytesErr.Limit},
+					"Request body exceeds %d bytes", maxBytesErr.Limit))
+				return
+			}
+			writeAPIError(w, r, http.StatusBadRequest, apiError{
+				Code:    errCodeInvalidPayload,
+				Message: `Invalid body. Usage: POST /api/v1/qr/badges with {"template": "<svg ...>", "rows": [{"name": "...", "qr": "..."}, ...]}`,
+			})
+			return
+		}
+		if len(body.Template) > maxTemplateBytes {
+			writeAPIError(w, r, http.StatusRequestEntityTooLarge, *apiErrorf(errCodePayloadTooLarge,
+				map[string]any{"bytes": len(body.Template), "max_bytes": maxTemplateBytes},
+				"Field 'template' exceeds %d bytes", maxTemplateBytes))
+			return
+		}
+		template, apiErr := parseBadgeTemplate(body.Template)
+		if apiErr != nil {
+			writeAPIError(w, r, http.StatusBadRequest, *apiErr)
+			return
+		}
+		if apiErr := body.printOptions.validate("pdf"); apiErr != nil {
+			writeAPIError(w, r, http.StatusBadRequest, *apiErr)
+			return
+		}
+		level := defaultRenderOptions.level
+		if body.Level != "" {
+			if _, ok := recoveryLevels[body.Level]; !ok {
+				writeAPIError(w, r, http.StatusBadRequest, apiError{Code: errCodeInvalidParam, Message: "Field 'ec' must be low, medium, high, or highest"})
+				return
+			}
+			level = body.Level
+		}
+		if deps.batchMaxItems > 0 && len(body.Rows) > deps.batchMaxItems {
+			writeAPIError(w, r, http.StatusRequestEntityTooLarge, *apiErrorf(errCodePayloadTooLarge,
+				map[string]any{"items": len(body.Rows), "max_items": deps.batchMaxItems},
+				"Batch has %d items, the maximum is %d", len(body.Rows), deps.batchMaxItems))
+			return
+		}
+		setAuditPayload(r.Context(), fmt.Sprintf("badges of %d rows", len(body.Rows)), map[string]string{"items": fmt.Sprint(len(body.Rows))})
+
+		doc := body.document()
+		for i, row := range body.Rows {
+			var apiErr *apiError
+			for _, field := range template.placeholders {
+				if _, ok := row[field]; !ok {
+					apiErr = apiErrorf(errCodeInvalidParam, nil, "Placeholder '%s' of the template has no field in the row", field)
+					break
+				}
+			}
+			var bitmap [][]bool
+			if apiErr == nil && slices.Contains(template.placeholders, "qr") {
+				var text string
+				if text, apiErr = checkText(r.Context(), deps, row["qr"]); apiErr == nil {
+					q, err := qrcode.New(text, recoveryLevels[level])
+					if err != nil {
+						apiErr = &apiError{
+							Code:    errCodePayloadTooLarge,
+							Message: "Field 'qr' is too long to fit in a QR code",
+							Details: map[string]any{"bytes": len(text)},
+						}
+					} else {
+						bitmap = q.Bitmap()
+					}
+				}
+			}
+			if apiErr != nil {
+				writeAPIError(w, r, itemErrorStatus(apiErr.Code), withItemIndex(*apiErr, i))
+				return
+			}
+			template.draw(doc.addPage(template.width, template.height), row, bitmap)
+		}
+
+		deps.metrics.generatedTotal.Add(float64(len(body.Rows)))
+		addLogAttrs(r.Context(), slog.Int("batch_items", len(body.Rows)))
+		w.Header().Set("Content-Type", "application/pdf")
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("inline", map[string]string{"filename": "badges.pdf"}))
+		w.Header().Set("Cache-Control", "no-store")
+		if err := doc.writeTo(w); err != nil {
+			slog.WarnContext(r.Context(), "failed to write badges", "error", err)
+		}
+	}
+}
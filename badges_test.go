@@ -0,0 +1,140 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// testBadgeTemplate is a 90 by 60 mm badge drawn in millimeters
+const testBadgeTemplate = `<?xml version="1.0"?>
+<svg xmlns="http://www.w3.org/2000/svg" xmlns:xlink="http://www.w3.org/1999/xlink" xmlns:inkscape="http://www.inkscape.org/namespaces/inkscape" width="90mm" height="60mm" viewBox="0 0 90 60">
+  <title>Conference badge</title>
+  <inkscape:grid/>
+  <rect x="0" y="0" width="90" height="10" fill="#1a73e8"/>
+  <g transform="translate(5 20)" font-size="6">
+    <text x="0" y="0">{{name}}</text>
+    <text x="0" y="8" fill="#555"><tspan>{{ company }}</tspan>, {{role}}</text>
+  </g>
+  <image x="60" y="25" width="25" height="30" xlink:href="{{qr}}"/>
+</svg>`
+
+func TestParseSVGTransform(t *testing.T) {
+	tests := []struct {
+		transform string
+		want      svgTransform
+		wantOK    bool
+	}{
+		{transform: "translate(10)", want: svgTransform{sx: 1, sy: 1, tx: 10}, wantOK: true},
+		{transform: "translate(10, 20) scale(2)", want: svgTransform{sx: 2, sy: 2, tx: 10, ty: 20}, wantOK: true},
+		{transform: "scale(2 3) translate(1 1)", want: svgTransform{sx: 2, sy: 3, tx: 2, ty: 3}, wantOK: true},
+		{transform: "rotate(45)"},
+		{transform: "translate(1) skew"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.transform, func(t *testing.T) {
+			got, ok := parseSVGTransform(tt.transform)
+			if ok != tt.wantOK || (ok && got != tt.want) {
+				t.Errorf("parseSVGTransform = %+v, %v, want %+v, %v", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestParseBadgeTemplate(t *testing.T) {
+	template, apiErr := parseBadgeTemplate(testBadgeTemplate)
+	if apiErr != nil {
+		t.Fatal(apiErr.Message)
+	}
+	if pdfNumber(template.width) != "255.118" || pdfNumber(template.height) != "170.079" {
+		t.Errorf("size = %v x %v points", template.width, template.height)
+	}
+	if want := []string{"name", "company", "role", "qr"}; !slices.Equal(template.placeholders, want) {
+		t.Errorf("placeholders = %q, want %q", template.placeholders, want)
+	}
+
+	for _, tt := range []struct {
+		name     string
+		template string
+		want     string
+	}{
+		{name: "not svg", template: `<html><body>{{name}}</body></html>`, want: "Field 'template' must be an SVG document"},
+		{name: "malformed", template: `<svg xmlns="http://www.w3.org/2000/svg" width="90mm" height="60mm">`, want: "Field 'template' must be an SVG document"},
+		{name: "no size", template: `<svg xmlns="http://www.w3.org/2000/svg"/>`, want: "The template needs a width and height from 25 to 1500 mm"},
+		{name: "path", template: `<svg xmlns="http://www.w3.org/2000/svg" width="90mm" height="60mm"><path d="M0 0h1v1z"/></svg>`, want: "Template element <path> is not supported"},
+		{name: "rotated", template: `<svg xmlns="http://www.w3.org/2000/svg" width="90mm" height="60mm"><g transform="rotate(90)"/></svg>`, want: "Attribute 'transform' of template element <g> is invalid"},
+		{name: "linked image", template: `<svg xmlns="http://www.w3.org/2000/svg" width="90mm" height="60mm"><image href="https://example.com/logo.png"/></svg>`, want: "Attribute 'href' of template element <image> is invalid"},
+		{name: "named color", template: `<svg xmlns="http://www.w3.org/2000/svg" width="90mm" height="60mm"><rect fill="rebeccapurple"/></svg>`, want: "Attribute 'fill' of template element <rect> is invalid"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, apiErr := parseBadgeTemplate(tt.template); apiErr == nil || apiErr.Message != tt.want {
+				t.Errorf("error = %+v, want %q", apiErr, tt.want)
+			}
+		})
+	}
+}
+
+func TestBadgeTemplate_Draw(t *testing.T) {
+	template, apiErr := parseBadgeTemplate(testBadgeTemplate)
+	if apiErr != nil {
+		t.Fatal(apiErr.Message)
+	}
+	svg := &svgCanvas{width: template.width, height: template.height}
+	template.draw(svg, map[string]string{"name": "Ada Lovelace", "company": "Engines", "role": "Analyst", "qr": "x"}, [][]bool{{true}})
+
+	for _, want := range []string{
+		`<g fill="#1a73e8" shape-rendering="crispEdges"><rect x="0" y="0" width="255.118" height="28.346"/>`,
+		// The group moves text 5 mm right and 20 mm down
+		`<text x="14.173" y="56.693" font-family="Helvetica, Arial, sans-serif" font-size="17.008">Ada Lovelace</text>`,
+		`<g fill="#555555" shape-rendering="crispEdges"><text x="14.173" y="79.37" font-family="Helvetica, Arial, sans-serif" font-size="17.008">Engines, Analyst</text>`,
+		// The code is the largest square centered in the image box
+		`<path transform="translate(170.079 77.953) scale(70.866)"`,
+	} {
+		if !strings.Contains(svg.body.String(), want) {
+			t.Errorf("badge lacks %q: %s", want, svg.body.String())
+		}
+	}
+}
+
+func TestBadges(t *testing.T) {
+	template := strings.ReplaceAll(testBadgeTemplate, `"`, `\"`)
+	template = strings.ReplaceAll(template, "\n", `\n`)
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+		wantPages  int
+	}{
+		{name: "two badges", body: `{"template": "` + template + `", "rows": [{"name": "Ada", "company": "Engines", "role": "Analyst", "qr": "T-1"}, {"name": "Charles", "company": "Engines", "role": "Inventor", "qr": "T-2"}]}`, wantStatus: http.StatusOK, wantPages: 2},
+		{name: "missing field", body: `{"template": "` + template + `", "rows": [{"name": "Ada", "company": "Engines", "role": "Analyst", "qr": "T-1"}, {"name": "Charles", "qr": "T-2"}]}`, wantStatus: http.StatusBadRequest},
+		{name: "denied url", body: `{"template": "` + template + `", "rows": [{"name": "Ada", "company": "Engines", "role": "Analyst", "qr": "javascript:alert(1)"}]}`, wantStatus: http.StatusUnprocessableEntity},
+		{name: "invalid template", body: `{"template": "<html/>", "rows": [{"qr": "T-1"}]}`, wantStatus: http.StatusBadRequest},
+		{name: "no rows", body: `{"template": "` + template + `", "rows": []}`, wantStatus: http.StatusBadRequest},
+		{name: "unknown ec", body: `{"template": "` + template + `", "rows": [{"qr": "T-1"}], "ec": "max"}`, wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deps := newTestDeps()
+			deps.flags = mustFeatureFlags("batch")
+			rec := httptest.NewRecorder()
+			newHandler(deps).ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v1/qr/badges", strings.NewReader(tt.body)))
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if rec.Code != http.StatusOK {
+				return
+			}
+			if got := rec.Header().Get("Content-Type"); got != "application/pdf" {
+				t.Errorf("Content-Type = %q", got)
+			}
+			if got := len(pdfStreams(t, rec.Body.Bytes())); got != tt.wantPages {
+				t.Errorf("got %d pages, want %d", got, tt.wantPages)
+			}
+		})
+	}
+}
@@ -0,0 +1,455 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"html"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Barcode types of the type parameter
+const (
+	barcodeEAN13  = "ean13"
+	barcodeUPCA   = "upca"
+	barcodeCode39 = "code39"
+	barcodeITF14  = "itf14"
+)
+
+// Bounds and default of the scale parameter: pixels per module, the width
+// of the thinnest bar
+const (
+	minBarcodeScale     = 1
+	maxBarcodeScale     = 10
+	defaultBarcodeScale = 3
+)
+
+// Layout of retail barcodes in modules, after the GS1 nominal size: bars are
+// 69 modules high and guard bars reach 5 modules further into the row of
+// digits, whose glyphs are 5 by 7 modules
+const (
+	barcodeBarHeight   = 69
+	barcodeGuardExtra  = 5
+	barcodeDigitsTop   = barcodeBarHeight + 1
+	barcodeDigitsSpace = 9
+)
+
+// eanCodes are the 7-module patterns of digits in the L (odd parity) and G
+// (even parity) sets of the left half and the R set of the right half
+var eanCodes = map[byte][10]string{
+	'L': {"0001101", "0011001", "0010011", "0111101", "0100011", "0110001", "0101111", "0111011", "0110111", "0001011"},
+	'G': {"0100111", "0110011", "0011011", "0100001", "0011101", "0111001", "0000101", "0010001", "0001001", "0010111"},
+	'R': {"1110010", "1100110", "1101100", "1000010", "1011100", "1001110", "1010000", "1000100", "1001000", "1110100"},
+}
+
+// eanParity is the code set of each left-half digit, chosen by the first
+// digit of an EAN-13, which has no bars of its own
+var eanParity = [10]string{"LLLLLL", "LLGLGG", "LLGGLG", "LLGGGL", "LGLLGG", "LGGLLG", "LGGGLL", "LGLGLG", "LGLGGL", "LGGLGL"}
+
+// barcodeGlyphs are 5 by 7 bitmaps of the human-readable characters of
+// barcodes, so PNGs and SVGs show the same text without depending on fonts.
+// PNG print layouts set their text in them too, with a few punctuation
+// marks barcodes don't use.
+var barcodeGlyphs = map[byte][7]string{
+	'0': {"01110", "10001", "10011", "10101", "11001", "10001", "01110"},
+	'1': {"00100", "01100", "00100", "00100", "00100", "00100", "01110"},
+	'2': {"01110", "10001", "00001", "00010", "00100", "01000", "11111"},
+	'3': {"11111", "00010", "00100", "00010", "00001", "10001", "01110"},
+	'4': {"00010", "00110", "01010", "10010", "11111", "00010", "00010"},
+	'5': {"11111", "10000", "11110", "00001", "00001", "10001", "01110"},
+	'6': {"00110", "01000", "10000", "11110", "10001", "10001", "01110"},
+	'7': {"11111", "00001", "00010", "00100", "01000", "01000", "01000"},
+	'8': {"01110", "10001", "10001", "01110", "10001", "10001", "01110"},
+	'9': {"01110", "10001", "10001", "01111", "00001", "00010", "01100"},
+	'A': {"01110", "10001", "10001", "11111", "10001", "10001", "10001"},
+	'B': {"11110", "10001", "10001", "11110", "10001", "10001", "11110"},
+	'C': {"01110", "10001", "10000", "10000", "10000", "10001", "01110"},
+	'D': {"11110", "10001", "10001", "10001", "10001", "10001", "11110"},
+	'E': {"11111", "10000", "10000", "11110", "10000", "10000", "11111"},
+	'F': {"11111", "10000", "10000", "11110", "10000", "10000", "10000"},
+	'G': {"01110", "10001", "10000", "10111", "10001", "10001", "01111"},
+	'H': {"10001", "10001", "10001", "11111", "10001", "10001", "10001"},
+	'I': {"01110", "00100", "00100", "00100", "00100", "00100", "01110"},
+	'J': {"00111", "00010", "00010", "00010", "00010", "10010", "01100"},
+	'K': {"10001", "10010", "10100", "11000", "10100", "10010", "10001"},
+	'L': {"10000", "10000", "10000", "10000", "10000", "10000", "11111"},
+	'M': {"10001", "11011", "10101", "10101", "10001", "10001", "10001"},
+	'N': {"10001", "10001", "11001", "10101", "10011", "10001", "10001"},
+	'O': {"01110", "10001", "10001", "10001", "10001", "10001", "01110"},
+	'P': {"11110", "10001", "10001", "11110", "10000", "10000", "10000"},
+	'Q': {"01110", "10001", "10001", "10001", "10101", "10010", "01101"},
+	'R': {"11110", "10001", "10001", "11110", "10100", "10010", "10001"},
+	'S': {"01111", "10000", "10000", "01110", "00001", "00001", "11110"},
+	'T': {"11111", "00100", "00100", "00100", "00100", "00100", "00100"},
+	'U': {"10001", "10001", "10001", "10001", "10001", "10001", "01110"},
+	'V': {"10001", "10001", "10001", "10001", "10001", "01010", "00100"},
+	'W': {"10001", "10001", "10001", "10101", "10101", "10101", "01010"},
+	'X': {"10001", "10001", "01010", "00100", "01010", "10001", "10001"},
+	'Y': {"10001", "10001", "10001", "01010", "00100", "00100", "00100"},
+	'Z': {"11111", "00001", "00010", "00100", "01000", "10000", "11111"},
+	'-': {"00000", "00000", "00000", "11111", "00000", "00000", "00000"},
+	'.': {"00000", "00000", "00000", "00000", "00000", "01100", "01100"},
+	' ': {"00000", "00000", "00000", "00000", "00000", "00000", "00000"},
+	'$': {"00100", "01111", "10100", "01110", "00101", "11110", "00100"},
+	'/': {"00000", "00001", "00010", "00100", "01000", "10000", "00000"},
+	'+': {"00000", "00100", "00100", "11111", "00100", "00100", "00000"},
+	'%': {"11000", "11001", "00010", "00100", "01000", "10011", "00011"},
+	'!': {"00100", "00100", "00100", "00100", "00100", "00000", "00100"},
+	'?': {"01110", "10001", "00001", "00010", "00100", "00000", "00100"},
+	',': {"00000", "00000", "00000", "00000", "01100", "00100", "01000"},
+	':': {"00000", "01100", "01100", "00000", "01100", "01100", "00000"},
+	'&': {"01100", "10010", "10100", "01000", "10101", "10010", "01101"},
+	'@': {"01110", "10001", "10111", "10101", "10111", "10000", "01110"},
+	'#': {"01010", "01010", "11111", "01010", "11111", "01010", "01010"},
+	'(': {"00010", "00100", "01000", "01000", "01000", "00100", "00010"},
+	')': {"01000", "00100", "00010", "00010", "00010", "00100", "01000"},
+}
+
+// linearBarcode is a barcode the generate endpoint renders
+type linearBarcode interface {
+	// value is the encoded value, check digit included
+	value() string
+	// layout returns the width and height of the barcode in modules and its
+	// dark rectangles, with the human-readable text when digits is set
+	layout(digits bool) (int, int, []rect)
+}
+
+// retailBarcode is an EAN-13 or UPC-A symbol. UPC-A codes are EAN-13 codes
+// with a leading zero and differ only in quiet zones and digit layout.
+type retailBarcode struct {
+	kind string
+	// ean is the 13 digits of the EAN-13 form, check digit included
+	ean string
+}
+
+// barcodeOptions control how a barcode is rendered
+type barcodeOptions struct {
+	scale  int
+	digits bool
+	format string
+}
+
+// rect is a filled rectangle in modules
+type rect struct{ x, y, w, h int }
+
+// parseBarcode reads the type and value parameters, the check parameter
+// of Code 39 barcodes, and the bearer parameter of ITF-14 barcodes
+func parseBarcode(query url.Values) (linearBarcode, *apiError) {
+	kind, value := query.Get("type"), query.Get("value")
+	switch kind {
+	case barcodeEAN13, barcodeUPCA:
+		b, apiErr := parseRetailBarcode(kind, value)
+		if apiErr != nil {
+			return nil, apiErr
+		}
+		return b, nil
+	case barcodeCode39:
+		check := false
+		if raw := query.Get("check"); raw != "" {
+			var err error
+			if check, err = strconv.ParseBool(raw); err != nil {
+				return nil, &apiError{Code: errCodeInvalidParam, Message: "Parameter 'check' must be true or false"}
+			}
+		}
+		b, apiErr := parseCode39(value, check)
+		if apiErr != nil {
+			return nil, apiErr
+		}
+		return b, nil
+	case barcodeITF14:
+		b, apiErr := parseITF14(value, query.Get("bearer"))
+		if apiErr != nil {
+			return nil, apiErr
+		}
+		return b, nil
+	}
+	return nil, &apiError{Code: errCodeInvalidParam, Message: "Parameter 'type' must be ean13, upca, code39, or itf14"}
+}
+
+// parseRetailBarcode validates value as a code of kind: 12 or 13 digits for
+// EAN-13 and 11 or 12 for UPC-A. A missing check digit is computed and a
+// present one must match.
+func parseRetailBarcode(kind, value string) (*retailBarcode, *apiError) {
+	length := 13
+	switch kind {
+	case barcodeEAN13:
+	case barcodeUPCA:
+		length = 12
+	default:
+		return nil, &apiError{Code: errCodeInvalidParam, Message: "Parameter 'type' must be ean13 or upca"}
+	}
+	if strings.Trim(value, "0123456789") != "" || (len(value) != length && len(value) != length-1) {
+		return nil, apiErrorf(errCodeInvalidParam, map[string]any{"type": kind},
+			"Parameter 'value' must be %d digits, or %d without the check digit", length, length-1)
+	}
+	ean := value
+	if kind == barcodeUPCA {
+		ean = "0" + value
+	}
+	check := gtinCheckDigit(ean[:12])
+	if len(ean) == 12 {
+		ean += string(check)
+	} else if ean[12] != check {
+		return nil, apiErrorf(errCodeInvalidParam, map[string]any{"type": kind, "check_digit": string(check)},
+			"Check digit of %s is %c, not %c", value, check, ean[12])
+	}
+	return &retailBarcode{kind: kind, ean: ean}, nil
+}
+
+// gtinCheckDigit computes the GS1 check digit of digits, the code without
+// its check digit: counting from the right, odd positions weigh 3 and even
+// positions 1
+func gtinCheckDigit(digits string) byte {
+	sum := 0
+	for i := range len(digits) {
+		weight := 1
+		if (len(digits)-i)%2 == 1 {
+			weight = 3
+		}
+		sum += int(digits[i]-'0') * weight
+	}
+	return byte('0' + (10-sum%10)%10)
+}
+
+// value is the code in its own form: 13 digits for EAN-13, 12 for UPC-A
+func (b *retailBarcode) value() string {
+	if b.kind == barcodeUPCA {
+		return b.ean[1:]
+	}
+	return b.ean
+}
+
+// modules returns the 95 modules of the symbol, dark ones true
+func (b *retailBarcode) modules() []bool {
+	var pattern strings.Builder
+	pattern.WriteString("101")
+	parity := eanParity[b.ean[0]-'0']
+	for i := range 6 {
+		pattern.WriteString(eanCodes[parity[i]][b.ean[1+i]-'0'])
+	}
+	pattern.WriteString("01010")
+	for i := range 6 {
+		pattern.WriteString(eanCodes['R'][b.ean[7+i]-'0'])
+	}
+	pattern.WriteString("101")
+
+	modules := make([]bool, pattern.Len())
+	for i, c := range pattern.String() {
+		modules[i] = c == '1'
+	}
+	return modules
+}
+
+// layout returns the width and height of the barcode in modules and its
+// dark rectangles: bars, then the digits when shown. UPC-A codes have wider
+// quiet zones, full-length bars for their first and last digits, and those
+// digits beside the bars.
+func (b *retailBarcode) layout(digits bool) (int, int, []rect) {
+	quietLeft, quietRight := 11, 7
+	if b.kind == barcodeUPCA {
+		quietLeft, quietRight = 9, 9
+	}
+	width, height := quietLeft+95+quietRight, barcodeBarHeight
+	if digits {
+		height += barcodeDigitsSpace
+	}
+
+	// Guard bars, and the bars of the outer UPC-A digits, extend below the others
+	long := func(module int) bool {
+		if module < 3 || (module >= 45 && module < 50) || module >= 92 {
+			return true
+		}
+		return b.kind == barcodeUPCA && (module < 10 || module >= 85)
+	}
+	var rects []rect
+	modules := b.modules()
+	for x := 0; x < len(modules); {
+		if !modules[x] {
+			x++
+			continue
+		}
+		start := x
+		for x < len(modules) && modules[x] && long(x) == long(start) {
+			x++
+		}
+		h := barcodeBarHeight
+		if digits && long(start) {
+			h += barcodeGuardExtra
+		}
+		rects = append(rects, rect{quietLeft + start, 0, x - start, h})
+	}
+	if !digits {
+		return width, height, rects
+	}
+
+	// Digits are centered in the 7 modules of their bars, or beside the
+	// guards in the quiet zones
+	glyph := func(digit byte, x int) {
+		rects = append(rects, glyphRects(digit, x, barcodeDigitsTop)...)
+	}
+	left, right := quietLeft+3, quietLeft+50
+	if b.kind == barcodeUPCA {
+		glyph(b.ean[1], quietLeft-7)
+		for i := 1; i < 6; i++ {
+			glyph(b.ean[1+i], left+7*i+1)
+		}
+		for i := range 5 {
+			glyph(b.ean[7+i], right+7*i+1)
+		}
+		glyph(b.ean[12], quietLeft+95+2)
+		return width, height, rects
+	}
+	glyph(b.ean[0], quietLeft-7)
+	for i := range 6 {
+		glyph(b.ean[1+i], left+7*i+1)
+		glyph(b.ean[7+i], right+7*i+1)
+	}
+	return width, height, rects
+}
+
+// glyphRects returns the modules of the human-readable character c with
+// its top left corner at x, y
+func glyphRects(c byte, x, y int) []rect {
+	var rects []rect
+	for row, line := range barcodeGlyphs[c] {
+		for col, bit := range line {
+			if bit == '1' {
+				rects = append(rects, rect{x + col, y + row, 1, 1})
+			}
+		}
+	}
+	return rects
+}
+
+// writeBarcode renders a linear barcode as a black-on-white PNG or SVG
+func writeBarcode(w io.Writer, b linearBarcode, opts barcodeOptions) error {
+	width, height, rects := b.layout(opts.digits)
+	if opts.format == formatSVG {
+		return writeRectsSVG(w, width, height, rects, opts.scale, "Barcode "+b.value())
+	}
+	return writeRectsPNG(w, width, height, rects, opts.scale)
+}
+
+// writeRectsPNG renders dark rectangles in a width by height area of
+// modules as a black-on-white PNG of scale pixels per module
+func writeRectsPNG(w io.Writer, width, height int, rects []rect, scale int) error {
+	img := image.NewPaletted(image.Rect(0, 0, width*scale, height*scale), color.Palette{color.White, color.Black})
+	for _, r := range rects {
+		for y := r.y * scale; y < (r.y+r.h)*scale; y++ {
+			for x := r.x * scale; x < (r.x+r.w)*scale; x++ {
+				img.SetColorIndex(x, y, 1)
+			}
+		}
+	}
+	return (&png.Encoder{CompressionLevel: png.BestSpeed}).Encode(w, img)
+}
+
+// writeRectsSVG renders dark rectangles as SVG: a white background and one
+// path of the rectangles, in modules scaled to scale pixels each
+func writeRectsSVG(w io.Writer, width, height int, rects []rect, scale int, label string) error {
+	buf := bufio.NewWriter(w)
+	fmt.Fprintf(buf, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" shape-rendering="crispEdges" role="img" aria-label="%s">`,
+		width*scale, height*scale, width, height, html.EscapeString(label))
+	fmt.Fprintf(buf, `<rect width="%d" height="%d" fill="#ffffff"/><path fill="#000000" d="`, width, height)
+	for _, r := range rects {
+		fmt.Fprintf(buf, "M%d %dh%dv%dh-%dz", r.x, r.y, r.w, r.h, r.w)
+	}
+	buf.WriteString(`"/></svg>`)
+	return buf.Flush()
+}
+
+// parseBarcodeOptions reads the scale, digits, and format parameters
+func parseBarcodeOptions(query url.Values) (barcodeOptions, *apiError) {
+	opts, apiErr := parseSymbolOptions(query)
+	if apiErr != nil {
+		return opts, apiErr
+	}
+	opts.digits = true
+	if raw := query.Get("digits"); raw != "" {
+		digits, err := strconv.ParseBool(raw)
+		if err != nil {
+			return opts, &apiError{Code: errCodeInvalidParam, Message: "Parameter 'digits' must be true or false"}
+		}
+		opts.digits = digits
+	}
+	return opts, nil
+}
+
+// parseSymbolOptions reads the scale and format parameters shared by
+// barcodes and Data Matrix symbols
+func parseSymbolOptions(query url.Values) (barcodeOptions, *apiError) {
+	opts := barcodeOptions{scale: defaultBarcodeScale, format: formatPNG}
+	if raw := query.Get("scale"); raw != "" {
+		scale, err := strconv.Atoi(raw)
+		if err != nil || scale < minBarcodeScale || scale > maxBarcodeScale {
+			return opts, apiErrorf(errCodeInvalidParam, nil, "Parameter 'scale' must be a number of pixels per module from %d to %d", minBarcodeScale, maxBarcodeScale)
+		}
+		opts.scale = scale
+	}
+	switch format := query.Get("format"); format {
+	case "":
+	case formatPNG, formatSVG:
+		opts.format = format
+	default:
+		return opts, &apiError{Code: errCodeInvalidParam, Message: "Parameter 'format' must be png or svg"}
+	}
+	return opts, nil
+}
+
+// handleBarcode renders an EAN-13, UPC-A, Code 39, or ITF-14 barcode of
+// the type and value parameters, with the human-readable text below the
+// bars unless digits=false. Barcodes are deterministic and served with the
+// caching headers of QR codes.
+func handleBarcode(deps handlerDeps) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		if query.Get("value") == "" {
+			writeAPIError(w, r, http.StatusBadRequest, apiError{Code: errCodeInvalidParam, Message: "Missing required parameter 'value'"})
+			return
+		}
+		barcode, apiErr := parseBarcode(query)
+		if apiErr != nil {
+			writeAPIError(w, r, http.StatusBadRequest, *apiErr)
+			return
+		}
+		opts, apiErr := parseBarcodeOptions(query)
+		if apiErr != nil {
+			writeAPIError(w, r, http.StatusBadRequest, *apiErr)
+			return
+		}
+		kind := query.Get("type")
+		setAuditPayload(r.Context(), barcode.value(), map[string]string{"type": kind})
+
+		parts := []string{renderVersion, "barcode", kind, opts.format, strconv.Itoa(opts.scale), strconv.FormatBool(opts.digits)}
+		if itf, ok := barcode.(*itf14Barcode); ok {
+			parts = append(parts, itf.bearer)
+		}
+		etag := imageETag(imageCacheKey(barcode.value(), parts...))
+		setImageCacheHeaders(w.Header(), etag, deps.imageMaxAge)
+		if etagMatches(r.Header.Get("If-None-Match"), etag) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		var out bytes.Buffer
+		contentType := "image/png"
+		if opts.format == formatSVG {
+			contentType = "image/svg+xml"
+		}
+		if err := writeBarcode(&out, barcode, opts); err != nil {
+			setRequestError(r.Context(), err)
+			http.Error(w, "Failed to generate barcode", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Last-Modified", renderModTime.Format(http.TimeFormat))
+		serveImage(w, r, kind+"."+opts.format, out.Bytes())
+	}
+}
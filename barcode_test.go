@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bytes"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParseRetailBarcode(t *testing.T) {
+	tests := []struct {
+		name      string
+		kind      string
+		value     string
+		wantEAN   string
+		wantValue string
+		wantErr   bool
+	}{
+		{name: "EAN-13", kind: barcodeEAN13, value: "4006381333931", wantEAN: "4006381333931", wantValue: "4006381333931"},
+		{name: "EAN-13 without check digit", kind: barcodeEAN13, value: "400638133393", wantEAN: "4006381333931", wantValue: "4006381333931"},
+		{name: "EAN-13 check digit zero", kind: barcodeEAN13, value: "590123412345", wantEAN: "5901234123457", wantValue: "5901234123457"},
+		{name: "UPC-A", kind: barcodeUPCA, value: "036000291452", wantEAN: "0036000291452", wantValue: "036000291452"},
+		{name: "UPC-A without check digit", kind: barcodeUPCA, value: "03600029145", wantEAN: "0036000291452", wantValue: "036000291452"},
+		{name: "wrong check digit", kind: barcodeEAN13, value: "4006381333932", wantErr: true},
+		{name: "too short", kind: barcodeEAN13, value: "40063813339", wantErr: true},
+		{name: "UPC-A too long", kind: barcodeUPCA, value: "4006381333931", wantErr: true},
+		{name: "letters", kind: barcodeEAN13, value: "40063813339a", wantErr: true},
+		{name: "unknown type", kind: "code128", value: "4006381333931", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, apiErr := parseRetailBarcode(tt.kind, tt.value)
+			if (apiErr != nil) != tt.wantErr {
+				t.Fatalf("error = %v, want error %v", apiErr, tt.wantErr)
+			}
+			if apiErr != nil {
+				return
+			}
+			if b.ean != tt.wantEAN || b.value() != tt.wantValue {
+				t.Errorf("barcode = %s (%s), want %s (%s)", b.ean, b.value(), tt.wantEAN, tt.wantValue)
+			}
+		})
+	}
+}
+
+func TestRetailBarcode_Modules(t *testing.T) {
+	for _, value := range []string{"4006381333931", "5901234123457", "9780201379624", "0036000291452"} {
+		t.Run(value, func(t *testing.T) {
+			b, apiErr := parseRetailBarcode(barcodeEAN13, value)
+			if apiErr != nil {
+				t.Fatal(apiErr)
+			}
+			if got := decodeEAN13(t, b.modules()); got != value {
+				t.Errorf("modules decode to %s, want %s", got, value)
+			}
+		})
+	}
+}
+
+func TestRetailBarcode_PNG(t *testing.T) {
+	tests := []struct {
+		kind       string
+		value      string
+		digits     bool
+		wantWidth  int
+		wantHeight int
+	}{
+		{kind: barcodeEAN13, value: "4006381333931", digits: true, wantWidth: 113 * 2, wantHeight: 78 * 2},
+		{kind: barcodeEAN13, value: "4006381333931", wantWidth: 113 * 2, wantHeight: 69 * 2},
+		{kind: barcodeUPCA, value: "036000291452", digits: true, wantWidth: 113 * 2, wantHeight: 78 * 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.kind, func(t *testing.T) {
+			b, apiErr := parseRetailBarcode(tt.kind, tt.value)
+			if apiErr != nil {
+				t.Fatal(apiErr)
+			}
+			var buf bytes.Buffer
+			if err := writeBarcode(&buf, b, barcodeOptions{scale: 2, digits: tt.digits, format: formatPNG}); err != nil {
+				t.Fatal(err)
+			}
+			img, err := png.Decode(&buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := img.Bounds(); got.Dx() != tt.wantWidth || got.Dy() != tt.wantHeight {
+				t.Fatalf("size = %dx%d, want %dx%d", got.Dx(), got.Dy(), tt.wantWidth, tt.wantHeight)
+			}
+
+			// A scan line through the bars reads the code back
+			quiet := 11
+			if tt.kind == barcodeUPCA {
+				quiet = 9
+			}
+			modules := make([]bool, 95)
+			for i := range modules {
+				r, _, _, _ := img.At((quiet+i)*2, 10).RGBA()
+				modules[i] = r == 0
+			}
+			if got := decodeEAN13(t, modules); got != b.ean {
+				t.Errorf("scan line decodes to %s, want %s", got, b.ean)
+			}
+			for x := range quiet * 2 {
+				if r, _, _, _ := img.At(x, 10).RGBA(); r == 0 {
+					t.Fatalf("quiet zone has a dark pixel at %d", x)
+				}
+			}
+		})
+	}
+}
+
+func TestBarcode(t *testing.T) {
+	tests := []struct {
+		name            string
+		query           string
+		wantStatus      int
+		wantContentType string
+		wantBody        string
+	}{
+		{name: "EAN-13 PNG", query: "type=ean13&value=400638133393", wantStatus: http.StatusOK, wantContentType: "image/png"},
+		{name: "UPC-A SVG", query: "type=upca&value=036000291452&format=svg&scale=4&digits=false", wantStatus: http.StatusOK, wantContentType: "image/svg+xml", wantBody: `width="452" height="276" viewBox="0 0 113 69"`},
+		{name: "missing value", query: "type=ean13", wantStatus: http.StatusBadRequest},
+		{name: "wrong check digit", query: "type=ean13&value=4006381333932", wantStatus: http.StatusBadRequest, wantBody: `"check_digit":"1"`},
+		{name: "invalid scale", query: "type=ean13&value=400638133393&scale=20", wantStatus: http.StatusBadRequest},
+		{name: "invalid digits", query: "type=ean13&value=400638133393&digits=below", wantStatus: http.StatusBadRequest},
+		{name: "invalid format", query: "type=ean13&value=400638133393&format=gif", wantStatus: http.StatusBadRequest},
+		{name: "Code 39 with check character", query: "type=code39&value=CODE39&check=true&format=svg", wantStatus: http.StatusOK, wantContentType: "image/svg+xml", wantBody: `aria-label="Barcode CODE39W"`},
+		{name: "Code 39 lowercase", query: "type=code39&value=code39", wantStatus: http.StatusBadRequest},
+		{name: "invalid check", query: "type=code39&value=CODE39&check=maybe", wantStatus: http.StatusBadRequest},
+		{name: "ITF-14 with computed check digit", query: "type=itf14&value=1540014128876&format=svg", wantStatus: http.StatusOK, wantContentType: "image/svg+xml", wantBody: `aria-label="Barcode 15400141288763"`},
+		{name: "ITF-14 wrong check digit", query: "type=itf14&value=15400141288764", wantStatus: http.StatusBadRequest, wantBody: `"check_digit":"3"`},
+		{name: "invalid bearer", query: "type=itf14&value=1540014128876&bearer=box", wantStatus: http.StatusBadRequest},
+		{name: "unknown type", query: "type=code128&value=CODE39", wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			newTestHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v1/barcode/generate?"+tt.query, nil))
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if tt.wantContentType != "" && rec.Header().Get("Content-Type") != tt.wantContentType {
+				t.Errorf("content type = %q, want %q", rec.Header().Get("Content-Type"), tt.wantContentType)
+			}
+			if !strings.Contains(rec.Body.String(), tt.wantBody) {
+				t.Errorf("body lacks %q: %.300s", tt.wantBody, rec.Body.String())
+			}
+			if rec.Code == http.StatusOK && rec.Header().Get("ETag") == "" {
+				t.Error("barcode has no ETag")
+			}
+		})
+	}
+}
+
+// decodeEAN13 reads the digits of 95 EAN-13 modules, failing on invalid
+// guards or digit patterns
+func decodeEAN13(t *testing.T, modules []bool) string {
+	t.Helper()
+	var pattern strings.Builder
+	for _, dark := range modules {
+		if dark {
+			pattern.WriteByte('1')
+		} else {
+			pattern.WriteByte('0')
+		}
+	}
+	bits := pattern.String()
+	if len(bits) != 95 || bits[:3] != "101" || bits[45:50] != "01010" || bits[92:] != "101" {
+		t.Fatalf("invalid guards: %s", bits)
+	}
+	lookup := func(code string, sets string) (byte, byte) {
+		for _, set := range []byte(sets) {
+			for digit, c := range eanCodes[set] {
+				if c == code {
+					return byte('0' + digit), set
+				}
+			}
+		}
+		t.Fatalf("invalid digit pattern %s", code)
+		return 0, 0
+	}
+	var left, parity, right []byte
+	for i := range 6 {
+		digit, set := lookup(bits[3+7*i:10+7*i], "LG")
+		left, parity = append(left, digit), append(parity, set)
+		digit, _ = lookup(bits[50+7*i:57+7*i], "R")
+		right = append(right, digit)
+	}
+	for first, p := range eanParity {
+		if p == string(parity) {
+			return string(rune('0'+first)) + string(left) + string(right)
+		}
+	}
+	t.Fatalf("invalid parity %s", parity)
+	return ""
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/skip2/go-qrcode"
+)
+
+// symbologyHeader names the symbology the auto endpoint chose
+const symbologyHeader = "X-Barcode-Symbology"
+
+// Symbologies of the auto endpoint besides the barcode types
+const (
+	symbologyQR         = "qr"
+	symbologyDataMatrix = "datamatrix"
+	symbologyPDF417     = "pdf417"
+)
+
+// Bounds and default of the module_mm parameter, the physical width of a
+// module: 0.33 mm is the nominal size of retail barcodes
+const (
+	minModuleMM     = 0.1
+	maxModuleMM     = 2.0
+	defaultModuleMM = 0.33
+)
+
+// maxSymbolMM bounds the max_width_mm and max_height_mm parameters
+const maxSymbolMM = 1000.0
+
+// autoConstraints are the constraints of the auto endpoint: the maximum
+// size of the symbol in mm, 0 for any, at moduleMM mm per module, and
+// whether only linear or only numeric symbologies are allowed
+type autoConstraints struct {
+	maxWidthMM, maxHeightMM float64
+	moduleMM                float64
+	linear, numeric         bool
+}
+
+// autoSymbol is a symbol the auto endpoint can render: its size in modules,
+// quiet zones included, and its dark rectangles
+type autoSymbol struct {
+	symbology     string
+	label         string
+	linear        bool
+	numeric       bool
+	width, height int
+	rects         []rect
+}
+
+// parseAutoConstraints reads the max_width_mm, max_height_mm, module_mm,
+// linear, and numeric parameters
+func parseAutoConstraints(query url.Values) (autoConstraints, *apiError) {
+	c := autoConstraints{moduleMM: defaultModuleMM}
+	for _, param := range []struct {
+		name     string
+		value    *float64
+		min, max float64
+	}{
+		{"max_width_mm", &c.maxWidthMM, 0, maxSymbolMM},
+		{"max_height_mm", &c.maxHeightMM, 0, maxSymbolMM},
+		{"module_mm", &c.moduleMM, minModuleMM, maxModuleMM},
+	} {
+		raw := query.Get(param.name)
+		if raw == "" {
+			continue
+		}
+		n, err := strconv.ParseFloat(raw, 64)
+		if err != nil || n <= param.min || n > param.max {
+			return c, apiErrorf(errCodeInvalidParam, nil, "Parameter '%s' must be a number of millimeters above %g and up to %g", param.name, param.min, param.max)
+		}
+		*param.value = n
+	}
+	for _, param := range []struct {
+		name  string
+		value *bool
+	}{
+		{"linear", &c.linear},
+		{"numeric", &c.numeric},
+	} {
+		raw := query.Get(param.name)
+		if raw == "" {
+			continue
+		}
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return c, apiErrorf(errCodeInvalidParam, nil, "Parameter '%s' must be true or false", param.name)
+		}
+		*param.value = b
+	}
+	return c, nil
+}
+
+// allows reports whether s is of an allowed symbology
+func (c autoConstraints) allows(s autoSymbol) bool {
+	return (!c.linear || s.linear) && (!c.numeric || s.numeric)
+}
+
+// fits reports whether s fits in the maximum size
+func (c autoConstraints) fits(s autoSymbol) bool {
+	return (c.maxWidthMM == 0 || float64(s.width)*c.moduleMM <= c.maxWidthMM) &&
+		(c.maxHeightMM == 0 || float64(s.height)*c.moduleMM <= c.maxHeightMM)
+}
+
+// autoSymbols encodes text in every symbology that can hold it: QR and
+// Data Matrix, PDF417 at each number of columns, and the linear barcodes
+// whose values text is. Retail and ITF-14 codes must include their check
+// digit, so a number is never given a computed one it didn't have.
+func autoSymbols(text string, digits bool) []autoSymbol {
+	var symbols []autoSymbol
+	if q, err := qrcode.New(text, recoveryLevels[defaultRenderOptions.level]); err == nil {
+		bitmap := q.Bitmap()
+		symbols = append(symbols, autoSymbol{symbology: symbologyQR, label: "QR code", width: len(bitmap), height: len(bitmap), rects: bitmapRects(bitmap)})
+	}
+	if content, apiErr := dataMatrixContent(text, false); apiErr == nil {
+		if width, height, rects, err := dataMatrixLayout(content); err == nil {
+			symbols = append(symbols, autoSymbol{symbology: symbologyDataMatrix, label: "Data Matrix code", width: width, height: height, rects: rects})
+		}
+	}
+	if content, apiErr := latin1Bytes("PDF417", text); apiErr == nil {
+		for columns := pdf417MinColumns; columns <= pdf417MaxColumns; columns++ {
+			symbol, _, apiErr := newPDF417(content, pdf417Options{level: -1, columns: columns})
+			if apiErr != nil {
+				continue
+			}
+			width, height, rects := symbol.layout()
+			symbols = append(symbols, autoSymbol{symbology: symbologyPDF417, label: "PDF417 code", width: width, height: height, rects: rects})
+		}
+	}
+
+	linear := func(kind string, b linearBarcode, numeric bool) {
+		width, height, rects := b.layout(digits)
+		symbols = append(symbols, autoSymbol{symbology: kind, label: "Barcode " + b.value(), linear: true, numeric: numeric, width: width, height: height, rects: rects})
+	}
+	if b, apiErr := parseCode39(text, false); apiErr == nil {
+		linear(barcodeCode39, b, false)
+	}
+	switch len(text) {
+	case 12:
+		if b, apiErr := parseRetailBarcode(barcodeUPCA, text); apiErr == nil {
+			linear(barcodeUPCA, b, true)
+		}
+	case 13:
+		if b, apiErr := parseRetailBarcode(barcodeEAN13, text); apiErr == nil {
+			linear(barcodeEAN13, b, true)
+		}
+	case 14:
+		if b, apiErr := parseITF14(text, ""); apiErr == nil {
+			linear(barcodeITF14, b, true)
+		}
+	}
+	return symbols
+}
+
+// bitmapRects returns the dark modules of a bitmap as one rectangle per
+// run in each row
+func bitmapRects(bitmap [][]bool) []rect {
+	var rects []rect
+	for y, row := range bitmap {
+		for x := 0; x < len(row); {
+			if !row[x] {
+				x++
+				continue
+			}
+			start := x
+			for x < len(row) && row[x] {
+				x++
+			}
+			rects = append(rects, rect{start, y, x - start, 1})
+		}
+	}
+	return rects
+}
+
+// chooseSymbol returns the densest allowed symbol, the one of the smallest
+// area, that fits the maximum size. Without one, it returns the densest
+// allowed symbol, if any, and false.
+func chooseSymbol(symbols []autoSymbol, c autoConstraints) (autoSymbol, bool, bool) {
+	var densest, best autoSymbol
+	allowed, found := false, false
+	for _, s := range symbols {
+		if !c.allows(s) {
+			continue
+		}
+		if !allowed || s.width*s.height < densest.width*densest.height {
+			densest, allowed = s, true
+		}
+		if c.fits(s) && (!found || s.width*s.height < best.width*best.height) {
+			best, found = s, true
+		}
+	}
+	if !found {
+		return densest, allowed, false
+	}
+	return best, true, true
+}
+
+// handleAutoBarcode renders the text parameter in the densest symbology
+// that holds it within the constraints, and names the symbology in the
+// X-Barcode-Symbology header. Symbols are deterministic and served with the
+// caching headers of QR codes.
+func handleAutoBarcode(deps handlerDeps) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		text := query.Get("text")
+		if text == "" {
+			writeAPIError(w, r, http.StatusBadRequest, apiError{Code: errCodeInvalidParam, Message: "Missing required parameter 'text'"})
+			return
+		}
+		constraints, apiErr := parseAutoConstraints(query)
+		if apiErr != nil {
+			writeAPIError(w, r, http.StatusBadRequest, *apiErr)
+			return
+		}
+		opts, apiErr := parseBarcodeOptions(query)
+		if apiErr != nil {
+			writeAPIError(w, r, http.StatusBadRequest, *apiErr)
+			return
+		}
+		setAuditPayload(r.Context(), text, nil)
+
+		if status, apiErr := validatePayload(text, deps.maxPayloadLength); apiErr != nil {
+			writeAPIError(w, r, status, *apiErr)
+			return
+		}
+		if text, apiErr = sanitizePayload(text, deps.sanitize); apiErr != nil {
+			writeAPIError(w, r, http.StatusBadRequest, *apiErr)
+			return
+		}
+		if apiErr := deps.policy.check(text, apiKeyName(r.Context())); apiErr != nil {
+			writeAPIError(w, r, http.StatusUnprocessableEntity, *apiErr)
+			return
+		}
+
+		symbols := autoSymbols(text, opts.digits)
+		if len(symbols) == 0 {
+			writeAPIError(w, r, http.StatusRequestEntityTooLarge, apiError{Code: errCodePayloadTooLarge, Message: "Parameter 'text' does not fit in any symbology"})
+			return
+		}
+		symbol, allowed, fits := chooseSymbol(symbols, constraints)
+		if !allowed {
+			writeAPIError(w, r, http.StatusUnprocessableEntity, apiError{Code: errCodeInvalidParam, Message: "No symbology allowed by the constraints encodes parameter 'text'"})
+			return
+		}
+		if !fits {
+			widthMM, heightMM := float64(symbol.width)*constraints.moduleMM, float64(symbol.height)*constraints.moduleMM
+			writeAPIError(w, r, http.StatusUnprocessableEntity, *apiErrorf(errCodeInvalidParam,
+				map[string]any{"symbology": symbol.symbology, "width_mm": widthMM, "height_mm": heightMM},
+				"The smallest symbol of parameter 'text' is %.1f by %.1f mm, larger than the maximum size", widthMM, heightMM))
+			return
+		}
+		w.Header().Set(symbologyHeader, symbol.symbology)
+
+		etag := imageETag(imageCacheKey(text, renderVersion, "auto", symbol.symbology, strconv.Itoa(symbol.width), strconv.Itoa(symbol.height),
+			opts.format, strconv.Itoa(opts.scale), strconv.FormatBool(opts.digits)))
+		setImageCacheHeaders(w.Header(), etag, deps.imageMaxAge)
+		if etagMatches(r.Header.Get("If-None-Match"), etag) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		var out bytes.Buffer
+		contentType := "image/png"
+		if opts.format == formatSVG {
+			contentType = "image/svg+xml"
+			writeRectsSVG(&out, symbol.width, symbol.height, symbol.rects, opts.scale, symbol.label)
+		} else if err := writeRectsPNG(&out, symbol.width, symbol.height, symbol.rects, opts.scale); err != nil {
+			setRequestError(r.Context(), err)
+			http.Error(w, "Failed to generate barcode", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Last-Modified", renderModTime.Format(http.TimeFormat))
+		serveImage(w, r, symbol.symbology+"."+opts.format, out.Bytes())
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParseAutoConstraints(t *testing.T) {
+	tests := []struct {
+		name    string
+		query   string
+		want    autoConstraints
+		wantErr bool
+	}{
+		{name: "defaults", query: "", want: autoConstraints{moduleMM: defaultModuleMM}},
+		{name: "all", query: "max_width_mm=40&max_height_mm=12.5&module_mm=0.5&linear=true&numeric=1", want: autoConstraints{maxWidthMM: 40, maxHeightMM: 12.5, moduleMM: 0.5, linear: true, numeric: true}},
+		{name: "zero width", query: "max_width_mm=0", wantErr: true},
+		{name: "module too large", query: "module_mm=3", wantErr: true},
+		{name: "not a number", query: "max_height_mm=tall", wantErr: true},
+		{name: "invalid linear", query: "linear=maybe", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, _ := url.ParseQuery(tt.query)
+			got, apiErr := parseAutoConstraints(query)
+			if (apiErr != nil) != tt.wantErr {
+				t.Fatalf("error = %v, want error %v", apiErr, tt.wantErr)
+			}
+			if apiErr == nil && got != tt.want {
+				t.Errorf("constraints = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAutoSymbols(t *testing.T) {
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{text: "HELLO", want: []string{symbologyQR, symbologyDataMatrix, symbologyPDF417, barcodeCode39}},
+		{text: "hello", want: []string{symbologyQR, symbologyDataMatrix, symbologyPDF417}},
+		{text: "036000291452", want: []string{symbologyQR, symbologyDataMatrix, symbologyPDF417, barcodeCode39, barcodeUPCA}},
+		{text: "4006381333931", want: []string{symbologyQR, symbologyDataMatrix, symbologyPDF417, barcodeCode39, barcodeEAN13}},
+		{text: "4006381333932", want: []string{symbologyQR, symbologyDataMatrix, symbologyPDF417, barcodeCode39}},
+		{text: "15400141288763", want: []string{symbologyQR, symbologyDataMatrix, symbologyPDF417, barcodeCode39, barcodeITF14}},
+		{text: "Grüße ✓", want: []string{symbologyQR}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			var got []string
+			for _, s := range autoSymbols(tt.text, true) {
+				if !slices.Contains(got, s.symbology) {
+					got = append(got, s.symbology)
+				}
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("symbologies = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestChooseSymbol(t *testing.T) {
+	symbols := []autoSymbol{
+		{symbology: symbologyQR, width: 29, height: 29},
+		{symbology: symbologyDataMatrix, width: 20, height: 20},
+		{symbology: symbologyPDF417, width: 120, height: 13},
+		{symbology: barcodeEAN13, width: 113, height: 78, linear: true, numeric: true},
+	}
+	tests := []struct {
+		name        string
+		constraints autoConstraints
+		want        string
+		wantAllowed bool
+		wantFits    bool
+	}{
+		{name: "densest", constraints: autoConstraints{moduleMM: 1}, want: symbologyDataMatrix, wantAllowed: true, wantFits: true},
+		{name: "low height", constraints: autoConstraints{moduleMM: 1, maxHeightMM: 15}, want: symbologyPDF417, wantAllowed: true, wantFits: true},
+		{name: "narrow", constraints: autoConstraints{moduleMM: 0.5, maxWidthMM: 10}, want: symbologyDataMatrix, wantAllowed: true, wantFits: true},
+		{name: "linear", constraints: autoConstraints{moduleMM: 1, linear: true}, want: barcodeEAN13, wantAllowed: true, wantFits: true},
+		{name: "nothing fits", constraints: autoConstraints{moduleMM: 1, maxWidthMM: 15}, want: symbologyDataMatrix, wantAllowed: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, allowed, fits := chooseSymbol(symbols, tt.constraints)
+			if got.symbology != tt.want || allowed != tt.wantAllowed || fits != tt.wantFits {
+				t.Errorf("chooseSymbol = %s, %v, %v, want %s, %v, %v", got.symbology, allowed, fits, tt.want, tt.wantAllowed, tt.wantFits)
+			}
+		})
+	}
+	if _, allowed, _ := chooseSymbol(symbols[:3], autoConstraints{moduleMM: 1, numeric: true}); allowed {
+		t.Error("numeric constraint allowed a 2D symbology")
+	}
+}
+
+func TestAutoBarcode(t *testing.T) {
+	tests := []struct {
+		name          string
+		query         string
+		wantStatus    int
+		wantSymbology string
+		wantBody      string
+	}{
+		{name: "densest", query: "text=HELLO", wantStatus: http.StatusOK, wantSymbology: symbologyDataMatrix},
+		{name: "linear only", query: "text=HELLO&linear=true&format=svg", wantStatus: http.StatusOK, wantSymbology: barcodeCode39, wantBody: `aria-label="Barcode HELLO"`},
+		{name: "numeric only", query: "text=4006381333931&numeric=true", wantStatus: http.StatusOK, wantSymbology: barcodeEAN13},
+		{name: "low label", query: "text=1234567890123456789012345678901234567890&max_height_mm=5", wantStatus: http.StatusOK, wantSymbology: symbologyPDF417},
+		{name: "numeric text required", query: "text=HELLO&numeric=true", wantStatus: http.StatusUnprocessableEntity},
+		{name: "too small", query: "text=HELLO&max_width_mm=2", wantStatus: http.StatusUnprocessableEntity, wantBody: `"symbology":"datamatrix"`},
+		{name: "missing text", query: "linear=true", wantStatus: http.StatusBadRequest},
+		{name: "invalid module size", query: "text=HELLO&module_mm=0", wantStatus: http.StatusBadRequest},
+		{name: "invalid format", query: "text=HELLO&format=gif", wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			newTestHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v1/barcode/auto?"+tt.query, nil))
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if got := rec.Header().Get(symbologyHeader); got != tt.wantSymbology {
+				t.Errorf("%s = %q, want %q", symbologyHeader, got, tt.wantSymbology)
+			}
+			if !strings.Contains(rec.Body.String(), tt.wantBody) {
+				t.Errorf("body = %s, want it to contain %s", rec.Body.String(), tt.wantBody)
+			}
+		})
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// forwardedPrefixHeader carries the path prefix a proxy mounts the service
+// at, when the proxy strips it before forwarding
+const forwardedPrefixHeader = "X-Forwarded-Prefix"
+
+// parseBasePath normalizes the path prefix the service is mounted at:
+// "/tools/qr/" becomes "/tools/qr", and empty or "/" is no prefix
+func parseBasePath(basePath string) (string, error) {
+	basePath = strings.TrimSuffix(basePath, "/")
+	if basePath == "" {
+		return "", nil
+	}
+	if !strings.HasPrefix(basePath, "/") || path.Clean(basePath) != basePath || strings.ContainsAny(basePath, "?#%") {
+		return "", fmt.Errorf("base path must be a clean absolute path such as /tools/qr, got %q", basePath)
+	}
+	return basePath, nil
+}
+
+// basePathKey is the context key for the path prefix of the request
+type basePathKey struct{}
+
+// originalURIKey is the context key for the request URI as the client sent
+// it, before the base path was stripped
+type originalURIKey struct{}
+
+// basePathMiddleware serves the API mounted below basePath. Requests below
+// it have the prefix stripped, so routes match whether or not the Ingress
+// rewrites paths, and requests without it are served as they are. A trusted
+// proxy's X-Forwarded-Prefix takes precedence as the prefix of links.
+func basePathMiddleware(basePath string, trusted *trustedProxies, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		prefix := basePath
+		ctx := context.WithValue(r.Context(), originalURIKey{}, r.URL.RequestURI())
+		if basePath != "" && (r.URL.Path == basePath || strings.HasPrefix(r.URL.Path, basePath+"/")) {
+			stripped := new(http.Request)
+			*stripped = *r
+			stripped.URL = new(url.URL)
+			*stripped.URL = *r.URL
+			stripped.URL.Path = "/" + strings.TrimPrefix(r.URL.Path[len(basePath):], "/")
+			stripped.URL.RawPath = strings.TrimPrefix(r.URL.RawPath, basePath)
+			r = stripped
+		}
+		if trusted.fromProxy(r) {
+			if forwarded, err := parseBasePath(r.Header.Get(forwardedPrefixHeader)); err == nil && forwarded != "" {
+				prefix = forwarded
+			}
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(ctx, basePathKey{}, prefix)))
+	})
+}
+
+// basePathFromContext returns the path prefix of links in responses to the
+// request carried by ctx, or "" when the service is mounted at the root
+func basePathFromContext(ctx context.Context) string {
+	prefix, _ := ctx.Value(basePathKey{}).(string)
+	return prefix
+}
+
+// originalRequestURI returns the URI of r as the client sent it, including
+// the base path that basePathMiddleware stripped
+func originalRequestURI(r *http.Request) string {
+	if uri, ok := r.Context().Value(originalURIKey{}).(string); ok {
+		return uri
+	}
+	return r.URL.RequestURI()
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseBasePath(t *testing.T) {
+	tests := []struct {
+		name    string
+		path    string
+		want    string
+		wantErr bool
+	}{
+		{name: "empty", path: "", want: ""},
+		{name: "root", path: "/", want: ""},
+		{name: "prefix", path: "/tools/qr", want: "/tools/qr"},
+		{name: "trailing slash", path: "/tools/qr/", want: "/tools/qr"},
+		{name: "relative", path: "tools/qr", wantErr: true},
+		{name: "unclean", path: "/tools/../qr", wantErr: true},
+		{name: "query", path: "/tools/qr?x=1", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseBasePath(tt.path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseBasePath(%q) error = %v, want error %v", tt.path, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("parseBasePath(%q) = %q, want %q", tt.path, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBasePath(t *testing.T) {
+	proxies, err := parseTrustedProxies("10.0.0.0/8", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name         string
+		basePath     string
+		path         string
+		remoteAddr   string
+		prefix       string
+		wantStatus   int
+		wantLocation string
+	}{
+		{name: "below base path", basePath: "/tools/qr", path: "/tools/qr/api/v1/qr/generate?text=hello", wantStatus: http.StatusOK},
+		{name: "without base path", basePath: "/tools/qr", path: "/api/v1/qr/generate?text=hello", wantStatus: http.StatusOK},
+		{name: "base path itself", basePath: "/tools/qr", path: "/tools/qr", wantStatus: http.StatusOK},
+		{name: "similar path", basePath: "/tools/qr", path: "/tools/qrx/api/v1/qr/generate?text=hello", wantStatus: http.StatusNotFound},
+		{name: "redirect keeps base path", basePath: "/tools/qr", path: "/tools/qr/ui", wantStatus: http.StatusMovedPermanently, wantLocation: "/tools/qr/ui/"},
+		{name: "redirect at root", path: "/ui", wantStatus: http.StatusMovedPermanently, wantLocation: "/ui/"},
+		{name: "forwarded prefix", path: "/ui", remoteAddr: "10.0.0.5:1234", prefix: "/tools/qr/", wantStatus: http.StatusMovedPermanently, wantLocation: "/tools/qr/ui/"},
+		{name: "forwarded prefix from untrusted peer", path: "/ui", remoteAddr: "203.0.113.7:1234", prefix: "/evil", wantStatus: http.StatusMovedPermanently, wantLocation: "/ui/"},
+		{name: "invalid forwarded prefix", basePath: "/tools/qr", path: "/ui", remoteAddr: "10.0.0.5:1234", prefix: "//evil.example.com", wantStatus: http.StatusMovedPermanently, wantLocation: "/tools/qr/ui/"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deps := newTestDeps()
+			deps.flags.Set(flagUI, true)
+			deps.basePath = tt.basePath
+			deps.proxies = proxies
+			req := httptest.NewRequest(http.MethodPost, tt.path, nil)
+			if tt.wantLocation != "" {
+				req.Method = http.MethodGet
+			}
+			if tt.remoteAddr != "" {
+				req.RemoteAddr = tt.remoteAddr
+			}
+			if tt.prefix != "" {
+				req.Header.Set(forwardedPrefixHeader, tt.prefix)
+			}
+			rec := httptest.NewRecorder()
+			newHandler(deps).ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if got := rec.Header().Get("Location"); got != tt.wantLocation {
+				t.Errorf("Location = %q, want %q", got, tt.wantLocation)
+			}
+		})
+	}
+}
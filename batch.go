@@ -0,0 +1,311 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"runtime/debug"
+	"strconv"
+	"sync"
+)
+
+// errCodeRenderFailed is reported for batch items whose render failed
+const errCodeRenderFailed = "render_failed"
+
+// batchRequest is the body of POST /api/v1/qr/batch
+type batchRequest struct {
+	Items []batchItem `json:"items"`
+	// Options apply to every item that doesn't override them
+	Options batchOptions `json:"options,omitempty"`
+	// Deliver uploads the results instead of returning them ("sftp")
+	Deliver string `json:"deliver,omitempty"`
+	// PathTemplate overrides SFTP_PATH_TEMPLATE for this batch
+	PathTemplate string `json:"path_template,omitempty"`
+}
+
+// batchCompleted is the data of the batch.completed webhook event
+type batchCompleted struct {
+	RequestID string `json:"request_id"`
+	Tenant    string `json:"tenant,omitempty"`
+	Items     int    `json:"items"`
+	Succeeded int    `json:"succeeded"`
+	Failed    int    `json:"failed"`
+}
+
+// batchItem is one code to render in a batch
+type batchItem struct {
+	Text string `json:"text"`
+	// ID is the caller's reference for the item, available to path templates
+	ID string `json:"id,omitempty"`
+	// Options override the options of the batch for this item
+	Options batchOptions `json:"options,omitempty"`
+}
+
+// batchOptions are the image options of batch items, named like the
+// parameters of the generate endpoint. Zero values leave an option as is.
+type batchOptions struct {
+	Size       int    `json:"size,omitempty"`
+	Level      string `json:"ec,omitempty"`
+	Foreground string `json:"fg,omitempty"`
+	Background string `json:"bg,omitempty"`
+	Format     string `json:"format,omitempty"`
+	// Mode is the encoding mode, auto by default, and Charset the character
+	// set behind an ECI header
+	Mode    string `json:"mode,omitempty"`
+	Charset string `json:"charset,omitempty"`
+	// Title and Description label SVG codes for screen readers
+	Title       string `json:"title,omitempty"`
+	Description string `json:"desc,omitempty"`
+}
+
+// apply sets the options that are set in query, as generate parameters
+func (o batchOptions) apply(query url.Values) {
+	if o.Size != 0 {
+		query.Set("size", strconv.Itoa(o.Size))
+	}
+	for _, param := range []struct{ name, value string }{
+		{"ec", o.Level}, {"mode", o.Mode}, {"charset", o.Charset}, {"fg", o.Foreground}, {"bg", o.Background}, {"format", o.Format}, {"title", o.Title}, {"desc", o.Description},
+	} {
+		if param.value != "" {
+			query.Set(param.name, param.value)
+		}
+	}
+}
+
+// resolve returns the render options of an item with overrides of
+// the batch options, validated like generate parameters
+func (o batchOptions) resolve(overrides batchOptions) (renderOptions, *apiError) {
+	query := url.Values{}
+	o.apply(query)
+	overrides.apply(query)
+	return parseRenderOptions(query)
+}
+
+// batchResult is the outcome of one batch item: a base64 image of
+// ContentType, the path it was uploaded to, or an error
+type batchResult struct {
+	Index       int       `json:"index"`
+	Image       []byte    `json:"image,omitempty"`
+	ContentType string    `json:"content_type,omitempty"`
+	Path        string    `json:"path,omitempty"`
+	Error       *apiError `json:"error,omitempty"`
+}
+
+// handleBatch renders many codes in one request. Items are rendered in
+// parallel by as many goroutines as the render pool has workers, so a batch
+// uses the whole pool without flooding its queue. Each item succeeds or fails
+// on its own: an invalid payload or a failed render is reported in its result
+// and the rest of the batch is still rendered.
+func handleBatch(deps handlerDeps) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var body batchRequest
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || len(body.Items) == 0 {
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				writeAPIError(w, r, http.StatusRequestEntityTooLarge, *apiErrorf(errCodeBodyTooLarge,
+					map[string]any{"max_bytes": maxBytesErr.Limit},
+					"Request body exceeds %d bytes", maxBytesErr.Limit))
+				return
+			}
+			writeAPIError(w, r, http.StatusBadRequest, apiError{
+				Code:    errCodeInvalidPayload,
+				Message: `Invalid body. Usage: POST /api/v1/qr/batch with {"items": [{"text": "..."}, ...]}`,
+			})
+			return
+		}
+		if deps.batchMaxItems > 0 && len(body.Items) > deps.batchMaxItems {
+			writeAPIError(w, r, http.StatusRequestEntityTooLarge, *apiErrorf(errCodePayloadTooLarge,
+				map[string]any{"items": len(body.Items), "max_items": deps.batchMaxItems},
+				"Batch has %d items, the maximum is %d", len(body.Items), deps.batchMaxItems))
+			return
+		}
+		pathTemplate, apiErr := batchDelivery(body, deps)
+		if apiErr == nil {
+			_, apiErr = body.Options.resolve(batchOptions{})
+		}
+		if apiErr != nil {
+			writeAPIError(w, r, http.StatusBadRequest, *apiErr)
+			return
+		}
+		setAuditPayload(r.Context(), fmt.Sprintf("batch of %d items", len(body.Items)), map[string]string{"items": fmt.Sprint(len(body.Items)), "deliver": body.Deliver})
+
+		results := renderBatch(r, deps, body.Items, body.Options)
+		if err := r.Context().Err(); err != nil {
+			writeContextError(w, r, err)
+			return
+		}
+		if pathTemplate != nil {
+			uploadBatch(r.Context(), deps, pathTemplate, body.Items, results)
+		}
+
+		lang := negotiateLanguage(r.Header.Get("Accept-Language"))
+		failed := 0
+		for i, result := range results {
+			if result.Error != nil {
+				localized := result.Error.localize(lang)
+				results[i].Error = &localized
+				failed++
+			}
+		}
+		succeeded := len(results) - failed
+		deps.metrics.generatedTotal.Add(float64(succeeded))
+		addLogAttrs(r.Context(), slog.Int("batch_items", len(results)), slog.Int("batch_failed", failed))
+		deps.webhooks.Emit(r.Context(), eventBatchCompleted, batchCompleted{
+			RequestID: requestIDFromContext(r.Context()),
+			Tenant:    tenantFromContext(r.Context()),
+			Items:     len(results),
+			Succeeded: succeeded,
+			Failed:    failed,
+		})
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Language", lang)
+		w.Header().Set("Cache-Control", "no-store")
+		json.NewEncoder(w).Encode(map[string]any{
+			"items":     results,
+			"succeeded": succeeded,
+			"failed":    failed,
+		})
+	}
+}
+
+// renderBatch renders items in parallel with the options, as each item
+// overrides them, returning their results in order. It stops handing out
+// items once the request is done.
+func renderBatch(r *http.Request, deps handlerDeps, items []batchItem, options batchOptions) []batchResult {
+	ctx := r.Context()
+	results := make([]batchResult, len(items))
+	indexes := make(chan int)
+
+	var wg sync.WaitGroup
+	for range min(deps.renderPool.size(), len(items)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				results[i] = renderBatchItem(r, deps, i, items[i], options)
+			}
+		}()
+	}
+
+dispatch:
+	for i := range items {
+		select {
+		case indexes <- i:
+		case <-ctx.Done():
+			break dispatch
+		}
+	}
+	close(indexes)
+	wg.Wait()
+	return results
+}
+
+// renderBatchItem renders one item. Invalid options are the item's error,
+// like invalid text. A panic is recovered and reported as the item's error,
+// since it would otherwise crash the process.
+func renderBatchItem(r *http.Request, deps handlerDeps, index int, item batchItem, options batchOptions) (result batchResult) {
+	ctx := r.Context()
+	result.Index = index
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			err := fmt.Errorf("panic: %v", recovered)
+			stack := debug.Stack()
+			slog.ErrorContext(ctx, "panic recovered in batch item", "error", err, "index", index, "stack", string(stack))
+			deps.reporter.Report(ctx, errorEvent{Err: err, Panic: true, Stack: stack, Status: http.StatusInternalServerError, Request: r})
+			result = batchResult{Index: index, Error: &apiError{Code: errCodeRenderFailed, Message: "Failed to generate QR code"}}
+		}
+	}()
+
+	opts, apiErr := options.resolve(item.Options)
+	if apiErr != nil {
+		result.Error = apiErr
+		return result
+	}
+	result.Image, result.Error = renderTextWith(ctx, deps, item.Text, opts)
+	if result.Error == nil {
+		result.ContentType = opts.contentType()
+	}
+	return result
+}
+
+// renderText validates text like the generate endpoint does and renders it
+// through the image cache and render pool. Failures are returned as API errors
+// with a code: validation errors, errCodeServerBusy when the render queue is
+// full, and errCodeRenderFailed otherwise.
+func renderText(ctx context.Context, deps handlerDeps, text string) ([]byte, *apiError) {
+	return renderTextWith(ctx, deps, text, defaultRenderOptions)
+}
+
+// renderTextWith is renderText with image options
+func renderTextWith(ctx context.Context, deps handlerDeps, text string, opts renderOptions) ([]byte, *apiError) {
+	text, apiErr := checkText(ctx, deps, text)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+
+	render := func() ([]byte, error) {
+		return renderImage(ctx, deps.renderPool, deps.qrGen, text, opts)
+	}
+	var imageBytes []byte
+	var err error
+	if deps.cache != nil {
+		var lookup string
+		imageBytes, lookup, err = deps.cache.getOrRender(ctx, opts.cacheKey(text), render)
+		if err == nil {
+			deps.metrics.cacheLookups.WithLabelValues(lookup).Inc()
+		}
+	} else {
+		imageBytes, err = render()
+	}
+
+	switch {
+	case err == nil:
+		return imageBytes, nil
+	case errors.Is(err, errPayloadTooLarge):
+		return nil, &apiError{
+			Code:    errCodePayloadTooLarge,
+			Message: "Field 'text' is too long to fit in a QR code",
+			Details: map[string]any{"bytes": len(text)},
+		}
+	case errors.Is(err, errTextNotInMode):
+		return nil, apiErrorf(errCodeInvalidPayload, nil, "Field 'text' can't be encoded in %s mode", opts.mode)
+	case errors.Is(err, errTextNotInCharset):
+		return nil, apiErrorf(errCodeInvalidPayload, nil, "Field 'text' has characters that %s lacks", opts.charset)
+	case errors.Is(err, errRenderQueueFull):
+		return nil, &apiError{Code: errCodeServerBusy, Message: "The server is busy, retry later"}
+	}
+	if ctx.Err() == nil {
+		slog.ErrorContext(ctx, "failed to generate QR code", "error", err)
+	}
+	return nil, &apiError{Code: errCodeRenderFailed, Message: "Failed to generate QR code"}
+}
+
+// checkText validates text like the generate endpoint does: it must be
+// present, within the length limit, and pass sanitization and the content
+// policy. It returns the sanitized text.
+func checkText(ctx context.Context, deps handlerDeps, text string) (string, *apiError) {
+	if text == "" {
+		return "", &apiError{Code: errCodeInvalidPayload, Message: "Field 'text' is required"}
+	}
+	if _, apiErr := validatePayload(text, deps.maxPayloadLength); apiErr != nil {
+		return "", apiErr
+	}
+	text, apiErr := sanitizePayload(text, deps.sanitize)
+	if apiErr != nil {
+		return "", apiErr
+	}
+	if apiErr := deps.policy.check(text, apiKeyName(ctx)); apiErr != nil {
+		return "", apiErr
+	}
+	return text, nil
+}
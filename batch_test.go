@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// batchResponse is the decoded body of a batch response
+type batchResponse struct {
+	Items     []batchResult `json:"items"`
+	Succeeded int           `json:"succeeded"`
+	Failed    int           `json:"failed"`
+}
+
+func TestBatch(t *testing.T) {
+	tests := []struct {
+		name       string
+		flagOff    bool
+		body       string
+		wantStatus int
+		// wantCodes is the error code of each item, "" for a rendered image
+		wantCodes []string
+	}{
+		{name: "feature disabled", flagOff: true, body: `{"items": [{"text": "a"}]}`, wantStatus: http.StatusNotFound},
+		{name: "invalid body", body: `{"items":`, wantStatus: http.StatusBadRequest},
+		{name: "no items", body: `{"items": []}`, wantStatus: http.StatusBadRequest},
+		{name: "too many items", body: `{"items": [{"text": "a"}, {"text": "b"}, {"text": "c"}, {"text": "d"}, {"text": "e"}]}`, wantStatus: http.StatusRequestEntityTooLarge},
+		{name: "invalid batch options", body: `{"items": [{"text": "a"}], "options": {"size": 1}}`, wantStatus: http.StatusBadRequest},
+		{name: "all rendered", body: `{"items": [{"text": "a"}, {"text": "b"}, {"text": "a"}]}`, wantStatus: http.StatusOK, wantCodes: []string{"", "", ""}},
+		{
+			name:       "errors isolated per item",
+			body:       `{"items": [{"text": "ok"}, {"text": ""}, {"text": "` + strings.Repeat("x", 21) + `"}, {"text": "javascript:alert(1)"}]}`,
+			wantStatus: http.StatusOK,
+			wantCodes:  []string{"", errCodeInvalidPayload, errCodePayloadTooLarge, errCodePolicyViolation},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deps := newTestDeps()
+			if !tt.flagOff {
+				deps.flags = mustFeatureFlags("batch")
+			}
+			deps.maxPayloadLength = 20
+			deps.batchMaxItems = 4
+			deps.renderPool = newRenderPool(2, 10)
+			rec := httptest.NewRecorder()
+			newHandler(deps).ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v1/qr/batch", strings.NewReader(tt.body)))
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if tt.wantCodes == nil {
+				return
+			}
+
+			var got batchResponse
+			if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+				t.Fatalf("decode response: %v", err)
+			}
+			if len(got.Items) != len(tt.wantCodes) {
+				t.Fatalf("got %d items, want %d", len(got.Items), len(tt.wantCodes))
+			}
+			failed := 0
+			for i, item := range got.Items {
+				if item.Index != i {
+					t.Errorf("item %d has index %d", i, item.Index)
+				}
+				if tt.wantCodes[i] == "" {
+					if item.Error != nil {
+						t.Errorf("item %d error = %+v, want an image", i, item.Error)
+					} else if _, err := png.Decode(bytes.NewReader(item.Image)); err != nil {
+						t.Errorf("item %d image is not a PNG: %v", i, err)
+					}
+					continue
+				}
+				failed++
+				if item.Error == nil || item.Error.Code != tt.wantCodes[i] {
+					t.Errorf("item %d error = %+v, want code %q", i, item.Error, tt.wantCodes[i])
+				}
+			}
+			if got.Failed != failed || got.Succeeded != len(tt.wantCodes)-failed {
+				t.Errorf("succeeded/failed = %d/%d, want %d/%d", got.Succeeded, got.Failed, len(tt.wantCodes)-failed, failed)
+			}
+		})
+	}
+}
+
+func TestRenderBatch_Parallel(t *testing.T) {
+	tests := []struct {
+		name string
+		pool *renderPool
+	}{
+		{name: "bounded pool", pool: newRenderPool(4, 0)},
+		{name: "unbounded", pool: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deps := newTestDeps()
+			deps.renderPool = tt.pool
+			deps.cache = newImageCache(10, 0)
+
+			items := make([]batchItem, 50)
+			for i := range items {
+				items[i].Text = fmt.Sprintf("item-%d", i%20)
+			}
+			results := renderBatch(httptest.NewRequest(http.MethodPost, "/", nil), deps, items, batchOptions{})
+
+			for i, result := range results {
+				if result.Index != i || result.Error != nil || len(result.Image) == 0 {
+					t.Errorf("result %d = index %d, error %+v, %d bytes", i, result.Index, result.Error, len(result.Image))
+				}
+			}
+		})
+	}
+}
+
+func TestBatch_Canceled(t *testing.T) {
+	deps := newTestDeps()
+	deps.flags = mustFeatureFlags("batch")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/qr/batch", strings.NewReader(`{"items": [{"text": "a"}]}`)).WithContext(ctx)
+	newHandler(deps).ServeHTTP(rec, req)
+
+	if rec.Code != statusClientClosedRequest {
+		t.Errorf("status = %d, want %d", rec.Code, statusClientClosedRequest)
+	}
+}
+
+func TestBatch_Overrides(t *testing.T) {
+	deps := newTestDeps()
+	deps.flags = mustFeatureFlags("batch")
+	body := `{"options": {"size": 128, "fg": "#1a73e8"}, "items": [
+		{"text": "a"},
+		{"text": "b", "options": {"size": 300}},
+		{"text": "c", "options": {"format": "svg", "title": "Entrance"}},
+		{"text": "d", "options": {"fg": "#ffffff", "bg": "#ffffff"}},
+		{"text": "e", "options": {"title": "Exit"}}
+	]}`
+	rec := httptest.NewRecorder()
+	newHandler(deps).ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v1/qr/batch", strings.NewReader(body)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+	}
+	var got batchResponse
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Succeeded != 3 || got.Failed != 2 {
+		t.Errorf("succeeded/failed = %d/%d, want 3/2", got.Succeeded, got.Failed)
+	}
+
+	// Items keep the batch options they don't override
+	for i, wantSize := range []int{128, 300} {
+		img, err := png.Decode(bytes.NewReader(got.Items[i].Image))
+		if err != nil {
+			t.Fatalf("item %d: %v", i, err)
+		}
+		if size := img.Bounds().Dx(); size != wantSize || got.Items[i].ContentType != "image/png" {
+			t.Errorf("item %d is a %d pixel %s, want a %d pixel PNG", i, size, got.Items[i].ContentType, wantSize)
+		}
+	}
+	if svg := string(got.Items[2].Image); got.Items[2].ContentType != "image/svg+xml" || !strings.Contains(svg, "<title>Entrance</title>") || !strings.Contains(svg, "#1a73e8") {
+		t.Errorf("item 2 = %s %s, want an SVG titled Entrance", got.Items[2].ContentType, svg)
+	}
+	// Invalid options fail their item only
+	for _, i := range []int{3, 4} {
+		if got.Items[i].Error == nil || got.Items[i].Error.Code != errCodeInvalidParam {
+			t.Errorf("item %d error = %+v, want %s", i, got.Items[i].Error, errCodeInvalidParam)
+		}
+	}
+}
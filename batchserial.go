@@ -0,0 +1,216 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"mime"
+	"net/http"
+	"regexp"
+	"strconv"
+)
+
+// serialManifestName is the name of the manifest in serial batch archives
+const serialManifestName = "manifest.csv"
+
+// maxSerialDigits bounds the zero padding of sequence numbers, so they
+// stay within int64
+const maxSerialDigits = 18
+
+// serialPattern matches the {seq} placeholder of serial patterns, with
+// optional digits such as {seq:00001} that set the zero padding and the
+// default start
+var serialPattern = regexp.MustCompile(`\{seq(?::(\d+))?\}`)
+
+// serialBatchRequest is the body of POST /api/v1/qr/batch/serial
+type serialBatchRequest struct {
+	// Pattern is the text of the codes, with {seq} placeholders replaced
+	// by the sequence number
+	Pattern string `json:"pattern"`
+	Count   int    `json:"count"`
+	// Start defaults to the digits of the placeholder, or 1, and Step to 1
+	Start *int64 `json:"start,omitempty"`
+	Step  int64  `json:"step,omitempty"`
+}
+
+// serialSequence is a validated serial batch: count numbers from start by
+// step, written with width digits into the pattern
+type serialSequence struct {
+	pattern     string
+	count       int
+	start, step int64
+	width       int
+}
+
+// parseSerialBatch validates a serial batch request of at most maxItems
+// codes, if maxItems is positive
+func parseSerialBatch(body serialBatchRequest, maxItems int) (serialSequence, int, *apiError) {
+	matches := serialPattern.FindAllStringSubmatch(body.Pattern, -1)
+	if len(matches) == 0 {
+		return serialSequence{}, http.StatusBadRequest, &apiError{Code: errCodeInvalidParam, Message: "Field 'pattern' must contain a {seq} placeholder, such as ASSET-{seq:00001}"}
+	}
+	seq := serialSequence{pattern: body.Pattern, count: body.Count, start: 1, step: 1}
+	for _, m := range matches {
+		if len(m[1]) > maxSerialDigits {
+			return serialSequence{}, http.StatusBadRequest, apiErrorf(errCodeInvalidParam, nil, "Placeholder {seq} takes at most %d digits", maxSerialDigits)
+		}
+		if m[1] != "" && seq.width == 0 {
+			seq.width = len(m[1])
+			seq.start, _ = strconv.ParseInt(m[1], 10, 64)
+		}
+	}
+	if body.Start != nil {
+		seq.start = *body.Start
+	}
+	if body.Step != 0 {
+		seq.step = body.Step
+	}
+	if body.Count < 1 {
+		return serialSequence{}, http.StatusBadRequest, &apiError{Code: errCodeInvalidParam, Message: "Field 'count' must be at least 1"}
+	}
+	if maxItems > 0 && body.Count > maxItems {
+		return serialSequence{}, http.StatusRequestEntityTooLarge, apiErrorf(errCodePayloadTooLarge,
+			map[string]any{"items": body.Count, "max_items": maxItems},
+			"Batch has %d items, the maximum is %d", body.Count, maxItems)
+	}
+	// Numbers stay within maxSerialDigits digits, checked without
+	// overflowing
+	limit := int64(1e18) - 1
+	steps := int64(body.Count - 1)
+	inRange := seq.start >= 0 && seq.start <= limit
+	if seq.step > 0 {
+		inRange = inRange && steps <= (limit-seq.start)/seq.step
+	} else {
+		inRange = inRange && steps <= seq.start/-seq.step
+	}
+	if !inRange {
+		return serialSequence{}, http.StatusBadRequest, apiErrorf(errCodeInvalidParam, nil,
+			"Sequence numbers must be from 0 to %d", limit)
+	}
+	return seq, http.StatusOK, nil
+}
+
+// number returns the i-th sequence number, zero padded
+func (s serialSequence) number(i int) string {
+	return fmt.Sprintf("%0*d", s.width, s.start+s.step*int64(i))
+}
+
+// items returns the batch items of the sequence, identified by their
+// sequence numbers
+func (s serialSequence) items() []batchItem {
+	items := make([]batchItem, s.count)
+	for i := range items {
+		number := s.number(i)
+		items[i] = batchItem{Text: serialPattern.ReplaceAllLiteralString(s.pattern, number), ID: number}
+	}
+	return items
+}
+
+// writeSerialArchive writes a ZIP archive of the rendered codes, named by
+// sequence number, and a manifest mapping each sequence number to its file
+// and payload. Failed items have no file and their error in the manifest.
+func writeSerialArchive(out *bytes.Buffer, items []batchItem, results []batchResult) error {
+	archive := zip.NewWriter(out)
+	var manifest bytes.Buffer
+	writer := csv.NewWriter(&manifest)
+	writer.Write([]string{"sequence", "filename", "payload", "error_code", "error_message"})
+	for i, result := range results {
+		record := []string{items[i].ID, "", items[i].Text, "", ""}
+		if result.Error != nil {
+			record[3], record[4] = result.Error.Code, result.Error.Message
+		} else {
+			record[1] = items[i].ID + ".png"
+			// PNGs are compressed already
+			f, err := archive.CreateHeader(&zip.FileHeader{Name: record[1], Method: zip.Store})
+			if err != nil {
+				return err
+			}
+			if _, err := f.Write(result.Image); err != nil {
+				return err
+			}
+		}
+		writer.Write(record)
+	}
+	writer.Flush()
+	f, err := archive.CreateHeader(&zip.FileHeader{Name: serialManifestName, Method: zip.Deflate})
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(manifest.Bytes()); err != nil {
+		return err
+	}
+	return archive.Close()
+}
+
+// handleSerialBatch renders count codes from a pattern with a sequence
+// number, such as asset tags, and answers with a ZIP archive of PNGs and
+// a CSV manifest. Items succeed or fail on their own, as in batches.
+func handleSerialBatch(deps handlerDeps) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var body serialBatchRequest
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				writeAPIError(w, r, http.StatusRequestEntityTooLarge, *apiErrorf(errCodeBodyTooLarge,
+					map[string]any{"max_bytes": maxBytesErr.Limit},
+					"Request body exceeds %d bytes", maxBytesErr.Limit))
+				return
+			}
+			writeAPIError(w, r, http.StatusBadRequest, apiError{
+				Code:    errCodeInvalidPayload,
+				Message: `Invalid body. Usage: POST /api/v1/qr/batch/serial with {"pattern": "ASSET-{seq:00001}", "count": 100}`,
+			})
+			return
+		}
+		seq, status, apiErr := parseSerialBatch(body, deps.batchMaxItems)
+		if apiErr != nil {
+			writeAPIError(w, r, status, *apiErr)
+			return
+		}
+		items := seq.items()
+		setAuditPayload(r.Context(), body.Pattern, map[string]string{"items": fmt.Sprint(len(items)), "start": seq.number(0)})
+
+		results := renderBatch(r, deps, items, batchOptions{})
+		if err := r.Context().Err(); err != nil {
+			writeContextError(w, r, err)
+			return
+		}
+		lang := negotiateLanguage(r.Header.Get("Accept-Language"))
+		failed := 0
+		for i, result := range results {
+			if result.Error != nil {
+				localized := result.Error.localize(lang)
+				results[i].Error = &localized
+				failed++
+			}
+		}
+		var out bytes.Buffer
+		if err := writeSerialArchive(&out, items, results); err != nil {
+			slog.ErrorContext(r.Context(), "failed to write serial batch archive", "error", err)
+			setRequestError(r.Context(), err)
+			http.Error(w, "Failed to write archive", http.StatusInternalServerError)
+			return
+		}
+
+		succeeded := len(results) - failed
+		deps.metrics.generatedTotal.Add(float64(succeeded))
+		addLogAttrs(r.Context(), slog.Int("batch_items", len(results)), slog.Int("batch_failed", failed))
+		deps.webhooks.Emit(r.Context(), eventBatchCompleted, batchCompleted{
+			RequestID: requestIDFromContext(r.Context()),
+			Tenant:    tenantFromContext(r.Context()),
+			Items:     len(results),
+			Succeeded: succeeded,
+			Failed:    failed,
+		})
+
+		w.Header().Set("Content-Type", "application/zip")
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": "codes.zip"}))
+		w.Header().Set("Content-Language", lang)
+		w.Header().Set("Cache-Control", "no-store")
+		w.Write(out.Bytes())
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"image/png"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParseSerialBatch(t *testing.T) {
+	start := func(n int64) *int64 { return &n }
+	tests := []struct {
+		name       string
+		body       serialBatchRequest
+		wantStatus int
+		want       []string
+	}{
+		{name: "padded", body: serialBatchRequest{Pattern: "ASSET-{seq:00001}", Count: 3}, wantStatus: http.StatusOK, want: []string{"ASSET-00001", "ASSET-00002", "ASSET-00003"}},
+		{name: "start and step", body: serialBatchRequest{Pattern: "ASSET-{seq:00001}", Count: 3, Start: start(100), Step: 10}, wantStatus: http.StatusOK, want: []string{"ASSET-00100", "ASSET-00110", "ASSET-00120"}},
+		{name: "unpadded from zero", body: serialBatchRequest{Pattern: "https://example.com/a/{seq}?s={seq}", Count: 2, Start: start(0)}, wantStatus: http.StatusOK, want: []string{"https://example.com/a/0?s=0", "https://example.com/a/1?s=1"}},
+		{name: "counting down", body: serialBatchRequest{Pattern: "{seq:003}", Count: 4, Step: -1}, wantStatus: http.StatusOK, want: []string{"003", "002", "001", "000"}},
+		{name: "no placeholder", body: serialBatchRequest{Pattern: "ASSET-1", Count: 1}, wantStatus: http.StatusBadRequest},
+		{name: "too many digits", body: serialBatchRequest{Pattern: "{seq:" + strings.Repeat("0", 19) + "}", Count: 1}, wantStatus: http.StatusBadRequest},
+		{name: "no count", body: serialBatchRequest{Pattern: "{seq}"}, wantStatus: http.StatusBadRequest},
+		{name: "too many", body: serialBatchRequest{Pattern: "{seq}", Count: 11}, wantStatus: http.StatusRequestEntityTooLarge},
+		{name: "below zero", body: serialBatchRequest{Pattern: "{seq:003}", Count: 5, Step: -1}, wantStatus: http.StatusBadRequest},
+		{name: "overflow", body: serialBatchRequest{Pattern: "{seq}", Count: 3, Step: 1 << 62}, wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			seq, status, apiErr := parseSerialBatch(tt.body, 10)
+			if status != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %+v", status, tt.wantStatus, apiErr)
+			}
+			if apiErr != nil {
+				return
+			}
+			var got []string
+			for _, item := range seq.items() {
+				got = append(got, item.Text)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("texts = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSerialBatch(t *testing.T) {
+	deps := newTestDeps()
+	deps.flags = mustFeatureFlags("batch")
+	deps.maxPayloadLength = 20
+	rec := httptest.NewRecorder()
+	body := `{"pattern": "ASSET-{seq:00001}", "count": 3, "start": 9, "step": 1}`
+	newHandler(deps).ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v1/qr/batch/serial", strings.NewReader(body)))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+	}
+	if got := rec.Header().Get("Content-Type"); got != "application/zip" {
+		t.Errorf("Content-Type = %q", got)
+	}
+	archive, err := zip.NewReader(bytes.NewReader(rec.Body.Bytes()), int64(rec.Body.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string][]byte{}
+	for _, f := range archive.File {
+		r, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[f.Name], _ = io.ReadAll(r)
+		r.Close()
+	}
+
+	records, err := csv.NewReader(bytes.NewReader(files[serialManifestName])).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{
+		{"sequence", "filename", "payload", "error_code", "error_message"},
+		{"00009", "00009.png", "ASSET-00009", "", ""},
+		{"00010", "00010.png", "ASSET-00010", "", ""},
+		{"00011", "00011.png", "ASSET-00011", "", ""},
+	}
+	if !slices.EqualFunc(records, want, slices.Equal) {
+		t.Errorf("manifest = %q, want %q", records, want)
+	}
+	for _, record := range want[1:] {
+		if _, err := png.Decode(bytes.NewReader(files[record[1]])); err != nil {
+			t.Errorf("%s: %v", record[1], err)
+		}
+	}
+
+	t.Run("failed items", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		// The payload outgrows the limit from the tenth code on
+		body := `{"pattern": "ASSET-LONGER-ABCDE-{seq}", "count": 2, "start": 9}`
+		newHandler(deps).ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v1/qr/batch/serial", strings.NewReader(body)))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+		}
+		archive, err := zip.NewReader(bytes.NewReader(rec.Body.Bytes()), int64(rec.Body.Len()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, f := range archive.File {
+			names = append(names, f.Name)
+		}
+		if want := []string{"9.png", serialManifestName}; !slices.Equal(names, want) {
+			t.Errorf("files = %q, want %q", names, want)
+		}
+		r, _ := archive.Open(serialManifestName)
+		manifest, _ := io.ReadAll(r)
+		if !strings.Contains(string(manifest), "10,,ASSET-LONGER-ABCDE-10,"+errCodePayloadTooLarge+",") {
+			t.Errorf("manifest lacks the failed item: %s", manifest)
+		}
+	})
+}
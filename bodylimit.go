@@ -0,0 +1,31 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+)
+
+// bodyLimitMiddleware caps request bodies at maxBytes. Requests announcing a
+// larger Content-Length are rejected with 413 up front; bodies without a
+// length are wrapped in http.MaxBytesReader so reads fail once the limit is hit.
+// A non-positive maxBytes disables the limit.
+func bodyLimitMiddleware(maxBytes int64, next http.Handler) http.Handler {
+	if maxBytes <= 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ContentLength > maxBytes {
+			w.Header().Set("Connection", "close")
+			writeAPIError(w, r, http.StatusRequestEntityTooLarge, apiError{
+				Code:    errCodeBodyTooLarge,
+				Message: "Request body exceeds " + strconv.FormatInt(maxBytes, 10) + " bytes",
+				Details: map[string]any{"max_bytes": maxBytes},
+			})
+			return
+		}
+		if r.Body != nil && r.Body != http.NoBody {
+			r.Body = http.MaxBytesReader(w, r.Body, maxBytes)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestBodyLimitMiddleware(t *testing.T) {
+	tests := []struct {
+		name          string
+		maxBytes      int64
+		body          string
+		unknownLength bool
+		wantStatus    int
+		wantReadErr   bool
+	}{
+		{name: "within limit", maxBytes: 10, body: "hello", wantStatus: http.StatusOK},
+		{name: "content-length over limit", maxBytes: 10, body: strings.Repeat("a", 11), wantStatus: http.StatusRequestEntityTooLarge},
+		{name: "streamed body over limit", maxBytes: 10, body: strings.Repeat("a", 11), unknownLength: true, wantStatus: http.StatusOK, wantReadErr: true},
+		{name: "disabled", maxBytes: 0, body: strings.Repeat("a", 100), wantStatus: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var readErr error
+			handler := bodyLimitMiddleware(tt.maxBytes, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				_, readErr = io.ReadAll(r.Body)
+			}))
+
+			req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.body))
+			if tt.unknownLength {
+				req.ContentLength = -1
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			var maxBytesErr *http.MaxBytesError
+			if got := errors.As(readErr, &maxBytesErr); got != tt.wantReadErr {
+				t.Errorf("body read error = %v, want MaxBytesError: %v", readErr, tt.wantReadErr)
+			}
+		})
+	}
+}
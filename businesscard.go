@@ -0,0 +1,258 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	"log/slog"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/skip2/go-qrcode"
+)
+
+// maxCardFieldLength bounds the text fields of business cards in characters
+const maxCardFieldLength = 100
+
+// maxLogoPixels bounds the width and height of business card logos; a
+// logo a centimeter high needs far fewer pixels even at 1200 dpi
+const maxLogoPixels = 2000
+
+// cardMargin is the safe margin of business cards in points, an eighth of
+// an inch that trimming may cut into
+const cardMargin = 0.125 * pointsPerInch
+
+// Font sizes of business cards in points
+const (
+	cardNameFontSize    = 11.0
+	cardDetailFontSize  = 7.5
+	cardContactFontSize = 6.5
+)
+
+// cardSizes are the business card sizes in points: US (3.5 x 2 in) and
+// European (85 x 55 mm)
+var cardSizes = map[string][2]float64{
+	"us": {3.5 * pointsPerInch, 2 * pointsPerInch},
+	"eu": {85 * pointsPerMM, 55 * pointsPerMM},
+}
+
+// businessCard is the body of POST /api/v1/qr/business-card. Logo is a
+// base64 PNG or JPEG image.
+type businessCard struct {
+	Name         string `json:"name"`
+	Title        string `json:"title,omitempty"`
+	Organization string `json:"organization,omitempty"`
+	Phone        string `json:"phone,omitempty"`
+	Email        string `json:"email,omitempty"`
+	URL          string `json:"url,omitempty"`
+	Address      string `json:"address,omitempty"`
+	Logo         []byte `json:"logo,omitempty"`
+	// Size is us (default) or eu, and Format pdf (default) or svg
+	Size   string `json:"size,omitempty"`
+	Format string `json:"format,omitempty"`
+	printOptions
+}
+
+// vcardEscape escapes a vCard property value
+func vcardEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, ",", `\,`, ";", `\;`, "\n", `\n`).Replace(s)
+}
+
+// vCard returns the card as a vCard 3.0, which phones add to contacts when
+// scanned. The last word of the name is taken as the family name.
+func (c businessCard) vCard() string {
+	var b strings.Builder
+	line := func(property, value string) {
+		if value != "" {
+			b.WriteString(property + ":" + value + "\r\n")
+		}
+	}
+	given, family := "", c.Name
+	if i := strings.LastIndexByte(c.Name, ' '); i >= 0 {
+		given, family = c.Name[:i], c.Name[i+1:]
+	}
+	line("BEGIN", "VCARD")
+	line("VERSION", "3.0")
+	line("N", vcardEscape(family)+";"+vcardEscape(given)+";;;")
+	line("FN", vcardEscape(c.Name))
+	line("ORG", vcardEscape(c.Organization))
+	line("TITLE", vcardEscape(c.Title))
+	line("TEL;TYPE=WORK,VOICE", vcardEscape(c.Phone))
+	line("EMAIL;TYPE=INTERNET", vcardEscape(c.Email))
+	line("URL", vcardEscape(c.URL))
+	if c.Address != "" {
+		line("ADR;TYPE=WORK", ";;"+vcardEscape(c.Address)+";;;;")
+	}
+	line("END", "VCARD")
+	return b.String()
+}
+
+// validate checks the fields of the card and decodes its logo, if any
+func (c businessCard) validate() (image.Image, *apiError) {
+	if c.Name == "" {
+		return nil, &apiError{Code: errCodeInvalidPayload, Message: "Field 'name' is required"}
+	}
+	for _, field := range []struct{ name, value string }{
+		{"name", c.Name}, {"title", c.Title}, {"organization", c.Organization}, {"phone", c.Phone},
+		{"email", c.Email}, {"url", c.URL}, {"address", c.Address},
+	} {
+		if !printableText(field.value, maxCardFieldLength) {
+			return nil, apiErrorf(errCodeInvalidParam, nil, "Field '%s' must be at most %d printable characters", field.name, maxCardFieldLength)
+		}
+	}
+	if _, ok := cardSizes[c.Size]; c.Size != "" && !ok {
+		return nil, &apiError{Code: errCodeInvalidParam, Message: "Field 'size' must be us or eu"}
+	}
+	switch c.Format {
+	case "", "pdf", formatSVG:
+	default:
+		return nil, &apiError{Code: errCodeInvalidParam, Message: "Field 'format' must be pdf or svg"}
+	}
+	if apiErr := c.printOptions.validate(c.Format); apiErr != nil {
+		return nil, apiErr
+	}
+	if len(c.Logo) == 0 {
+		return nil, nil
+	}
+	config, kind, err := image.DecodeConfig(bytes.NewReader(c.Logo))
+	if err != nil || (kind != "png" && kind != "jpeg") {
+		return nil, &apiError{Code: errCodeInvalidParam, Message: "Field 'logo' must be a base64 PNG or JPEG image"}
+	}
+	if config.Width > maxLogoPixels || config.Height > maxLogoPixels {
+		return nil, apiErrorf(errCodeInvalidParam, map[string]any{"width": config.Width, "height": config.Height},
+			"Field 'logo' must be at most %d pixels wide and high", maxLogoPixels)
+	}
+	logo, _, err := image.Decode(bytes.NewReader(c.Logo))
+	if err != nil {
+		return nil, &apiError{Code: errCodeInvalidParam, Message: "Field 'logo' must be a base64 PNG or JPEG image"}
+	}
+	return logo, nil
+}
+
+// layoutBusinessCard draws a card of width by height points: the code of
+// the vCard fills the right side within the margin, and the left side holds
+// the logo, name, title, and organization from the top and the contact
+// details from the bottom. Text shrinks to fit the column.
+func layoutBusinessCard(c canvas, width, height float64, card businessCard, bitmap [][]bool, logo image.Image) {
+	side := height - 2*cardMargin
+	c.fillBitmap(bitmap, width-cardMargin-side, height-cardMargin, side/float64(len(bitmap)))
+
+	columnWidth := width - 3*cardMargin - side
+	top := height - cardMargin
+	if logo != nil {
+		bounds := logo.Bounds()
+		aspect := float64(bounds.Dx()) / float64(bounds.Dy())
+		logoHeight := height / 4
+		logoWidth := logoHeight * aspect
+		if logoWidth > columnWidth {
+			logoWidth, logoHeight = columnWidth, columnWidth/aspect
+		}
+		c.drawImage(logo, cardMargin, top-logoHeight, logoWidth, logoHeight)
+		top -= logoHeight + cardDetailFontSize/2
+	}
+	for _, line := range []struct {
+		text string
+		size float64
+	}{{card.Name, cardNameFontSize}, {card.Title, cardDetailFontSize}, {card.Organization, cardDetailFontSize}} {
+		if line.text == "" {
+			continue
+		}
+		text, size := fitText(line.text, columnWidth, line.size)
+		top -= line.size
+		c.drawText(text, cardMargin, top, size)
+		top -= line.size / 4
+	}
+
+	// Contact lines stack up from the bottom margin, clear of descenders
+	bottom := cardMargin + cardContactFontSize/4
+	for _, line := range []string{card.Address, card.URL, card.Email, card.Phone} {
+		if line == "" {
+			continue
+		}
+		text, size := fitText(line, columnWidth, cardContactFontSize)
+		c.drawText(text, cardMargin, bottom, size)
+		bottom += 1.3 * cardContactFontSize
+	}
+}
+
+// handleBusinessCard composes a business card with a QR code of its vCard
+// from JSON fields, so people can make their own: a print-ready PDF page
+// of the card size, or an SVG with format=svg
+func handleBusinessCard(deps handlerDeps) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var card businessCard
+		if err := json.NewDecoder(r.Body).Decode(&card); err != nil {
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				writeAPIError(w, r, http.StatusRequestEntityTooLarge, *apiErrorf(errCodeBodyTooLarge,
+					map[string]any{"max_bytes": maxBytesErr.Limit},
+					"Request body exceeds %d bytes", maxBytesErr.Limit))
+				return
+			}
+			writeAPIError(w, r, http.StatusBadRequest, apiError{
+				Code:    errCodeInvalidPayload,
+				Message: `Invalid body. Usage: POST /api/v1/qr/business-card with {"name": "...", "title": "...", "email": "..."}`,
+			})
+			return
+		}
+		logo, apiErr := card.validate()
+		if apiErr != nil {
+			writeAPIError(w, r, http.StatusBadRequest, *apiErr)
+			return
+		}
+		vcard := card.vCard()
+		setAuditPayload(r.Context(), vcard, map[string]string{"logo": fmt.Sprint(logo != nil)})
+
+		// The policy sees the vCard as text, so the link it carries is
+		// checked on its own as well
+		text, apiErr := checkText(r.Context(), deps, vcard)
+		if apiErr == nil && card.URL != "" {
+			apiErr = deps.policy.check(card.URL, apiKeyName(r.Context()))
+		}
+		if apiErr != nil {
+			writeAPIError(w, r, itemErrorStatus(apiErr.Code), *apiErr)
+			return
+		}
+		q, err := qrcode.New(text, recoveryLevels[defaultRenderOptions.level])
+		if err != nil {
+			writeAPIError(w, r, http.StatusRequestEntityTooLarge, apiError{
+				Code:    errCodePayloadTooLarge,
+				Message: "The vCard of the card is too long to fit in a QR code",
+				Details: map[string]any{"bytes": len(text)},
+			})
+			return
+		}
+
+		size := cardSizes["us"]
+		if card.Size != "" {
+			size = cardSizes[card.Size]
+		}
+		var out bytes.Buffer
+		contentType, filename := "application/pdf", "business-card.pdf"
+		if card.Format == formatSVG {
+			contentType, filename = "image/svg+xml", "business-card.svg"
+			svg := &svgCanvas{width: size[0], height: size[1]}
+			layoutBusinessCard(svg, size[0], size[1], card, q.Bitmap(), logo)
+			err = svg.writeTo(&out, "Business card of "+card.Name)
+		} else {
+			doc := card.document()
+			layoutBusinessCard(doc.addPage(size[0], size[1]), size[0], size[1], card, q.Bitmap(), logo)
+			err = doc.writeTo(&out)
+		}
+		if err != nil {
+			slog.ErrorContext(r.Context(), "failed to compose business card", "error", err)
+			setRequestError(r.Context(), err)
+			http.Error(w, "Failed to compose business card", http.StatusInternalServerError)
+			return
+		}
+		deps.metrics.generatedTotal.Inc()
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("inline", map[string]string{"filename": filename}))
+		w.Header().Set("Cache-Control", "no-store")
+		w.Write(out.Bytes())
+	}
+}
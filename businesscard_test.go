@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestBusinessCard_VCard(t *testing.T) {
+	card := businessCard{
+		Name:         "Ada King Lovelace",
+		Title:        "Analyst",
+		Organization: "Engines, Ltd; London",
+		Email:        "ada@example.com",
+		Address:      "12 St James's Square",
+	}
+	want := "BEGIN:VCARD\r\nVERSION:3.0\r\nN:Lovelace;Ada King;;;\r\nFN:Ada King Lovelace\r\n" +
+		"ORG:Engines\\, Ltd\\; London\r\nTITLE:Analyst\r\nEMAIL;TYPE=INTERNET:ada@example.com\r\n" +
+		"ADR;TYPE=WORK:;;12 St James's Square;;;;\r\nEND:VCARD\r\n"
+	if got := card.vCard(); got != want {
+		t.Errorf("vCard() = %q, want %q", got, want)
+	}
+}
+
+func TestLayoutBusinessCard(t *testing.T) {
+	size := cardSizes["us"]
+	svg := &svgCanvas{width: size[0], height: size[1]}
+	card := businessCard{Name: "Ada Lovelace", Title: "Analyst", Phone: "+44 20 7946 0000", Email: "ada@example.com"}
+	logo := image.NewRGBA(image.Rect(0, 0, 40, 10))
+	layoutBusinessCard(svg, size[0], size[1], card, [][]bool{{true}}, logo)
+
+	for _, want := range []string{
+		// The code fills the card height within the margins, at the right
+		`<path transform="translate(117 9) scale(126)"`,
+		// The logo is a quarter of the card high, within the text column
+		`<image x="9" y="9" width="99" height="24.75"`,
+		`y="48.5" font-family="Helvetica, Arial, sans-serif" font-size="11">Ada Lovelace</text>`,
+		`font-size="7.5">Analyst</text>`,
+		`y="133.375" font-family="Helvetica, Arial, sans-serif" font-size="6.5">ada@example.com</text>`,
+	} {
+		if !strings.Contains(svg.body.String(), want) {
+			t.Errorf("layout lacks %q: %s", want, svg.body.String())
+		}
+	}
+}
+
+func TestBusinessCard(t *testing.T) {
+	var logo bytes.Buffer
+	png.Encode(&logo, image.NewGray(image.Rect(0, 0, 8, 4)))
+	var bigLogo bytes.Buffer
+	png.Encode(&bigLogo, image.NewGray(image.Rect(0, 0, maxLogoPixels+1, 1)))
+	encode := base64.StdEncoding.EncodeToString
+
+	tests := []struct {
+		name            string
+		body            string
+		wantStatus      int
+		wantContentType string
+		want            string
+	}{
+		{name: "pdf", body: `{"name": "Ada Lovelace", "title": "Analyst", "email": "ada@example.com"}`, wantStatus: http.StatusOK, wantContentType: "application/pdf", want: "/MediaBox [0 0 252 144]"},
+		{name: "european pdf with logo", body: `{"name": "Ada Lovelace", "size": "eu", "logo": "` + encode(logo.Bytes()) + `"}`, wantStatus: http.StatusOK, wantContentType: "application/pdf", want: "/Subtype /Image /Width 8 /Height 4"},
+		{name: "pdf with bleed", body: `{"name": "Ada Lovelace", "bleed_mm": 3}`, wantStatus: http.StatusOK, wantContentType: "application/pdf", want: "/MediaBox [0 0 269.008 161.008] /BleedBox [0 0 269.008 161.008] /TrimBox [8.504 8.504 260.504 152.504]"},
+		{name: "svg", body: `{"name": "Ada Lovelace", "format": "svg"}`, wantStatus: http.StatusOK, wantContentType: "image/svg+xml", want: `aria-label="Business card of Ada Lovelace"`},
+		{name: "invalid body", body: `{"name":`, wantStatus: http.StatusBadRequest},
+		{name: "missing name", body: `{"title": "Analyst"}`, wantStatus: http.StatusBadRequest},
+		{name: "field too long", body: `{"name": "Ada", "title": "` + strings.Repeat("x", maxCardFieldLength+1) + `"}`, wantStatus: http.StatusBadRequest},
+		{name: "control character", body: `{"name": "Ada\nLovelace"}`, wantStatus: http.StatusBadRequest},
+		{name: "unknown size", body: `{"name": "Ada", "size": "a4"}`, wantStatus: http.StatusBadRequest},
+		{name: "unknown format", body: `{"name": "Ada", "format": "png"}`, wantStatus: http.StatusBadRequest},
+		{name: "bleed of svg", body: `{"name": "Ada", "format": "svg", "bleed_mm": 3}`, wantStatus: http.StatusBadRequest},
+		{name: "bleed too large", body: `{"name": "Ada", "bleed_mm": 25}`, wantStatus: http.StatusBadRequest},
+		{name: "logo not an image", body: `{"name": "Ada", "logo": "` + encode([]byte("GIF89a")) + `"}`, wantStatus: http.StatusBadRequest},
+		{name: "logo too large", body: `{"name": "Ada", "logo": "` + encode(bigLogo.Bytes()) + `"}`, wantStatus: http.StatusBadRequest},
+		{name: "denied url", body: `{"name": "Ada", "url": "javascript:alert(1)"}`, wantStatus: http.StatusUnprocessableEntity},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			newTestHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v1/qr/business-card", strings.NewReader(tt.body)))
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if rec.Code != http.StatusOK {
+				return
+			}
+			if got := rec.Header().Get("Content-Type"); got != tt.wantContentType {
+				t.Errorf("Content-Type = %q, want %q", got, tt.wantContentType)
+			}
+			if tt.wantContentType == "application/pdf" && len(pdfStreams(t, rec.Body.Bytes())) != 1 {
+				t.Error("want a single page")
+			}
+			if !strings.Contains(rec.Body.String(), tt.want) {
+				t.Errorf("body lacks %q", tt.want)
+			}
+		})
+	}
+}
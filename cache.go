@@ -0,0 +1,178 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// imageCache is an in-memory LRU cache of rendered images. Entries expire
+// after ttl (zero keeps them until evicted). A nil cache is disabled.
+type imageCache struct {
+	maxEntries int
+	ttl        time.Duration
+	now        func() time.Time
+	// remote is a cache shared by all replicas behind the local one; nil disables it
+	remote *redisCache
+	flight singleflight.Group
+
+	mu      sync.Mutex
+	order   *list.List // front is most recently used
+	entries map[string]*list.Element
+}
+
+// cacheEntry is a cached image and its expiry
+type cacheEntry struct {
+	key     string
+	data    []byte
+	expires time.Time
+}
+
+// newImageCache creates a cache holding up to maxEntries images. It returns
+// nil (caching disabled) when maxEntries is not positive.
+func newImageCache(maxEntries int, ttl time.Duration) *imageCache {
+	if maxEntries <= 0 {
+		return nil
+	}
+	return &imageCache{
+		maxEntries: maxEntries,
+		ttl:        ttl,
+		now:        time.Now,
+		order:      list.New(),
+		entries:    make(map[string]*list.Element),
+	}
+}
+
+// imageCacheKey hashes the encoded payload with the rendering options
+func imageCacheKey(payload string, options ...string) string {
+	sum := sha256.Sum256([]byte(strings.Join(options, "\x00") + "\x00" + payload))
+	return hex.EncodeToString(sum[:])
+}
+
+// Results of an image cache lookup, used as metric label
+const (
+	cacheHit       = "hit"
+	cacheMiss      = "miss"
+	cacheCoalesced = "coalesced"
+)
+
+// getOrRender returns the cached image for key, or renders and caches it.
+// Concurrent misses for the same key share a single render. The result is
+// cacheHit (local or shared cache), cacheMiss (rendered by this request), or
+// cacheCoalesced (rendered by a concurrent request).
+func (c *imageCache) getOrRender(ctx context.Context, key string, render func() ([]byte, error)) ([]byte, string, error) {
+	for {
+		if data, ok := c.Get(key); ok {
+			return data, cacheHit, nil
+		}
+
+		leader := false
+		value, err, _ := c.flight.Do(key, func() (any, error) {
+			leader = true
+			var data []byte
+			var hit bool
+			var err error
+			if c.remote != nil {
+				data, hit, err = c.remote.getOrRender(ctx, key, render)
+			} else {
+				data, err = render()
+			}
+			if err != nil {
+				return nil, err
+			}
+			c.Add(key, data)
+			return imageFlight{data: data, hit: hit}, nil
+		})
+		// A render that failed because the request leading it was cancelled or
+		// timed out says nothing about this request: try again
+		if !leader && ctx.Err() == nil && (errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)) {
+			continue
+		}
+		if err != nil {
+			return nil, "", err
+		}
+
+		result := value.(imageFlight)
+		switch {
+		case result.hit:
+			return result.data, cacheHit, nil
+		case !leader:
+			return result.data, cacheCoalesced, nil
+		}
+		return result.data, cacheMiss, nil
+	}
+}
+
+// imageFlight is the result of a coalesced lookup
+type imageFlight struct {
+	data []byte
+	hit  bool
+}
+
+// Get returns the cached image for key. Callers must not modify it.
+func (c *imageCache) Get(key string) ([]byte, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := elem.Value.(*cacheEntry)
+	if !entry.expires.IsZero() && !c.now().Before(entry.expires) {
+		c.remove(elem)
+		return nil, false
+	}
+	c.order.MoveToFront(elem)
+	return entry.data, true
+}
+
+// Add stores an image, evicting the least recently used one when full
+func (c *imageCache) Add(key string, data []byte) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var expires time.Time
+	if c.ttl > 0 {
+		expires = c.now().Add(c.ttl)
+	}
+	if elem, ok := c.entries[key]; ok {
+		entry := elem.Value.(*cacheEntry)
+		entry.data, entry.expires = data, expires
+		c.order.MoveToFront(elem)
+		return
+	}
+	c.entries[key] = c.order.PushFront(&cacheEntry{key: key, data: data, expires: expires})
+	for c.order.Len() > c.maxEntries {
+		c.remove(c.order.Back())
+	}
+}
+
+// Len returns the number of cached images
+func (c *imageCache) Len() int {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+// remove drops an entry; c.mu must be held
+func (c *imageCache) remove(elem *list.Element) {
+	c.order.Remove(elem)
+	delete(c.entries, elem.Value.(*cacheEntry).key)
+}
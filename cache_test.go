@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestImageCache(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	tests := []struct {
+		name     string
+		ttl      time.Duration
+		steps    func(c *imageCache)
+		wantHits []string
+		wantMiss []string
+	}{
+		{
+			name: "evicts least recently used",
+			steps: func(c *imageCache) {
+				c.Add("a", []byte("a"))
+				c.Add("b", []byte("b"))
+				c.Get("a")
+				c.Add("c", []byte("c"))
+			},
+			wantHits: []string{"a", "c"},
+			wantMiss: []string{"b"},
+		},
+		{
+			name: "re-adding refreshes an entry",
+			steps: func(c *imageCache) {
+				c.Add("a", []byte("a"))
+				c.Add("b", []byte("b"))
+				c.Add("a", []byte("a"))
+				c.Add("c", []byte("c"))
+			},
+			wantHits: []string{"a", "c"},
+			wantMiss: []string{"b"},
+		},
+		{
+			name:     "expired entries are dropped",
+			ttl:      time.Minute,
+			steps:    func(c *imageCache) { c.Add("a", []byte("a")); now = now.Add(time.Minute) },
+			wantMiss: []string{"a"},
+		},
+		{
+			name:     "fresh entries are served",
+			ttl:      time.Minute,
+			steps:    func(c *imageCache) { c.Add("a", []byte("a")); now = now.Add(59 * time.Second) },
+			wantHits: []string{"a"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newImageCache(2, tt.ttl)
+			c.now = func() time.Time { return now }
+			tt.steps(c)
+
+			for _, key := range tt.wantHits {
+				if data, ok := c.Get(key); !ok || string(data) != key {
+					t.Errorf("Get(%q) = %q, %v, want hit", key, data, ok)
+				}
+			}
+			for _, key := range tt.wantMiss {
+				if _, ok := c.Get(key); ok {
+					t.Errorf("Get(%q) hit, want miss", key)
+				}
+			}
+		})
+	}
+}
+
+func TestImageCache_Disabled(t *testing.T) {
+	c := newImageCache(0, time.Hour)
+	if c != nil {
+		t.Fatalf("newImageCache(0) = %v, want nil", c)
+	}
+	c.Add("a", []byte("a"))
+	if _, ok := c.Get("a"); ok || c.Len() != 0 {
+		t.Error("disabled cache stored an entry")
+	}
+}
+
+func TestGenerate_Cache(t *testing.T) {
+	deps := newTestDeps()
+	deps.cache = newImageCache(10, time.Hour)
+	handler := newHandler(deps)
+
+	var bodies []string
+	for range 3 {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v1/qr/generate?text=https://example.com/promo", nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+		}
+		bodies = append(bodies, rec.Body.String())
+	}
+
+	if hits := testutil.ToFloat64(deps.metrics.cacheLookups.WithLabelValues("hit")); hits != 2 {
+		t.Errorf("cache hits = %v, want 2", hits)
+	}
+	if misses := testutil.ToFloat64(deps.metrics.cacheLookups.WithLabelValues("miss")); misses != 1 {
+		t.Errorf("cache misses = %v, want 1", misses)
+	}
+	if bodies[0] != bodies[2] {
+		t.Error("cached image differs from the rendered one")
+	}
+}
+
+func TestImageCache_CoalescesConcurrentRenders(t *testing.T) {
+	c := newImageCache(10, time.Hour)
+	release := make(chan struct{})
+	var renders atomic.Int32
+	render := func() ([]byte, error) {
+		renders.Add(1)
+		<-release
+		return []byte("png"), nil
+	}
+
+	var wg sync.WaitGroup
+	results := make(chan string, 10)
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			data, result, err := c.getOrRender(context.Background(), "k", render)
+			if err != nil || string(data) != "png" {
+				t.Errorf("getOrRender() = %q, %v", data, err)
+			}
+			results <- result
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	close(results)
+
+	if n := renders.Load(); n != 1 {
+		t.Errorf("rendered %d times, want 1", n)
+	}
+	counts := map[string]int{}
+	for result := range results {
+		counts[result]++
+	}
+	if counts[cacheMiss] != 1 || counts[cacheMiss]+counts[cacheCoalesced]+counts[cacheHit] != 10 {
+		t.Errorf("results = %v, want one miss and the rest coalesced or hits", counts)
+	}
+}
+
+func TestImageCache_CancelledLeaderDoesNotFailFollowers(t *testing.T) {
+	c := newImageCache(10, time.Hour)
+	leaderCtx, cancel := context.WithCancel(context.Background())
+	started := make(chan struct{})
+	var renders atomic.Int32
+
+	leaderDone := make(chan error)
+	go func() {
+		_, _, err := c.getOrRender(leaderCtx, "k", func() ([]byte, error) {
+			renders.Add(1)
+			close(started)
+			<-leaderCtx.Done()
+			return nil, leaderCtx.Err()
+		})
+		leaderDone <- err
+	}()
+	<-started
+
+	followerDone := make(chan []byte)
+	go func() {
+		data, _, err := c.getOrRender(context.Background(), "k", func() ([]byte, error) {
+			renders.Add(1)
+			return []byte("png"), nil
+		})
+		if err != nil {
+			t.Errorf("follower error = %v", err)
+		}
+		followerDone <- data
+	}()
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+
+	if err := <-leaderDone; !errors.Is(err, context.Canceled) {
+		t.Errorf("leader error = %v, want %v", err, context.Canceled)
+	}
+	if data := <-followerDone; string(data) != "png" {
+		t.Errorf("follower got %q, want %q", data, "png")
+	}
+}
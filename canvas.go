@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"html"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io"
+	"math"
+	"unicode"
+)
+
+// canvas is a page that print layouts draw on in points, with y growing up
+// from the bottom left corner as in PDF. *pdfPage and *svgCanvas draw it.
+type canvas interface {
+	// fillBitmap fills the dark modules of bitmap as squares of module
+	// points, with the top left corner of the bitmap at x, y
+	fillBitmap(bitmap [][]bool, x, y, module float64)
+	// drawText sets s in Helvetica at size points with its baseline
+	// starting at x, y
+	drawText(s string, x, y, size float64)
+	// drawImage draws img stretched to width by height points with its
+	// bottom left corner at x, y
+	drawImage(img image.Image, x, y, width, height float64)
+	// fillRect fills a width by height rectangle with its bottom left
+	// corner at x, y
+	fillRect(x, y, width, height float64)
+	// setColor fills the shapes and text drawn next with c; the default
+	// is black
+	setColor(c color.RGBA)
+	// textWidth is the width in points of s set at size points
+	textWidth(s string, size float64) float64
+}
+
+// svgCanvas draws a layout as an SVG of width by height points on white.
+// Text falls back from Helvetica to Arial, which has the same metrics.
+type svgCanvas struct {
+	width, height float64
+	body          bytes.Buffer
+}
+
+func (c *svgCanvas) fillBitmap(bitmap [][]bool, x, y, module float64) {
+	fmt.Fprintf(&c.body, `<path transform="translate(%s %s) scale(%s)" d="`, pdfNumber(x), pdfNumber(c.height-y), pdfNumber(module))
+	for row, modules := range bitmap {
+		for col := 0; col < len(modules); {
+			if !modules[col] {
+				col++
+				continue
+			}
+			start := col
+			for col < len(modules) && modules[col] {
+				col++
+			}
+			fmt.Fprintf(&c.body, "M%d %dh%dv1h-%dz", start, row, col-start, col-start)
+		}
+	}
+	c.body.WriteString(`"/>`)
+}
+
+func (c *svgCanvas) drawText(s string, x, y, size float64) {
+	fmt.Fprintf(&c.body, `<text x="%s" y="%s" font-family="Helvetica, Arial, sans-serif" font-size="%s">%s</text>`,
+		pdfNumber(x), pdfNumber(c.height-y), pdfNumber(size), html.EscapeString(s))
+}
+
+func (c *svgCanvas) drawImage(img image.Image, x, y, width, height float64) {
+	var encoded bytes.Buffer
+	png.Encode(&encoded, img)
+	fmt.Fprintf(&c.body, `<image x="%s" y="%s" width="%s" height="%s" preserveAspectRatio="none" href="data:image/png;base64,%s"/>`,
+		pdfNumber(x), pdfNumber(c.height-y-height), pdfNumber(width), pdfNumber(height), base64.StdEncoding.EncodeToString(encoded.Bytes()))
+}
+
+func (c *svgCanvas) fillRect(x, y, width, height float64) {
+	fmt.Fprintf(&c.body, `<rect x="%s" y="%s" width="%s" height="%s"/>`,
+		pdfNumber(x), pdfNumber(c.height-y-height), pdfNumber(width), pdfNumber(height))
+}
+
+// setColor starts a group of the color within the black group of writeTo
+func (c *svgCanvas) setColor(fill color.RGBA) {
+	fmt.Fprintf(&c.body, `</g><g fill="%s" shape-rendering="crispEdges">`, hexColor(fill))
+}
+
+func (c *svgCanvas) textWidth(s string, size float64) float64 {
+	return pdfTextWidth(s, size)
+}
+
+// writeTo writes the SVG, sized in points and labeled for screen readers
+func (c *svgCanvas) writeTo(w io.Writer, label string) error {
+	b := bufio.NewWriter(w)
+	fmt.Fprintf(b, `<svg xmlns="http://www.w3.org/2000/svg" width="%spt" height="%spt" viewBox="0 0 %s %s" role="img" aria-label="%s">`,
+		pdfNumber(c.width), pdfNumber(c.height), pdfNumber(c.width), pdfNumber(c.height), html.EscapeString(label))
+	fmt.Fprintf(b, `<rect width="%s" height="%s" fill="#ffffff"/><g fill="#000000" shape-rendering="crispEdges">`, pdfNumber(c.width), pdfNumber(c.height))
+	b.Write(c.body.Bytes())
+	b.WriteString(`</g></svg>`)
+	return b.Flush()
+}
+
+// pngCanvas draws a layout as an RGBA image of width by height points at
+// scale pixels per point, on white. Text is set in the block capitals of
+// barcodeGlyphs rather than Helvetica: cap height is 0.7 and the advance
+// 0.6 of the font size, and lowercase letters are set as capitals.
+type pngCanvas struct {
+	img    *image.RGBA
+	height float64
+	scale  float64
+	fill   color.RGBA
+}
+
+// newPNGCanvas returns a white canvas of width by height points at dpi
+// pixels per inch
+func newPNGCanvas(width, height float64, dpi int) *pngCanvas {
+	scale := float64(dpi) / pointsPerInch
+	img := image.NewRGBA(image.Rect(0, 0, int(math.Round(width*scale)), int(math.Round(height*scale))))
+	draw.Draw(img, img.Bounds(), image.White, image.Point{}, draw.Src)
+	return &pngCanvas{img: img, height: height, scale: scale, fill: color.RGBA{A: 0xff}}
+}
+
+// pixels returns the pixel rectangle covering width by height points with
+// the bottom left corner at x, y. Edges are rounded, so adjacent shapes
+// meet without gaps.
+func (c *pngCanvas) pixels(x, y, width, height float64) image.Rectangle {
+	round := func(v float64) int { return int(math.Round(v * c.scale)) }
+	return image.Rect(round(x), round(c.height-y-height), round(x+width), round(c.height-y))
+}
+
+func (c *pngCanvas) fillRect(x, y, width, height float64) {
+	draw.Draw(c.img, c.pixels(x, y, width, height), &image.Uniform{C: c.fill}, image.Point{}, draw.Src)
+}
+
+func (c *pngCanvas) fillBitmap(bitmap [][]bool, x, y, module float64) {
+	for row, modules := range bitmap {
+		for col := 0; col < len(modules); {
+			if !modules[col] {
+				col++
+				continue
+			}
+			start := col
+			for col < len(modules) && modules[col] {
+				col++
+			}
+			c.fillRect(x+float64(start)*module, y-float64(row+1)*module, float64(col-start)*module, module)
+		}
+	}
+}
+
+func (c *pngCanvas) drawText(s string, x, y, size float64) {
+	unit := size / 10
+	for _, r := range s {
+		glyph, ok := barcodeGlyphs[byte(unicode.ToUpper(r))]
+		if r > unicode.MaxASCII || !ok {
+			glyph = barcodeGlyphs['?']
+		}
+		for row, line := range glyph {
+			for col, bit := range line {
+				if bit == '1' {
+					c.fillRect(x+float64(col)*unit, y+float64(6-row)*unit, unit, unit)
+				}
+			}
+		}
+		x += 6 * unit
+	}
+}
+
+func (c *pngCanvas) textWidth(s string, size float64) float64 {
+	n := len([]rune(s))
+	if n == 0 {
+		return 0
+	}
+	return (6*float64(n) - 1) * size / 10
+}
+
+// drawImage scales img to the pixels by nearest neighbor and composites
+// it over the canvas
+func (c *pngCanvas) drawImage(img image.Image, x, y, width, height float64) {
+	dst := c.pixels(x, y, width, height)
+	if dst.Empty() {
+		return
+	}
+	src := img.Bounds()
+	for py := dst.Min.Y; py < dst.Max.Y; py++ {
+		sy := src.Min.Y + (py-dst.Min.Y)*src.Dy()/dst.Dy()
+		for px := dst.Min.X; px < dst.Max.X; px++ {
+			sx := src.Min.X + (px-dst.Min.X)*src.Dx()/dst.Dx()
+			draw.Draw(c.img, image.Rect(px, py, px+1, py+1), image.NewUniform(img.At(sx, sy)), image.Point{}, draw.Over)
+		}
+	}
+}
+
+func (c *pngCanvas) setColor(fill color.RGBA) {
+	c.fill = fill
+}
+
+// writeTo encodes the canvas as PNG
+func (c *pngCanvas) writeTo(w io.Writer) error {
+	return png.Encode(w, c.img)
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+)
+
+func TestSVGCanvas(t *testing.T) {
+	c := &svgCanvas{width: 100, height: 50}
+	c.fillBitmap([][]bool{{true, true, false}, {false, true, true}}, 10, 40, 2)
+	c.drawText("a<b", 5, 6, 7)
+	c.drawImage(image.NewRGBA(image.Rect(0, 0, 1, 1)), 10, 20, 30, 20)
+	c.setColor(color.RGBA{R: 0x1a, G: 0x73, B: 0xe8, A: 0xff})
+	c.fillRect(0, 40, 100, 10)
+
+	var out bytes.Buffer
+	if err := c.writeTo(&out, "Card & co"); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`<svg xmlns="http://www.w3.org/2000/svg" width="100pt" height="50pt" viewBox="0 0 100 50" role="img" aria-label="Card &amp; co">`,
+		// y is flipped: the top of the bitmap at 40 points up is 10 down
+		`<path transform="translate(10 10) scale(2)" d="M0 0h2v1h-2zM1 1h2v1h-2z"/>`,
+		`<text x="5" y="44" font-family="Helvetica, Arial, sans-serif" font-size="7">a&lt;b</text>`,
+		`<image x="10" y="10" width="30" height="20" preserveAspectRatio="none" href="data:image/png;base64,`,
+		`</g><g fill="#1a73e8" shape-rendering="crispEdges"><rect x="0" y="0" width="100" height="10"/></g></svg>`,
+	} {
+		if !bytes.Contains(out.Bytes(), []byte(want)) {
+			t.Errorf("SVG lacks %q: %s", want, out.String())
+		}
+	}
+}
+
+func TestPNGCanvas(t *testing.T) {
+	// 144 dpi is two pixels per point
+	c := newPNGCanvas(20, 10, 144)
+	if got := c.img.Bounds(); got != image.Rect(0, 0, 40, 20) {
+		t.Fatalf("bounds = %v", got)
+	}
+	red := color.RGBA{R: 0xff, A: 0xff}
+	c.setColor(red)
+	c.fillRect(0, 5, 20, 5)
+	c.setColor(color.RGBA{A: 0xff})
+	c.fillBitmap([][]bool{{true, false}}, 2, 4, 1)
+	c.drawText("i", 10, 0, 10)
+
+	black := color.RGBA{A: 0xff}
+	for _, tt := range []struct {
+		x, y int
+		want color.RGBA
+	}{
+		// The rectangle fills the top half, flipped from PDF coordinates
+		{0, 0, red}, {39, 9, red}, {0, 10, color.RGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff}},
+		// The module fills a point below y = 4, two by two pixels
+		{4, 12, black}, {5, 13, black},
+		// The top of the capital I at unit 1 of the glyph, 7 units of a
+		// point above the baseline
+		{2*10 + 2, 20 - 14, black},
+	} {
+		if got := c.img.RGBAAt(tt.x, tt.y); got != tt.want {
+			t.Errorf("pixel %d,%d = %v, want %v", tt.x, tt.y, got, tt.want)
+		}
+	}
+	if got := c.textWidth("abc", 10); got != 17 {
+		t.Errorf("textWidth = %v, want 17", got)
+	}
+
+	var out bytes.Buffer
+	if err := c.writeTo(&out); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := png.Decode(&out); err != nil {
+		t.Errorf("not a PNG: %v", err)
+	}
+}
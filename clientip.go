@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strings"
+)
+
+// trustedProxies are the networks of proxies (e.g. the Ingress controller)
+// whose client address header is believed
+type trustedProxies struct {
+	prefixes []netip.Prefix
+	// header is the header the proxies put client addresses in:
+	// X-Forwarded-For, X-Real-IP, or Forwarded (RFC 7239)
+	header string
+}
+
+// clientIPHeaders are the supported client address headers
+var clientIPHeaders = []string{"X-Forwarded-For", "X-Real-IP", "Forwarded"}
+
+// parseTrustedProxies parses a comma-separated list of CIDRs or single IPs
+// and the header they set, X-Forwarded-For when empty. Only one header is
+// read: the proxies set it, while the others could come from the client.
+func parseTrustedProxies(spec, header string) (*trustedProxies, error) {
+	prefixes, err := parsePrefixes(spec)
+	if err != nil {
+		return nil, fmt.Errorf("invalid trusted proxy %w", err)
+	}
+	if header == "" {
+		header = "X-Forwarded-For"
+	}
+	i := slices.IndexFunc(clientIPHeaders, func(h string) bool { return strings.EqualFold(h, header) })
+	if i < 0 {
+		return nil, fmt.Errorf("unsupported client IP header %q, use one of %s", header, strings.Join(clientIPHeaders, ", "))
+	}
+	return &trustedProxies{prefixes: prefixes, header: clientIPHeaders[i]}, nil
+}
+
+// parsePrefixes parses a comma-separated list of CIDRs or single IPs
+func parsePrefixes(spec string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, item := range splitList(spec) {
+		if !strings.Contains(item, "/") {
+			addr, err := netip.ParseAddr(item)
+			if err != nil {
+				return nil, fmt.Errorf("%q: %w", item, err)
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(item)
+		if err != nil {
+			return nil, fmt.Errorf("%q: %w", item, err)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+// contains reports whether addr belongs to a trusted proxy. A nil
+// trustedProxies trusts no one.
+func (t *trustedProxies) contains(addr netip.Addr) bool {
+	if t == nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range t.prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// fromProxy reports whether the peer of the request is a trusted proxy
+func (t *trustedProxies) fromProxy(r *http.Request) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	peer, err := netip.ParseAddr(host)
+	return err == nil && t.contains(peer)
+}
+
+// clientIP returns the IP of the client that sent the request. The peer
+// address is used unless it is a trusted proxy, in which case the hops of the
+// proxies' header are walked from the right, skipping trusted ones, so
+// clients cannot spoof their address by prepending entries.
+func clientIP(r *http.Request, trusted *trustedProxies) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	peer, err := netip.ParseAddr(host)
+	if err != nil || !trusted.contains(peer) {
+		return host
+	}
+
+	hops := trusted.hops(r.Header)
+	client := peer.Unmap()
+	for i := len(hops) - 1; i >= 0; i-- {
+		addr, ok := parseHop(hops[i])
+		if !ok {
+			break
+		}
+		client = addr
+		if !trusted.contains(client) {
+			break
+		}
+	}
+	return client.String()
+}
+
+// hops returns the client addresses of the proxies' header, nearest last
+func (t *trustedProxies) hops(h http.Header) []string {
+	switch t.header {
+	case "X-Real-IP":
+		return []string{h.Get("X-Real-IP")}
+	case "Forwarded":
+		var hops []string
+		for _, element := range strings.Split(strings.Join(h.Values("Forwarded"), ","), ",") {
+			// An element without for= hides the client, which ends the walk
+			hop := "unknown"
+			for _, pair := range strings.Split(element, ";") {
+				if name, value, ok := strings.Cut(strings.TrimSpace(pair), "="); ok && strings.EqualFold(name, "for") {
+					hop = value
+				}
+			}
+			hops = append(hops, hop)
+		}
+		return hops
+	default:
+		return strings.Split(strings.Join(h.Values("X-Forwarded-For"), ","), ",")
+	}
+}
+
+// parseHop parses a forwarded address: an IP, optionally quoted, with a port,
+// or in brackets as Forwarded writes IPv6 addresses. Obfuscated identifiers
+// such as "unknown" or "_hidden" aren't addresses.
+func parseHop(hop string) (netip.Addr, bool) {
+	hop = strings.Trim(strings.TrimSpace(hop), `"`)
+	if addr, err := netip.ParseAddr(hop); err == nil {
+		return addr.Unmap(), true
+	}
+	if addrPort, err := netip.ParseAddrPort(hop); err == nil {
+		return addrPort.Addr().Unmap(), true
+	}
+	if inner, ok := strings.CutPrefix(hop, "["); ok {
+		if addr, err := netip.ParseAddr(strings.TrimSuffix(inner, "]")); err == nil && strings.HasSuffix(inner, "]") {
+			return addr.Unmap(), true
+		}
+	}
+	return netip.Addr{}, false
+}
+
+// clientIPKey is the context key for the resolved client IP
+type clientIPKey struct{}
+
+// clientIPMiddleware resolves the client IP once per request and stores it in
+// the request context for logs and the audit log
+func clientIPMiddleware(trusted *trustedProxies, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), clientIPKey{}, clientIP(r, trusted))))
+	})
+}
+
+// clientIPFromContext returns the client IP stored in ctx, or "" if there is none
+func clientIPFromContext(ctx context.Context) string {
+	ip, _ := ctx.Value(clientIPKey{}).(string)
+	return ip
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseTrustedProxies(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    string
+		header  string
+		wantLen int
+		wantErr bool
+	}{
+		{name: "empty", spec: "", wantLen: 0},
+		{name: "cidrs and ips", spec: "10.0.0.0/8, 192.168.1.10, fd00::/8", wantLen: 3},
+		{name: "invalid ip", spec: "10.0.0.300", wantErr: true},
+		{name: "invalid cidr", spec: "10.0.0.0/40", wantErr: true},
+		{name: "known header", spec: "10.0.0.0/8", header: "x-real-ip", wantLen: 1},
+		{name: "unknown header", spec: "10.0.0.0/8", header: "X-Client-IP", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			proxies, err := parseTrustedProxies(tt.spec, tt.header)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("parseTrustedProxies() expected error but got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseTrustedProxies() unexpected error: %v", err)
+			}
+			if len(proxies.prefixes) != tt.wantLen {
+				t.Errorf("parsed %d proxies, want %d", len(proxies.prefixes), tt.wantLen)
+			}
+		})
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	proxies, err := parseTrustedProxies("10.0.0.0/8", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		forwarded  string
+		want       string
+	}{
+		{name: "direct client", remoteAddr: "203.0.113.7:1234", want: "203.0.113.7"},
+		{name: "untrusted peer ignores header", remoteAddr: "203.0.113.7:1234", forwarded: "198.51.100.1", want: "203.0.113.7"},
+		{name: "trusted proxy", remoteAddr: "10.0.0.5:1234", forwarded: "198.51.100.1", want: "198.51.100.1"},
+		{name: "chain of trusted proxies", remoteAddr: "10.0.0.5:1234", forwarded: "198.51.100.1, 10.1.2.3", want: "198.51.100.1"},
+		{name: "spoofed leftmost entry", remoteAddr: "10.0.0.5:1234", forwarded: "1.2.3.4, 198.51.100.1", want: "198.51.100.1"},
+		{name: "trusted proxy without header", remoteAddr: "10.0.0.5:1234", want: "10.0.0.5"},
+		{name: "garbage entry stops walk", remoteAddr: "10.0.0.5:1234", forwarded: "198.51.100.1, bogus", want: "10.0.0.5"},
+		{name: "ipv6 client", remoteAddr: "10.0.0.5:1234", forwarded: "2001:db8::1", want: "2001:db8::1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			if tt.forwarded != "" {
+				req.Header.Set("X-Forwarded-For", tt.forwarded)
+			}
+
+			if got := clientIP(req, proxies); got != tt.want {
+				t.Errorf("clientIP() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClientIP_Headers(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		values map[string]string
+		want   string
+	}{
+		{name: "X-Real-IP", header: "X-Real-IP", values: map[string]string{"X-Real-IP": "198.51.100.1"}, want: "198.51.100.1"},
+		{name: "X-Real-IP ignores X-Forwarded-For", header: "X-Real-IP", values: map[string]string{"X-Forwarded-For": "198.51.100.1"}, want: "10.0.0.5"},
+		{name: "X-Forwarded-For ignores X-Real-IP", values: map[string]string{"X-Real-IP": "198.51.100.1"}, want: "10.0.0.5"},
+		{name: "X-Forwarded-For with port", values: map[string]string{"X-Forwarded-For": "198.51.100.1:4711"}, want: "198.51.100.1"},
+		{name: "Forwarded", header: "Forwarded", values: map[string]string{"Forwarded": "for=198.51.100.1;proto=https"}, want: "198.51.100.1"},
+		{name: "Forwarded chain", header: "Forwarded", values: map[string]string{"Forwarded": "for=1.2.3.4, for=198.51.100.1;by=10.0.0.5, for=10.1.2.3"}, want: "198.51.100.1"},
+		{name: "Forwarded IPv6", header: "Forwarded", values: map[string]string{"Forwarded": `For="[2001:db8:cafe::17]:4711"`}, want: "2001:db8:cafe::17"},
+		{name: "Forwarded obfuscated", header: "Forwarded", values: map[string]string{"Forwarded": "for=198.51.100.1, for=_hidden"}, want: "10.0.0.5"},
+		{name: "Forwarded ignores X-Forwarded-For", header: "Forwarded", values: map[string]string{"X-Forwarded-For": "198.51.100.1"}, want: "10.0.0.5"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			proxies, err := parseTrustedProxies("10.0.0.0/8", tt.header)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = "10.0.0.5:1234"
+			for name, value := range tt.values {
+				req.Header.Set(name, value)
+			}
+
+			if got := clientIP(req, proxies); got != tt.want {
+				t.Errorf("clientIP() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParseCode39(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		check   bool
+		want    string
+		wantErr bool
+	}{
+		{name: "plain", value: "INV-0042", want: "INV-0042"},
+		{name: "check character", value: "CODE39", check: true, want: "CODE39W"},
+		{name: "check character of digits", value: "12345", check: true, want: "12345F"},
+		{name: "symbols", value: "A.B $/+%", want: "A.B $/+%"},
+		{name: "lowercase", value: "inv-0042", wantErr: true},
+		{name: "asterisk", value: "*INV*", wantErr: true},
+		{name: "too long", value: strings.Repeat("A", 41), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, apiErr := parseCode39(tt.value, tt.check)
+			if (apiErr != nil) != tt.wantErr {
+				t.Fatalf("error = %v, want error %v", apiErr, tt.wantErr)
+			}
+			if apiErr == nil && b.value() != tt.want {
+				t.Errorf("value = %q, want %q", b.value(), tt.want)
+			}
+		})
+	}
+}
+
+func TestCode39Patterns(t *testing.T) {
+	for i, pattern := range code39Patterns {
+		wide := 0
+		for element := range 9 {
+			wide += int(pattern >> element & 1)
+		}
+		if wide != 3 || pattern >= 1<<9 {
+			t.Errorf("pattern %d (%09b) has %d wide elements, want 3 of 9", i, pattern, wide)
+		}
+		if slices.Index(code39Patterns[:], pattern) != i {
+			t.Errorf("pattern %d (%09b) is not unique", i, pattern)
+		}
+	}
+}
+
+func TestCode39_Layout(t *testing.T) {
+	b, apiErr := parseCode39("INV-42", true)
+	if apiErr != nil {
+		t.Fatal(apiErr)
+	}
+	width, height, rects := b.layout(false)
+	// 9 characters of 15 modules with 8 gaps between them
+	if want := 10 + 9*15 + 8 + 10; width != want || height != barcodeBarHeight {
+		t.Fatalf("size = %dx%d, want %dx%d", width, height, want, barcodeBarHeight)
+	}
+
+	// The bars and the spaces between them read back as the characters
+	// between start and stop characters
+	modules := make([]bool, width)
+	for _, r := range rects {
+		for x := r.x; x < r.x+r.w; x++ {
+			modules[x] = true
+		}
+	}
+	if slices.Contains(modules[:10], true) || slices.Contains(modules[width-10:], true) {
+		t.Fatal("quiet zones have bars")
+	}
+	var decoded []byte
+	for x := 10; x < width-10; x += 16 {
+		var pattern uint16
+		i := x
+		for element := range 9 {
+			start := i
+			for i < width && modules[i] == (element%2 == 0) {
+				i++
+			}
+			pattern <<= 1
+			if i-start == code39Wide {
+				pattern |= 1
+			}
+		}
+		n := slices.Index(code39Patterns[:], pattern)
+		if n < 0 {
+			t.Fatalf("invalid pattern %09b at module %d", pattern, x)
+		}
+		decoded = append(decoded, (code39Characters + "*")[n])
+	}
+	if got, want := string(decoded), "*"+b.value()+"*"; got != want {
+		t.Errorf("bars decode to %q, want %q", got, want)
+	}
+
+	if _, withText, _ := b.layout(true); withText != barcodeBarHeight+barcodeDigitsSpace {
+		t.Errorf("height with text = %d, want %d", withText, barcodeBarHeight+barcodeDigitsSpace)
+	}
+}
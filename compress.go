@@ -0,0 +1,178 @@
+package main
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// compressibleTypes are the text-based response types worth compressing.
+// PNGs are already deflate-compressed and are served as is.
+var compressibleTypes = []string{"application/json", "image/svg+xml", "text/"}
+
+var (
+	gzipWriters  = sync.Pool{New: func() any { return gzip.NewWriter(io.Discard) }}
+	flateWriters = sync.Pool{New: func() any { w, _ := flate.NewWriter(io.Discard, flate.DefaultCompression); return w }}
+)
+
+// compressMiddleware compresses text-based responses (JSON, SVG, HTML) with
+// gzip or deflate as negotiated by Accept-Encoding. Responses that declare a
+// Content-Length below minBytes are sent uncompressed. A negative minBytes
+// disables compression.
+func compressMiddleware(minBytes int, next http.Handler) http.Handler {
+	if minBytes < 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		encoding := ""
+		if r.Method != http.MethodHead {
+			encoding = negotiateEncoding(r.Header.Get("Accept-Encoding"))
+		}
+		cw := &compressWriter{ResponseWriter: w, encoding: encoding, minBytes: minBytes}
+		defer cw.Close()
+		next.ServeHTTP(cw, r)
+	})
+}
+
+// negotiateEncoding picks gzip or deflate from an Accept-Encoding header,
+// honoring q-values. It returns "" when neither is acceptable.
+func negotiateEncoding(header string) string {
+	best, bestQ := "", 0.0
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		q := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		if name == "*" {
+			name = "gzip"
+		}
+		// Prefer gzip on ties: it is what every client and CDN handles best
+		if (name == "gzip" || name == "deflate") && q > 0 && (q > bestQ || q == bestQ && name == "gzip") {
+			best, bestQ = name, q
+		}
+	}
+	return best
+}
+
+// compressWriter decides on the first write whether the response is
+// compressed, based on its status and headers
+type compressWriter struct {
+	http.ResponseWriter
+	encoding string
+	minBytes int
+
+	decided bool
+	encoder interface {
+		io.WriteCloser
+		Flush() error
+	}
+}
+
+func (cw *compressWriter) WriteHeader(status int) {
+	if !cw.decided {
+		cw.decide(status)
+	}
+	cw.ResponseWriter.WriteHeader(status)
+}
+
+func (cw *compressWriter) Write(b []byte) (int, error) {
+	if !cw.decided {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if cw.encoder != nil {
+		return cw.encoder.Write(b)
+	}
+	return cw.ResponseWriter.Write(b)
+}
+
+// decide sets up the encoder if the response should be compressed
+func (cw *compressWriter) decide(status int) {
+	cw.decided = true
+	h := cw.Header()
+	if !isCompressible(h.Get("Content-Type")) {
+		return
+	}
+	h.Add("Vary", "Accept-Encoding")
+
+	if cw.encoding == "" || h.Get("Content-Encoding") != "" ||
+		status < http.StatusOK || status == http.StatusNoContent || status == http.StatusPartialContent || status == http.StatusNotModified {
+		return
+	}
+	if length, err := strconv.Atoi(h.Get("Content-Length")); err == nil && length < cw.minBytes {
+		return
+	}
+
+	h.Set("Content-Encoding", cw.encoding)
+	h.Del("Content-Length")
+	// The compressed body is a different representation, so a strong ETag
+	// would be wrong, and so would digests of the uncompressed one
+	if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		h.Set("ETag", "W/"+etag)
+	}
+	h.Del("Repr-Digest")
+	h.Del("Digest")
+	h.Del("Trailer")
+	if cw.encoding == "gzip" {
+		gz := gzipWriters.Get().(*gzip.Writer)
+		gz.Reset(cw.ResponseWriter)
+		cw.encoder = gz
+	} else {
+		fw := flateWriters.Get().(*flate.Writer)
+		fw.Reset(cw.ResponseWriter)
+		cw.encoder = fw
+	}
+}
+
+// Close flushes the compressed stream and returns the encoder to its pool
+func (cw *compressWriter) Close() error {
+	if cw.encoder == nil {
+		return nil
+	}
+	err := cw.encoder.Close()
+	switch enc := cw.encoder.(type) {
+	case *gzip.Writer:
+		gzipWriters.Put(enc)
+	case *flate.Writer:
+		flateWriters.Put(enc)
+	}
+	cw.encoder = nil
+	return err
+}
+
+// Flush sends buffered compressed data to the client, for streaming responses
+func (cw *compressWriter) Flush() {
+	if cw.encoder != nil {
+		cw.encoder.Flush()
+	}
+	http.NewResponseController(cw.ResponseWriter).Flush()
+}
+
+// Unwrap exposes the underlying ResponseWriter to http.ResponseController
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// isCompressible reports whether a Content-Type is text-based
+func isCompressible(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	for _, prefix := range compressibleTypes {
+		if strings.HasPrefix(mediaType, prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	tests := map[string]string{
+		"":                        "",
+		"gzip":                    "gzip",
+		"deflate":                 "deflate",
+		"gzip, deflate, br":       "gzip",
+		"deflate, gzip;q=0.5":     "deflate",
+		"gzip;q=0, deflate;q=0.1": "deflate",
+		"br":                      "",
+		"*":                       "gzip",
+		"identity":                "",
+	}
+	for header, want := range tests {
+		if got := negotiateEncoding(header); got != want {
+			t.Errorf("negotiateEncoding(%q) = %q, want %q", header, got, want)
+		}
+	}
+}
+
+func TestCompressMiddleware(t *testing.T) {
+	body := strings.Repeat(`{"text":"https://example.com"}`, 100)
+	tests := []struct {
+		name           string
+		acceptEncoding string
+		contentType    string
+		contentLength  int
+		etag           string
+		wantEncoding   string
+		wantVary       bool
+		wantETag       string
+	}{
+		{name: "json gzip", acceptEncoding: "gzip", contentType: "application/json", wantEncoding: "gzip", wantVary: true},
+		{name: "svg deflate", acceptEncoding: "deflate", contentType: "image/svg+xml", wantEncoding: "deflate", wantVary: true},
+		{name: "html with charset", acceptEncoding: "gzip", contentType: "text/html; charset=utf-8", wantEncoding: "gzip", wantVary: true},
+		{name: "png untouched", acceptEncoding: "gzip", contentType: "image/png"},
+		{name: "client without support", contentType: "application/json", wantVary: true},
+		{name: "small known length", acceptEncoding: "gzip", contentType: "application/json", contentLength: 100, wantVary: true},
+		{name: "strong etag weakened", acceptEncoding: "gzip", contentType: "image/svg+xml", etag: `"abc"`, wantEncoding: "gzip", wantVary: true, wantETag: `W/"abc"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want := body
+			if tt.contentLength > 0 {
+				want = body[:tt.contentLength]
+			}
+			handler := compressMiddleware(1024, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", tt.contentType)
+				if tt.contentLength > 0 {
+					w.Header().Set("Content-Length", strconv.Itoa(tt.contentLength))
+				}
+				if tt.etag != "" {
+					w.Header().Set("ETag", tt.etag)
+				}
+				io.WriteString(w, want)
+			}))
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.acceptEncoding != "" {
+				req.Header.Set("Accept-Encoding", tt.acceptEncoding)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if got := rec.Header().Get("Content-Encoding"); got != tt.wantEncoding {
+				t.Fatalf("Content-Encoding = %q, want %q", got, tt.wantEncoding)
+			}
+			if got := rec.Header().Get("Vary") == "Accept-Encoding"; got != tt.wantVary {
+				t.Errorf("Vary: Accept-Encoding set = %v, want %v", got, tt.wantVary)
+			}
+			if tt.wantETag != "" && rec.Header().Get("ETag") != tt.wantETag {
+				t.Errorf("ETag = %q, want %q", rec.Header().Get("ETag"), tt.wantETag)
+			}
+
+			var reader io.Reader = rec.Body
+			switch tt.wantEncoding {
+			case "gzip":
+				gz, err := gzip.NewReader(rec.Body)
+				if err != nil {
+					t.Fatal(err)
+				}
+				reader = gz
+			case "deflate":
+				reader = flate.NewReader(rec.Body)
+			}
+			if tt.wantEncoding != "" && rec.Body.Len() >= len(body) {
+				t.Errorf("compressed body is %d bytes, not smaller than %d", rec.Body.Len(), len(body))
+			}
+			decoded, err := io.ReadAll(reader)
+			if err != nil || string(decoded) != want {
+				t.Errorf("decoded body mismatch (error %v)", err)
+			}
+		})
+	}
+}
+
+func TestCompressMiddleware_Disabled(t *testing.T) {
+	handler := compressMiddleware(-1, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, "{}")
+	}))
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Header().Get("Content-Encoding") != "" {
+		t.Error("response compressed with compression disabled")
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/netutil"
+)
+
+// connLimits bounds the connections of the public listener
+type connLimits struct {
+	// maxConns caps open connections; further connections wait in the kernel
+	// accept backlog instead of using up file descriptors. Zero is unlimited.
+	maxConns int
+	// keepAlive enables HTTP keep-alive (connection reuse)
+	keepAlive bool
+	// tcpKeepAlive is the TCP keep-alive probe period; negative disables probes
+	tcpKeepAlive time.Duration
+	// maxRequestsPerConn closes HTTP/1.1 connections after this many requests
+	// so long-lived clients rebalance across replicas. Zero is unlimited.
+	maxRequestsPerConn int
+}
+
+// listen opens the TCP listener for addr with the connection limits applied
+func (l connLimits) listen(ctx context.Context, addr string) (net.Listener, error) {
+	lc := net.ListenConfig{KeepAlive: l.tcpKeepAlive}
+	ln, err := lc.Listen(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if l.maxConns > 0 {
+		ln = netutil.LimitListener(ln, l.maxConns)
+	}
+	return ln, nil
+}
+
+// connRequestsKey is the context key of a connection's request counter
+type connRequestsKey struct{}
+
+// apply configures keep-alive and the per-connection request limit on server,
+// and tracks open connections in m
+func (l connLimits) apply(server *http.Server, m *metrics) {
+	server.SetKeepAlivesEnabled(l.keepAlive)
+	server.ConnState = func(_ net.Conn, state http.ConnState) {
+		switch state {
+		case http.StateNew:
+			m.openConns.Inc()
+		case http.StateClosed, http.StateHijacked:
+			m.openConns.Dec()
+		}
+	}
+	if l.maxRequestsPerConn <= 0 {
+		return
+	}
+
+	server.ConnContext = func(ctx context.Context, _ net.Conn) context.Context {
+		return context.WithValue(ctx, connRequestsKey{}, new(atomic.Int64))
+	}
+	next := server.Handler
+	server.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if count, ok := r.Context().Value(connRequestsKey{}).(*atomic.Int64); ok && count.Add(1) >= int64(l.maxRequestsPerConn) {
+			// The server closes the connection after this response
+			w.Header().Set("Connection", "close")
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// startServer serves an empty handler with limits applied on a local port
+func startServer(t *testing.T, limits connLimits) (string, *metrics) {
+	t.Helper()
+	m := newMetrics()
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})}
+	limits.apply(server, m)
+	ln, err := limits.listen(context.Background(), "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve(ln)
+	t.Cleanup(func() { server.Close() })
+	return ln.Addr().String(), m
+}
+
+// roundTrip sends a GET over conn and returns the response
+func roundTrip(t *testing.T, conn net.Conn, reader *bufio.Reader) *http.Response {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodGet, "http://"+conn.RemoteAddr().String()+"/", nil)
+	if err := req.Write(conn); err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	resp, err := http.ReadResponse(reader, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp
+}
+
+func TestConnLimits_MaxRequestsPerConn(t *testing.T) {
+	tests := []struct {
+		name      string
+		limits    connLimits
+		wantClose []bool
+	}{
+		{name: "closed after the limit", limits: connLimits{keepAlive: true, maxRequestsPerConn: 2}, wantClose: []bool{false, true}},
+		{name: "unlimited", limits: connLimits{keepAlive: true}, wantClose: []bool{false, false, false}},
+		{name: "keep-alive disabled", limits: connLimits{keepAlive: false}, wantClose: []bool{true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addr, _ := startServer(t, tt.limits)
+			conn, err := net.Dial("tcp", addr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			reader := bufio.NewReader(conn)
+
+			for i, wantClose := range tt.wantClose {
+				if resp := roundTrip(t, conn, reader); resp.Close != wantClose {
+					t.Errorf("request %d: Connection close = %v, want %v", i+1, resp.Close, wantClose)
+				}
+			}
+		})
+	}
+}
+
+func TestConnLimits_MaxConns(t *testing.T) {
+	addr, m := startServer(t, connLimits{keepAlive: true, maxConns: 1})
+
+	first, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roundTrip(t, first, bufio.NewReader(first))
+	if open := testutil.ToFloat64(m.openConns); open != 1 {
+		t.Errorf("open connections = %v, want 1", open)
+	}
+
+	// The second connection is accepted by the kernel but not served while the first is open
+	second, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.Close()
+	req, _ := http.NewRequest(http.MethodGet, "http://"+addr+"/", nil)
+	req.Write(second)
+	second.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	reader := bufio.NewReader(second)
+	if _, err := http.ReadResponse(reader, req); err == nil {
+		t.Fatal("second connection was served beyond the limit")
+	}
+
+	first.Close()
+	second.SetReadDeadline(time.Now().Add(2 * time.Second))
+	resp, err := http.ReadResponse(reader, req)
+	if err != nil {
+		t.Fatalf("second connection not served after the first closed: %v", err)
+	}
+	resp.Body.Close()
+}
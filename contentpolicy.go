@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// errCodePolicyViolation is returned when content is rejected by the content policy
+const errCodePolicyViolation = "policy_violation"
+
+// defaultDeniedSchemes are blocked when no policy file is configured: they run
+// code or embed content when opened from a scanned code
+var defaultDeniedSchemes = []string{"javascript", "vbscript", "data", "file"}
+
+// uriScheme matches the scheme of a URI (RFC 3986)
+var uriScheme = regexp.MustCompile(`^([a-zA-Z][a-zA-Z0-9+.-]*):`)
+
+// policyRules restricts which URLs may be encoded. Domain rules match the
+// domain itself and all of its subdomains. Non-URL text is never restricted.
+type policyRules struct {
+	AllowSchemes []string `json:"allow_schemes,omitempty"`
+	DenySchemes  []string `json:"deny_schemes,omitempty"`
+	AllowDomains []string `json:"allow_domains,omitempty"`
+	DenyDomains  []string `json:"deny_domains,omitempty"`
+}
+
+// contentPolicy holds the deployment-wide rules plus per-API-key rules
+type contentPolicy struct {
+	Default policyRules            `json:"default"`
+	Keys    map[string]policyRules `json:"keys,omitempty"`
+}
+
+// loadContentPolicy reads a JSON policy file (e.g. a mounted ConfigMap). Without
+// a file, only the default dangerous schemes are denied.
+func loadContentPolicy(path string) (*contentPolicy, error) {
+	if path == "" {
+		return &contentPolicy{Default: policyRules{DenySchemes: defaultDeniedSchemes}}, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read content policy: %w", err)
+	}
+	var policy contentPolicy
+	if err := json.Unmarshal(data, &policy); err != nil {
+		return nil, fmt.Errorf("failed to parse content policy: %w", err)
+	}
+	return &policy, nil
+}
+
+// rulesFor merges the rules of apiKey into the defaults: deny lists add up,
+// while a key's allow lists replace the default ones
+func (p *contentPolicy) rulesFor(apiKey string) policyRules {
+	rules := p.Default
+	keyRules, ok := p.Keys[apiKey]
+	if !ok {
+		return rules
+	}
+	rules.DenySchemes = slices.Concat(rules.DenySchemes, keyRules.DenySchemes)
+	rules.DenyDomains = slices.Concat(rules.DenyDomains, keyRules.DenyDomains)
+	if len(keyRules.AllowSchemes) > 0 {
+		rules.AllowSchemes = keyRules.AllowSchemes
+	}
+	if len(keyRules.AllowDomains) > 0 {
+		rules.AllowDomains = keyRules.AllowDomains
+	}
+	return rules
+}
+
+// check returns a policy-violation error when text is a URL the rules for
+// apiKey (empty for unauthenticated or non-API-key callers) do not allow.
+// A nil policy allows everything.
+func (p *contentPolicy) check(text, apiKey string) *apiError {
+	if p == nil {
+		return nil
+	}
+	// Browsers ignore leading whitespace and control characters before a scheme
+	trimmed := strings.TrimLeftFunc(text, func(r rune) bool { return r <= ' ' })
+	match := uriScheme.FindStringSubmatch(trimmed)
+	if match == nil {
+		return nil
+	}
+	scheme := strings.ToLower(match[1])
+	rules := p.rulesFor(apiKey)
+
+	if containsFold(rules.DenySchemes, scheme) || (len(rules.AllowSchemes) > 0 && !containsFold(rules.AllowSchemes, scheme)) {
+		return policyViolation(fmt.Sprintf("URL scheme %q is not allowed", scheme), "scheme", scheme)
+	}
+
+	if len(rules.DenyDomains) == 0 && len(rules.AllowDomains) == 0 {
+		return nil
+	}
+	parsed, err := url.Parse(trimmed)
+	if err != nil || parsed.Hostname() == "" {
+		if len(rules.AllowDomains) > 0 {
+			return policyViolation("URL has no allowed domain", "domain", "")
+		}
+		return nil
+	}
+	host := strings.ToLower(strings.TrimSuffix(parsed.Hostname(), "."))
+	if matchesDomain(rules.DenyDomains, host) || (len(rules.AllowDomains) > 0 && !matchesDomain(rules.AllowDomains, host)) {
+		return policyViolation(fmt.Sprintf("Domain %q is not allowed", host), "domain", host)
+	}
+	return nil
+}
+
+// policyViolation builds the API error for a rejected URL
+func policyViolation(message, rule, value string) *apiError {
+	return &apiError{
+		Code:    errCodePolicyViolation,
+		Message: message,
+		Details: map[string]any{"rule": rule, "value": value},
+	}
+}
+
+// containsFold reports whether list contains value, ignoring case
+func containsFold(list []string, value string) bool {
+	return slices.ContainsFunc(list, func(item string) bool { return strings.EqualFold(item, value) })
+}
+
+// matchesDomain reports whether host is one of domains or a subdomain of one
+func matchesDomain(domains []string, host string) bool {
+	for _, domain := range domains {
+		domain = strings.ToLower(strings.TrimPrefix(domain, "*."))
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestContentPolicy_Check(t *testing.T) {
+	policy := &contentPolicy{
+		Default: policyRules{
+			DenySchemes: defaultDeniedSchemes,
+			DenyDomains: []string{"competitor.com"},
+		},
+		Keys: map[string]policyRules{
+			"partner": {AllowSchemes: []string{"https"}, AllowDomains: []string{"partner.example"}},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		text     string
+		apiKey   string
+		wantRule string
+	}{
+		{name: "plain text", text: "hello world"},
+		{name: "https url", text: "https://example.com/page"},
+		{name: "javascript", text: "javascript:alert(1)", wantRule: "scheme"},
+		{name: "javascript mixed case and whitespace", text: " \tJavaScript:alert(1)", wantRule: "scheme"},
+		{name: "data url", text: "data:text/html,<script>", wantRule: "scheme"},
+		{name: "denied domain", text: "https://competitor.com/offer", wantRule: "domain"},
+		{name: "denied subdomain", text: "https://www.Competitor.com./offer", wantRule: "domain"},
+		{name: "similar domain allowed", text: "https://notcompetitor.com"},
+		{name: "mailto without host", text: "mailto:someone@example.com"},
+		{name: "key allowed domain", text: "https://shop.partner.example", apiKey: "partner"},
+		{name: "key other domain", text: "https://example.com", apiKey: "partner", wantRule: "domain"},
+		{name: "key scheme allowlist", text: "http://partner.example", apiKey: "partner", wantRule: "scheme"},
+		{name: "key inherits default denies", text: "javascript:alert(1)", apiKey: "partner", wantRule: "scheme"},
+		{name: "key allowlist without host", text: "tel:+123456", apiKey: "partner", wantRule: "scheme"},
+		{name: "key plain text", text: "just text", apiKey: "partner"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			apiErr := policy.check(tt.text, tt.apiKey)
+			if tt.wantRule == "" {
+				if apiErr != nil {
+					t.Errorf("check(%q) = %+v, want allowed", tt.text, apiErr)
+				}
+				return
+			}
+			if apiErr == nil || apiErr.Code != errCodePolicyViolation || apiErr.Details["rule"] != tt.wantRule {
+				t.Errorf("check(%q) = %+v, want %s violation", tt.text, apiErr, tt.wantRule)
+			}
+		})
+	}
+}
+
+func TestLoadContentPolicy(t *testing.T) {
+	dir := t.TempDir()
+	valid := filepath.Join(dir, "policy.json")
+	if err := os.WriteFile(valid, []byte(`{"default": {"deny_domains": ["competitor.com"]}, "keys": {"ci": {"deny_schemes": ["http"]}}}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	invalid := filepath.Join(dir, "invalid.json")
+	if err := os.WriteFile(invalid, []byte(`{`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		path    string
+		wantErr bool
+	}{
+		{name: "builtin defaults", path: ""},
+		{name: "valid file", path: valid},
+		{name: "invalid json", path: invalid, wantErr: true},
+		{name: "missing file", path: filepath.Join(dir, "missing.json"), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := loadContentPolicy(tt.path)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("loadContentPolicy() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestGenerate_PolicyViolation(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/qr/generate?text="+url.QueryEscape("javascript:alert(1)"), nil)
+	rec := httptest.NewRecorder()
+	newTestHandler().ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusUnprocessableEntity)
+	}
+}
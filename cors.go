@@ -0,0 +1,106 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// corsPolicy configures cross-origin access for browser clients such as the SPA
+type corsPolicy struct {
+	origins          []string
+	allowAll         bool
+	methods          string
+	headers          string
+	exposeHeaders    string
+	maxAge           string
+	allowCredentials bool
+}
+
+// newCORSPolicy builds a policy from comma-separated lists. Origins may be
+// exact ("https://app.example.com"), "*", or a subdomain wildcard
+// ("https://*.example.com"). It returns nil (CORS disabled) without origins.
+func newCORSPolicy(origins, methods, headers string, maxAge time.Duration, allowCredentials bool) *corsPolicy {
+	list := splitList(origins)
+	if len(list) == 0 {
+		return nil
+	}
+	return &corsPolicy{
+		origins:          list,
+		allowAll:         slices.Contains(list, "*"),
+		methods:          strings.Join(splitList(methods), ", "),
+		headers:          strings.Join(splitList(headers), ", "),
+		exposeHeaders:    strings.Join([]string{requestIDHeader, servedByHeader, symbologyHeader, "Retry-After", "X-RateLimit-Limit", "X-RateLimit-Remaining", "X-RateLimit-Reset", "Deprecation", "Sunset", "Link"}, ", "),
+		maxAge:           strconv.Itoa(int(maxAge.Seconds())),
+		allowCredentials: allowCredentials,
+	}
+}
+
+// allowed reports whether requests from origin may be served cross-origin
+func (c *corsPolicy) allowed(origin string) bool {
+	if c.allowAll {
+		return true
+	}
+	for _, allowed := range c.origins {
+		if allowed == origin {
+			return true
+		}
+		if scheme, domain, ok := strings.Cut(allowed, "://*."); ok {
+			if rest, ok := strings.CutPrefix(origin, scheme+"://"); ok && strings.HasSuffix(rest, "."+domain) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// middleware adds CORS headers for allowed origins and answers preflight
+// requests before they reach authentication or route handlers. A nil policy
+// disables CORS.
+func (c *corsPolicy) middleware(next http.Handler) http.Handler {
+	if c == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Add("Vary", "Origin")
+
+		preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+		if !c.allowed(origin) {
+			if preflight {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if c.allowAll && !c.allowCredentials {
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+		} else {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+		}
+		if c.allowCredentials {
+			w.Header().Set("Access-Control-Allow-Credentials", "true")
+		}
+
+		if !preflight {
+			w.Header().Set("Access-Control-Expose-Headers", c.exposeHeaders)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Add("Vary", "Access-Control-Request-Method")
+		w.Header().Add("Vary", "Access-Control-Request-Headers")
+		w.Header().Set("Access-Control-Allow-Methods", c.methods)
+		w.Header().Set("Access-Control-Allow-Headers", c.headers)
+		w.Header().Set("Access-Control-Max-Age", c.maxAge)
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
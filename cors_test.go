@@ -0,0 +1,81 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCORSPolicy_Allowed(t *testing.T) {
+	policy := newCORSPolicy("https://app.example.com, https://*.example.org", "", "", 0, false)
+
+	tests := []struct {
+		origin string
+		want   bool
+	}{
+		{origin: "https://app.example.com", want: true},
+		{origin: "https://evil.com", want: false},
+		{origin: "http://app.example.com", want: false},
+		{origin: "https://ui.example.org", want: true},
+		{origin: "https://a.b.example.org", want: true},
+		{origin: "https://example.org", want: false},
+		{origin: "https://evilexample.org", want: false},
+	}
+
+	for _, tt := range tests {
+		if got := policy.allowed(tt.origin); got != tt.want {
+			t.Errorf("allowed(%q) = %v, want %v", tt.origin, got, tt.want)
+		}
+	}
+}
+
+func TestCORSMiddleware(t *testing.T) {
+	tests := []struct {
+		name        string
+		origins     string
+		credentials bool
+		method      string
+		origin      string
+		preflight   bool
+		wantStatus  int
+		wantAllow   string
+	}{
+		{name: "disabled", origins: "", method: http.MethodPost, origin: "https://app.example.com", wantStatus: http.StatusOK},
+		{name: "allowed origin", origins: "https://app.example.com", method: http.MethodPost, origin: "https://app.example.com", wantStatus: http.StatusOK, wantAllow: "https://app.example.com"},
+		{name: "disallowed origin", origins: "https://app.example.com", method: http.MethodPost, origin: "https://evil.com", wantStatus: http.StatusOK},
+		{name: "wildcard", origins: "*", method: http.MethodPost, origin: "https://any.example", wantStatus: http.StatusOK, wantAllow: "*"},
+		{name: "wildcard with credentials echoes origin", origins: "*", credentials: true, method: http.MethodPost, origin: "https://any.example", wantStatus: http.StatusOK, wantAllow: "https://any.example"},
+		{name: "preflight bypasses auth", origins: "https://app.example.com", method: http.MethodOptions, origin: "https://app.example.com", preflight: true, wantStatus: http.StatusNoContent, wantAllow: "https://app.example.com"},
+		{name: "preflight from disallowed origin", origins: "https://app.example.com", method: http.MethodOptions, origin: "https://evil.com", preflight: true, wantStatus: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deps := newTestDeps()
+			deps.cors = newCORSPolicy(tt.origins, "GET,POST,OPTIONS", "Content-Type,X-API-Key", 10*time.Minute, tt.credentials)
+			// Require API keys so preflights prove they are answered before authentication
+			deps.apiKeys = &apiKeyStore{required: tt.preflight, keys: map[string]*apiKey{}}
+			handler := newHandler(deps)
+
+			req := httptest.NewRequest(tt.method, "/api/v1/qr/generate?text=hello", nil)
+			req.Header.Set("Origin", tt.origin)
+			if tt.preflight {
+				req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+				req.Header.Set("Access-Control-Request-Headers", "X-API-Key")
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if got := rec.Header().Get("Access-Control-Allow-Origin"); got != tt.wantAllow {
+				t.Errorf("Access-Control-Allow-Origin = %q, want %q", got, tt.wantAllow)
+			}
+			if tt.wantStatus == http.StatusNoContent && rec.Header().Get("Access-Control-Allow-Headers") != "Content-Type, X-API-Key" {
+				t.Errorf("Access-Control-Allow-Headers = %q", rec.Header().Get("Access-Control-Allow-Headers"))
+			}
+		})
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	"io/fs"
+	"net/http"
+)
+
+// dashboardFiles is the admin dashboard: static HTML, CSS, and JavaScript
+// reading the admin listener's JSON endpoints
+//
+//go:embed dashboard
+var dashboardFiles embed.FS
+
+// dashboardContentSecurityPolicy limits the dashboard to its own files and
+// the admin endpoints
+const dashboardContentSecurityPolicy = "default-src 'none'; script-src 'self'; style-src 'self'; connect-src 'self'; form-action 'none'; frame-ancestors 'none'; base-uri 'none'"
+
+// handleDashboard serves the dashboard under /admin/. With SSO, browsers
+// log in before seeing it; otherwise the page asks for the admin token. The
+// data comes from endpoints that require admin credentials either way.
+func handleDashboard(sso *oidcAuth) http.HandlerFunc {
+	root, _ := fs.Sub(dashboardFiles, "dashboard")
+	files := http.StripPrefix("/admin/", http.FileServerFS(root))
+	return sso.requireSession(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Security-Policy", dashboardContentSecurityPolicy)
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.Header().Set("Cache-Control", "no-cache")
+		files.ServeHTTP(w, r)
+	})
+}
+
+// statsResponse is the state of the image cache and render queue
+type statsResponse struct {
+	Cache      cacheStats      `json:"cache"`
+	RenderPool renderPoolStats `json:"render_pool"`
+}
+
+// cacheStats describes the in-memory image cache
+type cacheStats struct {
+	Enabled    bool `json:"enabled"`
+	Entries    int  `json:"entries"`
+	MaxEntries int  `json:"max_entries"`
+	// Shared reports the Redis tier behind the in-memory cache
+	Shared bool `json:"shared"`
+}
+
+// renderPoolStats describes the render worker pool; an unbounded pool only
+// reports its parallelism
+type renderPoolStats struct {
+	Bounded  bool  `json:"bounded"`
+	Workers  int   `json:"workers"`
+	Busy     int   `json:"busy"`
+	Queued   int64 `json:"queued"`
+	MaxQueue int64 `json:"max_queue"`
+}
+
+// stats reports the cache's size
+func (c *imageCache) stats() cacheStats {
+	if c == nil {
+		return cacheStats{}
+	}
+	return cacheStats{Enabled: true, Entries: c.Len(), MaxEntries: c.maxEntries, Shared: c.remote != nil}
+}
+
+// stats reports the pool's workers and queue
+func (p *renderPool) stats() renderPoolStats {
+	if p == nil {
+		return renderPoolStats{Workers: p.size()}
+	}
+	return renderPoolStats{Bounded: true, Workers: p.size(), Busy: len(p.workers), Queued: p.queued.Load(), MaxQueue: p.maxQueue}
+}
+
+// handleStats returns the state of the image cache and render queue
+func handleStats(deps handlerDeps) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		json.NewEncoder(w).Encode(statsResponse{Cache: deps.cache.stats(), RenderPool: deps.renderPool.stats()})
+	}
+}
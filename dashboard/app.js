@@ -0,0 +1,142 @@
+// Admin dashboard: polls the admin listener's health, stats, flags, and
+// audit endpoints. With SSO the session cookie authenticates the requests;
+// otherwise the admin token is asked for and kept for the browser session.
+"use strict";
+
+const refreshInterval = 10000;
+const maxErrors = 20;
+
+const login = document.getElementById("login");
+const token = document.getElementById("token");
+const loginError = document.getElementById("login-error");
+const panels = document.getElementById("panels");
+const updated = document.getElementById("updated");
+
+let timer;
+
+login.addEventListener("submit", (event) => {
+  event.preventDefault();
+  sessionStorage.setItem("qr-admin-token", token.value);
+  refresh();
+});
+
+refresh();
+
+async function get(path) {
+  const headers = {};
+  const saved = sessionStorage.getItem("qr-admin-token");
+  if (saved) {
+    headers.Authorization = "Bearer " + saved;
+  }
+  const response = await fetch(path, { headers, cache: "no-store" });
+  if (response.status === 401 || response.status === 403) {
+    throw new AuthError(await response.text());
+  }
+  // The audit log is optional
+  if (response.status === 404) {
+    return undefined;
+  }
+  if (!response.ok && response.status !== 503) {
+    throw new Error(path + ": " + response.status);
+  }
+  return response.json();
+}
+
+class AuthError extends Error {}
+
+async function refresh() {
+  clearTimeout(timer);
+  try {
+    const [health, stats, flags, audit] = await Promise.all([
+      get("/healthz"),
+      get("/admin/stats"),
+      get("/flags"),
+      get("/admin/audit?limit=500"),
+    ]);
+    showHealth(health);
+    showStats(stats);
+    showFlags(flags);
+    showErrors(audit || []);
+    login.hidden = true;
+    panels.hidden = false;
+    updated.textContent = "Updated " + new Date().toLocaleTimeString();
+  } catch (err) {
+    if (err instanceof AuthError) {
+      panels.hidden = true;
+      login.hidden = false;
+      loginError.textContent = sessionStorage.getItem("qr-admin-token") ? err.message.trim() : "";
+      loginError.hidden = !loginError.textContent;
+      updated.textContent = "Not signed in";
+      return;
+    }
+    updated.textContent = "Update failed: " + err.message;
+  }
+  timer = setTimeout(refresh, refreshInterval);
+}
+
+function cell(text, className) {
+  const td = document.createElement("td");
+  td.textContent = text;
+  if (className) {
+    td.className = className;
+  }
+  return td;
+}
+
+function row(...cells) {
+  const tr = document.createElement("tr");
+  tr.append(...cells);
+  return tr;
+}
+
+function definitions(list, entries) {
+  list.replaceChildren(...entries.flatMap(([term, value]) => {
+    const dt = document.createElement("dt");
+    dt.textContent = term;
+    const dd = document.createElement("dd");
+    dd.textContent = value;
+    return [dt, dd];
+  }));
+}
+
+function showHealth(health) {
+  const status = document.getElementById("health-status");
+  status.textContent = `${health.status}, up ${health.uptime}`;
+  status.className = health.status;
+  const components = Object.entries(health.components || {}).sort(([a], [b]) => a.localeCompare(b));
+  document.getElementById("components").replaceChildren(...components.map(([name, c]) =>
+    row(cell(name + (c.critical ? " (critical)" : "")), cell(c.status, c.status), cell(c.latency_ms.toFixed(1) + " ms"), cell(c.error || ""))));
+}
+
+function showStats(stats) {
+  const cache = stats.cache;
+  definitions(document.getElementById("cache"), cache.enabled ? [
+    ["Entries", `${cache.entries} of ${cache.max_entries}`],
+    ["Usage", Math.round(100 * cache.entries / cache.max_entries) + "%"],
+    ["Shared Redis tier", cache.shared ? "yes" : "no"],
+  ] : [["Status", "disabled"]]);
+
+  const pool = stats.render_pool;
+  definitions(document.getElementById("render-pool"), pool.bounded ? [
+    ["Busy workers", `${pool.busy} of ${pool.workers}`],
+    ["Queued renders", `${pool.queued} of ${pool.max_queue}`],
+  ] : [["Status", `unbounded (${pool.workers} CPUs)`]]);
+}
+
+function showFlags(flags) {
+  const names = Object.keys(flags).sort();
+  document.getElementById("flags").replaceChildren(...names.map((name) => {
+    const item = document.createElement("li");
+    item.textContent = `${name}: ${flags[name] ? "on" : "off"}`;
+    item.className = flags[name] ? "on" : "";
+    return item;
+  }));
+}
+
+function showErrors(events) {
+  const failed = events.filter((event) => event.status >= 400).slice(0, maxErrors);
+  document.getElementById("errors-empty").hidden = failed.length > 0;
+  document.getElementById("errors").replaceChildren(...failed.map((event) =>
+    row(cell(new Date(event.time).toLocaleString()), cell(event.action), cell(event.actor), cell(event.tenant || ""),
+      cell(event.status, "error"), cell(event.request_id || ""))));
+}
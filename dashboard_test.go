@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDashboard(t *testing.T) {
+	provider := newTestProvider(t, []string{"qr-admins"})
+	sso, err := newOIDCAuth(oidcConfig{
+		IssuerURL:     provider.URL,
+		ClientID:      "qr-ui",
+		ClientSecret:  "client-secret",
+		RedirectURL:   "http://localhost:6060/auth/callback",
+		GroupsClaim:   "groups",
+		AdminGroups:   []string{"qr-admins"},
+		SessionSecret: "session-secret",
+		SessionTTL:    time.Hour,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name            string
+		sso             bool
+		loggedIn        bool
+		path            string
+		wantStatus      int
+		wantLocation    string
+		wantContentType string
+		wantBody        string
+	}{
+		{name: "redirect", path: "/admin", wantStatus: http.StatusMovedPermanently, wantLocation: "/admin/"},
+		{name: "page with admin token", path: "/admin/", wantStatus: http.StatusOK, wantContentType: "text/html; charset=utf-8", wantBody: `<script src="app.js" defer></script>`},
+		{name: "script", path: "/admin/app.js", wantStatus: http.StatusOK, wantContentType: "text/javascript; charset=utf-8", wantBody: "/admin/stats"},
+		{name: "SSO login", sso: true, path: "/admin/", wantStatus: http.StatusFound, wantLocation: "/auth/login?return_to=%2Fadmin%2F"},
+		{name: "SSO session", sso: true, loggedIn: true, path: "/admin/", wantStatus: http.StatusOK, wantContentType: "text/html; charset=utf-8", wantBody: "Recent errors"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deps := newTestDeps()
+			if tt.sso {
+				deps.oidc = sso
+			}
+			admin := newAdminHandler(deps)
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.loggedIn {
+				for _, c := range login(t, admin) {
+					req.AddCookie(c)
+				}
+			}
+			rec := httptest.NewRecorder()
+			admin.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if got := rec.Header().Get("Location"); got != tt.wantLocation {
+				t.Errorf("location = %q, want %q", got, tt.wantLocation)
+			}
+			if rec.Code != http.StatusOK {
+				return
+			}
+			if got := rec.Header().Get("Content-Type"); got != tt.wantContentType {
+				t.Errorf("content type = %q, want %q", got, tt.wantContentType)
+			}
+			if csp := rec.Header().Get("Content-Security-Policy"); !strings.Contains(csp, "frame-ancestors 'none'") {
+				t.Errorf("Content-Security-Policy = %q", csp)
+			}
+			if !strings.Contains(rec.Body.String(), tt.wantBody) {
+				t.Errorf("body lacks %q", tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestStats(t *testing.T) {
+	tests := []struct {
+		name       string
+		token      string
+		cache      *imageCache
+		pool       *renderPool
+		wantStatus int
+		want       statsResponse
+	}{
+		{name: "unauthenticated", wantStatus: http.StatusUnauthorized},
+		{
+			name:       "disabled cache and unbounded pool",
+			token:      "test-admin-token",
+			wantStatus: http.StatusOK,
+			want:       statsResponse{RenderPool: renderPoolStats{Workers: (*renderPool)(nil).size()}},
+		},
+		{
+			name:       "cache and pool",
+			token:      "test-admin-token",
+			cache:      newImageCache(10, 0),
+			pool:       newRenderPool(2, 5),
+			wantStatus: http.StatusOK,
+			want: statsResponse{
+				Cache:      cacheStats{Enabled: true, Entries: 1, MaxEntries: 10},
+				RenderPool: renderPoolStats{Bounded: true, Workers: 2, Busy: 1, MaxQueue: 5},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deps := newTestDeps()
+			deps.cache, deps.renderPool = tt.cache, tt.pool
+			tt.cache.Add("key", []byte("image"))
+			// Hold a worker while the stats are read
+			if tt.pool != nil {
+				started, release := make(chan struct{}), make(chan struct{})
+				go tt.pool.run(context.Background(), func() ([]byte, error) {
+					close(started)
+					<-release
+					return nil, nil
+				})
+				<-started
+				defer close(release)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "/admin/stats", nil)
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			rec := httptest.NewRecorder()
+			newAdminHandler(deps).ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if rec.Code != http.StatusOK {
+				return
+			}
+			var got statsResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("stats = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/boombuler/barcode/datamatrix"
+)
+
+// dataMatrixQuietZone is the light margin around Data Matrix symbols in
+// modules, the ISO/IEC 16022 minimum: symbols are meant for small parts
+const dataMatrixQuietZone = 1
+
+// gs1Separator is the ASCII group separator (GS) between variable-length
+// GS1 element strings, encoded as FNC1
+const gs1Separator = '\x1d'
+
+// dataMatrixContent converts text to the bytes a Data Matrix symbol encodes.
+// Data Matrix encodes ISO-8859-1, so other characters are rejected. GS1
+// content is ASCII element strings separated by GS characters, encoded
+// after an FNC1 start character with FNC1 separators.
+func dataMatrixContent(text string, gs1 bool) ([]byte, *apiError) {
+	if gs1 {
+		content := make([]byte, 0, len(text)+1)
+		content = append(content, datamatrix.FNC1)
+		for _, r := range text {
+			switch {
+			case r == gs1Separator:
+				content = append(content, datamatrix.FNC1)
+			case r >= ' ' && r <= '~':
+				content = append(content, byte(r))
+			default:
+				return nil, apiErrorf(errCodeInvalidPayload, map[string]any{"character": fmt.Sprintf("%U", r)},
+					"GS1 content must be printable ASCII element strings separated by GS characters, not %U", r)
+			}
+		}
+		return content, nil
+	}
+
+	content, apiErr := latin1Bytes("Data Matrix", text)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	// The encoder takes a leading FNC1 byte, è in ISO-8859-1, as the start
+	// of GS1 content
+	if len(content) > 0 && content[0] == datamatrix.FNC1 {
+		return nil, &apiError{Code: errCodeInvalidPayload, Message: "Data Matrix content can't start with 'è'"}
+	}
+	return content, nil
+}
+
+// latin1Bytes converts text to ISO-8859-1, the default character set of
+// Data Matrix and PDF417 symbols, rejecting other characters
+func latin1Bytes(symbology, text string) ([]byte, *apiError) {
+	content := make([]byte, 0, len(text))
+	for _, r := range text {
+		if r > 0xff {
+			return nil, apiErrorf(errCodeInvalidPayload, map[string]any{"character": fmt.Sprintf("%U", r)},
+				"%s encodes ISO-8859-1 (Latin-1) characters, not %U", symbology, r)
+		}
+		content = append(content, byte(r))
+	}
+	return content, nil
+}
+
+// dataMatrixLayout encodes content in the smallest square Data Matrix symbol
+// it fits and returns the width and height of the symbol in modules, quiet
+// zone included, and its dark modules as one rectangle per run in each row
+func dataMatrixLayout(content []byte) (int, int, []rect, error) {
+	code, err := datamatrix.Encode(string(content))
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	bounds := code.Bounds()
+	dark := func(x, y int) bool {
+		r, _, _, _ := code.At(x, y).RGBA()
+		return r < 0x8000
+	}
+	var rects []rect
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; {
+			if !dark(x, y) {
+				x++
+				continue
+			}
+			start := x
+			for x < bounds.Max.X && dark(x, y) {
+				x++
+			}
+			rects = append(rects, rect{dataMatrixQuietZone + start - bounds.Min.X, dataMatrixQuietZone + y - bounds.Min.Y, x - start, 1})
+		}
+	}
+	return bounds.Dx() + 2*dataMatrixQuietZone, bounds.Dy() + 2*dataMatrixQuietZone, rects, nil
+}
+
+// handleDataMatrix renders the text parameter as a Data Matrix symbol, which
+// fits on parts too small for QR codes. With gs1=true the text is GS1
+// element strings. Symbols are deterministic and served with the caching
+// headers of QR codes.
+func handleDataMatrix(deps handlerDeps) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		text := query.Get("text")
+		if text == "" {
+			writeAPIError(w, r, http.StatusBadRequest, apiError{Code: errCodeInvalidParam, Message: "Missing required parameter 'text'"})
+			return
+		}
+		gs1 := false
+		if raw := query.Get("gs1"); raw != "" {
+			var err error
+			if gs1, err = strconv.ParseBool(raw); err != nil {
+				writeAPIError(w, r, http.StatusBadRequest, apiError{Code: errCodeInvalidParam, Message: "Parameter 'gs1' must be true or false"})
+				return
+			}
+		}
+		opts, apiErr := parseSymbolOptions(query)
+		if apiErr != nil {
+			writeAPIError(w, r, http.StatusBadRequest, *apiErr)
+			return
+		}
+		options := map[string]string{}
+		if gs1 {
+			options["gs1"] = "true"
+		}
+		setAuditPayload(r.Context(), text, options)
+
+		if status, apiErr := validatePayload(text, deps.maxPayloadLength); apiErr != nil {
+			writeAPIError(w, r, status, *apiErr)
+			return
+		}
+		// GS1 content allows only printable ASCII and separators, which
+		// leaves nothing for the sanitizer to catch but the separators
+		if !gs1 {
+			if text, apiErr = sanitizePayload(text, deps.sanitize); apiErr != nil {
+				writeAPIError(w, r, http.StatusBadRequest, *apiErr)
+				return
+			}
+		}
+		content, apiErr := dataMatrixContent(text, gs1)
+		if apiErr != nil {
+			writeAPIError(w, r, http.StatusBadRequest, *apiErr)
+			return
+		}
+		if apiErr := deps.policy.check(strings.ReplaceAll(text, string(gs1Separator), ""), apiKeyName(r.Context())); apiErr != nil {
+			writeAPIError(w, r, http.StatusUnprocessableEntity, *apiErr)
+			return
+		}
+
+		width, height, rects, err := dataMatrixLayout(content)
+		if err != nil {
+			writeAPIError(w, r, http.StatusRequestEntityTooLarge, apiError{Code: errCodePayloadTooLarge, Message: "Parameter 'text' does not fit in the largest Data Matrix symbol"})
+			return
+		}
+
+		etag := imageETag(imageCacheKey(string(content), renderVersion, "datamatrix", opts.format, strconv.Itoa(opts.scale)))
+		setImageCacheHeaders(w.Header(), etag, deps.imageMaxAge)
+		if etagMatches(r.Header.Get("If-None-Match"), etag) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		var out bytes.Buffer
+		contentType := "image/png"
+		if opts.format == formatSVG {
+			contentType = "image/svg+xml"
+			writeRectsSVG(&out, width, height, rects, opts.scale, "Data Matrix code")
+		} else if err := writeRectsPNG(&out, width, height, rects, opts.scale); err != nil {
+			setRequestError(r.Context(), err)
+			http.Error(w, "Failed to generate Data Matrix symbol", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Last-Modified", renderModTime.Format(http.TimeFormat))
+		serveImage(w, r, "datamatrix."+opts.format, out.Bytes())
+	}
+}
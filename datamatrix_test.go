@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDataMatrixContent(t *testing.T) {
+	tests := []struct {
+		name    string
+		text    string
+		gs1     bool
+		want    string
+		wantErr bool
+	}{
+		{name: "ASCII", text: "SN-0042", want: "SN-0042"},
+		{name: "Latin-1", text: "Größe", want: "Gr\xf6\xdfe"},
+		{name: "outside Latin-1", text: "零件", wantErr: true},
+		{name: "leading è", text: "èclair", wantErr: true},
+		{name: "inner è", text: "crème", want: "cr\xe8me"},
+		{name: "GS1", text: "0109501101020917" + "10ABCD1234\x1d2110", gs1: true, want: "\xe80109501101020917" + "10ABCD1234\xe82110"},
+		{name: "GS1 non-ASCII", text: "10Größe", gs1: true, wantErr: true},
+		{name: "GS1 control character", text: "10AB\n", gs1: true, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, apiErr := dataMatrixContent(tt.text, tt.gs1)
+			if (apiErr != nil) != tt.wantErr {
+				t.Fatalf("error = %v, want error %v", apiErr, tt.wantErr)
+			}
+			if string(got) != tt.want {
+				t.Errorf("content = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDataMatrixLayout(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		wantSize int
+		wantErr  bool
+	}{
+		{name: "smallest symbol", content: "123456", wantSize: 10 + 2},
+		{name: "serial number", content: "SN-0042-ABCDEFGH", wantSize: 18 + 2},
+		{name: "too long", content: strings.Repeat("A", 2000), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			width, height, rects, err := dataMatrixLayout([]byte(tt.content))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if width != tt.wantSize || height != tt.wantSize {
+				t.Fatalf("size = %dx%d, want %dx%d", width, height, tt.wantSize, tt.wantSize)
+			}
+
+			dark := make([][]bool, height)
+			for y := range dark {
+				dark[y] = make([]bool, width)
+			}
+			for _, r := range rects {
+				for x := r.x; x < r.x+r.w; x++ {
+					dark[r.y][x] = true
+				}
+			}
+			// The quiet zone is light, and the finder pattern is a solid left
+			// column and bottom row
+			for i := range width {
+				if dark[0][i] || dark[height-1][i] || dark[i][0] || dark[i][width-1] {
+					t.Fatalf("quiet zone has a dark module at %d", i)
+				}
+			}
+			for i := 1; i < width-1; i++ {
+				if !dark[i][1] || !dark[height-2][i] {
+					t.Fatalf("finder pattern has a light module at %d", i)
+				}
+			}
+		})
+	}
+}
+
+func TestDataMatrix(t *testing.T) {
+	tests := []struct {
+		name            string
+		query           string
+		wantStatus      int
+		wantContentType string
+		wantBody        string
+	}{
+		{name: "PNG", query: "text=SN-0042", wantStatus: http.StatusOK, wantContentType: "image/png"},
+		{name: "SVG", query: "text=123456&format=svg&scale=5", wantStatus: http.StatusOK, wantContentType: "image/svg+xml", wantBody: `width="60" height="60" viewBox="0 0 12 12"`},
+		{name: "GS1", query: "text=0109501101020917%1D2110&gs1=true", wantStatus: http.StatusOK, wantContentType: "image/png"},
+		{name: "GS1 separator without gs1", query: "text=10AB%1D2110", wantStatus: http.StatusBadRequest},
+		{name: "missing text", query: "format=svg", wantStatus: http.StatusBadRequest},
+		{name: "outside Latin-1", query: "text=%E9%9B%B6", wantStatus: http.StatusBadRequest},
+		{name: "invalid gs1", query: "text=SN-0042&gs1=yes", wantStatus: http.StatusBadRequest},
+		{name: "invalid scale", query: "text=SN-0042&scale=0", wantStatus: http.StatusBadRequest},
+		{name: "too long", query: "text=" + strings.Repeat("x", 2000), wantStatus: http.StatusRequestEntityTooLarge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			newTestHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v1/barcode/datamatrix?"+tt.query, nil))
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if tt.wantContentType != "" && rec.Header().Get("Content-Type") != tt.wantContentType {
+				t.Errorf("content type = %q, want %q", rec.Header().Get("Content-Type"), tt.wantContentType)
+			}
+			if !strings.Contains(rec.Body.String(), tt.wantBody) {
+				t.Errorf("body lacks %q: %.300s", tt.wantBody, rec.Body.String())
+			}
+			if rec.Code != http.StatusOK {
+				return
+			}
+			if rec.Header().Get("ETag") == "" {
+				t.Error("symbol has no ETag")
+			}
+			if tt.wantContentType == "image/png" {
+				if _, err := png.Decode(bytes.NewReader(rec.Body.Bytes())); err != nil {
+					t.Errorf("invalid PNG: %v", err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// deliveryRequest holds the deliver.* parameters of a generate request, which
+// send the code to recipients instead of returning it
+type deliveryRequest struct {
+	emailTo, emailFormat  string
+	mqttTopic, mqttFormat string
+	printer, printMedia   string
+}
+
+// parseDelivery reads the deliver.* parameters. It returns nil when no
+// delivery is requested.
+func parseDelivery(query url.Values, deps handlerDeps) (*deliveryRequest, *apiError) {
+	var d deliveryRequest
+	var apiErr *apiError
+	if d.emailTo, d.emailFormat, apiErr = emailDelivery(query, deps.email); apiErr != nil {
+		return nil, apiErr
+	}
+	if d.mqttTopic, d.mqttFormat, apiErr = mqttDelivery(query, deps.mqtt); apiErr != nil {
+		return nil, apiErr
+	}
+	if d.printer, d.printMedia, apiErr = printDelivery(query, deps.printers); apiErr != nil {
+		return nil, apiErr
+	}
+	if d.emailTo == "" && d.mqttTopic == "" && d.printer == "" {
+		return nil, nil
+	}
+	return &d, nil
+}
+
+// channels lists the requested channels, for the audit log
+func (d *deliveryRequest) channels() string {
+	var channels []string
+	if d.emailTo != "" {
+		channels = append(channels, "email")
+	}
+	if d.mqttTopic != "" {
+		channels = append(channels, "mqtt")
+	}
+	if d.printer != "" {
+		channels = append(channels, "print")
+	}
+	return strings.Join(channels, ",")
+}
+
+// deliver sends the rendered code over every requested channel and returns
+// what was delivered. On failure it returns the failed channel; channels
+// before it have already been delivered. The plaintext of encrypted payloads
+// is never delivered.
+func (d *deliveryRequest) deliver(ctx context.Context, deps handlerDeps, text, payload string, encrypted bool, pngBytes []byte) (delivered map[string]string, failed string, err error) {
+	delivered = map[string]string{}
+	if encrypted {
+		text = ""
+	}
+	if d.emailTo != "" {
+		if err := deps.email.Send(ctx, d.emailTo, text, d.emailFormat, pngBytes); err != nil {
+			deps.metrics.deliveries.WithLabelValues("email", jobFailed).Inc()
+			return nil, "email", err
+		}
+		deps.metrics.deliveries.WithLabelValues("email", jobSucceeded).Inc()
+		delivered["email"], delivered["email_format"] = d.emailTo, d.emailFormat
+	}
+	if d.mqttTopic != "" {
+		if err := deps.mqtt.Send(ctx, deps.qrGen, d.mqttTopic, d.mqttFormat, payload, pngBytes); err != nil {
+			deps.metrics.deliveries.WithLabelValues("mqtt", jobFailed).Inc()
+			return nil, "mqtt", err
+		}
+		deps.metrics.deliveries.WithLabelValues("mqtt", jobSucceeded).Inc()
+		delivered["mqtt"], delivered["mqtt_format"] = d.mqttTopic, d.mqttFormat
+	}
+	if d.printer != "" {
+		jobID, err := deps.printers.Print(ctx, d.printer, d.printMedia, "qr-"+requestIDFromContext(ctx), pngBytes)
+		if err != nil {
+			deps.metrics.deliveries.WithLabelValues("print", jobFailed).Inc()
+			return nil, "print", err
+		}
+		deps.metrics.deliveries.WithLabelValues("print", jobSucceeded).Inc()
+		delivered["print"], delivered["print_job"] = d.printer, strconv.Itoa(jobID)
+		if d.printMedia != "" {
+			delivered["print_media"] = d.printMedia
+		}
+	}
+	return delivered, "", nil
+}
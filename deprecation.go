@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// deprecation marks a route as deprecated, with an optional sunset after
+// which it may be removed and a link to the migration guide
+type deprecation struct {
+	// Deprecated is when the route was or will be deprecated
+	Deprecated time.Time `json:"deprecated"`
+	// Sunset is when the route stops being served; zero leaves it open
+	Sunset time.Time `json:"sunset,omitempty"`
+	// Link points to documentation on the replacement
+	Link string `json:"link,omitempty"`
+}
+
+// deprecations holds the deprecated routes, keyed by a route pattern as
+// registered ("POST /api/v1/qr/compare"), its path ("/api/v1/qr/compare"),
+// or a path ending in a slash covering every route below it ("/api/v1/")
+type deprecations struct {
+	routes  map[string]deprecation
+	metrics *metrics
+}
+
+// loadDeprecations reads deprecated routes from a JSON file of the form
+// {"/api/v1/": {"deprecated": "2026-11-01T00:00:00Z", "sunset": "2027-05-01T00:00:00Z",
+// "link": "https://example.com/docs/v2"}}. Without a file nothing is deprecated.
+func loadDeprecations(path string, m *metrics) (*deprecations, error) {
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read deprecations file: %w", err)
+	}
+	d := &deprecations{metrics: m}
+	if err := json.Unmarshal(data, &d.routes); err != nil {
+		return nil, fmt.Errorf("invalid deprecations file %s: %w", path, err)
+	}
+	for route, dep := range d.routes {
+		if !strings.Contains(route, "/") {
+			return nil, fmt.Errorf("deprecated route %q must be a path or route pattern", route)
+		}
+		if dep.Deprecated.IsZero() {
+			return nil, fmt.Errorf("deprecated route %q: deprecated date is required", route)
+		}
+		if !dep.Sunset.IsZero() && dep.Sunset.Before(dep.Deprecated) {
+			return nil, fmt.Errorf("deprecated route %q: sunset precedes deprecation", route)
+		}
+	}
+	return d, nil
+}
+
+// lookup returns the deprecation of a route pattern: an entry for the
+// pattern itself, then its path, then the longest path prefix
+func (d *deprecations) lookup(pattern string) (deprecation, bool) {
+	if d == nil {
+		return deprecation{}, false
+	}
+	if dep, ok := d.routes[pattern]; ok {
+		return dep, true
+	}
+	path := pattern
+	if _, p, ok := strings.Cut(pattern, " "); ok {
+		path = p
+	}
+	if dep, ok := d.routes[path]; ok {
+		return dep, true
+	}
+	var found deprecation
+	longest := -1
+	for route, dep := range d.routes {
+		if strings.HasSuffix(route, "/") && strings.HasPrefix(path, route) && len(route) > longest {
+			found, longest = dep, len(route)
+		}
+	}
+	return found, longest >= 0
+}
+
+// setHeaders announces a deprecation as in RFC 9745 and RFC 8594
+func (dep deprecation) setHeaders(h http.Header) {
+	h.Set("Deprecation", "@"+strconv.FormatInt(dep.Deprecated.Unix(), 10))
+	if !dep.Sunset.IsZero() {
+		h.Set("Sunset", dep.Sunset.UTC().Format(http.TimeFormat))
+	}
+	if dep.Link != "" {
+		h.Add("Link", "<"+dep.Link+`>; rel="deprecation"; type="text/html"`)
+	}
+}
+
+// wrap announces the deprecation of the route on every response and counts
+// and logs its use, so callers still on it can be found before the sunset.
+// Routes that aren't deprecated are returned as they are.
+func (d *deprecations) wrap(pattern string, next http.HandlerFunc) http.HandlerFunc {
+	dep, ok := d.lookup(pattern)
+	if !ok {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		dep.setHeaders(w.Header())
+		d.metrics.deprecatedRequests.WithLabelValues(pattern).Inc()
+		addLogAttrs(r.Context(), slog.Bool("deprecated", true))
+		slog.WarnContext(r.Context(), "deprecated route used", "route", pattern, "sunset", dep.Sunset, "user_agent", r.UserAgent())
+		next(w, r)
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestLoadDeprecations(t *testing.T) {
+	tests := []struct {
+		name       string
+		content    string
+		pattern    string
+		wantSunset string
+		wantFound  bool
+		wantErr    bool
+	}{
+		{name: "exact pattern", content: `{"POST /api/v1/qr/compare": {"deprecated": "2026-01-01T00:00:00Z", "sunset": "2026-06-01T00:00:00Z"}}`, pattern: "POST /api/v1/qr/compare", wantSunset: "2026-06-01", wantFound: true},
+		{name: "path of pattern", content: `{"/api/v1/qr/compare": {"deprecated": "2026-01-01T00:00:00Z"}}`, pattern: "POST /api/v1/qr/compare", wantFound: true},
+		{name: "longest prefix", content: `{"/api/": {"deprecated": "2026-01-01T00:00:00Z", "sunset": "2027-01-01T00:00:00Z"}, "/api/v1/": {"deprecated": "2026-01-01T00:00:00Z", "sunset": "2026-06-01T00:00:00Z"}}`, pattern: "/api/v1/qr/generate", wantSunset: "2026-06-01", wantFound: true},
+		{name: "prefix without slash", content: `{"/api/v1": {"deprecated": "2026-01-01T00:00:00Z"}}`, pattern: "/api/v1/qr/generate"},
+		{name: "other route", content: `{"/api/v1/qr/compare": {"deprecated": "2026-01-01T00:00:00Z"}}`, pattern: "/api/v1/qr/generate"},
+		{name: "missing deprecation date", content: `{"/api/v1/": {"sunset": "2026-06-01T00:00:00Z"}}`, wantErr: true},
+		{name: "sunset before deprecation", content: `{"/api/v1/": {"deprecated": "2026-06-01T00:00:00Z", "sunset": "2026-01-01T00:00:00Z"}}`, wantErr: true},
+		{name: "not a route", content: `{"generate": {"deprecated": "2026-01-01T00:00:00Z"}}`, wantErr: true},
+		{name: "invalid date", content: `{"/api/v1/": {"deprecated": "tomorrow"}}`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "deprecations.json")
+			if err := os.WriteFile(path, []byte(tt.content), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			d, err := loadDeprecations(path, newMetrics())
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("loadDeprecations() expected error but got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("loadDeprecations() unexpected error: %v", err)
+			}
+			dep, found := d.lookup(tt.pattern)
+			if found != tt.wantFound {
+				t.Fatalf("lookup(%q) found %v, want %v", tt.pattern, found, tt.wantFound)
+			}
+			if sunset := dep.Sunset.Format(time.DateOnly); tt.wantSunset != "" && sunset != tt.wantSunset {
+				t.Errorf("sunset = %s, want %s", sunset, tt.wantSunset)
+			}
+		})
+	}
+}
+
+func TestDeprecations_Headers(t *testing.T) {
+	deps := newTestDeps()
+	deps.deprecations = &deprecations{
+		routes: map[string]deprecation{
+			"/api/v1/qr/generate": {
+				Deprecated: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC),
+				Sunset:     time.Date(2026, 6, 1, 0, 0, 0, 0, time.UTC),
+				Link:       "https://example.com/docs/v2",
+			},
+		},
+		metrics: deps.metrics,
+	}
+	handler := newHandler(deps)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v1/qr/generate?text=hello", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d", rec.Code)
+	}
+	if got := rec.Header().Get("Deprecation"); got != "@1767225600" {
+		t.Errorf("Deprecation = %q, want @1767225600", got)
+	}
+	if got := rec.Header().Get("Sunset"); got != "Mon, 01 Jun 2026 00:00:00 GMT" {
+		t.Errorf("Sunset = %q", got)
+	}
+	if got := rec.Header().Get("Link"); !strings.Contains(got, `<https://example.com/docs/v2>; rel="deprecation"`) {
+		t.Errorf("Link = %q", got)
+	}
+	if got := testutil.ToFloat64(deps.metrics.deprecatedRequests.WithLabelValues("/api/v1/qr/generate")); got != 1 {
+		t.Errorf("deprecated requests = %v, want 1", got)
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/version", nil))
+	if got := rec.Header().Get("Deprecation"); got != "" {
+		t.Errorf("Deprecation = %q on a current route", got)
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"hash"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// expectedDigestHeader carries the SHA-256 digest a client expects an image
+// to have, as in Repr-Digest (sha-256=:<base64>:) or Digest
+// (SHA-256=<base64>). An image that differs is refused instead of served.
+const expectedDigestHeader = "X-Expected-Digest"
+
+// errCodeDigestMismatch is the error of images that don't match the
+// expected digest
+const errCodeDigestMismatch = "digest_mismatch"
+
+// setDigestHeaders describes an image by its SHA-256 digest, as Repr-Digest
+// (RFC 9530) and as Digest (RFC 3230) for older clients. Both cover the
+// whole image, so range responses carry them unchanged.
+func setDigestHeaders(h http.Header, sum []byte) {
+	encoded := base64.StdEncoding.EncodeToString(sum)
+	h.Set("Repr-Digest", "sha-256=:"+encoded+":")
+	h.Set("Digest", "SHA-256="+encoded)
+}
+
+// parseExpectedDigest returns the SHA-256 digest of the X-Expected-Digest
+// header, or nil when it is absent. Other algorithms in the list are
+// ignored, but one SHA-256 digest is required.
+func parseExpectedDigest(r *http.Request) ([]byte, *apiError) {
+	header := r.Header.Get(expectedDigestHeader)
+	if header == "" {
+		return nil, nil
+	}
+	for _, member := range strings.Split(header, ",") {
+		algorithm, value, ok := strings.Cut(strings.TrimSpace(member), "=")
+		if !ok || !strings.EqualFold(algorithm, "sha-256") {
+			continue
+		}
+		// Structured field byte sequences are wrapped in colons
+		if len(value) >= 2 && value[0] == ':' && value[len(value)-1] == ':' {
+			value = value[1 : len(value)-1]
+		}
+		sum, err := base64.StdEncoding.DecodeString(value)
+		if err == nil && len(sum) == sha256.Size {
+			return sum, nil
+		}
+	}
+	return nil, &apiError{Code: errCodeInvalidParam, Message: "Header 'X-Expected-Digest' must be a SHA-256 digest, as sha-256=:<base64>:"}
+}
+
+// serveImage serves image bytes with their digest, or a 412 when they don't
+// match the X-Expected-Digest header
+func serveImage(w http.ResponseWriter, r *http.Request, name string, imageBytes []byte) {
+	expected, apiErr := parseExpectedDigest(r)
+	if apiErr != nil {
+		writeAPIError(w, r, http.StatusBadRequest, *apiErr)
+		return
+	}
+	sum := sha256.Sum256(imageBytes)
+	if expected != nil && !bytes.Equal(expected, sum[:]) {
+		// The image headers set so far describe the image that isn't served
+		for _, header := range []string{"Cache-Control", "ETag", "Last-Modified", "Content-Disposition", "Content-Length"} {
+			w.Header().Del(header)
+		}
+		writeAPIError(w, r, http.StatusPreconditionFailed, apiError{
+			Code:    errCodeDigestMismatch,
+			Message: "The image doesn't match the expected digest",
+			Details: map[string]any{
+				"expected": "sha-256=:" + base64.StdEncoding.EncodeToString(expected) + ":",
+				"actual":   "sha-256=:" + base64.StdEncoding.EncodeToString(sum[:]) + ":",
+			},
+		})
+		return
+	}
+	setDigestHeaders(w.Header(), sum[:])
+	http.ServeContent(w, r, name, time.Time{}, bytes.NewReader(imageBytes))
+}
+
+// digestTrailerWriter hashes a streamed image and sends its digest as
+// trailers, since the headers are gone before the last byte is known
+type digestTrailerWriter struct {
+	http.ResponseWriter
+	hash hash.Hash
+}
+
+// newDigestTrailerWriter announces the digest trailers; call them before
+// the first write, and finish after the last
+func newDigestTrailerWriter(w http.ResponseWriter) *digestTrailerWriter {
+	w.Header().Add("Trailer", "Repr-Digest")
+	w.Header().Add("Trailer", "Digest")
+	return &digestTrailerWriter{ResponseWriter: w, hash: sha256.New()}
+}
+
+func (dw *digestTrailerWriter) Write(b []byte) (int, error) {
+	n, err := dw.ResponseWriter.Write(b)
+	dw.hash.Write(b[:n])
+	return n, err
+}
+
+// finish sets the trailers
+func (dw *digestTrailerWriter) finish() {
+	setDigestHeaders(dw.Header(), dw.hash.Sum(nil))
+}
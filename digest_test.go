@@ -0,0 +1,127 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParseExpectedDigest(t *testing.T) {
+	sum := sha256.Sum256([]byte("hello"))
+	encoded := base64.StdEncoding.EncodeToString(sum[:])
+
+	tests := []struct {
+		name    string
+		header  string
+		want    bool
+		wantErr bool
+	}{
+		{name: "absent"},
+		{name: "Repr-Digest", header: "sha-256=:" + encoded + ":", want: true},
+		{name: "Digest", header: "SHA-256=" + encoded, want: true},
+		{name: "among others", header: "sha-512=:AAAA:, sha-256=:" + encoded + ":", want: true},
+		{name: "only other algorithms", header: "sha-512=:" + encoded + ":", wantErr: true},
+		{name: "not base64", header: "sha-256=:hello:", wantErr: true},
+		{name: "too short", header: "sha-256=:" + encoded[:20] + ":", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.header != "" {
+				r.Header.Set(expectedDigestHeader, tt.header)
+			}
+			got, apiErr := parseExpectedDigest(r)
+			if (apiErr != nil) != tt.wantErr {
+				t.Fatalf("error = %v, want error %v", apiErr, tt.wantErr)
+			}
+			if (got != nil) != tt.want || tt.want && string(got) != string(sum[:]) {
+				t.Errorf("digest = %x, want %x", got, sum)
+			}
+		})
+	}
+}
+
+func TestGenerate_Digest(t *testing.T) {
+	handler := newTestHandler()
+	cached := newTestDeps()
+	cached.cache = newImageCache(10, 0)
+	cachedHandler := newHandler(cached)
+
+	// The digest of an image, from its headers or, when streamed, trailers
+	digestOf := func(rec *httptest.ResponseRecorder) string {
+		res := rec.Result()
+		if digest := res.Header.Get("Repr-Digest"); digest != "" {
+			return digest
+		}
+		return res.Trailer.Get("Repr-Digest")
+	}
+
+	for name, h := range map[string]http.Handler{"streamed": handler, "cached": cachedHandler} {
+		t.Run(name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v1/qr/generate?text=hello", nil))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d", rec.Code)
+			}
+			sum := sha256.Sum256(rec.Body.Bytes())
+			want := "sha-256=:" + base64.StdEncoding.EncodeToString(sum[:]) + ":"
+			if got := digestOf(rec); got != want {
+				t.Errorf("Repr-Digest = %q, want %q", got, want)
+			}
+		})
+	}
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v1/qr/generate?text=hello", nil))
+	sum := sha256.Sum256(rec.Body.Bytes())
+	encoded := base64.StdEncoding.EncodeToString(sum[:])
+	other := sha256.Sum256([]byte("other"))
+
+	tests := []struct {
+		name       string
+		path       string
+		expected   string
+		encoding   string
+		wantStatus int
+		wantDigest bool
+	}{
+		{name: "expected", path: "/api/v1/qr/generate?text=hello", expected: "sha-256=:" + encoded + ":", wantStatus: http.StatusOK, wantDigest: true},
+		{name: "expected as Digest", path: "/api/v1/qr/generate?text=hello", expected: "SHA-256=" + encoded, wantStatus: http.StatusOK, wantDigest: true},
+		{name: "mismatch", path: "/api/v1/qr/generate?text=hello", expected: "sha-256=:" + base64.StdEncoding.EncodeToString(other[:]) + ":", wantStatus: http.StatusPreconditionFailed},
+		{name: "invalid", path: "/api/v1/qr/generate?text=hello", expected: "md5=:AAAA:", wantStatus: http.StatusBadRequest},
+		{name: "compressed SVG", path: "/api/v1/qr/generate?text=hello&format=svg", encoding: "gzip", wantStatus: http.StatusOK},
+		{name: "barcode", path: "/api/v1/barcode/generate?type=ean13&value=400638133393", wantStatus: http.StatusOK, wantDigest: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, tt.path, nil)
+			if tt.expected != "" {
+				req.Header.Set(expectedDigestHeader, tt.expected)
+			}
+			if tt.encoding != "" {
+				req.Header.Set("Accept-Encoding", tt.encoding)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if got := digestOf(rec); (got != "") != tt.wantDigest {
+				t.Errorf("Repr-Digest = %q, want one %v", got, tt.wantDigest)
+			}
+			if tt.wantDigest && !strings.HasPrefix(rec.Header().Get("Digest"), "SHA-256=") {
+				t.Errorf("Digest = %q", rec.Header().Get("Digest"))
+			}
+			if tt.wantStatus == http.StatusPreconditionFailed {
+				if !strings.Contains(rec.Body.String(), errCodeDigestMismatch) || rec.Header().Get("ETag") != "" {
+					t.Errorf("body %s with ETag %q", rec.Body.String(), rec.Header().Get("ETag"))
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"mime"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// defaultFilename names downloaded images without a filename parameter
+const defaultFilename = "qrcode"
+
+// maxFilenameLength bounds the filename in runes, leaving room for the
+// extension within common file system limits
+const maxFilenameLength = 100
+
+// contentDisposition reads the download and filename parameters into a
+// Content-Disposition header for images in format. download=true makes
+// browsers save the image; a filename alone names the image when saved.
+// Without either it returns "".
+func contentDisposition(query url.Values, format string) (string, *apiError) {
+	download := false
+	if raw := query.Get("download"); raw != "" {
+		var err error
+		if download, err = strconv.ParseBool(raw); err != nil {
+			return "", &apiError{Code: errCodeInvalidParam, Message: "Parameter 'download' must be true or false"}
+		}
+	}
+	filename := query.Get("filename")
+	if !download && filename == "" {
+		return "", nil
+	}
+	disposition := "inline"
+	if download {
+		disposition = "attachment"
+	}
+	return mime.FormatMediaType(disposition, map[string]string{"filename": sanitizeFilename(filename, format)}), nil
+}
+
+// sanitizeFilename reduces a client-chosen name to a safe file name with
+// the extension of format: no directories, no characters that file systems
+// or headers reject, and no leading dots that would hide the file
+func sanitizeFilename(name, format string) string {
+	name = path.Base(strings.ReplaceAll(name, `\`, "/"))
+	name = strings.Map(func(r rune) rune {
+		switch {
+		case r == utf8.RuneError, unicode.IsControl(r), unicode.Is(unicode.Cf, r):
+			return -1
+		case strings.ContainsRune(`<>:"/\|?*`, r):
+			return '_'
+		}
+		return r
+	}, name)
+	name = strings.Trim(name, ". ")
+	if runes := []rune(name); len(runes) > maxFilenameLength {
+		name = strings.TrimRight(string(runes[:maxFilenameLength]), ". ")
+	}
+	if name == "" {
+		name = defaultFilename
+	}
+	if !strings.EqualFold(path.Ext(name), "."+format) {
+		name += "." + format
+	}
+	return name
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestContentDisposition(t *testing.T) {
+	tests := []struct {
+		name    string
+		query   string
+		format  string
+		want    string
+		wantErr bool
+	}{
+		{name: "no parameters", format: formatPNG},
+		{name: "download without filename", query: "download=true", format: formatPNG, want: `attachment; filename=qrcode.png`},
+		{name: "download with filename", query: "download=true&filename=ticket-123.png", format: formatPNG, want: `attachment; filename=ticket-123.png`},
+		{name: "filename only", query: "filename=ticket-123", format: formatSVG, want: `inline; filename=ticket-123.svg`},
+		{name: "download off", query: "download=false", format: formatPNG},
+		{name: "quoted filename", query: "download=1&filename=my ticket.png", format: formatPNG, want: `attachment; filename="my ticket.png"`},
+		{name: "non-ASCII filename", query: "download=true&filename=billet-été.png", format: formatPNG, want: `attachment; filename*=utf-8''billet-%C3%A9t%C3%A9.png`},
+		{name: "invalid download", query: "download=yes", format: formatPNG, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, err := url.ParseQuery(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, apiErr := contentDisposition(query, tt.format)
+			if (apiErr != nil) != tt.wantErr {
+				t.Fatalf("error = %v, want error %v", apiErr, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("contentDisposition() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSanitizeFilename(t *testing.T) {
+	tests := []struct {
+		name   string
+		format string
+		want   string
+	}{
+		{name: "ticket-123.png", format: formatPNG, want: "ticket-123.png"},
+		{name: "ticket-123.PNG", format: formatPNG, want: "ticket-123.PNG"},
+		{name: "ticket-123", format: formatPNG, want: "ticket-123.png"},
+		{name: "ticket-123.png", format: formatSVG, want: "ticket-123.png.svg"},
+		{name: "", format: formatPNG, want: "qrcode.png"},
+		{name: "../../etc/passwd", format: formatPNG, want: "passwd.png"},
+		{name: `..\..\windows\win.ini`, format: formatPNG, want: "win.ini.png"},
+		{name: "..", format: formatPNG, want: "qrcode.png"},
+		{name: ".hidden.png", format: formatPNG, want: "hidden.png"},
+		{name: "a<b>c:d|e?f*g\".png", format: formatPNG, want: "a_b_c_d_e_f_g_.png"},
+		{name: "line\r\nbreak.png", format: formatPNG, want: "linebreak.png"},
+		{name: "gnp.exe\u202e.png", format: formatPNG, want: "gnp.exe.png"},
+		{name: strings.Repeat("é", 150), format: formatPNG, want: strings.Repeat("é", 100) + ".png"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sanitizeFilename(tt.name, tt.format); got != tt.want {
+				t.Errorf("sanitizeFilename(%q) = %q, want %q", tt.name, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGenerate_Download(t *testing.T) {
+	tests := []struct {
+		name            string
+		query           string
+		wantStatus      int
+		wantDisposition string
+	}{
+		{name: "plain", query: "text=hello", wantStatus: http.StatusOK},
+		{name: "download", query: "text=hello&download=true&filename=ticket-123.png", wantStatus: http.StatusOK, wantDisposition: "attachment; filename=ticket-123.png"},
+		{name: "streamed SVG", query: "text=hello&format=svg&download=true", wantStatus: http.StatusOK, wantDisposition: "attachment; filename=qrcode.svg"},
+		{name: "invalid download", query: "text=hello&download=maybe", wantStatus: http.StatusBadRequest},
+		{name: "errors are not downloads", query: "text=javascript:alert(1)&download=true", wantStatus: http.StatusUnprocessableEntity},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			newTestHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v1/qr/generate?"+tt.query, nil))
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if got := rec.Header().Get("Content-Disposition"); got != tt.wantDisposition {
+				t.Errorf("Content-Disposition = %q, want %q", got, tt.wantDisposition)
+			}
+		})
+	}
+}
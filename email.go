@@ -0,0 +1,270 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// errCodeDeliveryFailed reports that a generated code could not be delivered
+const errCodeDeliveryFailed = "delivery_failed"
+
+// Email formats of the deliver.email_format parameter
+const (
+	emailAttachment = "attachment"
+	emailInline     = "inline"
+)
+
+// defaultEmailTemplate is the HTML body used without EMAIL_TEMPLATE_FILE
+const defaultEmailTemplate = `<!DOCTYPE html>
+<html>
+<body>
+<p>Here is your QR code{{if .Text}} for <strong>{{.Text}}</strong>{{end}}.</p>
+{{if .Inline}}<p><img src="{{.ImageSrc}}" alt="QR code" width="256" height="256"></p>{{else}}<p>It is attached to this email.</p>{{end}}
+</body>
+</html>
+`
+
+// emailData is what email templates are rendered with
+type emailData struct {
+	// Text is the encoded content; empty for encrypted payloads
+	Text string
+	// To is the recipient address
+	To string
+	// Inline reports whether the image is embedded in the body
+	Inline bool
+	// ImageSrc references the inline image, for use as an <img> source
+	ImageSrc htmltemplate.URL
+}
+
+// emailSender delivers generated codes by email through an SMTP relay
+type emailSender struct {
+	addr string
+	from mail.Address
+	// username and password authenticate with PLAIN auth; empty disables auth
+	username, password string
+	subject            string
+	body               *htmltemplate.Template
+	// allowedDomains restricts recipients; empty allows any
+	allowedDomains []string
+	// send transmits a message; replaced in tests
+	send func(ctx context.Context, to string, msg []byte) error
+}
+
+// newEmailSender creates a sender relaying through the SMTP server at addr
+// (host:port). It returns nil when addr is empty. The body template is read
+// from templateFile, or the built-in template is used.
+func newEmailSender(addr, username, password, from, subject, templateFile, allowedDomains string) (*emailSender, error) {
+	if addr == "" {
+		return nil, nil
+	}
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		return nil, fmt.Errorf("invalid SMTP address %q: %w", addr, err)
+	}
+	sender, err := mail.ParseAddress(from)
+	if err != nil {
+		return nil, fmt.Errorf("invalid sender address %q: %w", from, err)
+	}
+	text := defaultEmailTemplate
+	if templateFile != "" {
+		data, err := os.ReadFile(templateFile)
+		if err != nil {
+			return nil, fmt.Errorf("read email template: %w", err)
+		}
+		text = string(data)
+	}
+	body, err := htmltemplate.New("email").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("parse email template: %w", err)
+	}
+
+	s := &emailSender{addr: addr, from: *sender, username: username, password: password, subject: subject, body: body}
+	for _, domain := range splitList(allowedDomains) {
+		s.allowedDomains = append(s.allowedDomains, strings.ToLower(domain))
+	}
+	s.send = s.sendSMTP
+	return s, nil
+}
+
+// emailDelivery reads the deliver.email and deliver.email_format parameters.
+// It returns an empty recipient when no email delivery is requested.
+func emailDelivery(query url.Values, sender *emailSender) (to, format string, apiErr *apiError) {
+	raw := query.Get("deliver.email")
+	if raw == "" {
+		return "", "", nil
+	}
+	if sender == nil {
+		return "", "", &apiError{Code: errCodeInvalidParam, Message: "Email delivery is not configured on this server"}
+	}
+	format = query.Get("deliver.email_format")
+	switch format {
+	case "":
+		format = emailAttachment
+	case emailAttachment, emailInline:
+	default:
+		return "", "", &apiError{Code: errCodeInvalidParam, Message: "Parameter 'deliver.email_format' must be 'attachment' or 'inline'"}
+	}
+	if to, apiErr = sender.recipient(raw); apiErr != nil {
+		return "", "", apiErr
+	}
+	return to, format, nil
+}
+
+// recipient parses and checks a recipient address, returning the bare address
+func (s *emailSender) recipient(raw string) (string, *apiError) {
+	addr, err := mail.ParseAddress(raw)
+	if err != nil {
+		return "", &apiError{Code: errCodeInvalidParam, Message: "Parameter 'deliver.email' is not a valid email address"}
+	}
+	domain := strings.ToLower(addr.Address[strings.LastIndex(addr.Address, "@")+1:])
+	if len(s.allowedDomains) > 0 && !slices.Contains(s.allowedDomains, domain) {
+		return "", &apiError{
+			Code:    errCodeInvalidParam,
+			Message: "Email delivery to this domain is not allowed",
+			Details: map[string]any{"domain": domain},
+		}
+	}
+	return addr.Address, nil
+}
+
+// Send emails pngBytes to the recipient, inline in the body or as an attachment
+func (s *emailSender) Send(ctx context.Context, to, text, format string, pngBytes []byte) error {
+	msg, err := s.message(to, text, format, pngBytes)
+	if err != nil {
+		return err
+	}
+	return s.send(ctx, to, msg)
+}
+
+// message builds the MIME message: multipart/related with the image
+// referenced by Content-ID when inline, multipart/mixed otherwise
+func (s *emailSender) message(to, text, format string, pngBytes []byte) ([]byte, error) {
+	inline := format == emailInline
+	cid := randomToken() + "@qr-generator"
+	var html bytes.Buffer
+	if err := s.body.Execute(&html, emailData{Text: text, To: to, Inline: inline, ImageSrc: htmltemplate.URL("cid:" + cid)}); err != nil {
+		return nil, fmt.Errorf("render email template: %w", err)
+	}
+
+	var body bytes.Buffer
+	parts := multipart.NewWriter(&body)
+	contentType := "multipart/mixed"
+	if inline {
+		contentType = `multipart/related; type="text/html"`
+	}
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", s.from.String())
+	fmt.Fprintf(&msg, "To: %s\r\n", to)
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", s.subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&msg, "Message-ID: <%s@qr-generator>\r\n", randomToken())
+	fmt.Fprintf(&msg, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&msg, "Content-Type: %s; boundary=%s\r\n\r\n", contentType, parts.Boundary())
+
+	htmlPart, err := parts.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {"text/html; charset=utf-8"},
+		"Content-Transfer-Encoding": {"base64"},
+	})
+	if err != nil {
+		return nil, err
+	}
+	writeBase64Lines(htmlPart, html.Bytes())
+
+	imageHeader := textproto.MIMEHeader{
+		"Content-Type":              {`image/png; name="qrcode.png"`},
+		"Content-Transfer-Encoding": {"base64"},
+		"Content-Disposition":       {`attachment; filename="qrcode.png"`},
+	}
+	if inline {
+		imageHeader.Set("Content-Disposition", `inline; filename="qrcode.png"`)
+		imageHeader.Set("Content-ID", "<"+cid+">")
+	}
+	imagePart, err := parts.CreatePart(imageHeader)
+	if err != nil {
+		return nil, err
+	}
+	writeBase64Lines(imagePart, pngBytes)
+	if err := parts.Close(); err != nil {
+		return nil, err
+	}
+
+	msg.Write(body.Bytes())
+	return msg.Bytes(), nil
+}
+
+// writeBase64Lines writes data base64-encoded in lines of 76 characters, as
+// MIME requires
+func writeBase64Lines(w io.Writer, data []byte) {
+	encoded := base64.StdEncoding.EncodeToString(data)
+	for len(encoded) > 76 {
+		w.Write([]byte(encoded[:76] + "\r\n"))
+		encoded = encoded[76:]
+	}
+	w.Write([]byte(encoded + "\r\n"))
+}
+
+// sendSMTP delivers msg through the relay, upgrading to TLS when the server
+// offers STARTTLS. The whole exchange is bounded by ctx.
+func (s *emailSender) sendSMTP(ctx context.Context, to string, msg []byte) error {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", s.addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	host, _, _ := net.SplitHostPort(s.addr)
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return fmt.Errorf("starttls: %w", err)
+		}
+	}
+	if s.username != "" {
+		// PlainAuth refuses to send credentials over unencrypted connections
+		// to anything but localhost
+		if err := client.Auth(smtp.PlainAuth("", s.username, s.password, host)); err != nil {
+			return fmt.Errorf("auth: %w", err)
+		}
+	}
+	if err := client.Mail(s.from.Address); err != nil {
+		return err
+	}
+	if err := client.Rcpt(to); err != nil {
+		return err
+	}
+	data, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := data.Write(msg); err != nil {
+		return err
+	}
+	if err := data.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
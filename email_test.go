@@ -0,0 +1,303 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/mail"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// sentEmail is a message captured instead of being sent
+type sentEmail struct {
+	to  string
+	msg []byte
+}
+
+// newTestEmailSender returns a sender capturing messages into sent
+func newTestEmailSender(t *testing.T, allowedDomains string, sent *[]sentEmail) *emailSender {
+	t.Helper()
+	s, err := newEmailSender("smtp.example.com:587", "", "", "QR <qr@example.com>", "Your QR code", "", allowedDomains)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.send = func(ctx context.Context, to string, msg []byte) error {
+		*sent = append(*sent, sentEmail{to: to, msg: msg})
+		return nil
+	}
+	return s
+}
+
+// parseEmail returns the MIME type of a message and its decoded parts
+func parseEmail(t *testing.T, raw []byte) (string, []*multipart.Part, [][]byte) {
+	t.Helper()
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var parts []*multipart.Part
+	var bodies [][]byte
+	reader := multipart.NewReader(msg.Body, params["boundary"])
+	for {
+		part, err := reader.NextRawPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := io.ReadAll(base64.NewDecoder(base64.StdEncoding, part))
+		if err != nil {
+			t.Fatal(err)
+		}
+		parts = append(parts, part)
+		bodies = append(bodies, body)
+	}
+	return mediaType, parts, bodies
+}
+
+func TestEmailDelivery(t *testing.T) {
+	var sent []sentEmail
+	sender := newTestEmailSender(t, "example.com, Example.org", &sent)
+	tests := []struct {
+		name       string
+		query      string
+		sender     *emailSender
+		wantTo     string
+		wantFormat string
+		wantErr    bool
+	}{
+		{name: "not requested", query: "text=x", sender: sender},
+		{name: "default format", query: "deliver.email=Ann+<ann@example.com>", sender: sender, wantTo: "ann@example.com", wantFormat: emailAttachment},
+		{name: "inline", query: "deliver.email=ann@EXAMPLE.ORG&deliver.email_format=inline", sender: sender, wantTo: "ann@EXAMPLE.ORG", wantFormat: emailInline},
+		{name: "not configured", query: "deliver.email=ann@example.com", wantErr: true},
+		{name: "invalid address", query: "deliver.email=not-an-address", sender: sender, wantErr: true},
+		{name: "domain not allowed", query: "deliver.email=ann@example.net", sender: sender, wantErr: true},
+		{name: "invalid format", query: "deliver.email=ann@example.com&deliver.email_format=pdf", sender: sender, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, _ := url.ParseQuery(tt.query)
+			to, format, apiErr := emailDelivery(query, tt.sender)
+			if (apiErr != nil) != tt.wantErr {
+				t.Fatalf("error = %v, want error %v", apiErr, tt.wantErr)
+			}
+			if to != tt.wantTo || format != tt.wantFormat {
+				t.Errorf("delivery = (%q, %q), want (%q, %q)", to, format, tt.wantTo, tt.wantFormat)
+			}
+		})
+	}
+}
+
+func TestEmailSender_Message(t *testing.T) {
+	tests := []struct {
+		name          string
+		format        string
+		wantType      string
+		wantImageDisp string
+	}{
+		{name: "attachment", format: emailAttachment, wantType: "multipart/mixed", wantImageDisp: "attachment"},
+		{name: "inline", format: emailInline, wantType: "multipart/related", wantImageDisp: "inline"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var sent []sentEmail
+			sender := newTestEmailSender(t, "", &sent)
+			png := bytes.Repeat([]byte{0x89, 'P', 'N', 'G'}, 100)
+			if err := sender.Send(context.Background(), "ann@example.com", "https://example.com/?a=1&b=<2>", tt.format, png); err != nil {
+				t.Fatal(err)
+			}
+
+			mediaType, parts, bodies := parseEmail(t, sent[0].msg)
+			if mediaType != tt.wantType || len(parts) != 2 {
+				t.Fatalf("message is %s with %d parts, want %s with 2", mediaType, len(parts), tt.wantType)
+			}
+			html := string(bodies[0])
+			if !strings.Contains(html, "https://example.com/?a=1&amp;b=&lt;2&gt;") {
+				t.Errorf("body does not contain the escaped text: %s", html)
+			}
+			if !bytes.Equal(bodies[1], png) {
+				t.Error("image part does not decode to the PNG")
+			}
+			if disposition, _, _ := mime.ParseMediaType(parts[1].Header.Get("Content-Disposition")); disposition != tt.wantImageDisp {
+				t.Errorf("image disposition = %q, want %q", disposition, tt.wantImageDisp)
+			}
+			if tt.format == emailInline {
+				cid := strings.Trim(parts[1].Header.Get("Content-ID"), "<>")
+				if cid == "" || !strings.Contains(html, `src="cid:`+cid+`"`) {
+					t.Errorf("body does not reference the inline image %q: %s", cid, html)
+				}
+			}
+		})
+	}
+}
+
+func TestEmailSender_Template(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "email.html")
+	if err := os.WriteFile(file, []byte(`<p>Hi {{.To}}: {{.Text}}</p>`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	sender, err := newEmailSender("smtp.example.com:25", "", "", "qr@example.com", "QR", file, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := sender.message("ann@example.com", "hello", emailAttachment, []byte("png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, bodies := parseEmail(t, msg); string(bodies[0]) != "<p>Hi ann@example.com: hello</p>" {
+		t.Errorf("body = %q", bodies[0])
+	}
+
+	if _, err := newEmailSender("smtp.example.com:25", "", "", "qr@example.com", "QR", filepath.Join(t.TempDir(), "missing"), ""); err == nil {
+		t.Error("missing template file accepted")
+	}
+	if _, err := newEmailSender("smtp.example.com", "", "", "qr@example.com", "QR", "", ""); err == nil {
+		t.Error("SMTP address without port accepted")
+	}
+}
+
+// serveSMTP answers one SMTP session on ln and returns the recipient and data
+func serveSMTP(t *testing.T, ln net.Listener) <-chan [2]string {
+	t.Helper()
+	result := make(chan [2]string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+		reply("220 test ESMTP")
+		var rcpt, data string
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			cmd := strings.ToUpper(strings.TrimSpace(line))
+			switch {
+			case strings.HasPrefix(cmd, "EHLO"):
+				reply("250 test")
+			case strings.HasPrefix(cmd, "MAIL FROM"):
+				reply("250 ok")
+			case strings.HasPrefix(cmd, "RCPT TO"):
+				rcpt = strings.TrimSpace(line[len("RCPT TO:"):])
+				reply("250 ok")
+			case cmd == "DATA":
+				reply("354 go ahead")
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil || line == ".\r\n" {
+						break
+					}
+					data += line
+				}
+				reply("250 queued")
+			case cmd == "QUIT":
+				reply("221 bye")
+				result <- [2]string{rcpt, data}
+				return
+			default:
+				reply("502 unsupported")
+			}
+		}
+	}()
+	return result
+}
+
+func TestEmailSender_SendSMTP(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	received := serveSMTP(t, ln)
+
+	sender, err := newEmailSender(ln.Addr().String(), "", "", "qr@example.com", "Your QR code", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := sender.Send(ctx, "ann@example.com", "hello", emailAttachment, []byte("png")); err != nil {
+		t.Fatal(err)
+	}
+
+	got := <-received
+	if got[0] != "<ann@example.com>" {
+		t.Errorf("recipient = %q", got[0])
+	}
+	if !strings.Contains(got[1], "Subject: Your QR code") || !strings.Contains(got[1], "To: ann@example.com") {
+		t.Errorf("data is missing headers:\n%s", got[1])
+	}
+}
+
+func TestGenerate_DeliverEmail(t *testing.T) {
+	tests := []struct {
+		name       string
+		query      string
+		sendErr    error
+		wantStatus int
+		wantSent   bool
+	}{
+		{name: "delivered", query: "text=hello&deliver.email=ann@example.com", wantStatus: http.StatusOK, wantSent: true},
+		{name: "domain not allowed", query: "text=hello&deliver.email=ann@example.net", wantStatus: http.StatusBadRequest},
+		{name: "relay failure", query: "text=hello&deliver.email=ann@example.com", sendErr: errors.New("550 rejected"), wantStatus: http.StatusBadGateway, wantSent: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var sent []sentEmail
+			deps := newTestDeps()
+			deps.email = newTestEmailSender(t, "example.com", &sent)
+			capture := deps.email.send
+			deps.email.send = func(ctx context.Context, to string, msg []byte) error {
+				capture(ctx, to, msg)
+				return tt.sendErr
+			}
+
+			rec := httptest.NewRecorder()
+			newHandler(deps).ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v1/qr/generate?"+tt.query, nil))
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if (len(sent) == 1) != tt.wantSent {
+				t.Fatalf("%d emails sent, want sent %v", len(sent), tt.wantSent)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			var body struct {
+				Delivered map[string]string `json:"delivered"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || body.Delivered["email"] != "ann@example.com" {
+				t.Errorf("body = %s (%v), want the delivery", rec.Body.String(), err)
+			}
+			if _, _, bodies := parseEmail(t, sent[0].msg); !bytes.HasPrefix(bodies[1], []byte("\x89PNG")) {
+				t.Error("email does not carry the PNG")
+			}
+		})
+	}
+}
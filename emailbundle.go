@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	htmltemplate "html/template"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"strconv"
+)
+
+// responseEmail is the response parameter value of email bundles
+const responseEmail = "email"
+
+// maxEmailImageWidth is the widest the snippet displays a code: the usual
+// width of email layouts. Larger images are scaled down, staying sharp on
+// high-density screens.
+const maxEmailImageWidth = 600
+
+// emailSnippet is the HTML of email bundles. Email clients ignore style
+// sheets and classes, so it only uses attributes and inline styles they
+// support.
+var emailSnippet = htmltemplate.Must(htmltemplate.New("snippet").Parse(
+	`<img src="{{.Src}}" alt="QR code" width="{{.Width}}" height="{{.Width}}" style="display:block;width:{{.Width}}px;height:{{.Width}}px;border:0;outline:none;text-decoration:none">`))
+
+// parseEmailBundle reads the response parameter, reporting whether the code
+// is wanted as an email bundle rather than an image
+func parseEmailBundle(query url.Values, opts renderOptions) (bool, *apiError) {
+	switch query.Get("response") {
+	case "", "image":
+		return false, nil
+	case responseEmail:
+	default:
+		return false, &apiError{Code: errCodeInvalidParam, Message: "Parameter 'response' must be image or email"}
+	}
+	// Common email clients block SVG images
+	if opts.format != formatPNG {
+		return false, &apiError{Code: errCodeInvalidParam, Message: "Email bundles contain PNG images; parameter 'format' must be png"}
+	}
+	return true, nil
+}
+
+// writeEmailBundle serves the code as multipart/related: an HTML snippet
+// whose image references the PNG part by Content-ID, ready to be put into
+// an email as is. Parts are base64-encoded in 76-character lines, as MIME
+// requires.
+func writeEmailBundle(w http.ResponseWriter, pngBytes []byte, opts renderOptions) error {
+	cid := randomToken() + "@qr-generator"
+	var html bytes.Buffer
+	if err := emailSnippet.Execute(&html, struct {
+		Src   htmltemplate.URL
+		Width int
+	}{htmltemplate.URL("cid:" + cid), min(opts.size, maxEmailImageWidth)}); err != nil {
+		return err
+	}
+
+	var body bytes.Buffer
+	parts := multipart.NewWriter(&body)
+	htmlPart, err := parts.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {"text/html; charset=utf-8"},
+		"Content-Transfer-Encoding": {"base64"},
+	})
+	if err != nil {
+		return err
+	}
+	writeBase64Lines(htmlPart, html.Bytes())
+	imagePart, err := parts.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {`image/png; name="qrcode.png"`},
+		"Content-Transfer-Encoding": {"base64"},
+		"Content-Disposition":       {`inline; filename="qrcode.png"`},
+		"Content-ID":                {"<" + cid + ">"},
+	})
+	if err != nil {
+		return err
+	}
+	writeBase64Lines(imagePart, pngBytes)
+	if err := parts.Close(); err != nil {
+		return err
+	}
+
+	w.Header().Set("Content-Type", `multipart/related; type="text/html"; boundary=`+parts.Boundary())
+	w.Header().Set("Content-Length", strconv.Itoa(body.Len()))
+	// The Content-ID is random, so bundles are never reused
+	w.Header().Set("Cache-Control", "no-store")
+	_, err = w.Write(body.Bytes())
+	return err
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image/png"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestGenerate_EmailBundle(t *testing.T) {
+	tests := []struct {
+		name       string
+		query      string
+		wantStatus int
+		wantWidth  string
+		wantSize   int
+	}{
+		{name: "default size", query: "text=hello&response=email", wantStatus: http.StatusOK, wantWidth: "256", wantSize: 256},
+		{name: "large image is scaled down", query: "text=hello&response=email&size=1024", wantStatus: http.StatusOK, wantWidth: "600", wantSize: 1024},
+		{name: "image response", query: "text=hello&response=image", wantStatus: http.StatusOK},
+		{name: "unknown response", query: "text=hello&response=html", wantStatus: http.StatusBadRequest},
+		{name: "SVG", query: "text=hello&response=email&format=svg", wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			newTestHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v1/qr/generate?"+tt.query, nil))
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if tt.wantSize == 0 {
+				return
+			}
+
+			mediaType, params, err := mime.ParseMediaType(rec.Header().Get("Content-Type"))
+			if err != nil || mediaType != "multipart/related" || params["type"] != "text/html" {
+				t.Fatalf("content type = %q", rec.Header().Get("Content-Type"))
+			}
+			if got := rec.Header().Get("Cache-Control"); got != "no-store" {
+				t.Errorf("Cache-Control = %q, want no-store", got)
+			}
+			for _, line := range strings.Split(rec.Body.String(), "\r\n") {
+				if len(line) > 78 {
+					t.Fatalf("line of %d characters exceeds the MIME limit", len(line))
+				}
+			}
+
+			reader := multipart.NewReader(rec.Body, params["boundary"])
+			htmlPart, err := reader.NextPart()
+			if err != nil {
+				t.Fatal(err)
+			}
+			html := decodeBase64Part(t, htmlPart)
+			imagePart, err := reader.NextPart()
+			if err != nil {
+				t.Fatal(err)
+			}
+			cid := strings.Trim(imagePart.Header.Get("Content-ID"), "<>")
+			if imagePart.Header.Get("Content-Type") != `image/png; name="qrcode.png"` || cid == "" {
+				t.Fatalf("image part header = %v", imagePart.Header)
+			}
+			img, err := png.Decode(bytes.NewReader(decodeBase64Part(t, imagePart)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := img.Bounds().Dx(); got != tt.wantSize {
+				t.Errorf("image width = %d, want %d", got, tt.wantSize)
+			}
+
+			want := regexp.MustCompile(`^<img src="cid:` + regexp.QuoteMeta(cid) + `" alt="QR code" width="` + tt.wantWidth + `" height="` + tt.wantWidth + `" style="display:block;`)
+			if !want.Match(html) {
+				t.Errorf("snippet = %s, want the image of %s pixels by Content-ID %s", html, tt.wantWidth, cid)
+			}
+			if _, err := reader.NextPart(); err != io.EOF {
+				t.Errorf("bundle has more than two parts: %v", err)
+			}
+		})
+	}
+}
+
+// decodeBase64Part reads a base64-encoded MIME part
+func decodeBase64Part(t *testing.T, part *multipart.Part) []byte {
+	t.Helper()
+	if got := part.Header.Get("Content-Transfer-Encoding"); got != "base64" {
+		t.Fatalf("transfer encoding = %q, want base64", got)
+	}
+	data, err := io.ReadAll(base64.NewDecoder(base64.StdEncoding, part))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
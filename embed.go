@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Themes of the embed page: the page background around the code. The code
+// itself keeps its light quiet zone in every theme, so it stays scannable.
+const (
+	embedThemeAuto  = "auto"
+	embedThemeLight = "light"
+	embedThemeDark  = "dark"
+)
+
+// embedStyle is the only styling of the embed page; the code scales to the
+// frame
+const embedStyle = `html,body{margin:0;height:100%}` +
+	`body{display:flex;align-items:center;justify-content:center;background:#fff}` +
+	`body.dark{background:#0d1117}` +
+	`@media (prefers-color-scheme:dark){body.auto{background:#0d1117}}` +
+	`.code{display:flex;width:100vmin;height:100vmin}` +
+	`.code svg{width:100%;height:100%}`
+
+// embedPage renders the code inline, so the page needs no scripts and no
+// further requests
+var embedPage = template.Must(template.New("embed").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>QR code</title>
+<style>{{.Style}}</style>
+</head>
+<body class="{{.Theme}}">
+<div class="code" role="img" aria-label="QR code for {{.Text}}">{{.SVG}}</div>
+</body>
+</html>
+`))
+
+// embedWidget serves /embed, a minimal page showing the code of its text
+// parameter that other internal tools put in an iframe instead of handling
+// images themselves
+type embedWidget struct {
+	contentSecurityPolicy string
+}
+
+// newEmbedWidget enables the embed page for the comma-separated origins
+// allowed to frame it ("*" or "https://*.example.com" allowed). It returns
+// nil when origins is empty.
+func newEmbedWidget(origins string) (*embedWidget, error) {
+	list := splitList(origins)
+	if len(list) == 0 {
+		return nil, nil
+	}
+	for _, origin := range list {
+		if origin == "*" {
+			continue
+		}
+		u, err := url.Parse(origin)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.Path != "" || u.RawQuery != "" || strings.ContainsAny(origin, " ;,'") {
+			return nil, fmt.Errorf("EMBED_FRAME_ANCESTORS must list http(s) origins, got %q", origin)
+		}
+	}
+	styleHash := sha256.Sum256([]byte(embedStyle))
+	return &embedWidget{
+		contentSecurityPolicy: "default-src 'none'; style-src 'sha256-" + base64.StdEncoding.EncodeToString(styleHash[:]) + "'; " +
+			"frame-ancestors " + strings.Join(list, " ") + "; form-action 'none'; base-uri 'none'",
+	}, nil
+}
+
+// handleEmbed renders the page for the text, theme, and image options of
+// the generate endpoint. The code is always rendered as SVG.
+func (e *embedWidget) handleEmbed(deps handlerDeps) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		text := query.Get("text")
+		if text == "" {
+			writeAPIError(w, r, http.StatusBadRequest, apiError{Code: errCodeInvalidParam, Message: "Missing required parameter 'text'"})
+			return
+		}
+		theme := query.Get("theme")
+		switch theme {
+		case "":
+			theme = embedThemeAuto
+		case embedThemeAuto, embedThemeLight, embedThemeDark:
+		default:
+			writeAPIError(w, r, http.StatusBadRequest, apiError{
+				Code:    errCodeInvalidParam,
+				Message: "Parameter 'theme' must be auto, light, or dark",
+				Details: map[string]any{"theme": theme},
+			})
+			return
+		}
+		if format := query.Get("format"); format != "" && format != formatSVG {
+			writeAPIError(w, r, http.StatusBadRequest, apiError{Code: errCodeInvalidParam, Message: "The embed page renders SVG only; omit parameter 'format'"})
+			return
+		}
+		// The page's policy blocks style elements inside the code; the theme
+		// styles the page instead
+		if query.Has("dark_mode") {
+			writeAPIError(w, r, http.StatusBadRequest, apiError{Code: errCodeInvalidParam, Message: "The embed page follows the color scheme with parameter 'theme'; omit parameter 'dark_mode'"})
+			return
+		}
+		query, status, apiErr := deps.presets.applyPreset(r.Context(), query)
+		if apiErr != nil {
+			writeAPIError(w, r, status, *apiErr)
+			return
+		}
+		query.Set("format", formatSVG)
+		opts, apiErr := parseRenderOptions(query)
+		if apiErr != nil {
+			writeAPIError(w, r, http.StatusBadRequest, *apiErr)
+			return
+		}
+		setAuditPayload(r.Context(), text, nil)
+		svg, apiErr := renderTextWith(r.Context(), deps, text, opts)
+		if apiErr != nil {
+			writeAPIError(w, r, jobErrorStatus(apiErr), *apiErr)
+			return
+		}
+
+		var page bytes.Buffer
+		embedPage.Execute(&page, struct {
+			Style template.CSS
+			Theme string
+			Text  string
+			SVG   template.HTML
+		}{embedStyle, theme, text, template.HTML(svg)})
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Content-Security-Policy", e.contentSecurityPolicy)
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Write(page.Bytes())
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestNewEmbedWidget(t *testing.T) {
+	tests := []struct {
+		name               string
+		origins            string
+		wantNil            bool
+		wantErr            bool
+		wantFrameAncestors string
+	}{
+		{name: "unset", wantNil: true},
+		{name: "origins", origins: "https://wiki.example.com, https://*.intranet.example.com", wantFrameAncestors: "frame-ancestors https://wiki.example.com https://*.intranet.example.com;"},
+		{name: "any", origins: "*", wantFrameAncestors: "frame-ancestors *;"},
+		{name: "path", origins: "https://wiki.example.com/page", wantErr: true},
+		{name: "no scheme", origins: "wiki.example.com", wantErr: true},
+		{name: "keyword", origins: "'self'", wantErr: true},
+		{name: "directive injection", origins: "https://a.example.com;script-src", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, err := newEmbedWidget(tt.origins)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, want error %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if (e == nil) != tt.wantNil {
+				t.Fatalf("widget = %v, want nil %v", e, tt.wantNil)
+			}
+			if e != nil && !strings.Contains(e.contentSecurityPolicy, tt.wantFrameAncestors) {
+				t.Errorf("Content-Security-Policy = %q, want %q", e.contentSecurityPolicy, tt.wantFrameAncestors)
+			}
+		})
+	}
+}
+
+func TestEmbed(t *testing.T) {
+	tests := []struct {
+		name       string
+		disabled   bool
+		query      string
+		wantStatus int
+		wantBody   []string
+	}{
+		{name: "disabled", disabled: true, query: "text=hello", wantStatus: http.StatusNotFound},
+		{name: "default theme", query: "text=hello", wantStatus: http.StatusOK, wantBody: []string{`<body class="auto">`, `aria-label="QR code for hello"`, `<svg xmlns="http://www.w3.org/2000/svg"`}},
+		{name: "dark theme with options", query: "text=hello&theme=dark&ec=high&fg=%23003366", wantStatus: http.StatusOK, wantBody: []string{`<body class="dark">`, `fill="#003366"`}},
+		{name: "text is escaped", query: "text=%3Cscript%3Ealert(1)%3C%2Fscript%3E", wantStatus: http.StatusOK, wantBody: []string{"QR code for &lt;script&gt;alert(1)&lt;/script&gt;"}},
+		{name: "missing text", query: "theme=dark", wantStatus: http.StatusBadRequest},
+		{name: "unknown theme", query: "text=hello&theme=neon", wantStatus: http.StatusBadRequest},
+		{name: "PNG format", query: "text=hello&format=png", wantStatus: http.StatusBadRequest},
+		{name: "invalid option", query: "text=hello&size=1", wantStatus: http.StatusBadRequest},
+		{name: "dark mode SVG", query: "text=hello&dark_mode=true", wantStatus: http.StatusBadRequest},
+		{name: "denied scheme", query: "text=javascript:alert(1)", wantStatus: http.StatusUnprocessableEntity},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deps := newTestDeps()
+			if !tt.disabled {
+				embed, err := newEmbedWidget("https://wiki.example.com")
+				if err != nil {
+					t.Fatal(err)
+				}
+				deps.embed = embed
+			}
+			rec := httptest.NewRecorder()
+			newHandler(deps).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/embed?"+tt.query, nil))
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if rec.Code != http.StatusOK {
+				return
+			}
+			if got := rec.Header().Get("Content-Type"); got != "text/html; charset=utf-8" {
+				t.Errorf("content type = %q", got)
+			}
+			body := rec.Body.String()
+			for _, want := range tt.wantBody {
+				if !strings.Contains(body, want) {
+					t.Errorf("body lacks %q: %.300s", want, body)
+				}
+			}
+
+			// The policy allows the page's style element and nothing else
+			csp := rec.Header().Get("Content-Security-Policy")
+			style := regexp.MustCompile(`<style>(.*)</style>`).FindStringSubmatch(body)
+			if style == nil {
+				t.Fatal("page has no style element")
+			}
+			hash := sha256.Sum256([]byte(style[1]))
+			if !strings.Contains(csp, "style-src 'sha256-"+base64.StdEncoding.EncodeToString(hash[:])+"'") {
+				t.Errorf("Content-Security-Policy = %q does not allow the style element", csp)
+			}
+			if !strings.Contains(csp, "default-src 'none'") || strings.Contains(body, "<script") {
+				t.Errorf("page may run scripts: %q", csp)
+			}
+		})
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+// encryptionKeyHeader carries the caller's base64 AES key. A header keeps the
+// key out of URLs, access logs, and proxy logs.
+const encryptionKeyHeader = "X-Encryption-Key"
+
+// encryptedPrefix marks encrypted payloads and versions their format:
+// "qrenc1:" + base64url(nonce || AES-GCM ciphertext)
+const encryptedPrefix = "qrenc1:"
+
+// Error codes of the encryption options
+const (
+	errCodeInvalidKey       = "invalid_encryption_key"
+	errCodeDecryptionFailed = "decryption_failed"
+)
+
+var errDecryptionFailed = errors.New("payload could not be decrypted with this key")
+
+// parseEncryptionKey decodes a base64 (standard or URL-safe) AES-128/192/256 key
+func parseEncryptionKey(encoded string) ([]byte, error) {
+	encoded = strings.TrimRight(strings.TrimSpace(encoded), "=")
+	key, err := base64.RawStdEncoding.DecodeString(encoded)
+	if err != nil {
+		if key, err = base64.RawURLEncoding.DecodeString(encoded); err != nil {
+			return nil, errors.New("key must be base64 encoded")
+		}
+	}
+	switch len(key) {
+	case 16, 24, 32:
+		return key, nil
+	}
+	return nil, fmt.Errorf("key must be 16, 24, or 32 bytes, got %d", len(key))
+}
+
+// newGCM creates an AES-GCM cipher for key
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// encryptPayload encrypts text with AES-GCM under a random nonce
+func encryptPayload(key []byte, text string) (string, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	sealed := gcm.Seal(nonce, nonce, []byte(text), nil)
+	return encryptedPrefix + base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+// decryptPayload reverses encryptPayload. Any wrong key, tampering, or
+// malformed input yields errDecryptionFailed.
+func decryptPayload(key []byte, payload string) (string, error) {
+	encoded, ok := strings.CutPrefix(payload, encryptedPrefix)
+	if !ok {
+		return "", fmt.Errorf("payload must start with %q", encryptedPrefix)
+	}
+	sealed, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", errDecryptionFailed
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return "", errDecryptionFailed
+	}
+	plain, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil)
+	if err != nil {
+		return "", errDecryptionFailed
+	}
+	return string(plain), nil
+}
+
+// requestEncryptionKey returns the key from the X-Encryption-Key header, or
+// nil when the header is absent
+func requestEncryptionKey(r *http.Request) ([]byte, *apiError) {
+	encoded := r.Header.Get(encryptionKeyHeader)
+	if encoded == "" {
+		return nil, nil
+	}
+	key, err := parseEncryptionKey(encoded)
+	if err != nil {
+		return nil, &apiError{Code: errCodeInvalidKey, Message: "Header " + encryptionKeyHeader + ": " + err.Error()}
+	}
+	return key, nil
+}
+
+// handleDecode decrypts a scanned encrypted payload posted as {"payload": "qrenc1:..."}
+// with the key in the X-Encryption-Key header and returns {"text": "..."}
+func handleDecode(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var body struct {
+		Payload string `json:"payload"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Payload == "" {
+		writeAPIError(w, r, http.StatusBadRequest, apiError{
+			Code:    errCodeInvalidPayload,
+			Message: `Invalid body. Usage: POST /api/v1/qr/decode with {"payload": "qrenc1:..."} and an ` + encryptionKeyHeader + " header",
+		})
+		return
+	}
+	setAuditPayload(r.Context(), body.Payload, nil)
+
+	key, apiErr := requestEncryptionKey(r)
+	if apiErr == nil && key == nil {
+		apiErr = &apiError{Code: errCodeInvalidKey, Message: "Missing " + encryptionKeyHeader + " header"}
+	}
+	if apiErr != nil {
+		writeAPIError(w, r, http.StatusBadRequest, *apiErr)
+		return
+	}
+
+	text, err := decryptPayload(key, body.Payload)
+	if err != nil {
+		code := errCodeInvalidPayload
+		if errors.Is(err, errDecryptionFailed) {
+			code = errCodeDecryptionFailed
+		}
+		slog.InfoContext(r.Context(), "payload decryption failed", "error", err)
+		writeAPIError(w, r, http.StatusBadRequest, apiError{Code: code, Message: err.Error()})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(map[string]string{"text": text})
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestEncryptPayload_RoundTrip(t *testing.T) {
+	key := []byte("0123456789abcdef0123456789abcdef")
+	otherKey := []byte("fedcba9876543210fedcba9876543210")
+
+	payload, err := encryptPayload(key, "ticket:4711")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(payload, encryptedPrefix) || strings.Contains(payload, "4711") {
+		t.Fatalf("encryptPayload() = %q, want an opaque %s payload", payload, encryptedPrefix)
+	}
+	if again, _ := encryptPayload(key, "ticket:4711"); again == payload {
+		t.Error("encryptPayload() reused a nonce")
+	}
+
+	tampered := payload[:len(payload)-2] + "AA"
+	tests := []struct {
+		name    string
+		key     []byte
+		payload string
+		want    string
+		wantErr bool
+	}{
+		{name: "right key", key: key, payload: payload, want: "ticket:4711"},
+		{name: "wrong key", key: otherKey, payload: payload, wantErr: true},
+		{name: "tampered", key: key, payload: tampered, wantErr: true},
+		{name: "missing prefix", key: key, payload: "ticket:4711", wantErr: true},
+		{name: "too short", key: key, payload: encryptedPrefix + "AAAA", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decryptPayload(tt.key, tt.payload)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("decryptPayload() expected error but got none")
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("decryptPayload() = %q, %v, want %q", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseEncryptionKey(t *testing.T) {
+	tests := []struct {
+		name    string
+		encoded string
+		wantErr bool
+	}{
+		{name: "standard base64", encoded: base64.StdEncoding.EncodeToString([]byte("0123456789abcdef"))},
+		{name: "url-safe unpadded", encoded: base64.RawURLEncoding.EncodeToString([]byte("0123456789abcdef01234567"))},
+		{name: "256-bit", encoded: base64.StdEncoding.EncodeToString([]byte("0123456789abcdef0123456789abcdef"))},
+		{name: "wrong length", encoded: base64.StdEncoding.EncodeToString([]byte("short")), wantErr: true},
+		{name: "not base64", encoded: "not base64!", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseEncryptionKey(tt.encoded)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseEncryptionKey() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestEncryptedGenerateAndDecode(t *testing.T) {
+	key := base64.StdEncoding.EncodeToString([]byte("0123456789abcdef"))
+	sealed, err := encryptPayload([]byte("0123456789abcdef"), "ticket:4711")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		flags    string
+		path     string
+		body     string
+		key      string
+		wantCode int
+		wantText string
+	}{
+		{name: "generate encrypted", path: "/api/v1/qr/generate?text=ticket:4711", key: key, wantCode: http.StatusOK},
+		{name: "generate with invalid key", path: "/api/v1/qr/generate?text=ticket:4711", key: "bad", wantCode: http.StatusBadRequest},
+		{name: "decode disabled", flags: "", path: "/api/v1/qr/decode", body: `{"payload":"` + sealed + `"}`, key: key, wantCode: http.StatusNotFound},
+		{name: "decode", flags: "decode", path: "/api/v1/qr/decode", body: `{"payload":"` + sealed + `"}`, key: key, wantCode: http.StatusOK, wantText: "ticket:4711"},
+		{name: "decode wrong key", flags: "decode", path: "/api/v1/qr/decode", body: `{"payload":"` + sealed + `"}`, key: base64.StdEncoding.EncodeToString([]byte("fedcba9876543210")), wantCode: http.StatusBadRequest},
+		{name: "decode missing key", flags: "decode", path: "/api/v1/qr/decode", body: `{"payload":"` + sealed + `"}`, wantCode: http.StatusBadRequest},
+		{name: "decode invalid body", flags: "decode", path: "/api/v1/qr/decode", body: `{}`, key: key, wantCode: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deps := newTestDeps()
+			deps.flags = mustFeatureFlags(tt.flags)
+
+			req := httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(tt.body))
+			if tt.key != "" {
+				req.Header.Set(encryptionKeyHeader, tt.key)
+			}
+			rec := httptest.NewRecorder()
+			newHandler(deps).ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantCode, rec.Body.String())
+			}
+			if tt.wantText != "" {
+				var resp struct{ Text string }
+				if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil || resp.Text != tt.wantText {
+					t.Errorf("decoded text = %q (err %v), want %q", resp.Text, err, tt.wantText)
+				}
+			}
+		})
+	}
+}
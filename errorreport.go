@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+	"go.opentelemetry.io/otel/exporters/otlp/otlplog/otlploghttp"
+	otellog "go.opentelemetry.io/otel/log"
+	sdklog "go.opentelemetry.io/otel/sdk/log"
+)
+
+// errorEvent describes a panic or server error to report
+type errorEvent struct {
+	Err     error
+	Panic   bool
+	Stack   []byte
+	Status  int
+	Request *http.Request
+}
+
+// errorReporter forwards server errors to an external error tracking backend
+type errorReporter interface {
+	Report(ctx context.Context, event errorEvent)
+	Shutdown(ctx context.Context) error
+}
+
+// multiReporter fans out events to every configured backend
+type multiReporter []errorReporter
+
+func (m multiReporter) Report(ctx context.Context, event errorEvent) {
+	for _, reporter := range m {
+		reporter.Report(ctx, event)
+	}
+}
+
+func (m multiReporter) Shutdown(ctx context.Context) error {
+	var errs []error
+	for _, reporter := range m {
+		errs = append(errs, reporter.Shutdown(ctx))
+	}
+	return errors.Join(errs...)
+}
+
+// newErrorReporter creates reporters for the configured backends (Sentry and/or OTLP logs).
+// With no backend configured the returned reporter is a no-op.
+func newErrorReporter(ctx context.Context, cfg Config) (errorReporter, error) {
+	var reporters multiReporter
+
+	if cfg.SentryDSN != "" {
+		err := sentry.Init(sentry.ClientOptions{
+			Dsn:         cfg.SentryDSN,
+			Environment: cfg.SentryEnvironment,
+			Release:     version,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize Sentry: %w", err)
+		}
+		reporters = append(reporters, sentryReporter{})
+	}
+
+	if cfg.OTLPLogsEndpoint != "" {
+		opts := []otlploghttp.Option{otlploghttp.WithEndpointURL(cfg.OTLPLogsEndpoint)}
+		if cfg.OTLPInsecure {
+			opts = append(opts, otlploghttp.WithInsecure())
+		}
+		exporter, err := otlploghttp.New(ctx, opts...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create OTLP log exporter: %w", err)
+		}
+		provider := sdklog.NewLoggerProvider(sdklog.WithProcessor(sdklog.NewBatchProcessor(exporter)))
+		reporters = append(reporters, &otlpLogReporter{provider: provider, logger: provider.Logger(tracerName)})
+	}
+
+	return reporters, nil
+}
+
+// sentryReporter captures events with the global Sentry client
+type sentryReporter struct{}
+
+func (sentryReporter) Report(ctx context.Context, event errorEvent) {
+	hub := sentry.CurrentHub().Clone()
+	hub.ConfigureScope(func(scope *sentry.Scope) {
+		if event.Request != nil {
+			scope.SetRequest(event.Request)
+		}
+		if id := requestIDFromContext(ctx); id != "" {
+			scope.SetTag("request_id", id)
+		}
+		scope.SetTag("status", fmt.Sprint(event.Status))
+		if event.Panic {
+			scope.SetLevel(sentry.LevelFatal)
+			scope.SetExtra("stack", string(event.Stack))
+		}
+	})
+	hub.CaptureException(event.Err)
+}
+
+func (sentryReporter) Shutdown(ctx context.Context) error {
+	timeout := 2 * time.Second
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout = time.Until(deadline)
+	}
+	sentry.Flush(timeout)
+	return nil
+}
+
+// otlpLogReporter emits error log records over OTLP, correlated with the active trace
+type otlpLogReporter struct {
+	provider *sdklog.LoggerProvider
+	logger   otellog.Logger
+}
+
+func (o *otlpLogReporter) Report(ctx context.Context, event errorEvent) {
+	var record otellog.Record
+	record.SetTimestamp(time.Now())
+	record.SetSeverity(otellog.SeverityError)
+	record.SetSeverityText("ERROR")
+	record.SetBody(otellog.StringValue(event.Err.Error()))
+	record.AddAttributes(
+		otellog.String("exception.type", fmt.Sprintf("%T", errors.Unwrap(event.Err))),
+		otellog.String("exception.message", event.Err.Error()),
+		otellog.Bool("exception.panic", event.Panic),
+		otellog.Int("http.response.status_code", event.Status),
+	)
+	if len(event.Stack) > 0 {
+		record.AddAttributes(otellog.String("exception.stacktrace", string(event.Stack)))
+	}
+	if event.Request != nil {
+		record.AddAttributes(
+			otellog.String("http.request.method", event.Request.Method),
+			otellog.String("url.path", event.Request.URL.Path),
+		)
+	}
+	if id := requestIDFromContext(ctx); id != "" {
+		record.AddAttributes(otellog.String("request.id", id))
+	}
+	o.logger.Emit(ctx, record)
+}
+
+func (o *otlpLogReporter) Shutdown(ctx context.Context) error {
+	return o.provider.Shutdown(ctx)
+}
+
+// requestErrorKey is the context key for the handler-provided error of a request
+type requestErrorKey struct{}
+
+// setRequestError records the cause of a 5xx response so it is reported with the event
+func setRequestError(ctx context.Context, err error) {
+	if holder, ok := ctx.Value(requestErrorKey{}).(*error); ok {
+		*holder = err
+	}
+}
+
+// recoveryMiddleware turns handler panics into 500 responses instead of crashing the
+// process, and reports panics and 5xx responses (with stack traces and request context)
+func recoveryMiddleware(reporter errorReporter, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var handlerErr error
+		r = r.WithContext(context.WithValue(r.Context(), requestErrorKey{}, &handlerErr))
+		rec := &statusRecorder{ResponseWriter: w}
+
+		defer func() {
+			recovered := recover()
+			if recovered == nil {
+				if rec.status >= http.StatusInternalServerError {
+					if handlerErr == nil {
+						handlerErr = fmt.Errorf("%s %s responded with status %d", r.Method, r.URL.Path, rec.status)
+					}
+					reporter.Report(r.Context(), errorEvent{Err: handlerErr, Status: rec.status, Request: r})
+				}
+				return
+			}
+			if recovered == http.ErrAbortHandler {
+				panic(recovered)
+			}
+
+			stack := debug.Stack()
+			err := fmt.Errorf("panic: %v", recovered)
+			slog.ErrorContext(r.Context(), "panic recovered", "error", err, "stack", string(stack))
+			reporter.Report(r.Context(), errorEvent{Err: err, Panic: true, Stack: stack, Status: http.StatusInternalServerError, Request: r})
+
+			if rec.status == 0 {
+				http.Error(rec, "Internal server error", http.StatusInternalServerError)
+			}
+		}()
+
+		next.ServeHTTP(rec, r)
+	})
+}
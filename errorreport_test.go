@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// recordingReporter collects reported events for assertions
+type recordingReporter struct {
+	mu     sync.Mutex
+	events []errorEvent
+}
+
+func (r *recordingReporter) Report(ctx context.Context, event errorEvent) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, event)
+}
+
+func (r *recordingReporter) Shutdown(context.Context) error { return nil }
+
+func TestRecoveryMiddleware(t *testing.T) {
+	errBackend := errors.New("backend exploded")
+
+	tests := []struct {
+		name       string
+		handler    http.HandlerFunc
+		wantStatus int
+		wantEvents int
+		wantPanic  bool
+		wantErr    error
+	}{
+		{
+			name:       "success is not reported",
+			handler:    func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) },
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "client error is not reported",
+			handler:    func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusBadRequest) },
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name: "server error is reported with cause",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				setRequestError(r.Context(), errBackend)
+				http.Error(w, "failed", http.StatusInternalServerError)
+			},
+			wantStatus: http.StatusInternalServerError,
+			wantEvents: 1,
+			wantErr:    errBackend,
+		},
+		{
+			name:       "panic is recovered and reported",
+			handler:    func(w http.ResponseWriter, r *http.Request) { panic("boom") },
+			wantStatus: http.StatusInternalServerError,
+			wantEvents: 1,
+			wantPanic:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reporter := &recordingReporter{}
+			rec := httptest.NewRecorder()
+
+			recoveryMiddleware(reporter, tt.handler).ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v1/qr/generate", nil))
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if len(reporter.events) != tt.wantEvents {
+				t.Fatalf("reported %d events, want %d", len(reporter.events), tt.wantEvents)
+			}
+			if tt.wantEvents == 0 {
+				return
+			}
+
+			event := reporter.events[0]
+			if event.Panic != tt.wantPanic {
+				t.Errorf("event.Panic = %v, want %v", event.Panic, tt.wantPanic)
+			}
+			if tt.wantPanic && len(event.Stack) == 0 {
+				t.Error("panic event missing stack trace")
+			}
+			if tt.wantErr != nil && !errors.Is(event.Err, tt.wantErr) {
+				t.Errorf("event.Err = %v, want %v", event.Err, tt.wantErr)
+			}
+			if event.Request == nil {
+				t.Error("event missing request context")
+			}
+		})
+	}
+}
+
+func TestNewErrorReporter_NoBackends(t *testing.T) {
+	reporter, err := newErrorReporter(context.Background(), Config{})
+	if err != nil {
+		t.Fatalf("newErrorReporter() error: %v", err)
+	}
+	reporter.Report(context.Background(), errorEvent{Err: errors.New("ignored")})
+	if err := reporter.Shutdown(context.Background()); err != nil {
+		t.Errorf("Shutdown() error: %v", err)
+	}
+}
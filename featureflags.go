@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Feature flags gating capabilities that are rolled out per environment
+const (
+	flagDecode       = "decode"
+	flagDynamicCodes = "dynamic_codes"
+	flagBatch        = "batch"
+	flagUI           = "ui"
+)
+
+// defaultFeatureFlags lists every known flag with its default state
+var defaultFeatureFlags = map[string]bool{
+	flagDecode:       false,
+	flagDynamicCodes: false,
+	flagBatch:        false,
+	flagUI:           false,
+}
+
+// featureFlags is a concurrency-safe set of runtime feature toggles
+type featureFlags struct {
+	mu    sync.RWMutex
+	flags map[string]bool
+}
+
+// newFeatureFlags starts from the defaults and applies overrides in the form
+// "batch=true,decode" (a bare name enables the flag). Unknown flags are rejected
+// so typos in a ConfigMap don't silently do nothing.
+func newFeatureFlags(overrides string) (*featureFlags, error) {
+	f := &featureFlags{flags: make(map[string]bool, len(defaultFeatureFlags))}
+	for name, enabled := range defaultFeatureFlags {
+		f.flags[name] = enabled
+	}
+
+	for _, item := range strings.Split(overrides, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		name, value, hasValue := strings.Cut(item, "=")
+		enabled := true
+		if hasValue {
+			parsed, err := strconv.ParseBool(strings.TrimSpace(value))
+			if err != nil {
+				return nil, fmt.Errorf("invalid value for feature flag %q: %q", name, value)
+			}
+			enabled = parsed
+		}
+		if err := f.Set(strings.TrimSpace(name), enabled); err != nil {
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+// Enabled reports whether the named flag is on; unknown flags are off
+func (f *featureFlags) Enabled(name string) bool {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.flags[name]
+}
+
+// Set toggles a known flag
+func (f *featureFlags) Set(name string, enabled bool) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.flags[name]; !ok {
+		return fmt.Errorf("unknown feature flag %q", name)
+	}
+	f.flags[name] = enabled
+	return nil
+}
+
+// Snapshot returns a copy of all flags and their states
+func (f *featureFlags) Snapshot() map[string]bool {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	snapshot := make(map[string]bool, len(f.flags))
+	for name, enabled := range f.flags {
+		snapshot[name] = enabled
+	}
+	return snapshot
+}
+
+// requireFeature hides a handler behind a flag, responding 404 while the flag is off
+func (f *featureFlags) requireFeature(name string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !f.Enabled(name) {
+			http.NotFound(w, r)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// handleList returns all flags as JSON (admin listener)
+func (f *featureFlags) handleList(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(f.Snapshot())
+}
+
+// handleToggle sets a single flag from a {"enabled": bool} body (admin listener)
+func (f *featureFlags) handleToggle(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+
+	var body struct {
+		Enabled *bool `json:"enabled"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Enabled == nil {
+		http.Error(w, `Invalid body. Usage: PUT /flags/{name} with {"enabled": true|false}`, http.StatusBadRequest)
+		return
+	}
+
+	if err := f.Set(name, *body.Enabled); err != nil {
+		known := make([]string, 0, len(defaultFeatureFlags))
+		for flag := range defaultFeatureFlags {
+			known = append(known, flag)
+		}
+		sort.Strings(known)
+		http.Error(w, fmt.Sprintf("%v (known flags: %s)", err, strings.Join(known, ", ")), http.StatusNotFound)
+		return
+	}
+
+	slog.InfoContext(r.Context(), "feature flag changed", "flag", name, "enabled", *body.Enabled)
+	f.handleList(w, r)
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNewFeatureFlags(t *testing.T) {
+	tests := []struct {
+		name      string
+		overrides string
+		want      map[string]bool
+		wantErr   bool
+	}{
+		{
+			name:      "defaults",
+			overrides: "",
+			want:      map[string]bool{flagBatch: false, flagDecode: false, flagDynamicCodes: false},
+		},
+		{
+			name:      "explicit values and bare names",
+			overrides: "batch=true, decode",
+			want:      map[string]bool{flagBatch: true, flagDecode: true, flagDynamicCodes: false},
+		},
+		{
+			name:      "explicit disable",
+			overrides: "batch=false",
+			want:      map[string]bool{flagBatch: false, flagDecode: false, flagDynamicCodes: false},
+		},
+		{
+			name:      "unknown flag",
+			overrides: "batchh=true",
+			wantErr:   true,
+		},
+		{
+			name:      "invalid value",
+			overrides: "batch=maybe",
+			wantErr:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			flags, err := newFeatureFlags(tt.overrides)
+			if tt.wantErr {
+				if err == nil {
+					t.Error("newFeatureFlags() expected error but got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("newFeatureFlags() unexpected error: %v", err)
+			}
+			for name, want := range tt.want {
+				if got := flags.Enabled(name); got != want {
+					t.Errorf("Enabled(%q) = %v, want %v", name, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestFeatureFlags_RequireFeature(t *testing.T) {
+	flags := mustFeatureFlags("")
+	handler := flags.requireFeature(flagBatch, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	rec := httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(http.MethodPost, "/", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("status with flag off = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+
+	if err := flags.Set(flagBatch, true); err != nil {
+		t.Fatalf("Set() error: %v", err)
+	}
+	rec = httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(http.MethodPost, "/", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("status with flag on = %d, want %d", rec.Code, http.StatusOK)
+	}
+}
+
+func TestFeatureFlags_AdminToggle(t *testing.T) {
+	deps := newTestDeps()
+	handler := newAdminHandler(deps)
+
+	tests := []struct {
+		name     string
+		path     string
+		body     string
+		wantCode int
+	}{
+		{"enable flag", "/flags/batch", `{"enabled": true}`, http.StatusOK},
+		{"unknown flag", "/flags/teleport", `{"enabled": true}`, http.StatusNotFound},
+		{"missing value", "/flags/batch", `{}`, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPut, tt.path, strings.NewReader(tt.body))
+			req.Header.Set("Authorization", "Bearer test-admin-token")
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != tt.wantCode {
+				t.Errorf("PUT %s status = %d, want %d", tt.path, rec.Code, tt.wantCode)
+			}
+		})
+	}
+
+	if !deps.flags.Enabled(flagBatch) {
+		t.Error("batch flag not enabled after toggle")
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/flags", nil)
+	req.Header.Set("Authorization", "Bearer test-admin-token")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	var listed map[string]bool
+	if err := json.Unmarshal(rec.Body.Bytes(), &listed); err != nil {
+		t.Fatalf("flags list is not valid JSON: %v", err)
+	}
+	if !listed[flagBatch] {
+		t.Errorf("GET /flags = %v, want batch enabled", listed)
+	}
+}
+
+func TestFeatureFlags_AdminToggleRequiresToken(t *testing.T) {
+	deps := newTestDeps()
+	handler := newAdminHandler(deps)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPut, "/flags/batch", strings.NewReader(`{"enabled": true}`)))
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("unauthenticated PUT /flags/batch status = %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+	if deps.flags.Enabled(flagBatch) {
+		t.Error("batch flag enabled by an unauthenticated request")
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/flags", nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("unauthenticated GET /flags status = %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// Health statuses reported for components and for the service overall
+const (
+	statusHealthy   = "healthy"
+	statusDegraded  = "degraded"
+	statusUnhealthy = "unhealthy"
+)
+
+// healthCheckTimeout bounds how long a single component check may take
+const healthCheckTimeout = 2 * time.Second
+
+// componentCheck is a registered dependency health check
+type componentCheck struct {
+	name string
+	// critical components make the service unhealthy when failing;
+	// non-critical ones only degrade it
+	critical bool
+	check    func(ctx context.Context) error
+}
+
+// componentStatus is the result of checking a single component
+type componentStatus struct {
+	Status    string  `json:"status"`
+	Critical  bool    `json:"critical"`
+	Error     string  `json:"error,omitempty"`
+	LatencyMS float64 `json:"latency_ms"`
+}
+
+// healthResponse is the JSON body returned by the health endpoint
+type healthResponse struct {
+	Status        string                     `json:"status"`
+	Uptime        string                     `json:"uptime"`
+	UptimeSeconds float64                    `json:"uptime_seconds"`
+	Versions      map[string]string          `json:"versions"`
+	Components    map[string]componentStatus `json:"components"`
+}
+
+// healthRegistry tracks dependency checks (storage, cache, queue, ...) and
+// aggregates them into an overall service status
+type healthRegistry struct {
+	started time.Time
+
+	mu     sync.RWMutex
+	checks []componentCheck
+}
+
+// newHealthRegistry creates an empty registry; uptime is measured from this call
+func newHealthRegistry() *healthRegistry {
+	return &healthRegistry{started: time.Now()}
+}
+
+// Register adds a component check
+func (h *healthRegistry) Register(name string, critical bool, check func(ctx context.Context) error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.checks = append(h.checks, componentCheck{name: name, critical: critical, check: check})
+}
+
+// Check runs all component checks concurrently and aggregates the result
+func (h *healthRegistry) Check(ctx context.Context) healthResponse {
+	h.mu.RLock()
+	checks := append([]componentCheck(nil), h.checks...)
+	h.mu.RUnlock()
+
+	results := make([]componentStatus, len(checks))
+	var wg sync.WaitGroup
+	for i, c := range checks {
+		wg.Add(1)
+		go func(i int, c componentCheck) {
+			defer wg.Done()
+			results[i] = runCheck(ctx, c)
+		}(i, c)
+	}
+	wg.Wait()
+
+	uptime := time.Since(h.started)
+	resp := healthResponse{
+		Status:        statusHealthy,
+		Uptime:        uptime.Round(time.Second).String(),
+		UptimeSeconds: uptime.Seconds(),
+		Versions:      buildVersions(),
+		Components:    make(map[string]componentStatus, len(checks)),
+	}
+	for i, c := range checks {
+		result := results[i]
+		resp.Components[c.name] = result
+		if result.Status == statusHealthy {
+			continue
+		}
+		if c.critical {
+			resp.Status = statusUnhealthy
+		} else if resp.Status == statusHealthy {
+			resp.Status = statusDegraded
+		}
+	}
+	return resp
+}
+
+// runCheck executes a single component check with a timeout
+func runCheck(ctx context.Context, c componentCheck) componentStatus {
+	ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+	defer cancel()
+
+	start := time.Now()
+	err := c.check(ctx)
+	result := componentStatus{
+		Status:    statusHealthy,
+		Critical:  c.critical,
+		LatencyMS: float64(time.Since(start).Microseconds()) / 1000,
+	}
+	if err != nil {
+		result.Status = statusUnhealthy
+		result.Error = err.Error()
+	}
+	return result
+}
+
+// ServeHTTP reports the aggregated health as JSON. Degraded still returns 200 so
+// non-critical outages don't pull pods out of rotation; unhealthy returns 503.
+func (h *healthRegistry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	resp := h.Check(r.Context())
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if resp.Status == statusUnhealthy {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+// buildVersions reports the Go runtime and key module versions
+func buildVersions() map[string]string {
+	versions := map[string]string{
+		"app":        version,
+		"git_commit": gitCommit,
+		"go":         runtime.Version(),
+	}
+
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return versions
+	}
+	for _, dep := range info.Deps {
+		if dep.Path == "github.com/skip2/go-qrcode" {
+			versions["go-qrcode"] = dep.Version
+		}
+	}
+	return versions
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHealthRegistry_Status(t *testing.T) {
+	ok := func(context.Context) error { return nil }
+	failing := func(context.Context) error { return errors.New("connection refused") }
+
+	tests := []struct {
+		name       string
+		register   func(h *healthRegistry)
+		wantStatus string
+		wantCode   int
+	}{
+		{
+			name:       "no components",
+			register:   func(h *healthRegistry) {},
+			wantStatus: statusHealthy,
+			wantCode:   http.StatusOK,
+		},
+		{
+			name: "all healthy",
+			register: func(h *healthRegistry) {
+				h.Register("generator", true, ok)
+				h.Register("cache", false, ok)
+			},
+			wantStatus: statusHealthy,
+			wantCode:   http.StatusOK,
+		},
+		{
+			name: "non-critical failure degrades",
+			register: func(h *healthRegistry) {
+				h.Register("generator", true, ok)
+				h.Register("cache", false, failing)
+			},
+			wantStatus: statusDegraded,
+			wantCode:   http.StatusOK,
+		},
+		{
+			name: "critical failure is unhealthy",
+			register: func(h *healthRegistry) {
+				h.Register("storage", true, failing)
+				h.Register("cache", false, failing)
+			},
+			wantStatus: statusUnhealthy,
+			wantCode:   http.StatusServiceUnavailable,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			health := newHealthRegistry()
+			tt.register(health)
+
+			rec := httptest.NewRecorder()
+			health.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/health", nil))
+
+			if rec.Code != tt.wantCode {
+				t.Errorf("status code = %d, want %d", rec.Code, tt.wantCode)
+			}
+
+			var resp healthResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("health response is not valid JSON: %v", err)
+			}
+			if resp.Status != tt.wantStatus {
+				t.Errorf("status = %q, want %q", resp.Status, tt.wantStatus)
+			}
+			if resp.Versions["go"] == "" {
+				t.Errorf("versions missing go runtime: %v", resp.Versions)
+			}
+			for name, component := range resp.Components {
+				if component.Status == statusUnhealthy && component.Error == "" {
+					t.Errorf("component %q unhealthy without error message", name)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHMACVerifier_Verify(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	verifier, err := newHMACVerifier("billing=s3cret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifier.now = func() time.Time { return now }
+
+	fresh := strconv.FormatInt(now.Unix(), 10)
+	stale := strconv.FormatInt(now.Add(-10*time.Minute).Unix(), 10)
+	const uri = "/api/v1/qr/generate?text=hello"
+
+	tests := []struct {
+		name      string
+		client    string
+		timestamp string
+		signedURI string
+		body      string
+		signature string
+		wantErr   bool
+	}{
+		{name: "valid", client: "billing", timestamp: fresh, signedURI: uri},
+		{name: "valid with body", client: "billing", timestamp: fresh, signedURI: uri, body: `{"text":"hello"}`},
+		{name: "unknown client", client: "other", timestamp: fresh, signedURI: uri, wantErr: true},
+		{name: "stale timestamp", client: "billing", timestamp: stale, signedURI: uri, wantErr: true},
+		{name: "missing timestamp", client: "billing", timestamp: "", signedURI: uri, wantErr: true},
+		{name: "tampered query", client: "billing", timestamp: fresh, signedURI: "/api/v1/qr/generate?text=other", wantErr: true},
+		{name: "wrong signature", client: "billing", timestamp: fresh, signedURI: uri, signature: "sha256=00", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, uri, strings.NewReader(tt.body))
+			req.Header.Set(signatureClientHeader, tt.client)
+			req.Header.Set(signatureTimestampHeader, tt.timestamp)
+			signature := tt.signature
+			if signature == "" {
+				signature = signRequest([]byte("s3cret"), tt.timestamp, http.MethodPost, tt.signedURI, []byte(tt.body))
+			}
+			req.Header.Set(signatureHeader, signature)
+
+			p, err := verifier.Verify(req)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("Verify() expected error but got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Verify() unexpected error: %v", err)
+			}
+			if p.Subject != "billing" || p.Method != authMethodHMAC {
+				t.Errorf("Verify() = %+v, want billing/hmac", p)
+			}
+		})
+	}
+}
+
+func TestRequireAuth_HMAC(t *testing.T) {
+	verifier, err := newHMACVerifier("billing=s3cret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	deps := newTestDeps()
+	deps.hmac = verifier
+	handler := newHandler(deps)
+
+	const uri = "/api/v1/qr/generate?text=hello"
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	tests := []struct {
+		name      string
+		signature string
+		wantCode  int
+	}{
+		{name: "signed", signature: signRequest([]byte("s3cret"), timestamp, http.MethodPost, uri, nil), wantCode: http.StatusOK},
+		{name: "bad signature", signature: "sha256=deadbeef", wantCode: http.StatusUnauthorized},
+		{name: "unsigned", wantCode: http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, uri, nil)
+			if tt.signature != "" {
+				req.Header.Set(signatureClientHeader, "billing")
+				req.Header.Set(signatureTimestampHeader, timestamp)
+				req.Header.Set(signatureHeader, tt.signature)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantCode {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestRequireAuth_HMACBasePath(t *testing.T) {
+	verifier, err := newHMACVerifier("billing=s3cret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	deps := newTestDeps()
+	deps.hmac = verifier
+	deps.basePath = "/tools/qr"
+	handler := newHandler(deps)
+
+	const uri = "/tools/qr/api/v1/qr/generate?text=hello"
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	tests := []struct {
+		name      string
+		signedURI string
+		wantCode  int
+	}{
+		{name: "signed with base path", signedURI: uri, wantCode: http.StatusOK},
+		{name: "signed without base path", signedURI: "/api/v1/qr/generate?text=hello", wantCode: http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, uri, nil)
+			req.Header.Set(signatureClientHeader, "billing")
+			req.Header.Set(signatureTimestampHeader, timestamp)
+			req.Header.Set(signatureHeader, signRequest([]byte("s3cret"), timestamp, http.MethodPost, tt.signedURI, nil))
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantCode {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestNewHMACVerifier(t *testing.T) {
+	if v, err := newHMACVerifier(""); v != nil || err != nil {
+		t.Errorf("newHMACVerifier(\"\") = %v, %v, want nil, nil", v, err)
+	}
+	if _, err := newHMACVerifier("billing"); err == nil {
+		t.Error("newHMACVerifier() expected error for entry without secret")
+	}
+}
+
+func TestHMACVerifier_Reload(t *testing.T) {
+	verifier, err := newHMACVerifier("billing=old")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := verifier.Reload("billing=new"); err != nil {
+		t.Fatalf("Reload() unexpected error: %v", err)
+	}
+	if err := verifier.Reload("billing"); err == nil {
+		t.Fatal("Reload() expected error for an invalid spec")
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	for secret, wantErr := range map[string]bool{"old": true, "new": false} {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/qr/generate?text=hello", nil)
+		req.Header.Set(signatureClientHeader, "billing")
+		req.Header.Set(signatureTimestampHeader, timestamp)
+		req.Header.Set(signatureHeader, signRequest([]byte(secret), timestamp, http.MethodPost, req.URL.RequestURI(), nil))
+		if _, err := verifier.Verify(req); (err != nil) != wantErr {
+			t.Errorf("Verify() with %q secret error = %v, wantErr %v", secret, err, wantErr)
+		}
+	}
+}
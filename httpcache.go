@@ -0,0 +1,46 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// renderVersion is part of every image cache key and ETag. Bump it, and
+// renderModTime, whenever the same input starts rendering to different bytes
+// so that caches and CDNs stop serving the old images. TestRender_Golden fails
+// when that happens, e.g. after a Go upgrade changes compress/flate.
+const renderVersion = "1"
+
+// renderModTime is the Last-Modified time of rendered images: when
+// renderVersion last changed. Deterministic images never change after that.
+var renderModTime = time.Date(2025, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// imageETag derives a strong ETag from an image cache key
+func imageETag(cacheKey string) string {
+	return `"` + cacheKey[:32] + `"`
+}
+
+// setImageCacheHeaders lets browsers and CDNs cache a deterministic image for
+// maxAge. A zero maxAge still sends validators but requires revalidation.
+func setImageCacheHeaders(h http.Header, etag string, maxAge time.Duration) {
+	if maxAge > 0 {
+		h.Set("Cache-Control", "public, max-age="+strconv.Itoa(int(maxAge.Seconds()))+", immutable")
+	} else {
+		h.Set("Cache-Control", "no-cache")
+	}
+	h.Set("ETag", etag)
+}
+
+// etagMatches reports whether an If-None-Match header matches etag, so the
+// client's copy is current and rendering can be skipped
+func etagMatches(ifNoneMatch, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
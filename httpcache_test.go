@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRender_Golden(t *testing.T) {
+	tests := []struct {
+		text string
+		want string // SHA-256 of the PNG
+	}{
+		{text: "https://example.com", want: "8335f21fcbc19063b0eb40d8b977b09025eac3a73e193393256e32f24cc1d5aa"},
+		{text: "hello", want: "014a7f444a0152298b8d273c91a20944fe382bff3bf3ce96247b64dbb843ec3b"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			// Repeated, so renders with reused pooled memory are covered too
+			for range 3 {
+				img, err := (&QRCodeGenerator{}).Encode(context.Background(), tt.text)
+				if err != nil {
+					t.Fatal(err)
+				}
+				png, err := img.PNG()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if sum := sha256.Sum256(png); hex.EncodeToString(sum[:]) != tt.want {
+					t.Fatalf("PNG of %q has SHA-256 %x, want %s: rendering changed, bump renderVersion and renderModTime and update this test", tt.text, sum, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestGenerate_DeterministicOutput(t *testing.T) {
+	tests := []struct {
+		name          string
+		deterministic bool
+		query         string
+		header        string
+		wantStatus    int
+	}{
+		{name: "plain", deterministic: true, query: "text=hello", wantStatus: http.StatusOK},
+		{name: "encrypted rejected", deterministic: true, query: "text=hello", header: base64.StdEncoding.EncodeToString(make([]byte, 32)), wantStatus: http.StatusBadRequest},
+		{name: "encrypted allowed when off", query: "text=hello", header: base64.StdEncoding.EncodeToString(make([]byte, 32)), wantStatus: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deps := newTestDeps()
+			deps.deterministicOutput = tt.deterministic
+			handler := newHandler(deps)
+
+			var bodies []string
+			for range 2 {
+				req := httptest.NewRequest(http.MethodPost, "/api/v1/qr/generate?"+tt.query, nil)
+				if tt.header != "" {
+					req.Header.Set(encryptionKeyHeader, tt.header)
+				}
+				rec := httptest.NewRecorder()
+				handler.ServeHTTP(rec, req)
+				if rec.Code != tt.wantStatus {
+					t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+				}
+				bodies = append(bodies, rec.Body.String())
+			}
+			if tt.deterministic && bodies[0] != bodies[1] {
+				t.Error("identical requests got different responses in deterministic mode")
+			}
+		})
+	}
+}
+
+func TestEtagMatches(t *testing.T) {
+	tests := []struct {
+		ifNoneMatch string
+		want        bool
+	}{
+		{ifNoneMatch: `"abc"`, want: true},
+		{ifNoneMatch: `W/"abc"`, want: true},
+		{ifNoneMatch: `"other", "abc"`, want: true},
+		{ifNoneMatch: `*`, want: true},
+		{ifNoneMatch: `"other"`, want: false},
+		{ifNoneMatch: ``, want: false},
+	}
+	for _, tt := range tests {
+		if got := etagMatches(tt.ifNoneMatch, `"abc"`); got != tt.want {
+			t.Errorf("etagMatches(%q) = %v, want %v", tt.ifNoneMatch, got, tt.want)
+		}
+	}
+}
+
+func TestGenerate_CacheHeaders(t *testing.T) {
+	deps := newTestDeps()
+	deps.imageMaxAge = time.Hour
+	handler := newHandler(deps)
+
+	generate := func(text string, headers map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/qr/generate?text="+text, nil)
+		for name, value := range headers {
+			req.Header.Set(name, value)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	first := generate("hello", nil)
+	etag := first.Header().Get("ETag")
+	if first.Code != http.StatusOK || etag == "" {
+		t.Fatalf("status = %d, ETag = %q, want 200 with an ETag", first.Code, etag)
+	}
+	if got := first.Header().Get("Cache-Control"); got != "public, max-age=3600, immutable" {
+		t.Errorf("Cache-Control = %q", got)
+	}
+	if got := first.Header().Get("Last-Modified"); got != renderModTime.Format(http.TimeFormat) {
+		t.Errorf("Last-Modified = %q, want %q", got, renderModTime.Format(http.TimeFormat))
+	}
+	if again := generate("hello", nil); again.Header().Get("ETag") != etag {
+		t.Error("ETag is not stable across requests")
+	}
+	if other := generate("world", nil); other.Header().Get("ETag") == etag {
+		t.Error("different payloads share an ETag")
+	}
+
+	t.Run("revalidation", func(t *testing.T) {
+		rec := generate("hello", map[string]string{"If-None-Match": etag})
+		if rec.Code != http.StatusNotModified || rec.Body.Len() != 0 {
+			t.Errorf("status = %d with %d body bytes, want 304 without body", rec.Code, rec.Body.Len())
+		}
+		if rec.Header().Get("ETag") != etag {
+			t.Error("304 response is missing the ETag")
+		}
+	})
+
+	t.Run("encrypted payloads are not cacheable", func(t *testing.T) {
+		key := base64.StdEncoding.EncodeToString([]byte(strings.Repeat("k", 32)))
+		rec := generate("hello", map[string]string{encryptionKeyHeader: key, "If-None-Match": etag})
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+		}
+		if rec.Header().Get("Cache-Control") != "no-store" || rec.Header().Get("ETag") != "" || rec.Header().Get("Last-Modified") != "" {
+			t.Errorf("headers = %v, want no-store without validators", rec.Header())
+		}
+	})
+
+	t.Run("errors are not cacheable", func(t *testing.T) {
+		rec := generate(strings.Repeat("x", 3000), nil)
+		if rec.Header().Get("Cache-Control") != "" {
+			t.Errorf("error response has Cache-Control %q", rec.Header().Get("Cache-Control"))
+		}
+	})
+}
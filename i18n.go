@@ -0,0 +1,79 @@
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+
+	"golang.org/x/text/language"
+)
+
+// localeFiles are the translations of error messages, one JSON object per
+// language mapping the English message (or its format) to the translation
+//
+//go:embed locales/*.json
+var localeFiles embed.FS
+
+// supportedLanguages are the languages of error messages; the first is the
+// default and the language of the messages in the code
+var supportedLanguages = []language.Tag{language.English, language.Spanish, language.German, language.French}
+
+var languageMatcher = language.NewMatcher(supportedLanguages)
+
+// errorTranslations maps a language code and an English message to its
+// translation
+var errorTranslations = mustLoadTranslations()
+
+// mustLoadTranslations reads localeFiles, panicking on invalid bundles
+func mustLoadTranslations() map[string]map[string]string {
+	entries, err := localeFiles.ReadDir("locales")
+	if err != nil {
+		panic(err)
+	}
+	translations := make(map[string]map[string]string, len(entries))
+	for _, entry := range entries {
+		data, err := localeFiles.ReadFile("locales/" + entry.Name())
+		if err != nil {
+			panic(err)
+		}
+		var messages map[string]string
+		if err := json.Unmarshal(data, &messages); err != nil {
+			panic(fmt.Sprintf("locale %s: %v", entry.Name(), err))
+		}
+		translations[strings.TrimSuffix(entry.Name(), path.Ext(entry.Name()))] = messages
+	}
+	return translations
+}
+
+// negotiateLanguage returns the code of the supported language best matching
+// an Accept-Language header, English when none matches
+func negotiateLanguage(acceptLanguage string) string {
+	tags, _, _ := language.ParseAcceptLanguage(acceptLanguage)
+	_, index, confidence := languageMatcher.Match(tags...)
+	if confidence == language.No {
+		index = 0
+	}
+	base, _ := supportedLanguages[index].Base()
+	return base.String()
+}
+
+// localize returns e with its message translated into lang. Messages
+// without a translation, such as errors of dependencies, stay English; the
+// code and details never change.
+func (e apiError) localize(lang string) apiError {
+	key := e.Message
+	if e.format != "" {
+		key = e.format
+	}
+	translated, ok := errorTranslations[lang][key]
+	if !ok {
+		return e
+	}
+	if e.format != "" {
+		translated = fmt.Sprintf(translated, e.args...)
+	}
+	e.Message = translated
+	return e
+}
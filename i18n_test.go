@@ -0,0 +1,227 @@
+package main
+
+import (
+	"encoding/json"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestNegotiateLanguage(t *testing.T) {
+	tests := []struct {
+		acceptLanguage string
+		want           string
+	}{
+		{acceptLanguage: "", want: "en"},
+		{acceptLanguage: "es", want: "es"},
+		{acceptLanguage: "de-AT,de;q=0.9,en;q=0.8", want: "de"},
+		{acceptLanguage: "fr-CA", want: "fr"},
+		{acceptLanguage: "ja, es;q=0.5", want: "es"},
+		{acceptLanguage: "en;q=0.9, fr", want: "fr"},
+		{acceptLanguage: "ja", want: "en"},
+		{acceptLanguage: "*", want: "en"},
+		{acceptLanguage: "not a header;;", want: "en"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.acceptLanguage, func(t *testing.T) {
+			if got := negotiateLanguage(tt.acceptLanguage); got != tt.want {
+				t.Errorf("negotiateLanguage(%q) = %q, want %q", tt.acceptLanguage, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAPIError_Localize(t *testing.T) {
+	tests := []struct {
+		name string
+		err  apiError
+		lang string
+		want string
+	}{
+		{name: "english", err: apiError{Code: errCodeInvalidPayload, Message: "Field 'text' is required"}, lang: "en", want: "Field 'text' is required"},
+		{name: "static message", err: apiError{Code: errCodeInvalidPayload, Message: "Field 'text' is required"}, lang: "de", want: "Das Feld 'text' ist erforderlich"},
+		{name: "formatted message", err: *apiErrorf(errCodePayloadTooLarge, nil, "Batch has %d items, the maximum is %d", 12, 10), lang: "es", want: "El lote tiene 12 elementos, el máximo es 10"},
+		{name: "untranslated message", err: apiError{Code: errCodeInvalidParam, Message: "asset_id is required"}, lang: "fr", want: "asset_id is required"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.err.localize(tt.lang)
+			if got.Message != tt.want {
+				t.Errorf("message = %q, want %q", got.Message, tt.want)
+			}
+			if got.Code != tt.err.Code {
+				t.Errorf("code = %q, want %q", got.Code, tt.err.Code)
+			}
+		})
+	}
+}
+
+func TestWriteAPIError_Localized(t *testing.T) {
+	tests := []struct {
+		acceptLanguage string
+		wantLanguage   string
+		wantMessage    string
+	}{
+		{acceptLanguage: "", wantLanguage: "en", wantMessage: "Parameter 'format' must be png or svg"},
+		{acceptLanguage: "es-ES", wantLanguage: "es", wantMessage: "El parámetro 'format' debe ser png o svg"},
+		{acceptLanguage: "de", wantLanguage: "de", wantMessage: "Der Parameter 'format' muss png oder svg sein"},
+		{acceptLanguage: "fr;q=0.9, it", wantLanguage: "fr", wantMessage: "Le paramètre 'format' doit être png ou svg"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.wantLanguage, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/qr/generate?text=hello&format=gif", nil)
+			req.Header.Set("Accept-Language", tt.acceptLanguage)
+			rec := httptest.NewRecorder()
+			newTestHandler().ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusBadRequest {
+				t.Fatalf("status = %d, want %d", rec.Code, http.StatusBadRequest)
+			}
+			if got := rec.Header().Get("Content-Language"); got != tt.wantLanguage {
+				t.Errorf("Content-Language = %q, want %q", got, tt.wantLanguage)
+			}
+			var resp struct {
+				Error apiError `json:"error"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			if resp.Error.Code != errCodeInvalidParam || resp.Error.Message != tt.wantMessage {
+				t.Errorf("error = %+v, want code %q and message %q", resp.Error, errCodeInvalidParam, tt.wantMessage)
+			}
+		})
+	}
+}
+
+func TestBatch_LocalizedItemErrors(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/qr/batch", strings.NewReader(`{"items": [{"text": "hello"}, {"text": ""}]}`))
+	req.Header.Set("Accept-Language", "de")
+	deps := newTestDeps()
+	deps.flags = mustFeatureFlags("batch")
+	rec := httptest.NewRecorder()
+	newHandler(deps).ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body.String())
+	}
+	if got := rec.Header().Get("Content-Language"); got != "de" {
+		t.Errorf("Content-Language = %q, want %q", got, "de")
+	}
+	var resp struct {
+		Items []batchResult `json:"items"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Items) != 2 || resp.Items[0].Error != nil || resp.Items[1].Error == nil {
+		t.Fatalf("items = %+v, want the second to fail", resp.Items)
+	}
+	if got := resp.Items[1].Error; got.Code != errCodeInvalidPayload || got.Message != "Das Feld 'text' ist erforderlich" {
+		t.Errorf("item error = %+v", got)
+	}
+}
+
+// TestLocales_Complete checks that every bundle translates every error
+// message of the code, with the same format verbs
+func TestLocales_Complete(t *testing.T) {
+	messages := apiErrorMessages(t)
+	if len(messages) < 50 {
+		t.Fatalf("found only %d error messages in the code", len(messages))
+	}
+	for _, tag := range supportedLanguages[1:] {
+		lang, _ := tag.Base()
+		bundle, ok := errorTranslations[lang.String()]
+		if !ok {
+			t.Errorf("no bundle for %s", lang)
+			continue
+		}
+		for _, message := range messages {
+			translated, ok := bundle[message]
+			if !ok {
+				t.Errorf("%s bundle lacks %q", lang, message)
+				continue
+			}
+			if !slices.Equal(formatVerbs(message), formatVerbs(translated)) {
+				t.Errorf("%s translation %q has other format verbs than %q", lang, translated, message)
+			}
+		}
+		for message := range bundle {
+			if !slices.Contains(messages, message) {
+				t.Errorf("%s bundle translates %q, which the code doesn't use", lang, message)
+			}
+		}
+	}
+}
+
+// apiErrorMessages collects the literal messages and formats of API errors
+// in the package's source
+func apiErrorMessages(t *testing.T) []string {
+	t.Helper()
+	sources, err := filepath.Glob("*.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var messages []string
+	add := func(expr ast.Expr) {
+		if lit, ok := expr.(*ast.BasicLit); ok && lit.Kind == token.STRING {
+			message, err := strconv.Unquote(lit.Value)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Contains(messages, message) {
+				messages = append(messages, message)
+			}
+		}
+	}
+	fset := token.NewFileSet()
+	for _, source := range sources {
+		if strings.HasSuffix(source, "_test.go") {
+			continue
+		}
+		file, err := parser.ParseFile(fset, source, nil, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ast.Inspect(file, func(n ast.Node) bool {
+			switch n := n.(type) {
+			case *ast.CompositeLit:
+				if ident, ok := n.Type.(*ast.Ident); ok && ident.Name == "apiError" {
+					for _, elt := range n.Elts {
+						if kv, ok := elt.(*ast.KeyValueExpr); ok && kv.Key.(*ast.Ident).Name == "Message" {
+							add(kv.Value)
+						}
+					}
+				}
+			case *ast.CallExpr:
+				switch fun := n.Fun.(type) {
+				case *ast.Ident:
+					if fun.Name == "apiErrorf" {
+						add(n.Args[2])
+					}
+				case *ast.SelectorExpr:
+					if fun.Sel.Name == "unavailable" {
+						add(n.Args[0])
+					}
+				}
+			}
+			return true
+		})
+	}
+	return messages
+}
+
+// formatVerbs lists the fmt verbs of a format in order
+func formatVerbs(format string) []string {
+	return regexp.MustCompile(`%[-+# 0-9.]*[a-zA-Z%]`).FindAllString(format, -1)
+}
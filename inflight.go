@@ -0,0 +1,37 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+)
+
+// concurrencyLimitMiddleware caps the number of requests served at once.
+// Requests over the limit get 429 with Retry-After immediately instead of
+// piling up goroutines and memory, so latency degrades predictably under
+// overload. Probe endpoints are exempt, as are long-lived connections such
+// as the preview WebSocket, which would hold a slot while idle and are
+// limited per message instead. A limit of zero disables it.
+func concurrencyLimitMiddleware(limit int, next http.Handler) http.Handler {
+	if limit <= 0 {
+		return next
+	}
+	slots := make(chan struct{}, limit)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if probePaths[r.URL.Path] || r.URL.Path == previewPath {
+			next.ServeHTTP(w, r)
+			return
+		}
+		select {
+		case slots <- struct{}{}:
+			defer func() { <-slots }()
+			next.ServeHTTP(w, r)
+		default:
+			slog.WarnContext(r.Context(), "concurrency limit reached, rejecting request", "limit", limit)
+			w.Header().Set("Retry-After", "1")
+			writeAPIError(w, r, http.StatusTooManyRequests, apiError{
+				Code:    errCodeServerBusy,
+				Message: "The server is busy, retry later",
+			})
+		}
+	})
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestConcurrencyLimitMiddleware(t *testing.T) {
+	tests := []struct {
+		name       string
+		limit      int
+		path       string
+		wantStatus int
+	}{
+		{name: "rejected at the limit", limit: 1, path: "/api/v1/qr/generate", wantStatus: http.StatusTooManyRequests},
+		{name: "probes are exempt", limit: 1, path: "/health", wantStatus: http.StatusOK},
+		{name: "preview WebSocket is exempt", limit: 1, path: previewPath, wantStatus: http.StatusOK},
+		{name: "disabled", limit: 0, path: "/api/v1/qr/generate", wantStatus: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			started := make(chan struct{})
+			release := make(chan struct{})
+			handler := concurrencyLimitMiddleware(tt.limit, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/slow" {
+					close(started)
+					<-release
+				}
+			}))
+
+			// Occupy the only slot with a slow request
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/slow", nil))
+			}()
+			<-started
+
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, tt.path, nil))
+			close(release)
+			<-done
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if tt.wantStatus == http.StatusTooManyRequests && rec.Header().Get("Retry-After") == "" {
+				t.Error("missing Retry-After header")
+			}
+		})
+	}
+}
+
+func TestConcurrencyLimitMiddleware_ReleasesSlots(t *testing.T) {
+	handler := concurrencyLimitMiddleware(1, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	for i := range 3 {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("request %d: status = %d, want %d", i, rec.Code, http.StatusOK)
+		}
+	}
+}
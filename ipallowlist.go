@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/netip"
+)
+
+// probePaths stay reachable from anywhere so kubelet probes keep working when
+// an allowlist is configured
+var probePaths = map[string]bool{"/health": true, "/ready": true, "/healthz": true, "/readyz": true}
+
+// ipAllowlist restricts a listener to clients in a set of networks
+type ipAllowlist struct {
+	// group names the endpoint group in logs (e.g. "public" or "admin")
+	group    string
+	networks []netip.Prefix
+	proxies  *trustedProxies
+}
+
+// newIPAllowlist parses a comma-separated list of CIDRs or single IPs. Client
+// IPs are resolved through proxies. It returns nil (everyone allowed) when
+// spec is empty.
+func newIPAllowlist(group, spec string, proxies *trustedProxies) (*ipAllowlist, error) {
+	networks, err := parsePrefixes(spec)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s allowlist entry %w", group, err)
+	}
+	if len(networks) == 0 {
+		return nil, nil
+	}
+	return &ipAllowlist{group: group, networks: networks, proxies: proxies}, nil
+}
+
+// allows reports whether ip belongs to an allowed network
+func (a *ipAllowlist) allows(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, network := range a.networks {
+		if network.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// middleware rejects requests from clients outside the allowlist with 403.
+// Probe endpoints are exempt. A nil allowlist allows everyone.
+func (a *ipAllowlist) middleware(next http.Handler) http.Handler {
+	if a == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if probePaths[r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ip := clientIP(r, a.proxies)
+		if !a.allows(ip) {
+			slog.InfoContext(r.Context(), "request denied by IP allowlist", "group", a.group, "client_ip", ip, "path", r.URL.Path)
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
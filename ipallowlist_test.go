@@ -0,0 +1,102 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNewIPAllowlist(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    string
+		wantNil bool
+		wantErr bool
+	}{
+		{name: "empty allows everyone", spec: "", wantNil: true},
+		{name: "cidrs and ips", spec: "10.0.0.0/8, 203.0.113.7, 2001:db8::/32"},
+		{name: "invalid", spec: "10.0.0.0/33", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			allowlist, err := newIPAllowlist("admin", tt.spec, nil)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("newIPAllowlist() expected error but got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("newIPAllowlist() unexpected error: %v", err)
+			}
+			if (allowlist == nil) != tt.wantNil {
+				t.Errorf("newIPAllowlist() = %v, wantNil %v", allowlist, tt.wantNil)
+			}
+		})
+	}
+}
+
+func TestIPAllowlist_Middleware(t *testing.T) {
+	proxies, err := parseTrustedProxies("10.0.0.1", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	office, err := newIPAllowlist("admin", "203.0.113.0/24,2001:db8::/32", proxies)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name          string
+		allowlist     *ipAllowlist
+		path          string
+		remoteAddr    string
+		xForwardedFor string
+		wantCode      int
+	}{
+		{name: "no allowlist", path: "/metrics", remoteAddr: "198.51.100.1:1234", wantCode: http.StatusOK},
+		{name: "office ip", allowlist: office, path: "/metrics", remoteAddr: "203.0.113.9:1234", wantCode: http.StatusOK},
+		{name: "office ipv6", allowlist: office, path: "/metrics", remoteAddr: "[2001:db8::1]:1234", wantCode: http.StatusOK},
+		{name: "outside ip", allowlist: office, path: "/metrics", remoteAddr: "198.51.100.1:1234", wantCode: http.StatusForbidden},
+		{name: "office ip through trusted proxy", allowlist: office, path: "/metrics", remoteAddr: "10.0.0.1:1234", xForwardedFor: "203.0.113.9", wantCode: http.StatusOK},
+		{name: "spoofed header from untrusted peer", allowlist: office, path: "/metrics", remoteAddr: "198.51.100.1:1234", xForwardedFor: "203.0.113.9", wantCode: http.StatusForbidden},
+		{name: "probes are exempt", allowlist: office, path: "/readyz", remoteAddr: "198.51.100.1:1234", wantCode: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := tt.allowlist.middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			req.RemoteAddr = tt.remoteAddr
+			if tt.xForwardedFor != "" {
+				req.Header.Set("X-Forwarded-For", tt.xForwardedFor)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantCode {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestAdminHandler_Allowlist(t *testing.T) {
+	deps := newTestDeps()
+	allowlist, err := newIPAllowlist("admin", "203.0.113.0/24", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deps.adminAllowlist = allowlist
+
+	req := httptest.NewRequest(http.MethodGet, "/admin/loglevel", nil)
+	req.RemoteAddr = "198.51.100.1:1234"
+	rec := httptest.NewRecorder()
+	newAdminHandler(deps).ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusForbidden)
+	}
+}
@@ -0,0 +1,314 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// IPP operations, status codes, and value tags used here (RFC 8010, 8011)
+const (
+	ippOpPrintJob             = 0x0002
+	ippOpGetPrinterAttributes = 0x000B
+
+	ippStatusSuccessMax = 0x00FF
+
+	ippTagOperation   = 0x01
+	ippTagJob         = 0x02
+	ippTagEnd         = 0x03
+	ippTagInteger     = 0x21
+	ippTagEnum        = 0x23
+	ippTagText        = 0x41
+	ippTagName        = 0x42
+	ippTagKeyword     = 0x44
+	ippTagURI         = 0x45
+	ippTagCharset     = 0x47
+	ippTagLanguage    = 0x48
+	ippTagMimeType    = 0x49
+	ippPrinterStopped = 5
+)
+
+// validMediaKeyword matches IPP media size names such as
+// oe_4x6-label_4x6in or iso_a6_105x148mm
+var validMediaKeyword = regexp.MustCompile(`^[a-z0-9][a-z0-9_.-]{0,63}$`)
+
+// ippAttribute is one attribute of an IPP message
+type ippAttribute struct {
+	tag   byte
+	name  string
+	value []byte
+}
+
+// ippResponse is the part of an IPP response used here
+type ippResponse struct {
+	status  uint16
+	message string
+	jobID   int
+	state   int
+}
+
+// ippPrinter is a configured printer
+type ippPrinter struct {
+	name string
+	// uri is the printer-uri sent in requests (ipp:// or ipps://)
+	uri string
+	// endpoint is the HTTP URL requests are posted to
+	endpoint string
+}
+
+// ippPrinters prints generated labels on IPP printers, such as CUPS queues
+// or driverless label printers, so a warehouse flow is one API call from
+// request to printed label. Printers are configured by name; callers pick
+// one and optionally a media size.
+type ippPrinters struct {
+	printers     map[string]*ippPrinter
+	defaultMedia string
+	client       *http.Client
+	requestID    atomic.Int32
+}
+
+// newIPPPrinters parses comma-separated name=uri pairs such as
+// "dock-1=ipp://cups:631/printers/zebra". URIs are ipp://, ipps://, http://,
+// or https://. It returns nil when spec is empty.
+func newIPPPrinters(spec, defaultMedia string, timeout time.Duration) (*ippPrinters, error) {
+	entries := splitList(spec)
+	if len(entries) == 0 {
+		return nil, nil
+	}
+	if defaultMedia != "" && !validMediaKeyword.MatchString(defaultMedia) {
+		return nil, fmt.Errorf("invalid IPP media %q: want a media keyword such as oe_4x6-label_4x6in", defaultMedia)
+	}
+	p := &ippPrinters{printers: map[string]*ippPrinter{}, defaultMedia: defaultMedia, client: newOutboundClient(timeout)}
+	for _, entry := range entries {
+		name, rawURI, ok := strings.Cut(entry, "=")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid printer %q: want name=uri", entry)
+		}
+		if _, dup := p.printers[name]; dup {
+			return nil, fmt.Errorf("printer %q is configured twice", name)
+		}
+		printer, err := parsePrinterURI(name, strings.TrimSpace(rawURI))
+		if err != nil {
+			return nil, err
+		}
+		p.printers[name] = printer
+	}
+	return p, nil
+}
+
+// parsePrinterURI derives the HTTP endpoint of a printer URI; IPP defaults
+// to port 631
+func parsePrinterURI(name, rawURI string) (*ippPrinter, error) {
+	u, err := url.Parse(rawURI)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid URI of printer %q", name)
+	}
+	endpoint := *u
+	switch u.Scheme {
+	case "ipp", "ipps":
+		endpoint.Scheme = map[string]string{"ipp": "http", "ipps": "https"}[u.Scheme]
+		if u.Port() == "" {
+			endpoint.Host = net.JoinHostPort(u.Hostname(), "631")
+		}
+	case "http", "https":
+	default:
+		return nil, fmt.Errorf("unsupported URI scheme %q of printer %q (want ipp, ipps, http, or https)", u.Scheme, name)
+	}
+	return &ippPrinter{name: name, uri: rawURI, endpoint: endpoint.String()}, nil
+}
+
+// printDelivery reads the deliver.print and deliver.print_media parameters.
+// It returns an empty printer when no printing is requested.
+func printDelivery(query url.Values, p *ippPrinters) (printer, media string, apiErr *apiError) {
+	printer = query.Get("deliver.print")
+	if printer == "" {
+		return "", "", nil
+	}
+	if p == nil {
+		return "", "", &apiError{Code: errCodeInvalidParam, Message: "Printing is not configured on this server"}
+	}
+	if _, ok := p.printers[printer]; !ok {
+		return "", "", apiErrorf(errCodeInvalidParam,
+			map[string]any{"printers": p.names()},
+			"Unknown printer %q", printer)
+	}
+	media = query.Get("deliver.print_media")
+	if media == "" {
+		media = p.defaultMedia
+	} else if !validMediaKeyword.MatchString(media) {
+		return "", "", &apiError{Code: errCodeInvalidParam, Message: "Parameter 'deliver.print_media' must be a media keyword such as oe_4x6-label_4x6in"}
+	}
+	return printer, media, nil
+}
+
+// names returns the configured printer names, sorted
+func (p *ippPrinters) names() []string {
+	names := make([]string, 0, len(p.printers))
+	for name := range p.printers {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// Print submits the PNG as a job named jobName on the named printer, scaled
+// to fit media (the printer's default when empty), and returns the job ID
+func (p *ippPrinters) Print(ctx context.Context, name, media, jobName string, pngBytes []byte) (int, error) {
+	printer := p.printers[name]
+	operation := []ippAttribute{
+		ippString(ippTagURI, "printer-uri", printer.uri),
+		ippString(ippTagName, "requesting-user-name", "qr-generator"),
+		ippString(ippTagName, "job-name", jobName),
+		ippString(ippTagMimeType, "document-format", "image/png"),
+	}
+	job := []ippAttribute{ippString(ippTagKeyword, "print-scaling", "fit")}
+	if media != "" {
+		job = append(job, ippString(ippTagKeyword, "media", media))
+	}
+	resp, err := p.do(ctx, printer, ippOpPrintJob, operation, job, pngBytes)
+	if err != nil {
+		return 0, err
+	}
+	return resp.jobID, nil
+}
+
+// check reports whether every printer answers and none is stopped, for the
+// health endpoint
+func (p *ippPrinters) check(ctx context.Context) error {
+	var errs []error
+	for _, name := range p.names() {
+		printer := p.printers[name]
+		resp, err := p.do(ctx, printer, ippOpGetPrinterAttributes, []ippAttribute{
+			ippString(ippTagURI, "printer-uri", printer.uri),
+			ippString(ippTagKeyword, "requested-attributes", "printer-state"),
+		}, nil, nil)
+		switch {
+		case err != nil:
+			errs = append(errs, fmt.Errorf("printer %s: %w", name, err))
+		case resp.state == ippPrinterStopped:
+			errs = append(errs, fmt.Errorf("printer %s is stopped", name))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// do sends one IPP request and fails on an unsuccessful status
+func (p *ippPrinters) do(ctx context.Context, printer *ippPrinter, operation uint16, operationAttrs, jobAttrs []ippAttribute, document []byte) (ippResponse, error) {
+	var body bytes.Buffer
+	body.Write([]byte{2, 0}) // IPP/2.0
+	binary.Write(&body, binary.BigEndian, operation)
+	binary.Write(&body, binary.BigEndian, p.requestID.Add(1))
+	body.WriteByte(ippTagOperation)
+	for _, attr := range append([]ippAttribute{
+		ippString(ippTagCharset, "attributes-charset", "utf-8"),
+		ippString(ippTagLanguage, "attributes-natural-language", "en"),
+	}, operationAttrs...) {
+		attr.writeTo(&body)
+	}
+	if len(jobAttrs) > 0 {
+		body.WriteByte(ippTagJob)
+		for _, attr := range jobAttrs {
+			attr.writeTo(&body)
+		}
+	}
+	body.WriteByte(ippTagEnd)
+	body.Write(document)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, printer.endpoint, &body)
+	if err != nil {
+		return ippResponse{}, err
+	}
+	req.Header.Set("Content-Type", "application/ipp")
+	httpResp, err := p.client.Do(req)
+	if err != nil {
+		return ippResponse{}, err
+	}
+	defer httpResp.Body.Close()
+	if httpResp.StatusCode != http.StatusOK {
+		return ippResponse{}, fmt.Errorf("printer answered %s", httpResp.Status)
+	}
+	raw, err := io.ReadAll(io.LimitReader(httpResp.Body, 1<<20))
+	if err != nil {
+		return ippResponse{}, err
+	}
+	resp, err := parseIPPResponse(raw)
+	if err != nil {
+		return ippResponse{}, err
+	}
+	if resp.status > ippStatusSuccessMax {
+		return resp, fmt.Errorf("printer rejected the request with status 0x%04x: %s", resp.status, resp.message)
+	}
+	return resp, nil
+}
+
+// ippString is an attribute with a string value
+func ippString(tag byte, name, value string) ippAttribute {
+	return ippAttribute{tag: tag, name: name, value: []byte(value)}
+}
+
+// writeTo encodes the attribute: tag, name length, name, value length, value
+func (a ippAttribute) writeTo(b *bytes.Buffer) {
+	b.WriteByte(a.tag)
+	binary.Write(b, binary.BigEndian, uint16(len(a.name)))
+	b.WriteString(a.name)
+	binary.Write(b, binary.BigEndian, uint16(len(a.value)))
+	b.Write(a.value)
+}
+
+// parseIPPResponse decodes the status and the attributes used here
+func parseIPPResponse(raw []byte) (ippResponse, error) {
+	errTruncated := errors.New("truncated IPP response")
+	if len(raw) < 8 {
+		return ippResponse{}, errTruncated
+	}
+	resp := ippResponse{status: binary.BigEndian.Uint16(raw[2:4])}
+	rest := raw[8:]
+	for len(rest) > 0 {
+		tag := rest[0]
+		rest = rest[1:]
+		if tag == ippTagEnd {
+			return resp, nil
+		}
+		if tag < 0x10 {
+			// Delimiter of the next attribute group
+			continue
+		}
+		if len(rest) < 2 {
+			return ippResponse{}, errTruncated
+		}
+		nameLen := int(binary.BigEndian.Uint16(rest))
+		if len(rest) < 2+nameLen+2 {
+			return ippResponse{}, errTruncated
+		}
+		name := string(rest[2 : 2+nameLen])
+		rest = rest[2+nameLen:]
+		valueLen := int(binary.BigEndian.Uint16(rest))
+		if len(rest) < 2+valueLen {
+			return ippResponse{}, errTruncated
+		}
+		value := rest[2 : 2+valueLen]
+		rest = rest[2+valueLen:]
+
+		switch {
+		case name == "status-message" && tag == ippTagText:
+			resp.message = string(value)
+		case name == "job-id" && tag == ippTagInteger && len(value) == 4:
+			resp.jobID = int(binary.BigEndian.Uint32(value))
+		case name == "printer-state" && tag == ippTagEnum && len(value) == 4:
+			resp.state = int(binary.BigEndian.Uint32(value))
+		}
+	}
+	return ippResponse{}, errTruncated
+}
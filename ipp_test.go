@@ -0,0 +1,250 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// ippRequest is a request received by fakePrinter
+type ippRequest struct {
+	operation uint16
+	attrs     map[string]string
+	document  []byte
+}
+
+// fakePrinter answers IPP requests with status and printer state, recording
+// what it received
+type fakePrinter struct {
+	status uint16
+	state  int
+
+	mu       sync.Mutex
+	requests []ippRequest
+}
+
+func (p *fakePrinter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	raw, _ := io.ReadAll(r.Body)
+	if r.Header.Get("Content-Type") != "application/ipp" || len(raw) < 9 {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	req := ippRequest{operation: binary.BigEndian.Uint16(raw[2:4]), attrs: map[string]string{}}
+	rest := raw[8:]
+	for len(rest) > 0 {
+		tag := rest[0]
+		rest = rest[1:]
+		if tag == ippTagEnd {
+			req.document = rest
+			break
+		}
+		if tag < 0x10 {
+			continue
+		}
+		nameLen := int(binary.BigEndian.Uint16(rest))
+		name := string(rest[2 : 2+nameLen])
+		rest = rest[2+nameLen:]
+		valueLen := int(binary.BigEndian.Uint16(rest))
+		req.attrs[name] = string(rest[2 : 2+valueLen])
+		rest = rest[2+valueLen:]
+	}
+	p.mu.Lock()
+	p.requests = append(p.requests, req)
+	p.mu.Unlock()
+
+	var resp bytes.Buffer
+	resp.Write([]byte{2, 0})
+	binary.Write(&resp, binary.BigEndian, p.status)
+	resp.Write(raw[4:8])
+	resp.WriteByte(ippTagOperation)
+	ippString(ippTagCharset, "attributes-charset", "utf-8").writeTo(&resp)
+	if p.status > ippStatusSuccessMax {
+		ippString(ippTagText, "status-message", "document-format-not-supported").writeTo(&resp)
+	}
+	if req.operation == ippOpPrintJob {
+		resp.WriteByte(ippTagJob)
+		ippAttribute{tag: ippTagInteger, name: "job-id", value: binary.BigEndian.AppendUint32(nil, 42)}.writeTo(&resp)
+	} else {
+		resp.WriteByte(0x04) // printer attributes
+		ippAttribute{tag: ippTagEnum, name: "printer-state", value: binary.BigEndian.AppendUint32(nil, uint32(p.state))}.writeTo(&resp)
+	}
+	resp.WriteByte(ippTagEnd)
+	w.Header().Set("Content-Type", "application/ipp")
+	w.Write(resp.Bytes())
+}
+
+// newTestPrinters returns printers "dock-1" served by printer and "dock-2"
+// pointing nowhere
+func newTestPrinters(t *testing.T, printer *fakePrinter) *ippPrinters {
+	t.Helper()
+	server := httptest.NewServer(printer)
+	t.Cleanup(server.Close)
+	p, err := newIPPPrinters("dock-1="+server.URL+"/printers/zebra, dock-2=ipp://127.0.0.1:1/printers/none", "oe_2x1-label_2x1in", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestNewIPPPrinters(t *testing.T) {
+	tests := []struct {
+		name  string
+		spec  string
+		media string
+	}{
+		{name: "no name", spec: "ipp://cups/printers/zebra"},
+		{name: "unsupported scheme", spec: "dock-1=lpd://cups/zebra"},
+		{name: "no host", spec: "dock-1=ipp:///printers/zebra"},
+		{name: "duplicate", spec: "dock-1=ipp://cups/a,dock-1=ipp://cups/b"},
+		{name: "invalid media", spec: "dock-1=ipp://cups/printers/zebra", media: "4x6 label"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := newIPPPrinters(tt.spec, tt.media, time.Second); err == nil {
+				t.Error("invalid configuration accepted")
+			}
+		})
+	}
+
+	p, err := newIPPPrinters("dock-1=ipp://cups/printers/zebra, dock-2=ipps://printer.local:8631/ipp/print", "", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := p.printers["dock-1"].endpoint; got != "http://cups:631/printers/zebra" {
+		t.Errorf("endpoint of dock-1 = %q", got)
+	}
+	if got := p.printers["dock-2"]; got.endpoint != "https://printer.local:8631/ipp/print" || got.uri != "ipps://printer.local:8631/ipp/print" {
+		t.Errorf("dock-2 = %+v", got)
+	}
+	if p, err := newIPPPrinters("", "", time.Second); p != nil || err != nil {
+		t.Errorf("without printers = (%v, %v), want disabled", p, err)
+	}
+}
+
+func TestPrintDelivery(t *testing.T) {
+	printers := newTestPrinters(t, &fakePrinter{})
+	tests := []struct {
+		name        string
+		query       string
+		printers    *ippPrinters
+		wantPrinter string
+		wantMedia   string
+		wantErr     bool
+	}{
+		{name: "not requested", query: "text=x", printers: printers},
+		{name: "default media", query: "deliver.print=dock-1", printers: printers, wantPrinter: "dock-1", wantMedia: "oe_2x1-label_2x1in"},
+		{name: "media", query: "deliver.print=dock-1&deliver.print_media=oe_4x6-label_4x6in", printers: printers, wantPrinter: "dock-1", wantMedia: "oe_4x6-label_4x6in"},
+		{name: "not configured", query: "deliver.print=dock-1", wantErr: true},
+		{name: "unknown printer", query: "deliver.print=dock-9", printers: printers, wantErr: true},
+		{name: "invalid media", query: "deliver.print=dock-1&deliver.print_media=A6%20paper", printers: printers, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, _ := url.ParseQuery(tt.query)
+			printer, media, apiErr := printDelivery(query, tt.printers)
+			if (apiErr != nil) != tt.wantErr {
+				t.Fatalf("error = %v, want error %v", apiErr, tt.wantErr)
+			}
+			if printer != tt.wantPrinter || media != tt.wantMedia {
+				t.Errorf("delivery = (%q, %q), want (%q, %q)", printer, media, tt.wantPrinter, tt.wantMedia)
+			}
+		})
+	}
+}
+
+func TestGenerate_DeliverPrint(t *testing.T) {
+	tests := []struct {
+		name       string
+		query      string
+		status     uint16
+		wantStatus int
+	}{
+		{name: "printed", query: "text=hello&deliver.print=dock-1&deliver.print_media=oe_4x6-label_4x6in", wantStatus: http.StatusOK},
+		{name: "rejected", query: "text=hello&deliver.print=dock-1", status: 0x040A, wantStatus: http.StatusBadGateway},
+		{name: "unreachable", query: "text=hello&deliver.print=dock-2", wantStatus: http.StatusBadGateway},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			printer := &fakePrinter{status: tt.status}
+			deps := newTestDeps()
+			deps.printers = newTestPrinters(t, printer)
+
+			rec := httptest.NewRecorder()
+			newHandler(deps).ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v1/qr/generate?"+tt.query, nil))
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if rec.Code != http.StatusOK {
+				return
+			}
+
+			var body struct {
+				Delivered map[string]string `json:"delivered"`
+			}
+			json.Unmarshal(rec.Body.Bytes(), &body)
+			if body.Delivered["print"] != "dock-1" || body.Delivered["print_job"] != "42" || body.Delivered["print_media"] != "oe_4x6-label_4x6in" {
+				t.Errorf("delivered = %v", body.Delivered)
+			}
+			if len(printer.requests) != 1 {
+				t.Fatalf("printer got %d requests, want 1", len(printer.requests))
+			}
+			req := printer.requests[0]
+			if req.operation != ippOpPrintJob || req.attrs["media"] != "oe_4x6-label_4x6in" || req.attrs["document-format"] != "image/png" ||
+				req.attrs["print-scaling"] != "fit" || !strings.HasSuffix(req.attrs["printer-uri"], "/printers/zebra") {
+				t.Errorf("request = %d %v", req.operation, req.attrs)
+			}
+			if !bytes.HasPrefix(req.document, []byte("\x89PNG")) {
+				t.Error("document is not a PNG")
+			}
+		})
+	}
+}
+
+func TestIPPPrinters_Check(t *testing.T) {
+	tests := []struct {
+		name    string
+		state   int
+		wantErr bool
+	}{
+		{name: "idle", state: 3},
+		{name: "stopped", state: ippPrinterStopped, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(&fakePrinter{state: tt.state})
+			defer server.Close()
+			p, err := newIPPPrinters("dock-1="+server.URL+"/printers/zebra", "", time.Second)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := p.check(context.Background()); (err != nil) != tt.wantErr {
+				t.Errorf("check = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+	// An unreachable printer fails the check
+	if err := newTestPrinters(t, &fakePrinter{state: 3}).check(context.Background()); err == nil || !strings.Contains(err.Error(), "dock-2") {
+		t.Errorf("check = %v, want dock-2 unreachable", err)
+	}
+}
+
+func TestParseIPPResponse_Truncated(t *testing.T) {
+	for _, raw := range [][]byte{nil, {2, 0, 0, 0}, {2, 0, 0, 0, 0, 0, 0, 1, ippTagOperation, ippTagText, 0, 5}, {2, 0, 0, 0, 0, 0, 0, 1, ippTagOperation}} {
+		if _, err := parseIPPResponse(raw); err == nil {
+			t.Errorf("parseIPPResponse(%v) succeeded", raw)
+		}
+	}
+}
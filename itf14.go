@@ -0,0 +1,136 @@
+package main
+
+import "strings"
+
+// Bearer styles of ITF-14 barcodes: a frame around bars and quiet zones,
+// required when printing on corrugated cartons, bars above and below only,
+// or none
+const (
+	itf14BearerFrame = "frame"
+	itf14BearerBars  = "bars"
+	itf14BearerNone  = "none"
+)
+
+// Layout of ITF-14 barcodes in modules, after the GS1 nominal size: wide
+// elements are 3 narrow ones, bars are 32 modules high, quiet zones are 10
+// modules, and bearers are 5 modules thick
+const (
+	itf14Wide      = 3
+	itf14BarHeight = 32
+	itf14QuietZone = 10
+	itf14Bearer    = 5
+)
+
+// itf14Patterns are the 5 elements of each digit in Interleaved 2 of 5,
+// with wide elements as w: pairs of digits are encoded by the bars of the
+// first and the spaces of the second
+var itf14Patterns = [10]string{"nnwwn", "wnnnw", "nwnnw", "wwnnn", "nnwnw", "wnwnn", "nwwnn", "nnnww", "wnnwn", "nwnwn"}
+
+// itf14Barcode is an ITF-14 barcode of a GTIN-14, the carton code of the
+// trade items inside
+type itf14Barcode struct {
+	// gtin is the 14 digits, check digit included
+	gtin   string
+	bearer string
+}
+
+// parseITF14 validates value as a GTIN-14 of 14 digits, or 13 without the
+// check digit, which is then computed, and bearer as a bearer style,
+// defaulting to a frame
+func parseITF14(value, bearer string) (*itf14Barcode, *apiError) {
+	switch bearer {
+	case "":
+		bearer = itf14BearerFrame
+	case itf14BearerFrame, itf14BearerBars, itf14BearerNone:
+	default:
+		return nil, &apiError{Code: errCodeInvalidParam, Message: "Parameter 'bearer' must be frame, bars, or none"}
+	}
+	if strings.Trim(value, "0123456789") != "" || (len(value) != 14 && len(value) != 13) {
+		return nil, apiErrorf(errCodeInvalidParam, map[string]any{"type": barcodeITF14},
+			"Parameter 'value' must be %d digits, or %d without the check digit", 14, 13)
+	}
+	check := gtinCheckDigit(value[:13])
+	if len(value) == 13 {
+		value += string(check)
+	} else if value[13] != check {
+		return nil, apiErrorf(errCodeInvalidParam, map[string]any{"type": barcodeITF14, "check_digit": string(check)},
+			"Check digit of %s is %c, not %c", value, check, value[13])
+	}
+	return &itf14Barcode{gtin: value, bearer: bearer}, nil
+}
+
+// value is the 14 digits of the GTIN, check digit included
+func (b *itf14Barcode) value() string {
+	return b.gtin
+}
+
+// modules returns the modules between the quiet zones, dark ones true: the
+// start pattern, the interleaved pairs of digits, and the stop pattern
+func (b *itf14Barcode) modules() []bool {
+	modules := []bool{true, false, true, false}
+	element := func(dark bool, code byte) {
+		w := 1
+		if code == 'w' {
+			w = itf14Wide
+		}
+		for range w {
+			modules = append(modules, dark)
+		}
+	}
+	for i := 0; i < len(b.gtin); i += 2 {
+		bars, spaces := itf14Patterns[b.gtin[i]-'0'], itf14Patterns[b.gtin[i+1]-'0']
+		for e := range 5 {
+			element(true, bars[e])
+			element(false, spaces[e])
+		}
+	}
+	element(true, 'w')
+	element(false, 'n')
+	element(true, 'n')
+	return modules
+}
+
+// layout returns the width and height of the barcode in modules and its
+// dark rectangles: the bars, the bearers, then the digits centered below
+// them when shown
+func (b *itf14Barcode) layout(digits bool) (int, int, []rect) {
+	left, top := itf14QuietZone, 0
+	if b.bearer == itf14BearerFrame {
+		left += itf14Bearer
+	}
+	if b.bearer != itf14BearerNone {
+		top = itf14Bearer
+	}
+
+	var rects []rect
+	modules := b.modules()
+	for x := 0; x < len(modules); {
+		if !modules[x] {
+			x++
+			continue
+		}
+		start := x
+		for x < len(modules) && modules[x] {
+			x++
+		}
+		rects = append(rects, rect{left + start, top, x - start, itf14BarHeight})
+	}
+
+	width, height := 2*left+len(modules), 2*top+itf14BarHeight
+	if b.bearer != itf14BearerNone {
+		rects = append(rects, rect{0, 0, width, itf14Bearer}, rect{0, height - itf14Bearer, width, itf14Bearer})
+	}
+	if b.bearer == itf14BearerFrame {
+		rects = append(rects, rect{0, itf14Bearer, itf14Bearer, itf14BarHeight}, rect{width - itf14Bearer, itf14Bearer, itf14Bearer, itf14BarHeight})
+	}
+	if !digits {
+		return width, height, rects
+	}
+
+	// Glyphs are 5 modules wide with a module between them
+	textLeft := (width - (6*len(b.gtin) - 1)) / 2
+	for i := range len(b.gtin) {
+		rects = append(rects, glyphRects(b.gtin[i], textLeft+6*i, height+1)...)
+	}
+	return width, height + barcodeDigitsSpace, rects
+}
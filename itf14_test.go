@@ -0,0 +1,141 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParseITF14(t *testing.T) {
+	tests := []struct {
+		name       string
+		value      string
+		bearer     string
+		want       string
+		wantBearer string
+		wantErr    bool
+	}{
+		{name: "without check digit", value: "1540014128876", want: "15400141288763", wantBearer: itf14BearerFrame},
+		{name: "with check digit", value: "00012345678905", bearer: itf14BearerBars, want: "00012345678905", wantBearer: itf14BearerBars},
+		{name: "check digit zero", value: "1001234500000", bearer: itf14BearerNone, want: "10012345000000", wantBearer: itf14BearerNone},
+		{name: "wrong check digit", value: "15400141288764", wantErr: true},
+		{name: "too short", value: "154001412887", wantErr: true},
+		{name: "letters", value: "154001412887A", wantErr: true},
+		{name: "unknown bearer", value: "1540014128876", bearer: "box", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, apiErr := parseITF14(tt.value, tt.bearer)
+			if (apiErr != nil) != tt.wantErr {
+				t.Fatalf("error = %v, want error %v", apiErr, tt.wantErr)
+			}
+			if apiErr != nil {
+				return
+			}
+			if b.value() != tt.want || b.bearer != tt.wantBearer {
+				t.Errorf("value, bearer = %q, %q, want %q, %q", b.value(), b.bearer, tt.want, tt.wantBearer)
+			}
+		})
+	}
+}
+
+func TestGTINCheckDigit(t *testing.T) {
+	tests := []struct {
+		digits string
+		want   byte
+	}{
+		{digits: "400638133393", want: '1'},
+		{digits: "1540014128876", want: '3'},
+		{digits: "0001234567890", want: '5'},
+		{digits: "9501234", want: '6'},
+	}
+	for _, tt := range tests {
+		if got := gtinCheckDigit(tt.digits); got != tt.want {
+			t.Errorf("gtinCheckDigit(%s) = %c, want %c", tt.digits, got, tt.want)
+		}
+	}
+}
+
+func TestITF14_Layout(t *testing.T) {
+	tests := []struct {
+		bearer     string
+		wantWidth  int
+		wantHeight int
+	}{
+		// start, 7 pairs of 18 modules, and stop
+		{bearer: itf14BearerFrame, wantWidth: 5 + 10 + 135 + 10 + 5, wantHeight: 5 + 32 + 5},
+		{bearer: itf14BearerBars, wantWidth: 10 + 135 + 10, wantHeight: 5 + 32 + 5},
+		{bearer: itf14BearerNone, wantWidth: 10 + 135 + 10, wantHeight: 32},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.bearer, func(t *testing.T) {
+			b, apiErr := parseITF14("1540014128876", tt.bearer)
+			if apiErr != nil {
+				t.Fatal(apiErr)
+			}
+			width, height, rects := b.layout(false)
+			if width != tt.wantWidth || height != tt.wantHeight {
+				t.Fatalf("size = %dx%d, want %dx%d", width, height, tt.wantWidth, tt.wantHeight)
+			}
+			dark := func(x, y int) bool {
+				return slices.ContainsFunc(rects, func(r rect) bool {
+					return x >= r.x && x < r.x+r.w && y >= r.y && y < r.y+r.h
+				})
+			}
+			if tt.bearer != itf14BearerNone && !(dark(0, 0) && dark(width-1, height-1)) {
+				t.Error("bearers do not reach the corners")
+			}
+			if tt.bearer == itf14BearerFrame && !dark(0, height/2) {
+				t.Error("frame has no sides")
+			}
+
+			// The bars and spaces of the middle row read back as the digits
+			left := 10
+			if tt.bearer == itf14BearerFrame {
+				left += 5
+			}
+			var widths []byte
+			for x := left; x < width-left; {
+				start := x
+				for x < width-left && dark(x, height/2) == dark(start, height/2) {
+					x++
+				}
+				switch x - start {
+				case 1:
+					widths = append(widths, 'n')
+				case itf14Wide:
+					widths = append(widths, 'w')
+				default:
+					t.Fatalf("element of %d modules at module %d", x-start, start)
+				}
+			}
+			if string(widths[:4]) != "nnnn" || string(widths[len(widths)-3:]) != "wnn" {
+				t.Fatalf("start and stop = %s, %s", widths[:4], widths[len(widths)-3:])
+			}
+			widths = widths[4 : len(widths)-3]
+			var decoded []byte
+			for i := 0; i+10 <= len(widths); i += 10 {
+				var bars, spaces []byte
+				for e := range 5 {
+					bars = append(bars, widths[i+2*e])
+					spaces = append(spaces, widths[i+2*e+1])
+				}
+				for _, pattern := range [][]byte{bars, spaces} {
+					digit := slices.Index(itf14Patterns[:], string(pattern))
+					if digit < 0 {
+						t.Fatalf("invalid pattern %s", pattern)
+					}
+					decoded = append(decoded, byte('0'+digit))
+				}
+			}
+			if string(decoded) != b.value() {
+				t.Errorf("bars decode to %s, want %s", decoded, b.value())
+			}
+
+			if _, withText, _ := b.layout(true); withText != height+barcodeDigitsSpace {
+				t.Errorf("height with text = %d, want %d", withText, height+barcodeDigitsSpace)
+			}
+		})
+	}
+}
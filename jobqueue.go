@@ -0,0 +1,1007 @@
+package main
+
+import (
+	"container/heap"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Error codes of the jobs API
+const (
+	errCodeJobNotFound     = "job_not_found"
+	errCodeJobExists       = "job_exists"
+	errCodeJobsUnavailable = "jobs_unavailable"
+)
+
+// Statuses of jobs in the job queue, beside jobSucceeded and jobFailed
+const (
+	jobQueued  = "queued"
+	jobRunning = "running"
+)
+
+// Priorities of jobs. Interactive jobs, such as single codes a user waits
+// for, are served before bulk jobs.
+const (
+	jobInteractive = "interactive"
+	jobBulk        = "bulk"
+)
+
+// jobPriorities lists the priorities in the order they are served
+var jobPriorities = []string{jobInteractive, jobBulk}
+
+// maxInteractiveJobs bounds the jobs of an interactive submission;
+// submissions of more jobs are bulk
+const maxInteractiveJobs = 10
+
+const (
+	// jobLease bounds an attempt at a job. A job claimed longer ago, by a
+	// replica that stopped without finishing it, is queued again.
+	jobLease = 2 * time.Minute
+	// jobPollInterval is how often workers look for jobs submitted to
+	// other replicas
+	jobPollInterval = time.Second
+)
+
+var (
+	// errJobQueueFull is returned when submitting beyond the queue's capacity
+	errJobQueueFull = errors.New("job queue is full")
+	// errJobExists is returned when submitting a job ID the tenant already has
+	errJobExists = errors.New("job already exists")
+	// errJobLeaseLost is returned when storing the progress of a job whose
+	// lease expired, so it was queued again
+	errJobLeaseLost = errors.New("job lease expired")
+)
+
+// queuedJob is a job submitted to the jobs API, with its progress
+type queuedJob struct {
+	ID       string `json:"id"`
+	Text     string `json:"text"`
+	Status   string `json:"status"`
+	Priority string `json:"priority"`
+	Attempts int    `json:"attempts"`
+	URL      string `json:"url,omitempty"`
+	// Error is the last failure; a queued job with an error waits for its
+	// retry at RetryAt
+	Error      *apiError  `json:"error,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	RetryAt    *time.Time `json:"retry_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+
+	tenant string
+	// readyAt orders the waiting jobs of a tenant, and seq orders those
+	// ready at once
+	readyAt time.Time
+	seq     uint64
+	// index is the position in the tenant's waiting heap
+	index int
+}
+
+// jobExistsError reports the ID of a job submitted twice
+type jobExistsError struct {
+	id string
+}
+
+func (e *jobExistsError) Error() string { return "job " + e.id + " already exists" }
+
+func (e *jobExistsError) Is(target error) bool { return target == errJobExists }
+
+// jobKey identifies a job; every tenant has its own namespace of job IDs
+func jobKey(tenant, id string) string {
+	return tenant + "/" + id
+}
+
+// jobStore keeps the jobs of the job queue. A job is claimed by one worker
+// at a time, which then retries or finishes it. Ready jobs are claimed by
+// priority, and within a priority from each tenant in turn, so a tenant's
+// large batch doesn't hold up other tenants' jobs.
+type jobStore interface {
+	// submit queues jobs of one tenant and priority, all or none. It
+	// returns errJobQueueFull beyond the capacity, and a jobExistsError for
+	// an ID the tenant already has.
+	submit(ctx context.Context, jobs []queuedJob) error
+	get(ctx context.Context, tenant, id string) (queuedJob, bool, error)
+	// dead returns the dead-lettered jobs of tenant, the latest first
+	dead(ctx context.Context, tenant string, now time.Time) ([]queuedJob, error)
+	// requeue queues a dead-lettered job again as job, reporting whether
+	// there is such a job
+	requeue(ctx context.Context, job queuedJob, now time.Time) (bool, error)
+	// claim takes a job ready at now: of the first priority with one, the
+	// next tenant's in turn, and the tenant's earliest. When none is ready, it
+	// returns how long until the first job is ready, or zero when none is
+	// waiting.
+	claim(ctx context.Context, now time.Time) (*queuedJob, time.Duration, error)
+	// retry puts a claimed job back, ready at readyAt
+	retry(ctx context.Context, job queuedJob, readyAt time.Time) error
+	// finish records the final status of a claimed job
+	finish(ctx context.Context, job queuedJob) error
+}
+
+// jobQueue processes jobs submitted to the jobs API in the background. A
+// job failing transiently goes back into the queue until the retry policy's
+// backoff has passed, so waiting for a retry never blocks a worker. A job out
+// of attempts, or failing for good, is dead-lettered: it is kept with its
+// error, listed by GET /api/v1/jobs/dead, until it is requeued or its
+// retention ends.
+type jobQueue struct {
+	store     jobStore
+	processor *jobProcessor
+	// capacity bounds the jobs waiting or running
+	capacity int
+	now      func() time.Time
+
+	mu sync.Mutex
+	// changed is closed and replaced when a job of this replica starts waiting
+	changed chan struct{}
+}
+
+// newJobQueue keeps jobs in Redis at redisURL, shared by all replicas, or in
+// memory when redisURL is empty
+func newJobQueue(processor *jobProcessor, redisURL string, capacity int, retention time.Duration) (*jobQueue, error) {
+	q := &jobQueue{processor: processor, capacity: capacity, now: time.Now, changed: make(chan struct{})}
+	if redisURL == "" {
+		q.store = &memoryJobStore{capacity: capacity, retention: retention, jobs: map[string]*queuedJob{}, classes: map[string]*jobClass{}}
+		return q, nil
+	}
+	opts, err := redis.ParseURL(redisURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid REDIS_URL: %w", err)
+	}
+	q.store = &redisJobStore{client: redis.NewClient(opts), prefix: "qr:jobs:", capacity: capacity, retention: retention}
+	return q, nil
+}
+
+// shared reports whether the jobs are shared between replicas
+func (q *jobQueue) shared() bool {
+	_, ok := q.store.(*redisJobStore)
+	return ok
+}
+
+// check reports whether the Redis server of a shared store answers, for the
+// health endpoint
+func (q *jobQueue) check(ctx context.Context) error {
+	if s, ok := q.store.(*redisJobStore); ok {
+		return s.client.Ping(ctx).Err()
+	}
+	return nil
+}
+
+// Close releases the Redis connections of a shared store
+func (q *jobQueue) Close() error {
+	if s, ok := q.store.(*redisJobStore); ok {
+		return s.client.Close()
+	}
+	return nil
+}
+
+// submit queues jobs of tenant with priority, all or none. Jobs without an
+// ID get a random one.
+func (q *jobQueue) submit(ctx context.Context, tenant, priority string, jobs []generationJob) ([]queuedJob, error) {
+	now := q.now()
+	queued := make([]queuedJob, len(jobs))
+	seen := map[string]bool{}
+	for i, job := range jobs {
+		if job.ID == "" {
+			job.ID = newRequestID()
+		}
+		if seen[job.ID] {
+			return nil, &jobExistsError{id: job.ID}
+		}
+		seen[job.ID] = true
+		queued[i] = queuedJob{ID: job.ID, Text: job.Text, Status: jobQueued, Priority: priority, CreatedAt: now, tenant: tenant}
+	}
+	if err := q.store.submit(ctx, queued); err != nil {
+		return nil, err
+	}
+	q.notify()
+	return queued, nil
+}
+
+// requeue queues a dead-lettered job of tenant again, with all its
+// attempts. It reports whether there is such a job.
+func (q *jobQueue) requeue(ctx context.Context, tenant, id string) (queuedJob, bool, error) {
+	job, ok, err := q.store.get(ctx, tenant, id)
+	if err != nil || !ok || job.Status != jobFailed {
+		return queuedJob{}, false, err
+	}
+	job.Status, job.Attempts, job.URL, job.Error, job.RetryAt, job.FinishedAt = jobQueued, 0, "", nil, nil, nil
+	if ok, err = q.store.requeue(ctx, job, q.now()); err != nil || !ok {
+		return queuedJob{}, ok, err
+	}
+	q.notify()
+	return job, true, nil
+}
+
+// notify wakes up the workers waiting for jobs
+func (q *jobQueue) notify() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	close(q.changed)
+	q.changed = make(chan struct{})
+}
+
+// run processes jobs on workers goroutines until ctx is done
+func (q *jobQueue) run(ctx context.Context, workers int) {
+	var wg sync.WaitGroup
+	for range max(workers, 1) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				q.mu.Lock()
+				changed := q.changed
+				q.mu.Unlock()
+
+				job, wait, err := q.store.claim(ctx, q.now())
+				if err != nil {
+					if ctx.Err() == nil {
+						slog.ErrorContext(ctx, "failed to claim a job", "error", err)
+					}
+					wait = jobPollInterval
+				}
+				if job != nil {
+					q.process(ctx, *job)
+					continue
+				}
+				// Jobs submitted to other replicas don't wake up this one
+				if q.shared() && (wait == 0 || wait > jobPollInterval) {
+					wait = jobPollInterval
+				}
+				var timer *time.Timer
+				var ready <-chan time.Time
+				if wait > 0 {
+					timer = time.NewTimer(wait)
+					ready = timer.C
+				}
+				select {
+				case <-ctx.Done():
+				case <-changed:
+				case <-ready:
+				}
+				if timer != nil {
+					timer.Stop()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// process makes one attempt at a claimed job, then finishes it or puts it
+// back for a retry. Jobs interrupted by shutdown are put back without using
+// up an attempt, for another worker or replica.
+func (q *jobQueue) process(ctx context.Context, job queuedJob) {
+	processor := *q.processor
+	processor.store = prefixedStore{objectStore: q.processor.store, prefix: "jobs"}
+	if job.tenant != "" {
+		processor.store = prefixedStore{objectStore: q.processor.store, prefix: "jobs/" + url.PathEscape(job.tenant)}
+	}
+	attemptCtx, cancel := context.WithTimeout(ctx, jobLease)
+	result, err := processor.attempt(attemptCtx, generationJob{ID: job.ID, Text: job.Text})
+	cancel()
+
+	// The outcome is stored even when shutdown has begun
+	storeCtx := context.WithoutCancel(ctx)
+	now := q.now()
+	if ctx.Err() != nil {
+		job.Status = jobQueued
+		q.stored(storeCtx, job, q.store.retry(storeCtx, job, now))
+		return
+	}
+	job.Attempts++
+	job.Error = result.Error
+	if errors.Is(err, errJobRetryable) && job.Attempts < processor.attempts {
+		slog.WarnContext(ctx, "job failed, retrying", "source", processor.source, "job_id", job.ID, "attempt", job.Attempts, "error", err)
+		processor.deps.metrics.jobRetries.WithLabelValues(processor.source).Inc()
+		retryAt := now.Add(processor.delay(job.Attempts))
+		job.Status, job.RetryAt = jobQueued, &retryAt
+		if q.stored(storeCtx, job, q.store.retry(storeCtx, job, retryAt)) {
+			q.notify()
+		}
+		return
+	}
+	processor.finish(ctx, result)
+	job.Status, job.URL, job.RetryAt, job.FinishedAt = result.Status, result.URL, nil, &now
+	if q.stored(storeCtx, job, q.store.finish(storeCtx, job)) && result.Status == jobFailed {
+		slog.WarnContext(ctx, "job dead-lettered", "source", processor.source, "job_id", job.ID, "tenant", job.tenant, "attempts", job.Attempts)
+	}
+}
+
+// stored logs a failure to store the progress of a job, reporting whether
+// it was stored
+func (q *jobQueue) stored(ctx context.Context, job queuedJob, err error) bool {
+	switch {
+	case errors.Is(err, errJobLeaseLost):
+		slog.WarnContext(ctx, "job lease expired, it was queued again", "job_id", job.ID, "tenant", job.tenant)
+	case err != nil:
+		slog.ErrorContext(ctx, "failed to store job progress", "job_id", job.ID, "tenant", job.tenant, "error", err)
+	}
+	return err == nil
+}
+
+// jobsRequest is the body of POST /api/v1/jobs
+type jobsRequest struct {
+	// Priority defaults to interactive for up to maxInteractiveJobs items,
+	// and bulk otherwise
+	Priority string          `json:"priority"`
+	Items    []generationJob `json:"items"`
+}
+
+// handleSubmit queues the jobs of the request body for the caller's tenant
+// and answers 202 with their status
+func (q *jobQueue) handleSubmit(deps handlerDeps) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var body jobsRequest
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || len(body.Items) == 0 {
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				writeAPIError(w, r, http.StatusRequestEntityTooLarge, *apiErrorf(errCodeBodyTooLarge,
+					map[string]any{"max_bytes": maxBytesErr.Limit},
+					"Request body exceeds %d bytes", maxBytesErr.Limit))
+				return
+			}
+			writeAPIError(w, r, http.StatusBadRequest, apiError{
+				Code:    errCodeInvalidPayload,
+				Message: `Invalid body. Usage: POST /api/v1/jobs with {"items": [{"id": "...", "text": "..."}, ...]}`,
+			})
+			return
+		}
+		if deps.batchMaxItems > 0 && len(body.Items) > deps.batchMaxItems {
+			writeAPIError(w, r, http.StatusRequestEntityTooLarge, *apiErrorf(errCodePayloadTooLarge,
+				map[string]any{"items": len(body.Items), "max_items": deps.batchMaxItems},
+				"Batch has %d items, the maximum is %d", len(body.Items), deps.batchMaxItems))
+			return
+		}
+		for i, job := range body.Items {
+			apiErr := job.check()
+			if apiErr == nil && job.ID == "dead" {
+				// Reserved for GET /api/v1/jobs/dead
+				apiErr = &apiError{Code: errCodeInvalidParam, Message: "Job 'id' must not be \"dead\""}
+			}
+			if apiErr != nil {
+				apiErr.Details = map[string]any{"index": i}
+				writeAPIError(w, r, http.StatusBadRequest, *apiErr)
+				return
+			}
+		}
+		switch {
+		case body.Priority == "" && len(body.Items) <= maxInteractiveJobs:
+			body.Priority = jobInteractive
+		case body.Priority == "":
+			body.Priority = jobBulk
+		case !slices.Contains(jobPriorities, body.Priority):
+			writeAPIError(w, r, http.StatusBadRequest, apiError{Code: errCodeInvalidParam, Message: `Job 'priority' must be "interactive" or "bulk"`})
+			return
+		case body.Priority == jobInteractive && len(body.Items) > maxInteractiveJobs:
+			writeAPIError(w, r, http.StatusBadRequest, *apiErrorf(errCodeInvalidParam,
+				map[string]any{"items": len(body.Items), "max_items": maxInteractiveJobs},
+				"Interactive submissions have at most %d items, submit larger ones as bulk", maxInteractiveJobs))
+			return
+		}
+
+		jobs, err := q.submit(r.Context(), tenantFromContext(r.Context()), body.Priority, body.Items)
+		var exists *jobExistsError
+		switch {
+		case errors.As(err, &exists):
+			writeAPIError(w, r, http.StatusConflict, *apiErrorf(errCodeJobExists, map[string]any{"id": exists.id}, "Job %q already exists", exists.id))
+			return
+		case errors.Is(err, errJobQueueFull):
+			q.writeFull(w, r)
+			return
+		case err != nil:
+			q.writeStoreError(w, r, err)
+			return
+		}
+		addLogAttrs(r.Context(), slog.Int("batch_items", len(jobs)), slog.String("job_priority", body.Priority))
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(map[string]any{"jobs": jobs})
+	}
+}
+
+// handleGet returns a job of the caller's tenant
+func (q *jobQueue) handleGet(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	job, ok, err := q.store.get(r.Context(), tenantFromContext(r.Context()), id)
+	if err != nil {
+		q.writeStoreError(w, r, err)
+		return
+	}
+	if !ok {
+		writeAPIError(w, r, http.StatusNotFound, *apiErrorf(errCodeJobNotFound, map[string]any{"id": id}, "Unknown job %q", id))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(job)
+}
+
+// handleDead lists the dead-lettered jobs of the caller's tenant
+func (q *jobQueue) handleDead(w http.ResponseWriter, r *http.Request) {
+	jobs, err := q.store.dead(r.Context(), tenantFromContext(r.Context()), q.now())
+	if err != nil {
+		q.writeStoreError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(map[string]any{"jobs": jobs})
+}
+
+// handleRequeue queues a dead-lettered job of the caller's tenant again
+func (q *jobQueue) handleRequeue(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	job, ok, err := q.requeue(r.Context(), tenantFromContext(r.Context()), id)
+	switch {
+	case errors.Is(err, errJobQueueFull):
+		q.writeFull(w, r)
+		return
+	case err != nil:
+		q.writeStoreError(w, r, err)
+		return
+	case !ok:
+		writeAPIError(w, r, http.StatusNotFound, *apiErrorf(errCodeJobNotFound, map[string]any{"id": id}, "No dead-lettered job %q", id))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(job)
+}
+
+// writeFull answers requests that would overflow the queue with 503
+func (q *jobQueue) writeFull(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Retry-After", "1")
+	writeAPIError(w, r, http.StatusServiceUnavailable, *apiErrorf(errCodeServerBusy,
+		map[string]any{"capacity": q.capacity}, "The job queue is full, retry later"))
+}
+
+// writeStoreError answers requests the store failed with 503
+func (q *jobQueue) writeStoreError(w http.ResponseWriter, r *http.Request, err error) {
+	slog.ErrorContext(r.Context(), "job store failed", "error", err)
+	setRequestError(r.Context(), err)
+	writeAPIError(w, r, http.StatusServiceUnavailable, apiError{Code: errCodeJobsUnavailable, Message: "Jobs are unavailable, retry later"})
+}
+
+// jobHeap is a heap of waiting jobs, the earliest ready first
+type jobHeap []*queuedJob
+
+func (h jobHeap) Len() int { return len(h) }
+
+func (h jobHeap) Less(i, j int) bool {
+	if !h[i].readyAt.Equal(h[j].readyAt) {
+		return h[i].readyAt.Before(h[j].readyAt)
+	}
+	return h[i].seq < h[j].seq
+}
+
+func (h jobHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index, h[j].index = i, j
+}
+
+func (h *jobHeap) Push(x any) {
+	job := x.(*queuedJob)
+	job.index = len(*h)
+	*h = append(*h, job)
+}
+
+func (h *jobHeap) Pop() any {
+	old := *h
+	job := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	return job
+}
+
+// jobClass holds the waiting jobs of a priority class by tenant, served
+// round-robin
+type jobClass struct {
+	tenants map[string]*jobHeap
+	// order lists the tenants with waiting jobs, and next is the one served
+	// next
+	order []string
+	next  int
+}
+
+// memoryJobStore keeps jobs in the replica's memory; they are processed by
+// the replica they were submitted to and lost on restart
+type memoryJobStore struct {
+	capacity int
+	// retention is how long finished jobs are kept
+	retention time.Duration
+
+	mu sync.Mutex
+	// jobs is keyed by jobKey
+	jobs map[string]*queuedJob
+	// classes holds the waiting jobs by priority
+	classes map[string]*jobClass
+	// active counts the jobs waiting or running
+	active int
+	seq    uint64
+	pruned time.Time
+}
+
+func (s *memoryJobStore) submit(_ context.Context, jobs []queuedJob) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.active+len(jobs) > s.capacity {
+		return errJobQueueFull
+	}
+	for _, job := range jobs {
+		if _, ok := s.jobs[jobKey(job.tenant, job.ID)]; ok {
+			return &jobExistsError{id: job.ID}
+		}
+	}
+	for _, job := range jobs {
+		stored := job
+		s.jobs[jobKey(job.tenant, job.ID)] = &stored
+		s.wait(&stored, job.CreatedAt)
+	}
+	s.active += len(jobs)
+	return nil
+}
+
+// wait puts a job into its tenant's heap of its class, ready at readyAt. A
+// tenant without waiting jobs is served after the others. The caller holds
+// s.mu.
+func (s *memoryJobStore) wait(job *queuedJob, readyAt time.Time) {
+	class := s.classes[job.Priority]
+	if class == nil {
+		class = &jobClass{tenants: map[string]*jobHeap{}}
+		s.classes[job.Priority] = class
+	}
+	waiting := class.tenants[job.tenant]
+	if waiting == nil {
+		waiting = &jobHeap{}
+		class.tenants[job.tenant] = waiting
+		// Insert before the tenant served next, making it the last in turn
+		class.order = slices.Insert(class.order, class.next, job.tenant)
+		class.next = (class.next + 1) % len(class.order)
+	}
+	s.seq++
+	job.readyAt, job.seq = readyAt, s.seq
+	heap.Push(waiting, job)
+}
+
+func (s *memoryJobStore) get(_ context.Context, tenant, id string) (queuedJob, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job, ok := s.jobs[jobKey(tenant, id)]
+	if !ok {
+		return queuedJob{}, false, nil
+	}
+	return *job, true, nil
+}
+
+func (s *memoryJobStore) dead(_ context.Context, tenant string, _ time.Time) ([]queuedJob, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	dead := []queuedJob{}
+	for _, job := range s.jobs {
+		if job.tenant == tenant && job.Status == jobFailed {
+			dead = append(dead, *job)
+		}
+	}
+	slices.SortFunc(dead, func(a, b queuedJob) int { return b.FinishedAt.Compare(*a.FinishedAt) })
+	return dead, nil
+}
+
+func (s *memoryJobStore) requeue(_ context.Context, job queuedJob, now time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stored, ok := s.jobs[jobKey(job.tenant, job.ID)]
+	if !ok || stored.Status != jobFailed {
+		return false, nil
+	}
+	if s.active >= s.capacity {
+		return true, errJobQueueFull
+	}
+	*stored = job
+	s.wait(stored, now)
+	s.active++
+	return true, nil
+}
+
+func (s *memoryJobStore) claim(_ context.Context, now time.Time) (*queuedJob, time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if now.Sub(s.pruned) > time.Minute {
+		s.prune(now)
+	}
+	var wait time.Duration
+	for _, priority := range jobPriorities {
+		class := s.classes[priority]
+		if class == nil {
+			continue
+		}
+		for range len(class.order) {
+			tenant := class.order[class.next]
+			waiting := class.tenants[tenant]
+			if ready := (*waiting)[0].readyAt.Sub(now); ready > 0 {
+				if wait == 0 || ready < wait {
+					wait = ready
+				}
+				class.next = (class.next + 1) % len(class.order)
+				continue
+			}
+			job := heap.Pop(waiting).(*queuedJob)
+			if waiting.Len() == 0 {
+				delete(class.tenants, tenant)
+				class.order = slices.Delete(class.order, class.next, class.next+1)
+			} else {
+				class.next++
+			}
+			if class.next >= len(class.order) {
+				class.next = 0
+			}
+			job.Status, job.RetryAt = jobRunning, nil
+			claimed := *job
+			return &claimed, 0, nil
+		}
+	}
+	return nil, wait, nil
+}
+
+// prune forgets jobs finished longer than the retention ago. The caller
+// holds s.mu.
+func (s *memoryJobStore) prune(now time.Time) {
+	s.pruned = now
+	for key, job := range s.jobs {
+		if job.FinishedAt != nil && now.Sub(*job.FinishedAt) > s.retention {
+			delete(s.jobs, key)
+		}
+	}
+}
+
+func (s *memoryJobStore) retry(_ context.Context, job queuedJob, readyAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stored := s.jobs[jobKey(job.tenant, job.ID)]
+	*stored = job
+	s.wait(stored, readyAt)
+	return nil
+}
+
+func (s *memoryJobStore) finish(_ context.Context, job queuedJob) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	*s.jobs[jobKey(job.tenant, job.ID)] = job
+	s.active--
+	return nil
+}
+
+// redisJobStore keeps jobs in Redis, shared by all replicas. Every job is a
+// JSON value at job:<tenant>/<id>. The sorted set waiting:<priority>:<tenant>
+// holds a tenant's waiting jobs of a priority by the time they are ready, and
+// the list tenants:<priority> the tenants with waiting jobs, rotated as they
+// are served. The sorted set running holds the claimed jobs as
+// <priority>:<tenant>/<id> by the end of their lease, after which they are
+// waiting again, and active counts the jobs waiting or running. The sorted
+// set dead:<tenant> holds a tenant's dead-lettered jobs by when they failed.
+// Finished jobs expire after the retention.
+type redisJobStore struct {
+	client    *redis.Client
+	prefix    string
+	capacity  int
+	retention time.Duration
+}
+
+// redisJob is the JSON value of a job in Redis
+type redisJob struct {
+	queuedJob
+	Tenant string `json:"tenant"`
+}
+
+func (s *redisJobStore) key(name string) string {
+	return s.prefix + name
+}
+
+func (s *redisJobStore) jobKey(tenant, id string) string {
+	return s.prefix + "job:" + jobKey(tenant, id)
+}
+
+func (s *redisJobStore) encode(job queuedJob) (string, error) {
+	value, err := json.Marshal(redisJob{queuedJob: job, Tenant: job.tenant})
+	return string(value), err
+}
+
+func (s *redisJobStore) decode(value string) (queuedJob, error) {
+	var job redisJob
+	if err := json.Unmarshal([]byte(value), &job); err != nil {
+		return queuedJob{}, fmt.Errorf("job: %w", err)
+	}
+	job.queuedJob.tenant = job.Tenant
+	return job.queuedJob, nil
+}
+
+// redisJobScript prefixes the scripts of redisJobStore, whose keys are below
+// ARGV[1], with enqueue, which puts a job into its tenant's waiting jobs and
+// the tenant into its priority's rotation
+const redisJobScript = `
+local prefix = ARGV[1]
+local function enqueue(priority, tenant, id, score)
+	local key = prefix .. 'waiting:' .. priority .. ':' .. tenant
+	if redis.call('ZADD', key, score, id) == 1 and redis.call('ZCARD', key) == 1 then
+		redis.call('LPUSH', prefix .. 'tenants:' .. priority, tenant)
+	end
+end
+`
+
+// redisSubmitJobs queues the ARGV[6] jobs of the tenant ARGV[4] with the
+// priority ARGV[3] unless the queue would overflow or one exists: it
+// returns -1 when full, the 1-based index of an existing job, or 0
+var redisSubmitJobs = redis.NewScript(redisJobScript + `
+local tenant, n = ARGV[4], tonumber(ARGV[6])
+if tonumber(redis.call('GET', prefix .. 'active') or 0) + n > tonumber(ARGV[2]) then
+	return -1
+end
+for i = 1, n do
+	if redis.call('EXISTS', prefix .. 'job:' .. tenant .. '/' .. ARGV[6 + i]) == 1 then
+		return i
+	end
+end
+for i = 1, n do
+	redis.call('SET', prefix .. 'job:' .. tenant .. '/' .. ARGV[6 + i], ARGV[6 + n + i])
+	enqueue(ARGV[3], tenant, ARGV[6 + i], ARGV[5])
+end
+redis.call('INCRBY', prefix .. 'active', n)
+return 0
+`)
+
+// submit queues jobs of one tenant and priority
+func (s *redisJobStore) submit(ctx context.Context, jobs []queuedJob) error {
+	ids := make([]any, 0, len(jobs))
+	values := make([]any, 0, len(jobs))
+	for _, job := range jobs {
+		value, err := s.encode(job)
+		if err != nil {
+			return err
+		}
+		ids = append(ids, job.ID)
+		values = append(values, value)
+	}
+	args := []any{s.prefix, s.capacity, jobs[0].Priority, jobs[0].tenant, jobs[0].CreatedAt.UnixMilli(), len(jobs)}
+	args = append(append(args, ids...), values...)
+	result, err := redisSubmitJobs.Run(ctx, s.client, nil, args...).Int()
+	switch {
+	case err != nil:
+		return err
+	case result < 0:
+		return errJobQueueFull
+	case result > 0:
+		return &jobExistsError{id: jobs[result-1].ID}
+	}
+	return nil
+}
+
+func (s *redisJobStore) get(ctx context.Context, tenant, id string) (queuedJob, bool, error) {
+	value, err := s.client.Get(ctx, s.jobKey(tenant, id)).Result()
+	if errors.Is(err, redis.Nil) {
+		return queuedJob{}, false, nil
+	}
+	if err != nil {
+		return queuedJob{}, false, err
+	}
+	job, err := s.decode(value)
+	return job, err == nil, err
+}
+
+func (s *redisJobStore) dead(ctx context.Context, tenant string, now time.Time) ([]queuedJob, error) {
+	key := s.key("dead:" + tenant)
+	expired := strconv.FormatInt(now.Add(-s.retention).UnixMilli(), 10)
+	if err := s.client.ZRemRangeByScore(ctx, key, "-inf", expired).Err(); err != nil {
+		return nil, err
+	}
+	ids, err := s.client.ZRevRange(ctx, key, 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+	dead := []queuedJob{}
+	if len(ids) == 0 {
+		return dead, nil
+	}
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = s.jobKey(tenant, id)
+	}
+	values, err := s.client.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+	for _, value := range values {
+		value, ok := value.(string)
+		if !ok {
+			continue
+		}
+		job, err := s.decode(value)
+		if err != nil {
+			return nil, err
+		}
+		if job.Status == jobFailed {
+			dead = append(dead, job)
+		}
+	}
+	return dead, nil
+}
+
+// redisRequeueJob moves the job ARGV[5] of the tenant ARGV[4] from its dead
+// letters back to the waiting jobs of the priority ARGV[3]: it returns 0
+// when it isn't dead-lettered, -1 when the queue is full, and 1 otherwise
+var redisRequeueJob = redis.NewScript(redisJobScript + `
+local tenant, id = ARGV[4], ARGV[5]
+if not redis.call('ZSCORE', prefix .. 'dead:' .. tenant, id) then
+	return 0
+end
+if tonumber(redis.call('GET', prefix .. 'active') or 0) >= tonumber(ARGV[2]) then
+	return -1
+end
+redis.call('ZREM', prefix .. 'dead:' .. tenant, id)
+redis.call('SET', prefix .. 'job:' .. tenant .. '/' .. id, ARGV[6])
+enqueue(ARGV[3], tenant, id, ARGV[7])
+redis.call('INCR', prefix .. 'active')
+return 1
+`)
+
+func (s *redisJobStore) requeue(ctx context.Context, job queuedJob, now time.Time) (bool, error) {
+	value, err := s.encode(job)
+	if err != nil {
+		return false, err
+	}
+	result, err := redisRequeueJob.Run(ctx, s.client, nil, s.prefix, s.capacity, job.Priority, job.tenant, job.ID, value, now.UnixMilli()).Int()
+	switch {
+	case err != nil:
+		return false, err
+	case result < 0:
+		return true, errJobQueueFull
+	}
+	return result == 1, nil
+}
+
+// redisClaimJob queues the jobs of expired leases again, then claims the
+// first job ready at ARGV[2] of the next tenant in turn, with a lease until
+// ARGV[3], trying the priorities ARGV[4:] in order. It returns the job's
+// value, the milliseconds until the first job is ready, or nil when none is
+// waiting.
+var redisClaimJob = redis.NewScript(redisJobScript + `
+local now = tonumber(ARGV[2])
+for _, member in ipairs(redis.call('ZRANGEBYSCORE', prefix .. 'running', '-inf', now)) do
+	redis.call('ZREM', prefix .. 'running', member)
+	local priority, tenant, id = member:match('^([^:]*):(.*)/([^/]*)$')
+	enqueue(priority, tenant, id, now)
+end
+local wait = false
+for p = 4, #ARGV do
+	local priority = ARGV[p]
+	local tenants = prefix .. 'tenants:' .. priority
+	for _ = 1, redis.call('LLEN', tenants) do
+		local tenant = redis.call('RPOPLPUSH', tenants, tenants)
+		if not tenant then
+			break
+		end
+		local key = prefix .. 'waiting:' .. priority .. ':' .. tenant
+		local first = redis.call('ZRANGE', key, 0, 0, 'WITHSCORES')
+		if #first == 0 then
+			redis.call('LREM', tenants, 0, tenant)
+		elseif tonumber(first[2]) <= now then
+			redis.call('ZREM', key, first[1])
+			if redis.call('ZCARD', key) == 0 then
+				redis.call('LREM', tenants, 0, tenant)
+			end
+			local value = redis.call('GET', prefix .. 'job:' .. tenant .. '/' .. first[1])
+			if value then
+				redis.call('ZADD', prefix .. 'running', ARGV[3], priority .. ':' .. tenant .. '/' .. first[1])
+				return value
+			end
+			redis.call('DECR', prefix .. 'active')
+		elseif not wait or tonumber(first[2]) - now < wait then
+			wait = tonumber(first[2]) - now
+		end
+	end
+end
+return wait
+`)
+
+func (s *redisJobStore) claim(ctx context.Context, now time.Time) (*queuedJob, time.Duration, error) {
+	args := []any{s.prefix, now.UnixMilli(), now.Add(jobLease).UnixMilli()}
+	for _, priority := range jobPriorities {
+		args = append(args, priority)
+	}
+	result, err := redisClaimJob.Run(ctx, s.client, nil, args...).Result()
+	if errors.Is(err, redis.Nil) {
+		return nil, 0, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	value, ok := result.(string)
+	if !ok {
+		wait, _ := result.(int64)
+		return nil, time.Duration(wait) * time.Millisecond, nil
+	}
+	job, err := s.decode(value)
+	if err != nil {
+		return nil, 0, err
+	}
+	job.Status, job.RetryAt = jobRunning, nil
+	if value, err = s.encode(job); err != nil {
+		return nil, 0, err
+	}
+	if err := s.client.Set(ctx, s.jobKey(job.tenant, job.ID), value, 0).Err(); err != nil {
+		return nil, 0, err
+	}
+	return &job, 0, nil
+}
+
+// runningMember is the member of a claimed job in the sorted set running
+func (s *redisJobStore) runningMember(job queuedJob) string {
+	return job.Priority + ":" + jobKey(job.tenant, job.ID)
+}
+
+// redisRetryJob moves the claimed job ARGV[2] back to the waiting jobs,
+// ready at ARGV[4]. It returns 0 when the job's lease expired.
+var redisRetryJob = redis.NewScript(redisJobScript + `
+if redis.call('ZREM', prefix .. 'running', ARGV[2]) == 0 then
+	return 0
+end
+redis.call('SET', prefix .. 'job:' .. ARGV[6] .. '/' .. ARGV[7], ARGV[3])
+enqueue(ARGV[5], ARGV[6], ARGV[7], ARGV[4])
+return 1
+`)
+
+func (s *redisJobStore) retry(ctx context.Context, job queuedJob, readyAt time.Time) error {
+	value, err := s.encode(job)
+	if err != nil {
+		return err
+	}
+	result, err := redisRetryJob.Run(ctx, s.client, nil, s.prefix, s.runningMember(job), value, readyAt.UnixMilli(),
+		job.Priority, job.tenant, job.ID).Int()
+	if err == nil && result == 0 {
+		err = errJobLeaseLost
+	}
+	return err
+}
+
+// redisFinishJob removes the claimed job ARGV[2] and stores it for ARGV[4]
+// milliseconds, adding it to its tenant's dead letters when ARGV[5] is set.
+// It returns 0 when the job's lease expired.
+var redisFinishJob = redis.NewScript(redisJobScript + `
+if redis.call('ZREM', prefix .. 'running', ARGV[2]) == 0 then
+	return 0
+end
+redis.call('SET', prefix .. 'job:' .. ARGV[6] .. '/' .. ARGV[7], ARGV[3], 'PX', ARGV[4])
+redis.call('DECR', prefix .. 'active')
+if ARGV[5] ~= '' then
+	redis.call('ZADD', prefix .. 'dead:' .. ARGV[6], ARGV[5], ARGV[7])
+	redis.call('PEXPIRE', prefix .. 'dead:' .. ARGV[6], ARGV[4])
+end
+return 1
+`)
+
+func (s *redisJobStore) finish(ctx context.Context, job queuedJob) error {
+	value, err := s.encode(job)
+	if err != nil {
+		return err
+	}
+	failedAt := ""
+	if job.Status == jobFailed {
+		failedAt = strconv.FormatInt(job.FinishedAt.UnixMilli(), 10)
+	}
+	result, err := redisFinishJob.Run(ctx, s.client, nil, s.prefix, s.runningMember(job), value,
+		max(s.retention.Milliseconds(), 1), failedAt, job.tenant, job.ID).Int()
+	if err == nil && result == 0 {
+		err = errJobLeaseLost
+	}
+	return err
+}
@@ -0,0 +1,381 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+// newTestJobQueues returns constructors of queues over each job store
+func newTestJobQueues() map[string]func(t *testing.T, processor *jobProcessor, capacity int) *jobQueue {
+	return map[string]func(t *testing.T, processor *jobProcessor, capacity int) *jobQueue{
+		"memory": func(t *testing.T, processor *jobProcessor, capacity int) *jobQueue {
+			q, err := newJobQueue(processor, "", capacity, time.Hour)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return q
+		},
+		"redis": func(t *testing.T, processor *jobProcessor, capacity int) *jobQueue {
+			server := miniredis.RunT(t)
+			q, err := newJobQueue(processor, "redis://"+server.Addr(), capacity, time.Hour)
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { q.Close() })
+			return q
+		},
+	}
+}
+
+// waitForJob polls q until the job of tenant has status
+func waitForJob(t *testing.T, q *jobQueue, tenant, id, status string) queuedJob {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		job, ok, err := q.store.get(context.Background(), tenant, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok && job.Status == status {
+			return job
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("job %q = %+v, want status %q", id, job, status)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestJobStores(t *testing.T) {
+	for name, newQueue := range newTestJobQueues() {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			s := newQueue(t, newTestJobProcessor(&memoryStore{}), 4).store
+			now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+			job := func(tenant, id string) queuedJob {
+				return queuedJob{ID: id, Text: "hello", Status: jobQueued, Priority: jobBulk, CreatedAt: now, tenant: tenant}
+			}
+
+			if job, wait, err := s.claim(ctx, now); job != nil || wait != 0 || err != nil {
+				t.Fatalf("claim of empty store = %v, %v, %v", job, wait, err)
+			}
+			for _, tenant := range []string{"acme", "globex"} {
+				if err := s.submit(ctx, []queuedJob{job(tenant, "a")}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			var exists *jobExistsError
+			if err := s.submit(ctx, []queuedJob{job("acme", "b"), job("acme", "a")}); !errors.As(err, &exists) || exists.id != "a" {
+				t.Fatalf("submit of existing job error = %v, want it exists", err)
+			}
+			if _, ok, _ := s.get(ctx, "acme", "b"); ok {
+				t.Fatal("job of a rejected submission was queued")
+			}
+			if err := s.submit(ctx, []queuedJob{job("acme", "b"), job("acme", "c"), job("acme", "d")}); !errors.Is(err, errJobQueueFull) {
+				t.Fatalf("submit beyond the capacity error = %v, want errJobQueueFull", err)
+			}
+
+			claimed, _, err := s.claim(ctx, now)
+			if err != nil || claimed == nil || claimed.ID != "a" || claimed.Status != jobRunning {
+				t.Fatalf("claim = %+v, %v", claimed, err)
+			}
+			if got, _, _ := s.get(ctx, claimed.tenant, "a"); got.Status != jobRunning {
+				t.Errorf("claimed job status = %q, want running", got.Status)
+			}
+
+			// A retried job waits for its backoff
+			claimed.Status, claimed.Attempts = jobQueued, 1
+			if err := s.retry(ctx, *claimed, now.Add(time.Minute)); err != nil {
+				t.Fatal(err)
+			}
+			other, _, err := s.claim(ctx, now)
+			if err != nil || other == nil || other.tenant == claimed.tenant {
+				t.Fatalf("claim while retry waits = %+v, %v, want the other tenant's job", other, err)
+			}
+			if job, wait, err := s.claim(ctx, now); job != nil || wait != time.Minute || err != nil {
+				t.Fatalf("claim before retry = %v, %v, %v, want a wait of 1m", job, wait, err)
+			}
+			retried, _, err := s.claim(ctx, now.Add(time.Minute))
+			if err != nil || retried == nil || retried.ID != "a" || retried.Attempts != 1 {
+				t.Fatalf("claim after backoff = %+v, %v", retried, err)
+			}
+
+			failedAt := now.Add(time.Minute)
+			retried.Status, retried.FinishedAt = jobFailed, &failedAt
+			retried.Error = &apiError{Code: errCodeRenderFailed, Message: "Failed to store the image"}
+			if err := s.finish(ctx, *retried); err != nil {
+				t.Fatal(err)
+			}
+			other.Status, other.URL, other.FinishedAt = jobSucceeded, "mem://a.png", &failedAt
+			if err := s.finish(ctx, *other); err != nil {
+				t.Fatal(err)
+			}
+			dead, err := s.dead(ctx, retried.tenant, failedAt)
+			if err != nil || len(dead) != 1 || dead[0].ID != "a" || dead[0].Error == nil || dead[0].tenant != retried.tenant {
+				t.Fatalf("dead = %+v, %v", dead, err)
+			}
+			if dead, err := s.dead(ctx, other.tenant, failedAt); err != nil || len(dead) != 0 {
+				t.Fatalf("dead of the succeeded job's tenant = %+v, %v, want none", dead, err)
+			}
+
+			requeued := dead[0]
+			requeued.Status, requeued.Attempts, requeued.Error, requeued.FinishedAt = jobQueued, 0, nil, nil
+			if ok, err := s.requeue(ctx, requeued, failedAt); err != nil || !ok {
+				t.Fatalf("requeue = %v, %v", ok, err)
+			}
+			if ok, err := s.requeue(ctx, requeued, failedAt); err != nil || ok {
+				t.Fatalf("requeue of a queued job = %v, %v, want not found", ok, err)
+			}
+			if dead, _ := s.dead(ctx, retried.tenant, failedAt); len(dead) != 0 {
+				t.Errorf("dead after requeue = %+v, want none", dead)
+			}
+			if got, _, _ := s.get(ctx, retried.tenant, "a"); got.Status != jobQueued || got.Error != nil {
+				t.Errorf("requeued job = %+v", got)
+			}
+		})
+	}
+}
+
+func TestJobStores_Fairness(t *testing.T) {
+	for name, newQueue := range newTestJobQueues() {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			s := newQueue(t, newTestJobProcessor(&memoryStore{}), 100).store
+			now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+			submit := func(tenant, priority string, ids ...string) {
+				t.Helper()
+				jobs := make([]queuedJob, len(ids))
+				for i, id := range ids {
+					jobs[i] = queuedJob{ID: id, Text: "hello", Status: jobQueued, Priority: priority, CreatedAt: now, tenant: tenant}
+				}
+				if err := s.submit(ctx, jobs); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			// A large batch first, then another tenant's smaller one and a
+			// single interactive code
+			submit("acme", jobBulk, "a1", "a2", "a3", "a4")
+			submit("globex", jobBulk, "g1", "g2")
+			submit("initech", jobInteractive, "i1")
+
+			var order []string
+			for {
+				job, _, err := s.claim(ctx, now)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if job == nil {
+					break
+				}
+				order = append(order, job.ID)
+			}
+			want := []string{"i1", "a1", "g1", "a2", "g2", "a3", "a4"}
+			if !slices.Equal(order, want) {
+				t.Errorf("claim order = %v, want %v", order, want)
+			}
+		})
+	}
+}
+
+func TestRedisJobStore_ExpiredLease(t *testing.T) {
+	q := newTestJobQueues()["redis"](t, newTestJobProcessor(&memoryStore{}), 10)
+	ctx := context.Background()
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	if err := q.store.submit(ctx, []queuedJob{{ID: "a", Text: "hello", Status: jobQueued, Priority: jobBulk, CreatedAt: now}}); err != nil {
+		t.Fatal(err)
+	}
+	claimed, _, err := q.store.claim(ctx, now)
+	if err != nil || claimed == nil {
+		t.Fatalf("claim = %v, %v", claimed, err)
+	}
+
+	// The replica holding the job stopped; after the lease, another claims it
+	if job, _, err := q.store.claim(ctx, now.Add(jobLease-time.Second)); job != nil || err != nil {
+		t.Fatalf("claim during the lease = %+v, %v, want none", job, err)
+	}
+	again, _, err := q.store.claim(ctx, now.Add(jobLease))
+	if err != nil || again == nil || again.ID != "a" {
+		t.Fatalf("claim after the lease = %+v, %v", again, err)
+	}
+	if err := q.store.finish(ctx, *claimed); err != nil {
+		t.Fatalf("finish by the new holder = %v", err)
+	}
+	if err := q.store.finish(ctx, *claimed); !errors.Is(err, errJobLeaseLost) {
+		t.Fatalf("finish of a lost job error = %v, want errJobLeaseLost", err)
+	}
+}
+
+func TestJobQueue_DeadLetterAndRequeue(t *testing.T) {
+	for name, newQueue := range newTestJobQueues() {
+		t.Run(name, func(t *testing.T) {
+			store := &memoryStore{failures: -1}
+			q := newQueue(t, newTestJobProcessor(store), 10)
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan struct{})
+			go func() {
+				q.run(ctx, 2)
+				close(done)
+			}()
+			defer func() {
+				cancel()
+				<-done
+			}()
+
+			if _, err := q.submit(ctx, "acme", jobInteractive, []generationJob{{ID: "a", Text: "hello"}}); err != nil {
+				t.Fatal(err)
+			}
+			job := waitForJob(t, q, "acme", "a", jobFailed)
+			if job.Attempts != 3 || job.Error == nil || job.Error.Code != errCodeRenderFailed || job.FinishedAt == nil {
+				t.Errorf("dead-lettered job = %+v", job)
+			}
+			if dead, err := q.store.dead(ctx, "acme", q.now()); err != nil || len(dead) != 1 || dead[0].ID != "a" {
+				t.Errorf("dead(acme) = %+v, %v", dead, err)
+			}
+
+			store.mu.Lock()
+			store.failures = 0
+			store.mu.Unlock()
+			if _, ok, err := q.requeue(ctx, "acme", "a"); !ok || err != nil {
+				t.Fatalf("requeue() = %v, %v", ok, err)
+			}
+			job = waitForJob(t, q, "acme", "a", jobSucceeded)
+			if job.Attempts != 1 || job.Error != nil || job.URL != "mem://jobs/acme/a.png" {
+				t.Errorf("requeued job = %+v", job)
+			}
+			if _, ok, _ := q.requeue(ctx, "acme", "a"); ok {
+				t.Error("requeue() of a succeeded job = true, want false")
+			}
+		})
+	}
+}
+
+func TestJobQueue_Backoff(t *testing.T) {
+	ctx := context.Background()
+	processor := newTestJobProcessor(&memoryStore{failures: -1})
+	processor.backoff, processor.maxBackoff = time.Second, 3*time.Second
+	q, err := newJobQueue(processor, "", 10, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	q.now = func() time.Time { return now }
+	if _, err := q.submit(ctx, "", jobBulk, []generationJob{{ID: "a", Text: "hello"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Every failed attempt waits twice as long for the next, up to the cap
+	for _, wantWait := range []time.Duration{time.Second, 2 * time.Second} {
+		job, _, _ := q.store.claim(ctx, now)
+		if job == nil {
+			t.Fatal("claim() = nil, want a ready job")
+		}
+		q.process(ctx, *job)
+		if job, wait, _ := q.store.claim(ctx, now); job != nil || wait != wantWait {
+			t.Fatalf("claim() after failure = %v, wait %v, want nil, %v", job, wait, wantWait)
+		}
+		got, _, _ := q.store.get(ctx, "", "a")
+		if got.Status != jobQueued || got.RetryAt == nil || !got.RetryAt.Equal(now.Add(wantWait)) {
+			t.Errorf("job waiting for retry = %+v", got)
+		}
+		now = now.Add(wantWait)
+	}
+
+	job, _, _ := q.store.claim(ctx, now)
+	q.process(ctx, *job)
+	if got, _, _ := q.store.get(ctx, "", "a"); got.Status != jobFailed || got.Attempts != 3 {
+		t.Errorf("job after all attempts = %+v", got)
+	}
+
+	// Finished jobs are forgotten after the retention
+	now = now.Add(2 * time.Hour)
+	q.store.claim(ctx, now)
+	if _, ok, _ := q.store.get(ctx, "", "a"); ok {
+		t.Error("job kept after its retention")
+	}
+}
+
+func TestJobsAPI(t *testing.T) {
+	keys, err := newAPIKeyStore(true, "acme-ci=secret1,globex-ci=secret2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := keys.SetTenants("acme-ci=acme,globex-ci=globex"); err != nil {
+		t.Fatal(err)
+	}
+	deps := newTestDeps()
+	deps.apiKeys = keys
+	deps.flags = mustFeatureFlags("batch")
+	deps.batchMaxItems = 3
+	// No workers run, so jobs stay queued
+	deps.jobs, err = newJobQueue(newTestJobProcessor(&memoryStore{}), "", 4, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := newHandler(deps)
+
+	// The steps run in order against the same handler
+	steps := []struct {
+		name       string
+		method     string
+		path       string
+		key        string
+		body       string
+		wantStatus int
+		wantCode   string
+	}{
+		{name: "submit", method: http.MethodPost, path: "/api/v1/jobs", key: "secret1", body: `{"items": [{"id": "a", "text": "hello"}, {"text": "world"}]}`, wantStatus: http.StatusAccepted},
+		{name: "get", method: http.MethodGet, path: "/api/v1/jobs/a", key: "secret1", wantStatus: http.StatusOK},
+		{name: "other tenant", method: http.MethodGet, path: "/api/v1/jobs/a", key: "secret2", wantStatus: http.StatusNotFound, wantCode: errCodeJobNotFound},
+		{name: "duplicate", method: http.MethodPost, path: "/api/v1/jobs", key: "secret1", body: `{"items": [{"id": "a", "text": "again"}]}`, wantStatus: http.StatusConflict, wantCode: errCodeJobExists},
+		{name: "duplicate in request", method: http.MethodPost, path: "/api/v1/jobs", key: "secret1", body: `{"items": [{"id": "b", "text": "x"}, {"id": "b", "text": "y"}]}`, wantStatus: http.StatusConflict, wantCode: errCodeJobExists},
+		{name: "same id for other tenant", method: http.MethodPost, path: "/api/v1/jobs", key: "secret2", body: `{"priority": "bulk", "items": [{"id": "a", "text": "hello"}]}`, wantStatus: http.StatusAccepted},
+		{name: "queue full", method: http.MethodPost, path: "/api/v1/jobs", key: "secret1", body: `{"items": [{"text": "1"}, {"text": "2"}]}`, wantStatus: http.StatusServiceUnavailable, wantCode: errCodeServerBusy},
+		{name: "too many items", method: http.MethodPost, path: "/api/v1/jobs", key: "secret1", body: `{"items": [{"text": "1"}, {"text": "2"}, {"text": "3"}, {"text": "4"}]}`, wantStatus: http.StatusRequestEntityTooLarge, wantCode: errCodePayloadTooLarge},
+		{name: "unsafe id", method: http.MethodPost, path: "/api/v1/jobs", key: "secret1", body: `{"items": [{"id": "../a", "text": "hello"}]}`, wantStatus: http.StatusBadRequest, wantCode: errCodeInvalidParam},
+		{name: "reserved id", method: http.MethodPost, path: "/api/v1/jobs", key: "secret1", body: `{"items": [{"id": "dead", "text": "hello"}]}`, wantStatus: http.StatusBadRequest, wantCode: errCodeInvalidParam},
+		{name: "unknown priority", method: http.MethodPost, path: "/api/v1/jobs", key: "secret1", body: `{"priority": "urgent", "items": [{"text": "hello"}]}`, wantStatus: http.StatusBadRequest, wantCode: errCodeInvalidParam},
+		{name: "no items", method: http.MethodPost, path: "/api/v1/jobs", key: "secret1", body: `{"items": []}`, wantStatus: http.StatusBadRequest, wantCode: errCodeInvalidPayload},
+		{name: "dead letters", method: http.MethodGet, path: "/api/v1/jobs/dead", key: "secret1", wantStatus: http.StatusOK},
+		{name: "requeue queued job", method: http.MethodPost, path: "/api/v1/jobs/dead/a/requeue", key: "secret1", wantStatus: http.StatusNotFound, wantCode: errCodeJobNotFound},
+		{name: "unauthenticated", method: http.MethodGet, path: "/api/v1/jobs/a", wantStatus: http.StatusUnauthorized},
+	}
+
+	for _, tt := range steps {
+		req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+		if tt.key != "" {
+			req.Header.Set(apiKeyHeader, tt.key)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if rec.Code != tt.wantStatus {
+			t.Fatalf("%s: status = %d, want %d: %s", tt.name, rec.Code, tt.wantStatus, rec.Body.String())
+		}
+		if tt.wantCode != "" {
+			var resp struct {
+				Error apiError `json:"error"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || resp.Error.Code != tt.wantCode {
+				t.Errorf("%s: error = %s, want code %q", tt.name, rec.Body.String(), tt.wantCode)
+			}
+		}
+		if tt.wantStatus == http.StatusServiceUnavailable && rec.Header().Get("Retry-After") == "" {
+			t.Errorf("%s: no Retry-After header", tt.name)
+		}
+	}
+
+	job, ok, err := deps.jobs.store.get(context.Background(), "acme", "a")
+	if err != nil || !ok || job.Status != jobQueued || job.Text != "hello" || job.Priority != jobInteractive {
+		t.Errorf("queued job = %+v, %v, %v", job, ok, err)
+	}
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	// jwksRefreshInterval is how long fetched signing keys are used before refetching
+	jwksRefreshInterval = time.Hour
+	// jwksMinRefreshInterval limits refetches triggered by unknown key IDs
+	jwksMinRefreshInterval = time.Minute
+	// jwtLeeway tolerates clock skew when validating exp/nbf/iat
+	jwtLeeway = 30 * time.Second
+)
+
+// jwtSigningMethods are the asymmetric algorithms accepted for bearer tokens;
+// HMAC and "none" are never accepted
+var jwtSigningMethods = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512"}
+
+// jwks fetches and caches the signing keys published at a JWKS URL
+type jwks struct {
+	url    string
+	client *http.Client
+
+	mu          sync.Mutex
+	keys        map[string]any
+	fetchedAt   time.Time
+	lastAttempt time.Time
+}
+
+// newJWKS creates a key cache for url; keys are fetched lazily on first use
+func newJWKS(url string, client *http.Client) *jwks {
+	return &jwks{url: url, client: client}
+}
+
+// key returns the public key with the given key ID, refetching the key set when
+// it is stale or the ID is unknown (e.g. after the issuer rotated its keys)
+func (k *jwks) key(ctx context.Context, kid string) (any, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	key, ok := k.keys[kid]
+	if ok && time.Since(k.fetchedAt) < jwksRefreshInterval {
+		return key, nil
+	}
+
+	if time.Since(k.lastAttempt) >= jwksMinRefreshInterval {
+		k.lastAttempt = time.Now()
+		keys, err := k.fetch(ctx)
+		switch {
+		case err == nil:
+			k.keys, k.fetchedAt = keys, time.Now()
+			key, ok = keys[kid]
+		case !ok:
+			return nil, err
+		}
+		// On fetch errors a cached key keeps being served while the issuer is unreachable
+	}
+
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	return key, nil
+}
+
+// jsonWebKey is the subset of RFC 7517 fields needed for RSA and EC public keys
+type jsonWebKey struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// fetch downloads and parses the key set, skipping keys it cannot use
+func (k *jwks) fetch(ctx context.Context) (map[string]any, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, k.url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build JWKS request: %w", err)
+	}
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch JWKS: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch JWKS: status %d", resp.StatusCode)
+	}
+
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return nil, fmt.Errorf("failed to decode JWKS: %w", err)
+	}
+
+	keys := make(map[string]any, len(set.Keys))
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		if key, err := jwk.publicKey(); err == nil {
+			keys[jwk.Kid] = key
+		}
+	}
+	return keys, nil
+}
+
+// publicKey converts the JWK into an *rsa.PublicKey or *ecdsa.PublicKey
+func (j jsonWebKey) publicKey() (any, error) {
+	switch j.Kty {
+	case "RSA":
+		n, err := decodeBigInt(j.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(j.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch j.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", j.Crv)
+		}
+		x, err := decodeBigInt(j.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(j.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", j.Kty)
+}
+
+// decodeBigInt decodes a base64url-encoded big-endian integer
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(b) == 0 {
+		return nil, fmt.Errorf("invalid key parameter")
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+// jwtVerifier validates bearer tokens against a JWKS, issuer, and audience
+type jwtVerifier struct {
+	keys        *jwks
+	issuer      string
+	audience    string
+	tenantClaim string
+}
+
+// newJWTVerifier creates a verifier for tokens signed by keys published at jwksURL.
+// Empty issuer or audience skip the respective check.
+func newJWTVerifier(jwksURL, issuer, audience, tenantClaim string) *jwtVerifier {
+	return &jwtVerifier{
+		keys:        newJWKS(jwksURL, newOutboundClient(10*time.Second)),
+		issuer:      issuer,
+		audience:    audience,
+		tenantClaim: tenantClaim,
+	}
+}
+
+// Verify validates the token's signature and claims and returns the caller's identity
+func (v *jwtVerifier) Verify(ctx context.Context, token string) (principal, error) {
+	claims, err := v.verifyClaims(ctx, token)
+	if err != nil {
+		return principal{}, err
+	}
+	subject, _ := claims.GetSubject()
+	tenant, _ := claims[v.tenantClaim].(string)
+	return principal{Subject: subject, Tenant: tenant, Method: authMethodJWT}, nil
+}
+
+// verifyClaims validates the token's signature, algorithm, expiry, issuer,
+// audience, and subject and returns its claims
+func (v *jwtVerifier) verifyClaims(ctx context.Context, token string) (jwt.MapClaims, error) {
+	opts := []jwt.ParserOption{
+		jwt.WithValidMethods(jwtSigningMethods),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(jwtLeeway),
+	}
+	if v.issuer != "" {
+		opts = append(opts, jwt.WithIssuer(v.issuer))
+	}
+	if v.audience != "" {
+		opts = append(opts, jwt.WithAudience(v.audience))
+	}
+
+	claims := jwt.MapClaims{}
+	_, err := jwt.NewParser(opts...).ParseWithClaims(token, claims, func(t *jwt.Token) (any, error) {
+		kid, _ := t.Header["kid"].(string)
+		return v.keys.key(ctx, kid)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if subject, err := claims.GetSubject(); err != nil || subject == "" {
+		return nil, errors.New("token has no subject")
+	}
+	return claims, nil
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// testIssuer serves a JWKS with one RSA and one EC signing key and signs test tokens
+type testIssuer struct {
+	server  *httptest.Server
+	rsaKey  *rsa.PrivateKey
+	ecKey   *ecdsa.PrivateKey
+	fetches atomic.Int32
+}
+
+func newTestIssuer(t *testing.T) *testIssuer {
+	t.Helper()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	iss := &testIssuer{rsaKey: rsaKey, ecKey: ecKey}
+	b64 := func(i *big.Int) string { return base64.RawURLEncoding.EncodeToString(i.Bytes()) }
+	set := map[string]any{"keys": []map[string]string{
+		{"kid": "rsa-1", "kty": "RSA", "use": "sig", "n": b64(rsaKey.N), "e": b64(big.NewInt(int64(rsaKey.E)))},
+		{"kid": "ec-1", "kty": "EC", "crv": "P-256", "x": b64(ecKey.X), "y": b64(ecKey.Y)},
+		{"kid": "enc-1", "kty": "RSA", "use": "enc", "n": b64(rsaKey.N), "e": b64(big.NewInt(int64(rsaKey.E)))},
+	}}
+	iss.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		iss.fetches.Add(1)
+		json.NewEncoder(w).Encode(set)
+	}))
+	t.Cleanup(iss.server.Close)
+	return iss
+}
+
+// sign creates a token with the given method and key ID
+func (i *testIssuer) sign(t *testing.T, method jwt.SigningMethod, kid string, claims jwt.MapClaims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(method, claims)
+	token.Header["kid"] = kid
+
+	var key any = i.rsaKey
+	switch method.(type) {
+	case *jwt.SigningMethodECDSA:
+		key = i.ecKey
+	case *jwt.SigningMethodHMAC:
+		key = []byte("shared-secret")
+	}
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+// validClaims returns claims accepted by the verifier built in the tests
+func validClaims() jwt.MapClaims {
+	return jwt.MapClaims{
+		"sub":    "user-123",
+		"iss":    "https://issuer.example",
+		"aud":    "qr-generator",
+		"exp":    time.Now().Add(time.Hour).Unix(),
+		"tenant": "acme",
+	}
+}
+
+func TestJWTVerifier_Verify(t *testing.T) {
+	issuer := newTestIssuer(t)
+	verifier := newJWTVerifier(issuer.server.URL, "https://issuer.example", "qr-generator", "tenant")
+
+	withClaim := func(key string, value any) jwt.MapClaims {
+		claims := validClaims()
+		if value == nil {
+			delete(claims, key)
+		} else {
+			claims[key] = value
+		}
+		return claims
+	}
+
+	tests := []struct {
+		name       string
+		method     jwt.SigningMethod
+		kid        string
+		claims     jwt.MapClaims
+		wantErr    bool
+		wantTenant string
+	}{
+		{name: "RS256", method: jwt.SigningMethodRS256, kid: "rsa-1", claims: validClaims(), wantTenant: "acme"},
+		{name: "ES256", method: jwt.SigningMethodES256, kid: "ec-1", claims: validClaims(), wantTenant: "acme"},
+		{name: "no tenant", method: jwt.SigningMethodRS256, kid: "rsa-1", claims: withClaim("tenant", nil)},
+		{name: "expired", method: jwt.SigningMethodRS256, kid: "rsa-1", claims: withClaim("exp", time.Now().Add(-time.Hour).Unix()), wantErr: true},
+		{name: "missing exp", method: jwt.SigningMethodRS256, kid: "rsa-1", claims: withClaim("exp", nil), wantErr: true},
+		{name: "wrong issuer", method: jwt.SigningMethodRS256, kid: "rsa-1", claims: withClaim("iss", "https://evil.example"), wantErr: true},
+		{name: "wrong audience", method: jwt.SigningMethodRS256, kid: "rsa-1", claims: withClaim("aud", "other"), wantErr: true},
+		{name: "missing subject", method: jwt.SigningMethodRS256, kid: "rsa-1", claims: withClaim("sub", nil), wantErr: true},
+		{name: "HMAC rejected", method: jwt.SigningMethodHS256, kid: "rsa-1", claims: validClaims(), wantErr: true},
+		{name: "unknown kid", method: jwt.SigningMethodRS256, kid: "nope", claims: validClaims(), wantErr: true},
+		{name: "encryption key", method: jwt.SigningMethodRS256, kid: "enc-1", claims: validClaims(), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := verifier.Verify(context.Background(), issuer.sign(t, tt.method, tt.kid, tt.claims))
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("Verify() expected error but got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Verify() unexpected error: %v", err)
+			}
+			if p.Subject != "user-123" || p.Tenant != tt.wantTenant || p.Method != authMethodJWT {
+				t.Errorf("Verify() = %+v, want subject user-123, tenant %q", p, tt.wantTenant)
+			}
+		})
+	}
+}
+
+func TestJWKS_CachesKeys(t *testing.T) {
+	issuer := newTestIssuer(t)
+	keys := newJWKS(issuer.server.URL, issuer.server.Client())
+
+	for range 3 {
+		if _, err := keys.key(context.Background(), "rsa-1"); err != nil {
+			t.Fatalf("key() unexpected error: %v", err)
+		}
+	}
+	// Unknown key IDs only trigger a refetch once per jwksMinRefreshInterval
+	for range 3 {
+		if _, err := keys.key(context.Background(), "unknown"); err == nil {
+			t.Fatal("key() expected error for unknown kid")
+		}
+	}
+
+	if got := issuer.fetches.Load(); got != 1 {
+		t.Errorf("JWKS fetched %d times, want 1", got)
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// kafkaReader is the part of *kafka.Reader the worker uses
+type kafkaReader interface {
+	FetchMessage(ctx context.Context) (kafka.Message, error)
+	CommitMessages(ctx context.Context, msgs ...kafka.Message) error
+	Close() error
+}
+
+// kafkaWriter is the part of *kafka.Writer the worker uses
+type kafkaWriter interface {
+	WriteMessages(ctx context.Context, msgs ...kafka.Message) error
+	Close() error
+}
+
+// kafkaWorker consumes generation jobs from a Kafka topic as part of a
+// consumer group, stores the images, and publishes a completion event per job
+// to a result topic. A message is committed only once its event is published,
+// so jobs interrupted by a crash or shutdown are redelivered (at-least-once).
+type kafkaWorker struct {
+	brokers   []string
+	reader    kafkaReader
+	writer    kafkaWriter // nil disables completion events
+	processor *jobProcessor
+	// retryInterval is the wait after a failed fetch, publish, or commit
+	retryInterval time.Duration
+}
+
+// newKafkaWorker creates a worker consuming topic in consumer group groupID.
+// An empty resultTopic disables completion events.
+func newKafkaWorker(brokers []string, topic, groupID, resultTopic string, processor *jobProcessor) *kafkaWorker {
+	worker := &kafkaWorker{
+		brokers: brokers,
+		reader: kafka.NewReader(kafka.ReaderConfig{
+			Brokers: brokers,
+			Topic:   topic,
+			GroupID: groupID,
+		}),
+		processor:     processor,
+		retryInterval: time.Second,
+	}
+	if resultTopic != "" {
+		worker.writer = &kafka.Writer{
+			Addr:         kafka.TCP(brokers...),
+			Topic:        resultTopic,
+			Balancer:     &kafka.Hash{},
+			RequiredAcks: kafka.RequireAll,
+		}
+	}
+	return worker
+}
+
+// run processes messages until ctx is done
+func (k *kafkaWorker) run(ctx context.Context) {
+	for {
+		msg, err := k.reader.FetchMessage(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			slog.ErrorContext(ctx, "failed to fetch kafka message", "error", err)
+			if !sleepContext(ctx, k.retryInterval) {
+				return
+			}
+			continue
+		}
+
+		result, err := k.processor.process(ctx, msg.Value)
+		if err != nil {
+			return // shutting down; the uncommitted message is redelivered
+		}
+		if k.writer != nil && !k.retry(ctx, "publish job result", func() error { return k.publish(ctx, msg, result) }) {
+			return
+		}
+		if !k.retry(ctx, "commit kafka message", func() error { return k.reader.CommitMessages(ctx, msg) }) {
+			return
+		}
+	}
+}
+
+// publish sends the completion event, keyed like the job message so events
+// of one key stay ordered
+func (k *kafkaWorker) publish(ctx context.Context, msg kafka.Message, result jobResult) error {
+	value, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+	return k.writer.WriteMessages(ctx, kafka.Message{Key: msg.Key, Value: value})
+}
+
+// retry calls fn until it succeeds, reporting false if ctx is done first
+func (k *kafkaWorker) retry(ctx context.Context, action string, fn func() error) bool {
+	for {
+		err := fn()
+		if err == nil {
+			return true
+		}
+		if ctx.Err() != nil {
+			return false
+		}
+		slog.ErrorContext(ctx, "failed to "+action, "error", err)
+		if !sleepContext(ctx, k.retryInterval) {
+			return false
+		}
+	}
+}
+
+// check reports whether a broker is reachable, for the health endpoint
+func (k *kafkaWorker) check(ctx context.Context) error {
+	var lastErr error
+	for _, broker := range k.brokers {
+		conn, err := kafka.DialContext(ctx, "tcp", broker)
+		if err == nil {
+			return conn.Close()
+		}
+		lastErr = err
+	}
+	return fmt.Errorf("no kafka broker reachable: %w", lastErr)
+}
+
+// Close closes the consumer, leaving the group, and flushes the writer
+func (k *kafkaWorker) Close() error {
+	err := k.reader.Close()
+	if k.writer != nil {
+		if werr := k.writer.Close(); err == nil {
+			err = werr
+		}
+	}
+	return err
+}
+
+// sleepContext waits for d, reporting false if ctx is done first
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
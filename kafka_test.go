@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// fakeKafka is an in-memory topic pair implementing kafkaReader and kafkaWriter
+type fakeKafka struct {
+	messages chan kafka.Message
+
+	mu            sync.Mutex
+	committed     []kafka.Message
+	published     []kafka.Message
+	publishErrors int
+}
+
+func (f *fakeKafka) FetchMessage(ctx context.Context) (kafka.Message, error) {
+	select {
+	case msg := <-f.messages:
+		return msg, nil
+	case <-ctx.Done():
+		return kafka.Message{}, ctx.Err()
+	}
+}
+
+func (f *fakeKafka) CommitMessages(ctx context.Context, msgs ...kafka.Message) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.committed = append(f.committed, msgs...)
+	return nil
+}
+
+func (f *fakeKafka) WriteMessages(ctx context.Context, msgs ...kafka.Message) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.publishErrors > 0 {
+		f.publishErrors--
+		return errors.New("leader not available")
+	}
+	f.published = append(f.published, msgs...)
+	return nil
+}
+
+func (f *fakeKafka) Close() error { return nil }
+
+func TestKafkaWorker(t *testing.T) {
+	tests := []struct {
+		name          string
+		values        []string
+		publishErrors int
+		wantStatuses  []string
+	}{
+		{name: "jobs", values: []string{`{"id": "a", "text": "hello"}`, `{"id": "b", "text": ""}`}, wantStatuses: []string{jobSucceeded, jobFailed}},
+		{name: "poison message", values: []string{`not json`}, wantStatuses: []string{jobFailed}},
+		{name: "publish retried before commit", values: []string{`{"id": "a", "text": "hello"}`}, publishErrors: 2, wantStatuses: []string{jobSucceeded}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeKafka{messages: make(chan kafka.Message, len(tt.values)), publishErrors: tt.publishErrors}
+			for i, value := range tt.values {
+				fake.messages <- kafka.Message{Key: []byte{byte('0' + i)}, Value: []byte(value), Offset: int64(i)}
+			}
+			worker := &kafkaWorker{reader: fake, writer: fake, processor: newTestJobProcessor(&memoryStore{}), retryInterval: time.Millisecond}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan struct{})
+			go func() {
+				worker.run(ctx)
+				close(done)
+			}()
+			deadline := time.Now().Add(5 * time.Second)
+			for {
+				fake.mu.Lock()
+				n := len(fake.committed)
+				fake.mu.Unlock()
+				if n == len(tt.values) || time.Now().After(deadline) {
+					break
+				}
+				time.Sleep(time.Millisecond)
+			}
+			cancel()
+			<-done
+
+			if len(fake.committed) != len(tt.values) || len(fake.published) != len(tt.values) {
+				t.Fatalf("%d committed, %d published, want %d", len(fake.committed), len(fake.published), len(tt.values))
+			}
+			for i, msg := range fake.published {
+				var result jobResult
+				if err := json.Unmarshal(msg.Value, &result); err != nil {
+					t.Fatalf("result %d: %v", i, err)
+				}
+				if result.Status != tt.wantStatuses[i] {
+					t.Errorf("result %d status = %q, want %q", i, result.Status, tt.wantStatuses[i])
+				}
+				if string(msg.Key) != string(fake.committed[i].Key) {
+					t.Errorf("result %d key = %q, want the job's key %q", i, msg.Key, fake.committed[i].Key)
+				}
+			}
+		})
+	}
+}
+
+func TestKafkaWorker_ShutdownLeavesJobUncommitted(t *testing.T) {
+	fake := &fakeKafka{messages: make(chan kafka.Message, 1), publishErrors: 1 << 30}
+	fake.messages <- kafka.Message{Value: []byte(`{"id": "a", "text": "hello"}`)}
+	worker := &kafkaWorker{reader: fake, writer: fake, processor: newTestJobProcessor(&memoryStore{}), retryInterval: time.Millisecond}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	worker.run(ctx)
+
+	if len(fake.committed) != 0 {
+		t.Errorf("%d messages committed although their result was never published", len(fake.committed))
+	}
+}
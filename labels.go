@@ -0,0 +1,366 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"mime"
+	"net/http"
+	"slices"
+
+	"github.com/skip2/go-qrcode"
+)
+
+// labelPaddingMM keeps codes clear of the label edges, where die-cut sheets
+// drift by up to a millimeter
+const labelPaddingMM = 1.5
+
+// captionFontSize is the font size of captions on roomy labels in points
+const captionFontSize = 8.0
+
+// maxCaptionLength bounds captions in characters
+const maxCaptionLength = 100
+
+// Bounds of custom grids: cells per row or column, page sizes, margins and
+// gutters, and the smallest cell that holds a scannable code, in millimeters
+const (
+	maxGridCells     = 50
+	minGridPageMM    = 25.0
+	maxGridPageMM    = 1500.0
+	maxGridSpacingMM = 100.0
+	minGridCellMM    = 8.0
+)
+
+// labelTemplate is the geometry of a label sheet in points: the page, the
+// grid of labels, the size of a label, the margins to the first label, and
+// the pitch from one label to the next
+type labelTemplate struct {
+	pageWidth, pageHeight   float64
+	columns, rows           int
+	labelWidth, labelHeight float64
+	marginLeft, marginTop   float64
+	pitchX, pitchY          float64
+}
+
+// labelTemplates are Avery sheets by product code: US Letter sheets of the
+// 5xxx range and A4 sheets of the L7xxx range
+var labelTemplates = map[string]labelTemplate{
+	// Address labels, 2-5/8 x 1 in, 30 per sheet
+	"avery-5160": inchTemplate(8.5, 11, 3, 10, 2.625, 1, 0.1875, 0.5, 2.75, 1),
+	// Shipping labels, 4 x 2 in, 10 per sheet
+	"avery-5163": inchTemplate(8.5, 11, 2, 5, 4, 2, 0.15625, 0.5, 4.1875, 2),
+	// Shipping labels, 4 x 3-1/3 in, 6 per sheet
+	"avery-5164": inchTemplate(8.5, 11, 2, 3, 4, 10.0/3, 0.15625, 0.5, 4.1875, 10.0/3),
+	// Return address labels, 1-3/4 x 1/2 in, 80 per sheet
+	"avery-5167": inchTemplate(8.5, 11, 4, 20, 1.75, 0.5, 0.28125, 0.5, 2.0625, 0.5),
+	// Address labels, 63.5 x 38.1 mm, 21 per sheet
+	"avery-l7160": mmTemplate(210, 297, 3, 7, 63.5, 38.1, 7.21, 15.15, 66.04, 38.1),
+	// Address labels, 99.1 x 38.1 mm, 14 per sheet
+	"avery-l7163": mmTemplate(210, 297, 2, 7, 99.1, 38.1, 4.65, 15.15, 101.6, 38.1),
+	// Mini labels, 38.1 x 21.2 mm, 65 per sheet
+	"avery-l7651": mmTemplate(210, 297, 5, 13, 38.1, 21.2, 4.75, 10.7, 40.6, 21.2),
+}
+
+// inchTemplate is a label template measured in inches
+func inchTemplate(pageWidth, pageHeight float64, columns, rows int, labelWidth, labelHeight, marginLeft, marginTop, pitchX, pitchY float64) labelTemplate {
+	return scaleTemplate(pointsPerInch, pageWidth, pageHeight, columns, rows, labelWidth, labelHeight, marginLeft, marginTop, pitchX, pitchY)
+}
+
+// mmTemplate is a label template measured in millimeters
+func mmTemplate(pageWidth, pageHeight float64, columns, rows int, labelWidth, labelHeight, marginLeft, marginTop, pitchX, pitchY float64) labelTemplate {
+	return scaleTemplate(pointsPerMM, pageWidth, pageHeight, columns, rows, labelWidth, labelHeight, marginLeft, marginTop, pitchX, pitchY)
+}
+
+// scaleTemplate converts a template measured in unit points to points
+func scaleTemplate(unit, pageWidth, pageHeight float64, columns, rows int, labelWidth, labelHeight, marginLeft, marginTop, pitchX, pitchY float64) labelTemplate {
+	return labelTemplate{
+		pageWidth: pageWidth * unit, pageHeight: pageHeight * unit,
+		columns: columns, rows: rows,
+		labelWidth: labelWidth * unit, labelHeight: labelHeight * unit,
+		marginLeft: marginLeft * unit, marginTop: marginTop * unit,
+		pitchX: pitchX * unit, pitchY: pitchY * unit,
+	}
+}
+
+// perPage is the number of labels on a sheet
+func (t labelTemplate) perPage() int {
+	return t.columns * t.rows
+}
+
+// labelCorner returns the top left corner of label slot of a sheet, counted
+// across rows from the top left, in PDF coordinates
+func (t labelTemplate) labelCorner(slot int) (x, y float64) {
+	column, row := slot%t.columns, slot/t.columns
+	return t.marginLeft + float64(column)*t.pitchX, t.pageHeight - t.marginTop - float64(row)*t.pitchY
+}
+
+// labelCell is the content of a label: a code and an optional caption
+// below it
+type labelCell struct {
+	bitmap  [][]bool
+	caption string
+}
+
+// layoutLabels places the cells on sheets of t, one per label, starting
+// skip labels into the first sheet. Each code is the largest square that
+// fits its label within the padding, above its caption, and the two are
+// centered on the label.
+func layoutLabels(t labelTemplate, skip int, cells []labelCell) *pdfDocument {
+	doc := &pdfDocument{}
+	var page *pdfPage
+	padding := labelPaddingMM * pointsPerMM
+	innerWidth, innerHeight := t.labelWidth-2*padding, t.labelHeight-2*padding
+	for i, cell := range cells {
+		slot := (skip + i) % t.perPage()
+		if page == nil || slot == 0 {
+			page = doc.addPage(t.pageWidth, t.pageHeight)
+		}
+		x, y := t.labelCorner(slot)
+
+		fontSize, captionHeight := 0.0, 0.0
+		if cell.caption != "" {
+			// Lines are 1.25 font sizes high, leaving room for descenders
+			fontSize = min(captionFontSize, innerHeight/5)
+			captionHeight = 1.25 * fontSize
+		}
+		side := min(innerWidth, innerHeight-captionHeight)
+		top := y - padding - (innerHeight-side-captionHeight)/2
+		page.fillBitmap(cell.bitmap, x+(t.labelWidth-side)/2, top, side/float64(len(cell.bitmap)))
+		if cell.caption != "" {
+			caption, size := fitText(cell.caption, innerWidth, fontSize)
+			page.drawText(caption, x+(t.labelWidth-pdfTextWidth(caption, size))/2, top-side-fontSize, size)
+		}
+	}
+	return doc
+}
+
+// pageSizes are the named page sizes of grids in points
+var pageSizes = map[string][2]float64{
+	"letter": {8.5 * pointsPerInch, 11 * pointsPerInch},
+	"a4":     {210 * pointsPerMM, 297 * pointsPerMM},
+}
+
+// labelGrid is a custom sheet layout for sticker runs: a page, named or
+// measured, divided into rows and columns of equal cells within a margin
+// and with gutters between them, all in millimeters
+type labelGrid struct {
+	Page         string  `json:"page,omitempty"`
+	PageWidthMM  float64 `json:"page_width_mm,omitempty"`
+	PageHeightMM float64 `json:"page_height_mm,omitempty"`
+	Rows         int     `json:"rows"`
+	Columns      int     `json:"columns"`
+	MarginMM     float64 `json:"margin_mm,omitempty"`
+	GutterMM     float64 `json:"gutter_mm,omitempty"`
+}
+
+// template validates the grid and returns its label template. The page
+// defaults to US Letter.
+func (g labelGrid) template() (labelTemplate, *apiError) {
+	width, height := pageSizes["letter"][0], pageSizes["letter"][1]
+	switch {
+	case g.PageWidthMM != 0 || g.PageHeightMM != 0:
+		if g.Page != "" {
+			return labelTemplate{}, &apiError{Code: errCodeInvalidParam, Message: "Field 'grid.page' can't be combined with a page size in millimeters"}
+		}
+		for _, field := range []struct {
+			name  string
+			value float64
+		}{{"grid.page_width_mm", g.PageWidthMM}, {"grid.page_height_mm", g.PageHeightMM}} {
+			if field.value < minGridPageMM || field.value > maxGridPageMM {
+				return labelTemplate{}, apiErrorf(errCodeInvalidParam, nil, "Field '%s' must be a number of millimeters from %g to %g", field.name, minGridPageMM, maxGridPageMM)
+			}
+		}
+		width, height = g.PageWidthMM*pointsPerMM, g.PageHeightMM*pointsPerMM
+	case g.Page != "":
+		size, ok := pageSizes[g.Page]
+		if !ok {
+			return labelTemplate{}, &apiError{Code: errCodeInvalidParam, Message: "Field 'grid.page' must be letter or a4"}
+		}
+		width, height = size[0], size[1]
+	}
+	for _, field := range []struct {
+		name  string
+		value int
+	}{{"grid.rows", g.Rows}, {"grid.columns", g.Columns}} {
+		if field.value < 1 || field.value > maxGridCells {
+			return labelTemplate{}, apiErrorf(errCodeInvalidParam, nil, "Field '%s' must be from 1 to %d", field.name, maxGridCells)
+		}
+	}
+	for _, field := range []struct {
+		name  string
+		value float64
+	}{{"grid.margin_mm", g.MarginMM}, {"grid.gutter_mm", g.GutterMM}} {
+		if field.value < 0 || field.value > maxGridSpacingMM {
+			return labelTemplate{}, apiErrorf(errCodeInvalidParam, nil, "Field '%s' must be a number of millimeters from %g to %g", field.name, 0.0, maxGridSpacingMM)
+		}
+	}
+
+	margin, gutter := g.MarginMM*pointsPerMM, g.GutterMM*pointsPerMM
+	cellWidth := (width - 2*margin - float64(g.Columns-1)*gutter) / float64(g.Columns)
+	cellHeight := (height - 2*margin - float64(g.Rows-1)*gutter) / float64(g.Rows)
+	if cellWidth < minGridCellMM*pointsPerMM || cellHeight < minGridCellMM*pointsPerMM {
+		return labelTemplate{}, apiErrorf(errCodeInvalidParam,
+			map[string]any{"cell_width_mm": max(cellWidth, 0) / pointsPerMM, "cell_height_mm": max(cellHeight, 0) / pointsPerMM},
+			"The grid cells are smaller than the minimum of %g by %g mm", minGridCellMM, minGridCellMM)
+	}
+	return labelTemplate{
+		pageWidth: width, pageHeight: height,
+		columns: g.Columns, rows: g.Rows,
+		labelWidth: cellWidth, labelHeight: cellHeight,
+		marginLeft: margin, marginTop: margin,
+		pitchX: cellWidth + gutter, pitchY: cellHeight + gutter,
+	}, nil
+}
+
+// labelItem is one label of a sheet
+type labelItem struct {
+	Text string `json:"text"`
+	// Caption is printed below the code
+	Caption string `json:"caption,omitempty"`
+}
+
+// labelsRequest is the body of POST /api/v1/qr/labels
+type labelsRequest struct {
+	// Template names an Avery sheet; Grid describes a custom one instead
+	Template string      `json:"template,omitempty"`
+	Grid     *labelGrid  `json:"grid,omitempty"`
+	Items    []labelItem `json:"items"`
+	// Skip leaves the first labels of the first sheet empty, to print on a
+	// partly used sheet
+	Skip int `json:"skip,omitempty"`
+	// Level is the error correction level, as the ec parameter
+	Level string `json:"ec,omitempty"`
+}
+
+// handleLabels lays out a batch of codes, each with an optional caption, on
+// Avery label sheets or a custom grid, and answers with a print-ready PDF,
+// one page per sheet. Codes are vector shapes, so they print sharp at any
+// resolution. Unlike batch results, items fail together: a sheet with a
+// missing label would be misprinted.
+func handleLabels(deps handlerDeps) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var body labelsRequest
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || len(body.Items) == 0 {
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				writeAPIError(w, r, http.StatusRequestEntityTooLarge, *apiErrorf(errCodeBodyTooLarge,
+					map[string]any{"max_bytes": maxBytesErr.Limit},
+					"Request body exceeds %d bytes", maxBytesErr.Limit))
+				return
+			}
+			writeAPIError(w, r, http.StatusBadRequest, apiError{
+				Code:    errCodeInvalidPayload,
+				Message: `Invalid body. Usage: POST /api/v1/qr/labels with {"template": "avery-5160", "items": [{"text": "..."}, ...]}`,
+			})
+			return
+		}
+		var template labelTemplate
+		if body.Grid != nil {
+			if body.Template != "" {
+				writeAPIError(w, r, http.StatusBadRequest, apiError{Code: errCodeInvalidParam, Message: "Fields 'template' and 'grid' can't be combined"})
+				return
+			}
+			var apiErr *apiError
+			if template, apiErr = body.Grid.template(); apiErr != nil {
+				writeAPIError(w, r, http.StatusBadRequest, *apiErr)
+				return
+			}
+		} else {
+			var ok bool
+			if template, ok = labelTemplates[body.Template]; !ok {
+				writeAPIError(w, r, http.StatusBadRequest, apiError{
+					Code:    errCodeInvalidParam,
+					Message: "Field 'template' must be a supported label sheet",
+					Details: map[string]any{"templates": slices.Sorted(maps.Keys(labelTemplates))},
+				})
+				return
+			}
+		}
+		if body.Skip < 0 || body.Skip >= template.perPage() {
+			writeAPIError(w, r, http.StatusBadRequest, *apiErrorf(errCodeInvalidParam, nil,
+				"Field 'skip' must be from 0 to %d, the labels on a sheet minus one", template.perPage()-1))
+			return
+		}
+		level := defaultRenderOptions.level
+		if body.Level != "" {
+			if _, ok := recoveryLevels[body.Level]; !ok {
+				writeAPIError(w, r, http.StatusBadRequest, apiError{Code: errCodeInvalidParam, Message: "Field 'ec' must be low, medium, high, or highest"})
+				return
+			}
+			level = body.Level
+		}
+		if deps.batchMaxItems > 0 && len(body.Items) > deps.batchMaxItems {
+			writeAPIError(w, r, http.StatusRequestEntityTooLarge, *apiErrorf(errCodePayloadTooLarge,
+				map[string]any{"items": len(body.Items), "max_items": deps.batchMaxItems},
+				"Batch has %d items, the maximum is %d", len(body.Items), deps.batchMaxItems))
+			return
+		}
+		layout := body.Template
+		if body.Grid != nil {
+			layout = fmt.Sprintf("grid %dx%d", body.Grid.Columns, body.Grid.Rows)
+		}
+		setAuditPayload(r.Context(), fmt.Sprintf("labels of %d items", len(body.Items)), map[string]string{"items": fmt.Sprint(len(body.Items)), "template": layout})
+
+		cells := make([]labelCell, len(body.Items))
+		for i, item := range body.Items {
+			text, apiErr := checkText(r.Context(), deps, item.Text)
+			if apiErr == nil && !printableText(item.Caption, maxCaptionLength) {
+				apiErr = apiErrorf(errCodeInvalidParam, nil, "Field 'caption' must be at most %d printable characters", maxCaptionLength)
+			}
+			if apiErr == nil {
+				q, err := qrcode.New(text, recoveryLevels[level])
+				if err != nil {
+					apiErr = &apiError{
+						Code:    errCodePayloadTooLarge,
+						Message: "Field 'text' is too long to fit in a QR code",
+						Details: map[string]any{"bytes": len(text)},
+					}
+				} else {
+					cells[i] = labelCell{bitmap: q.Bitmap(), caption: item.Caption}
+				}
+			}
+			if apiErr != nil {
+				writeAPIError(w, r, itemErrorStatus(apiErr.Code), withItemIndex(*apiErr, i))
+				return
+			}
+		}
+
+		doc := layoutLabels(template, body.Skip, cells)
+		deps.metrics.generatedTotal.Add(float64(len(cells)))
+		addLogAttrs(r.Context(), slog.Int("batch_items", len(cells)), slog.Int("pages", len(doc.pages)))
+		w.Header().Set("Content-Type", "application/pdf")
+		filename := "labels.pdf"
+		if body.Template != "" {
+			filename = "labels-" + body.Template + ".pdf"
+		}
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("inline", map[string]string{"filename": filename}))
+		w.Header().Set("Cache-Control", "no-store")
+		if err := doc.writeTo(w); err != nil {
+			slog.WarnContext(r.Context(), "failed to write label sheets", "error", err)
+		}
+	}
+}
+
+// itemErrorStatus is the status of a request failed by an item error with
+// code, matching the status of the error on the generate endpoint
+func itemErrorStatus(code string) int {
+	switch code {
+	case errCodePayloadTooLarge:
+		return http.StatusRequestEntityTooLarge
+	case errCodePolicyViolation:
+		return http.StatusUnprocessableEntity
+	}
+	return http.StatusBadRequest
+}
+
+// withItemIndex adds the index of the failed item to the details of err
+func withItemIndex(err apiError, index int) apiError {
+	err.Details = maps.Clone(err.Details)
+	if err.Details == nil {
+		err.Details = map[string]any{}
+	}
+	err.Details["index"] = index
+	return err
+}
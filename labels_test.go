@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestLabelTemplates_FitPage(t *testing.T) {
+	for name, tmpl := range labelTemplates {
+		x, y := tmpl.labelCorner(0)
+		if x < 0 || y > tmpl.pageHeight {
+			t.Errorf("%s: first label at %v, %v is off the page", name, x, y)
+		}
+		x, y = tmpl.labelCorner(tmpl.perPage() - 1)
+		if right, bottom := x+tmpl.labelWidth, y-tmpl.labelHeight; right > tmpl.pageWidth+0.01 || bottom < -0.01 {
+			t.Errorf("%s: last label ends at %v, %v, off the %v x %v page", name, right, bottom, tmpl.pageWidth, tmpl.pageHeight)
+		}
+		if tmpl.pitchX < tmpl.labelWidth || tmpl.pitchY < tmpl.labelHeight-0.01 {
+			t.Errorf("%s: labels overlap", name)
+		}
+	}
+	if got := labelTemplates["avery-5160"].perPage(); got != 30 {
+		t.Errorf("avery-5160 has %d labels, want 30", got)
+	}
+}
+
+func TestLayoutLabels(t *testing.T) {
+	tmpl := labelTemplate{pageWidth: 100, pageHeight: 100, columns: 2, rows: 2, labelWidth: 40, labelHeight: 20, marginLeft: 5, marginTop: 10, pitchX: 50, pitchY: 40}
+	code := labelCell{bitmap: [][]bool{{true}}}
+	tests := []struct {
+		name      string
+		skip      int
+		codes     int
+		wantPages int
+	}{
+		{name: "one sheet", codes: 4, wantPages: 1},
+		{name: "second sheet", codes: 5, wantPages: 2},
+		{name: "skip into second sheet", skip: 3, codes: 2, wantPages: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			codes := make([]labelCell, tt.codes)
+			for i := range codes {
+				codes[i] = code
+			}
+			doc := layoutLabels(tmpl, tt.skip, codes)
+			if len(doc.pages) != tt.wantPages {
+				t.Errorf("got %d pages, want %d", len(doc.pages), tt.wantPages)
+			}
+		})
+	}
+
+	// The code is the largest square within the padding, centered: label 3
+	// spans 55..95 by 50..30 (PDF coordinates), so a 20 - 2*padding square
+	// is centered on 75, 40
+	doc := layoutLabels(tmpl, 3, []labelCell{code})
+	side := 20 - 2*labelPaddingMM*pointsPerMM
+	want := pdfNumber(75-side/2) + " " + pdfNumber(40-side/2) + " " + pdfNumber(side) + " " + pdfNumber(side) + " re\n"
+	if got := doc.pages[0].content.String(); !strings.HasPrefix(got, want) {
+		t.Errorf("content = %q, want %q", got, want)
+	}
+}
+
+func TestLayoutLabels_Caption(t *testing.T) {
+	tmpl := labelTemplate{pageWidth: 100, pageHeight: 100, columns: 1, rows: 1, labelWidth: 100, labelHeight: 100, pitchX: 100, pitchY: 100}
+	doc := layoutLabels(tmpl, 0, []labelCell{{bitmap: [][]bool{{true}}, caption: "A (1)"}})
+	page := doc.pages[0]
+	if !page.text {
+		t.Fatal("page with a caption has no text")
+	}
+	// The code leaves room for a line of 1.25 font sizes below it, and the
+	// two are centered: inner height 100 - 2*padding holds the code and the
+	// line, so the code is that minus the line
+	padding := labelPaddingMM * pointsPerMM
+	side := 100 - 2*padding - 1.25*captionFontSize
+	if want := pdfNumber(50-side/2) + " "; !strings.HasPrefix(page.content.String(), want) {
+		t.Errorf("content = %q, want the code at x %q", page.content.String(), want)
+	}
+	if !strings.Contains(page.content.String(), `(A \(1\)) Tj`) {
+		t.Errorf("content = %q, want the escaped caption", page.content.String())
+	}
+}
+
+func TestLabelGrid_Template(t *testing.T) {
+	tests := []struct {
+		name    string
+		grid    labelGrid
+		want    labelTemplate
+		wantErr bool
+	}{
+		{
+			name: "letter default",
+			grid: labelGrid{Rows: 11, Columns: 8.5 * 2},
+			want: labelTemplate{pageWidth: 612, pageHeight: 792, columns: 17, rows: 11, labelWidth: 36, labelHeight: 72, pitchX: 36, pitchY: 72},
+		},
+		{
+			name: "margin and gutter",
+			grid: labelGrid{PageWidthMM: 95, PageHeightMM: 50, Rows: 2, Columns: 3, MarginMM: 5, GutterMM: 5},
+			want: labelTemplate{
+				pageWidth: 95 * pointsPerMM, pageHeight: 50 * pointsPerMM, columns: 3, rows: 2,
+				labelWidth: 25 * pointsPerMM, labelHeight: 17.5 * pointsPerMM,
+				marginLeft: 5 * pointsPerMM, marginTop: 5 * pointsPerMM,
+				pitchX: 30 * pointsPerMM, pitchY: 22.5 * pointsPerMM,
+			},
+		},
+		{name: "unknown page", grid: labelGrid{Page: "a3", Rows: 1, Columns: 1}, wantErr: true},
+		{name: "page and size", grid: labelGrid{Page: "a4", PageWidthMM: 100, PageHeightMM: 100, Rows: 1, Columns: 1}, wantErr: true},
+		{name: "width only", grid: labelGrid{PageWidthMM: 100, Rows: 1, Columns: 1}, wantErr: true},
+		{name: "no rows", grid: labelGrid{Columns: 1}, wantErr: true},
+		{name: "too many columns", grid: labelGrid{Rows: 1, Columns: maxGridCells + 1}, wantErr: true},
+		{name: "negative gutter", grid: labelGrid{Rows: 1, Columns: 1, GutterMM: -1}, wantErr: true},
+		{name: "cells too small", grid: labelGrid{Page: "a4", Rows: 40, Columns: 40}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, apiErr := tt.grid.template()
+			if (apiErr != nil) != tt.wantErr {
+				t.Fatalf("error = %v, want error %v", apiErr, tt.wantErr)
+			}
+			if apiErr != nil {
+				return
+			}
+			for _, pair := range [][2]float64{
+				{got.pageWidth, tt.want.pageWidth}, {got.pageHeight, tt.want.pageHeight},
+				{got.labelWidth, tt.want.labelWidth}, {got.labelHeight, tt.want.labelHeight},
+				{got.marginLeft, tt.want.marginLeft}, {got.marginTop, tt.want.marginTop},
+				{got.pitchX, tt.want.pitchX}, {got.pitchY, tt.want.pitchY},
+			} {
+				if math.Abs(pair[0]-pair[1]) > 0.001 {
+					t.Errorf("template = %+v, want %+v", got, tt.want)
+					break
+				}
+			}
+			if got.columns != tt.want.columns || got.rows != tt.want.rows {
+				t.Errorf("grid = %dx%d, want %dx%d", got.columns, got.rows, tt.want.columns, tt.want.rows)
+			}
+		})
+	}
+}
+
+func TestLabels(t *testing.T) {
+	tests := []struct {
+		name       string
+		flagOff    bool
+		body       string
+		wantStatus int
+		wantPages  int
+		wantIndex  int
+		wantA4     bool
+	}{
+		{name: "feature disabled", flagOff: true, body: `{"template": "avery-5160", "items": [{"text": "a"}]}`, wantStatus: http.StatusNotFound},
+		{name: "one sheet", body: `{"template": "avery-5160", "items": [{"text": "a"}, {"text": "b"}]}`, wantStatus: http.StatusOK, wantPages: 1},
+		{name: "skip onto second sheet", body: `{"template": "avery-5164", "skip": 5, "ec": "high", "items": [{"text": "a"}, {"text": "b"}]}`, wantStatus: http.StatusOK, wantPages: 2},
+		{name: "invalid body", body: `{"items":`, wantStatus: http.StatusBadRequest},
+		{name: "unknown template", body: `{"template": "avery-0000", "items": [{"text": "a"}]}`, wantStatus: http.StatusBadRequest},
+		{name: "skip beyond sheet", body: `{"template": "avery-5164", "skip": 6, "items": [{"text": "a"}]}`, wantStatus: http.StatusBadRequest},
+		{name: "invalid level", body: `{"template": "avery-5160", "ec": "max", "items": [{"text": "a"}]}`, wantStatus: http.StatusBadRequest},
+		{name: "too many items", body: `{"template": "avery-5160", "items": [{"text": "a"}, {"text": "b"}, {"text": "c"}, {"text": "d"}, {"text": "e"}]}`, wantStatus: http.StatusRequestEntityTooLarge},
+		{name: "empty item", body: `{"template": "avery-5160", "items": [{"text": "a"}, {"text": ""}]}`, wantStatus: http.StatusBadRequest, wantIndex: 1},
+		{name: "grid with captions", body: `{"grid": {"page": "a4", "rows": 2, "columns": 2, "margin_mm": 10, "gutter_mm": 3}, "items": [{"text": "a", "caption": "Sticker A"}]}`, wantStatus: http.StatusOK, wantPages: 1, wantA4: true},
+		{name: "template and grid", body: `{"template": "avery-5160", "grid": {"rows": 2, "columns": 2}, "items": [{"text": "a"}]}`, wantStatus: http.StatusBadRequest},
+		{name: "invalid grid", body: `{"grid": {"rows": 0, "columns": 2}, "items": [{"text": "a"}]}`, wantStatus: http.StatusBadRequest},
+		{name: "caption too long", body: `{"template": "avery-5160", "items": [{"text": "a", "caption": "` + strings.Repeat("x", maxCaptionLength+1) + `"}]}`, wantStatus: http.StatusBadRequest},
+		{name: "denied item", body: `{"template": "avery-5160", "items": [{"text": "javascript:alert(1)"}]}`, wantStatus: http.StatusUnprocessableEntity},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deps := newTestDeps()
+			if !tt.flagOff {
+				deps.flags = mustFeatureFlags("batch")
+			}
+			deps.batchMaxItems = 4
+			rec := httptest.NewRecorder()
+			newHandler(deps).ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v1/qr/labels", strings.NewReader(tt.body)))
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if rec.Code != http.StatusOK {
+				if tt.wantIndex > 0 {
+					var resp struct {
+						Error apiError `json:"error"`
+					}
+					json.NewDecoder(rec.Body).Decode(&resp)
+					if got := resp.Error.Details["index"]; got != float64(tt.wantIndex) {
+						t.Errorf("details.index = %v, want %d", got, tt.wantIndex)
+					}
+				}
+				return
+			}
+			if got := rec.Header().Get("Content-Type"); got != "application/pdf" {
+				t.Errorf("Content-Type = %q", got)
+			}
+			if got := len(pdfStreams(t, rec.Body.Bytes())); got != tt.wantPages {
+				t.Errorf("got %d pages, want %d", got, tt.wantPages)
+			}
+			mediaBox := "/MediaBox [0 0 612 792]"
+			if tt.wantA4 {
+				mediaBox = "/MediaBox [0 0 595.276 841.89]"
+			}
+			if !bytes.Contains(rec.Body.Bytes(), []byte(mediaBox)) {
+				t.Errorf("pages lack %s", mediaBox)
+			}
+		})
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
+)
+
+// cgroupRoot is where the container's cgroup filesystem is mounted
+const cgroupRoot = "/sys/fs/cgroup"
+
+// errNoLimit is returned when the cgroup does not constrain the resource
+var errNoLimit = errors.New("no cgroup limit")
+
+// containerLimits describes the CPU and memory limits applied to the process
+type containerLimits struct {
+	// CPUQuota is the number of CPUs the container may use (e.g. 0.5, 2)
+	CPUQuota float64
+	// MemoryBytes is the container memory limit
+	MemoryBytes int64
+}
+
+// readCPUQuota returns the CPU quota from cgroup v2 (cpu.max) or v1 (cfs quota/period)
+func readCPUQuota(root string) (float64, error) {
+	if data, err := os.ReadFile(filepath.Join(root, "cpu.max")); err == nil {
+		fields := strings.Fields(string(data))
+		if len(fields) != 2 {
+			return 0, fmt.Errorf("unexpected cpu.max format: %q", data)
+		}
+		if fields[0] == "max" {
+			return 0, errNoLimit
+		}
+		return parseQuota(fields[0], fields[1])
+	}
+
+	quota, err := os.ReadFile(filepath.Join(root, "cpu", "cpu.cfs_quota_us"))
+	if err != nil {
+		return 0, errNoLimit
+	}
+	period, err := os.ReadFile(filepath.Join(root, "cpu", "cpu.cfs_period_us"))
+	if err != nil {
+		return 0, errNoLimit
+	}
+	if strings.TrimSpace(string(quota)) == "-1" {
+		return 0, errNoLimit
+	}
+	return parseQuota(strings.TrimSpace(string(quota)), strings.TrimSpace(string(period)))
+}
+
+// parseQuota converts a quota/period pair in microseconds into a CPU count
+func parseQuota(quota, period string) (float64, error) {
+	q, err := strconv.ParseFloat(quota, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid CPU quota %q: %w", quota, err)
+	}
+	p, err := strconv.ParseFloat(period, 64)
+	if err != nil || p <= 0 {
+		return 0, fmt.Errorf("invalid CPU period %q", period)
+	}
+	return q / p, nil
+}
+
+// readMemoryLimit returns the memory limit from cgroup v2 (memory.max) or v1 (memory.limit_in_bytes)
+func readMemoryLimit(root string) (int64, error) {
+	data, err := os.ReadFile(filepath.Join(root, "memory.max"))
+	if err != nil {
+		data, err = os.ReadFile(filepath.Join(root, "memory", "memory.limit_in_bytes"))
+		if err != nil {
+			return 0, errNoLimit
+		}
+	}
+
+	value := strings.TrimSpace(string(data))
+	if value == "max" {
+		return 0, errNoLimit
+	}
+	limit, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid memory limit %q: %w", value, err)
+	}
+	// cgroup v1 reports "unlimited" as a huge page-aligned number
+	if limit <= 0 || limit >= math.MaxInt64/2 {
+		return 0, errNoLimit
+	}
+	return limit, nil
+}
+
+// readContainerLimits reads CPU and memory limits, leaving unconstrained resources at zero
+func readContainerLimits(root string) containerLimits {
+	var limits containerLimits
+	if quota, err := readCPUQuota(root); err == nil {
+		limits.CPUQuota = quota
+	}
+	if memory, err := readMemoryLimit(root); err == nil {
+		limits.MemoryBytes = memory
+	}
+	return limits
+}
+
+// maxProcsForQuota rounds a CPU quota down to a GOMAXPROCS value, never below 1
+func maxProcsForQuota(quota float64) int {
+	procs := int(math.Floor(quota))
+	if procs < 1 {
+		return 1
+	}
+	return procs
+}
+
+// applyContainerLimits sizes GOMAXPROCS to the CPU quota and sets the soft memory
+// limit to memoryRatio of the container limit, so the runtime neither oversubscribes
+// throttled CPUs nor gets OOM-killed before the GC reacts. Explicit GOMAXPROCS and
+// GOMEMLIMIT environment variables always win. It returns the applied values.
+func applyContainerLimits(limits containerLimits, memoryRatio float64) (maxProcs int, memLimit int64) {
+	if _, set := os.LookupEnv("GOMAXPROCS"); !set && limits.CPUQuota > 0 {
+		procs := maxProcsForQuota(limits.CPUQuota)
+		if procs < runtime.NumCPU() {
+			runtime.GOMAXPROCS(procs)
+		}
+	}
+
+	if _, set := os.LookupEnv("GOMEMLIMIT"); !set && limits.MemoryBytes > 0 && memoryRatio > 0 && memoryRatio <= 1 {
+		debug.SetMemoryLimit(int64(float64(limits.MemoryBytes) * memoryRatio))
+	}
+
+	// A negative input only reads the current limit
+	return runtime.GOMAXPROCS(0), debug.SetMemoryLimit(-1)
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeCgroupFiles creates a fake cgroup hierarchy with the given files
+func writeCgroupFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+	root := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatalf("failed to create %s: %v", filepath.Dir(path), err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatalf("failed to write %s: %v", path, err)
+		}
+	}
+	return root
+}
+
+func TestReadContainerLimits(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+		want  containerLimits
+	}{
+		{
+			name:  "cgroup v2 limited",
+			files: map[string]string{"cpu.max": "200000 100000\n", "memory.max": "134217728\n"},
+			want:  containerLimits{CPUQuota: 2, MemoryBytes: 134217728},
+		},
+		{
+			name:  "cgroup v2 unlimited",
+			files: map[string]string{"cpu.max": "max 100000\n", "memory.max": "max\n"},
+			want:  containerLimits{},
+		},
+		{
+			name: "cgroup v1 limited",
+			files: map[string]string{
+				"cpu/cpu.cfs_quota_us":         "50000\n",
+				"cpu/cpu.cfs_period_us":        "100000\n",
+				"memory/memory.limit_in_bytes": "33554432\n",
+			},
+			want: containerLimits{CPUQuota: 0.5, MemoryBytes: 33554432},
+		},
+		{
+			name: "cgroup v1 unlimited",
+			files: map[string]string{
+				"cpu/cpu.cfs_quota_us":         "-1\n",
+				"cpu/cpu.cfs_period_us":        "100000\n",
+				"memory/memory.limit_in_bytes": "9223372036854771712\n",
+			},
+			want: containerLimits{},
+		},
+		{
+			name:  "no cgroup files",
+			files: map[string]string{},
+			want:  containerLimits{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := writeCgroupFiles(t, tt.files)
+			if got := readContainerLimits(root); got != tt.want {
+				t.Errorf("readContainerLimits() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMaxProcsForQuota(t *testing.T) {
+	tests := []struct {
+		quota float64
+		want  int
+	}{
+		{0.2, 1},
+		{1, 1},
+		{1.5, 1},
+		{2, 2},
+		{3.9, 3},
+	}
+
+	for _, tt := range tests {
+		if got := maxProcsForQuota(tt.quota); got != tt.want {
+			t.Errorf("maxProcsForQuota(%v) = %d, want %d", tt.quota, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// loadTestConfig describes a load test run
+type loadTestConfig struct {
+	target      string
+	path        string
+	method      string
+	rps         int
+	duration    time.Duration
+	concurrency int
+	timeout     time.Duration
+	apiKey      string
+}
+
+// loadTestReport summarizes a load test run
+type loadTestReport struct {
+	elapsed   time.Duration
+	latencies []time.Duration // of completed requests, sorted
+	statuses  map[int]int
+	errors    int // requests that got no response
+	dropped   int // requests not sent because concurrency was exhausted
+}
+
+// runLoadTest implements the loadtest subcommand:
+//
+//	qr-generator loadtest --target http://localhost:8080 --rps 200 --duration 30s
+//
+// It sends requests at a fixed rate, independent of response times, and
+// prints the status codes and latency percentiles. It returns the exit code.
+func runLoadTest(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("loadtest", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	var cfg loadTestConfig
+	fs.StringVar(&cfg.target, "target", "http://localhost:8080", "base URL of the service")
+	fs.StringVar(&cfg.path, "path", "/api/v1/qr/generate?text=loadtest", "request path and query")
+	fs.StringVar(&cfg.method, "method", http.MethodPost, "HTTP method")
+	fs.IntVar(&cfg.rps, "rps", 50, "requests per second")
+	fs.DurationVar(&cfg.duration, "duration", 30*time.Second, "how long to send requests")
+	fs.IntVar(&cfg.concurrency, "concurrency", 200, "maximum requests in flight; requests beyond it are dropped")
+	fs.DurationVar(&cfg.timeout, "timeout", 10*time.Second, "per-request timeout")
+	fs.StringVar(&cfg.apiKey, "api-key", "", "value of the "+apiKeyHeader+" header")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if cfg.rps <= 0 || cfg.duration <= 0 || cfg.concurrency <= 0 {
+		fmt.Fprintln(stderr, "loadtest: --rps, --duration, and --concurrency must be positive")
+		return 2
+	}
+
+	fmt.Fprintf(stdout, "Sending %d req/s to %s %s%s for %s\n", cfg.rps, cfg.method, cfg.target, cfg.path, cfg.duration)
+	report := loadTest(context.Background(), cfg)
+	report.print(stdout)
+	if len(report.latencies) == 0 {
+		return 1
+	}
+	return 0
+}
+
+// loadTest sends requests at cfg.rps for cfg.duration and waits for the
+// outstanding ones
+func loadTest(ctx context.Context, cfg loadTestConfig) *loadTestReport {
+	client := &http.Client{
+		Timeout:   cfg.timeout,
+		Transport: &http.Transport{MaxIdleConnsPerHost: cfg.concurrency},
+	}
+	url := strings.TrimSuffix(cfg.target, "/") + cfg.path
+	report := &loadTestReport{statuses: map[int]int{}}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, cfg.concurrency)
+	ticker := time.NewTicker(time.Second / time.Duration(cfg.rps))
+	defer ticker.Stop()
+	ctx, cancel := context.WithTimeout(ctx, cfg.duration)
+	defer cancel()
+
+	start := time.Now()
+send:
+	for {
+		select {
+		case <-ctx.Done():
+			break send
+		case <-ticker.C:
+		}
+		select {
+		case slots <- struct{}{}:
+		default:
+			report.dropped++
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+			status, latency, err := loadTestRequest(client, cfg, url)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				report.errors++
+				return
+			}
+			report.statuses[status]++
+			report.latencies = append(report.latencies, latency)
+		}()
+	}
+	wg.Wait()
+	report.elapsed = time.Since(start)
+	slices.Sort(report.latencies)
+	return report
+}
+
+// loadTestRequest sends one request and reads the whole response
+func loadTestRequest(client *http.Client, cfg loadTestConfig, url string) (int, time.Duration, error) {
+	req, err := http.NewRequest(cfg.method, url, nil)
+	if err != nil {
+		return 0, 0, err
+	}
+	if cfg.apiKey != "" {
+		req.Header.Set(apiKeyHeader, cfg.apiKey)
+	}
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer resp.Body.Close()
+	if _, err := io.Copy(io.Discard, resp.Body); err != nil && !errors.Is(err, io.EOF) {
+		return 0, 0, err
+	}
+	return resp.StatusCode, time.Since(start), nil
+}
+
+// percentile returns the p-th percentile (0-100) of sorted latencies using
+// the nearest-rank method
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p/100*float64(len(sorted)))) - 1
+	return sorted[min(max(rank, 0), len(sorted)-1)]
+}
+
+// print writes the report as a human-readable summary
+func (r *loadTestReport) print(w io.Writer) {
+	completed := len(r.latencies)
+	fmt.Fprintf(w, "\nRequests:  %d completed, %d failed, %d dropped in %s (%.1f req/s)\n",
+		completed, r.errors, r.dropped, r.elapsed.Round(time.Millisecond), float64(completed)/r.elapsed.Seconds())
+
+	codes := make([]int, 0, len(r.statuses))
+	for code := range r.statuses {
+		codes = append(codes, code)
+	}
+	slices.Sort(codes)
+	fmt.Fprint(w, "Statuses: ")
+	for _, code := range codes {
+		fmt.Fprintf(w, " %d=%d", code, r.statuses[code])
+	}
+	fmt.Fprintln(w)
+
+	if completed == 0 {
+		return
+	}
+	fmt.Fprintf(w, "Latency:   p50=%s p90=%s p95=%s p99=%s max=%s\n",
+		percentile(r.latencies, 50).Round(time.Microsecond),
+		percentile(r.latencies, 90).Round(time.Microsecond),
+		percentile(r.latencies, 95).Round(time.Microsecond),
+		percentile(r.latencies, 99).Round(time.Microsecond),
+		r.latencies[completed-1].Round(time.Microsecond))
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPercentile(t *testing.T) {
+	latencies := make([]time.Duration, 100)
+	for i := range latencies {
+		latencies[i] = time.Duration(i+1) * time.Millisecond
+	}
+
+	tests := []struct {
+		name   string
+		sorted []time.Duration
+		p      float64
+		want   time.Duration
+	}{
+		{name: "empty", sorted: nil, p: 50, want: 0},
+		{name: "single", sorted: []time.Duration{time.Second}, p: 99, want: time.Second},
+		{name: "p50", sorted: latencies, p: 50, want: 50 * time.Millisecond},
+		{name: "p99", sorted: latencies, p: 99, want: 99 * time.Millisecond},
+		{name: "p100", sorted: latencies, p: 100, want: 100 * time.Millisecond},
+		{name: "p0", sorted: latencies, p: 0, want: time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := percentile(tt.sorted, tt.p); got != tt.want {
+				t.Errorf("percentile(%v) = %s, want %s", tt.p, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadTest(t *testing.T) {
+	var gotKey atomic.Value
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotKey.Store(r.Header.Get(apiKeyHeader))
+		if r.URL.Query().Get("text") == "fail" {
+			http.Error(w, "boom", http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name       string
+		path       string
+		wantStatus int
+	}{
+		{name: "success", path: "/api/v1/qr/generate?text=ok", wantStatus: http.StatusOK},
+		{name: "server errors", path: "/api/v1/qr/generate?text=fail", wantStatus: http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			report := loadTest(context.Background(), loadTestConfig{
+				target:      server.URL + "/",
+				path:        tt.path,
+				method:      http.MethodPost,
+				rps:         100,
+				duration:    300 * time.Millisecond,
+				concurrency: 10,
+				timeout:     time.Second,
+				apiKey:      "secret",
+			})
+
+			// Ticks are dropped under load, so allow some slack on the count
+			if n := report.statuses[tt.wantStatus]; n < 10 || n > 31 {
+				t.Errorf("%d responses with status %d, want about 30", n, tt.wantStatus)
+			}
+			if len(report.statuses) != 1 || report.errors != 0 {
+				t.Errorf("statuses = %v, errors = %d", report.statuses, report.errors)
+			}
+			if got := gotKey.Load(); got != "secret" {
+				t.Errorf("%s = %q, want %q", apiKeyHeader, got, "secret")
+			}
+
+			var out bytes.Buffer
+			report.print(&out)
+			if !strings.Contains(out.String(), "p99=") {
+				t.Errorf("report lacks latency percentiles:\n%s", out.String())
+			}
+		})
+	}
+}
+
+func TestRunLoadTest_InvalidFlags(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+	}{
+		{name: "unknown flag", args: []string{"--bogus"}},
+		{name: "zero rps", args: []string{"--rps", "0"}},
+		{name: "negative duration", args: []string{"--duration", "-1s"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			if code := runLoadTest(tt.args, &stdout, &stderr); code != 2 {
+				t.Errorf("exit code = %d, want 2", code)
+			}
+		})
+	}
+}
+
+func TestRunLoadTest_Unreachable(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	code := runLoadTest([]string{"--target", "http://127.0.0.1:1", "--rps", "20", "--duration", "100ms"}, &stdout, &stderr)
+	if code != 1 {
+		t.Errorf("exit code = %d, want 1:\n%s", code, stdout.String())
+	}
+}
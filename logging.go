@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// newLogger creates a structured logger writing to w in the given format ("json" or "text")
+func newLogger(w io.Writer, format string, level slog.Leveler) *slog.Logger {
+	opts := &slog.HandlerOptions{Level: level}
+	if strings.EqualFold(format, "text") {
+		return slog.New(contextHandler{slog.NewTextHandler(w, opts)})
+	}
+	return slog.New(contextHandler{slog.NewJSONHandler(w, opts)})
+}
+
+// contextHandler decorates records logged with a context with request-scoped
+// attributes such as the request ID
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, record slog.Record) error {
+	if id := requestIDFromContext(ctx); id != "" {
+		record.AddAttrs(slog.String("request_id", id))
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
+
+// parseLogLevel converts a level name (debug, info, warn, error) into a slog.Level, defaulting to info
+func parseLogLevel(name string) slog.Level {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(name)); err != nil {
+		return slog.LevelInfo
+	}
+	return level
+}
+
+// logAttrsKey is the context key for the per-request log attribute bag
+type logAttrsKey struct{}
+
+// requestLogAttrs collects attributes that handlers attach to the request's access log line
+type requestLogAttrs struct {
+	mu    sync.Mutex
+	attrs []slog.Attr
+}
+
+// addLogAttrs attaches attributes to the access log line of the request carried by ctx.
+// It is a no-op outside of loggingMiddleware.
+func addLogAttrs(ctx context.Context, attrs ...slog.Attr) {
+	if bag, ok := ctx.Value(logAttrsKey{}).(*requestLogAttrs); ok {
+		bag.mu.Lock()
+		bag.attrs = append(bag.attrs, attrs...)
+		bag.mu.Unlock()
+	}
+}
+
+// statusRecorder wraps a ResponseWriter to capture the status code and body size
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	n, err := r.ResponseWriter.Write(b)
+	r.bytes += n
+	return n, err
+}
+
+// Unwrap exposes the underlying ResponseWriter to http.ResponseController
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// loggingMiddleware emits one structured access log line per request with
+// method, path, status, latency, response size, and any handler-provided attributes
+func loggingMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		bag := &requestLogAttrs{}
+		rec := &statusRecorder{ResponseWriter: w}
+
+		next.ServeHTTP(rec, r.WithContext(context.WithValue(r.Context(), logAttrsKey{}, bag)))
+
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+
+		attrs := []slog.Attr{
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.Int("status", rec.status),
+			slog.Duration("latency", time.Since(start)),
+			slog.Int("response_bytes", rec.bytes),
+		}
+		if ip := clientIPFromContext(r.Context()); ip != "" {
+			attrs = append(attrs, slog.String("client_ip", ip))
+		}
+		if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+			attrs = append(attrs, slog.String("client_cn", r.TLS.PeerCertificates[0].Subject.CommonName))
+		}
+		bag.mu.Lock()
+		attrs = append(attrs, bag.attrs...)
+		bag.mu.Unlock()
+
+		level := slog.LevelInfo
+		if rec.status >= http.StatusInternalServerError {
+			level = slog.LevelError
+		}
+		slog.LogAttrs(r.Context(), level, "request completed", attrs...)
+	})
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseLogLevel(t *testing.T) {
+	tests := []struct {
+		input string
+		want  slog.Level
+	}{
+		{"debug", slog.LevelDebug},
+		{"INFO", slog.LevelInfo},
+		{"warn", slog.LevelWarn},
+		{"error", slog.LevelError},
+		{"", slog.LevelInfo},
+		{"verbose", slog.LevelInfo},
+	}
+
+	for _, tt := range tests {
+		if got := parseLogLevel(tt.input); got != tt.want {
+			t.Errorf("parseLogLevel(%q) = %v, want %v", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestLoggingMiddleware(t *testing.T) {
+	var buf bytes.Buffer
+	previous := slog.Default()
+	slog.SetDefault(newLogger(&buf, "json", slog.LevelInfo))
+	t.Cleanup(func() { slog.SetDefault(previous) })
+
+	handler := clientIPMiddleware(nil, loggingMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		addLogAttrs(r.Context(), slog.Int("payload_length", 5))
+		w.WriteHeader(http.StatusTeapot)
+		w.Write([]byte("short"))
+	})))
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/qr/generate?text=hello", nil)
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	var entry map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("access log is not valid JSON: %v (%s)", err, buf.String())
+	}
+
+	want := map[string]any{
+		"level":          "INFO",
+		"msg":            "request completed",
+		"method":         "POST",
+		"path":           "/api/v1/qr/generate",
+		"status":         float64(http.StatusTeapot),
+		"response_bytes": float64(5),
+		"payload_length": float64(5),
+		"client_ip":      "192.0.2.1",
+	}
+	for key, value := range want {
+		if entry[key] != value {
+			t.Errorf("log field %q = %v, want %v", key, entry[key], value)
+		}
+	}
+	if _, ok := entry["latency"]; !ok {
+		t.Errorf("log entry missing latency: %v", entry)
+	}
+}
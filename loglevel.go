@@ -0,0 +1,46 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+// logLevelResponse is the JSON body describing the current log level
+type logLevelResponse struct {
+	Level string `json:"level"`
+}
+
+// logLevelHandler reads and changes the level of the running logger
+type logLevelHandler struct {
+	level *slog.LevelVar
+}
+
+// handleGet returns the current log level
+func (h logLevelHandler) handleGet(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(logLevelResponse{Level: strings.ToLower(h.level.Level().String())})
+}
+
+// handlePut changes the log level from a {"level": "debug"} body
+func (h logLevelHandler) handlePut(w http.ResponseWriter, r *http.Request) {
+	var body logLevelResponse
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Level == "" {
+		http.Error(w, `Invalid body. Usage: PUT /admin/loglevel with {"level": "debug|info|warn|error"}`, http.StatusBadRequest)
+		return
+	}
+
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(body.Level)); err != nil {
+		http.Error(w, "Unknown log level "+body.Level+". Use debug, info, warn, or error", http.StatusBadRequest)
+		return
+	}
+
+	previous := h.level.Level()
+	h.level.Set(level)
+	// Logged at warn so the change is visible at any level
+	slog.WarnContext(r.Context(), "log level changed", "from", previous.String(), "to", level.String())
+
+	h.handleGet(w, r)
+}
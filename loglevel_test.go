@@ -0,0 +1,47 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAdminLogLevel(t *testing.T) {
+	tests := []struct {
+		name      string
+		token     string
+		body      string
+		wantCode  int
+		wantLevel slog.Level
+	}{
+		{"switch to debug", "test-admin-token", `{"level": "debug"}`, http.StatusOK, slog.LevelDebug},
+		{"switch to warn", "test-admin-token", `{"level": "WARN"}`, http.StatusOK, slog.LevelWarn},
+		{"missing token", "", `{"level": "debug"}`, http.StatusUnauthorized, slog.LevelInfo},
+		{"wrong token", "nope", `{"level": "debug"}`, http.StatusUnauthorized, slog.LevelInfo},
+		{"unknown level", "test-admin-token", `{"level": "chatty"}`, http.StatusBadRequest, slog.LevelInfo},
+		{"invalid body", "test-admin-token", `debug`, http.StatusBadRequest, slog.LevelInfo},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deps := newTestDeps()
+			handler := newAdminHandler(deps)
+
+			req := httptest.NewRequest(http.MethodPut, "/admin/loglevel", strings.NewReader(tt.body))
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantCode {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantCode)
+			}
+			if got := deps.logLevel.Level(); got != tt.wantLevel {
+				t.Errorf("log level = %v, want %v", got, tt.wantLevel)
+			}
+		})
+	}
+}
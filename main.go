@@ -1,11 +1,17 @@
 package main
 
 import (
-	"bytes"
+	"cmp"
+	"context"
+	"crypto/x509"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/skip2/go-qrcode"
@@ -14,76 +20,786 @@ import (
 // QRCodeGenerator represents the core QR code generation functionality
 type QRCodeGenerator struct{}
 
-// GenerateQRCodeBytes generates a QR code and returns raw PNG bytes
-func (qr *QRCodeGenerator) GenerateQRCodeBytes(text string) ([]byte, error) {
+// GenerateQRCodeBytes generates a QR code and returns raw PNG bytes.
+// It returns ctx's error without doing any work once ctx is done, so requests
+// whose client went away or whose deadline passed stop consuming CPU.
+func (qr *QRCodeGenerator) GenerateQRCodeBytes(ctx context.Context, text string) ([]byte, error) {
+	img, err := qr.Encode(ctx, text)
+	if err != nil {
+		return nil, err
+	}
+	pngBytes, err := img.PNG()
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode PNG: %w", err)
+	}
+
+	return pngBytes, nil
+}
+
+// Encode builds the QR code symbol for text without rendering it. All input
+// errors surface here, so callers can still send an error response before
+// streaming the image with WriteTo.
+func (qr *QRCodeGenerator) Encode(ctx context.Context, text string) (*qrImage, error) {
+	return qr.EncodeWith(ctx, text, defaultRenderOptions)
+}
+
+// EncodeWith is Encode with options other than the default size, error
+// correction, colors, and format
+func (qr *QRCodeGenerator) EncodeWith(ctx context.Context, text string, opts renderOptions) (*qrImage, error) {
 	if text == "" {
 		return nil, fmt.Errorf("text cannot be empty")
 	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 
-	pngBytes, err := qrcode.Encode(text, qrcode.Medium, 256)
+	img := &qrImage{
+		content:     text,
+		foreground:  opts.foreground,
+		background:  opts.background,
+		size:        opts.size,
+		format:      opts.format,
+		darkMode:    opts.darkMode,
+		optimize:    opts.optimize,
+		accessible:  opts.accessible,
+		title:       opts.title,
+		description: opts.description,
+	}
+	// go-qrcode picks the modes of segments itself and writes no ECI
+	// headers; explicit modes and character sets need the in-house encoder
+	if opts.mode != qrModeAuto || opts.charset != "" {
+		mode := opts.mode
+		if mode == qrModeAuto {
+			mode = qrTextMode(text)
+		}
+		bitmap, version, err := qrEncode(text, mode, opts.charset, opts.level)
+		if err != nil {
+			return nil, err
+		}
+		img.bitmap, img.version = bitmap, version
+		return img, nil
+	}
+	q, err := qrcode.New(text, recoveryLevels[opts.level])
 	if err != nil {
+		// go-qrcode has no sentinel error for oversize content
+		if strings.Contains(err.Error(), "too long") || strings.Contains(err.Error(), "too large") {
+			return nil, fmt.Errorf("%w: %d bytes", errPayloadTooLarge, len(text))
+		}
 		return nil, fmt.Errorf("failed to generate QR code: %w", err)
 	}
-
-	return pngBytes, nil
+	img.bitmap, img.version = q.Bitmap(), q.VersionNumber
+	return img, nil
 }
 
 func main() {
-	fmt.Println("QR Code Generator starting...")
+	if len(os.Args) > 1 && os.Args[1] == "loadtest" {
+		os.Exit(runLoadTest(os.Args[2:], os.Stdout, os.Stderr))
+	}
 
-	qrGen := &QRCodeGenerator{}
+	cfg := loadConfig()
 
 	// Cache hostname at startup
 	hostname, err := os.Hostname()
 	if err != nil {
 		hostname = "unknown"
 	}
-	log.Printf("Hostname: %s", hostname)
 
-	// Health check endpoint
-	http.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "application/json")
-		fmt.Fprintf(w, `{"status":"healthy"}`)
+	pod := loadPodInfo(cfg, hostname)
+
+	logLevel := new(slog.LevelVar)
+	logLevel.Set(parseLogLevel(cfg.LogLevel))
+	logger := newLogger(os.Stdout, cfg.LogFormat, logLevel).With(pod.logAttrs()...)
+	slog.SetDefault(logger)
+	build := currentVersion()
+	slog.Info("QR Code Generator starting",
+		"version", build.Version,
+		"git_commit", build.GitCommit,
+		"build_time", build.BuildTime,
+		"go_version", build.GoVersion,
+		"log_level", cfg.LogLevel)
+
+	if err := loadSecretFiles(&cfg); err != nil {
+		slog.Error("failed to load secrets", "error", err)
+		os.Exit(1)
+	}
+	var vault *vaultClient
+	if cfg.VaultAddr != "" {
+		vault = newVaultClient(cfg)
+		values, err := vault.Load(context.Background())
+		if err != nil {
+			slog.Error("failed to load secrets from Vault", "error", err)
+			os.Exit(1)
+		}
+		slog.Info("secrets loaded from Vault", "path", cfg.VaultSecretPath, "applied", applySecrets(&cfg, values))
+	}
+
+	limits := readContainerLimits(cgroupRoot)
+	maxProcs, memLimit := applyContainerLimits(limits, cfg.MemoryLimitRatio)
+	slog.Info("runtime limits applied",
+		"cgroup_cpu_quota", limits.CPUQuota,
+		"cgroup_memory_bytes", limits.MemoryBytes,
+		"gomaxprocs", maxProcs,
+		"gomemlimit_bytes", memLimit)
+
+	shutdownTracing, err := setupTracing(context.Background(), cfg, pod)
+	if err != nil {
+		slog.Error("failed to set up tracing", "error", err)
+		os.Exit(1)
+	}
+	if cfg.OTLPEndpoint != "" {
+		slog.Info("exporting traces", "endpoint", cfg.OTLPEndpoint)
+	}
+
+	reporter, err := newErrorReporter(context.Background(), cfg)
+	if err != nil {
+		slog.Error("failed to set up error reporting", "error", err)
+		os.Exit(1)
+	}
+
+	qrGen := &QRCodeGenerator{}
+
+	health := newHealthRegistry()
+	health.Register("generator", true, func(ctx context.Context) error {
+		_, err := qrGen.GenerateQRCodeBytes(ctx, "health-check")
+		return err
 	})
 
-	// QR code generation endpoint - POST with query parameters
-	http.HandleFunc("/api/v1/qr/generate", func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != http.MethodPost {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-			return
+	flags, err := newFeatureFlags(cfg.FeatureFlags)
+	if err != nil {
+		slog.Error("invalid feature flags", "error", err)
+		os.Exit(1)
+	}
+	slog.Info("feature flags loaded", "flags", flags.Snapshot())
+
+	keySpec, err := loadSecretSpec(cfg.APIKeys, cfg.APIKeysFile)
+	if err != nil {
+		slog.Error("failed to load api keys", "error", err)
+		os.Exit(1)
+	}
+	apiKeys, err := newAPIKeyStore(cfg.APIKeyAuth, keySpec)
+	if err != nil {
+		slog.Error("invalid api keys", "error", err)
+		os.Exit(1)
+	}
+	if err := apiKeys.SetTenants(cfg.APIKeyTenants); err != nil {
+		slog.Error("invalid API_KEY_TENANTS", "error", err)
+		os.Exit(1)
+	}
+	slog.Info("api keys loaded", "required", cfg.APIKeyAuth, "count", len(apiKeys.List()))
+
+	tenants, err := loadTenants(cfg.TenantsFile)
+	if err != nil {
+		slog.Error("invalid tenant configuration", "error", err)
+		os.Exit(1)
+	}
+
+	hmacSpec, err := loadSecretSpec(cfg.HMACClients, cfg.HMACClientsFile)
+	if err != nil {
+		slog.Error("failed to load HMAC clients", "error", err)
+		os.Exit(1)
+	}
+	hmacVerifier, err := newHMACVerifier(hmacSpec)
+	if err != nil {
+		slog.Error("invalid HMAC clients", "error", err)
+		os.Exit(1)
+	}
+	if hmacVerifier != nil {
+		slog.Info("HMAC request signing enabled", "clients", len(hmacVerifier.secrets))
+	}
+
+	signer, err := newPayloadSigner(cfg.SigningKey, cfg.SigningIssuer)
+	if err != nil {
+		slog.Error("invalid SIGNING_KEY", "error", err)
+		os.Exit(1)
+	}
+	if signer != nil {
+		slog.Info("signed payloads enabled", "alg", signer.method.Alg(), "kid", signer.kid, "issuer", cfg.SigningIssuer)
+	}
+
+	var jwtVerifier *jwtVerifier
+	if cfg.JWTJWKSURL != "" {
+		jwtVerifier = newJWTVerifier(cfg.JWTJWKSURL, cfg.JWTIssuer, cfg.JWTAudience, cfg.JWTTenantClaim)
+		slog.Info("JWT bearer authentication enabled", "jwks_url", cfg.JWTJWKSURL, "issuer", cfg.JWTIssuer, "audience", cfg.JWTAudience)
+	}
+
+	var sso *oidcAuth
+	if cfg.OIDCIssuerURL != "" {
+		sso, err = newOIDCAuth(oidcConfig{
+			IssuerURL:     cfg.OIDCIssuerURL,
+			ClientID:      cfg.OIDCClientID,
+			ClientSecret:  cfg.OIDCClientSecret,
+			RedirectURL:   cfg.OIDCRedirectURL,
+			GroupsClaim:   cfg.OIDCGroupsClaim,
+			AdminGroups:   splitList(cfg.OIDCAdminGroups),
+			SessionSecret: cfg.OIDCSessionSecret,
+			SessionTTL:    cfg.OIDCSessionTTL,
+		})
+		if err != nil {
+			slog.Error("invalid OIDC configuration", "error", err)
+			os.Exit(1)
 		}
+		slog.Info("OIDC login enabled", "issuer", cfg.OIDCIssuerURL, "admin_groups", cfg.OIDCAdminGroups)
+	}
+
+	proxies, err := parseTrustedProxies(cfg.TrustedProxies, cfg.ClientIPHeader)
+	if err != nil {
+		slog.Error("invalid TRUSTED_PROXIES or CLIENT_IP_HEADER", "error", err)
+		os.Exit(1)
+	}
+	basePath, err := parseBasePath(cfg.BasePath)
+	if err != nil {
+		slog.Error("invalid BASE_PATH", "error", err)
+		os.Exit(1)
+	}
+	if basePath != "" {
+		slog.Info("serving below base path", "base_path", basePath)
+	}
+	publicAllowlist, err := newIPAllowlist("public", cfg.PublicAllowedCIDRs, proxies)
+	if err != nil {
+		slog.Error("invalid PUBLIC_ALLOWED_CIDRS", "error", err)
+		os.Exit(1)
+	}
+	adminAllowlist, err := newIPAllowlist("admin", cfg.AdminAllowedCIDRs, proxies)
+	if err != nil {
+		slog.Error("invalid ADMIN_ALLOWED_CIDRS", "error", err)
+		os.Exit(1)
+	}
+	if publicAllowlist != nil || adminAllowlist != nil {
+		slog.Info("IP allowlists enabled", "public", cfg.PublicAllowedCIDRs, "admin", cfg.AdminAllowedCIDRs)
+	}
+
+	audit, err := newAuditLog(cfg.AuditLogSize, cfg.AuditLogFile)
+	if err != nil {
+		slog.Error("failed to set up audit log", "error", err)
+		os.Exit(1)
+	}
+
+	policy, err := loadContentPolicy(cfg.ContentPolicyFile)
+	if err != nil {
+		slog.Error("invalid content policy", "error", err)
+		os.Exit(1)
+	}
+
+	sanitize, err := parseSanitizeMode(cfg.PayloadSanitize)
+	if err != nil {
+		slog.Error("invalid PAYLOAD_SANITIZE", "error", err)
+		os.Exit(1)
+	}
 
-		text := r.URL.Query().Get("text")
-		if text == "" {
-			http.Error(w, "Missing required parameter 'text'. Usage: POST /api/v1/qr/generate?text=your-text-here", http.StatusBadRequest)
-			return
+	cache := newImageCache(cfg.CacheSize, cfg.CacheTTL)
+	redisCache, err := newRedisCache(cfg.RedisURL, cfg.CacheTTL)
+	if err != nil {
+		slog.Error("invalid Redis configuration", "error", err)
+		os.Exit(1)
+	}
+	if redisCache != nil {
+		if cache == nil {
+			slog.Error("REDIS_URL requires CACHE_SIZE > 0")
+			os.Exit(1)
 		}
+		cache.remote = redisCache
+		health.Register("redis_cache", false, redisCache.check)
+		slog.Info("shared Redis image cache enabled", "ttl", cfg.CacheTTL)
+	}
+
+	presets, err := newPresetRegistry(cfg.RedisURL)
+	if err != nil {
+		slog.Error("invalid Redis configuration", "error", err)
+		os.Exit(1)
+	}
+	if presets.shared() {
+		health.Register("presets", false, presets.check)
+	} else {
+		slog.Info("presets are kept in memory; set REDIS_URL to share them between replicas")
+	}
+
+	renderWorkers := cfg.RenderWorkers
+	if renderWorkers == 0 {
+		renderWorkers = maxProcs
+	}
+	slog.Info("render pool configured", "workers", renderWorkers, "queue", cfg.RenderQueue)
+
+	var rateLimiter *ipRateLimiter
+	if cfg.RateLimitRPS > 0 {
+		rateLimiter = newIPRateLimiter(cfg.RateLimitRPS, cfg.RateLimitBurst, proxies)
+		slog.Info("per-IP rate limiting enabled", "rps", cfg.RateLimitRPS, "burst", cfg.RateLimitBurst, "trusted_proxies", cfg.TrustedProxies, "client_ip_header", proxies.header)
+	}
+
+	email, err := newEmailSender(cfg.SMTPAddr, cfg.SMTPUsername, cfg.SMTPPassword, cfg.EmailFrom, cfg.EmailSubject, cfg.EmailTemplateFile, cfg.EmailAllowedDomains)
+	if err != nil {
+		slog.Error("invalid email delivery configuration", "error", err)
+		os.Exit(1)
+	}
+	if email != nil {
+		slog.Info("email delivery enabled", "smtp", cfg.SMTPAddr, "from", cfg.EmailFrom, "allowed_domains", cfg.EmailAllowedDomains)
+	}
+
+	mqttClientID := cfg.MQTTClientID
+	if mqttClientID == "" {
+		mqttClientID = "qr-generator-" + pod.Name
+	}
+	mqttPublisher, err := newMQTTPublisher(cfg.MQTTBrokerURL, mqttClientID, cfg.MQTTTopicPrefix, cfg.MQTTQoS, cfg.MQTTRetain)
+	if err != nil {
+		slog.Error("invalid MQTT configuration", "error", err)
+		os.Exit(1)
+	}
+	if mqttPublisher != nil {
+		health.Register("mqtt", false, mqttPublisher.check)
+		slog.Info("MQTT delivery enabled", "client_id", mqttClientID, "topic_prefix", cfg.MQTTTopicPrefix, "qos", cfg.MQTTQoS, "retain", cfg.MQTTRetain)
+	}
+
+	printers, err := newIPPPrinters(cfg.IPPPrinters, cfg.IPPMedia, cfg.IPPTimeout)
+	if err != nil {
+		slog.Error("invalid printer configuration", "error", err)
+		os.Exit(1)
+	}
+	if printers != nil {
+		health.Register("printers", false, printers.check)
+		slog.Info("printing enabled", "printers", printers.names(), "media", cfg.IPPMedia)
+	}
 
-		log.Printf("[%s] Processing QR code generation request for content: %q", hostname, text)
+	sftpTarget, err := newSFTPTarget(cfg.SFTPURL, cfg.SFTPHostKey, cfg.SFTPPrivateKeyFile, cfg.SFTPPathTemplate, cfg.SFTPTimeout)
+	if err != nil {
+		slog.Error("invalid SFTP configuration", "error", err)
+		os.Exit(1)
+	}
+	if sftpTarget != nil {
+		slog.Info("SFTP delivery enabled", "addr", sftpTarget.addr, "dir", sftpTarget.dir, "path_template", cfg.SFTPPathTemplate)
+	}
 
-		pngBytes, err := qrGen.GenerateQRCodeBytes(text)
+	slack, err := newSlackCommands(cfg.SlackSigningSecret, cfg.SlackPublicURL, cfg.SlackImageTTL)
+	if err != nil {
+		slog.Error("invalid Slack configuration", "error", err)
+		os.Exit(1)
+	}
+	if slack != nil {
+		slog.Info("Slack slash command enabled", "public_url", cfg.SlackPublicURL)
+	}
+
+	simple, err := newSimpleAPI(cfg.SimpleAPIPublicURL, cfg.SimpleAPILinkSecret, cfg.SimpleAPIImageTTL)
+	if err != nil {
+		slog.Error("invalid simple API configuration", "error", err)
+		os.Exit(1)
+	}
+	if simple != nil {
+		slog.Info("simple endpoints for low-code connectors enabled", "public_url", cfg.SimpleAPIPublicURL)
+	}
+
+	embed, err := newEmbedWidget(cfg.EmbedFrameAncestors)
+	if err != nil {
+		slog.Error("invalid embed configuration", "error", err)
+		os.Exit(1)
+	}
+	if embed != nil {
+		slog.Info("embed page enabled", "frame_ancestors", cfg.EmbedFrameAncestors)
+	}
+
+	for _, b := range registeredPayloadBuilders() {
+		slog.Info("custom payload type enabled", "type", b.Type(), "endpoint", "/api/v1/qr/"+b.Type())
+	}
+
+	ready := &readiness{}
+	serviceMetrics := newMetrics()
+	webhooks, err := newWebhookDispatcher(cfg.WebhookEndpoints, cfg.WebhookSecret, cfg.WebhookTimeout, cfg.WebhookMaxAttempts, serviceMetrics)
+	if err != nil {
+		slog.Error("invalid webhook configuration", "error", err)
+		os.Exit(1)
+	}
+	if webhooks != nil {
+		slog.Info("webhooks enabled", "endpoints", len(webhooks.endpoints))
+	}
+	deprecated, err := loadDeprecations(cfg.DeprecationsFile, serviceMetrics)
+	if err != nil {
+		slog.Error("invalid deprecations", "error", err)
+		os.Exit(1)
+	}
+	if deprecated != nil {
+		slog.Info("deprecated routes loaded", "routes", len(deprecated.routes))
+	}
+	deps := handlerDeps{
+		qrGen:       qrGen,
+		health:      health,
+		ready:       ready,
+		pod:         pod,
+		metrics:     serviceMetrics,
+		flags:       flags,
+		reporter:    reporter,
+		apiKeys:     apiKeys,
+		rateLimiter: rateLimiter,
+		jwt:         jwtVerifier,
+		hmac:        hmacVerifier,
+		signer:      signer,
+		tenants:     tenants,
+		presets:     presets,
+		logLevel:    logLevel,
+		adminToken:  cfg.AdminToken,
+		oidc:        sso,
+
+		requestTimeout:      cfg.RequestTimeout,
+		maxInFlight:         cfg.MaxInFlight,
+		admission:           newAdmissionController(cfg.AdmissionCapacity, cfg.AdmissionTargetWait, serviceMetrics),
+		compressMinBytes:    cfg.CompressionMinBytes,
+		maxPayloadLength:    cfg.MaxPayloadLength,
+		sanitize:            sanitize,
+		cache:               cache,
+		imageMaxAge:         cfg.ImageMaxAge,
+		deterministicOutput: cfg.DeterministicOutput,
+		renderPool:          newRenderPool(renderWorkers, cfg.RenderQueue),
+		batchMaxItems:       cfg.BatchMaxItems,
+		email:               email,
+		mqtt:                mqttPublisher,
+		printers:            printers,
+		sftp:                sftpTarget,
+		slack:               slack,
+		simple:              simple,
+		embed:               embed,
+		webhooks:            webhooks,
+		deprecations:        deprecated,
+		maxBodyBytes:        cfg.MaxBodyBytes,
+		maxGzipBodyBytes:    cmp.Or(cfg.MaxDecompressedBodyBytes, cfg.MaxBodyBytes),
+		policy:              policy,
+		audit:               audit,
+		proxies:             proxies,
+		basePath:            basePath,
+		publicAllowlist:     publicAllowlist,
+		adminAllowlist:      adminAllowlist,
+		cors:                newCORSPolicy(cfg.CORSAllowedOrigins, cfg.CORSAllowedMethods, cfg.CORSAllowedHeaders, cfg.CORSMaxAge, cfg.CORSAllowCredentials),
+	}
+
+	store, err := newObjectStore(context.Background(), cfg.StorageURL)
+	if err != nil {
+		slog.Error("invalid STORAGE_URL", "error", err)
+		os.Exit(1)
+	}
+	if store != nil {
+		health.Register("storage", false, store.Check)
+	}
+	newJobProcessor := func(source string) *jobProcessor {
+		return &jobProcessor{deps: deps, store: store, source: source, retryPolicy: retryPolicy{
+			attempts: max(cfg.JobMaxAttempts, 1), backoff: cfg.JobRetryBackoff, maxBackoff: cfg.JobRetryMaxBackoff,
+		}}
+	}
+	if store != nil {
+		deps.jobs, err = newJobQueue(newJobProcessor("api"), cfg.RedisURL, max(cfg.JobQueueSize, 1), cfg.JobRetention)
+		if err != nil {
+			slog.Error("invalid Redis configuration", "error", err)
+			os.Exit(1)
+		}
+		if deps.jobs.shared() {
+			health.Register("job_queue", false, deps.jobs.check)
+		} else {
+			slog.Info("jobs API queue is kept in memory; set REDIS_URL to share it between replicas")
+		}
+	}
+	if cfg.GoogleSheetsCredentials != "" {
+		if store == nil {
+			slog.Error("GOOGLE_SHEETS_CREDENTIALS requires STORAGE_URL")
+			os.Exit(1)
+		}
+		client, err := newSheetsClient(cfg.GoogleSheetsCredentials, cfg.GoogleSheetsAPIURL)
 		if err != nil {
-			http.Error(w, "Failed to generate QR code", http.StatusInternalServerError)
-			return
+			slog.Error("invalid Google Sheets configuration", "error", err)
+			os.Exit(1)
 		}
+		deps.sheets = &sheetBatches{client: client, processor: newJobProcessor("sheets")}
+		slog.Info("Google Sheets batches enabled", "service_account", client.email)
+	}
 
-		w.Header().Set("Content-Type", "image/png")
-		w.Header().Set("Content-Length", fmt.Sprintf("%d", len(pngBytes)))
+	schedules, err := loadSchedules(cfg.SchedulesFile)
+	if err != nil {
+		slog.Error("invalid SCHEDULES_FILE", "error", err)
+		os.Exit(1)
+	}
+	if len(schedules) > 0 {
+		if store == nil {
+			slog.Error("SCHEDULES_FILE requires STORAGE_URL")
+			os.Exit(1)
+		}
+		var client *sheetsClient
+		if deps.sheets != nil {
+			client = deps.sheets.client
+		}
+		deps.schedules, err = newScheduler(context.Background(), schedules, newJobProcessor("schedule"), client,
+			cfg.RedisURL, deps.renderPool.size(), cfg.BatchMaxItems)
+		if err != nil {
+			slog.Error("failed to set up schedules", "error", err)
+			os.Exit(1)
+		}
+		if cfg.RedisURL == "" {
+			slog.Info("schedules run on every replica; set REDIS_URL to run each once")
+		}
+		slog.Info("scheduled batches enabled", "schedules", len(schedules))
+	}
 
-		reader := bytes.NewReader(pngBytes)
-		http.ServeContent(w, r, "qrcode.png", time.Time{}, reader)
-	})
+	var kafkaWorker *kafkaWorker
+	if cfg.KafkaBrokers != "" {
+		if store == nil {
+			slog.Error("KAFKA_BROKERS requires STORAGE_URL")
+			os.Exit(1)
+		}
+		kafkaWorker = newKafkaWorker(splitList(cfg.KafkaBrokers), cfg.KafkaTopic, cfg.KafkaGroupID, cfg.KafkaResultTopic, newJobProcessor("kafka"))
+		health.Register("kafka", false, kafkaWorker.check)
+		slog.Info("kafka worker enabled", "brokers", cfg.KafkaBrokers, "topic", cfg.KafkaTopic, "group", cfg.KafkaGroupID, "result_topic", cfg.KafkaResultTopic)
+	}
 
-	// Root endpoint
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		if r.URL.Path != "/" {
-			http.NotFound(w, r)
-			return
+	var natsWorker *natsWorker
+	if cfg.NATSURL != "" {
+		natsWorker, err = newNATSWorker(cfg.NATSURL, cfg.NATSSubject, cfg.NATSQueueGroup, cfg.RequestTimeout, newJobProcessor("nats"))
+		if err != nil {
+			slog.Error("failed to set up NATS", "error", err)
+			os.Exit(1)
 		}
-		fmt.Fprintf(w, "QR Code Generator API")
-	})
+		health.Register("nats", false, natsWorker.check)
+		slog.Info("NATS request-reply enabled", "subject", cfg.NATSSubject, "queue_group", cfg.NATSQueueGroup)
+	}
+
+	var sqsWorker *sqsWorker
+	if cfg.SQSQueueURL != "" {
+		if store == nil {
+			slog.Error("SQS_QUEUE_URL requires STORAGE_URL")
+			os.Exit(1)
+		}
+		sqsWorker, err = newSQSWorker(context.Background(), cfg.SQSQueueURL, cfg.SQSDeadLetterURL, cfg.SQSResultQueueURL,
+			cfg.SQSVisibilityTimeout, max(cfg.SQSMaxReceives, 1), newJobProcessor("sqs"))
+		if err != nil {
+			slog.Error("failed to set up SQS", "error", err)
+			os.Exit(1)
+		}
+		health.Register("sqs", false, sqsWorker.check)
+		slog.Info("SQS worker enabled", "queue", cfg.SQSQueueURL, "dead_letter_queue", cfg.SQSDeadLetterURL, "result_queue", cfg.SQSResultQueueURL)
+	}
 
-	fmt.Println("Server starting on :8080")
-	fmt.Println("QR generation: POST http://localhost:8080/api/v1/qr/generate?text=your-text-here")
-	log.Fatal(http.ListenAndServe(":8080", nil))
+	var s3Events *s3EventWorker
+	if cfg.S3EventsQueueURL != "" {
+		s3Events, err = newS3EventWorker(context.Background(), cfg.S3EventsQueueURL, cfg.S3EventsSuffix, cfg.SQSVisibilityTimeout,
+			max(cfg.SQSMaxReceives, 1), deps.renderPool.size(), cfg.BatchMaxItems, newJobProcessor("s3"))
+		if err != nil {
+			slog.Error("failed to set up S3 events", "error", err)
+			os.Exit(1)
+		}
+		health.Register("s3_events", false, s3Events.check)
+		slog.Info("S3 event processing enabled", "queue", cfg.S3EventsQueueURL, "suffix", cfg.S3EventsSuffix)
+	}
+
+	var amqpWorker *amqpWorker
+	if cfg.AMQPURL != "" {
+		if store == nil {
+			slog.Error("AMQP_URL requires STORAGE_URL")
+			os.Exit(1)
+		}
+		amqpWorker = newAMQPWorker(cfg.AMQPURL, cfg.AMQPQueue, cfg.AMQPResultExchange, cfg.AMQPResultRoutingKey,
+			cfg.AMQPPrefetch, cfg.AMQPRequeueOnFailure, newJobProcessor("amqp"))
+		health.Register("amqp", false, amqpWorker.check)
+		slog.Info("AMQP worker enabled", "queue", cfg.AMQPQueue, "prefetch", amqpWorker.prefetch, "requeue_on_failure", cfg.AMQPRequeueOnFailure)
+	}
+
+	server := &http.Server{
+		Addr:     ":" + cfg.Port,
+		Handler:  newHandler(deps),
+		ErrorLog: slog.NewLogLogger(logger.Handler(), slog.LevelError),
+
+		// Bound slow clients (slowloris) now that the service is exposed through the Ingress
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		ReadTimeout:       cfg.ReadTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+		MaxHeaderBytes:    cfg.MaxHeaderBytes,
+	}
+	conns := connLimits{
+		maxConns:           cfg.MaxConnections,
+		keepAlive:          cfg.KeepAlive,
+		tcpKeepAlive:       cfg.TCPKeepAlive,
+		maxRequestsPerConn: cfg.MaxRequestsPerConn,
+	}
+	conns.apply(server, deps.metrics)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if rateLimiter != nil {
+		go rateLimiter.cleanup(ctx)
+	}
+	if vault != nil {
+		current := cfg
+		go vault.watch(ctx, cfg.VaultRefreshInterval, func(values map[string]string) {
+			rotateCredentials(&current, values, apiKeys, hmacVerifier)
+		})
+	}
+
+	scheme := "http"
+	if cfg.TLSCertFile != "" && cfg.TLSKeyFile != "" {
+		reloader, err := newCertReloader(cfg.TLSCertFile, cfg.TLSKeyFile)
+		if err != nil {
+			slog.Error("failed to load TLS certificate", "error", err)
+			os.Exit(1)
+		}
+		go reloader.watch(ctx, cfg.TLSReloadInterval)
+
+		var clientCAs *x509.CertPool
+		if cfg.TLSClientCAFile != "" {
+			clientCAs, err = loadCertPool(cfg.TLSClientCAFile)
+			if err != nil {
+				slog.Error("failed to load client CA bundle", "error", err)
+				os.Exit(1)
+			}
+			slog.Info("mutual TLS enabled, client certificates required", "ca_file", cfg.TLSClientCAFile)
+		}
+
+		server.TLSConfig = newTLSConfig(reloader, clientCAs)
+		scheme = "https"
+	} else if cfg.TLSClientCAFile != "" {
+		slog.Error("TLS_CLIENT_CA_FILE requires TLS_CERT_FILE and TLS_KEY_FILE")
+		os.Exit(1)
+	}
+
+	go func() {
+		slog.Info("server starting", "addr", server.Addr, "scheme", scheme,
+			"usage", fmt.Sprintf("POST %s://localhost:%s/api/v1/qr/generate?text=your-text-here", scheme, cfg.Port))
+		listener, err := conns.listen(ctx, server.Addr)
+		if err != nil {
+			slog.Error("failed to listen", "addr", server.Addr, "error", err)
+			os.Exit(1)
+		}
+		if server.TLSConfig != nil {
+			err = server.ServeTLS(listener, "", "")
+		} else {
+			err = server.Serve(listener)
+		}
+		if err != nil && err != http.ErrServerClosed {
+			slog.Error("server error", "error", err)
+			os.Exit(1)
+		}
+	}()
+
+	// Queue workers stop taking jobs on shutdown; unfinished jobs are redelivered
+	var workers sync.WaitGroup
+	if kafkaWorker != nil {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			kafkaWorker.run(ctx)
+		}()
+	}
+	if sqsWorker != nil {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			sqsWorker.run(ctx)
+		}()
+	}
+	if s3Events != nil {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			s3Events.run(ctx)
+		}()
+	}
+	if amqpWorker != nil {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			amqpWorker.run(ctx)
+		}()
+	}
+	if deps.schedules != nil {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			deps.schedules.run(ctx)
+		}()
+	}
+	if deps.jobs != nil {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			deps.jobs.run(ctx, deps.renderPool.size())
+		}()
+	}
+	if natsWorker != nil {
+		if err := natsWorker.start(); err != nil {
+			slog.Error("failed to start NATS worker", "error", err)
+			os.Exit(1)
+		}
+	}
+
+	// Warm up in the background: liveness is served immediately, readiness only afterwards
+	go warmupUntilReady(ctx, qrGen, health, ready)
+
+	// Internal admin listener for probes, metrics, and debugging, never exposed through the Ingress
+	var adminServer *http.Server
+	if cfg.AdminPort != "" {
+		adminServer = &http.Server{
+			Addr:     ":" + cfg.AdminPort,
+			Handler:  newAdminHandler(deps),
+			ErrorLog: server.ErrorLog,
+
+			// No read/write timeouts: CPU profiles and traces stream for up to 30s+
+			ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+			IdleTimeout:       cfg.IdleTimeout,
+		}
+		go func() {
+			slog.Info("admin server starting", "addr", adminServer.Addr)
+			if err := adminServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				slog.Error("admin server error", "error", err)
+				os.Exit(1)
+			}
+		}()
+	}
+
+	// Wait for a termination signal, then drain in-flight requests and flush pending spans
+	<-ctx.Done()
+
+	slog.Info("shutting down")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		slog.Error("server shutdown error", "error", err)
+	}
+	if adminServer != nil {
+		if err := adminServer.Shutdown(shutdownCtx); err != nil {
+			slog.Error("admin server shutdown error", "error", err)
+		}
+	}
+	if natsWorker != nil {
+		if err := natsWorker.Close(shutdownCtx); err != nil {
+			slog.Error("failed to drain NATS subscriptions", "error", err)
+		}
+	}
+	workers.Wait()
+	webhooks.Close(shutdownCtx)
+	if mqttPublisher != nil {
+		mqttPublisher.Close()
+	}
+	if kafkaWorker != nil {
+		if err := kafkaWorker.Close(); err != nil {
+			slog.Error("failed to close kafka worker", "error", err)
+		}
+	}
+	if redisCache != nil {
+		if err := redisCache.Close(); err != nil {
+			slog.Error("failed to close redis connections", "error", err)
+		}
+	}
+	if deps.schedules != nil {
+		if err := deps.schedules.Close(); err != nil {
+			slog.Error("failed to close scheduler redis connections", "error", err)
+		}
+	}
+	if err := presets.Close(); err != nil {
+		slog.Error("failed to close preset store", "error", err)
+	}
+	if deps.jobs != nil {
+		if err := deps.jobs.Close(); err != nil {
+			slog.Error("failed to close job store", "error", err)
+		}
+	}
+	if err := audit.Close(); err != nil {
+		slog.Error("failed to close audit log", "error", err)
+	}
+	if err := reporter.Shutdown(shutdownCtx); err != nil {
+		slog.Error("failed to flush error reports", "error", err)
+	}
+	if err := shutdownTracing(shutdownCtx); err != nil {
+		slog.Error("failed to flush traces", "error", err)
+	}
 }
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"testing"
 )
 
@@ -31,7 +32,7 @@ func TestQRCodeGenerator_GenerateQRCodeBytes(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			result, err := qrGen.GenerateQRCodeBytes(tt.input)
+			result, err := qrGen.GenerateQRCodeBytes(context.Background(), tt.input)
 
 			if tt.wantErr {
 				if err == nil {
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// metrics holds the Prometheus collectors exported on the admin listener
+type metrics struct {
+	registry *prometheus.Registry
+
+	requestsTotal   *prometheus.CounterVec
+	requestDuration *prometheus.HistogramVec
+	inFlight        prometheus.Gauge
+	openConns       prometheus.Gauge
+	generatedTotal  prometheus.Counter
+	cacheLookups    *prometheus.CounterVec
+	requestsShed    *prometheus.CounterVec
+	jobsProcessed   *prometheus.CounterVec
+	jobRetries      *prometheus.CounterVec
+	deliveries      *prometheus.CounterVec
+	scheduledRuns   *prometheus.CounterVec
+
+	deprecatedRequests *prometheus.CounterVec
+}
+
+// newMetrics creates a registry with the service metrics plus Go runtime and process collectors
+func newMetrics() *metrics {
+	m := &metrics{
+		registry: prometheus.NewRegistry(),
+		requestsTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "qr_http_requests_total",
+			Help: "Total number of HTTP requests by route, method, and status code.",
+		}, []string{"route", "method", "code"}),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "qr_http_request_duration_seconds",
+			Help:    "HTTP request latency by route and method.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"route", "method"}),
+		inFlight: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "qr_http_requests_in_flight",
+			Help: "Number of HTTP requests currently being served.",
+		}),
+		openConns: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "qr_http_open_connections",
+			Help: "Number of open client connections on the public listener.",
+		}),
+		generatedTotal: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "qr_codes_generated_total",
+			Help: "Total number of QR codes successfully generated.",
+		}),
+		cacheLookups: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "qr_image_cache_lookups_total",
+			Help: "Rendered image cache lookups by result (hit, miss, or coalesced with a concurrent render).",
+		}, []string{"result"}),
+		requestsShed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "qr_http_requests_shed_total",
+			Help: "Requests rejected by admission control under overload, by priority.",
+		}, []string{"priority"}),
+		jobsProcessed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "qr_jobs_processed_total",
+			Help: "Generation jobs from message queues by source and status (succeeded or failed).",
+		}, []string{"source", "status"}),
+		jobRetries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "qr_job_retries_total",
+			Help: "Retries of generation jobs failing transiently by source.",
+		}, []string{"source"}),
+		deliveries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "qr_deliveries_total",
+			Help: "Generated codes and events delivered to recipients by channel and status (succeeded, failed, or dropped).",
+		}, []string{"channel", "status"}),
+		scheduledRuns: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "qr_scheduled_runs_total",
+			Help: "Runs of scheduled batches by schedule and status (succeeded or failed).",
+		}, []string{"schedule", "status"}),
+		deprecatedRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "qr_deprecated_requests_total",
+			Help: "Requests to deprecated routes by route.",
+		}, []string{"route"}),
+	}
+
+	m.registry.MustRegister(
+		m.requestsTotal,
+		m.requestDuration,
+		m.inFlight,
+		m.openConns,
+		m.generatedTotal,
+		m.cacheLookups,
+		m.requestsShed,
+		m.jobsProcessed,
+		m.jobRetries,
+		m.deliveries,
+		m.scheduledRuns,
+		m.deprecatedRequests,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return m
+}
+
+// Handler serves the registry in the Prometheus exposition format
+func (m *metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{Registry: m.registry})
+}
+
+// instrument records request count, latency, and in-flight requests for a route.
+// The route pattern (not the raw path) is used as label to keep cardinality bounded.
+func (m *metrics) instrument(route string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		m.inFlight.Inc()
+		defer m.inFlight.Dec()
+
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		m.requestsTotal.WithLabelValues(route, r.Method, strconv.Itoa(rec.status)).Inc()
+		m.requestDuration.WithLabelValues(route, r.Method).Observe(time.Since(start).Seconds())
+	})
+}
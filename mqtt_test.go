@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// mqttMessage is a message captured instead of being published
+type mqttMessage struct {
+	topic   string
+	payload []byte
+}
+
+// newTestMQTTPublisher returns a publisher capturing messages into sent
+func newTestMQTTPublisher(sent *[]mqttMessage, err error) *mqttPublisher {
+	return &mqttPublisher{prefix: "labels/", qos: 1, retain: true, publish: func(ctx context.Context, topic string, payload []byte) error {
+		*sent = append(*sent, mqttMessage{topic: topic, payload: payload})
+		return err
+	}}
+}
+
+func TestMQTTDelivery(t *testing.T) {
+	var sent []mqttMessage
+	publisher := newTestMQTTPublisher(&sent, nil)
+	tests := []struct {
+		name       string
+		query      string
+		publisher  *mqttPublisher
+		wantTopic  string
+		wantFormat string
+		wantErr    bool
+	}{
+		{name: "not requested", query: "text=x", publisher: publisher},
+		{name: "png", query: "deliver.mqtt=store-7/shelf-12", publisher: publisher, wantTopic: "labels/store-7/shelf-12", wantFormat: mqttFormatPNG},
+		{name: "matrix", query: "deliver.mqtt=shelf-12&deliver.mqtt_format=matrix", publisher: publisher, wantTopic: "labels/shelf-12", wantFormat: mqttFormatMatrix},
+		{name: "not configured", query: "deliver.mqtt=shelf-12", wantErr: true},
+		{name: "wildcard", query: "deliver.mqtt=store-7/%23", publisher: publisher, wantErr: true},
+		{name: "escapes prefix", query: "deliver.mqtt=../other", publisher: publisher, wantErr: true},
+		{name: "invalid format", query: "deliver.mqtt=shelf-12&deliver.mqtt_format=svg", publisher: publisher, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, _ := url.ParseQuery(tt.query)
+			topic, format, apiErr := mqttDelivery(query, tt.publisher)
+			if (apiErr != nil) != tt.wantErr {
+				t.Fatalf("error = %v, want error %v", apiErr, tt.wantErr)
+			}
+			if topic != tt.wantTopic || format != tt.wantFormat {
+				t.Errorf("delivery = (%q, %q), want (%q, %q)", topic, format, tt.wantTopic, tt.wantFormat)
+			}
+		})
+	}
+}
+
+func TestQRImage_Matrix(t *testing.T) {
+	img, err := (&QRCodeGenerator{}).Encode(context.Background(), "hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := img.matrix()
+	if m.Size != 21 || len(m.Rows) != 21 {
+		t.Fatalf("matrix is %d with %d rows, want a version 1 symbol of 21", m.Size, len(m.Rows))
+	}
+	// Every symbol starts with a finder pattern in its top left corner
+	for y, want := range []string{"1111111", "1000001", "1011101", "1011101", "1011101", "1000001", "1111111"} {
+		if !strings.HasPrefix(m.Rows[y], want) {
+			t.Errorf("row %d = %s, want finder pattern %s", y, m.Rows[y], want)
+		}
+	}
+	for y, row := range m.Rows {
+		for x, module := range row {
+			if (module == '1') != img.bitmap[y+4][x+4] {
+				t.Fatalf("module (%d, %d) differs from the bitmap", x, y)
+			}
+		}
+	}
+}
+
+func TestGenerate_DeliverMQTT(t *testing.T) {
+	tests := []struct {
+		name       string
+		query      string
+		publishErr error
+		wantStatus int
+		wantMatrix bool
+	}{
+		{name: "png", query: "text=hello&deliver.mqtt=shelf-12", wantStatus: http.StatusOK},
+		{name: "matrix", query: "text=hello&deliver.mqtt=shelf-12&deliver.mqtt_format=matrix", wantStatus: http.StatusOK, wantMatrix: true},
+		{name: "broker failure", query: "text=hello&deliver.mqtt=shelf-12", publishErr: errors.New("not connected"), wantStatus: http.StatusBadGateway},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var sent []mqttMessage
+			deps := newTestDeps()
+			deps.mqtt = newTestMQTTPublisher(&sent, tt.publishErr)
+
+			rec := httptest.NewRecorder()
+			newHandler(deps).ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v1/qr/generate?"+tt.query, nil))
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if len(sent) != 1 || sent[0].topic != "labels/shelf-12" {
+				t.Fatalf("published %+v, want one message to labels/shelf-12", sent)
+			}
+			if tt.wantMatrix {
+				var m qrMatrix
+				if err := json.Unmarshal(sent[0].payload, &m); err != nil || m.Size != 21 {
+					t.Errorf("matrix payload = %s (%v)", sent[0].payload, err)
+				}
+			} else if !strings.HasPrefix(string(sent[0].payload), "\x89PNG") {
+				t.Error("payload is not a PNG")
+			}
+		})
+	}
+}
+
+func TestGenerate_DeliverEmailAndMQTT(t *testing.T) {
+	var emails []sentEmail
+	var messages []mqttMessage
+	deps := newTestDeps()
+	deps.email = newTestEmailSender(t, "", &emails)
+	deps.mqtt = newTestMQTTPublisher(&messages, nil)
+
+	rec := httptest.NewRecorder()
+	newHandler(deps).ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v1/qr/generate?text=hello&deliver.email=ann@example.com&deliver.mqtt=shelf-12", nil))
+	if rec.Code != http.StatusOK || len(emails) != 1 || len(messages) != 1 {
+		t.Fatalf("status %d with %d emails and %d messages, want 200 with one each", rec.Code, len(emails), len(messages))
+	}
+	var body struct {
+		Delivered map[string]string `json:"delivered"`
+	}
+	json.Unmarshal(rec.Body.Bytes(), &body)
+	if body.Delivered["email"] != "ann@example.com" || body.Delivered["mqtt"] != "labels/shelf-12" {
+		t.Errorf("delivered = %v", body.Delivered)
+	}
+}
+
+func TestNewMQTTPublisher(t *testing.T) {
+	tests := []struct {
+		name   string
+		url    string
+		prefix string
+		qos    int
+	}{
+		{name: "no host", url: "tcp://", prefix: "qr/", qos: 1},
+		{name: "invalid qos", url: "tcp://broker:1883", prefix: "qr/", qos: 3},
+		{name: "empty prefix", url: "tcp://broker:1883", qos: 1},
+		{name: "wildcard prefix", url: "tcp://broker:1883", prefix: "qr/#", qos: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := newMQTTPublisher(tt.url, "test", tt.prefix, tt.qos, true); err == nil {
+				t.Error("invalid configuration accepted")
+			}
+		})
+	}
+	if p, err := newMQTTPublisher("", "test", "qr/", 1, true); p != nil || err != nil {
+		t.Errorf("without broker = (%v, %v), want disabled", p, err)
+	}
+}
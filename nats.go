@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+	"strconv"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// natsReplyHeader selects what a NATS request is answered with: the PNG by
+// default, or with "url" the completion event of the image stored in
+// STORAGE_URL
+const natsReplyHeader = "Qr-Reply"
+
+// Error headers of NATS service replies, as used by the nats.go micro package
+const (
+	natsErrorHeader     = "Nats-Service-Error"
+	natsErrorCodeHeader = "Nats-Service-Error-Code"
+)
+
+// natsWorker answers generation requests on a NATS subject, so in-cluster
+// services can use request-reply instead of HTTP. All replicas subscribe in
+// one queue group, so each request is handled once. Messages published
+// without a reply subject are processed as jobs into the object store.
+type natsWorker struct {
+	conn      *nats.Conn
+	subject   string
+	queue     string
+	processor *jobProcessor
+	// timeout bounds the handling of one request; zero disables it
+	timeout time.Duration
+	closed  chan struct{}
+}
+
+// newNATSWorker connects to the NATS server at url. The connection reconnects
+// forever; requests sent while it is down time out on the client side.
+func newNATSWorker(url, subject, queue string, timeout time.Duration, processor *jobProcessor) (*natsWorker, error) {
+	worker := &natsWorker{subject: subject, queue: queue, processor: processor, timeout: timeout, closed: make(chan struct{})}
+	conn, err := nats.Connect(url,
+		nats.Name("qr-generator"),
+		nats.MaxReconnects(-1),
+		nats.ClosedHandler(func(*nats.Conn) { close(worker.closed) }),
+		nats.DisconnectErrHandler(func(_ *nats.Conn, err error) {
+			if err != nil {
+				slog.Warn("disconnected from NATS", "error", err)
+			}
+		}),
+		nats.ReconnectHandler(func(conn *nats.Conn) { slog.Info("reconnected to NATS", "url", conn.ConnectedUrlRedacted()) }),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("connect to NATS: %w", err)
+	}
+	worker.conn = conn
+	return worker, nil
+}
+
+// start subscribes with one subscription per render worker: each
+// subscription delivers its messages sequentially, so together they keep the
+// render pool busy without queueing more than it can take
+func (n *natsWorker) start() error {
+	for range n.processor.deps.renderPool.size() {
+		if _, err := n.conn.QueueSubscribe(n.subject, n.queue, n.handle); err != nil {
+			return fmt.Errorf("subscribe to %s: %w", n.subject, err)
+		}
+	}
+	return nil
+}
+
+// handle answers one request. It runs on a background context: on shutdown
+// the subscriptions are drained, so requests already received are answered.
+func (n *natsWorker) handle(msg *nats.Msg) {
+	ctx := context.Background()
+	if n.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, n.timeout)
+		defer cancel()
+	}
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			err := fmt.Errorf("panic: %v", recovered)
+			stack := debug.Stack()
+			slog.ErrorContext(ctx, "panic recovered in NATS handler", "error", err, "stack", string(stack))
+			n.processor.deps.reporter.Report(ctx, errorEvent{Err: err, Panic: true, Stack: stack})
+			n.respond(msg, jobResult{Status: jobFailed, Error: &apiError{Code: errCodeRenderFailed, Message: "Failed to generate QR code"}})
+		}
+	}()
+
+	if msg.Reply == "" || msg.Header.Get(natsReplyHeader) == "url" {
+		if n.processor.store == nil {
+			n.respond(msg, n.processor.finish(ctx, jobResult{Status: jobFailed, Error: &apiError{
+				Code:    errCodeInvalidParam,
+				Message: "Storing images requires STORAGE_URL to be configured",
+			}}))
+			return
+		}
+		result, err := n.processor.process(ctx, msg.Data)
+		if err != nil {
+			result = jobResult{Status: jobFailed, Error: &apiError{Code: errCodeRenderFailed, Message: "Timed out generating the QR code"}}
+		}
+		n.respond(msg, result)
+		return
+	}
+
+	job, apiErr := decodeJob(msg.Data)
+	if apiErr == nil {
+		var pngBytes []byte
+		if pngBytes, apiErr = renderText(ctx, n.processor.deps, job.Text); apiErr == nil {
+			n.processor.finish(ctx, jobResult{ID: job.ID, Status: jobSucceeded})
+			reply := nats.NewMsg(msg.Reply)
+			reply.Header.Set("Content-Type", "image/png")
+			reply.Data = pngBytes
+			if err := msg.RespondMsg(reply); err != nil {
+				slog.WarnContext(ctx, "failed to reply to NATS request", "error", err)
+			}
+			return
+		}
+	}
+	n.respond(msg, n.processor.finish(ctx, jobResult{ID: job.ID, Status: jobFailed, Error: apiErr}))
+}
+
+// respond replies with a completion event as JSON. Failures also carry the
+// NATS service error headers with an HTTP-like status code.
+func (n *natsWorker) respond(msg *nats.Msg, result jobResult) {
+	if msg.Reply == "" {
+		return
+	}
+	reply := nats.NewMsg(msg.Reply)
+	reply.Header.Set("Content-Type", "application/json")
+	if result.Error != nil {
+		reply.Header.Set(natsErrorHeader, result.Error.Message)
+		reply.Header.Set(natsErrorCodeHeader, strconv.Itoa(jobErrorStatus(result.Error)))
+	}
+	reply.Data, _ = json.Marshal(result)
+	if err := msg.RespondMsg(reply); err != nil && !errors.Is(err, nats.ErrConnectionClosed) {
+		slog.Warn("failed to reply to NATS request", "error", err)
+	}
+}
+
+// jobErrorStatus maps a job error to the HTTP status the API would answer with
+func jobErrorStatus(err *apiError) int {
+	switch err.Code {
+	case errCodePayloadTooLarge:
+		return http.StatusRequestEntityTooLarge
+	case errCodePolicyViolation:
+		return http.StatusUnprocessableEntity
+	case errCodeServerBusy:
+		return http.StatusServiceUnavailable
+	case errCodeRenderFailed:
+		return http.StatusInternalServerError
+	}
+	return http.StatusBadRequest
+}
+
+// check reports whether the connection is up, for the health endpoint
+func (n *natsWorker) check(ctx context.Context) error {
+	if status := n.conn.Status(); status != nats.CONNECTED {
+		return fmt.Errorf("NATS connection is %s", status)
+	}
+	return nil
+}
+
+// Close drains the subscriptions, answering the requests already received,
+// then closes the connection. It gives up when ctx is done.
+func (n *natsWorker) Close(ctx context.Context) error {
+	if err := n.conn.Drain(); err != nil {
+		n.conn.Close()
+		return err
+	}
+	select {
+	case <-n.closed:
+		return nil
+	case <-ctx.Done():
+		n.conn.Close()
+		return ctx.Err()
+	}
+}
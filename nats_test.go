@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+)
+
+// startNATSServer runs an embedded NATS server for the test
+func startNATSServer(t *testing.T) string {
+	t.Helper()
+	srv, err := server.NewServer(&server.Options{Host: "127.0.0.1", Port: -1, NoLog: true, NoSigs: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Start()
+	if !srv.ReadyForConnections(5 * time.Second) {
+		t.Fatal("NATS server not ready")
+	}
+	t.Cleanup(srv.Shutdown)
+	return srv.ClientURL()
+}
+
+func TestNATSWorker_Request(t *testing.T) {
+	url := startNATSServer(t)
+
+	tests := []struct {
+		name       string
+		body       string
+		reply      string
+		noStore    bool
+		wantType   string
+		wantStatus string // of a JSON reply
+		wantError  string // Nats-Service-Error-Code
+	}{
+		{name: "image", body: `{"text": "https://example.com"}`, wantType: "image/png"},
+		{name: "stored url", body: `{"id": "a", "text": "hello"}`, reply: "url", wantType: "application/json", wantStatus: jobSucceeded},
+		{name: "invalid job", body: `{"text": ""}`, wantType: "application/json", wantStatus: jobFailed, wantError: "400"},
+		{name: "policy violation", body: `{"text": "javascript:alert(1)"}`, wantType: "application/json", wantStatus: jobFailed, wantError: "422"},
+		{name: "url without storage", body: `{"text": "hello"}`, reply: "url", noStore: true, wantType: "application/json", wantStatus: jobFailed, wantError: "400"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var store objectStore = &memoryStore{}
+			if tt.noStore {
+				store = nil
+			}
+			subject := "qr.test." + strings.ReplaceAll(tt.name, " ", "-")
+			worker, err := newNATSWorker(url, subject, "qr-generator", time.Second, newTestJobProcessor(store))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer worker.Close(context.Background())
+			if err := worker.start(); err != nil {
+				t.Fatal(err)
+			}
+
+			client, err := nats.Connect(url)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer client.Close()
+			req := nats.NewMsg(subject)
+			req.Data = []byte(tt.body)
+			if tt.reply != "" {
+				req.Header.Set(natsReplyHeader, tt.reply)
+			}
+			reply, err := client.RequestMsg(req, 5*time.Second)
+			if err != nil {
+				t.Fatalf("request: %v", err)
+			}
+
+			if got := reply.Header.Get("Content-Type"); got != tt.wantType {
+				t.Fatalf("Content-Type = %q, want %q", got, tt.wantType)
+			}
+			if got := reply.Header.Get(natsErrorCodeHeader); got != tt.wantError {
+				t.Errorf("%s = %q, want %q", natsErrorCodeHeader, got, tt.wantError)
+			}
+			if tt.wantType == "image/png" {
+				if !strings.HasPrefix(string(reply.Data), "\x89PNG") {
+					t.Error("reply is not a PNG")
+				}
+				return
+			}
+			var result jobResult
+			if err := json.Unmarshal(reply.Data, &result); err != nil {
+				t.Fatal(err)
+			}
+			if result.Status != tt.wantStatus {
+				t.Errorf("status = %q, want %q (error %+v)", result.Status, tt.wantStatus, result.Error)
+			}
+			if tt.reply == "url" && result.Status == jobSucceeded && result.URL != "mem://a.png" {
+				t.Errorf("URL = %q, want %q", result.URL, "mem://a.png")
+			}
+		})
+	}
+}
+
+func TestNATSWorker_PublishedJob(t *testing.T) {
+	url := startNATSServer(t)
+	store := &memoryStore{}
+	worker, err := newNATSWorker(url, "qr.jobs", "qr-generator", time.Second, newTestJobProcessor(store))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := worker.start(); err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := nats.Connect(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if err := client.Publish("qr.jobs", []byte(`{"id": "label-1", "text": "hello"}`)); err != nil {
+		t.Fatal(err)
+	}
+	client.Flush()
+
+	// Draining answers the messages already received before closing
+	if err := worker.Close(context.Background()); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	if len(store.objects["label-1.png"]) == 0 {
+		t.Error("published job was not stored")
+	}
+}
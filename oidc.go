@@ -0,0 +1,408 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// sessionCookie holds the signed SSO session
+	sessionCookie = "qr_session"
+	// oidcStateCookie holds the signed state of an in-progress login
+	oidcStateCookie = "qr_oidc_state"
+	// oidcStateTTL bounds how long a login may take at the identity provider
+	oidcStateTTL = 10 * time.Minute
+)
+
+// Roles granted to SSO sessions
+const (
+	roleAdmin  = "admin"
+	roleViewer = "viewer"
+)
+
+// oidcConfig configures OIDC login (authorization code flow with PKCE)
+type oidcConfig struct {
+	IssuerURL    string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	// GroupsClaim is the ID token claim listing the user's groups
+	GroupsClaim string
+	// AdminGroups are the groups mapped to the admin role
+	AdminGroups []string
+	// SessionSecret signs session cookies; sessions only survive restarts and
+	// work across replicas when it is set
+	SessionSecret string
+	SessionTTL    time.Duration
+}
+
+// session is the identity of a user logged in through OIDC
+type session struct {
+	Subject string   `json:"sub"`
+	Email   string   `json:"email,omitempty"`
+	Name    string   `json:"name,omitempty"`
+	Groups  []string `json:"groups,omitempty"`
+	Roles   []string `json:"roles"`
+	Expires int64    `json:"exp"`
+}
+
+// HasRole reports whether the session was granted role
+func (s session) HasRole(role string) bool {
+	return slices.Contains(s.Roles, role)
+}
+
+// oidcLoginState is stored in a cookie between the login redirect and the callback
+type oidcLoginState struct {
+	State    string `json:"state"`
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"`
+	ReturnTo string `json:"return_to"`
+	Expires  int64  `json:"exp"`
+}
+
+// oidcProvider is the subset of the OpenID provider metadata used for login
+type oidcProvider struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// oidcAuth implements SSO login for the web UI and admin endpoints
+type oidcAuth struct {
+	cfg    oidcConfig
+	secret []byte
+	client *http.Client
+
+	mu       sync.Mutex
+	provider *oidcProvider
+	verifier *jwtVerifier
+}
+
+// newOIDCAuth creates the OIDC login handler. Provider metadata is discovered
+// lazily on the first login so an unreachable identity provider doesn't block startup.
+func newOIDCAuth(cfg oidcConfig) (*oidcAuth, error) {
+	if cfg.IssuerURL == "" || cfg.ClientID == "" || cfg.RedirectURL == "" {
+		return nil, errors.New("OIDC requires an issuer URL, client ID, and redirect URL")
+	}
+
+	secret := []byte(cfg.SessionSecret)
+	if len(secret) == 0 {
+		secret = make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, fmt.Errorf("failed to generate session secret: %w", err)
+		}
+		slog.Warn("OIDC_SESSION_SECRET not set, sessions are only valid on this replica until it restarts")
+	}
+
+	return &oidcAuth{cfg: cfg, secret: secret, client: newOutboundClient(10 * time.Second)}, nil
+}
+
+// discover fetches (once) the provider metadata and sets up ID token verification
+func (o *oidcAuth) discover(ctx context.Context) (*oidcProvider, *jwtVerifier, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.provider != nil {
+		return o.provider, o.verifier, nil
+	}
+
+	wellKnown := strings.TrimSuffix(o.cfg.IssuerURL, "/") + "/.well-known/openid-configuration"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, wellKnown, nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to build discovery request: %w", err)
+	}
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to fetch OIDC discovery document: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("failed to fetch OIDC discovery document: status %d", resp.StatusCode)
+	}
+
+	var provider oidcProvider
+	if err := json.NewDecoder(resp.Body).Decode(&provider); err != nil {
+		return nil, nil, fmt.Errorf("failed to decode OIDC discovery document: %w", err)
+	}
+	if provider.AuthorizationEndpoint == "" || provider.TokenEndpoint == "" || provider.JWKSURI == "" {
+		return nil, nil, errors.New("OIDC discovery document is missing endpoints")
+	}
+
+	o.provider = &provider
+	o.verifier = newJWTVerifier(provider.JWKSURI, provider.Issuer, o.cfg.ClientID, "")
+	return o.provider, o.verifier, nil
+}
+
+// handleLogin redirects the browser to the identity provider
+func (o *oidcAuth) handleLogin(w http.ResponseWriter, r *http.Request) {
+	provider, _, err := o.discover(r.Context())
+	if err != nil {
+		slog.ErrorContext(r.Context(), "OIDC discovery failed", "error", err)
+		http.Error(w, "Identity provider unavailable", http.StatusBadGateway)
+		return
+	}
+
+	state := oidcLoginState{
+		State:    randomToken(),
+		Nonce:    randomToken(),
+		Verifier: randomToken() + randomToken(),
+		ReturnTo: safeReturnTo(r.URL.Query().Get("return_to")),
+		Expires:  time.Now().Add(oidcStateTTL).Unix(),
+	}
+	o.setSignedCookie(w, oidcStateCookie, "/auth/", state, oidcStateTTL)
+
+	challenge := sha256.Sum256([]byte(state.Verifier))
+	params := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {o.cfg.ClientID},
+		"redirect_uri":          {o.cfg.RedirectURL},
+		"scope":                 {"openid profile email"},
+		"state":                 {state.State},
+		"nonce":                 {state.Nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+	sep := "?"
+	if strings.Contains(provider.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	http.Redirect(w, r, provider.AuthorizationEndpoint+sep+params.Encode(), http.StatusFound)
+}
+
+// handleCallback completes the login: it exchanges the code, validates the ID
+// token, maps groups to roles, and sets the session cookie
+func (o *oidcAuth) handleCallback(w http.ResponseWriter, r *http.Request) {
+	var state oidcLoginState
+	if !o.readSignedCookie(r, oidcStateCookie, &state) || state.Expires < time.Now().Unix() {
+		http.Error(w, "Login expired, please try again", http.StatusBadRequest)
+		return
+	}
+	o.clearCookie(w, oidcStateCookie, "/auth/")
+
+	query := r.URL.Query()
+	if errCode := query.Get("error"); errCode != "" {
+		slog.WarnContext(r.Context(), "OIDC login failed", "error", errCode, "description", query.Get("error_description"))
+		http.Error(w, "Login failed: "+errCode, http.StatusUnauthorized)
+		return
+	}
+	if !hmac.Equal([]byte(query.Get("state")), []byte(state.State)) || query.Get("code") == "" {
+		http.Error(w, "Invalid login state", http.StatusBadRequest)
+		return
+	}
+
+	sess, err := o.exchange(r.Context(), query.Get("code"), state)
+	if err != nil {
+		slog.WarnContext(r.Context(), "OIDC code exchange failed", "error", err)
+		http.Error(w, "Login failed", http.StatusUnauthorized)
+		return
+	}
+
+	o.setSignedCookie(w, sessionCookie, "/", sess, o.cfg.SessionTTL)
+	slog.InfoContext(r.Context(), "user logged in", "subject", sess.Subject, "email", sess.Email, "roles", sess.Roles)
+	http.Redirect(w, r, state.ReturnTo, http.StatusFound)
+}
+
+// exchange redeems the authorization code and builds a session from the ID token
+func (o *oidcAuth) exchange(ctx context.Context, code string, state oidcLoginState) (session, error) {
+	provider, verifier, err := o.discover(ctx)
+	if err != nil {
+		return session{}, err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {o.cfg.RedirectURL},
+		"client_id":     {o.cfg.ClientID},
+		"code_verifier": {state.Verifier},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, provider.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return session{}, fmt.Errorf("failed to build token request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if o.cfg.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(o.cfg.ClientID), url.QueryEscape(o.cfg.ClientSecret))
+	}
+
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return session{}, fmt.Errorf("token request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return session{}, fmt.Errorf("token request failed: status %d", resp.StatusCode)
+	}
+	var tokens struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tokens); err != nil || tokens.IDToken == "" {
+		return session{}, errors.New("token response has no id_token")
+	}
+
+	claims, err := verifier.verifyClaims(ctx, tokens.IDToken)
+	if err != nil {
+		return session{}, fmt.Errorf("invalid ID token: %w", err)
+	}
+	if nonce, _ := claims["nonce"].(string); !hmac.Equal([]byte(nonce), []byte(state.Nonce)) {
+		return session{}, errors.New("ID token nonce mismatch")
+	}
+
+	sess := session{Groups: stringsClaim(claims[o.cfg.GroupsClaim]), Expires: time.Now().Add(o.cfg.SessionTTL).Unix()}
+	sess.Subject, _ = claims["sub"].(string)
+	sess.Email, _ = claims["email"].(string)
+	sess.Name, _ = claims["name"].(string)
+	sess.Roles = o.rolesFor(sess.Groups)
+	return sess, nil
+}
+
+// rolesFor maps the user's groups to roles
+func (o *oidcAuth) rolesFor(groups []string) []string {
+	for _, group := range groups {
+		if slices.Contains(o.cfg.AdminGroups, group) {
+			return []string{roleAdmin, roleViewer}
+		}
+	}
+	return []string{roleViewer}
+}
+
+// handleLogout clears the session cookie
+func (o *oidcAuth) handleLogout(w http.ResponseWriter, r *http.Request) {
+	o.clearCookie(w, sessionCookie, "/")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleMe returns the current session as JSON, or 401 when not logged in
+func (o *oidcAuth) handleMe(w http.ResponseWriter, r *http.Request) {
+	sess, ok := o.Session(r)
+	if !ok {
+		http.Error(w, "Not logged in", http.StatusUnauthorized)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(sess)
+}
+
+// Session returns the valid, unexpired session of the request, if any
+func (o *oidcAuth) Session(r *http.Request) (session, bool) {
+	var sess session
+	if !o.readSignedCookie(r, sessionCookie, &sess) || sess.Expires < time.Now().Unix() {
+		return session{}, false
+	}
+	return sess, true
+}
+
+// requireSession gates browser pages such as the web UI behind SSO. Requests
+// without a valid session are redirected to the login, which returns to the
+// page, except WebSocket handshakes, which can't follow a redirect and get
+// 401. Any role passes. A nil oidcAuth lets every request through.
+func (o *oidcAuth) requireSession(next http.HandlerFunc) http.HandlerFunc {
+	if o == nil {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		sess, ok := o.Session(r)
+		if !ok {
+			if websocket.IsWebSocketUpgrade(r) {
+				http.Error(w, "Not logged in", http.StatusUnauthorized)
+				return
+			}
+			prefix := basePathFromContext(r.Context())
+			http.Redirect(w, r, prefix+"/auth/login?return_to="+url.QueryEscape(prefix+r.URL.Path), http.StatusFound)
+			return
+		}
+		addLogAttrs(r.Context(), slog.String("subject", sess.Subject))
+		next(w, r.WithContext(withPrincipal(r.Context(), principal{Subject: sess.Subject, Method: authMethodOIDC})))
+	}
+}
+
+// setSignedCookie stores value as an HMAC-signed cookie
+func (o *oidcAuth) setSignedCookie(w http.ResponseWriter, name, path string, value any, ttl time.Duration) {
+	payload, _ := json.Marshal(value)
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	http.SetCookie(w, &http.Cookie{
+		Name:     name,
+		Value:    encoded + "." + o.sign(encoded),
+		Path:     path,
+		MaxAge:   int(ttl.Seconds()),
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(o.cfg.RedirectURL, "https://"),
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// readSignedCookie decodes a cookie written by setSignedCookie, verifying its signature
+func (o *oidcAuth) readSignedCookie(r *http.Request, name string, value any) bool {
+	cookie, err := r.Cookie(name)
+	if err != nil {
+		return false
+	}
+	encoded, sig, ok := strings.Cut(cookie.Value, ".")
+	if !ok || !hmac.Equal([]byte(sig), []byte(o.sign(encoded))) {
+		return false
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return false
+	}
+	return json.Unmarshal(payload, value) == nil
+}
+
+// clearCookie expires a cookie
+func (o *oidcAuth) clearCookie(w http.ResponseWriter, name, path string) {
+	http.SetCookie(w, &http.Cookie{Name: name, Value: "", Path: path, MaxAge: -1, HttpOnly: true})
+}
+
+// sign returns the base64url HMAC-SHA256 of value
+func (o *oidcAuth) sign(value string) string {
+	mac := hmac.New(sha256.New, o.secret)
+	mac.Write([]byte(value))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// randomToken returns 32 random bytes, base64url-encoded
+func randomToken() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// safeReturnTo only allows local absolute paths as post-login redirect targets
+func safeReturnTo(path string) string {
+	if !strings.HasPrefix(path, "/") || strings.HasPrefix(path, "//") || strings.HasPrefix(path, "/\\") {
+		return "/auth/me"
+	}
+	return path
+}
+
+// stringsClaim converts a string or string-array claim into a slice
+func stringsClaim(value any) []string {
+	switch v := value.(type) {
+	case string:
+		return []string{v}
+	case []any:
+		out := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	}
+	return nil
+}
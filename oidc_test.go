@@ -0,0 +1,281 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/gorilla/websocket"
+)
+
+// newTestProvider serves OIDC discovery and a token endpoint issuing ID tokens
+// with the given groups, signed by a testIssuer key
+func newTestProvider(t *testing.T, groups []string) *httptest.Server {
+	t.Helper()
+	issuer := newTestIssuer(t)
+	var provider *httptest.Server
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(oidcProvider{
+			Issuer:                provider.URL,
+			AuthorizationEndpoint: provider.URL + "/authorize",
+			TokenEndpoint:         provider.URL + "/token",
+			JWKSURI:               issuer.server.URL,
+		})
+	})
+	// The authorization code is "<nonce>.<code_challenge>" so the token endpoint can check PKCE
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if id, secret, _ := r.BasicAuth(); id != "qr-ui" || secret != "client-secret" {
+			http.Error(w, "invalid_client", http.StatusUnauthorized)
+			return
+		}
+		nonce, challenge, _ := strings.Cut(r.PostFormValue("code"), ".")
+		sum := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+		if base64.RawURLEncoding.EncodeToString(sum[:]) != challenge {
+			http.Error(w, "invalid_grant", http.StatusBadRequest)
+			return
+		}
+		idToken := issuer.sign(t, jwt.SigningMethodRS256, "rsa-1", jwt.MapClaims{
+			"iss":    provider.URL,
+			"aud":    "qr-ui",
+			"sub":    "user-123",
+			"email":  "user@example.com",
+			"exp":    time.Now().Add(time.Hour).Unix(),
+			"nonce":  nonce,
+			"groups": groups,
+		})
+		json.NewEncoder(w).Encode(map[string]string{"id_token": idToken})
+	})
+	provider = httptest.NewServer(mux)
+	t.Cleanup(provider.Close)
+	return provider
+}
+
+// login runs the authorization code flow against the admin handler and returns its cookies
+func login(t *testing.T, admin http.Handler) []*http.Cookie {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	admin.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/auth/login?return_to=/flags", nil))
+	if rec.Code != http.StatusFound {
+		t.Fatalf("login status = %d, want %d", rec.Code, http.StatusFound)
+	}
+	location, err := url.Parse(rec.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	params := location.Query()
+	if params.Get("code_challenge_method") != "S256" || params.Get("client_id") != "qr-ui" {
+		t.Fatalf("authorization request params = %v", params)
+	}
+
+	callback := "/auth/callback?" + url.Values{
+		"state": {params.Get("state")},
+		"code":  {params.Get("nonce") + "." + params.Get("code_challenge")},
+	}.Encode()
+	req := httptest.NewRequest(http.MethodGet, callback, nil)
+	for _, c := range rec.Result().Cookies() {
+		req.AddCookie(c)
+	}
+	rec = httptest.NewRecorder()
+	admin.ServeHTTP(rec, req)
+	if rec.Code != http.StatusFound || rec.Header().Get("Location") != "/flags" {
+		t.Fatalf("callback = %d -> %q, want 302 -> /flags (%s)", rec.Code, rec.Header().Get("Location"), rec.Body.String())
+	}
+	return rec.Result().Cookies()
+}
+
+func TestOIDCLogin_RoleMapping(t *testing.T) {
+	tests := []struct {
+		name     string
+		groups   []string
+		wantCode int
+	}{
+		{name: "admin group", groups: []string{"devs", "qr-admins"}, wantCode: http.StatusOK},
+		{name: "other group", groups: []string{"devs"}, wantCode: http.StatusForbidden},
+		{name: "no groups", groups: nil, wantCode: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := newTestProvider(t, tt.groups)
+			sso, err := newOIDCAuth(oidcConfig{
+				IssuerURL:     provider.URL,
+				ClientID:      "qr-ui",
+				ClientSecret:  "client-secret",
+				RedirectURL:   "http://localhost:6060/auth/callback",
+				GroupsClaim:   "groups",
+				AdminGroups:   []string{"qr-admins"},
+				SessionSecret: "session-secret",
+				SessionTTL:    time.Hour,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			deps := newTestDeps()
+			deps.adminToken = ""
+			deps.oidc = sso
+			admin := newAdminHandler(deps)
+
+			cookies := login(t, admin)
+
+			req := httptest.NewRequest(http.MethodGet, "/auth/me", nil)
+			for _, c := range cookies {
+				req.AddCookie(c)
+			}
+			rec := httptest.NewRecorder()
+			admin.ServeHTTP(rec, req)
+			var me session
+			if err := json.NewDecoder(rec.Body).Decode(&me); err != nil || me.Subject != "user-123" || me.Email != "user@example.com" {
+				t.Fatalf("/auth/me = %d %+v (err %v)", rec.Code, me, err)
+			}
+
+			req = httptest.NewRequest(http.MethodPut, "/admin/loglevel", strings.NewReader(`{"level": "debug"}`))
+			for _, c := range cookies {
+				req.AddCookie(c)
+			}
+			rec = httptest.NewRecorder()
+			admin.ServeHTTP(rec, req)
+			if rec.Code != tt.wantCode {
+				t.Errorf("PUT /admin/loglevel with session status = %d, want %d", rec.Code, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestOIDC_UIRequiresSession(t *testing.T) {
+	provider := newTestProvider(t, []string{"devs"})
+	sso, err := newOIDCAuth(oidcConfig{
+		IssuerURL:     provider.URL,
+		ClientID:      "qr-ui",
+		ClientSecret:  "client-secret",
+		RedirectURL:   "http://localhost:8080/auth/callback",
+		GroupsClaim:   "groups",
+		SessionSecret: "session-secret",
+		SessionTTL:    time.Hour,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	deps := newTestDeps()
+	deps.flags.Set(flagUI, true)
+	deps.oidc = sso
+	handler := newHandler(deps)
+	server := httptest.NewServer(handler)
+	defer server.Close()
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + previewPath
+	dialer := websocket.Dialer{Subprotocols: []string{previewSubprotocol}}
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/ui/", nil))
+	if want := "/auth/login?return_to=%2Fui%2F"; rec.Code != http.StatusFound || rec.Header().Get("Location") != want {
+		t.Errorf("GET /ui/ without session = %d -> %q, want 302 -> %q", rec.Code, rec.Header().Get("Location"), want)
+	}
+	if _, resp, err := dialer.Dial(wsURL, nil); err == nil || resp == nil || resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("preview handshake without session: response %v, error %v; want 401", resp, err)
+	}
+
+	// The login is served on the public listener, and its session opens the page
+	cookies := login(t, handler)
+	req := httptest.NewRequest(http.MethodGet, "/ui/", nil)
+	header := http.Header{}
+	for _, c := range cookies {
+		req.AddCookie(c)
+		header.Add("Cookie", c.Name+"="+c.Value)
+	}
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Errorf("GET /ui/ with session status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	conn, resp, err := dialer.Dial(wsURL, header)
+	if err != nil {
+		t.Fatalf("preview handshake with session: response %v, error %v", resp, err)
+	}
+	conn.Close()
+}
+
+func TestOIDCCallback_RejectsInvalidState(t *testing.T) {
+	sso, err := newOIDCAuth(oidcConfig{IssuerURL: "http://idp.invalid", ClientID: "qr-ui", RedirectURL: "http://localhost/auth/callback", SessionTTL: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	state := oidcLoginState{State: "expected", Expires: time.Now().Add(time.Minute).Unix()}
+
+	tests := []struct {
+		name   string
+		cookie bool
+		query  string
+	}{
+		{name: "no state cookie", cookie: false, query: "state=expected&code=abc"},
+		{name: "state mismatch", cookie: true, query: "state=other&code=abc"},
+		{name: "missing code", cookie: true, query: "state=expected"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/auth/callback?"+tt.query, nil)
+			if tt.cookie {
+				set := httptest.NewRecorder()
+				sso.setSignedCookie(set, oidcStateCookie, "/auth/", state, time.Minute)
+				req.AddCookie(set.Result().Cookies()[0])
+			}
+			rec := httptest.NewRecorder()
+			sso.handleCallback(rec, req)
+
+			if rec.Code != http.StatusBadRequest {
+				t.Errorf("status = %d, want %d", rec.Code, http.StatusBadRequest)
+			}
+		})
+	}
+}
+
+func TestOIDCSession_RejectsTamperedCookie(t *testing.T) {
+	sso, err := newOIDCAuth(oidcConfig{IssuerURL: "http://idp.invalid", ClientID: "qr-ui", RedirectURL: "http://localhost/auth/callback", SessionSecret: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := httptest.NewRecorder()
+	sso.setSignedCookie(rec, sessionCookie, "/", session{Subject: "user", Roles: []string{roleViewer}, Expires: time.Now().Add(time.Hour).Unix()}, time.Hour)
+	cookie := rec.Result().Cookies()[0]
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.AddCookie(cookie)
+	if _, ok := sso.Session(req); !ok {
+		t.Fatal("Session() rejected a valid cookie")
+	}
+
+	forged, _ := json.Marshal(session{Subject: "user", Roles: []string{roleAdmin}, Expires: time.Now().Add(time.Hour).Unix()})
+	_, sig, _ := strings.Cut(cookie.Value, ".")
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	req.AddCookie(&http.Cookie{Name: sessionCookie, Value: base64.RawURLEncoding.EncodeToString(forged) + "." + sig})
+	if _, ok := sso.Session(req); ok {
+		t.Error("Session() accepted a cookie with a forged payload")
+	}
+}
+
+func TestSafeReturnTo(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{in: "/flags", want: "/flags"},
+		{in: "", want: "/auth/me"},
+		{in: "https://evil.example", want: "/auth/me"},
+		{in: "//evil.example", want: "/auth/me"},
+		{in: "/\\evil.example", want: "/auth/me"},
+	}
+
+	for _, tt := range tests {
+		if got := safeReturnTo(tt.in); got != tt.want {
+			t.Errorf("safeReturnTo(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"unicode/utf8"
+)
+
+// errPayloadTooLarge is returned when content does not fit in a QR code
+var errPayloadTooLarge = errors.New("content exceeds QR code capacity")
+
+// errTextNotInMode is returned when content has characters its encoding
+// mode lacks
+var errTextNotInMode = errors.New("content can't be encoded in the mode")
+
+// errTextNotInCharset is returned when content has characters its
+// character set lacks
+var errTextNotInCharset = errors.New("content can't be encoded in the character set")
+
+// validatePayload checks text before it reaches the encoder. Length is counted
+// in characters (not bytes) so multi-byte text is limited the way users see it;
+// the byte-level QR capacity is enforced by the encoder itself.
+func validatePayload(text string, maxLength int) (int, *apiError) {
+	if !utf8.ValidString(text) {
+		return http.StatusBadRequest, &apiError{
+			Code:    errCodeInvalidPayload,
+			Message: "Parameter 'text' must be valid UTF-8",
+		}
+	}
+
+	if length := utf8.RuneCountInString(text); maxLength > 0 && length > maxLength {
+		return http.StatusRequestEntityTooLarge, apiErrorf(errCodePayloadTooLarge,
+			map[string]any{"length": length, "max_length": maxLength, "bytes": len(text)},
+			"Parameter 'text' is %d characters long, the maximum is %d", length, maxLength)
+	}
+	return 0, nil
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestValidatePayload(t *testing.T) {
+	tests := []struct {
+		name       string
+		text       string
+		maxLength  int
+		wantStatus int
+		wantCode   string
+	}{
+		{name: "ascii within limit", text: "hello", maxLength: 5},
+		{name: "ascii over limit", text: "hello!", maxLength: 5, wantStatus: http.StatusRequestEntityTooLarge, wantCode: errCodePayloadTooLarge},
+		{name: "multi-byte counted as characters", text: "世界世界世", maxLength: 5},
+		{name: "multi-byte over limit", text: "世界世界世界", maxLength: 5, wantStatus: http.StatusRequestEntityTooLarge, wantCode: errCodePayloadTooLarge},
+		{name: "emoji within limit", text: "👋🌍", maxLength: 2},
+		{name: "invalid utf-8", text: "bad\xffbyte", maxLength: 100, wantStatus: http.StatusBadRequest, wantCode: errCodeInvalidPayload},
+		{name: "no limit", text: strings.Repeat("a", 10000), maxLength: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, apiErr := validatePayload(tt.text, tt.maxLength)
+			if tt.wantCode == "" {
+				if apiErr != nil {
+					t.Errorf("validatePayload() = %d %+v, want no error", status, apiErr)
+				}
+				return
+			}
+			if apiErr == nil || status != tt.wantStatus || apiErr.Code != tt.wantCode {
+				t.Errorf("validatePayload() = %d %+v, want %d %s", status, apiErr, tt.wantStatus, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestGenerate_PayloadLimits(t *testing.T) {
+	deps := newTestDeps()
+	deps.maxPayloadLength = 4000
+	handler := newHandler(deps)
+
+	tests := []struct {
+		name       string
+		text       string
+		wantStatus int
+		wantCode   string
+	}{
+		{name: "fits", text: strings.Repeat("a", 100), wantStatus: http.StatusOK},
+		{name: "over configured limit", text: strings.Repeat("a", 4001), wantStatus: http.StatusRequestEntityTooLarge, wantCode: errCodePayloadTooLarge},
+		{name: "over QR capacity", text: strings.Repeat("世", 1000), wantStatus: http.StatusRequestEntityTooLarge, wantCode: errCodePayloadTooLarge},
+		{name: "invalid utf-8", text: "bad\xffbyte", wantStatus: http.StatusBadRequest, wantCode: errCodeInvalidPayload},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/qr/generate?text="+url.QueryEscape(tt.text), nil)
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (%s)", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if tt.wantCode == "" {
+				return
+			}
+			var body struct {
+				Error apiError `json:"error"`
+			}
+			if err := json.NewDecoder(rec.Body).Decode(&body); err != nil || body.Error.Code != tt.wantCode {
+				t.Errorf("error body code = %q (err %v), want %q", body.Error.Code, err, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestQRCodeGenerator_PayloadTooLarge(t *testing.T) {
+	_, err := (&QRCodeGenerator{}).GenerateQRCodeBytes(context.Background(), strings.Repeat("x", 3000))
+	if !errors.Is(err, errPayloadTooLarge) {
+		t.Errorf("GenerateQRCodeBytes() error = %v, want %v", err, errPayloadTooLarge)
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"sync"
+)
+
+// PayloadBuilder builds the payload of a custom QR code type from request
+// parameters, such as an internal asset URN from an asset ID. Organizations
+// compile builders into the service by adding a file that registers them
+// from an init function; each registered type is served as a first-class
+// endpoint at POST /api/v1/qr/{type}.
+type PayloadBuilder interface {
+	// Type is the endpoint's path segment, such as "asset"
+	Type() string
+	// Build returns the text to encode from the query and form parameters.
+	// Errors are returned to the client as invalid parameters, so their
+	// messages should say what to fix.
+	Build(ctx context.Context, params url.Values) (string, error)
+}
+
+// validPayloadType matches the names of payload types
+var validPayloadType = regexp.MustCompile(`^[a-z][a-z0-9-]{0,31}$`)
+
+// reservedPayloadTypes are path segments of built-in endpoints under
+// /api/v1/qr/
+var reservedPayloadTypes = []string{"batch", "capacity", "compare", "decode", "generate", "info", "verify"}
+
+var (
+	payloadBuildersMu sync.Mutex
+	payloadBuilders   = map[string]PayloadBuilder{}
+)
+
+// RegisterPayloadBuilder makes a payload type available. It panics when the
+// type name is invalid, reserved, or already registered, so mistakes fail at
+// startup.
+func RegisterPayloadBuilder(b PayloadBuilder) {
+	name := b.Type()
+	if !validPayloadType.MatchString(name) {
+		panic(fmt.Sprintf("invalid payload type %q: want lowercase letters, digits, and dashes", name))
+	}
+	if slices.Contains(reservedPayloadTypes, name) {
+		panic(fmt.Sprintf("payload type %q is reserved by a built-in endpoint", name))
+	}
+	payloadBuildersMu.Lock()
+	defer payloadBuildersMu.Unlock()
+	if _, dup := payloadBuilders[name]; dup {
+		panic(fmt.Sprintf("payload type %q is registered twice", name))
+	}
+	payloadBuilders[name] = b
+}
+
+// registeredPayloadBuilders returns the registered builders sorted by type
+func registeredPayloadBuilders() []PayloadBuilder {
+	payloadBuildersMu.Lock()
+	defer payloadBuildersMu.Unlock()
+	builders := make([]PayloadBuilder, 0, len(payloadBuilders))
+	for _, b := range payloadBuilders {
+		builders = append(builders, b)
+	}
+	slices.SortFunc(builders, func(a, b PayloadBuilder) int {
+		if a.Type() < b.Type() {
+			return -1
+		}
+		return 1
+	})
+	return builders
+}
+
+// handlePayloadType renders the payload built by b from the query and form
+// parameters. Built payloads pass the same validation, sanitization, content
+// policy, and image cache as the generate endpoint.
+func handlePayloadType(deps handlerDeps, b PayloadBuilder) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if err := r.ParseForm(); err != nil {
+			writeAPIError(w, r, http.StatusBadRequest, apiError{Code: errCodeInvalidParam, Message: "Invalid form body"})
+			return
+		}
+		payload, err := b.Build(r.Context(), r.Form)
+		if err != nil {
+			writeAPIError(w, r, http.StatusBadRequest, apiError{
+				Code:    errCodeInvalidParam,
+				Message: err.Error(),
+				Details: map[string]any{"type": b.Type()},
+			})
+			return
+		}
+		disposition, apiErr := contentDisposition(r.Form, formatPNG)
+		if apiErr != nil {
+			writeAPIError(w, r, http.StatusBadRequest, *apiErr)
+			return
+		}
+		setAuditPayload(r.Context(), payload, map[string]string{"type": b.Type()})
+
+		pngBytes, apiErr := renderText(r.Context(), deps, payload)
+		if apiErr != nil {
+			writeAPIError(w, r, jobErrorStatus(apiErr), *apiErr)
+			return
+		}
+		deps.metrics.generatedTotal.Inc()
+		if disposition != "" {
+			w.Header().Set("Content-Disposition", disposition)
+		}
+		writePNG(w, r, pngBytes)
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// assetBuilder builds internal asset URNs, or returns its raw "payload"
+// parameter to exercise validation
+type assetBuilder struct {
+	name string
+}
+
+func (b assetBuilder) Type() string { return b.name }
+
+func (b assetBuilder) Build(ctx context.Context, params url.Values) (string, error) {
+	if raw := params.Get("payload"); raw != "" {
+		return raw, nil
+	}
+	id := params.Get("asset_id")
+	if !regexp.MustCompile(`^[A-Z]{2}-[0-9]+$`).MatchString(id) {
+		return "", errors.New("Parameter 'asset_id' must look like AB-123")
+	}
+	return "urn:example:asset:" + id, nil
+}
+
+// registerTestPayloadBuilder registers b for the duration of the test
+func registerTestPayloadBuilder(t *testing.T, b PayloadBuilder) {
+	t.Helper()
+	RegisterPayloadBuilder(b)
+	t.Cleanup(func() {
+		payloadBuildersMu.Lock()
+		delete(payloadBuilders, b.Type())
+		payloadBuildersMu.Unlock()
+	})
+}
+
+func TestPayloadTypeEndpoint(t *testing.T) {
+	registerTestPayloadBuilder(t, assetBuilder{name: "asset"})
+	tests := []struct {
+		name        string
+		method      string
+		query       string
+		form        string
+		wantStatus  int
+		wantCode    string
+		wantPayload string
+	}{
+		{name: "query", method: http.MethodPost, query: "asset_id=AB-123", wantStatus: http.StatusOK, wantPayload: "urn:example:asset:AB-123"},
+		{name: "form", method: http.MethodPost, form: "asset_id=CD-9", wantStatus: http.StatusOK, wantPayload: "urn:example:asset:CD-9"},
+		{name: "builder error", method: http.MethodPost, query: "asset_id=123", wantStatus: http.StatusBadRequest, wantCode: errCodeInvalidParam},
+		{name: "policy violation", method: http.MethodPost, query: "payload=javascript%3Aalert(1)", wantStatus: http.StatusUnprocessableEntity, wantCode: errCodePolicyViolation},
+		{name: "too long", method: http.MethodPost, query: "payload=" + strings.Repeat("x", 5000), wantStatus: http.StatusRequestEntityTooLarge, wantCode: errCodePayloadTooLarge},
+		{name: "GET", method: http.MethodGet, query: "asset_id=AB-123", wantStatus: http.StatusMethodNotAllowed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deps := newTestDeps()
+			deps.audit, _ = newAuditLog(10, "")
+			req := httptest.NewRequest(tt.method, "/api/v1/qr/asset?"+tt.query, strings.NewReader(tt.form))
+			if tt.form != "" {
+				req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			}
+			rec := httptest.NewRecorder()
+			newHandler(deps).ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if tt.wantCode != "" {
+				var resp struct {
+					Error apiError `json:"error"`
+				}
+				json.Unmarshal(rec.Body.Bytes(), &resp)
+				if resp.Error.Code != tt.wantCode {
+					t.Errorf("error code = %q, want %q", resp.Error.Code, tt.wantCode)
+				}
+			}
+			if rec.Code != http.StatusOK {
+				return
+			}
+			if rec.Header().Get("Content-Type") != "image/png" {
+				t.Errorf("content type = %q", rec.Header().Get("Content-Type"))
+			}
+			sum := sha256.Sum256([]byte(tt.wantPayload))
+			events := deps.audit.Query(auditFilter{})
+			if len(events) != 1 || events[0].Action != "qr.asset" || events[0].PayloadSHA256 != hex.EncodeToString(sum[:]) {
+				t.Errorf("audit events = %+v, want qr.asset of %q", events, tt.wantPayload)
+			}
+		})
+	}
+}
+
+func TestRegisterPayloadBuilder(t *testing.T) {
+	registerTestPayloadBuilder(t, assetBuilder{name: "ticket"})
+	for _, name := range []string{"Ticket", "generate", "batch", "ticket", "", "a/b"} {
+		t.Run(name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("payload type %q registered", name)
+				}
+			}()
+			RegisterPayloadBuilder(assetBuilder{name: name})
+		})
+	}
+	if builders := registeredPayloadBuilders(); len(builders) != 1 || builders[0].Type() != "ticket" {
+		t.Errorf("registered builders = %v", builders)
+	}
+}
@@ -0,0 +1,416 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Lengths in PDF points, the unit of PDF user space
+const (
+	pointsPerInch = 72.0
+	pointsPerMM   = pointsPerInch / 25.4
+)
+
+// minFontSize is the smallest font size in points that still prints
+// legibly
+const minFontSize = 4.0
+
+// Prepress marks in millimeters: the largest bleed print shops ask for,
+// and the crop marks, which start clear of the bleed
+const (
+	maxBleedMM       = 10.0
+	cropMarkGapMM    = 2.0
+	cropMarkLengthMM = 5.0
+)
+
+// cropMarkWidth is the line width of crop marks in points, a hairline
+// print shops expect
+const cropMarkWidth = 0.25
+
+// pdfPage is a page of a pdfDocument: its size in points and the operators
+// of its content stream. Coordinates start at the bottom left corner.
+type pdfPage struct {
+	width, height float64
+	content       bytes.Buffer
+	// text is set once the page draws text, which needs the font resource
+	text bool
+	// images are drawn as XObjects /Im0, /Im1, ... of the page
+	images []image.Image
+	// bleed is how far fills touching the page edge extend past it
+	bleed float64
+}
+
+// pdfDocument is a minimal PDF 1.4 writer for vector print layouts: pages
+// of filled shapes, Helvetica text, and RGB images, without embedded fonts
+type pdfDocument struct {
+	pages []*pdfPage
+	// bleed in points and cropMarks add a bleed area and crop marks around
+	// pages, which become the trim box of a larger media box
+	bleed     float64
+	cropMarks bool
+}
+
+// printOptions are the prepress options of print-ready PDFs
+type printOptions struct {
+	// BleedMM extends fills touching the page edge past it, so trimming
+	// leaves no white slivers
+	BleedMM   float64 `json:"bleed_mm,omitempty"`
+	CropMarks bool    `json:"crop_marks,omitempty"`
+}
+
+// validate checks the options of a document in format
+func (o printOptions) validate(format string) *apiError {
+	if o.BleedMM < 0 || o.BleedMM > maxBleedMM {
+		return apiErrorf(errCodeInvalidParam, nil, "Field 'bleed_mm' must be from 0 to %g", maxBleedMM)
+	}
+	if (o.BleedMM > 0 || o.CropMarks) && format != "" && format != "pdf" {
+		return &apiError{Code: errCodeInvalidParam, Message: "Fields 'bleed_mm' and 'crop_marks' require PDF output"}
+	}
+	return nil
+}
+
+// document returns an empty document with the options
+func (o printOptions) document() *pdfDocument {
+	return &pdfDocument{bleed: o.BleedMM * pointsPerMM, cropMarks: o.CropMarks}
+}
+
+// helveticaWidths are the advances of the printable ASCII characters of
+// Helvetica, from space to tilde, in thousandths of the font size. The
+// standard 14 fonts need no embedding, so PDF readers measure them the same.
+var helveticaWidths = [95]int{
+	278, 278, 355, 556, 556, 889, 667, 191, 333, 333, 389, 584, 278, 333, 278, 278,
+	556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 278, 278, 584, 584, 584, 556,
+	1015, 667, 667, 722, 722, 667, 611, 778, 722, 278, 500, 667, 556, 833, 722, 778,
+	667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 278, 278, 278, 469, 556,
+	333, 556, 556, 500, 556, 556, 278, 556, 556, 222, 222, 500, 222, 833, 556, 556,
+	556, 556, 333, 500, 278, 556, 500, 722, 500, 500, 500, 334, 260, 334, 584,
+}
+
+// winAnsiExtras are the characters of WinAnsiEncoding outside Latin-1 that
+// captions commonly use
+var winAnsiExtras = map[rune]byte{
+	'€': 0x80, '…': 0x85, '‘': 0x91, '’': 0x92, '“': 0x93, '”': 0x94, '•': 0x95, '–': 0x96, '—': 0x97,
+}
+
+// pdfText encodes s in WinAnsiEncoding, the encoding of the standard
+// fonts, replacing characters it lacks with '?'
+func pdfText(s string) []byte {
+	out := make([]byte, 0, len(s))
+	for _, r := range s {
+		switch {
+		case r >= 0x20 && r < 0x7f, r >= 0xa0 && r <= 0xff:
+			out = append(out, byte(r))
+		case winAnsiExtras[r] != 0:
+			out = append(out, winAnsiExtras[r])
+		default:
+			out = append(out, '?')
+		}
+	}
+	return out
+}
+
+// pdfTextWidth is the width in points of s set in Helvetica at size points.
+// Characters beyond ASCII are counted as wide as a digit.
+func pdfTextWidth(s string, size float64) float64 {
+	width := 0
+	for _, c := range pdfText(s) {
+		if c >= 0x20 && c < 0x7f {
+			width += helveticaWidths[c-0x20]
+		} else {
+			width += 556
+		}
+	}
+	return float64(width) * size / 1000
+}
+
+// fitText fits s into width points at up to size points of Helvetica: the
+// font shrinks down to minFontSize, below which s is cut short with an
+// ellipsis
+func fitText(s string, width, size float64) (string, float64) {
+	full := pdfTextWidth(s, size)
+	if full <= width {
+		return s, size
+	}
+	if shrunk := size * width / full; shrunk >= minFontSize {
+		return s, shrunk
+	}
+	size = min(size, minFontSize)
+	runes := []rune(s)
+	for len(runes) > 0 && pdfTextWidth(string(runes)+"…", size) > width {
+		runes = runes[:len(runes)-1]
+	}
+	return strings.TrimRight(string(runes), " ") + "…", size
+}
+
+// printableText reports whether s is valid UTF-8 of at most maxLength
+// characters, none of them control characters
+func printableText(s string, maxLength int) bool {
+	return utf8.ValidString(s) && utf8.RuneCountInString(s) <= maxLength && strings.IndexFunc(s, unicode.IsControl) < 0
+}
+
+// addPage appends an empty page of width by height points
+func (d *pdfDocument) addPage(width, height float64) *pdfPage {
+	page := &pdfPage{width: width, height: height, bleed: d.bleed}
+	d.pages = append(d.pages, page)
+	return page
+}
+
+// pdfNumber formats v with at most three decimals, as PDF readers expect
+// no exponents
+func pdfNumber(v float64) string {
+	s := strconv.FormatFloat(v, 'f', 3, 64)
+	for s[len(s)-1] == '0' {
+		s = s[:len(s)-1]
+	}
+	if s[len(s)-1] == '.' {
+		s = s[:len(s)-1]
+	}
+	if s == "-0" {
+		return "0"
+	}
+	return s
+}
+
+// fillBitmap fills the dark modules of bitmap as squares of module points,
+// with the top left corner of the bitmap at x, y
+func (p *pdfPage) fillBitmap(bitmap [][]bool, x, y, module float64) {
+	for row, modules := range bitmap {
+		for col := 0; col < len(modules); {
+			if !modules[col] {
+				col++
+				continue
+			}
+			start := col
+			for col < len(modules) && modules[col] {
+				col++
+			}
+			fmt.Fprintf(&p.content, "%s %s %s %s re\n",
+				pdfNumber(x+float64(start)*module), pdfNumber(y-float64(row+1)*module),
+				pdfNumber(float64(col-start)*module), pdfNumber(module))
+		}
+	}
+	p.content.WriteString("f\n")
+}
+
+// drawText sets s in Helvetica at size points with its baseline starting
+// at x, y
+func (p *pdfPage) drawText(s string, x, y, size float64) {
+	p.text = true
+	fmt.Fprintf(&p.content, "BT /F1 %s Tf %s %s Td (", pdfNumber(size), pdfNumber(x), pdfNumber(y))
+	for _, c := range pdfText(s) {
+		if c == '(' || c == ')' || c == '\\' {
+			p.content.WriteByte('\\')
+		}
+		p.content.WriteByte(c)
+	}
+	p.content.WriteString(") Tj ET\n")
+}
+
+// fillRect fills a width by height rectangle with its bottom left corner
+// at x, y. Edges on the page edge extend into the bleed.
+func (p *pdfPage) fillRect(x, y, width, height float64) {
+	if x <= 0 {
+		x, width = x-p.bleed, width+p.bleed
+	}
+	if y <= 0 {
+		y, height = y-p.bleed, height+p.bleed
+	}
+	if x+width >= p.width {
+		width += p.bleed
+	}
+	if y+height >= p.height {
+		height += p.bleed
+	}
+	fmt.Fprintf(&p.content, "%s %s %s %s re f\n", pdfNumber(x), pdfNumber(y), pdfNumber(width), pdfNumber(height))
+}
+
+// setColor fills the shapes and text drawn next with c
+func (p *pdfPage) setColor(c color.RGBA) {
+	fmt.Fprintf(&p.content, "%s %s %s rg\n", pdfNumber(float64(c.R)/255), pdfNumber(float64(c.G)/255), pdfNumber(float64(c.B)/255))
+}
+
+// textWidth is the width in points of s set in Helvetica at size points
+func (p *pdfPage) textWidth(s string, size float64) float64 {
+	return pdfTextWidth(s, size)
+}
+
+// drawImage draws img stretched to width by height points with its bottom
+// left corner at x, y
+func (p *pdfPage) drawImage(img image.Image, x, y, width, height float64) {
+	fmt.Fprintf(&p.content, "q %s 0 0 %s %s %s cm /Im%d Do Q\n", pdfNumber(width), pdfNumber(height), pdfNumber(x), pdfNumber(y), len(p.images))
+	p.images = append(p.images, img)
+}
+
+// pdfImageStream returns the pixels of img as a compressed 8-bit RGB
+// stream, composited onto white since pages are white
+func pdfImageStream(img image.Image) []byte {
+	bounds := img.Bounds()
+	var stream bytes.Buffer
+	z := zlib.NewWriter(&stream)
+	row := make([]byte, 0, 3*bounds.Dx())
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		row = row[:0]
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			r, g, b, a := img.At(x, y).RGBA()
+			// Premultiplied channels plus the white showing through
+			white := 0xffff - a
+			row = append(row, byte((r+white)>>8), byte((g+white)>>8), byte((b+white)>>8))
+		}
+		z.Write(row)
+	}
+	z.Close()
+	return stream.Bytes()
+}
+
+// writeTo writes the document: a catalog, the page tree, a page and a
+// compressed content stream per page, the font of text, if any, and the
+// images of the pages, followed by the cross-reference table. Output is
+// deterministic, without creation dates or IDs.
+func (d *pdfDocument) writeTo(w io.Writer) error {
+	counter := &countingWriter{w: w}
+	b := bufio.NewWriter(counter)
+	var offsets []int64
+	object := func(body func()) {
+		b.Flush()
+		offsets = append(offsets, counter.n)
+		fmt.Fprintf(b, "%d 0 obj\n", len(offsets))
+		body()
+		b.WriteString("\nendobj\n")
+	}
+
+	// Objects 1 and 2 are the catalog and page tree; page i is object 3+2i
+	// and its content stream 4+2i, followed by the font and the images
+	text := slices.ContainsFunc(d.pages, func(p *pdfPage) bool { return p.text })
+	font := 3 + 2*len(d.pages)
+	firstImages := make([]int, len(d.pages))
+	next := font
+	if text {
+		next++
+	}
+	for i, page := range d.pages {
+		firstImages[i] = next
+		next += len(page.images)
+	}
+	// Pages sit in the middle of a media box grown by the bleed and the
+	// crop marks
+	margin := d.bleed
+	if d.cropMarks {
+		margin += (cropMarkGapMM + cropMarkLengthMM) * pointsPerMM
+	}
+	b.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+	object(func() { b.WriteString("<< /Type /Catalog /Pages 2 0 R >>") })
+	object(func() {
+		b.WriteString("<< /Type /Pages /Kids [")
+		for i := range d.pages {
+			fmt.Fprintf(b, " %d 0 R", 3+2*i)
+		}
+		fmt.Fprintf(b, " ] /Count %d >>", len(d.pages))
+	})
+	for i, page := range d.pages {
+		resources := "<<"
+		if page.text {
+			resources += fmt.Sprintf(" /Font << /F1 %d 0 R >>", font)
+		}
+		if len(page.images) > 0 {
+			resources += " /XObject <<"
+			for j := range page.images {
+				resources += fmt.Sprintf(" /Im%d %d 0 R", j, firstImages[i]+j)
+			}
+			resources += " >>"
+		}
+		resources += " >>"
+		content := page.content.Bytes()
+		boxes := fmt.Sprintf("/MediaBox [0 0 %s %s]", pdfNumber(page.width), pdfNumber(page.height))
+		if margin > 0 {
+			content, boxes = d.prepress(page, margin)
+		}
+		object(func() {
+			fmt.Fprintf(b, "<< /Type /Page /Parent 2 0 R %s /Resources %s /Contents %d 0 R >>",
+				boxes, resources, 4+2*i)
+		})
+		var stream bytes.Buffer
+		z := zlib.NewWriter(&stream)
+		z.Write(content)
+		z.Close()
+		object(func() {
+			fmt.Fprintf(b, "<< /Length %d /Filter /FlateDecode >>\nstream\n", stream.Len())
+			b.Write(stream.Bytes())
+			b.WriteString("\nendstream")
+		})
+	}
+
+	if text {
+		object(func() {
+			b.WriteString("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>")
+		})
+	}
+	for _, page := range d.pages {
+		for _, img := range page.images {
+			stream := pdfImageStream(img)
+			object(func() {
+				fmt.Fprintf(b, "<< /Type /XObject /Subtype /Image /Width %d /Height %d /ColorSpace /DeviceRGB /BitsPerComponent 8 /Length %d /Filter /FlateDecode >>\nstream\n",
+					img.Bounds().Dx(), img.Bounds().Dy(), len(stream))
+				b.Write(stream)
+				b.WriteString("\nendstream")
+			})
+		}
+	}
+
+	b.Flush()
+	xref := counter.n
+	fmt.Fprintf(b, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(b, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(b, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+	return b.Flush()
+}
+
+// prepress returns the content of page moved margin points into its media
+// box, followed by crop marks at the corners of the trim box, and the page
+// boxes: the trim box is the finished page and the bleed box the area
+// print shops keep before trimming
+func (d *pdfDocument) prepress(page *pdfPage, margin float64) ([]byte, string) {
+	var content bytes.Buffer
+	fmt.Fprintf(&content, "q 1 0 0 1 %s %s cm\n", pdfNumber(margin), pdfNumber(margin))
+	content.Write(page.content.Bytes())
+	content.WriteString("Q\n")
+	if d.cropMarks {
+		// Marks continue the trim edges outward, clear of the bleed
+		content.WriteString("0 0 0 rg\n")
+		offset := d.bleed + cropMarkGapMM*pointsPerMM
+		length := cropMarkLengthMM * pointsPerMM
+		for _, x := range []float64{margin, margin + page.width} {
+			for _, y := range []float64{margin, margin + page.height} {
+				outX, outY := offset, offset
+				if x == margin {
+					outX = -offset - length
+				}
+				if y == margin {
+					outY = -offset - length
+				}
+				fmt.Fprintf(&content, "%s %s %s %s re f\n", pdfNumber(x+outX), pdfNumber(y-cropMarkWidth/2),
+					pdfNumber(length), pdfNumber(cropMarkWidth))
+				fmt.Fprintf(&content, "%s %s %s %s re f\n", pdfNumber(x-cropMarkWidth/2), pdfNumber(y+outY),
+					pdfNumber(cropMarkWidth), pdfNumber(length))
+			}
+		}
+	}
+
+	box := func(inset float64) string {
+		return fmt.Sprintf("[%s %s %s %s]", pdfNumber(inset), pdfNumber(inset),
+			pdfNumber(2*margin+page.width-inset), pdfNumber(2*margin+page.height-inset))
+	}
+	boxes := fmt.Sprintf("/MediaBox %s /BleedBox %s /TrimBox %s", box(0), box(margin-d.bleed), box(margin))
+	return content.Bytes(), boxes
+}
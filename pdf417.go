@@ -0,0 +1,508 @@
+package main
+
+import (
+	"bytes"
+	"math"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Limits of PDF417 symbols: codewords per symbol, rows, and data columns
+const (
+	pdf417MaxCodewords = 928
+	pdf417MinRows      = 3
+	pdf417MaxRows      = 90
+	pdf417MinColumns   = 1
+	pdf417MaxColumns   = 30
+	pdf417MaxLevel     = 8
+)
+
+// Layout of PDF417 symbols in modules: rows are 3 modules high, the ISO/IEC
+// 15438 recommendation, and the quiet zone is its 2-module minimum. Without
+// rows or columns given, symbols are about 3 times wider than high.
+const (
+	pdf417RowHeight   = 3
+	pdf417QuietZone   = 2
+	pdf417AspectRatio = 3.0
+)
+
+// Start and stop patterns of every row, 17 and 18 modules
+const (
+	pdf417StartPattern = 0x1fea8
+	pdf417StopPattern  = 0x3fa29
+)
+
+// Mode codewords of PDF417 data
+const (
+	pdf417LatchText     = 900
+	pdf417LatchByte     = 901
+	pdf417LatchNumeric  = 902
+	pdf417LatchByteSix  = 924
+	pdf417PadCodeword   = 900
+	pdf417MinNumericRun = 13
+	pdf417MinTextRun    = 5
+)
+
+// Characters of the upper, lower, mixed, and punctuation submodes of text
+// compaction, by value. Space is 26 in the first three; the values after
+// the characters are latches and shifts.
+const (
+	pdf417Upper       = "ABCDEFGHIJKLMNOPQRSTUVWXYZ"
+	pdf417Lower       = "abcdefghijklmnopqrstuvwxyz"
+	pdf417Mixed       = "0123456789&\r\t,:#-.$/+%*=^"
+	pdf417Punctuation = ";<>@[\\]_`~!\r\t,:\n-.$/\"|*()?{}'"
+)
+
+// Text compaction latches and shifts: to lower (ll), to mixed (ml), to
+// upper from mixed (al), the one-character shift to upper from lower (as),
+// and the one-character shift to punctuation (ps)
+const (
+	pdf417Space      = 26
+	pdf417ToLower    = 27
+	pdf417ShiftUpper = 27
+	pdf417ToMixed    = 28
+	pdf417ToUpper    = 28
+	pdf417ShiftPunct = 29
+)
+
+// pdf417ReedSolomon is the modulus of the error correction field, GF(929)
+const pdf417ReedSolomon = 929
+
+// pdf417Options are the error correction level, from 0 to 8, and the
+// dimensions of a symbol; -1 and 0 choose them from the content
+type pdf417Options struct {
+	level   int
+	rows    int
+	columns int
+}
+
+// pdf417Symbol is an encoded PDF417 symbol: rows of columns codewords,
+// error correction included
+type pdf417Symbol struct {
+	rows, columns, level int
+	codewords            []int
+}
+
+// pdf417Compact encodes content as data codewords, in numeric compaction
+// for runs of 13 digits or more, text compaction for runs of 5 printable
+// ASCII characters or more, and byte compaction for the rest. Symbols start
+// in text compaction.
+func pdf417Compact(content []byte) []int {
+	var codewords []int
+	text := true
+	for len(content) > 0 {
+		if n := leadingDigits(content); n >= pdf417MinNumericRun || n == len(content) {
+			codewords = append(codewords, pdf417LatchNumeric)
+			codewords = append(codewords, pdf417Numeric(content[:n])...)
+			content, text = content[n:], false
+			continue
+		}
+		if n := pdf417TextRun(content); n >= pdf417MinTextRun || n == len(content) {
+			if !text {
+				codewords = append(codewords, pdf417LatchText)
+			}
+			codewords = append(codewords, pdf417Text(content[:n])...)
+			content, text = content[n:], true
+			continue
+		}
+		n := 1
+		for n < len(content) && leadingDigits(content[n:]) < pdf417MinNumericRun && pdf417TextRun(content[n:]) < pdf417MinTextRun {
+			n++
+		}
+		codewords = append(codewords, pdf417Bytes(content[:n])...)
+		content, text = content[n:], false
+	}
+	return codewords
+}
+
+// leadingDigits counts the digits content starts with
+func leadingDigits(content []byte) int {
+	n := 0
+	for n < len(content) && content[n] >= '0' && content[n] <= '9' {
+		n++
+	}
+	return n
+}
+
+// pdf417TextRun counts the characters content starts with that text
+// compaction encodes, up to a run of digits worth numeric compaction
+func pdf417TextRun(content []byte) int {
+	n := 0
+	for n < len(content) {
+		c := content[n]
+		if c != '\t' && c != '\n' && c != '\r' && (c < ' ' || c > '~') {
+			break
+		}
+		if leadingDigits(content[n:]) >= pdf417MinNumericRun {
+			break
+		}
+		n++
+	}
+	return n
+}
+
+// pdf417Text encodes printable ASCII in text compaction: two submode values
+// of 0 to 29 per codeword, latching to the submode of each character and
+// shifting for single punctuation characters and capitals in lowercase text
+func pdf417Text(text []byte) []int {
+	submodes := []string{pdf417Upper, pdf417Lower, pdf417Mixed}
+	const upper, lower, mixed = 0, 1, 2
+	mode := upper
+	var values []int
+	for i, c := range text {
+		if c == ' ' {
+			values = append(values, pdf417Space)
+			continue
+		}
+		if v := strings.IndexByte(submodes[mode], c); v >= 0 {
+			values = append(values, v)
+			continue
+		}
+		switch {
+		case strings.IndexByte(pdf417Upper, c) >= 0:
+			if mode == lower {
+				if i+1 >= len(text) || strings.IndexByte(pdf417Upper, text[i+1]) < 0 {
+					values = append(values, pdf417ShiftUpper, int(c-'A'))
+					continue
+				}
+				values = append(values, pdf417ToMixed)
+			}
+			values, mode = append(values, pdf417ToUpper), upper
+		case strings.IndexByte(pdf417Lower, c) >= 0:
+			values, mode = append(values, pdf417ToLower), lower
+		case strings.IndexByte(pdf417Mixed, c) >= 0:
+			values, mode = append(values, pdf417ToMixed), mixed
+		default:
+			values = append(values, pdf417ShiftPunct, strings.IndexByte(pdf417Punctuation, c))
+			continue
+		}
+		values = append(values, strings.IndexByte(submodes[mode], c))
+	}
+	if len(values)%2 == 1 {
+		values = append(values, pdf417ShiftPunct)
+	}
+	codewords := make([]int, 0, len(values)/2)
+	for i := 0; i < len(values); i += 2 {
+		codewords = append(codewords, values[i]*30+values[i+1])
+	}
+	return codewords
+}
+
+// pdf417Numeric encodes digits in numeric compaction: groups of up to 44
+// digits, prefixed with 1, in base 900
+func pdf417Numeric(digits []byte) []int {
+	var codewords []int
+	base := big.NewInt(900)
+	for len(digits) > 0 {
+		n := min(len(digits), 44)
+		group, _ := new(big.Int).SetString("1"+string(digits[:n]), 10)
+		var words []int
+		remainder := new(big.Int)
+		for group.Sign() > 0 {
+			group.DivMod(group, base, remainder)
+			words = append([]int{int(remainder.Int64())}, words...)
+		}
+		codewords = append(codewords, words...)
+		digits = digits[n:]
+	}
+	return codewords
+}
+
+// pdf417Bytes encodes bytes in byte compaction: groups of 6 bytes in 5
+// base-900 codewords and one codeword per remaining byte. The latch tells
+// whether the length is a multiple of 6.
+func pdf417Bytes(data []byte) []int {
+	latch := pdf417LatchByte
+	if len(data)%6 == 0 {
+		latch = pdf417LatchByteSix
+	}
+	codewords := []int{latch}
+	for ; len(data) >= 6; data = data[6:] {
+		var group uint64
+		for _, b := range data[:6] {
+			group = group<<8 | uint64(b)
+		}
+		var words [5]int
+		for i := 4; i >= 0; i-- {
+			words[i] = int(group % 900)
+			group /= 900
+		}
+		codewords = append(codewords, words[:]...)
+	}
+	for _, b := range data {
+		codewords = append(codewords, int(b))
+	}
+	return codewords
+}
+
+// pdf417ErrorCorrection computes the 2^(level+1) error correction codewords
+// of data, the Reed-Solomon code over GF(929) whose generator has the roots
+// 3^1 to 3^k
+func pdf417ErrorCorrection(data []int, level int) []int {
+	k := 2 << level
+	// Coefficients of the generator, lowest degree first
+	generator := []int{1}
+	root := 1
+	for range k {
+		root = root * 3 % pdf417ReedSolomon
+		next := make([]int, len(generator)+1)
+		for i, c := range generator {
+			next[i+1] = (next[i+1] + c) % pdf417ReedSolomon
+			next[i] = (next[i] + pdf417ReedSolomon - c*root%pdf417ReedSolomon) % pdf417ReedSolomon
+		}
+		generator = next
+	}
+
+	ecc := make([]int, k)
+	for _, d := range data {
+		t := (d + ecc[k-1]) % pdf417ReedSolomon
+		for j := k - 1; j > 0; j-- {
+			ecc[j] = (ecc[j-1] + pdf417ReedSolomon - t*generator[j]%pdf417ReedSolomon) % pdf417ReedSolomon
+		}
+		ecc[0] = (pdf417ReedSolomon - t*generator[0]%pdf417ReedSolomon) % pdf417ReedSolomon
+	}
+	codewords := make([]int, k)
+	for i, c := range ecc {
+		codewords[k-1-i] = (pdf417ReedSolomon - c) % pdf417ReedSolomon
+	}
+	return codewords
+}
+
+// pdf417Level is the minimum error correction level ISO/IEC 15438
+// recommends for a number of data codewords
+func pdf417Level(dataCodewords int) int {
+	switch {
+	case dataCodewords <= 40:
+		return 2
+	case dataCodewords <= 160:
+		return 3
+	case dataCodewords <= 320:
+		return 4
+	}
+	return 5
+}
+
+// newPDF417 encodes content in a symbol of opts. Content beyond the largest
+// symbol is a payload error; content beyond the rows or columns asked for
+// is a parameter error.
+func newPDF417(content []byte, opts pdf417Options) (*pdf417Symbol, int, *apiError) {
+	data := pdf417Compact(content)
+	level := opts.level
+	if level < 0 {
+		level = pdf417Level(len(data) + 1)
+	}
+	// The symbol length descriptor, the data, and error correction
+	needed := 1 + len(data) + 2<<level
+	if needed > pdf417MaxCodewords {
+		return nil, http.StatusRequestEntityTooLarge, apiErrorf(errCodePayloadTooLarge,
+			map[string]any{"codewords": needed, "max_codewords": pdf417MaxCodewords},
+			"Parameter 'text' needs %d codewords at error correction level %d, the maximum is %d", needed, level, pdf417MaxCodewords)
+	}
+
+	rows, columns := opts.rows, opts.columns
+	switch {
+	case rows > 0 && columns > 0:
+	case columns > 0:
+		rows = max(pdf417MinRows, (needed+columns-1)/columns)
+	case rows > 0:
+		columns = (needed + rows - 1) / rows
+	default:
+		bestDistance := 0.0
+		for c := pdf417MinColumns; c <= pdf417MaxColumns; c++ {
+			r := max(pdf417MinRows, (needed+c-1)/c)
+			if r > pdf417MaxRows || r*c > pdf417MaxCodewords {
+				continue
+			}
+			ratio := float64(17*(c+4)+1) / float64(r*pdf417RowHeight)
+			// Ratios compare by their factor from the preferred one
+			if distance := math.Abs(math.Log(ratio / pdf417AspectRatio)); columns == 0 || distance < bestDistance {
+				rows, columns, bestDistance = r, c, distance
+			}
+		}
+	}
+	// A symbol holds at most pdf417MaxCodewords, even when its rows and
+	// columns are in range, so the length descriptor stays a codeword
+	if rows <= pdf417MaxRows && columns <= pdf417MaxColumns && rows*columns > pdf417MaxCodewords {
+		return nil, http.StatusBadRequest, apiErrorf(errCodeInvalidParam,
+			map[string]any{"rows": rows, "columns": columns, "max_codewords": pdf417MaxCodewords},
+			"Parameters 'rows' and 'columns' allow at most %d codewords, not %d rows of %d columns", pdf417MaxCodewords, rows, columns)
+	}
+	if rows > pdf417MaxRows || columns > pdf417MaxColumns || rows*columns < needed {
+		return nil, http.StatusBadRequest, apiErrorf(errCodeInvalidParam,
+			map[string]any{"codewords": needed},
+			"Parameter 'text' needs %d codewords at error correction level %d, more than %d rows of %d columns hold", needed, level, min(rows, pdf417MaxRows), min(columns, pdf417MaxColumns))
+	}
+
+	// Padding fills the rows; the length descriptor counts itself, the
+	// data, and the padding
+	padding := rows*columns - needed
+	codewords := make([]int, 0, rows*columns)
+	codewords = append(codewords, 1+len(data)+padding)
+	codewords = append(codewords, data...)
+	for range padding {
+		codewords = append(codewords, pdf417PadCodeword)
+	}
+	codewords = append(codewords, pdf417ErrorCorrection(codewords, level)...)
+	return &pdf417Symbol{rows: rows, columns: columns, level: level, codewords: codewords}, 0, nil
+}
+
+// rowIndicators returns the left and right row indicator codewords of row,
+// which tell scanners the rows, columns, and error correction level
+func (s *pdf417Symbol) rowIndicators(row int) (int, int) {
+	base := 30 * (row / 3)
+	rowsValue := (s.rows - 1) / 3
+	levelValue := s.level*3 + (s.rows-1)%3
+	columnsValue := s.columns - 1
+	switch row % 3 {
+	case 0:
+		return base + rowsValue, base + columnsValue
+	case 1:
+		return base + levelValue, base + rowsValue
+	}
+	return base + columnsValue, base + levelValue
+}
+
+// layout returns the width and height of the symbol in modules, quiet zone
+// included, and its bars. Each row uses the codeword patterns of cluster 0,
+// 3, or 6 in turn.
+func (s *pdf417Symbol) layout() (int, int, []rect) {
+	width := 17*(s.columns+4) + 1
+	var rects []rect
+	for row := range s.rows {
+		left, right := s.rowIndicators(row)
+		cluster := &pdf417Patterns[row%3]
+		patterns := []uint32{pdf417StartPattern, cluster[left]}
+		for _, codeword := range s.codewords[row*s.columns : (row+1)*s.columns] {
+			patterns = append(patterns, cluster[codeword])
+		}
+		patterns = append(patterns, cluster[right])
+
+		x := pdf417QuietZone
+		y := pdf417QuietZone + row*pdf417RowHeight
+		bar := func(pattern uint32, modules int) {
+			for i := modules - 1; i >= 0; {
+				if pattern>>i&1 == 0 {
+					x, i = x+1, i-1
+					continue
+				}
+				start := x
+				for ; i >= 0 && pattern>>i&1 == 1; i-- {
+					x++
+				}
+				rects = append(rects, rect{start, y, x - start, pdf417RowHeight})
+			}
+		}
+		for _, pattern := range patterns {
+			bar(pattern, 17)
+		}
+		bar(pdf417StopPattern, 18)
+	}
+	return width + 2*pdf417QuietZone, s.rows*pdf417RowHeight + 2*pdf417QuietZone, rects
+}
+
+// parsePDF417Options reads the ec, rows, and columns parameters
+func parsePDF417Options(query url.Values) (pdf417Options, *apiError) {
+	opts := pdf417Options{level: -1}
+	for _, param := range []struct {
+		name     string
+		value    *int
+		min, max int
+	}{
+		{"ec", &opts.level, 0, pdf417MaxLevel},
+		{"rows", &opts.rows, pdf417MinRows, pdf417MaxRows},
+		{"columns", &opts.columns, pdf417MinColumns, pdf417MaxColumns},
+	} {
+		raw := query.Get(param.name)
+		if raw == "" {
+			continue
+		}
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < param.min || n > param.max {
+			return opts, apiErrorf(errCodeInvalidParam, nil, "Parameter '%s' must be a number from %d to %d", param.name, param.min, param.max)
+		}
+		*param.value = n
+	}
+	return opts, nil
+}
+
+// handlePDF417 renders the text parameter as a PDF417 symbol, the stacked
+// barcode of driver's licenses and shipping labels. Symbols are
+// deterministic and served with the caching headers of QR codes.
+func handlePDF417(deps handlerDeps) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		text := query.Get("text")
+		if text == "" {
+			writeAPIError(w, r, http.StatusBadRequest, apiError{Code: errCodeInvalidParam, Message: "Missing required parameter 'text'"})
+			return
+		}
+		symbolOpts, apiErr := parsePDF417Options(query)
+		if apiErr != nil {
+			writeAPIError(w, r, http.StatusBadRequest, *apiErr)
+			return
+		}
+		opts, apiErr := parseSymbolOptions(query)
+		if apiErr != nil {
+			writeAPIError(w, r, http.StatusBadRequest, *apiErr)
+			return
+		}
+		options := map[string]string{}
+		// AAMVA driver's license data separates its fields with control
+		// characters, which are encoded as given on request
+		sanitize := deps.sanitize
+		if query.Get("allow_unsafe_chars") == "true" {
+			sanitize = sanitizeOff
+			options["unsafe_chars"] = "allowed"
+		}
+		setAuditPayload(r.Context(), text, options)
+
+		if status, apiErr := validatePayload(text, deps.maxPayloadLength); apiErr != nil {
+			writeAPIError(w, r, status, *apiErr)
+			return
+		}
+		if text, apiErr = sanitizePayload(text, sanitize); apiErr != nil {
+			writeAPIError(w, r, http.StatusBadRequest, *apiErr)
+			return
+		}
+		content, apiErr := latin1Bytes("PDF417", text)
+		if apiErr != nil {
+			writeAPIError(w, r, http.StatusBadRequest, *apiErr)
+			return
+		}
+		if apiErr := deps.policy.check(text, apiKeyName(r.Context())); apiErr != nil {
+			writeAPIError(w, r, http.StatusUnprocessableEntity, *apiErr)
+			return
+		}
+		symbol, status, apiErr := newPDF417(content, symbolOpts)
+		if apiErr != nil {
+			writeAPIError(w, r, status, *apiErr)
+			return
+		}
+
+		etag := imageETag(imageCacheKey(string(content), renderVersion, "pdf417", strconv.Itoa(symbol.level),
+			strconv.Itoa(symbol.rows), strconv.Itoa(symbol.columns), opts.format, strconv.Itoa(opts.scale)))
+		setImageCacheHeaders(w.Header(), etag, deps.imageMaxAge)
+		if etagMatches(r.Header.Get("If-None-Match"), etag) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		width, height, rects := symbol.layout()
+		var out bytes.Buffer
+		contentType := "image/png"
+		if opts.format == formatSVG {
+			contentType = "image/svg+xml"
+			writeRectsSVG(&out, width, height, rects, opts.scale, "PDF417 code")
+		} else if err := writeRectsPNG(&out, width, height, rects, opts.scale); err != nil {
+			setRequestError(r.Context(), err)
+			http.Error(w, "Failed to generate PDF417 symbol", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Last-Modified", renderModTime.Format(http.TimeFormat))
+		serveImage(w, r, "pdf417."+opts.format, out.Bytes())
+	}
+}
@@ -0,0 +1,344 @@
+package main
+
+import (
+	"bytes"
+	"image/png"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestPDF417Patterns(t *testing.T) {
+	seen := map[uint32]bool{}
+	for cluster, patterns := range pdf417Patterns {
+		for codeword, pattern := range patterns {
+			widths := moduleWidths(pattern, 17)
+			if len(widths) != 8 || pattern>>16&1 != 1 {
+				t.Fatalf("cluster %d codeword %d: %017b is not 4 bars and 4 spaces", cluster*3, codeword, pattern)
+			}
+			if got := (widths[0] - widths[2] + widths[4] - widths[6] + 9) % 9; got != cluster*3 {
+				t.Fatalf("cluster %d codeword %d: pattern is of cluster %d", cluster*3, codeword, got)
+			}
+			if seen[pattern] {
+				t.Fatalf("cluster %d codeword %d: pattern %017b is not unique", cluster*3, codeword, pattern)
+			}
+			seen[pattern] = true
+		}
+	}
+}
+
+func TestPDF417ErrorCorrection(t *testing.T) {
+	data := []int{16, 902, 1, 278, 827, 900, 295, 902, 2, 326, 823, 544, 900, 149, 900, 900}
+	tests := []struct {
+		level int
+		want  []int
+	}{
+		{level: 0, want: []int{156, 765}},
+		{level: 1, want: []int{168, 875, 63, 355}},
+		{level: 2, want: []int{628, 715, 393, 299, 863, 601, 169, 708}},
+	}
+
+	for _, tt := range tests {
+		if got := pdf417ErrorCorrection(data, tt.level); !slices.Equal(got, tt.want) {
+			t.Errorf("level %d: error correction = %v, want %v", tt.level, got, tt.want)
+		}
+	}
+}
+
+func TestPDF417Compact(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    []int
+	}{
+		{name: "digits", content: "01234", want: []int{902, 112, 434}},
+		{name: "text", content: "Super ", want: []int{567, 615, 137, 809}},
+		{name: "text then digits", content: "ABC123", want: []int{1, 88, 32, 119}},
+		{name: "long number", content: "Order 12345678901234567890"},
+		{name: "mixed case", content: "aBc DEF gHIj, k-l.m"},
+		{name: "punctuation", content: "a;b<c>d@e[f]g_h\n(i)?{j}'k\"l|m~n!o`p\\q"},
+		{name: "Latin-1", content: "crème brûlée à 12 €uros"[:25]},
+		{name: "control characters", content: "@\n\x1e\rANSI 636000090002DL00410278ZV03190008DLDAQT64235789\nDCSSAMPLE\nDDEN\x1e\r"},
+		{name: "bytes in groups of six", content: "\x01\x02\x03\x04\x05\x06"},
+		{name: "numbers and bytes", content: "1234567890123\xff\xfe4567890123456"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := pdf417Compact([]byte(tt.content))
+			if tt.want != nil && !slices.Equal(got, tt.want) {
+				t.Errorf("codewords = %v, want %v", got, tt.want)
+			}
+			if decoded := decodePDF417Data(t, got); decoded != tt.content {
+				t.Errorf("codewords decode to %q, want %q", decoded, tt.content)
+			}
+		})
+	}
+}
+
+func TestNewPDF417(t *testing.T) {
+	tests := []struct {
+		name        string
+		content     string
+		opts        pdf417Options
+		wantRows    int
+		wantColumns int
+		wantLevel   int
+		wantStatus  int
+	}{
+		{name: "defaults", content: "PDF417 test", opts: pdf417Options{level: -1}, wantRows: 8, wantColumns: 2, wantLevel: 2},
+		{name: "columns", content: "PDF417 test", opts: pdf417Options{level: -1, columns: 4}, wantRows: 4, wantColumns: 4, wantLevel: 2},
+		{name: "rows", content: "PDF417 test", opts: pdf417Options{level: -1, rows: 3}, wantRows: 3, wantColumns: 6, wantLevel: 2},
+		{name: "rows and columns", content: "PDF417 test", opts: pdf417Options{level: 5, rows: 20, columns: 10}, wantRows: 20, wantColumns: 10, wantLevel: 5},
+		{name: "too small", content: "PDF417 test", opts: pdf417Options{level: 2, rows: 3, columns: 2}, wantStatus: http.StatusBadRequest},
+		{name: "too many codewords", content: "PDF417 test", opts: pdf417Options{level: -1, rows: 90, columns: 30}, wantStatus: http.StatusBadRequest},
+		{name: "too many rows", content: strings.Repeat("\xff", 600), opts: pdf417Options{level: -1, columns: 1}, wantStatus: http.StatusBadRequest},
+		{name: "higher level for more data", content: strings.Repeat("ABCDEFGH", 40), opts: pdf417Options{level: -1}, wantRows: 25, wantColumns: 8, wantLevel: 4},
+		{name: "too long", content: strings.Repeat("\xff", 1200), opts: pdf417Options{level: -1}, wantStatus: http.StatusRequestEntityTooLarge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			symbol, status, apiErr := newPDF417([]byte(tt.content), tt.opts)
+			if status != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %v", status, tt.wantStatus, apiErr)
+			}
+			if apiErr != nil {
+				return
+			}
+			if symbol.rows != tt.wantRows || symbol.columns != tt.wantColumns || symbol.level != tt.wantLevel {
+				t.Errorf("symbol = %d rows of %d columns at level %d, want %d rows of %d columns at level %d",
+					symbol.rows, symbol.columns, symbol.level, tt.wantRows, tt.wantColumns, tt.wantLevel)
+			}
+			if len(symbol.codewords) != symbol.rows*symbol.columns {
+				t.Fatalf("%d codewords fill %d rows of %d columns", len(symbol.codewords), symbol.rows, symbol.columns)
+			}
+			length := symbol.codewords[0]
+			if decoded := decodePDF417Data(t, trimPadding(symbol.codewords[1:length])); decoded != tt.content {
+				t.Errorf("symbol decodes to %q, want %q", decoded, tt.content)
+			}
+			if got := pdf417ErrorCorrection(symbol.codewords[:length], symbol.level); !slices.Equal(got, symbol.codewords[length:]) {
+				t.Error("error correction codewords don't match the data")
+			}
+		})
+	}
+}
+
+func TestPDF417_Layout(t *testing.T) {
+	symbol, _, apiErr := newPDF417([]byte("Shipment 1Z999AA10123456784"), pdf417Options{level: 3, columns: 3})
+	if apiErr != nil {
+		t.Fatal(apiErr)
+	}
+	width, height, rects := symbol.layout()
+	if want := 17*(3+4) + 1 + 4; width != want {
+		t.Errorf("width = %d, want %d", width, want)
+	}
+	if want := symbol.rows*3 + 4; height != want {
+		t.Errorf("height = %d, want %d", height, want)
+	}
+
+	// Each row reads back as start, left indicator, codewords, right
+	// indicator, and stop in the patterns of its cluster
+	dark := make([][]bool, height)
+	for y := range dark {
+		dark[y] = make([]bool, width)
+	}
+	for _, r := range rects {
+		for y := r.y; y < r.y+r.h; y++ {
+			for x := r.x; x < r.x+r.w; x++ {
+				dark[y][x] = true
+			}
+		}
+	}
+	for row := range symbol.rows {
+		y := pdf417QuietZone + row*pdf417RowHeight
+		if !slices.Equal(dark[y], dark[y+pdf417RowHeight-1]) {
+			t.Fatalf("row %d is not %d modules high", row, pdf417RowHeight)
+		}
+		read := func(x, modules int) uint32 {
+			var pattern uint32
+			for i := range modules {
+				pattern <<= 1
+				if dark[y][x+i] {
+					pattern |= 1
+				}
+			}
+			return pattern
+		}
+		if read(2, 17) != pdf417StartPattern || read(width-2-18, 18) != pdf417StopPattern {
+			t.Fatalf("row %d lacks the start or stop pattern", row)
+		}
+		var codewords []int
+		for column := range symbol.columns + 2 {
+			pattern := read(2+17*(column+1), 17)
+			codeword := slices.Index(pdf417Patterns[row%3][:], pattern)
+			if codeword < 0 {
+				t.Fatalf("row %d column %d: %017b is not a pattern of cluster %d", row, column, pattern, row%3*3)
+			}
+			codewords = append(codewords, codeword)
+		}
+		left, right := symbol.rowIndicators(row)
+		want := append(append([]int{left}, symbol.codewords[row*symbol.columns:(row+1)*symbol.columns]...), right)
+		if !slices.Equal(codewords, want) {
+			t.Errorf("row %d reads %v, want %v", row, codewords, want)
+		}
+	}
+}
+
+func TestPDF417(t *testing.T) {
+	tests := []struct {
+		name            string
+		query           string
+		wantStatus      int
+		wantContentType string
+		wantBody        string
+	}{
+		{name: "PNG", query: "text=1Z999AA10123456784", wantStatus: http.StatusOK, wantContentType: "image/png"},
+		{name: "SVG with dimensions", query: "text=hello&columns=2&rows=6&ec=1&format=svg&scale=2", wantStatus: http.StatusOK, wantContentType: "image/svg+xml", wantBody: `width="214" height="44" viewBox="0 0 107 22"`},
+		{name: "control characters", query: "text=%40%0A%1E%0DANSI+636000&allow_unsafe_chars=true", wantStatus: http.StatusOK, wantContentType: "image/png"},
+		{name: "control characters rejected", query: "text=%40%0A%1E%0DANSI+636000", wantStatus: http.StatusBadRequest},
+		{name: "missing text", query: "ec=2", wantStatus: http.StatusBadRequest},
+		{name: "invalid level", query: "text=hello&ec=9", wantStatus: http.StatusBadRequest},
+		{name: "invalid columns", query: "text=hello&columns=31", wantStatus: http.StatusBadRequest},
+		{name: "invalid rows", query: "text=hello&rows=2", wantStatus: http.StatusBadRequest},
+		{name: "rows and columns past the codeword limit", query: "text=x&rows=90&columns=30", wantStatus: http.StatusBadRequest, wantBody: `"invalid_parameter"`},
+		{name: "does not fit", query: "text=" + strings.Repeat("x", 100) + "&rows=3&columns=2", wantStatus: http.StatusBadRequest, wantBody: `"codewords":`},
+		{name: "outside Latin-1", query: "text=%E2%82%AC", wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			newTestHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v1/barcode/pdf417?"+tt.query, nil))
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if tt.wantContentType != "" && rec.Header().Get("Content-Type") != tt.wantContentType {
+				t.Errorf("content type = %q, want %q", rec.Header().Get("Content-Type"), tt.wantContentType)
+			}
+			if !strings.Contains(rec.Body.String(), tt.wantBody) {
+				t.Errorf("body lacks %q: %.300s", tt.wantBody, rec.Body.String())
+			}
+			if rec.Code != http.StatusOK {
+				return
+			}
+			if rec.Header().Get("ETag") == "" {
+				t.Error("symbol has no ETag")
+			}
+			if tt.wantContentType == "image/png" {
+				if _, err := png.Decode(bytes.NewReader(rec.Body.Bytes())); err != nil {
+					t.Errorf("invalid PNG: %v", err)
+				}
+			}
+		})
+	}
+}
+
+// moduleWidths returns the widths of the alternating bars and spaces of a
+// pattern of modules
+func moduleWidths(pattern uint32, modules int) []int {
+	var widths []int
+	last := uint32(2)
+	for i := modules - 1; i >= 0; i-- {
+		if bit := pattern >> i & 1; bit != last {
+			widths, last = append(widths, 0), bit
+		}
+		widths[len(widths)-1]++
+	}
+	return widths
+}
+
+// trimPadding removes the pad codewords that end data codewords
+func trimPadding(codewords []int) []int {
+	for len(codewords) > 0 && codewords[len(codewords)-1] == pdf417PadCodeword {
+		codewords = codewords[:len(codewords)-1]
+	}
+	return codewords
+}
+
+// decodePDF417Data decodes data codewords of text, byte, and numeric
+// compaction
+func decodePDF417Data(t *testing.T, codewords []int) string {
+	t.Helper()
+	var out []byte
+	mode := pdf417LatchText
+	submode, shifted := 0, -1
+	tables := []string{pdf417Upper, pdf417Lower, pdf417Mixed, pdf417Punctuation}
+	for i := 0; i < len(codewords); {
+		switch cw := codewords[i]; {
+		case cw == pdf417LatchText || cw == pdf417LatchNumeric || cw == pdf417LatchByte || cw == pdf417LatchByteSix:
+			mode, submode = cw, 0
+			i++
+			continue
+		case cw > pdf417LatchText:
+			t.Fatalf("unexpected codeword %d", cw)
+		}
+
+		switch mode {
+		case pdf417LatchText:
+			for _, v := range []int{codewords[i] / 30, codewords[i] % 30} {
+				table := submode
+				if shifted >= 0 {
+					table, shifted = shifted, -1
+				} else if v >= 26 && submode != 3 {
+					switch {
+					case v == 26:
+						out = append(out, ' ')
+					case v == 29:
+						shifted = 3
+					case submode == 1 && v == 27:
+						shifted = 0
+					case v == 27:
+						submode = 1
+					case v == 28 && submode == 2:
+						submode = 0
+					default:
+						submode = 2
+					}
+					continue
+				} else if submode == 2 && v == 25 {
+					submode = 3
+					continue
+				} else if submode == 3 && v == 29 {
+					submode = 0
+					continue
+				}
+				out = append(out, tables[table][v])
+			}
+			i++
+		case pdf417LatchNumeric:
+			n := i
+			for n < len(codewords) && n-i < 15 && codewords[n] < pdf417LatchText {
+				n++
+			}
+			group := new(big.Int)
+			for _, cw := range codewords[i:n] {
+				group.Mul(group, big.NewInt(900)).Add(group, big.NewInt(int64(cw)))
+			}
+			out = append(out, group.String()[1:]...)
+			i = n
+		default:
+			n := i
+			for n < len(codewords) && codewords[n] < pdf417LatchText {
+				n++
+			}
+			for n-i >= 5 && (mode == pdf417LatchByteSix || n-i > 5) {
+				var group uint64
+				for _, cw := range codewords[i : i+5] {
+					group = group*900 + uint64(cw)
+				}
+				for shift := 40; shift >= 0; shift -= 8 {
+					out = append(out, byte(group>>shift))
+				}
+				i += 5
+			}
+			for ; i < n; i++ {
+				out = append(out, byte(codewords[i]))
+			}
+		}
+	}
+	return string(out)
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestPDFNumber(t *testing.T) {
+	tests := []struct {
+		v    float64
+		want string
+	}{
+		{0, "0"},
+		{72, "72"},
+		{2.5, "2.5"},
+		{1.0 / 3, "0.333"},
+		{-0.0001, "0"},
+		{841.8897, "841.89"},
+	}
+
+	for _, tt := range tests {
+		if got := pdfNumber(tt.v); got != tt.want {
+			t.Errorf("pdfNumber(%v) = %q, want %q", tt.v, got, tt.want)
+		}
+	}
+}
+
+// pdfStreams checks the cross-reference table of a PDF against the object
+// offsets and returns the decompressed content stream of each page
+func pdfStreams(t *testing.T, data []byte) []string {
+	t.Helper()
+	if !bytes.HasPrefix(data, []byte("%PDF-1.4\n")) || !bytes.HasSuffix(data, []byte("%%EOF\n")) {
+		t.Fatalf("not a PDF: %q...", data[:min(len(data), 20)])
+	}
+	m := regexp.MustCompile(`startxref\n(\d+)\n`).FindSubmatch(data)
+	if m == nil {
+		t.Fatal("no startxref")
+	}
+	xref, _ := strconv.Atoi(string(m[1]))
+	if !bytes.HasPrefix(data[xref:], []byte("xref\n")) {
+		t.Fatalf("startxref %d doesn't point to the xref table", xref)
+	}
+	entries := regexp.MustCompile(`(\d{10}) 00000 n `).FindAllSubmatch(data[xref:], -1)
+	for i, entry := range entries {
+		offset, _ := strconv.Atoi(string(entry[1]))
+		if want := fmt.Sprintf("%d 0 obj\n", i+1); !bytes.HasPrefix(data[offset:], []byte(want)) {
+			t.Errorf("xref entry %d points to %q", i+1, data[offset:offset+10])
+		}
+	}
+
+	var streams []string
+	for _, m := range regexp.MustCompile(`(?s)<< /Length (\d+) /Filter /FlateDecode >>\nstream\n`).FindAllSubmatchIndex(data, -1) {
+		length, _ := strconv.Atoi(string(data[m[2]:m[3]]))
+		z, err := zlib.NewReader(bytes.NewReader(data[m[1] : m[1]+length]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, err := io.ReadAll(z)
+		if err != nil {
+			t.Fatal(err)
+		}
+		streams = append(streams, string(content))
+	}
+	return streams
+}
+
+func TestPDFText(t *testing.T) {
+	if got, want := string(pdfText("Grüße – 10 € ✓")), "Gr\xfc\xdfe \x96 10 \x80 ?"; got != want {
+		t.Errorf("pdfText = %q, want %q", got, want)
+	}
+	if got := pdfTextWidth("Hi 1", 10); math.Abs(got-(722+222+278+556)/100.0) > 0.001 {
+		t.Errorf("pdfTextWidth = %v", got)
+	}
+}
+
+func TestFitText(t *testing.T) {
+	tests := []struct {
+		name     string
+		caption  string
+		width    float64
+		want     string
+		wantSize float64
+	}{
+		{name: "fits", caption: "SKU-1", width: 100, want: "SKU-1", wantSize: 8},
+		{name: "shrunk", caption: "0000000000", width: 33.36, want: "0000000000", wantSize: 6},
+		{name: "cut short", caption: "0000000000", width: 10, want: "000…", wantSize: 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, size := fitText(tt.caption, tt.width, 8)
+			if got != tt.want || math.Abs(size-tt.wantSize) > 0.001 {
+				t.Errorf("fitText = %q, %v, want %q, %v", got, size, tt.want, tt.wantSize)
+			}
+			if pdfTextWidth(got, size) > tt.width+0.001 {
+				t.Errorf("%q at %v is %v wide, more than %v", got, size, pdfTextWidth(got, size), tt.width)
+			}
+		})
+	}
+}
+
+func TestPDFDocument_WriteTo(t *testing.T) {
+	doc := &pdfDocument{}
+	doc.addPage(200, 100).fillBitmap([][]bool{{true, true, false}, {false, true, true}}, 10, 90, 2)
+	page := doc.addPage(100, 200)
+	page.drawText("(x)", 5, 6, 7)
+	page.drawImage(image.NewRGBA(image.Rect(0, 0, 3, 2)), 10, 20, 30, 20)
+
+	var out bytes.Buffer
+	if err := doc.writeTo(&out); err != nil {
+		t.Fatal(err)
+	}
+	streams := pdfStreams(t, out.Bytes())
+	if len(streams) != 2 {
+		t.Fatalf("got %d content streams, want 2", len(streams))
+	}
+	if want := "10 88 4 2 re\n12 86 4 2 re\nf\n"; streams[0] != want {
+		t.Errorf("first page content = %q, want %q", streams[0], want)
+	}
+	if want := "BT /F1 7 Tf 5 6 Td (\\(x\\)) Tj ET\nq 30 0 0 20 10 20 cm /Im0 Do Q\n"; streams[1] != want {
+		t.Errorf("second page content = %q, want %q", streams[1], want)
+	}
+	for _, want := range []string{
+		"/Count 2",
+		"/MediaBox [0 0 200 100] /Resources << >>",
+		"/MediaBox [0 0 100 200] /Resources << /Font << /F1 7 0 R >> /XObject << /Im0 8 0 R >> >>",
+		"7 0 obj\n<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>",
+		"8 0 obj\n<< /Type /XObject /Subtype /Image /Width 3 /Height 2 /ColorSpace /DeviceRGB",
+		"/Size 9",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("PDF lacks %q", want)
+		}
+	}
+
+	var again bytes.Buffer
+	doc.writeTo(&again)
+	if !bytes.Equal(out.Bytes(), again.Bytes()) {
+		t.Error("output is not deterministic")
+	}
+}
+
+func TestPDFPage_Colors(t *testing.T) {
+	page := &pdfPage{width: 100, height: 100}
+	page.setColor(color.RGBA{R: 0xff, G: 0x80, A: 0xff})
+	page.fillRect(0, 90, 100, 10.5)
+	if want := "1 0.502 0 rg\n0 90 100 10.5 re f\n"; page.content.String() != want {
+		t.Errorf("content = %q, want %q", page.content.String(), want)
+	}
+}
+
+func TestPDFImageStream(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 2, 1))
+	img.Set(0, 0, color.NRGBA{R: 255, A: 255})
+	img.Set(1, 0, color.NRGBA{B: 255, A: 0})
+
+	z, err := zlib.NewReader(bytes.NewReader(pdfImageStream(img)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _ := io.ReadAll(z)
+	// Transparent pixels show the white page
+	if want := []byte{255, 0, 0, 255, 255, 255}; !bytes.Equal(got, want) {
+		t.Errorf("pixels = %v, want %v", got, want)
+	}
+}
+
+func TestPDFDocument_Prepress(t *testing.T) {
+	doc := printOptions{BleedMM: 3, CropMarks: true}.document()
+	page := doc.addPage(100, 50)
+	// The band touches the top and sides, so it extends into the bleed
+	page.fillRect(0, 40, 100, 10)
+	page.fillRect(10, 10, 10, 10)
+	var buf bytes.Buffer
+	if err := doc.writeTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	// The marks and a 3 mm bleed take 10 mm around the page
+	if want := "/MediaBox [0 0 156.693 106.693] /BleedBox [19.843 19.843 136.85 86.85] /TrimBox [28.346 28.346 128.346 78.346]"; !bytes.Contains(buf.Bytes(), []byte(want)) {
+		t.Errorf("PDF lacks %q", want)
+	}
+	streams := pdfStreams(t, buf.Bytes())
+	for _, want := range []string{
+		"q 1 0 0 1 28.346 28.346 cm\n-8.504 40 117.008 18.504 re f\n10 10 10 10 re f\nQ\n",
+		// The horizontal and vertical marks at the bottom left corner
+		"0 0 0 rg\n0 28.221 14.173 0.25 re f\n28.221 0 0.25 14.173 re f\n",
+	} {
+		if !strings.Contains(streams[0], want) {
+			t.Errorf("content lacks %q: %q", want, streams[0])
+		}
+	}
+	if got := strings.Count(streams[0], " re f\n"); got != 2+8 {
+		t.Errorf("got %d rectangles, want 2 fills and 8 marks", got)
+	}
+}
+
+func TestPrintOptions_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		options printOptions
+		format  string
+		want    string
+	}{
+		{name: "none", format: "svg"},
+		{name: "bleed", options: printOptions{BleedMM: 3, CropMarks: true}, format: "pdf"},
+		{name: "default format", options: printOptions{BleedMM: 10}},
+		{name: "negative bleed", options: printOptions{BleedMM: -1}, want: "Field 'bleed_mm' must be from 0 to 10"},
+		{name: "large bleed", options: printOptions{BleedMM: 12}, want: "Field 'bleed_mm' must be from 0 to 10"},
+		{name: "png", options: printOptions{CropMarks: true}, format: "png", want: "Fields 'bleed_mm' and 'crop_marks' require PDF output"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			apiErr := tt.options.validate(tt.format)
+			if (apiErr == nil) != (tt.want == "") || (apiErr != nil && apiErr.Message != tt.want) {
+				t.Errorf("validate = %+v, want %q", apiErr, tt.want)
+			}
+		})
+	}
+}
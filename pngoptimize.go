@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"io"
+)
+
+// PNG filter types of the rows of an optimized image
+const (
+	pngFilterNone = 0
+	pngFilterUp   = 2
+)
+
+// pngSignature starts every PNG file
+const pngSignature = "\x89PNG\r\n\x1a\n"
+
+// writeOptimizedPNG renders the image, for optimize=true, as the smallest
+// of several lossless encodings of the same pixels. The default encoding is
+// already 1-bit paletted at the best compression level, so it is one of the
+// candidates and the output is never larger. Black-and-white codes can drop
+// the palette as 1-bit grayscale, and rows repeating the one above can be
+// filtered to zeros, which often compresses further.
+func (img *qrImage) writeOptimizedPNG(w io.Writer) (int64, error) {
+	pix := img.draw()
+	defer imagePool.Put(pix)
+
+	var best bytes.Buffer
+	if err := pngEncoder.Encode(&best, pix); err != nil {
+		return 0, err
+	}
+	for _, candidate := range bilevelCandidates(pix.Palette) {
+		encoded, err := encodeBilevelPNG(pix, candidate.gray, candidate.filterRepeats)
+		if err != nil {
+			return 0, err
+		}
+		if len(encoded) < best.Len() {
+			best.Reset()
+			best.Write(encoded)
+		}
+	}
+	n, err := w.Write(best.Bytes())
+	return int64(n), err
+}
+
+// bilevelEncoding is a way of writing a two-color image: as 1-bit
+// grayscale instead of paletted, and with repeated rows Up-filtered
+type bilevelEncoding struct {
+	gray, filterRepeats bool
+}
+
+// bilevelCandidates lists the encodings worth trying for an image of the
+// two-color palette (background, foreground). Grayscale needs pure black
+// and white; translucent colors are left to the default encoder, which
+// writes their transparency.
+func bilevelCandidates(palette color.Palette) []bilevelEncoding {
+	for _, c := range palette {
+		if _, _, _, a := c.RGBA(); a != 0xffff {
+			return nil
+		}
+	}
+	candidates := []bilevelEncoding{{filterRepeats: true}}
+	if isBlackOrWhite(palette[0]) && isBlackOrWhite(palette[1]) {
+		candidates = append(candidates, bilevelEncoding{gray: true}, bilevelEncoding{gray: true, filterRepeats: true})
+	}
+	return candidates
+}
+
+// isBlackOrWhite reports whether c is pure black or pure white
+func isBlackOrWhite(c color.Color) bool {
+	r, g, b, _ := c.RGBA()
+	return r == g && g == b && (r == 0 || r == 0xffff)
+}
+
+// encodeBilevelPNG encodes pix, whose palette has two opaque colors, as a
+// 1-bit PNG with only the required chunks. Grayscale images store white as
+// 1, so the palette's black and white must be mapped to those bits.
+func encodeBilevelPNG(pix *image.Paletted, gray, filterRepeats bool) ([]byte, error) {
+	size := pix.Rect.Dx()
+	stride := (size + 7) / 8
+
+	// Bit value of each palette index
+	var bits [2]byte
+	for i, c := range pix.Palette[:2] {
+		bits[i] = byte(i)
+		if gray {
+			if r, _, _, _ := c.RGBA(); r == 0xffff {
+				bits[i] = 1
+			} else {
+				bits[i] = 0
+			}
+		}
+	}
+
+	var idat bytes.Buffer
+	zw, err := zlib.NewWriterLevel(&idat, zlib.BestCompression)
+	if err != nil {
+		return nil, err
+	}
+	row := make([]byte, 1+stride)
+	prev := make([]byte, stride)
+	zeros := make([]byte, stride)
+	for y := 0; y < size; y++ {
+		packed := row[1:]
+		clear(packed)
+		for x, index := range pix.Pix[y*pix.Stride : y*pix.Stride+size] {
+			packed[x/8] |= bits[index] << (7 - x%8)
+		}
+		if filterRepeats && y > 0 && bytes.Equal(packed, prev) {
+			// Up subtracts the row above, leaving zeros
+			zw.Write([]byte{pngFilterUp})
+			zw.Write(zeros)
+		} else {
+			row[0] = pngFilterNone
+			zw.Write(row)
+		}
+		copy(prev, packed)
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+
+	var out bytes.Buffer
+	out.WriteString(pngSignature)
+	header := make([]byte, 13)
+	binary.BigEndian.PutUint32(header[0:], uint32(size))
+	binary.BigEndian.PutUint32(header[4:], uint32(size))
+	header[8] = 1 // bit depth
+	if gray {
+		header[9] = 0 // grayscale
+	} else {
+		header[9] = 3 // paletted
+	}
+	writePNGChunk(&out, "IHDR", header)
+	if !gray {
+		palette := make([]byte, 0, 6)
+		for _, c := range pix.Palette[:2] {
+			rgba := color.RGBAModel.Convert(c).(color.RGBA)
+			palette = append(palette, rgba.R, rgba.G, rgba.B)
+		}
+		writePNGChunk(&out, "PLTE", palette)
+	}
+	writePNGChunk(&out, "IDAT", idat.Bytes())
+	writePNGChunk(&out, "IEND", nil)
+	return out.Bytes(), nil
+}
+
+// writePNGChunk appends a chunk of the given type and data, framed by its
+// length and CRC
+func writePNGChunk(out *bytes.Buffer, kind string, data []byte) {
+	var word [4]byte
+	binary.BigEndian.PutUint32(word[:], uint32(len(data)))
+	out.Write(word[:])
+	crc := crc32.NewIEEE()
+	crc.Write([]byte(kind))
+	crc.Write(data)
+	out.WriteString(kind)
+	out.Write(data)
+	binary.BigEndian.PutUint32(word[:], crc.Sum32())
+	out.Write(word[:])
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"image/color"
+	"image/png"
+	"strings"
+	"testing"
+
+	"github.com/skip2/go-qrcode"
+)
+
+func TestWriteOptimizedPNG(t *testing.T) {
+	navy := color.RGBA{B: 0x80, A: 0xff}
+	tests := []struct {
+		name        string
+		text        string
+		size        int
+		fg, bg      color.Color
+		wantSmaller bool
+	}{
+		{name: "url", text: "https://example.com", size: 256, fg: color.Black, bg: color.White, wantSmaller: true},
+		{name: "long text", text: strings.Repeat("lorem ipsum ", 50), size: 256, fg: color.Black, bg: color.White, wantSmaller: true},
+		{name: "inverted", text: "https://example.com", size: 300, fg: color.White, bg: color.Black, wantSmaller: true},
+		{name: "colored", text: "https://example.com", size: 256, fg: navy, bg: color.White},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q, err := qrcode.New(tt.text, qrcode.Medium)
+			if err != nil {
+				t.Fatal(err)
+			}
+			img := &qrImage{bitmap: q.Bitmap(), foreground: tt.fg, background: tt.bg, size: tt.size}
+			plain, err := img.PNG()
+			if err != nil {
+				t.Fatal(err)
+			}
+			img.optimize = true
+			optimized, err := img.PNG()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(optimized) > len(plain) || (tt.wantSmaller && len(optimized) == len(plain)) {
+				t.Errorf("optimized PNG is %d bytes, default %d; want smaller", len(optimized), len(plain))
+			}
+			want, err := png.Decode(bytes.NewReader(plain))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := png.Decode(bytes.NewReader(optimized))
+			if err != nil {
+				t.Fatalf("optimized PNG doesn't decode: %v", err)
+			}
+			for y := range tt.size {
+				for x := range tt.size {
+					r1, g1, b1, a1 := want.At(x, y).RGBA()
+					r2, g2, b2, a2 := got.At(x, y).RGBA()
+					if r1 != r2 || g1 != g2 || b1 != b2 || a1 != a2 {
+						t.Fatalf("pixel (%d, %d) differs from the default encoding", x, y)
+					}
+				}
+			}
+		})
+	}
+}
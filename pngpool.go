@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"sync"
+)
+
+// Pools of the per-render scratch memory: the paletted image, the PNG output
+// buffer, and the encoder's zlib state. Only the final PNG is allocated per
+// request, at its exact size, and not even that when the image is streamed.
+var (
+	imagePool     sync.Pool // *image.Paletted
+	pngBufferPool = sync.Pool{New: func() any { return new(bytes.Buffer) }}
+	pngEncoder    = png.Encoder{CompressionLevel: png.BestCompression, BufferPool: &encoderBufferPool{}}
+)
+
+// encoderBufferPool lets PNG encoders reuse their compression buffers
+type encoderBufferPool struct {
+	pool sync.Pool
+}
+
+func (p *encoderBufferPool) Get() *png.EncoderBuffer {
+	buf, _ := p.pool.Get().(*png.EncoderBuffer)
+	return buf
+}
+
+func (p *encoderBufferPool) Put(buf *png.EncoderBuffer) {
+	p.pool.Put(buf)
+}
+
+// qrImage is an encoded QR code symbol ready to be rendered as a PNG, or
+// as SVG when format is formatSVG
+type qrImage struct {
+	// bitmap holds the modules of the symbol, quiet zone included, of the
+	// version that encodes content
+	bitmap     [][]bool
+	version    int
+	content    string
+	foreground color.Color
+	background color.Color
+	size       int
+	format     string
+	// darkMode adds dark-mode colors to SVGs
+	darkMode bool
+	// optimize writes PNGs through writeOptimizedPNG
+	optimize bool
+	// accessible adds a title and description to SVGs, replacing the
+	// defaults with title and description when set
+	accessible  bool
+	title       string
+	description string
+}
+
+// PNG renders the image as PNG into a new byte slice
+func (img *qrImage) PNG() ([]byte, error) {
+	return img.render(img.writePNG)
+}
+
+// Bytes renders the image in its format into a new byte slice
+func (img *qrImage) Bytes() ([]byte, error) {
+	return img.render(img.WriteTo)
+}
+
+// render buffers the output of write in pooled memory
+func (img *qrImage) render(write func(io.Writer) (int64, error)) ([]byte, error) {
+	buf := pngBufferPool.Get().(*bytes.Buffer)
+	defer pngBufferPool.Put(buf)
+	buf.Reset()
+	if _, err := write(buf); err != nil {
+		return nil, err
+	}
+	return bytes.Clone(buf.Bytes()), nil
+}
+
+// WriteTo renders the image in its format directly to w, so the encoded
+// image is never held in memory as a whole
+func (img *qrImage) WriteTo(w io.Writer) (int64, error) {
+	if img.format == formatSVG {
+		return img.writeSVG(w)
+	}
+	return img.writePNG(w)
+}
+
+// writePNG renders the image as PNG. The output is the same as go-qrcode's
+// q.PNG(size), with pooled scratch memory, unless the image is optimized.
+func (img *qrImage) writePNG(w io.Writer) (int64, error) {
+	if img.optimize {
+		return img.writeOptimizedPNG(w)
+	}
+	pix := img.draw()
+	defer imagePool.Put(pix)
+
+	counter := &countingWriter{w: w}
+	err := pngEncoder.Encode(counter, pix)
+	return counter.n, err
+}
+
+// draw maps each pixel to the nearest module, as go-qrcode does, into a
+// pooled image
+func (img *qrImage) draw() *image.Paletted {
+	bitmap, size := img.bitmap, img.size
+	realSize := len(bitmap)
+	if size < realSize {
+		size = realSize
+	}
+
+	pix := pooledImage(size, color.Palette{img.background, img.foreground})
+	fg := uint8(pix.Palette.Index(img.foreground))
+	bg := uint8(pix.Palette.Index(img.background))
+
+	modulesPerPixel := float64(realSize) / float64(size)
+	for y := 0; y < size; y++ {
+		modules := bitmap[int(float64(y)*modulesPerPixel)]
+		row := pix.Pix[y*pix.Stride : y*pix.Stride+size]
+		for x := range row {
+			if modules[int(float64(x)*modulesPerPixel)] {
+				row[x] = fg
+			} else {
+				row[x] = bg
+			}
+		}
+	}
+	return pix
+}
+
+// countingWriter counts the bytes written through it
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(b []byte) (int, error) {
+	n, err := c.w.Write(b)
+	c.n += int64(n)
+	return n, err
+}
+
+// pooledImage returns a paletted image of size x size pixels, reusing a
+// pooled one when it has the same size. Its pixels must all be overwritten.
+func pooledImage(size int, palette color.Palette) *image.Paletted {
+	if img, ok := imagePool.Get().(*image.Paletted); ok && img.Rect.Dx() == size && img.Rect.Dy() == size {
+		img.Palette = palette
+		return img
+	}
+	return image.NewPaletted(image.Rect(0, 0, size, size), palette)
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/skip2/go-qrcode"
+)
+
+func TestRenderPNG_MatchesGoQRCode(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		size int
+	}{
+		{name: "url", text: "https://example.com", size: 256},
+		{name: "long text", text: strings.Repeat("lorem ipsum ", 50), size: 256},
+		{name: "size smaller than the symbol", text: "https://example.com", size: 10},
+		{name: "other size", text: "hello", size: 512},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q, err := qrcode.New(tt.text, qrcode.Medium)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want, err := q.PNG(tt.size)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Twice, so the second render reuses pooled memory
+			for range 2 {
+				got, err := (&qrImage{bitmap: q.Bitmap(), foreground: q.ForegroundColor, background: q.BackgroundColor, size: tt.size}).PNG()
+				if err != nil {
+					t.Fatalf("PNG() error = %v", err)
+				}
+				if !bytes.Equal(got, want) {
+					t.Fatalf("PNG() output differs from go-qrcode (%d vs %d bytes)", len(got), len(want))
+				}
+			}
+		})
+	}
+}
+
+// TestRenderPNG_Minimal guards the output size: images are 1-bit paletted
+// PNGs with no ancillary chunks, so there is nothing left to palettize or strip
+func TestRenderPNG_Minimal(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		size int
+	}{
+		{name: "url", text: "https://example.com", size: 256},
+		{name: "long text", text: strings.Repeat("lorem ipsum ", 50), size: 256},
+		{name: "large image", text: "hello", size: 1024},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q, err := qrcode.New(tt.text, qrcode.Medium)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := (&qrImage{bitmap: q.Bitmap(), foreground: q.ForegroundColor, background: q.BackgroundColor, size: tt.size}).PNG()
+			if err != nil {
+				t.Fatalf("PNG() error = %v", err)
+			}
+
+			// IHDR is the first chunk; bit depth and color type follow width and height
+			if depth, colorType := got[24], got[25]; depth != 1 || colorType != 3 {
+				t.Errorf("bit depth %d, color type %d, want 1-bit paletted (1, 3)", depth, colorType)
+			}
+			var chunks []string
+			for i := 8; i+8 <= len(got); {
+				length := int(binary.BigEndian.Uint32(got[i:]))
+				chunks = append(chunks, string(got[i+4:i+8]))
+				i += 12 + length
+			}
+			if want := []string{"IHDR", "PLTE", "IDAT", "IEND"}; !slices.Equal(chunks, want) {
+				t.Errorf("chunks = %v, want %v", chunks, want)
+			}
+		})
+	}
+}
+
+func TestRenderPNG_Concurrent(t *testing.T) {
+	texts := []string{"first", "second", "third", "fourth"}
+	want := map[string][]byte{}
+	for _, text := range texts {
+		png, err := qrcode.Encode(text, qrcode.Medium, 256)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want[text] = png
+	}
+
+	var wg sync.WaitGroup
+	for i := range 40 {
+		text := texts[i%len(texts)]
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			got, err := (&QRCodeGenerator{}).GenerateQRCodeBytes(context.Background(), text)
+			if err != nil || !bytes.Equal(got, want[text]) {
+				t.Errorf("GenerateQRCodeBytes(%q) returned a different image (error %v)", text, err)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func BenchmarkGenerateQRCodeBytes(b *testing.B) {
+	qrGen := &QRCodeGenerator{}
+	b.ReportAllocs()
+	for range b.N {
+		if _, err := qrGen.GenerateQRCodeBytes(context.Background(), "https://example.com/campaign?utm_source=qr"); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestQRImage_WriteTo(t *testing.T) {
+	img, err := (&QRCodeGenerator{}).Encode(context.Background(), "https://example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := img.PNG()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	n, err := img.WriteTo(&buf)
+	if err != nil {
+		t.Fatalf("WriteTo() error = %v", err)
+	}
+	if n != int64(buf.Len()) || !bytes.Equal(buf.Bytes(), want) {
+		t.Errorf("WriteTo() wrote %d bytes (reported %d), want the %d bytes of PNG()", buf.Len(), n, len(want))
+	}
+}
+
+func TestGenerate_StreamsUncachedImages(t *testing.T) {
+	want, err := (&QRCodeGenerator{}).GenerateQRCodeBytes(context.Background(), "hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec := httptest.NewRecorder()
+	newHandler(newTestDeps()).ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v1/qr/generate?text=hello", nil))
+
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "image/png" {
+		t.Fatalf("status = %d, Content-Type = %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	if rec.Header().Get("Content-Length") != "" {
+		t.Error("streamed response has a Content-Length")
+	}
+	if !bytes.Equal(rec.Body.Bytes(), want) {
+		t.Error("streamed image differs from the buffered one")
+	}
+}
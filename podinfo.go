@@ -0,0 +1,51 @@
+package main
+
+import (
+	"net/http"
+)
+
+// servedByHeader identifies the replica that served a response
+const servedByHeader = "X-Served-By"
+
+// podInfo is the Kubernetes identity of this replica, populated from the Downward API
+type podInfo struct {
+	Name      string
+	Namespace string
+	Node      string
+}
+
+// loadPodInfo reads the pod identity from config, falling back to the hostname
+// (which Kubernetes sets to the pod name) when the Downward API is not wired up
+func loadPodInfo(cfg Config, hostname string) podInfo {
+	pod := podInfo{
+		Name:      cfg.PodName,
+		Namespace: cfg.PodNamespace,
+		Node:      cfg.NodeName,
+	}
+	if pod.Name == "" {
+		pod.Name = hostname
+	}
+	return pod
+}
+
+// logAttrs returns the pod identity as slog key/value pairs, omitting unknown fields
+func (p podInfo) logAttrs() []any {
+	attrs := []any{"pod", p.Name}
+	if p.Namespace != "" {
+		attrs = append(attrs, "namespace", p.Namespace)
+	}
+	if p.Node != "" {
+		attrs = append(attrs, "node", p.Node)
+	}
+	return attrs
+}
+
+// servedByMiddleware adds an X-Served-By header naming the pod that handled the request
+func servedByMiddleware(pod podInfo, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if pod.Name != "" {
+			w.Header().Set(servedByHeader, pod.Name)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestLoadPodInfo(t *testing.T) {
+	t.Run("downward API values", func(t *testing.T) {
+		cfg := Config{PodName: "qr-generator-abc", PodNamespace: "qr-generator", NodeName: "node-1"}
+		got := loadPodInfo(cfg, "hostname")
+		want := podInfo{Name: "qr-generator-abc", Namespace: "qr-generator", Node: "node-1"}
+		if got != want {
+			t.Errorf("loadPodInfo() = %+v, want %+v", got, want)
+		}
+	})
+
+	t.Run("falls back to hostname", func(t *testing.T) {
+		got := loadPodInfo(Config{}, "my-host")
+		if got.Name != "my-host" {
+			t.Errorf("loadPodInfo().Name = %q, want %q", got.Name, "my-host")
+		}
+		if attrs := got.logAttrs(); len(attrs) != 2 {
+			t.Errorf("logAttrs() = %v, want only the pod name", attrs)
+		}
+	})
+}
+
+func TestServedByHeader(t *testing.T) {
+	rec := httptest.NewRecorder()
+	newTestHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/health", nil))
+
+	if got := rec.Header().Get(servedByHeader); got != "test-pod" {
+		t.Errorf("%s = %q, want %q", servedByHeader, got, "test-pod")
+	}
+}
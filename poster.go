@@ -0,0 +1,256 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"image/color"
+	"log/slog"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/skip2/go-qrcode"
+)
+
+// Bounds of poster text in characters
+const (
+	maxHeadlineLength = 80
+	maxSubtextLength  = 200
+)
+
+// Resolutions of PNG posters in dots per inch: the default suits office
+// printers, and larger images cost memory per render
+const (
+	defaultPosterDPI = 150
+	minPosterDPI     = 72
+	maxPosterDPI     = 300
+)
+
+// minBrandContrast is the WCAG contrast of large text against the white
+// page below which brand-colored headlines get hard to read
+const minBrandContrast = 3.0
+
+// posterPages are the poster page sizes in points
+var posterPages = map[string][2]float64{
+	"a4":     {210 * pointsPerMM, 297 * pointsPerMM},
+	"a3":     {297 * pointsPerMM, 420 * pointsPerMM},
+	"letter": {8.5 * pointsPerInch, 11 * pointsPerInch},
+}
+
+// posterRequest is the body of POST /api/v1/qr/poster
+type posterRequest struct {
+	Text     string `json:"text"`
+	Headline string `json:"headline,omitempty"`
+	Subtext  string `json:"subtext,omitempty"`
+	// Color is the brand color of the headline and the top band as
+	// #rrggbb, black by default
+	Color string `json:"color,omitempty"`
+	// Page is a4 (default), a3, or letter, and Format pdf (default) or png
+	Page   string `json:"page,omitempty"`
+	Format string `json:"format,omitempty"`
+	// DPI is the resolution of PNG posters
+	DPI int `json:"dpi,omitempty"`
+	// Level is the error correction level, as the ec parameter
+	Level string `json:"ec,omitempty"`
+	printOptions
+}
+
+// validate checks the fields of the request and returns the brand color
+func (p posterRequest) validate() (color.RGBA, *apiError) {
+	brand := color.RGBA{A: 0xff}
+	for _, field := range []struct {
+		name, value string
+		maxLength   int
+	}{{"headline", p.Headline, maxHeadlineLength}, {"subtext", p.Subtext, maxSubtextLength}} {
+		if !printableText(field.value, field.maxLength) {
+			return brand, apiErrorf(errCodeInvalidParam, nil, "Field '%s' must be at most %d printable characters", field.name, field.maxLength)
+		}
+	}
+	if p.Color != "" {
+		var ok bool
+		if brand, ok = parseHexColor(p.Color); !ok {
+			return brand, &apiError{Code: errCodeInvalidParam, Message: "Field 'color' must be a hex color such as #1a2b3c"}
+		}
+		white := color.RGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff}
+		if ratio := contrastRatio(brand, white); ratio < minBrandContrast {
+			return brand, apiErrorf(errCodeInvalidParam, map[string]any{"contrast": ratio},
+				"Field 'color' needs a contrast of at least %g:1 against the white page", minBrandContrast)
+		}
+	}
+	if _, ok := posterPages[p.Page]; p.Page != "" && !ok {
+		return brand, &apiError{Code: errCodeInvalidParam, Message: "Field 'page' must be a4, a3, or letter"}
+	}
+	switch p.Format {
+	case "", "pdf":
+		if p.DPI != 0 {
+			return brand, &apiError{Code: errCodeInvalidParam, Message: "Field 'dpi' requires format png"}
+		}
+	case formatPNG:
+		if p.DPI != 0 && (p.DPI < minPosterDPI || p.DPI > maxPosterDPI) {
+			return brand, apiErrorf(errCodeInvalidParam, nil, "Field 'dpi' must be from %d to %d", minPosterDPI, maxPosterDPI)
+		}
+	default:
+		return brand, &apiError{Code: errCodeInvalidParam, Message: "Field 'format' must be pdf or png"}
+	}
+	if apiErr := p.printOptions.validate(p.Format); apiErr != nil {
+		return brand, apiErr
+	}
+	if _, ok := recoveryLevels[p.Level]; p.Level != "" && !ok {
+		return brand, &apiError{Code: errCodeInvalidParam, Message: "Field 'ec' must be low, medium, high, or highest"}
+	}
+	return brand, nil
+}
+
+// wrapText breaks s into lines of at most width points at size points,
+// between words. Words wider than a line get a line of their own.
+func wrapText(c canvas, s string, width, size float64) []string {
+	var lines []string
+	line := ""
+	for _, word := range strings.Fields(s) {
+		if line != "" && c.textWidth(line+" "+word, size) > width {
+			lines = append(lines, line)
+			line = ""
+		}
+		if line != "" {
+			line += " "
+		}
+		line += word
+	}
+	if line != "" {
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// layoutPoster draws a poster of width by height points: a band of the
+// brand color across the top, the headline in the brand color below it,
+// the subtext wrapped above the bottom margin, and the code as large as
+// fits between them, all centered. The headline shrinks to fit one line.
+func layoutPoster(c canvas, width, height float64, p posterRequest, brand color.RGBA, bitmap [][]bool) {
+	margin := width / 12
+	band := margin / 3
+	column := width - 2*margin
+	c.setColor(brand)
+	c.fillRect(0, height-band, width, band)
+
+	top := height - band - margin
+	if p.Headline != "" {
+		size := width / 10
+		if w := c.textWidth(p.Headline, size); w > column {
+			size *= column / w
+		}
+		// Capitals reach about three quarters of the font size above the
+		// baseline
+		baseline := top - 0.75*size
+		c.drawText(p.Headline, (width-c.textWidth(p.Headline, size))/2, baseline, size)
+		top = baseline - margin/2
+	}
+
+	c.setColor(color.RGBA{A: 0xff})
+	bottom := margin
+	if p.Subtext != "" {
+		size := width / 32
+		lines := wrapText(c, p.Subtext, column, size)
+		for i := len(lines) - 1; i >= 0; i-- {
+			// Words wider than the column shrink to fit
+			lineSize := size
+			if w := c.textWidth(lines[i], size); w > column {
+				lineSize *= column / w
+			}
+			c.drawText(lines[i], (width-c.textWidth(lines[i], lineSize))/2, bottom, lineSize)
+			bottom += 1.3 * size
+		}
+		bottom += margin / 2
+	}
+
+	side := min(column, top-bottom)
+	c.fillBitmap(bitmap, (width-side)/2, top-(top-bottom-side)/2, side/float64(len(bitmap)))
+}
+
+// handlePoster composes a poster for event signage from JSON: a headline
+// and subtext around a large centered code, as a print-ready PDF page or a
+// PNG with format=png
+func handlePoster(deps handlerDeps) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var body posterRequest
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				writeAPIError(w, r, http.StatusRequestEntityTooLarge, *apiErrorf(errCodeBodyTooLarge,
+					map[string]any{"max_bytes": maxBytesErr.Limit},
+					"Request body exceeds %d bytes", maxBytesErr.Limit))
+				return
+			}
+			writeAPIError(w, r, http.StatusBadRequest, apiError{
+				Code:    errCodeInvalidPayload,
+				Message: `Invalid body. Usage: POST /api/v1/qr/poster with {"text": "...", "headline": "...", "subtext": "..."}`,
+			})
+			return
+		}
+		brand, apiErr := body.validate()
+		if apiErr != nil {
+			writeAPIError(w, r, http.StatusBadRequest, *apiErr)
+			return
+		}
+		options := map[string]string{}
+		if body.Page != "" {
+			options["page"] = body.Page
+		}
+		if body.Format != "" {
+			options["format"] = body.Format
+		}
+		setAuditPayload(r.Context(), body.Text, options)
+
+		text, apiErr := checkText(r.Context(), deps, body.Text)
+		if apiErr != nil {
+			writeAPIError(w, r, itemErrorStatus(apiErr.Code), *apiErr)
+			return
+		}
+		level := defaultRenderOptions.level
+		if body.Level != "" {
+			level = body.Level
+		}
+		q, err := qrcode.New(text, recoveryLevels[level])
+		if err != nil {
+			writeAPIError(w, r, http.StatusRequestEntityTooLarge, apiError{
+				Code:    errCodePayloadTooLarge,
+				Message: "Field 'text' is too long to fit in a QR code",
+				Details: map[string]any{"bytes": len(text)},
+			})
+			return
+		}
+
+		page := posterPages["a4"]
+		if body.Page != "" {
+			page = posterPages[body.Page]
+		}
+		var out bytes.Buffer
+		contentType, filename := "application/pdf", "poster.pdf"
+		if body.Format == formatPNG {
+			contentType, filename = "image/png", "poster.png"
+			dpi := defaultPosterDPI
+			if body.DPI != 0 {
+				dpi = body.DPI
+			}
+			img := newPNGCanvas(page[0], page[1], dpi)
+			layoutPoster(img, page[0], page[1], body, brand, q.Bitmap())
+			err = img.writeTo(&out)
+		} else {
+			doc := body.document()
+			layoutPoster(doc.addPage(page[0], page[1]), page[0], page[1], body, brand, q.Bitmap())
+			err = doc.writeTo(&out)
+		}
+		if err != nil {
+			slog.ErrorContext(r.Context(), "failed to compose poster", "error", err)
+			setRequestError(r.Context(), err)
+			http.Error(w, "Failed to compose poster", http.StatusInternalServerError)
+			return
+		}
+		deps.metrics.generatedTotal.Inc()
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("inline", map[string]string{"filename": filename}))
+		w.Header().Set("Cache-Control", "no-store")
+		w.Write(out.Bytes())
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"image/color"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestWrapText(t *testing.T) {
+	c := newPNGCanvas(100, 100, 72)
+	// At size 10, n characters are 6n-1 points wide
+	got := wrapText(c, "aa bb  cc ddddddddd", 46, 10)
+	if want := []string{"aa bb", "cc", "ddddddddd"}; !slices.Equal(got, want) {
+		t.Errorf("wrapText = %q, want %q", got, want)
+	}
+}
+
+func TestLayoutPoster(t *testing.T) {
+	page := posterPages["letter"]
+	svg := &svgCanvas{width: page[0], height: page[1]}
+	p := posterRequest{Headline: "Hi", Subtext: "Scan me"}
+	layoutPoster(svg, page[0], page[1], p, color.RGBA{R: 0x1a, G: 0x73, B: 0xe8, A: 0xff}, [][]bool{{true}})
+
+	for _, want := range []string{
+		// A band of a third of the margin across the top, in the brand color
+		`<g fill="#1a73e8" shape-rendering="crispEdges"><rect x="0" y="0" width="612" height="17"/>`,
+		`font-size="61.2">Hi</text>`,
+		`<g fill="#000000" shape-rendering="crispEdges">`,
+		`y="741" font-family="Helvetica, Arial, sans-serif" font-size="19.125">Scan me</text>`,
+		// The code fills the column, centered between headline and subtext
+		`<path transform="translate(51 160.019) scale(510)"`,
+	} {
+		if !strings.Contains(svg.body.String(), want) {
+			t.Errorf("layout lacks %q: %s", want, svg.body.String())
+		}
+	}
+}
+
+func TestPoster(t *testing.T) {
+	tests := []struct {
+		name            string
+		body            string
+		wantStatus      int
+		wantContentType string
+		want            string
+	}{
+		{name: "pdf", body: `{"text": "https://example.com", "headline": "Welcome", "subtext": "Scan for the schedule", "color": "#1a73e8"}`, wantStatus: http.StatusOK, wantContentType: "application/pdf", want: "/MediaBox [0 0 595.276 841.89]"},
+		{name: "a3 pdf", body: `{"text": "hello", "page": "a3"}`, wantStatus: http.StatusOK, wantContentType: "application/pdf", want: "/MediaBox [0 0 841.89 1190.551]"},
+		{name: "bleed and crop marks", body: `{"text": "hello", "page": "letter", "bleed_mm": 3, "crop_marks": true}`, wantStatus: http.StatusOK, wantContentType: "application/pdf", want: "/TrimBox [28.346 28.346 640.346 820.346]"},
+		{name: "png", body: `{"text": "hello", "headline": "Welcome", "page": "letter", "format": "png", "dpi": 72}`, wantStatus: http.StatusOK, wantContentType: "image/png"},
+		{name: "invalid body", body: `{"text":`, wantStatus: http.StatusBadRequest},
+		{name: "missing text", body: `{"headline": "Welcome"}`, wantStatus: http.StatusBadRequest},
+		{name: "headline too long", body: `{"text": "hello", "headline": "` + strings.Repeat("x", maxHeadlineLength+1) + `"}`, wantStatus: http.StatusBadRequest},
+		{name: "invalid color", body: `{"text": "hello", "color": "blue"}`, wantStatus: http.StatusBadRequest},
+		{name: "pale color", body: `{"text": "hello", "color": "#ffff00"}`, wantStatus: http.StatusBadRequest},
+		{name: "unknown page", body: `{"text": "hello", "page": "a5"}`, wantStatus: http.StatusBadRequest},
+		{name: "unknown format", body: `{"text": "hello", "format": "svg"}`, wantStatus: http.StatusBadRequest},
+		{name: "dpi of pdf", body: `{"text": "hello", "dpi": 300}`, wantStatus: http.StatusBadRequest},
+		{name: "crop marks of png", body: `{"text": "hello", "format": "png", "crop_marks": true}`, wantStatus: http.StatusBadRequest},
+		{name: "dpi too high", body: `{"text": "hello", "format": "png", "dpi": 1200}`, wantStatus: http.StatusBadRequest},
+		{name: "denied url", body: `{"text": "javascript:alert(1)"}`, wantStatus: http.StatusUnprocessableEntity},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			newTestHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v1/qr/poster", strings.NewReader(tt.body)))
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if rec.Code != http.StatusOK {
+				return
+			}
+			if got := rec.Header().Get("Content-Type"); got != tt.wantContentType {
+				t.Errorf("Content-Type = %q, want %q", got, tt.wantContentType)
+			}
+			if tt.wantContentType == "image/png" {
+				img, err := png.Decode(bytes.NewReader(rec.Body.Bytes()))
+				if err != nil {
+					t.Fatal(err)
+				}
+				// Letter at 72 dpi is a pixel per point
+				if got := img.Bounds().Size(); got.X != 612 || got.Y != 792 {
+					t.Errorf("size = %v, want 612x792", got)
+				}
+				return
+			}
+			if len(pdfStreams(t, rec.Body.Bytes())) != 1 {
+				t.Error("want a single page")
+			}
+			if !strings.Contains(rec.Body.String(), tt.want) {
+				t.Errorf("body lacks %q", tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,364 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Error codes of the presets API
+const (
+	errCodePresetNotFound     = "preset_not_found"
+	errCodePresetLimit        = "preset_limit_reached"
+	errCodePresetsUnavailable = "presets_unavailable"
+)
+
+// maxPresetsPerTenant bounds the presets a tenant can save
+const maxPresetsPerTenant = 100
+
+// errPresetLimit is returned when saving a new preset would exceed the limit
+var errPresetLimit = errors.New("preset limit reached")
+
+// preset is a named set of image options, applied with the preset parameter.
+// Its fields are the parameters of the generate endpoint; unset fields keep
+// their defaults.
+type preset struct {
+	Size     int    `json:"size,omitempty"`
+	EC       string `json:"ec,omitempty"`
+	FG       string `json:"fg,omitempty"`
+	BG       string `json:"bg,omitempty"`
+	Format   string `json:"format,omitempty"`
+	DarkMode bool   `json:"dark_mode,omitempty"`
+}
+
+// query returns the options of the preset as request parameters
+func (p preset) query() url.Values {
+	query := url.Values{}
+	if p.Size != 0 {
+		query.Set("size", strconv.Itoa(p.Size))
+	}
+	for name, value := range map[string]string{"ec": p.EC, "fg": p.FG, "bg": p.BG, "format": p.Format} {
+		if value != "" {
+			query.Set(name, value)
+		}
+	}
+	if p.DarkMode {
+		query.Set("dark_mode", "true")
+	}
+	return query
+}
+
+// presetStore keeps the presets of each tenant
+type presetStore interface {
+	list(ctx context.Context, tenant string) (map[string]preset, error)
+	get(ctx context.Context, tenant, name string) (preset, bool, error)
+	// put saves the preset, reporting whether it is new. It returns
+	// errPresetLimit instead of adding a preset beyond limit.
+	put(ctx context.Context, tenant, name string, p preset, limit int) (bool, error)
+	delete(ctx context.Context, tenant, name string) (bool, error)
+}
+
+// presetRegistry serves the presets API and applies presets to requests.
+// Every tenant has its own namespace of preset names.
+type presetRegistry struct {
+	store presetStore
+}
+
+// newPresetRegistry stores presets in Redis at url, shared by all replicas,
+// or in memory when url is empty
+func newPresetRegistry(url string) (*presetRegistry, error) {
+	if url == "" {
+		return &presetRegistry{store: &memoryPresetStore{presets: map[string]map[string]preset{}}}, nil
+	}
+	opts, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, fmt.Errorf("invalid REDIS_URL: %w", err)
+	}
+	return &presetRegistry{store: &redisPresetStore{client: redis.NewClient(opts), prefix: "qr:presets:"}}, nil
+}
+
+// shared reports whether the presets are shared between replicas
+func (p *presetRegistry) shared() bool {
+	_, ok := p.store.(*redisPresetStore)
+	return ok
+}
+
+// check reports whether the Redis server of a shared store answers, for the
+// health endpoint
+func (p *presetRegistry) check(ctx context.Context) error {
+	if s, ok := p.store.(*redisPresetStore); ok {
+		return s.client.Ping(ctx).Err()
+	}
+	return nil
+}
+
+// Close releases the Redis connections of a shared store
+func (p *presetRegistry) Close() error {
+	if s, ok := p.store.(*redisPresetStore); ok {
+		return s.client.Close()
+	}
+	return nil
+}
+
+// applyPreset fills the image options that query leaves unset from the
+// caller's preset named by the preset parameter, so explicit parameters
+// override the preset. Queries without the parameter are returned as is, as
+// are all queries when p is nil.
+func (p *presetRegistry) applyPreset(ctx context.Context, query url.Values) (url.Values, int, *apiError) {
+	name := query.Get("preset")
+	if p == nil || name == "" {
+		return query, 0, nil
+	}
+	saved, ok, err := p.store.get(ctx, tenantFromContext(ctx), name)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to read preset", "preset", name, "error", err)
+		return nil, http.StatusServiceUnavailable, &apiError{Code: errCodePresetsUnavailable, Message: "Presets are unavailable, retry later"}
+	}
+	if !ok {
+		return nil, http.StatusBadRequest, apiErrorf(errCodePresetNotFound, map[string]any{"preset": name}, "Unknown preset %q", name)
+	}
+	merged := url.Values{}
+	for key, values := range query {
+		merged[key] = values
+	}
+	for key, values := range saved.query() {
+		if !merged.Has(key) {
+			merged[key] = values
+		}
+	}
+	return merged, 0, nil
+}
+
+// handleList returns the presets of the caller's tenant by name
+func (p *presetRegistry) handleList(w http.ResponseWriter, r *http.Request) {
+	presets, err := p.store.list(r.Context(), tenantFromContext(r.Context()))
+	if err != nil {
+		p.writeStoreError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(map[string]any{"presets": presets})
+}
+
+// handleGet returns a single preset of the caller's tenant
+func (p *presetRegistry) handleGet(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	saved, ok, err := p.store.get(r.Context(), tenantFromContext(r.Context()), name)
+	if err != nil {
+		p.writeStoreError(w, r, err)
+		return
+	}
+	if !ok {
+		writeAPIError(w, r, http.StatusNotFound, *apiErrorf(errCodePresetNotFound, map[string]any{"preset": name}, "Unknown preset %q", name))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(saved)
+}
+
+// handlePut creates or replaces a preset of the caller's tenant from a JSON
+// body of image options, answering 201 for new presets and 200 otherwise
+func (p *presetRegistry) handlePut(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if !validAPIKeyName.MatchString(name) {
+		writeAPIError(w, r, http.StatusBadRequest, apiError{
+			Code:    errCodeInvalidParam,
+			Message: "Preset names must be 1-64 letters, digits, '.', '_' or '-'",
+			Details: map[string]any{"preset": name},
+		})
+		return
+	}
+	var body preset
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&body); err != nil || body == (preset{}) {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			writeAPIError(w, r, http.StatusRequestEntityTooLarge, *apiErrorf(errCodeBodyTooLarge,
+				map[string]any{"max_bytes": maxBytesErr.Limit},
+				"Request body exceeds %d bytes", maxBytesErr.Limit))
+			return
+		}
+		writeAPIError(w, r, http.StatusBadRequest, apiError{
+			Code:    errCodeInvalidPayload,
+			Message: `Invalid body. Usage: PUT /api/v1/presets/{name} with image options such as {"size": 512, "ec": "high", "fg": "#1a2b3c", "format": "svg"}`,
+		})
+		return
+	}
+	if _, apiErr := parseRenderOptions(body.query()); apiErr != nil {
+		writeAPIError(w, r, http.StatusBadRequest, *apiErr)
+		return
+	}
+
+	tenant := tenantFromContext(r.Context())
+	created, err := p.store.put(r.Context(), tenant, name, body, maxPresetsPerTenant)
+	if errors.Is(err, errPresetLimit) {
+		writeAPIError(w, r, http.StatusConflict, *apiErrorf(errCodePresetLimit,
+			map[string]any{"tenant": tenant, "max_presets": maxPresetsPerTenant},
+			"Tenant %q already has the maximum of %d presets", tenant, maxPresetsPerTenant))
+		return
+	}
+	if err != nil {
+		p.writeStoreError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if created {
+		w.Header().Set("Location", basePathFromContext(r.Context())+"/api/v1/presets/"+name)
+		w.WriteHeader(http.StatusCreated)
+	}
+	json.NewEncoder(w).Encode(body)
+}
+
+// handleDelete removes a preset of the caller's tenant
+func (p *presetRegistry) handleDelete(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	deleted, err := p.store.delete(r.Context(), tenantFromContext(r.Context()), name)
+	if err != nil {
+		p.writeStoreError(w, r, err)
+		return
+	}
+	if !deleted {
+		writeAPIError(w, r, http.StatusNotFound, *apiErrorf(errCodePresetNotFound, map[string]any{"preset": name}, "Unknown preset %q", name))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// writeStoreError answers requests the store failed with 503
+func (p *presetRegistry) writeStoreError(w http.ResponseWriter, r *http.Request, err error) {
+	slog.ErrorContext(r.Context(), "preset store failed", "error", err)
+	setRequestError(r.Context(), err)
+	writeAPIError(w, r, http.StatusServiceUnavailable, apiError{Code: errCodePresetsUnavailable, Message: "Presets are unavailable, retry later"})
+}
+
+// memoryPresetStore keeps presets in the replica's memory; they are lost on
+// restart and not shared between replicas
+type memoryPresetStore struct {
+	mu sync.RWMutex
+	// presets is keyed by tenant and name
+	presets map[string]map[string]preset
+}
+
+func (s *memoryPresetStore) list(_ context.Context, tenant string) (map[string]preset, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	presets := make(map[string]preset, len(s.presets[tenant]))
+	for name, p := range s.presets[tenant] {
+		presets[name] = p
+	}
+	return presets, nil
+}
+
+func (s *memoryPresetStore) get(_ context.Context, tenant, name string) (preset, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	p, ok := s.presets[tenant][name]
+	return p, ok, nil
+}
+
+func (s *memoryPresetStore) put(_ context.Context, tenant, name string, p preset, limit int) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	presets := s.presets[tenant]
+	if presets == nil {
+		presets = map[string]preset{}
+		s.presets[tenant] = presets
+	}
+	_, exists := presets[name]
+	if !exists && len(presets) >= limit {
+		return false, errPresetLimit
+	}
+	presets[name] = p
+	return !exists, nil
+}
+
+func (s *memoryPresetStore) delete(_ context.Context, tenant, name string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.presets[tenant][name]
+	delete(s.presets[tenant], name)
+	return ok, nil
+}
+
+// redisPresetStore keeps the presets of each tenant in a Redis hash of JSON
+// values by name
+type redisPresetStore struct {
+	client *redis.Client
+	prefix string
+}
+
+// redisPutPreset saves a preset unless it is new and the hash is full:
+// it returns 1 for new presets, 0 for replaced ones, and -1 at the limit
+var redisPutPreset = redis.NewScript(`
+if redis.call('HEXISTS', KEYS[1], ARGV[1]) == 0 then
+	if redis.call('HLEN', KEYS[1]) >= tonumber(ARGV[3]) then
+		return -1
+	end
+	redis.call('HSET', KEYS[1], ARGV[1], ARGV[2])
+	return 1
+end
+redis.call('HSET', KEYS[1], ARGV[1], ARGV[2])
+return 0
+`)
+
+func (s *redisPresetStore) list(ctx context.Context, tenant string) (map[string]preset, error) {
+	values, err := s.client.HGetAll(ctx, s.prefix+tenant).Result()
+	if err != nil {
+		return nil, err
+	}
+	presets := make(map[string]preset, len(values))
+	for name, value := range values {
+		var p preset
+		if err := json.Unmarshal([]byte(value), &p); err != nil {
+			return nil, fmt.Errorf("preset %q: %w", name, err)
+		}
+		presets[name] = p
+	}
+	return presets, nil
+}
+
+func (s *redisPresetStore) get(ctx context.Context, tenant, name string) (preset, bool, error) {
+	value, err := s.client.HGet(ctx, s.prefix+tenant, name).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return preset{}, false, nil
+	}
+	if err != nil {
+		return preset{}, false, err
+	}
+	var p preset
+	if err := json.Unmarshal(value, &p); err != nil {
+		return preset{}, false, fmt.Errorf("preset %q: %w", name, err)
+	}
+	return p, true, nil
+}
+
+func (s *redisPresetStore) put(ctx context.Context, tenant, name string, p preset, limit int) (bool, error) {
+	value, err := json.Marshal(p)
+	if err != nil {
+		return false, err
+	}
+	result, err := redisPutPreset.Run(ctx, s.client, []string{s.prefix + tenant}, name, value, limit).Int()
+	if err != nil {
+		return false, err
+	}
+	if result < 0 {
+		return false, errPresetLimit
+	}
+	return result == 1, nil
+}
+
+func (s *redisPresetStore) delete(ctx context.Context, tenant, name string) (bool, error) {
+	n, err := s.client.HDel(ctx, s.prefix+tenant, name).Result()
+	return n > 0, err
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+func TestPresetStores(t *testing.T) {
+	stores := map[string]func(t *testing.T) presetStore{
+		"memory": func(t *testing.T) presetStore {
+			return &memoryPresetStore{presets: map[string]map[string]preset{}}
+		},
+		"redis": func(t *testing.T) presetStore {
+			server := miniredis.RunT(t)
+			presets, err := newPresetRegistry("redis://" + server.Addr())
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { presets.Close() })
+			return presets.store
+		},
+	}
+
+	for name, newStore := range stores {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			s := newStore(t)
+			blue := preset{Size: 512, FG: "#003366"}
+
+			if created, err := s.put(ctx, "acme", "brand", blue, 2); err != nil || !created {
+				t.Fatalf("put new = %v, %v, want created", created, err)
+			}
+			blue.Format = formatSVG
+			if created, err := s.put(ctx, "acme", "brand", blue, 1); err != nil || created {
+				t.Fatalf("put existing at the limit = %v, %v, want replaced", created, err)
+			}
+			if _, err := s.put(ctx, "acme", "other", blue, 1); !errors.Is(err, errPresetLimit) {
+				t.Fatalf("put beyond the limit error = %v, want errPresetLimit", err)
+			}
+			if got, ok, err := s.get(ctx, "acme", "brand"); err != nil || !ok || got != blue {
+				t.Fatalf("get = %+v, %v, %v, want %+v", got, ok, err, blue)
+			}
+			if _, ok, err := s.get(ctx, "globex", "brand"); err != nil || ok {
+				t.Fatalf("get of another tenant = %v, %v, want not found", ok, err)
+			}
+			if presets, err := s.list(ctx, "acme"); err != nil || len(presets) != 1 || presets["brand"] != blue {
+				t.Fatalf("list = %+v, %v", presets, err)
+			}
+			if deleted, err := s.delete(ctx, "acme", "brand"); err != nil || !deleted {
+				t.Fatalf("delete = %v, %v, want deleted", deleted, err)
+			}
+			if deleted, err := s.delete(ctx, "acme", "brand"); err != nil || deleted {
+				t.Fatalf("delete again = %v, %v, want not found", deleted, err)
+			}
+		})
+	}
+}
+
+func TestPresetsAPI(t *testing.T) {
+	keys, err := newAPIKeyStore(true, "acme-ci=secret1,globex-ci=secret2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := keys.SetTenants("acme-ci=acme,globex-ci=globex"); err != nil {
+		t.Fatal(err)
+	}
+	deps := newTestDeps()
+	deps.apiKeys = keys
+	handler := newHandler(deps)
+
+	// The steps run in order against the same handler
+	steps := []struct {
+		name            string
+		method          string
+		path            string
+		key             string
+		body            string
+		wantStatus      int
+		wantCode        string
+		wantContentType string
+	}{
+		{name: "create", method: http.MethodPut, path: "/api/v1/presets/brand-blue", key: "secret1", body: `{"size": 512, "fg": "#003366", "format": "svg"}`, wantStatus: http.StatusCreated},
+		{name: "replace", method: http.MethodPut, path: "/api/v1/presets/brand-blue", key: "secret1", body: `{"size": 512, "fg": "#003366", "ec": "high", "format": "svg"}`, wantStatus: http.StatusOK},
+		{name: "invalid option", method: http.MethodPut, path: "/api/v1/presets/tiny", key: "secret1", body: `{"size": 1}`, wantStatus: http.StatusBadRequest, wantCode: errCodeInvalidParam},
+		{name: "unknown option", method: http.MethodPut, path: "/api/v1/presets/logo", key: "secret1", body: `{"logo": "https://example.com/logo.png"}`, wantStatus: http.StatusBadRequest, wantCode: errCodeInvalidPayload},
+		{name: "no options", method: http.MethodPut, path: "/api/v1/presets/empty", key: "secret1", body: `{}`, wantStatus: http.StatusBadRequest, wantCode: errCodeInvalidPayload},
+		{name: "invalid name", method: http.MethodPut, path: "/api/v1/presets/brand%20blue", key: "secret1", body: `{"size": 512}`, wantStatus: http.StatusBadRequest, wantCode: errCodeInvalidParam},
+		{name: "get", method: http.MethodGet, path: "/api/v1/presets/brand-blue", key: "secret1", wantStatus: http.StatusOK},
+		{name: "other tenant", method: http.MethodGet, path: "/api/v1/presets/brand-blue", key: "secret2", wantStatus: http.StatusNotFound, wantCode: errCodePresetNotFound},
+		{name: "generate with preset", method: http.MethodPost, path: "/api/v1/qr/generate?text=hello&preset=brand-blue", key: "secret1", wantStatus: http.StatusOK, wantContentType: "image/svg+xml"},
+		{name: "parameters override preset", method: http.MethodPost, path: "/api/v1/qr/generate?text=hello&preset=brand-blue&format=png", key: "secret1", wantStatus: http.StatusOK, wantContentType: "image/png"},
+		{name: "generate with other tenant's preset", method: http.MethodPost, path: "/api/v1/qr/generate?text=hello&preset=brand-blue", key: "secret2", wantStatus: http.StatusBadRequest, wantCode: errCodePresetNotFound},
+		{name: "delete", method: http.MethodDelete, path: "/api/v1/presets/brand-blue", key: "secret1", wantStatus: http.StatusNoContent},
+		{name: "delete again", method: http.MethodDelete, path: "/api/v1/presets/brand-blue", key: "secret1", wantStatus: http.StatusNotFound, wantCode: errCodePresetNotFound},
+		{name: "unauthenticated", method: http.MethodGet, path: "/api/v1/presets", wantStatus: http.StatusUnauthorized},
+	}
+
+	for _, tt := range steps {
+		req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+		if tt.key != "" {
+			req.Header.Set(apiKeyHeader, tt.key)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if rec.Code != tt.wantStatus {
+			t.Fatalf("%s: status = %d, want %d: %s", tt.name, rec.Code, tt.wantStatus, rec.Body.String())
+		}
+		if tt.wantCode != "" {
+			var resp struct {
+				Error apiError `json:"error"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || resp.Error.Code != tt.wantCode {
+				t.Errorf("%s: error = %s, want code %q", tt.name, rec.Body.String(), tt.wantCode)
+			}
+		}
+		if tt.wantContentType != "" && rec.Header().Get("Content-Type") != tt.wantContentType {
+			t.Errorf("%s: content type = %q, want %q", tt.name, rec.Header().Get("Content-Type"), tt.wantContentType)
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/presets", nil)
+	req.Header.Set(apiKeyHeader, "secret1")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	var list struct {
+		Presets map[string]preset `json:"presets"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &list); err != nil || len(list.Presets) != 0 {
+		t.Errorf("list after delete = %s", rec.Body.String())
+	}
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// WebSocket subprotocols of the preview channel. Browsers can't set headers
+// on WebSocket handshakes, so the page sends its API key as a second
+// subprotocol, base64url-encoded after previewKeyProtocolPrefix.
+const (
+	previewSubprotocol       = "qr-preview"
+	previewKeyProtocolPrefix = "qr-preview.key."
+)
+
+// previewPath is the path of the preview WebSocket
+const previewPath = "/ui/ws"
+
+// Limits of a preview connection
+const (
+	previewMaxMessageBytes = 64 << 10
+	previewIdleTimeout     = 5 * time.Minute
+	previewWriteTimeout    = 10 * time.Second
+)
+
+// Thresholds of scannability warnings
+const (
+	minPixelsPerModule = 3
+	denseSymbolVersion = 10
+)
+
+// previewRequest is a message of the playground: the text and the image
+// options as the generate endpoint's parameters
+type previewRequest struct {
+	ID      int    `json:"id"`
+	Text    string `json:"text"`
+	Size    string `json:"size"`
+	EC      string `json:"ec"`
+	Mode    string `json:"mode"`
+	Charset string `json:"charset"`
+	FG      string `json:"fg"`
+	BG      string `json:"bg"`
+	Format  string `json:"format"`
+}
+
+// previewResponse answers a previewRequest with the base64 image, or an
+// error, and warnings about options that hurt scannability
+type previewResponse struct {
+	ID          int       `json:"id"`
+	ContentType string    `json:"content_type,omitempty"`
+	Image       string    `json:"image,omitempty"`
+	Version     int       `json:"version,omitempty"`
+	Modules     int       `json:"modules,omitempty"`
+	Warnings    []string  `json:"warnings,omitempty"`
+	Error       *apiError `json:"error,omitempty"`
+}
+
+// previewUpgrader accepts same-origin connections of the preview protocol
+var previewUpgrader = websocket.Upgrader{Subprotocols: []string{previewSubprotocol}}
+
+// previewCredentials moves an API key sent as a subprotocol into the
+// X-API-Key header, so requireAuth authenticates the handshake
+func previewCredentials(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		for _, protocol := range websocket.Subprotocols(r) {
+			encoded, ok := strings.CutPrefix(protocol, previewKeyProtocolPrefix)
+			if !ok {
+				continue
+			}
+			if key, err := base64.RawURLEncoding.DecodeString(encoded); err == nil && r.Header.Get(apiKeyHeader) == "" {
+				r.Header.Set(apiKeyHeader, string(key))
+			}
+		}
+		next(w, r)
+	}
+}
+
+// handlePreview serves the playground's live preview over a WebSocket. Each
+// message is rendered like a generate request, through the image cache and
+// render pool, and counts against the client's rate limit and its tenant's
+// quota and is audited like one. Messages arriving while a render runs
+// replace each other, so only the latest state of the form is rendered.
+func handlePreview(deps handlerDeps) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// The middleware chain wraps the ResponseWriter; the upgrader needs
+		// the connection underneath
+		conn, err := previewUpgrader.Upgrade(hijackableWriter{w}, r, nil)
+		if err != nil {
+			// The upgrader has answered with an error status
+			return
+		}
+		defer conn.Close()
+		conn.SetReadLimit(previewMaxMessageBytes)
+
+		// The connection outlives the request timeout
+		ctx, cancel := context.WithCancel(context.WithoutCancel(r.Context()))
+		defer cancel()
+		latest := make(chan previewRequest, 1)
+		go func() {
+			defer cancel()
+			for {
+				conn.SetReadDeadline(time.Now().Add(previewIdleTimeout))
+				var req previewRequest
+				if err := conn.ReadJSON(&req); err != nil {
+					if !websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+						slog.DebugContext(ctx, "preview connection closed", "error", err)
+					}
+					return
+				}
+				// Replace a request that hasn't been rendered yet
+				select {
+				case <-latest:
+				default:
+				}
+				latest <- req
+			}
+		}()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case req := <-latest:
+				resp := previewResponse{ID: req.ID}
+				if apiErr := chargePreview(r, deps); apiErr != nil {
+					resp.Error = apiErr
+				} else {
+					resp = renderPreview(ctx, deps, req)
+				}
+				var options map[string]string
+				if req.Format != "" {
+					options = map[string]string{"format": req.Format}
+				}
+				deps.audit.recordMessage(r, "qr.preview", req.Text, options, previewStatus(resp.Error))
+				conn.SetWriteDeadline(time.Now().Add(previewWriteTimeout))
+				if err := conn.WriteJSON(resp); err != nil {
+					return
+				}
+			}
+		}
+	}
+}
+
+// chargePreview counts a preview message against the rate limit of the
+// client and the daily quota of its tenant, returning the error to answer
+// the message with when either is used up
+func chargePreview(r *http.Request, deps handlerDeps) *apiError {
+	if status := deps.rateLimiter.allowRequest(r); !status.allowed {
+		return &apiError{
+			Code:    errCodeRateLimited,
+			Message: "Rate limit exceeded, retry later",
+			Details: map[string]any{"retry_after_seconds": int(math.Ceil(status.retryAfter.Seconds()))},
+		}
+	}
+	if deps.tenants != nil {
+		if tenant := tenantFromContext(r.Context()); !deps.tenants.take(tenant) {
+			apiErr, _ := deps.tenants.quotaExceeded(tenant)
+			return apiErr
+		}
+	}
+	return nil
+}
+
+// previewStatus is the HTTP status a generate request failing with apiErr
+// would get, recorded in the audit event of a preview message
+func previewStatus(apiErr *apiError) int {
+	if apiErr == nil {
+		return http.StatusOK
+	}
+	switch apiErr.Code {
+	case errCodeRateLimited, errCodeQuotaExceeded:
+		return http.StatusTooManyRequests
+	case errCodeServerBusy:
+		return http.StatusServiceUnavailable
+	case errCodeRenderFailed:
+		return http.StatusInternalServerError
+	}
+	return http.StatusBadRequest
+}
+
+// renderPreview renders one playground message, bounded by the request
+// timeout like a generate request
+func renderPreview(ctx context.Context, deps handlerDeps, req previewRequest) previewResponse {
+	resp := previewResponse{ID: req.ID}
+	opts, apiErr := parseRenderOptions(url.Values{
+		"size": {req.Size}, "ec": {req.EC}, "mode": {req.Mode}, "charset": {req.Charset}, "fg": {req.FG}, "bg": {req.BG}, "format": {req.Format},
+	})
+	if apiErr != nil {
+		resp.Error = apiErr
+		return resp
+	}
+	if deps.requestTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, deps.requestTimeout)
+		defer cancel()
+	}
+	imageBytes, apiErr := renderTextWith(ctx, deps, req.Text, opts)
+	if apiErr != nil {
+		resp.Error = apiErr
+		return resp
+	}
+	resp.ContentType = opts.contentType()
+	resp.Image = base64.StdEncoding.EncodeToString(imageBytes)
+	// The symbol is encoded again for its dimensions; that is cheap next to
+	// rendering
+	if img, err := deps.qrGen.EncodeWith(ctx, req.Text, opts); err == nil {
+		resp.Version, resp.Modules = img.version, len(img.bitmap)
+		resp.Warnings = scannabilityWarnings(opts, resp.Version, resp.Modules)
+	}
+	return resp
+}
+
+// scannabilityWarnings explains which options make a code of the given
+// version and module count (including the quiet zone) hard to scan
+func scannabilityWarnings(opts renderOptions, version, modules int) []string {
+	var warnings []string
+	fg, bg := relativeLuminance(opts.foreground), relativeLuminance(opts.background)
+	if fg > bg {
+		warnings = append(warnings, "Light modules on a dark background: many scanners can't read inverted codes")
+	}
+	if ratio := contrastRatio(opts.foreground, opts.background); ratio < minContrastRatio {
+		warnings = append(warnings, fmt.Sprintf("Low contrast between foreground and background (%.1f:1); use at least %.1f:1", ratio, minContrastRatio))
+	}
+	if opts.format == formatPNG && opts.size/modules < minPixelsPerModule {
+		warnings = append(warnings, fmt.Sprintf("Modules are only %d pixel(s) wide; use a size of at least %d", opts.size/modules, modules*minPixelsPerModule))
+	}
+	if version >= denseSymbolVersion {
+		hint := "shorten the text"
+		if opts.level == "high" || opts.level == "highest" {
+			hint += " or lower the error correction level"
+		}
+		warnings = append(warnings, fmt.Sprintf("Dense code (version %d, %d×%d modules) needs a large print size; %s", version, modules, modules, hint))
+	}
+	return warnings
+}
+
+// hijackableWriter finds the http.Hijacker beneath middleware wrappers that
+// implement Unwrap
+type hijackableWriter struct {
+	http.ResponseWriter
+}
+
+func (w hijackableWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return http.NewResponseController(w.ResponseWriter).Hijack()
+}
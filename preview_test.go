@@ -0,0 +1,252 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image/color"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestPreview_Handshake(t *testing.T) {
+	tests := []struct {
+		name       string
+		enabled    bool
+		protocols  []string
+		wantStatus int
+	}{
+		{name: "disabled", protocols: []string{previewSubprotocol}, wantStatus: http.StatusNotFound},
+		{name: "missing key", enabled: true, protocols: []string{previewSubprotocol}, wantStatus: http.StatusUnauthorized},
+		{name: "wrong key", enabled: true, protocols: []string{previewSubprotocol, previewKeyProtocol("wrong")}, wantStatus: http.StatusUnauthorized},
+		{name: "key as subprotocol", enabled: true, protocols: []string{previewSubprotocol, previewKeyProtocol("secret1")}, wantStatus: http.StatusSwitchingProtocols},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deps := newTestDeps()
+			deps.flags.Set(flagUI, tt.enabled)
+			keys, err := newAPIKeyStore(true, "ci=secret1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			deps.apiKeys = keys
+			server := httptest.NewServer(newHandler(deps))
+			defer server.Close()
+
+			dialer := websocket.Dialer{Subprotocols: tt.protocols}
+			conn, resp, err := dialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/ui/ws", nil)
+			if resp == nil {
+				t.Fatalf("no handshake response: %v", err)
+			}
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			if conn == nil {
+				return
+			}
+			defer conn.Close()
+			if conn.Subprotocol() != previewSubprotocol {
+				t.Errorf("subprotocol = %q, want %q", conn.Subprotocol(), previewSubprotocol)
+			}
+		})
+	}
+}
+
+func TestPreview_Render(t *testing.T) {
+	deps := newTestDeps()
+	deps.flags.Set(flagUI, true)
+	server := httptest.NewServer(newHandler(deps))
+	defer server.Close()
+	dialer := websocket.Dialer{Subprotocols: []string{previewSubprotocol}}
+	conn, _, err := dialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/ui/ws", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	tests := []struct {
+		name            string
+		req             previewRequest
+		wantContentType string
+		wantErrCode     string
+		wantWarnings    int
+	}{
+		{name: "defaults", req: previewRequest{Text: "hello"}, wantContentType: "image/png"},
+		{name: "svg", req: previewRequest{Text: "hello", Format: "svg"}, wantContentType: "image/svg+xml"},
+		{name: "low contrast", req: previewRequest{Text: "hello", FG: "#777777", BG: "#888888"}, wantContentType: "image/png", wantWarnings: 1},
+		{name: "invalid option", req: previewRequest{Text: "hello", Size: "1"}, wantErrCode: errCodeInvalidParam},
+		{name: "empty text", req: previewRequest{}, wantErrCode: errCodeInvalidPayload},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.req.ID = i + 1
+			conn.NetConn().SetDeadline(time.Now().Add(5 * time.Second))
+			if err := conn.WriteJSON(tt.req); err != nil {
+				t.Fatal(err)
+			}
+			var resp previewResponse
+			if err := conn.ReadJSON(&resp); err != nil {
+				t.Fatal(err)
+			}
+			if resp.ID != tt.req.ID {
+				t.Errorf("id = %d, want %d", resp.ID, tt.req.ID)
+			}
+			if tt.wantErrCode != "" {
+				if resp.Error == nil || resp.Error.Code != tt.wantErrCode {
+					t.Fatalf("error = %+v, want code %q", resp.Error, tt.wantErrCode)
+				}
+				return
+			}
+			if resp.Error != nil {
+				t.Fatalf("error = %+v", resp.Error)
+			}
+			if resp.ContentType != tt.wantContentType {
+				t.Errorf("content type = %q, want %q", resp.ContentType, tt.wantContentType)
+			}
+			if resp.Version != 1 || resp.Modules != 29 {
+				t.Errorf("version %d with %d modules, want 1 with 29", resp.Version, resp.Modules)
+			}
+			if len(resp.Warnings) != tt.wantWarnings {
+				t.Errorf("warnings = %q, want %d", resp.Warnings, tt.wantWarnings)
+			}
+			image, err := base64.StdEncoding.DecodeString(resp.Image)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.wantContentType == "image/png" {
+				if _, err := png.Decode(bytes.NewReader(image)); err != nil {
+					t.Errorf("image is no PNG: %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestPreview_ChargedPerMessage(t *testing.T) {
+	tests := []struct {
+		name        string
+		setup       func(deps *handlerDeps)
+		wantErrCode string
+	}{
+		{
+			name:        "rate limit",
+			setup:       func(deps *handlerDeps) { deps.rateLimiter = newIPRateLimiter(0.001, 2, nil) },
+			wantErrCode: errCodeRateLimited,
+		},
+		{
+			name: "tenant quota",
+			setup: func(deps *handlerDeps) {
+				deps.tenants.configs = map[string]tenantConfig{defaultTenant: {DailyQuota: 1}}
+			},
+			wantErrCode: errCodeQuotaExceeded,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deps := newTestDeps()
+			deps.flags.Set(flagUI, true)
+			tt.setup(&deps)
+			server := httptest.NewServer(newHandler(deps))
+			defer server.Close()
+			dialer := websocket.Dialer{Subprotocols: []string{previewSubprotocol}}
+			conn, _, err := dialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+previewPath, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+
+			var errCodes []string
+			for id := 1; id <= 2; id++ {
+				conn.NetConn().SetDeadline(time.Now().Add(5 * time.Second))
+				if err := conn.WriteJSON(previewRequest{ID: id, Text: "hello"}); err != nil {
+					t.Fatal(err)
+				}
+				var resp previewResponse
+				if err := conn.ReadJSON(&resp); err != nil {
+					t.Fatal(err)
+				}
+				code := ""
+				if resp.Error != nil {
+					code = resp.Error.Code
+				}
+				errCodes = append(errCodes, code)
+			}
+			if errCodes[0] != "" || errCodes[1] != tt.wantErrCode {
+				t.Errorf("error codes = %q, want the second message rejected with %q", errCodes, tt.wantErrCode)
+			}
+
+			events := deps.audit.Query(auditFilter{Action: "qr.preview"})
+			if len(events) != 2 {
+				t.Fatalf("audited %d preview messages, want 2", len(events))
+			}
+			statuses := []int{events[0].Status, events[1].Status}
+			if !slices.Contains(statuses, http.StatusOK) || !slices.Contains(statuses, http.StatusTooManyRequests) {
+				t.Errorf("audited statuses = %v, want 200 and 429", statuses)
+			}
+		})
+	}
+}
+
+func TestScannabilityWarnings(t *testing.T) {
+	tests := []struct {
+		name    string
+		fg, bg  color.RGBA
+		size    int
+		format  string
+		level   string
+		version int
+		want    []string
+	}{
+		{name: "defaults", version: 1},
+		{name: "low contrast", fg: color.RGBA{R: 0x99, G: 0x99, B: 0x99, A: 0xff}, version: 1, want: []string{"Low contrast"}},
+		{name: "inverted", fg: color.RGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff}, bg: color.RGBA{A: 0xff}, version: 1, want: []string{"Light modules on a dark background"}},
+		{name: "small modules", size: 64, version: 1, want: []string{"Modules are only 2 pixel(s) wide; use a size of at least 87"}},
+		{name: "small SVG", size: 64, format: formatSVG, version: 1},
+		{name: "dense", version: 12, want: []string{"Dense code (version 12, 29×29 modules) needs a large print size; shorten the text"}},
+		{name: "dense with high level", level: "highest", version: 12, want: []string{"or lower the error correction level"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := defaultRenderOptions
+			if tt.fg != (color.RGBA{}) {
+				opts.foreground = tt.fg
+			}
+			if tt.bg != (color.RGBA{}) {
+				opts.background = tt.bg
+			}
+			if tt.size != 0 {
+				opts.size = tt.size
+			}
+			if tt.format != "" {
+				opts.format = tt.format
+			}
+			if tt.level != "" {
+				opts.level = tt.level
+			}
+			got := scannabilityWarnings(opts, tt.version, 29)
+			if len(got) != len(tt.want) {
+				t.Fatalf("warnings = %q, want %d", got, len(tt.want))
+			}
+			for i, want := range tt.want {
+				if !strings.Contains(got[i], want) {
+					t.Errorf("warning %d = %q, want %q", i, got[i], want)
+				}
+			}
+		})
+	}
+}
+
+// previewKeyProtocol encodes an API key as a preview subprotocol
+func previewKeyProtocol(key string) string {
+	return previewKeyProtocolPrefix + base64.RawURLEncoding.EncodeToString([]byte(key))
+}
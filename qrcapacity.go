@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/skip2/go-qrcode"
+)
+
+// qrModeAuto lets the encoder split text into segments of the densest
+// modes, as rendering does
+const qrModeAuto = "auto"
+
+// qrLevelLetters are the letters the standard names error correction
+// levels by
+var qrLevelLetters = map[string]string{"L": "low", "M": "medium", "Q": "high", "H": "highest"}
+
+// capacityResponse is the body of GET /api/v1/qr/capacity. Characters,
+// MinVersion, and Fits describe the text parameter, when given.
+type capacityResponse struct {
+	Level         string `json:"ec"`
+	Mode          string `json:"mode"`
+	Version       int    `json:"version"`
+	Modules       int    `json:"modules"`
+	MaxCharacters int    `json:"max_characters"`
+	Characters    int    `json:"characters,omitempty"`
+	MinVersion    int    `json:"min_version,omitempty"`
+	Fits          *bool  `json:"fits,omitempty"`
+}
+
+// parseQRLevel reads the ec parameter, a level name or letter, defaulting
+// to the level of defaultRenderOptions
+func parseQRLevel(query url.Values) (string, *apiError) {
+	raw := query.Get("ec")
+	if raw == "" {
+		return defaultRenderOptions.level, nil
+	}
+	if level, ok := qrLevelLetters[strings.ToUpper(raw)]; ok {
+		return level, nil
+	}
+	if _, ok := recoveryLevels[raw]; !ok {
+		return "", &apiError{Code: errCodeInvalidParam, Message: "Parameter 'ec' must be low, medium, high, or highest, or L, M, Q, or H"}
+	}
+	return raw, nil
+}
+
+// parseQRVersion reads the version parameter, 0 if absent
+func parseQRVersion(query url.Values) (int, *apiError) {
+	raw := query.Get("version")
+	if raw == "" {
+		return 0, nil
+	}
+	version, err := strconv.Atoi(raw)
+	if err != nil || version < minQRVersion || version > maxQRVersion {
+		return 0, apiErrorf(errCodeInvalidParam, nil, "Parameter 'version' must be from %d to %d", minQRVersion, maxQRVersion)
+	}
+	return version, nil
+}
+
+// qrMinVersion returns the smallest version holding text in a mode at an
+// error correction level, or 0 if none does. Auto mode asks the encoder,
+// whose mixed segments can be smaller than any single mode.
+func qrMinVersion(text, mode, level string) int {
+	if mode == qrModeAuto {
+		q, err := qrcode.New(text, recoveryLevels[level])
+		if err != nil {
+			return 0
+		}
+		return q.VersionNumber
+	}
+	n, _ := qrCharacters(text, mode)
+	for version := minQRVersion; version <= maxQRVersion; version++ {
+		if n <= qrCapacity(mode, version, level) {
+			return version
+		}
+	}
+	return 0
+}
+
+// handleCapacity answers how many characters fit in a QR code of the
+// version, ec, and mode parameters, and with a text parameter the smallest
+// version that holds it, so clients can check payloads before committing
+// to a printed size. Nothing is rendered.
+func handleCapacity(deps handlerDeps) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		level, apiErr := parseQRLevel(query)
+		if apiErr != nil {
+			writeAPIError(w, r, http.StatusBadRequest, *apiErr)
+			return
+		}
+		version, apiErr := parseQRVersion(query)
+		if apiErr != nil {
+			writeAPIError(w, r, http.StatusBadRequest, *apiErr)
+			return
+		}
+		mode := query.Get("mode")
+		text := query.Get("text")
+		switch {
+		case mode == "" && text == "":
+			mode = qrModeByte
+		case mode == "":
+			mode = qrModeAuto
+		case mode == qrModeAuto && text == "":
+			writeAPIError(w, r, http.StatusBadRequest, apiError{Code: errCodeInvalidParam, Message: "Parameter 'mode' auto requires parameter 'text'"})
+			return
+		case mode != qrModeAuto && !slices.Contains(qrModes, mode):
+			writeAPIError(w, r, http.StatusBadRequest, apiError{Code: errCodeInvalidParam, Message: "Parameter 'mode' must be auto, numeric, alphanumeric, byte, or kanji"})
+			return
+		}
+		if text == "" && version == 0 {
+			writeAPIError(w, r, http.StatusBadRequest, apiError{Code: errCodeInvalidParam, Message: "Parameter 'version' or 'text' is required"})
+			return
+		}
+
+		resp := capacityResponse{Level: level, Mode: mode, Version: version}
+		if text != "" {
+			if status, apiErr := validatePayload(text, deps.maxPayloadLength); apiErr != nil {
+				writeAPIError(w, r, status, *apiErr)
+				return
+			}
+			if mode == qrModeAuto {
+				resp.Mode = qrTextMode(text)
+			}
+			n, ok := qrCharacters(text, resp.Mode)
+			if !ok {
+				writeAPIError(w, r, http.StatusBadRequest, *apiErrorf(errCodeInvalidParam, nil, "Parameter 'text' can't be encoded in %s mode", mode))
+				return
+			}
+			resp.Characters = n
+			resp.MinVersion = qrMinVersion(text, mode, level)
+			if resp.MinVersion == 0 {
+				writeAPIError(w, r, http.StatusRequestEntityTooLarge, apiError{
+					Code:    errCodePayloadTooLarge,
+					Message: "Parameter 'text' is too long to fit in a QR code",
+					Details: map[string]any{"characters": n},
+				})
+				return
+			}
+			if resp.Version == 0 {
+				resp.Version = resp.MinVersion
+			}
+			fits := resp.MinVersion <= resp.Version
+			resp.Fits = &fits
+		}
+		resp.Modules = qrModules(resp.Version)
+		resp.MaxCharacters = qrCapacity(resp.Mode, resp.Version, level)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCapacity(t *testing.T) {
+	fits, overflows := true, false
+	tests := []struct {
+		name       string
+		query      string
+		wantStatus int
+		want       capacityResponse
+	}{
+		{name: "version", query: "ec=H&version=10&mode=alphanumeric", wantStatus: http.StatusOK, want: capacityResponse{Level: "highest", Mode: "alphanumeric", Version: 10, Modules: 57, MaxCharacters: 174}},
+		{name: "byte by default", query: "version=1", wantStatus: http.StatusOK, want: capacityResponse{Level: "medium", Mode: "byte", Version: 1, Modules: 21, MaxCharacters: 14}},
+		{name: "smallest version", query: "text=12345", wantStatus: http.StatusOK, want: capacityResponse{Level: "medium", Mode: "numeric", Version: 1, Modules: 21, MaxCharacters: 34, Characters: 5, MinVersion: 1, Fits: &fits}},
+		{name: "too small a version", query: "text=" + strings.Repeat("a", 30) + "&version=1&ec=low", wantStatus: http.StatusOK, want: capacityResponse{Level: "low", Mode: "byte", Version: 1, Modules: 21, MaxCharacters: 17, Characters: 30, MinVersion: 2, Fits: &overflows}},
+		{name: "explicit mode", query: "text=HELLO&mode=byte&ec=q", wantStatus: http.StatusOK, want: capacityResponse{Level: "high", Mode: "byte", Version: 1, Modules: 21, MaxCharacters: 11, Characters: 5, MinVersion: 1, Fits: &fits}},
+		{name: "text not in mode", query: "text=hello&mode=kanji", wantStatus: http.StatusBadRequest},
+		{name: "nothing to estimate", query: "ec=H", wantStatus: http.StatusBadRequest},
+		{name: "auto without text", query: "version=2&mode=auto", wantStatus: http.StatusBadRequest},
+		{name: "unknown mode", query: "version=2&mode=ascii", wantStatus: http.StatusBadRequest},
+		{name: "version too high", query: "version=41", wantStatus: http.StatusBadRequest},
+		{name: "unknown ec", query: "version=1&ec=X", wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			newTestHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/qr/capacity?"+tt.query, nil))
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if rec.Code != http.StatusOK {
+				return
+			}
+			var got capacityResponse
+			if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+				t.Fatal(err)
+			}
+			gotFits, wantFits := got.Fits, tt.want.Fits
+			got.Fits, tt.want.Fits = nil, nil
+			if got != tt.want || (gotFits == nil) != (wantFits == nil) || (gotFits != nil && *gotFits != *wantFits) {
+				t.Errorf("response = %+v (fits %v), want %+v (fits %v)", got, gotFits, tt.want, wantFits)
+			}
+		})
+	}
+}
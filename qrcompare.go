@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"image"
+	"log/slog"
+	"maps"
+	"net/http"
+)
+
+// compareRequest is the body of POST /api/v1/qr/compare: two codes, each a
+// rendered image or a text with batch options
+type compareRequest struct {
+	A compareSide `json:"a"`
+	B compareSide `json:"b"`
+}
+
+// compareSide is one code of a comparison. Image is a base64 PNG or JPEG
+// of a plain code, as the generate endpoint renders it; without one, Text
+// is encoded with Options like a batch item.
+type compareSide struct {
+	Image   []byte       `json:"image,omitempty"`
+	Text    string       `json:"text,omitempty"`
+	Options batchOptions `json:"options"`
+}
+
+// compareResult is what one code decodes to
+type compareResult struct {
+	Content string `json:"content"`
+	Version int    `json:"version"`
+	Level   string `json:"ec"`
+	Mask    int    `json:"mask"`
+}
+
+// compareDiff marks the modules two symbols of the same version differ
+// in, one string per row like qrMatrix, '1' where they differ
+type compareDiff struct {
+	Modules int      `json:"modules"`
+	Size    int      `json:"size"`
+	Rows    []string `json:"rows"`
+}
+
+// compareResponse is the body answering a comparison. Codes of the same
+// content can still differ in modules, by level, mode, or mask; the diff
+// is left out when their versions differ.
+type compareResponse struct {
+	SameContent bool          `json:"same_content"`
+	SameSymbol  bool          `json:"same_symbol"`
+	A           compareResult `json:"a"`
+	B           compareResult `json:"b"`
+	Diff        *compareDiff  `json:"diff,omitempty"`
+}
+
+// modules returns the modules of a side without quiet zone, read from its
+// image or encoded from its text. Errors other than API errors are context
+// errors and encoding failures.
+func (s compareSide) modules(ctx context.Context, deps handlerDeps, name string) ([][]bool, *apiError, error) {
+	if len(s.Image) > 0 {
+		config, kind, err := image.DecodeConfig(bytes.NewReader(s.Image))
+		if err != nil || (kind != "png" && kind != "jpeg") {
+			return nil, apiErrorf(errCodeInvalidParam, nil, "Field '%s.image' must be a base64 PNG or JPEG image", name), nil
+		}
+		if config.Width > maxImageSize || config.Height > maxImageSize {
+			return nil, apiErrorf(errCodeInvalidParam, map[string]any{"width": config.Width, "height": config.Height},
+				"Field '%s.image' must be at most %d pixels wide and high", name, maxImageSize), nil
+		}
+		img, _, err := image.Decode(bytes.NewReader(s.Image))
+		if err != nil {
+			return nil, apiErrorf(errCodeInvalidParam, nil, "Field '%s.image' must be a base64 PNG or JPEG image", name), nil
+		}
+		modules, err := qrSampleImage(img)
+		if err != nil {
+			return nil, apiErrorf(errCodeInvalidParam, nil, "Field '%s.image' holds no readable QR code", name), nil
+		}
+		return modules, nil, nil
+	}
+	if s.Text == "" {
+		return nil, apiErrorf(errCodeInvalidPayload, nil, "Field '%s' must have an image or a text", name), nil
+	}
+
+	opts, apiErr := s.Options.resolve(batchOptions{})
+	if apiErr != nil {
+		return nil, apiErr, nil
+	}
+	text, apiErr := checkText(ctx, deps, s.Text)
+	if apiErr != nil {
+		return nil, apiErr, nil
+	}
+	img, err := deps.qrGen.EncodeWith(ctx, text, opts)
+	switch {
+	case errors.Is(err, errPayloadTooLarge):
+		return nil, &apiError{
+			Code:    errCodePayloadTooLarge,
+			Message: "Field 'text' is too long to fit in a QR code",
+			Details: map[string]any{"bytes": len(text)},
+		}, nil
+	case errors.Is(err, errTextNotInMode):
+		return nil, apiErrorf(errCodeInvalidPayload, nil, "Field 'text' can't be encoded in %s mode", opts.mode), nil
+	case errors.Is(err, errTextNotInCharset):
+		return nil, apiErrorf(errCodeInvalidPayload, nil, "Field 'text' has characters that %s lacks", opts.charset), nil
+	case err != nil:
+		return nil, nil, err
+	}
+	size := len(img.bitmap) - 2*qrQuietZone
+	modules := make([][]bool, size)
+	for y := range size {
+		modules[y] = img.bitmap[y+qrQuietZone][qrQuietZone : size+qrQuietZone]
+	}
+	return modules, nil, nil
+}
+
+// newCompareResponse compares two symbols and what they decode to
+func newCompareResponse(a, b [][]bool, decodedA, decodedB qrDecoded) compareResponse {
+	resp := compareResponse{
+		SameContent: decodedA.content == decodedB.content,
+		A:           compareResult{Content: decodedA.content, Version: decodedA.version, Level: decodedA.level, Mask: decodedA.mask},
+		B:           compareResult{Content: decodedB.content, Version: decodedB.version, Level: decodedB.level, Mask: decodedB.mask},
+	}
+	if len(a) != len(b) {
+		return resp
+	}
+	diff := &compareDiff{Size: len(a), Rows: make([]string, len(a))}
+	row := make([]byte, len(a))
+	for y := range a {
+		for x := range a[y] {
+			row[x] = '0'
+			if a[y][x] != b[y][x] {
+				row[x] = '1'
+				diff.Modules++
+			}
+		}
+		diff.Rows[y] = string(row)
+	}
+	resp.SameSymbol = diff.Modules == 0
+	resp.Diff = diff
+	return resp
+}
+
+// handleCompare decodes two codes, rendered images or texts with options,
+// and reports whether they hold the same content and which modules differ,
+// to check that template or option changes keep codes scanning the same
+func handleCompare(deps handlerDeps) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req compareRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				writeAPIError(w, r, http.StatusRequestEntityTooLarge, *apiErrorf(errCodeBodyTooLarge,
+					map[string]any{"max_bytes": maxBytesErr.Limit},
+					"Request body exceeds %d bytes", maxBytesErr.Limit))
+				return
+			}
+			writeAPIError(w, r, http.StatusBadRequest, apiError{
+				Code:    errCodeInvalidPayload,
+				Message: `Invalid body. Usage: POST /api/v1/qr/compare with {"a": {"image": "..."}, "b": {"text": "...", "options": {...}}}`,
+			})
+			return
+		}
+
+		var modules [2][][]bool
+		var decoded [2]qrDecoded
+		for i, side := range []compareSide{req.A, req.B} {
+			name := []string{"a", "b"}[i]
+			m, apiErr, err := side.modules(r.Context(), deps, name)
+			if err == nil && apiErr == nil {
+				decoded[i], err = qrDecode(m)
+				if errors.Is(err, errQRUnreadable) && len(side.Image) > 0 {
+					apiErr, err = apiErrorf(errCodeInvalidParam, nil, "Field '%s.image' holds no readable QR code", name), nil
+				}
+			}
+			if apiErr != nil {
+				apiErr.Details = maps.Clone(apiErr.Details)
+				if apiErr.Details == nil {
+					apiErr.Details = map[string]any{}
+				}
+				apiErr.Details["side"] = name
+				writeAPIError(w, r, itemErrorStatus(apiErr.Code), *apiErr)
+				return
+			}
+			if err != nil {
+				if writeContextError(w, r, err) {
+					return
+				}
+				slog.ErrorContext(r.Context(), "failed to compare QR codes", "side", name, "error", err)
+				setRequestError(r.Context(), err)
+				http.Error(w, "Failed to compare QR codes", http.StatusInternalServerError)
+				return
+			}
+			modules[i] = m
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(newCompareResponse(modules[0], modules[1], decoded[0], decoded[1]))
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCompare(t *testing.T) {
+	rec := httptest.NewRecorder()
+	newTestHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v1/qr/generate?text=hello&size=200", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d", rec.Code)
+	}
+	hello := base64.StdEncoding.EncodeToString(rec.Body.Bytes())
+	var blank bytes.Buffer
+	png.Encode(&blank, image.NewGray(image.Rect(0, 0, 64, 64)))
+
+	tests := []struct {
+		name            string
+		body            string
+		wantStatus      int
+		wantSameContent bool
+		wantSameSymbol  bool
+		wantDiff        int
+	}{
+		{name: "image and its text", body: `{"a": {"image": "` + hello + `"}, "b": {"text": "hello"}}`, wantStatus: http.StatusOK, wantSameContent: true, wantSameSymbol: true},
+		{name: "other level", body: `{"a": {"image": "` + hello + `"}, "b": {"text": "hello", "options": {"ec": "low"}}}`, wantStatus: http.StatusOK, wantSameContent: true, wantDiff: -1},
+		{name: "ECI header", body: `{"a": {"text": "hello"}, "b": {"text": "hello", "options": {"charset": "utf-8", "ec": "highest"}}}`, wantStatus: http.StatusOK, wantSameContent: true, wantDiff: -1},
+		{name: "other version", body: `{"a": {"text": "hello"}, "b": {"text": "` + strings.Repeat("hello", 10) + `"}}`, wantStatus: http.StatusOK},
+		{name: "other text", body: `{"a": {"image": "` + hello + `"}, "b": {"text": "hellO"}}`, wantStatus: http.StatusOK, wantDiff: -1},
+		{name: "missing side", body: `{"a": {"text": "hello"}}`, wantStatus: http.StatusBadRequest},
+		{name: "invalid image", body: `{"a": {"image": "aGVsbG8="}, "b": {"text": "hello"}}`, wantStatus: http.StatusBadRequest},
+		{name: "no code in image", body: `{"a": {"text": "hello"}, "b": {"image": "` + base64.StdEncoding.EncodeToString(blank.Bytes()) + `"}}`, wantStatus: http.StatusBadRequest},
+		{name: "unknown mode", body: `{"a": {"text": "hello", "options": {"mode": "ascii"}}, "b": {"text": "hello"}}`, wantStatus: http.StatusBadRequest},
+		{name: "too long", body: `{"a": {"text": "` + strings.Repeat("a", 2000) + `", "options": {"ec": "highest"}}, "b": {"text": "hello"}}`, wantStatus: http.StatusRequestEntityTooLarge},
+		{name: "invalid body", body: `[]`, wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			newTestHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v1/qr/compare", strings.NewReader(tt.body)))
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if rec.Code != http.StatusOK {
+				return
+			}
+			var got compareResponse
+			if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+				t.Fatal(err)
+			}
+			if got.SameContent != tt.wantSameContent || got.SameSymbol != tt.wantSameSymbol {
+				t.Errorf("same content %v and symbol %v, want %v and %v", got.SameContent, got.SameSymbol, tt.wantSameContent, tt.wantSameSymbol)
+			}
+			if got.A.Content != "hello" {
+				t.Errorf("a decoded to %q, want hello", got.A.Content)
+			}
+			// -1 stands for some modules
+			switch {
+			case got.A.Version != got.B.Version:
+				if got.Diff != nil {
+					t.Errorf("diff of versions %d and %d", got.A.Version, got.B.Version)
+				}
+			case got.Diff == nil || len(got.Diff.Rows) != got.Diff.Size:
+				t.Errorf("diff = %+v", got.Diff)
+			case tt.wantDiff < 0 && got.Diff.Modules == 0, tt.wantDiff >= 0 && got.Diff.Modules != tt.wantDiff:
+				t.Errorf("%d modules differ, want %d", got.Diff.Modules, tt.wantDiff)
+			}
+		})
+	}
+}
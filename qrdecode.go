@@ -0,0 +1,426 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"math"
+	"strings"
+
+	"golang.org/x/text/encoding/japanese"
+)
+
+// errQRUnreadable is returned for images and modules that hold no QR code
+// this decoder reads. It repairs no errors: a damaged symbol, or one
+// partly covered by a logo, is unreadable.
+var errQRUnreadable = errors.New("no readable QR code")
+
+// qrDecoded is the content of a symbol and how it was encoded
+type qrDecoded struct {
+	content string
+	version int
+	level   string
+	mask    int
+}
+
+// qrSampleImage reads the modules of a plain code, as rendered by this
+// service, from an image. Dark is the side of the mid luminance the corner
+// pixel isn't on, so inverted colors read the same, and transparent pixels
+// count as white. The timing patterns give the version and where modules
+// are, which must be a pixel wide at least.
+func qrSampleImage(img image.Image) ([][]bool, error) {
+	b := img.Bounds()
+	if b.Empty() {
+		return nil, errQRUnreadable
+	}
+	luminance := func(x, y int) float64 {
+		r, g, bl, a := img.At(x, y).RGBA()
+		l := 0.299*float64(r) + 0.587*float64(g) + 0.114*float64(bl)
+		return l + float64(0xffff-a)
+	}
+	low, high := math.Inf(1), math.Inf(-1)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			l := luminance(x, y)
+			low, high = min(low, l), max(high, l)
+		}
+	}
+	threshold := (low + high) / 2
+	background := luminance(b.Min.X, b.Min.Y) > threshold
+	dark := func(x, y int) bool { return (luminance(x, y) > threshold) != background }
+
+	// The dark modules span the symbol, finder patterns in three corners
+	minX, minY, maxX, maxY := b.Max.X, b.Max.Y, b.Min.X-1, b.Min.Y-1
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if dark(x, y) {
+				minX, minY, maxX, maxY = min(minX, x), min(minY, y), max(maxX, x), max(maxY, y)
+			}
+		}
+	}
+	width, height := maxX-minX+1, maxY-minY+1
+	if width < qrModules(minQRVersion) || abs(width-height) > width/20 {
+		return nil, errQRUnreadable
+	}
+
+	// The top edge of the top left finder pattern is seven modules dark,
+	// which tells about where the horizontal timing pattern runs, half a
+	// module below its sixth row
+	run := 0
+	for x := minX; x <= maxX && dark(x, minY); x++ {
+		run++
+	}
+	// Its modules round to runs a pixel apart, or a quarter module in
+	// blurred images
+	spread := 1 + run/28
+	var edgesX []qrEdge
+	for _, offset := range []int{0, -1, 1, -2, 2} {
+		y := minY + run*13/14 + offset
+		if y < minY || y > maxY {
+			continue
+		}
+		edgesX = qrTimingEdges(func(x int) bool { return dark(x, y) }, minX, maxX, spread)
+		if edgesX != nil {
+			break
+		}
+	}
+	size := len(edgesX) + 13
+	version := (size - 17) / 4
+	if edgesX == nil || version < minQRVersion || version > maxQRVersion || qrModules(version) != size {
+		return nil, errQRUnreadable
+	}
+	// Lines through the middle of the finder patterns cross five of
+	// their edges on either side
+	finderY := minY + run/2
+	edgesX = append(edgesX, qrFinderEdges(func(x int) bool { return dark(x, finderY) }, minX, maxX, size)...)
+	startX, moduleWidth := qrFitModules(edgesX, minX, maxX+1, size)
+	timingX := qrModulePixel(startX, moduleWidth, 6)
+	edgesY := qrTimingEdges(func(y int) bool { return dark(timingX, y) }, minY, maxY, spread)
+	if len(edgesY) != size-13 {
+		return nil, errQRUnreadable
+	}
+	finderX := qrModulePixel(startX, moduleWidth, 3)
+	edgesY = append(edgesY, qrFinderEdges(func(y int) bool { return dark(finderX, y) }, minY, maxY, size)...)
+	startY, moduleHeight := qrFitModules(edgesY, minY, maxY+1, size)
+
+	modules := make([][]bool, size)
+	for y := range size {
+		modules[y] = make([]bool, size)
+		py := min(qrModulePixel(startY, moduleHeight, y), maxY)
+		for x := range size {
+			modules[y][x] = dark(min(qrModulePixel(startX, moduleWidth, x), maxX), py)
+		}
+	}
+	return modules, nil
+}
+
+// qrEdge is the first pixel of a module along an axis
+type qrEdge struct {
+	module, pixel int
+}
+
+// qrTimingEdges returns the pixels from one past first to last where a
+// line through a timing pattern changes color: the edges of the modules
+// from the seventh to the last but sixth. Runs between them may differ in
+// length by spread pixels at most; otherwise the line runs elsewhere, and
+// the result is nil.
+func qrTimingEdges(dark func(int) bool, first, last, spread int) []qrEdge {
+	var edges []qrEdge
+	for p := first + 1; p <= last; p++ {
+		if dark(p) != dark(p-1) {
+			edges = append(edges, qrEdge{module: 7 + len(edges), pixel: p})
+		}
+	}
+	// Version 1 has eight modules from the seventh to the last but sixth
+	if len(edges) < 8 {
+		return nil
+	}
+	shortest, longest := last, 0
+	for i := 1; i < len(edges); i++ {
+		gap := edges[i].pixel - edges[i-1].pixel
+		shortest, longest = min(shortest, gap), max(longest, gap)
+	}
+	if longest-shortest > spread {
+		return nil
+	}
+	return edges
+}
+
+// qrFinderEdges returns the edges of modules a line through the middle of
+// the finder patterns of a symbol of size modules crosses, from one past
+// first to last: where their rings and separators start. It returns none
+// if the line doesn't cross them all.
+func qrFinderEdges(dark func(int) bool, first, last, size int) []qrEdge {
+	var pixels []int
+	for p := first + 1; p <= last; p++ {
+		if dark(p) != dark(p-1) {
+			pixels = append(pixels, p)
+		}
+	}
+	if len(pixels) < 10 {
+		return nil
+	}
+	n := len(pixels)
+	return []qrEdge{
+		{1, pixels[0]}, {2, pixels[1]}, {5, pixels[2]}, {6, pixels[3]}, {7, pixels[4]},
+		{size - 7, pixels[n-5]}, {size - 6, pixels[n-4]}, {size - 5, pixels[n-3]}, {size - 2, pixels[n-2]}, {size - 1, pixels[n-1]},
+	}
+}
+
+// qrFitModules returns where, in fractional pixels, the first of size
+// modules starts along an axis and how wide they are, from edges and the
+// pixels the symbol starts at and ends before. Renderers here color each
+// pixel by the module under its top left corner, so module i starts at the
+// first pixel at or past start + i*width; of the fits that explain the
+// most edges, the result is their mean.
+func qrFitModules(edges []qrEdge, first, end, size int) (float64, float64) {
+	edges = append(edges, qrEdge{module: size, pixel: end})
+	const steps = 64
+	var bestScore, n int
+	var sumStart, sumWidth float64
+	for i := range steps {
+		width := (float64(end-first-1) + 2*(float64(i)+0.5)/steps) / float64(size)
+		for j := range steps {
+			start := float64(first-1) + (float64(j)+0.5)/steps
+			score := 0
+			for _, edge := range edges {
+				if int(math.Ceil(start+float64(edge.module)*width)) == edge.pixel {
+					score++
+				}
+			}
+			switch {
+			case score > bestScore:
+				bestScore, n, sumStart, sumWidth = score, 1, start, width
+			case score == bestScore:
+				n++
+				sumStart += start
+				sumWidth += width
+			}
+		}
+	}
+	return sumStart / float64(n), sumWidth / float64(n)
+}
+
+// qrModulePixel is the pixel to sample module i at along an axis: inside
+// the module by as much on either side as its width allows
+func qrModulePixel(start, width float64, i int) int {
+	return int(math.Ceil(start + float64(i)*width + (width-1)/2))
+}
+
+// qrDecode reads the content of a symbol from its modules, without quiet
+// zone. The error correction codewords must match the data; errors aren't
+// repaired.
+func qrDecode(modules [][]bool) (qrDecoded, error) {
+	size := len(modules)
+	version := (size - 17) / 4
+	if version < minQRVersion || version > maxQRVersion || qrModules(version) != size {
+		return qrDecoded{}, errQRUnreadable
+	}
+
+	// Format information is the valid codeword nearest either copy; any
+	// two differ in at least seven bits, so up to three are read wrong
+	decoded := qrDecoded{version: version}
+	best := 16
+	for level := range qrFormatLevels {
+		for mask := range 8 {
+			want := qrFormatBits(level, mask)
+			var first, second int
+			for i := range 15 {
+				p := qrFormatPositions(size, i)
+				if modules[p[0][1]][p[0][0]] != (want>>i&1 == 1) {
+					first++
+				}
+				if modules[p[1][1]][p[1][0]] != (want>>i&1 == 1) {
+					second++
+				}
+			}
+			if distance := min(first, second); distance < best {
+				best, decoded.level, decoded.mask = distance, level, mask
+			}
+		}
+	}
+	if best > 3 {
+		return qrDecoded{}, errQRUnreadable
+	}
+
+	m := newQRMatrixBuilder(version, decoded.level)
+	for y := range size {
+		copy(m.modules[y], modules[y])
+	}
+	m.applyMask(decoded.mask)
+	codewords := make([]byte, qrRawCodewords(version))
+	for i, p := range m.dataPositions() {
+		if i < 8*len(codewords) && m.modules[p[1]][p[0]] {
+			codewords[i/8] |= 0x80 >> (i % 8)
+		}
+	}
+
+	// Undo the interleaving, checking the error correction of each block
+	lengths, ecLen := qrBlockLengths(version, decoded.level)
+	blocks := make([][]byte, len(lengths))
+	next := 0
+	for i := range lengths[len(lengths)-1] {
+		for j, n := range lengths {
+			if i < n {
+				blocks[j] = append(blocks[j], codewords[next])
+				next++
+			}
+		}
+	}
+	var data []byte
+	for j, block := range blocks {
+		for i, c := range qrErrorCorrection(block, ecLen) {
+			if codewords[next+i*len(blocks)+j] != c {
+				return qrDecoded{}, errQRUnreadable
+			}
+		}
+		data = append(data, block...)
+	}
+
+	content, err := qrParseSegments(data, version)
+	if err != nil {
+		return qrDecoded{}, err
+	}
+	decoded.content = content
+	return decoded, nil
+}
+
+// qrBitReader reads a bit string, most significant bit first
+type qrBitReader struct {
+	data []byte
+	pos  int
+}
+
+// read returns the next width bits, or false past the end
+func (r *qrBitReader) read(width int) (int, bool) {
+	if r.pos+width > 8*len(r.data) {
+		return 0, false
+	}
+	v := 0
+	for range width {
+		v = v<<1 | int(r.data[r.pos/8]>>(7-r.pos%8)&1)
+		r.pos++
+	}
+	return v, true
+}
+
+// qrParseSegments returns the text of the segments in the data codewords
+// of a version, up to the terminator. Byte segments are UTF-8 unless an
+// ECI header names one of qrCharsets.
+func qrParseSegments(data []byte, version int) (string, error) {
+	modes := make(map[int]string, len(qrModeIndicators))
+	for mode, indicator := range qrModeIndicators {
+		modes[indicator] = mode
+	}
+	r := &qrBitReader{data: data}
+	var text strings.Builder
+	charset := ""
+	for {
+		indicator, ok := r.read(4)
+		if !ok || indicator == 0 {
+			// The terminator may be cut short when the data is full
+			return text.String(), nil
+		}
+		if indicator == qrModeECI {
+			designator, ok := r.read(8)
+			// Longer designators start with 10 or 110, and name none of ours
+			if !ok || designator&0x80 != 0 {
+				return "", fmt.Errorf("%w: unsupported ECI", errQRUnreadable)
+			}
+			charset = ""
+			for name, c := range qrCharsets {
+				if c.eci == designator {
+					charset = name
+				}
+			}
+			if charset == "" {
+				return "", fmt.Errorf("%w: unsupported ECI %d", errQRUnreadable, designator)
+			}
+			continue
+		}
+		mode, known := modes[indicator]
+		if !known {
+			return "", fmt.Errorf("%w: unsupported mode %d", errQRUnreadable, indicator)
+		}
+		n, ok := r.read(qrCharCountBits(mode, version))
+		if !ok {
+			return "", errQRUnreadable
+		}
+		segment, ok := qrReadSegment(r, mode, n)
+		if !ok {
+			return "", errQRUnreadable
+		}
+		if mode == qrModeByte && charset != "" {
+			converted, err := qrCharsets[charset].encoding.NewDecoder().String(segment)
+			if err != nil {
+				return "", fmt.Errorf("%w: invalid %s", errQRUnreadable, charset)
+			}
+			segment = converted
+		}
+		text.WriteString(segment)
+	}
+}
+
+// qrReadSegment reads n characters of a segment in mode, the inverse of
+// qrBits.appendSegment. Byte segments are returned as read.
+func qrReadSegment(r *qrBitReader, mode string, n int) (string, bool) {
+	var text strings.Builder
+	switch mode {
+	case qrModeNumeric:
+		for n > 0 {
+			digits := min(n, 3)
+			v, ok := r.read([]int{0, 4, 7, 10}[digits])
+			if !ok || v >= []int{1, 10, 100, 1000}[digits] {
+				return "", false
+			}
+			fmt.Fprintf(&text, "%0*d", digits, v)
+			n -= digits
+		}
+	case qrModeAlphanumeric:
+		for ; n >= 2; n -= 2 {
+			v, ok := r.read(11)
+			if !ok || v >= 45*45 {
+				return "", false
+			}
+			text.WriteByte(qrAlphanumeric[v/45])
+			text.WriteByte(qrAlphanumeric[v%45])
+		}
+		if n == 1 {
+			v, ok := r.read(6)
+			if !ok || v >= 45 {
+				return "", false
+			}
+			text.WriteByte(qrAlphanumeric[v])
+		}
+	case qrModeByte:
+		for range n {
+			v, ok := r.read(8)
+			if !ok {
+				return "", false
+			}
+			text.WriteByte(byte(v))
+		}
+	case qrModeKanji:
+		// 13-bit codes expand back to the two bytes of Shift-JIS
+		sjis := make([]byte, 0, 2*n)
+		for range n {
+			v, ok := r.read(13)
+			if !ok {
+				return "", false
+			}
+			code := v/0xc0<<8 | v%0xc0
+			if code < 0x1f00 {
+				code += 0x8140
+			} else {
+				code += 0xc140
+			}
+			sjis = append(sjis, byte(code>>8), byte(code))
+		}
+		decoded, err := japanese.ShiftJIS.NewDecoder().Bytes(sjis)
+		if err != nil {
+			return "", false
+		}
+		text.Write(decoded)
+	}
+	return text.String(), true
+}
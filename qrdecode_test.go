@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// withoutQuietZone returns the modules of a bitmap inside the quiet zone
+func withoutQuietZone(bitmap [][]bool) [][]bool {
+	size := len(bitmap) - 2*qrQuietZone
+	modules := make([][]bool, size)
+	for y := range size {
+		modules[y] = bitmap[y+qrQuietZone][qrQuietZone : size+qrQuietZone]
+	}
+	return modules
+}
+
+func TestQRDecode(t *testing.T) {
+	tests := []struct {
+		name                string
+		text, mode, charset string
+	}{
+		{name: "numeric", text: "0123456789012", mode: qrModeNumeric},
+		{name: "alphanumeric", text: "HTTPS://EXAMPLE.COM/A-1", mode: qrModeAlphanumeric},
+		{name: "byte", text: "hello, wörld", mode: qrModeByte},
+		{name: "kanji", text: "点茗漢字", mode: qrModeKanji},
+		{name: "UTF-8", text: "naïve", mode: qrModeByte, charset: "utf-8"},
+		{name: "Latin-1", text: "naïve", mode: qrModeByte, charset: "iso-8859-1"},
+		{name: "Shift-JIS", text: "点茗", mode: qrModeByte, charset: "shift_jis"},
+		{name: "many blocks with version information", text: strings.Repeat("a1-", 200), mode: qrModeByte},
+	}
+
+	for _, tt := range tests {
+		for level := range qrFormatLevels {
+			t.Run(tt.name+" "+level, func(t *testing.T) {
+				bitmap, version, err := qrEncode(tt.text, tt.mode, tt.charset, level)
+				if err != nil {
+					t.Fatal(err)
+				}
+				got, err := qrDecode(withoutQuietZone(bitmap))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got.content != tt.text || got.version != version || got.level != level {
+					t.Errorf("decoded %q at version %d %s, want %q at version %d %s", got.content, got.version, got.level, tt.text, version, level)
+				}
+			})
+		}
+	}
+
+	t.Run("mixed segments from go-qrcode", func(t *testing.T) {
+		text := "ORDER 12345678901234567890 for jürgen@example.com"
+		img, err := (&QRCodeGenerator{}).EncodeWith(context.Background(), text, defaultRenderOptions)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := qrDecode(withoutQuietZone(img.bitmap))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.content != text {
+			t.Errorf("content = %q, want %q", got.content, text)
+		}
+	})
+
+	t.Run("damaged", func(t *testing.T) {
+		bitmap, _, err := qrEncode("hello", qrModeByte, "", "medium")
+		if err != nil {
+			t.Fatal(err)
+		}
+		modules := withoutQuietZone(bitmap)
+		// A data module in the bottom right corner
+		modules[20][20] = !modules[20][20]
+		if _, err := qrDecode(modules); !errors.Is(err, errQRUnreadable) {
+			t.Errorf("error = %v, want %v", err, errQRUnreadable)
+		}
+	})
+}
+
+func TestQRSampleImage(t *testing.T) {
+	tests := []struct {
+		name   string
+		text   string
+		params string
+	}{
+		{name: "default", text: "hello"},
+		{name: "colors", text: "hello", params: "&fg=1a237e&bg=fff59d&size=100"},
+		{name: "inverted colors", text: "hello", params: "&fg=ffffff&bg=000000&size=300"},
+		{name: "one pixel per module", text: strings.Repeat("a", 200), params: "&size=64"},
+		{name: "large version", text: strings.Repeat("7", 2000), params: "&size=2048&ec=low"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			newTestHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v1/qr/generate?text="+tt.text+tt.params, nil))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+			}
+			img, err := png.Decode(rec.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			modules, err := qrSampleImage(img)
+			if err != nil {
+				t.Fatal(err)
+			}
+			decoded, err := qrDecode(modules)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if decoded.content != tt.text {
+				t.Errorf("content = %q, want %q", decoded.content, tt.text)
+			}
+		})
+	}
+
+	t.Run("blank", func(t *testing.T) {
+		img := image.NewGray(image.Rect(0, 0, 64, 64))
+		for i := range img.Pix {
+			img.Pix[i] = 0xff
+		}
+		img.Set(10, 10, color.Black)
+		if _, err := qrSampleImage(img); !errors.Is(err, errQRUnreadable) {
+			t.Errorf("error = %v, want %v", err, errQRUnreadable)
+		}
+	})
+}
@@ -0,0 +1,553 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/encoding/unicode"
+)
+
+// qrModeECI is the indicator of Extended Channel Interpretation headers,
+// which tell scanners the character set of the segments that follow
+const qrModeECI = 7
+
+// qrCharsets are the character sets byte segments can be encoded in, by
+// the charset parameter, with their ECI designators. Without one, text is
+// UTF-8 with no header, which most scanners guess.
+var qrCharsets = map[string]struct {
+	eci      int
+	encoding encoding.Encoding
+}{
+	"utf-8":      {26, unicode.UTF8},
+	"iso-8859-1": {3, charmap.ISO8859_1},
+	"shift_jis":  {20, japanese.ShiftJIS},
+}
+
+// qrModeIndicators are the 4-bit indicators that start segments of each
+// mode
+var qrModeIndicators = map[string]int{qrModeNumeric: 1, qrModeAlphanumeric: 2, qrModeByte: 4, qrModeKanji: 8}
+
+// qrFormatLevels are the bits of error correction levels in format
+// information, which don't follow their order
+var qrFormatLevels = map[string]int{"low": 1, "medium": 0, "high": 3, "highest": 2}
+
+// qrBits is a bit string, most significant bit first
+type qrBits []bool
+
+// append appends the width low bits of value
+func (b *qrBits) append(value, width int) {
+	for i := width - 1; i >= 0; i-- {
+		*b = append(*b, value>>i&1 == 1)
+	}
+}
+
+// appendSegment appends text as a segment in mode at version. Text must be
+// encodable in mode.
+func (b *qrBits) appendSegment(text, mode string, version int) {
+	n, _ := qrCharacters(text, mode)
+	b.append(qrModeIndicators[mode], 4)
+	b.append(n, qrCharCountBits(mode, version))
+	switch mode {
+	case qrModeNumeric:
+		// Groups of three digits take 10 bits, a trailing two 7 and one 4
+		for i := 0; i < len(text); i += 3 {
+			group := text[i:min(i+3, len(text))]
+			v, _ := strconv.Atoi(group)
+			b.append(v, []int{0, 4, 7, 10}[len(group)])
+		}
+	case qrModeAlphanumeric:
+		// Pairs of characters take 11 bits, a trailing one 6
+		for i := 0; i < len(text); i += 2 {
+			v := strings.IndexByte(qrAlphanumeric, text[i])
+			if i+1 == len(text) {
+				b.append(v, 6)
+				break
+			}
+			b.append(45*v+strings.IndexByte(qrAlphanumeric, text[i+1]), 11)
+		}
+	case qrModeByte:
+		for i := range len(text) {
+			b.append(int(text[i]), 8)
+		}
+	case qrModeKanji:
+		// Shift-JIS codes are compacted to 13 bits
+		sjis, _ := japanese.ShiftJIS.NewEncoder().String(text)
+		for i := 0; i+1 < len(sjis); i += 2 {
+			code := int(sjis[i])<<8 | int(sjis[i+1])
+			if code <= 0x9ffc {
+				code -= 0x8140
+			} else {
+				code -= 0xc140
+			}
+			b.append(code>>8*0xc0+code&0xff, 13)
+		}
+	}
+}
+
+// qrEncode encodes text as a single segment in mode at the smallest version
+// that holds it at an error correction level, and returns the modules of
+// the symbol, quiet zone included, and its version. Unlike go-qrcode, which
+// picks modes itself, it encodes exactly the mode asked for.
+func qrEncode(text, mode, charset, level string) ([][]bool, int, error) {
+	bits, version, err := qrDataBits(text, mode, charset, level)
+	if err != nil {
+		return nil, 0, err
+	}
+	return qrSymbol(qrCodewords(bits, version, level), version, level), version, nil
+}
+
+// qrDataBits returns the bits of text as a segment in mode and the smallest
+// version that holds them at level. With a charset, an ECI header leads the
+// segment, and byte segments hold text in that character set.
+func qrDataBits(text, mode, charset, level string) (qrBits, int, error) {
+	headerBits := 0
+	if charset != "" {
+		c := qrCharsets[charset]
+		if mode == qrModeByte {
+			converted, err := c.encoding.NewEncoder().String(text)
+			if err != nil {
+				return nil, 0, fmt.Errorf("%w: %s", errTextNotInCharset, charset)
+			}
+			text = converted
+		}
+		headerBits = 4 + 8
+	}
+	n, ok := qrCharacters(text, mode)
+	if !ok {
+		return nil, 0, fmt.Errorf("%w: %s", errTextNotInMode, mode)
+	}
+	version := 0
+	for v := minQRVersion; v <= maxQRVersion && version == 0; v++ {
+		if n <= qrCapacity(mode, v, level) && headerBits+qrSegmentBits(mode, n, v) <= 8*qrDataCodewords(v, level) {
+			version = v
+		}
+	}
+	if version == 0 {
+		return nil, 0, fmt.Errorf("%w: %d bytes", errPayloadTooLarge, len(text))
+	}
+	var bits qrBits
+	if charset != "" {
+		// Designators below 128 take one byte
+		bits.append(qrModeECI, 4)
+		bits.append(qrCharsets[charset].eci, 8)
+	}
+	bits.appendSegment(text, mode, version)
+	return bits, version, nil
+}
+
+// qrCodewords pads the data bits to the data codewords of a version and
+// level, splits them into blocks, and returns the data and error correction
+// codewords of the blocks interleaved, in the order they are placed
+func qrCodewords(bits qrBits, version int, level string) []byte {
+	capacity := 8 * qrDataCodewords(version, level)
+	// A terminator of up to four zero bits, then zeros to a whole byte
+	bits.append(0, min(4, capacity-len(bits)))
+	bits.append(0, -len(bits)&7)
+	data := make([]byte, capacity/8)
+	for i, bit := range bits {
+		if bit {
+			data[i/8] |= 0x80 >> (i % 8)
+		}
+	}
+	for i := len(bits) / 8; i < len(data); i++ {
+		data[i] = []byte{0xec, 0x11}[(i-len(bits)/8)%2]
+	}
+
+	lengths, ecLen := qrBlockLengths(version, level)
+	dataBlocks, ecBlocks := make([][]byte, len(lengths)), make([][]byte, len(lengths))
+	for i, offset := 0, 0; i < len(lengths); i++ {
+		dataBlocks[i] = data[offset : offset+lengths[i]]
+		ecBlocks[i] = qrErrorCorrection(dataBlocks[i], ecLen)
+		offset += lengths[i]
+	}
+	codewords := make([]byte, 0, qrRawCodewords(version))
+	for i := range lengths[len(lengths)-1] {
+		for _, block := range dataBlocks {
+			if i < len(block) {
+				codewords = append(codewords, block[i])
+			}
+		}
+	}
+	for i := range ecLen {
+		for _, block := range ecBlocks {
+			codewords = append(codewords, block[i])
+		}
+	}
+	return codewords
+}
+
+// qrBlockLengths returns the number of data codewords in each block of a
+// version and level, and of error correction codewords in every block.
+// Blocks share the data codewords evenly; the later ones take one more when
+// they don't divide.
+func qrBlockLengths(version int, level string) ([]int, int) {
+	l := qrLevelIndex[level]
+	blocks, ecLen := qrECBlocks[l][version], qrECCodewordsPerBlock[l][version]
+	raw := qrRawCodewords(version)
+	lengths := make([]int, blocks)
+	for i := range lengths {
+		lengths[i] = raw/blocks - ecLen
+		if i >= blocks-raw%blocks {
+			lengths[i]++
+		}
+	}
+	return lengths, ecLen
+}
+
+// qrMultiply multiplies in GF(256) with the QR polynomial
+// x^8 + x^4 + x^3 + x^2 + 1
+func qrMultiply(a, b byte) byte {
+	var product byte
+	for ; b > 0; b >>= 1 {
+		if b&1 != 0 {
+			product ^= a
+		}
+		carry := a & 0x80
+		a <<= 1
+		if carry != 0 {
+			a ^= 0x1d
+		}
+	}
+	return product
+}
+
+// qrErrorCorrection returns the n Reed-Solomon error correction codewords
+// of a block: the remainder of its division by the generator polynomial
+// whose roots are the first n powers of 2
+func qrErrorCorrection(data []byte, n int) []byte {
+	// The generator's coefficients, highest first, without the leading 1
+	generator := make([]byte, n)
+	generator[n-1] = 1
+	root := byte(1)
+	for range n {
+		for j := range generator {
+			generator[j] = qrMultiply(generator[j], root)
+			if j+1 < n {
+				generator[j] ^= generator[j+1]
+			}
+		}
+		root = qrMultiply(root, 2)
+	}
+
+	remainder := make([]byte, n)
+	for _, b := range data {
+		factor := b ^ remainder[0]
+		copy(remainder, remainder[1:])
+		remainder[n-1] = 0
+		for j, c := range generator {
+			remainder[j] ^= qrMultiply(c, factor)
+		}
+	}
+	return remainder
+}
+
+// qrMatrixBuilder builds symbols: modules, and which of them belong to
+// function patterns rather than data
+type qrMatrixBuilder struct {
+	size              int
+	modules, function [][]bool
+}
+
+// set sets a function module
+func (m *qrMatrixBuilder) set(x, y int, dark bool) {
+	m.modules[y][x] = dark
+	m.function[y][x] = true
+}
+
+// newQRMatrixBuilder returns a builder with the function patterns of a
+// version and level drawn, format information with mask 0
+func newQRMatrixBuilder(version int, level string) *qrMatrixBuilder {
+	size := qrModules(version)
+	m := &qrMatrixBuilder{size: size, modules: make([][]bool, size), function: make([][]bool, size)}
+	for y := range size {
+		m.modules[y], m.function[y] = make([]bool, size), make([]bool, size)
+	}
+
+	// Timing patterns, overlapped by the finder and alignment patterns
+	for i := range size {
+		m.set(6, i, i%2 == 0)
+		m.set(i, 6, i%2 == 0)
+	}
+	// Finder patterns with their separators in three corners
+	for _, c := range [][2]int{{3, 3}, {size - 4, 3}, {3, size - 4}} {
+		for dy := -4; dy <= 4; dy++ {
+			for dx := -4; dx <= 4; dx++ {
+				x, y := c[0]+dx, c[1]+dy
+				if x < 0 || x >= size || y < 0 || y >= size {
+					continue
+				}
+				d := max(abs(dx), abs(dy))
+				m.set(x, y, d != 2 && d != 4)
+			}
+		}
+	}
+	// Alignment patterns on a grid, except where finder patterns are
+	positions := qrAlignmentPositions(version)
+	last := len(positions) - 1
+	for i, cy := range positions {
+		for j, cx := range positions {
+			if i == 0 && j == 0 || i == 0 && j == last || i == last && j == 0 {
+				continue
+			}
+			for dy := -2; dy <= 2; dy++ {
+				for dx := -2; dx <= 2; dx++ {
+					m.set(cx+dx, cy+dy, max(abs(dx), abs(dy)) != 1)
+				}
+			}
+		}
+	}
+	// Format information is reserved now and written with the mask
+	m.drawFormat(level, 0)
+	if version >= 7 {
+		m.drawVersion(version)
+	}
+	return m
+}
+
+// dataPositions returns the data modules, as x, y, in the order bits are
+// placed: in two-module columns from the bottom right, up and down in turn,
+// skipping the vertical timing pattern
+func (m *qrMatrixBuilder) dataPositions() [][2]int {
+	var positions [][2]int
+	for right := m.size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+		upward := (right+1)&2 == 0
+		for vert := range m.size {
+			y := vert
+			if upward {
+				y = m.size - 1 - vert
+			}
+			for x := right; x > right-2; x-- {
+				if !m.function[y][x] {
+					positions = append(positions, [2]int{x, y})
+				}
+			}
+		}
+	}
+	return positions
+}
+
+// qrSymbol lays out the codewords of a version and level with the mask of
+// the lowest penalty, and returns its modules with the quiet zone
+func qrSymbol(codewords []byte, version int, level string) [][]bool {
+	m := newQRMatrixBuilder(version, level)
+	size := m.size
+	for i, p := range m.dataPositions() {
+		if i < 8*len(codewords) {
+			m.modules[p[1]][p[0]] = codewords[i/8]&(0x80>>(i%8)) != 0
+		}
+	}
+
+	best, bestPenalty := -1, 0
+	for mask := range 8 {
+		m.applyMask(mask)
+		m.drawFormat(level, mask)
+		if penalty := qrPenalty(m.modules); best < 0 || penalty < bestPenalty {
+			best, bestPenalty = mask, penalty
+		}
+		// Masks are their own inverse
+		m.applyMask(mask)
+	}
+	m.applyMask(best)
+	m.drawFormat(level, best)
+
+	bitmap := make([][]bool, size+2*qrQuietZone)
+	for y := range bitmap {
+		bitmap[y] = make([]bool, size+2*qrQuietZone)
+		if y >= qrQuietZone && y < size+qrQuietZone {
+			copy(bitmap[y][qrQuietZone:], m.modules[y-qrQuietZone])
+		}
+	}
+	return bitmap
+}
+
+// qrAlignmentPositions are the centers of alignment patterns of a version
+// on each axis, evenly spaced from 6 to the last but six module
+func qrAlignmentPositions(version int) []int {
+	if version == 1 {
+		return nil
+	}
+	n := version/7 + 2
+	step := (8*version + 3*n + 5) / (4*n - 4) * 2
+	positions := make([]int, n)
+	positions[0] = 6
+	for i, p := n-1, qrModules(version)-7; i > 0; i, p = i-1, p-step {
+		positions[i] = p
+	}
+	return positions
+}
+
+// drawFormat writes the level and mask with their BCH code twice: around
+// the top left finder pattern, and split below the top right and beside
+// the bottom left one, with the dark module
+func (m *qrMatrixBuilder) drawFormat(level string, mask int) {
+	bits := qrFormatBits(level, mask)
+	for i := range 15 {
+		for _, p := range qrFormatPositions(m.size, i) {
+			m.set(p[0], p[1], bits>>i&1 == 1)
+		}
+	}
+	m.set(8, m.size-8, true)
+}
+
+// qrFormatBits are the 15 bits of format information of a level and mask,
+// with their BCH code and masked
+func qrFormatBits(level string, mask int) int {
+	data := qrFormatLevels[level]<<3 | mask
+	remainder := data
+	for range 10 {
+		remainder = remainder<<1 ^ remainder>>9*0x537
+	}
+	return (data<<10 | remainder) ^ 0x5412
+}
+
+// qrFormatPositions are the two modules, as x, y, that bit i of format
+// information is written to in a symbol of size modules
+func qrFormatPositions(size, i int) [2][2]int {
+	var first, second [2]int
+	switch {
+	case i < 6:
+		first = [2]int{8, i}
+	case i < 8:
+		// Skipping the horizontal timing pattern
+		first = [2]int{8, i + 1}
+	case i == 8:
+		first = [2]int{7, 8}
+	default:
+		first = [2]int{14 - i, 8}
+	}
+	if i < 8 {
+		second = [2]int{size - 1 - i, 8}
+	} else {
+		second = [2]int{8, size - 15 + i}
+	}
+	return [2][2]int{first, second}
+}
+
+// drawVersion writes the version with its BCH code in the blocks beside
+// the bottom left and above the top right finder patterns of versions 7
+// and up
+func (m *qrMatrixBuilder) drawVersion(version int) {
+	remainder := version
+	for range 12 {
+		remainder = remainder<<1 ^ remainder>>11*0x1f25
+	}
+	bits := version<<12 | remainder
+	for i := range 18 {
+		dark := bits>>i&1 == 1
+		a, b := m.size-11+i%3, i/3
+		m.set(a, b, dark)
+		m.set(b, a, dark)
+	}
+}
+
+// applyMask inverts the data modules the mask pattern selects
+func (m *qrMatrixBuilder) applyMask(mask int) {
+	for y := range m.size {
+		for x := range m.size {
+			if m.function[y][x] {
+				continue
+			}
+			var invert bool
+			switch mask {
+			case 0:
+				invert = (x+y)%2 == 0
+			case 1:
+				invert = y%2 == 0
+			case 2:
+				invert = x%3 == 0
+			case 3:
+				invert = (x+y)%3 == 0
+			case 4:
+				invert = (x/3+y/2)%2 == 0
+			case 5:
+				invert = x*y%2+x*y%3 == 0
+			case 6:
+				invert = (x*y%2+x*y%3)%2 == 0
+			case 7:
+				invert = ((x+y)%2+x*y%3)%2 == 0
+			}
+			m.modules[y][x] = m.modules[y][x] != invert
+		}
+	}
+}
+
+// qrPenalty scores how hard modules are to scan by the four rules of the
+// standard: runs of five or more modules of a color in a row or column,
+// 2x2 blocks of a color, finder-like patterns, and an imbalance of dark
+// and light modules
+func qrPenalty(modules [][]bool) int {
+	size := len(modules)
+	at := func(x, y int, transposed bool) bool {
+		if transposed {
+			return modules[x][y]
+		}
+		return modules[y][x]
+	}
+	finderLike := [2][11]bool{
+		{true, false, true, true, true, false, true, false, false, false, false},
+		{false, false, false, false, true, false, true, true, true, false, true},
+	}
+
+	penalty := 0
+	for _, transposed := range []bool{false, true} {
+		for y := range size {
+			run := 0
+			for x := range size {
+				if x > 0 && at(x, y, transposed) == at(x-1, y, transposed) {
+					run++
+				} else {
+					run = 1
+				}
+				if run == 5 {
+					penalty += 3
+				} else if run > 5 {
+					penalty++
+				}
+			}
+			for x := 0; x+11 <= size; x++ {
+				for _, pattern := range finderLike {
+					matches := true
+					for i, dark := range pattern {
+						if at(x+i, y, transposed) != dark {
+							matches = false
+							break
+						}
+					}
+					if matches {
+						penalty += 40
+					}
+				}
+			}
+		}
+	}
+
+	dark := 0
+	for y := range size {
+		for x := range size {
+			if modules[y][x] {
+				dark++
+			}
+			if x+1 < size && y+1 < size && modules[y][x] == modules[y][x+1] && modules[y][x] == modules[y+1][x] && modules[y][x] == modules[y+1][x+1] {
+				penalty += 3
+			}
+		}
+	}
+	// 10 for every 5% the dark modules deviate from half, rounded down
+	total := size * size
+	return penalty + 10*(abs(20*dark-10*total)/total)
+}
+
+// abs is the absolute value of n
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
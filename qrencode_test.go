@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/boombuler/barcode/qr"
+)
+
+func TestQRBits_AppendSegment(t *testing.T) {
+	// The examples of ISO/IEC 18004, at version 1
+	tests := []struct {
+		mode string
+		text string
+		want string
+	}{
+		{mode: qrModeNumeric, text: "01234567", want: "0001 0000001000 0000001100 0101011001 1000011"},
+		{mode: qrModeAlphanumeric, text: "AC-42", want: "0010 000000101 00111001110 11100111001 000010"},
+		{mode: qrModeByte, text: "hé", want: "0100 00000011 01101000 11000011 10101001"},
+		{mode: qrModeKanji, text: "点茗", want: "1000 00000010 0110110011111 1101010101010"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			var bits qrBits
+			bits.appendSegment(tt.text, tt.mode, 1)
+			var got strings.Builder
+			for _, bit := range bits {
+				if bit {
+					got.WriteByte('1')
+				} else {
+					got.WriteByte('0')
+				}
+			}
+			if want := strings.ReplaceAll(tt.want, " ", ""); got.String() != want {
+				t.Errorf("bits = %s, want %s", got.String(), want)
+			}
+		})
+	}
+}
+
+// TestQREncode_MatchesBoombuler checks whole symbols, error correction,
+// layout, and mask choice included, against another encoder that takes
+// explicit modes
+func TestQREncode_MatchesBoombuler(t *testing.T) {
+	levels := map[string]qr.ErrorCorrectionLevel{"low": qr.L, "medium": qr.M, "high": qr.Q, "highest": qr.H}
+	modes := []struct {
+		mode     string
+		encoding qr.Encoding
+		chars    string
+	}{
+		{qrModeNumeric, qr.Numeric, "0123456789"},
+		{qrModeAlphanumeric, qr.AlphaNumeric, "HTTPS://EXAMPLE.COM/A-1 $%*+"},
+		{qrModeByte, qr.Unicode, "hello, world! "},
+	}
+
+	for _, m := range modes {
+		for level, l := range levels {
+			// Lengths reaching versions with one to many blocks, and with
+			// version information
+			for _, n := range []int{1, 17, 100, 700, 1200} {
+				text := strings.Repeat(m.chars, n/len(m.chars)+1)[:n]
+				want, err := qr.Encode(text, l, m.encoding)
+				if err != nil {
+					continue
+				}
+				bitmap, version, err := qrEncode(text, m.mode, "", level)
+				if err != nil {
+					t.Fatalf("%s %s %d: %v", m.mode, level, n, err)
+				}
+				size := want.Bounds().Dx()
+				if len(bitmap) != size+2*qrQuietZone {
+					t.Fatalf("%s %s %d: version %d has %d modules, want %d", m.mode, level, n, version, len(bitmap)-2*qrQuietZone, size)
+				}
+				for y := range size {
+					for x := range size {
+						r, _, _, _ := want.At(x, y).RGBA()
+						if bitmap[y+qrQuietZone][x+qrQuietZone] != (r < 0x8000) {
+							t.Fatalf("%s %s %d: module (%d, %d) differs", m.mode, level, n, x, y)
+						}
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestQREncode(t *testing.T) {
+	tests := []struct {
+		name                string
+		text, mode, charset string
+		wantVersion         int
+		wantErr             error
+	}{
+		{name: "numeric", text: strings.Repeat("7", 41), mode: qrModeNumeric, wantVersion: 2},
+		{name: "numeric as bytes", text: strings.Repeat("7", 41), mode: qrModeByte, wantVersion: 3},
+		{name: "kanji", text: "点茗", mode: qrModeKanji, wantVersion: 1},
+		{name: "letters in numeric", text: "12a", mode: qrModeNumeric, wantErr: errTextNotInMode},
+		{name: "lowercase in alphanumeric", text: "Hello", mode: qrModeAlphanumeric, wantErr: errTextNotInMode},
+		{name: "too long", text: strings.Repeat("1", 7090), mode: qrModeNumeric, wantErr: errPayloadTooLarge},
+		{name: "full version 1", text: strings.Repeat("a", 14), mode: qrModeByte, wantVersion: 1},
+		{name: "ECI header outgrows version 1", text: strings.Repeat("a", 14), mode: qrModeByte, charset: "utf-8", wantVersion: 2},
+		{name: "Latin-1 takes a byte per character", text: strings.Repeat("é", 14), mode: qrModeByte, charset: "iso-8859-1", wantVersion: 2},
+		{name: "not in Latin-1", text: "5 €", mode: qrModeByte, charset: "iso-8859-1", wantErr: errTextNotInCharset},
+		{name: "not in Shift-JIS", text: "naïve", mode: qrModeByte, charset: "shift_jis", wantErr: errTextNotInCharset},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bitmap, version, err := qrEncode(tt.text, tt.mode, tt.charset, "medium")
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if version != tt.wantVersion || len(bitmap) != qrModules(version)+2*qrQuietZone {
+				t.Errorf("version %d with %d modules, want version %d", version, len(bitmap), tt.wantVersion)
+			}
+		})
+	}
+}
+
+func TestQRDataBits_ECI(t *testing.T) {
+	tests := []struct {
+		name          string
+		text, charset string
+		want          string
+	}{
+		{name: "no header", text: "é", want: "0100 00000010 11000011 10101001"},
+		{name: "UTF-8", text: "é", charset: "utf-8", want: "0111 00011010 0100 00000010 11000011 10101001"},
+		{name: "Latin-1", text: "é", charset: "iso-8859-1", want: "0111 00000011 0100 00000001 11101001"},
+		{name: "Shift-JIS", text: "点", charset: "shift_jis", want: "0111 00010100 0100 00000010 10010011 01011111"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bits, version, err := qrDataBits(tt.text, qrModeByte, tt.charset, "medium")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if version != 1 {
+				t.Errorf("version = %d, want 1", version)
+			}
+			var got strings.Builder
+			for _, bit := range bits {
+				if bit {
+					got.WriteByte('1')
+				} else {
+					got.WriteByte('0')
+				}
+			}
+			if want := strings.ReplaceAll(tt.want, " ", ""); got.String() != want {
+				t.Errorf("bits = %s, want %s", got.String(), want)
+			}
+		})
+	}
+}
+
+func TestGenerate_Mode(t *testing.T) {
+	tests := []struct {
+		name       string
+		query      string
+		wantStatus int
+	}{
+		{name: "numeric", query: "text=0123456789&mode=numeric", wantStatus: http.StatusOK},
+		{name: "kanji", query: "text=%E7%82%B9%E8%8C%97&mode=kanji&format=svg", wantStatus: http.StatusOK},
+		{name: "not numeric", query: "text=hello&mode=numeric", wantStatus: http.StatusBadRequest},
+		{name: "unknown mode", query: "text=hello&mode=ascii", wantStatus: http.StatusBadRequest},
+		{name: "Shift-JIS", query: "text=%E7%82%B9%E8%8C%97&charset=Shift_JIS", wantStatus: http.StatusOK},
+		{name: "Latin-1 numeric", query: "text=0123&mode=numeric&charset=iso-8859-1", wantStatus: http.StatusOK},
+		{name: "not in Latin-1", query: "text=%E7%82%B9&charset=iso-8859-1", wantStatus: http.StatusBadRequest},
+		{name: "unknown charset", query: "text=hello&charset=ebcdic", wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			newTestHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v1/qr/generate?"+tt.query, nil))
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+		})
+	}
+
+	t.Run("smaller than bytes", func(t *testing.T) {
+		opts := defaultRenderOptions
+		opts.mode = qrModeNumeric
+		numeric, err := (&QRCodeGenerator{}).EncodeWith(context.Background(), strings.Repeat("7", 41), opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		opts.mode = qrModeByte
+		bytes, err := (&QRCodeGenerator{}).EncodeWith(context.Background(), strings.Repeat("7", 41), opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if numeric.version >= bytes.version {
+			t.Errorf("numeric mode takes version %d, byte mode %d", numeric.version, bytes.version)
+		}
+	})
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// Resolutions the info endpoint estimates physical sizes at
+const (
+	defaultInfoDPI = 300
+	minInfoDPI     = 72
+	maxInfoDPI     = 2400
+)
+
+// infoResponse is the body of GET /api/v1/qr/info: the symbol the generate
+// endpoint would render for the same parameters, and its printed size
+type infoResponse struct {
+	Version   int    `json:"version"`
+	Level     string `json:"ec"`
+	Mode      string `json:"mode"`
+	Charset   string `json:"charset,omitempty"`
+	Modules   int    `json:"modules"`
+	QuietZone int    `json:"quiet_zone"`
+	Format    string `json:"format"`
+	// SizePixels is the width of the image, which PNGs widen to one pixel
+	// per module when size is smaller
+	SizePixels   int     `json:"size_px"`
+	DPI          int     `json:"dpi"`
+	ModulePixels float64 `json:"module_px"`
+	ModuleMM     float64 `json:"module_mm"`
+	WidthMM      float64 `json:"width_mm"`
+	WidthInches  float64 `json:"width_in"`
+}
+
+// parseInfoDPI reads the dpi parameter, defaultInfoDPI if absent
+func parseInfoDPI(query url.Values) (int, *apiError) {
+	raw := query.Get("dpi")
+	if raw == "" {
+		return defaultInfoDPI, nil
+	}
+	dpi, err := strconv.Atoi(raw)
+	if err != nil || dpi < minInfoDPI || dpi > maxInfoDPI {
+		return 0, apiErrorf(errCodeInvalidParam, nil, "Parameter 'dpi' must be from %d to %d", minInfoDPI, maxInfoDPI)
+	}
+	return dpi, nil
+}
+
+// newInfoResponse describes img, encoded with opts, printed at dpi
+func newInfoResponse(img *qrImage, opts renderOptions, dpi int) infoResponse {
+	modules := qrModules(img.version)
+	size := opts.size
+	if side := modules + 2*qrQuietZone; opts.format == formatPNG && size < side {
+		size = side
+	}
+	width := float64(size) / float64(dpi)
+	// Sizes are rounded to hundredths, finer than any printer resolves
+	round := func(x float64) float64 { return math.Round(x*100) / 100 }
+	mode := opts.mode
+	if mode == qrModeAuto {
+		mode = qrTextMode(img.content)
+	}
+	return infoResponse{
+		Version:      img.version,
+		Level:        opts.level,
+		Mode:         mode,
+		Charset:      opts.charset,
+		Modules:      modules,
+		QuietZone:    qrQuietZone,
+		Format:       opts.format,
+		SizePixels:   size,
+		DPI:          dpi,
+		ModulePixels: round(float64(size) / float64(modules+2*qrQuietZone)),
+		ModuleMM:     round(width * 25.4 / float64(modules+2*qrQuietZone)),
+		WidthMM:      round(width * 25.4),
+		WidthInches:  round(width),
+	}
+}
+
+// handleInfo answers which symbol the generate endpoint would render for
+// the text, size, ec, mode, charset, and format parameters, and how large it prints
+// at the dpi parameter, without rendering it
+func handleInfo(deps handlerDeps) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		text := query.Get("text")
+		if text == "" {
+			writeAPIError(w, r, http.StatusBadRequest, apiError{Code: errCodeInvalidParam, Message: "Missing required parameter 'text'"})
+			return
+		}
+		opts, apiErr := parseRenderOptions(query)
+		if apiErr != nil {
+			writeAPIError(w, r, http.StatusBadRequest, *apiErr)
+			return
+		}
+		dpi, apiErr := parseInfoDPI(query)
+		if apiErr != nil {
+			writeAPIError(w, r, http.StatusBadRequest, *apiErr)
+			return
+		}
+		if status, apiErr := validatePayload(text, deps.maxPayloadLength); apiErr != nil {
+			writeAPIError(w, r, status, *apiErr)
+			return
+		}
+
+		// Encoding is cheap next to rendering, which is skipped
+		img, err := deps.qrGen.EncodeWith(r.Context(), text, opts)
+		switch {
+		case errors.Is(err, errPayloadTooLarge):
+			writeAPIError(w, r, http.StatusRequestEntityTooLarge, apiError{
+				Code:    errCodePayloadTooLarge,
+				Message: "Parameter 'text' is too long to fit in a QR code",
+				Details: map[string]any{"bytes": len(text)},
+			})
+			return
+		case errors.Is(err, errTextNotInMode):
+			writeAPIError(w, r, http.StatusBadRequest, *apiErrorf(errCodeInvalidParam, nil, "Parameter 'text' can't be encoded in %s mode", opts.mode))
+			return
+		case errors.Is(err, errTextNotInCharset):
+			writeAPIError(w, r, http.StatusBadRequest, *apiErrorf(errCodeInvalidParam, nil, "Parameter 'text' has characters that %s lacks", opts.charset))
+			return
+		case err != nil:
+			if writeContextError(w, r, err) {
+				return
+			}
+			slog.ErrorContext(r.Context(), "failed to encode QR code", "error", err)
+			setRequestError(r.Context(), err)
+			http.Error(w, "Failed to encode QR code", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(newInfoResponse(img, opts, dpi))
+	}
+}
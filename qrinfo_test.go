@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestInfo(t *testing.T) {
+	tests := []struct {
+		name       string
+		query      string
+		wantStatus int
+		want       infoResponse
+	}{
+		{name: "defaults", query: "text=hello", wantStatus: http.StatusOK, want: infoResponse{
+			Version: 1, Level: "medium", Mode: "byte", Modules: 21, QuietZone: 4, Format: "png",
+			SizePixels: 256, DPI: 300, ModulePixels: 8.83, ModuleMM: 0.75, WidthMM: 21.67, WidthInches: 0.85,
+		}},
+		{name: "numeric at 600 dpi", query: "text=" + strings.Repeat("7", 100) + "&ec=highest&size=512&dpi=600", wantStatus: http.StatusOK, want: infoResponse{
+			Version: 5, Level: "highest", Mode: "numeric", Modules: 37, QuietZone: 4, Format: "png",
+			SizePixels: 512, DPI: 600, ModulePixels: 11.38, ModuleMM: 0.48, WidthMM: 21.67, WidthInches: 0.85,
+		}},
+		{name: "png widened", query: "text=" + strings.Repeat("a", 200) + "&size=64&dpi=72", wantStatus: http.StatusOK, want: infoResponse{
+			Version: 10, Level: "medium", Mode: "byte", Modules: 57, QuietZone: 4, Format: "png",
+			SizePixels: 65, DPI: 72, ModulePixels: 1, ModuleMM: 0.35, WidthMM: 22.93, WidthInches: 0.9,
+		}},
+		{name: "svg", query: "text=HELLO&format=svg&size=64&ec=low", wantStatus: http.StatusOK, want: infoResponse{
+			Version: 1, Level: "low", Mode: "alphanumeric", Modules: 21, QuietZone: 4, Format: "svg",
+			SizePixels: 64, DPI: 300, ModulePixels: 2.21, ModuleMM: 0.19, WidthMM: 5.42, WidthInches: 0.21,
+		}},
+		{name: "missing text", query: "size=256", wantStatus: http.StatusBadRequest},
+		{name: "dpi too low", query: "text=hello&dpi=10", wantStatus: http.StatusBadRequest},
+		{name: "unknown ec", query: "text=hello&ec=X", wantStatus: http.StatusBadRequest},
+		{name: "too long", query: "text=" + strings.Repeat("a", 2000) + "&ec=highest", wantStatus: http.StatusRequestEntityTooLarge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			newTestHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/qr/info?"+tt.query, nil))
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if rec.Code != http.StatusOK {
+				return
+			}
+			var got infoResponse
+			if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("response = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding/japanese"
+)
+
+// QR versions run from 1, 21 modules on a side, to 40, 177 modules
+const (
+	minQRVersion = 1
+	maxQRVersion = 40
+)
+
+// qrQuietZone is the number of light modules around rendered codes
+const qrQuietZone = 4
+
+// Encoding modes of QR segments, from the densest to the most general
+const (
+	qrModeNumeric      = "numeric"
+	qrModeAlphanumeric = "alphanumeric"
+	qrModeByte         = "byte"
+	qrModeKanji        = "kanji"
+)
+
+// qrModes are the encoding modes, densest first
+var qrModes = []string{qrModeNumeric, qrModeAlphanumeric, qrModeByte, qrModeKanji}
+
+// qrAlphanumeric are the characters of alphanumeric mode
+const qrAlphanumeric = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZ $%*+-./:"
+
+// qrLevelIndex is the index of each error correction level in the tables
+// below, in the order of the standard: L, M, Q, H
+var qrLevelIndex = map[string]int{"low": 0, "medium": 1, "high": 2, "highest": 3}
+
+// qrECCodewordsPerBlock is the number of error correction codewords in
+// each block, by level and version; index 0 is unused
+var qrECCodewordsPerBlock = [4][maxQRVersion + 1]int{
+	{0, 7, 10, 15, 20, 26, 18, 20, 24, 30, 18, 20, 24, 26, 30, 22, 24, 28, 30, 28, 28, 28, 28, 30, 30, 26, 28, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30},
+	{0, 10, 16, 26, 18, 24, 16, 18, 22, 22, 26, 30, 22, 22, 24, 24, 28, 28, 26, 26, 26, 26, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28},
+	{0, 13, 22, 18, 26, 18, 24, 18, 22, 20, 24, 28, 26, 24, 20, 30, 24, 28, 28, 26, 30, 28, 30, 30, 30, 30, 28, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30},
+	{0, 17, 28, 22, 16, 22, 28, 26, 26, 24, 28, 24, 28, 22, 24, 24, 30, 28, 28, 26, 28, 30, 24, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30},
+}
+
+// qrECBlocks is the number of error correction blocks, by level and version
+var qrECBlocks = [4][maxQRVersion + 1]int{
+	{0, 1, 1, 1, 1, 1, 2, 2, 2, 2, 4, 4, 4, 4, 4, 6, 6, 6, 6, 7, 8, 8, 9, 9, 10, 12, 12, 12, 13, 14, 15, 16, 17, 18, 19, 19, 20, 21, 22, 24, 25},
+	{0, 1, 1, 1, 2, 2, 4, 4, 4, 5, 5, 5, 8, 9, 9, 10, 10, 11, 13, 14, 16, 17, 17, 18, 20, 21, 23, 25, 26, 28, 29, 31, 33, 35, 37, 38, 40, 43, 45, 47, 49},
+	{0, 1, 1, 2, 2, 4, 4, 6, 6, 8, 8, 8, 10, 12, 16, 12, 17, 16, 18, 21, 20, 23, 23, 25, 27, 29, 34, 34, 35, 38, 40, 43, 45, 48, 51, 53, 56, 59, 62, 65, 68},
+	{0, 1, 1, 2, 4, 4, 4, 5, 6, 8, 8, 11, 11, 16, 16, 18, 16, 19, 21, 25, 25, 25, 34, 30, 32, 35, 37, 40, 42, 45, 48, 51, 54, 57, 60, 63, 66, 70, 74, 77, 81},
+}
+
+// qrModules is the number of modules on a side of a version, without the
+// quiet zone
+func qrModules(version int) int {
+	return 17 + 4*version
+}
+
+// qrRawCodewords is the number of codewords a version holds, data and
+// error correction: the modules left by the function patterns and the
+// format and version information, in bytes
+func qrRawCodewords(version int) int {
+	modules := (16*version+128)*version + 64
+	if version >= 2 {
+		alignment := version/7 + 2
+		modules -= (25*alignment-10)*alignment - 55
+		if version >= 7 {
+			modules -= 36
+		}
+	}
+	return modules / 8
+}
+
+// qrDataCodewords is the number of data codewords of a version at an
+// error correction level
+func qrDataCodewords(version int, level string) int {
+	i := qrLevelIndex[level]
+	return qrRawCodewords(version) - qrECCodewordsPerBlock[i][version]*qrECBlocks[i][version]
+}
+
+// qrCharCountBits is the width of the character count of a segment in a
+// mode, which grows at versions 10 and 27
+func qrCharCountBits(mode string, version int) int {
+	widths := map[string][3]int{
+		qrModeNumeric:      {10, 12, 14},
+		qrModeAlphanumeric: {9, 11, 13},
+		qrModeByte:         {8, 16, 16},
+		qrModeKanji:        {8, 10, 12},
+	}[mode]
+	switch {
+	case version < 10:
+		return widths[0]
+	case version < 27:
+		return widths[1]
+	}
+	return widths[2]
+}
+
+// qrSegmentBits is the number of bits of a segment of n characters in a
+// mode, with its 4-bit mode indicator and character count
+func qrSegmentBits(mode string, n, version int) int {
+	bits := 4 + qrCharCountBits(mode, version)
+	switch mode {
+	case qrModeNumeric:
+		bits += 10*(n/3) + []int{0, 4, 7}[n%3]
+	case qrModeAlphanumeric:
+		bits += 11*(n/2) + 6*(n%2)
+	case qrModeByte:
+		bits += 8 * n
+	case qrModeKanji:
+		bits += 13 * n
+	}
+	return bits
+}
+
+// qrCapacity is the largest number of characters a single segment in a
+// mode holds at a version and error correction level. Byte mode counts
+// bytes and kanji mode double-byte characters.
+func qrCapacity(mode string, version int, level string) int {
+	bits := 8 * qrDataCodewords(version, level)
+	maxCount := 1<<qrCharCountBits(mode, version) - 1
+	n := 0
+	// Segments grow with their count, so the largest count that fits is
+	// found by bisection
+	for hi := min(maxCount, bits); n < hi; {
+		mid := (n + hi + 1) / 2
+		if qrSegmentBits(mode, mid, version) <= bits {
+			n = mid
+		} else {
+			hi = mid - 1
+		}
+	}
+	return n
+}
+
+// qrCharacters returns the number of characters of text in a mode, and
+// false if the mode can't encode it
+func qrCharacters(text, mode string) (int, bool) {
+	switch mode {
+	case qrModeNumeric:
+		return len(text), strings.Trim(text, "0123456789") == ""
+	case qrModeAlphanumeric:
+		return len(text), strings.Trim(text, qrAlphanumeric) == ""
+	case qrModeByte:
+		return len(text), true
+	case qrModeKanji:
+		for _, r := range text {
+			if !qrKanji(r) {
+				return 0, false
+			}
+		}
+		return utf8.RuneCountInString(text), true
+	}
+	return 0, false
+}
+
+// qrKanji reports whether kanji mode encodes r: characters whose
+// Shift-JIS code is a double byte from 0x8140 to 0x9ffc or 0xe040 to 0xebbf
+func qrKanji(r rune) bool {
+	b, err := japanese.ShiftJIS.NewEncoder().Bytes([]byte(string(r)))
+	if err != nil || len(b) != 2 {
+		return false
+	}
+	code := int(b[0])<<8 | int(b[1])
+	return code >= 0x8140 && code <= 0x9ffc || code >= 0xe040 && code <= 0xebbf
+}
+
+// qrTextMode returns the densest of numeric, alphanumeric, and byte mode
+// that encodes all of text
+func qrTextMode(text string) string {
+	for _, mode := range []string{qrModeNumeric, qrModeAlphanumeric} {
+		if _, ok := qrCharacters(text, mode); ok {
+			return mode
+		}
+	}
+	return qrModeByte
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/skip2/go-qrcode"
+)
+
+func TestQRCapacity(t *testing.T) {
+	tests := []struct {
+		version int
+		level   string
+		want    map[string]int
+	}{
+		{version: 1, level: "low", want: map[string]int{qrModeNumeric: 41, qrModeAlphanumeric: 25, qrModeByte: 17, qrModeKanji: 10}},
+		{version: 10, level: "highest", want: map[string]int{qrModeNumeric: 288, qrModeAlphanumeric: 174, qrModeByte: 119, qrModeKanji: 74}},
+		{version: 40, level: "low", want: map[string]int{qrModeNumeric: 7089, qrModeAlphanumeric: 4296, qrModeByte: 2953, qrModeKanji: 1817}},
+		{version: 40, level: "highest", want: map[string]int{qrModeNumeric: 3057, qrModeAlphanumeric: 1852, qrModeByte: 1273, qrModeKanji: 784}},
+	}
+
+	for _, tt := range tests {
+		for mode, want := range tt.want {
+			if got := qrCapacity(mode, tt.version, tt.level); got != want {
+				t.Errorf("qrCapacity(%s, %d, %s) = %d, want %d", mode, tt.version, tt.level, got, want)
+			}
+		}
+	}
+}
+
+func TestQRCapacity_Encoder(t *testing.T) {
+	// The encoder fits exactly the capacity of every version and level
+	for level, recovery := range recoveryLevels {
+		for version := minQRVersion; version <= maxQRVersion; version++ {
+			t.Run(fmt.Sprintf("%s/%d", level, version), func(t *testing.T) {
+				for mode, char := range map[string]string{qrModeNumeric: "1", qrModeAlphanumeric: "A", qrModeByte: "a"} {
+					n := qrCapacity(mode, version, level)
+					if _, err := qrcode.NewWithForcedVersion(strings.Repeat(char, n), version, recovery); err != nil {
+						t.Errorf("%d %s characters don't fit: %v", n, mode, err)
+					}
+					if _, err := qrcode.NewWithForcedVersion(strings.Repeat(char, n+1), version, recovery); err == nil {
+						t.Errorf("%d %s characters fit", n+1, mode)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestQRCharacters(t *testing.T) {
+	tests := []struct {
+		text, mode string
+		want       int
+		wantOK     bool
+	}{
+		{text: "0123", mode: qrModeNumeric, want: 4, wantOK: true},
+		{text: "12A", mode: qrModeNumeric},
+		{text: "HTTPS://EXAMPLE.COM/A-1", mode: qrModeAlphanumeric, want: 23, wantOK: true},
+		{text: "https://example.com", mode: qrModeAlphanumeric},
+		{text: "héllo", mode: qrModeByte, want: 6, wantOK: true},
+		{text: "漢字", mode: qrModeKanji, want: 2, wantOK: true},
+		{text: "漢字A", mode: qrModeKanji},
+	}
+
+	for _, tt := range tests {
+		n, ok := qrCharacters(tt.text, tt.mode)
+		if n != tt.want && tt.wantOK || ok != tt.wantOK {
+			t.Errorf("qrCharacters(%q, %s) = %d, %v, want %d, %v", tt.text, tt.mode, n, ok, tt.want, tt.wantOK)
+		}
+	}
+	for text, want := range map[string]string{"0042": qrModeNumeric, "AB-12": qrModeAlphanumeric, "ab": qrModeByte} {
+		if got := qrTextMode(text); got != want {
+			t.Errorf("qrTextMode(%q) = %s, want %s", text, got, want)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// errCodeRateLimited is returned when a client exceeds its rate limit on a
+// channel that can't answer with a 429, such as the preview WebSocket
+const errCodeRateLimited = "rate_limited"
+
+// rateLimiterIdleTTL is how long an idle client's bucket is kept before eviction
+const rateLimiterIdleTTL = 10 * time.Minute
+
+// clientLimiter is the token bucket of a single client IP
+type clientLimiter struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// ipRateLimiter enforces a token-bucket rate limit per client IP
+type ipRateLimiter struct {
+	limit   rate.Limit
+	burst   int
+	proxies *trustedProxies
+
+	mu      sync.Mutex
+	clients map[string]*clientLimiter
+}
+
+// newIPRateLimiter allows each client rps requests per second on average with
+// bursts of up to burst requests. Client IPs are resolved through proxies.
+func newIPRateLimiter(rps float64, burst int, proxies *trustedProxies) *ipRateLimiter {
+	return &ipRateLimiter{
+		limit:   rate.Limit(rps),
+		burst:   max(burst, 1),
+		proxies: proxies,
+		clients: make(map[string]*clientLimiter),
+	}
+}
+
+// rateLimitStatus is where a client stands after a request
+type rateLimitStatus struct {
+	allowed bool
+	// remaining is the requests left in the bucket, reset how long until
+	// it is full again, and retryAfter how long a rejected client waits
+	remaining         int
+	reset, retryAfter time.Duration
+}
+
+// allow takes a token for ip if one is left
+func (l *ipRateLimiter) allow(ip string) rateLimitStatus {
+	now := time.Now()
+
+	l.mu.Lock()
+	client, ok := l.clients[ip]
+	if !ok {
+		client = &clientLimiter{limiter: rate.NewLimiter(l.limit, l.burst)}
+		l.clients[ip] = client
+	}
+	client.lastSeen = now
+	l.mu.Unlock()
+
+	status := rateLimitStatus{allowed: true}
+	reservation := client.limiter.ReserveN(now, 1)
+	if delay := reservation.DelayFrom(now); delay > 0 {
+		reservation.CancelAt(now)
+		status = rateLimitStatus{retryAfter: delay}
+	}
+	tokens := max(client.limiter.TokensAt(now), 0)
+	status.remaining = int(tokens)
+	status.reset = time.Duration((float64(l.burst) - tokens) / float64(l.limit) * float64(time.Second))
+	return status
+}
+
+// allowRequest takes a token for the client of r, as limitRequests does
+// for each request. A nil limiter allows everything.
+func (l *ipRateLimiter) allowRequest(r *http.Request) rateLimitStatus {
+	if l == nil {
+		return rateLimitStatus{allowed: true}
+	}
+	return l.allow(clientIP(r, l.proxies))
+}
+
+// setHeaders tells clients their limit, the requests they have left, and
+// the seconds until all of them are available again, so they can slow
+// down before they are rejected
+func (l *ipRateLimiter) setHeaders(h http.Header, status rateLimitStatus) {
+	h.Set("X-RateLimit-Limit", strconv.Itoa(l.burst))
+	h.Set("X-RateLimit-Remaining", strconv.Itoa(status.remaining))
+	h.Set("X-RateLimit-Reset", strconv.Itoa(int(math.Ceil(status.reset.Seconds()))))
+}
+
+// evictIdle drops the buckets of clients not seen within rateLimiterIdleTTL
+func (l *ipRateLimiter) evictIdle(now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for ip, client := range l.clients {
+		if now.Sub(client.lastSeen) > rateLimiterIdleTTL {
+			delete(l.clients, ip)
+		}
+	}
+}
+
+// cleanup periodically evicts idle clients until ctx is cancelled
+func (l *ipRateLimiter) cleanup(ctx context.Context) {
+	ticker := time.NewTicker(rateLimiterIdleTTL / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			l.evictIdle(now)
+		}
+	}
+}
+
+// limitRequests rejects requests over the client's rate limit with 429 and a
+// Retry-After header. Every response carries X-RateLimit headers. A nil
+// limiter disables rate limiting.
+func (l *ipRateLimiter) limitRequests(next http.HandlerFunc) http.HandlerFunc {
+	if l == nil {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		ip := clientIP(r, l.proxies)
+		status := l.allow(ip)
+		l.setHeaders(w.Header(), status)
+		if !status.allowed {
+			addLogAttrs(r.Context(), slog.String("client_ip", ip), slog.Bool("rate_limited", true))
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(status.retryAfter.Seconds()))))
+			http.Error(w, "Rate limit exceeded, retry later", http.StatusTooManyRequests)
+			return
+		}
+		next(w, r)
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter_PerIP(t *testing.T) {
+	deps := newTestDeps()
+	deps.rateLimiter = newIPRateLimiter(0.001, 2, nil)
+	handler := newHandler(deps)
+
+	send := func(ip string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/qr/generate?text=hello", nil)
+		req.RemoteAddr = ip + ":1234"
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	tests := []struct {
+		name     string
+		ip       string
+		wantCode int
+	}{
+		{name: "first request", ip: "203.0.113.1", wantCode: http.StatusOK},
+		{name: "within burst", ip: "203.0.113.1", wantCode: http.StatusOK},
+		{name: "over limit", ip: "203.0.113.1", wantCode: http.StatusTooManyRequests},
+		{name: "other client unaffected", ip: "203.0.113.2", wantCode: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		rec := send(tt.ip)
+		if rec.Code != tt.wantCode {
+			t.Errorf("%s: status = %d, want %d", tt.name, rec.Code, tt.wantCode)
+		}
+		if tt.wantCode == http.StatusTooManyRequests && rec.Header().Get("Retry-After") == "" {
+			t.Errorf("%s: missing Retry-After header", tt.name)
+		}
+	}
+
+	// Health endpoints are never rate limited
+	req := httptest.NewRequest(http.MethodGet, "/health", nil)
+	req.RemoteAddr = "203.0.113.1:1234"
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Errorf("/health status = %d, want %d", rec.Code, http.StatusOK)
+	}
+}
+
+func TestRateLimiter_Headers(t *testing.T) {
+	deps := newTestDeps()
+	deps.rateLimiter = newIPRateLimiter(0.001, 2, nil)
+	handler := newHandler(deps)
+
+	// The bucket refills a request every 1000 seconds
+	tests := []struct {
+		wantCode      int
+		wantRemaining string
+		wantReset     string
+	}{
+		{wantCode: http.StatusOK, wantRemaining: "1", wantReset: "1000"},
+		{wantCode: http.StatusOK, wantRemaining: "0", wantReset: "2000"},
+		{wantCode: http.StatusTooManyRequests, wantRemaining: "0", wantReset: "2000"},
+	}
+
+	for i, tt := range tests {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/qr/generate?text=hello", nil)
+		req.RemoteAddr = "203.0.113.1:1234"
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != tt.wantCode {
+			t.Errorf("request %d: status = %d, want %d", i, rec.Code, tt.wantCode)
+		}
+		h := rec.Header()
+		if h.Get("X-RateLimit-Limit") != "2" || h.Get("X-RateLimit-Remaining") != tt.wantRemaining || h.Get("X-RateLimit-Reset") != tt.wantReset {
+			t.Errorf("request %d: limit %q, remaining %q, reset %q, want 2, %s, %s", i,
+				h.Get("X-RateLimit-Limit"), h.Get("X-RateLimit-Remaining"), h.Get("X-RateLimit-Reset"), tt.wantRemaining, tt.wantReset)
+		}
+	}
+}
+
+func TestRateLimiter_EvictIdle(t *testing.T) {
+	limiter := newIPRateLimiter(1, 1, nil)
+	limiter.allow("203.0.113.1")
+
+	limiter.evictIdle(time.Now())
+	if len(limiter.clients) != 1 {
+		t.Fatalf("active client evicted, %d clients left", len(limiter.clients))
+	}
+	limiter.evictIdle(time.Now().Add(2 * rateLimiterIdleTTL))
+	if len(limiter.clients) != 0 {
+		t.Errorf("idle client not evicted, %d clients left", len(limiter.clients))
+	}
+}
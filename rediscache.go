@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// redisCache shares rendered images between replicas. When a key is cold,
+// one replica takes a short lock and renders while the others wait for its
+// result, so a popular new code is rendered once rather than once per replica.
+// Redis errors are logged and treated as misses: the cache never fails a request.
+type redisCache struct {
+	client *redis.Client
+	prefix string
+	ttl    time.Duration
+	// lockTTL bounds both how long a render holds the lock and how long other replicas wait for it
+	lockTTL time.Duration
+	// pollInterval is how often waiting replicas check for the rendered image
+	pollInterval time.Duration
+}
+
+// newRedisCache connects to the Redis server at url (redis:// or rediss://).
+// It returns nil (no shared cache) when url is empty.
+func newRedisCache(url string, ttl time.Duration) (*redisCache, error) {
+	if url == "" {
+		return nil, nil
+	}
+	opts, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, fmt.Errorf("invalid REDIS_URL: %w", err)
+	}
+	return &redisCache{
+		client:       redis.NewClient(opts),
+		prefix:       "qr:image:",
+		ttl:          ttl,
+		lockTTL:      5 * time.Second,
+		pollInterval: 25 * time.Millisecond,
+	}, nil
+}
+
+// getOrRender returns the shared image for key, rendering and storing it when
+// no other replica is already doing so
+func (c *redisCache) getOrRender(ctx context.Context, key string, render func() ([]byte, error)) ([]byte, bool, error) {
+	if data, ok := c.get(ctx, key); ok {
+		return data, true, nil
+	}
+
+	if token, ok := c.lock(ctx, key); ok {
+		defer c.unlock(context.WithoutCancel(ctx), key, token)
+		// The previous holder may have stored the image between the lookup and the lock
+		if data, ok := c.get(ctx, key); ok {
+			return data, true, nil
+		}
+	} else if data, ok := c.wait(ctx, key); ok {
+		return data, true, nil
+	}
+
+	data, err := render()
+	if err != nil {
+		return nil, false, err
+	}
+	if err := c.client.Set(ctx, c.prefix+key, data, c.ttl).Err(); err != nil {
+		slog.WarnContext(ctx, "failed to store image in redis cache", "error", err)
+	}
+	return data, false, nil
+}
+
+// get reads an image, treating errors as a miss
+func (c *redisCache) get(ctx context.Context, key string) ([]byte, bool) {
+	data, err := c.client.Get(ctx, c.prefix+key).Bytes()
+	if err != nil {
+		if !errors.Is(err, redis.Nil) {
+			slog.WarnContext(ctx, "redis cache lookup failed", "error", err)
+		}
+		return nil, false
+	}
+	return data, true
+}
+
+// lock claims the render of key, returning the token that owns the lock. It
+// also returns true, without a token, when Redis is unavailable, so the
+// caller renders instead of waiting.
+func (c *redisCache) lock(ctx context.Context, key string) (string, bool) {
+	token := randomToken()
+	acquired, err := c.client.SetNX(ctx, c.prefix+key+":lock", token, c.lockTTL).Result()
+	if err != nil {
+		slog.WarnContext(ctx, "redis cache lock failed", "error", err)
+		return "", true
+	}
+	if !acquired {
+		return "", false
+	}
+	return token, true
+}
+
+// redisUnlock deletes the lock KEYS[1] only while it holds the owner token
+// ARGV[1]
+var redisUnlock = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+
+// unlock releases the lock of key taken with token. A render that outlived
+// the lock leaves alone the lock another replica has taken since.
+func (c *redisCache) unlock(ctx context.Context, key, token string) {
+	if token == "" {
+		return
+	}
+	if err := redisUnlock.Run(ctx, c.client, []string{c.prefix + key + ":lock"}, token).Err(); err != nil {
+		slog.WarnContext(ctx, "redis cache unlock failed", "error", err)
+	}
+}
+
+// wait polls for the image another replica is rendering. It gives up when the
+// lock is released or expires without a result, or when ctx is done.
+func (c *redisCache) wait(ctx context.Context, key string) ([]byte, bool) {
+	ticker := time.NewTicker(c.pollInterval)
+	defer ticker.Stop()
+	deadline := time.After(c.lockTTL)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, false
+		case <-deadline:
+			return nil, false
+		case <-ticker.C:
+			// Check the lock first: once it is gone the image is either stored or never will be
+			n, err := c.client.Exists(ctx, c.prefix+key+":lock").Result()
+			if data, ok := c.get(ctx, key); ok {
+				return data, true
+			}
+			if err != nil || n == 0 {
+				return nil, false
+			}
+		}
+	}
+}
+
+// check reports whether Redis answers, for the health endpoint
+func (c *redisCache) check(ctx context.Context) error {
+	return c.client.Ping(ctx).Err()
+}
+
+// Close closes the connection pool
+func (c *redisCache) Close() error {
+	return c.client.Close()
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+// newTestRedisCache returns a cache backed by an in-process Redis server
+func newTestRedisCache(t *testing.T) (*redisCache, *miniredis.Miniredis) {
+	t.Helper()
+	server := miniredis.RunT(t)
+	c, err := newRedisCache("redis://"+server.Addr(), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.pollInterval = time.Millisecond
+	t.Cleanup(func() { c.Close() })
+	return c, server
+}
+
+func TestRedisCache_Check(t *testing.T) {
+	c, server := newTestRedisCache(t)
+	health := newHealthRegistry()
+	health.Register("redis_cache", false, c.check)
+
+	if resp := health.Check(context.Background()); resp.Status != statusHealthy {
+		t.Errorf("status = %q with Redis up, want %q", resp.Status, statusHealthy)
+	}
+
+	server.Close()
+	resp := health.Check(context.Background())
+	if resp.Status != statusDegraded {
+		t.Errorf("status = %q with Redis down, want %q", resp.Status, statusDegraded)
+	}
+	if component := resp.Components["redis_cache"]; component.Status != statusUnhealthy || component.Error == "" {
+		t.Errorf("redis_cache = %+v, want unhealthy with an error", component)
+	}
+}
+
+func TestRedisCache_GetOrRender(t *testing.T) {
+	tests := []struct {
+		name      string
+		setup     func(server *miniredis.Miniredis)
+		renderErr error
+		wantHit   bool
+		wantData  string
+		wantErr   bool
+		wantStore bool
+	}{
+		{name: "miss renders and stores", wantData: "rendered", wantStore: true},
+		{name: "hit skips render", setup: func(s *miniredis.Miniredis) { s.Set("qr:image:k", "shared") }, wantHit: true, wantData: "shared"},
+		{name: "render errors are not stored", renderErr: errors.New("boom"), wantErr: true},
+		{name: "stale lock does not block", setup: func(s *miniredis.Miniredis) { s.Set("qr:image:k:lock", "1") }, wantData: "rendered", wantStore: true},
+		{name: "redis down falls back to rendering", setup: func(s *miniredis.Miniredis) { s.Close() }, wantData: "rendered"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, server := newTestRedisCache(t)
+			c.lockTTL = 50 * time.Millisecond
+			if tt.setup != nil {
+				tt.setup(server)
+			}
+
+			data, hit, err := c.getOrRender(context.Background(), "k", func() ([]byte, error) {
+				return []byte("rendered"), tt.renderErr
+			})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("getOrRender() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if hit != tt.wantHit || string(data) != tt.wantData && !tt.wantErr {
+				t.Errorf("getOrRender() = %q, %v, want %q, %v", data, hit, tt.wantData, tt.wantHit)
+			}
+			if stored, _ := server.Get("qr:image:k"); tt.wantStore && stored != tt.wantData {
+				t.Errorf("stored image = %q, want %q", stored, tt.wantData)
+			}
+			if tt.wantErr && server.Exists("qr:image:k") {
+				t.Error("failed render was stored")
+			}
+		})
+	}
+}
+
+func TestRedisCache_UnlockKeepsOtherOwnersLock(t *testing.T) {
+	c, server := newTestRedisCache(t)
+	_, _, err := c.getOrRender(context.Background(), "k", func() ([]byte, error) {
+		// The lock expired during a slow render and another replica took it
+		server.Set("qr:image:k:lock", "other-replica")
+		return []byte("rendered"), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lock, err := server.Get("qr:image:k:lock"); err != nil || lock != "other-replica" {
+		t.Errorf("lock = %q (%v), want the other replica's lock kept", lock, err)
+	}
+
+	// Without a takeover, the render releases its own lock
+	if _, _, err := c.getOrRender(context.Background(), "j", func() ([]byte, error) { return []byte("rendered"), nil }); err != nil {
+		t.Fatal(err)
+	}
+	if server.Exists("qr:image:j:lock") {
+		t.Error("lock not released after the render")
+	}
+}
+
+func TestRedisCache_ColdKeyRenderedOnce(t *testing.T) {
+	c, _ := newTestRedisCache(t)
+	release := make(chan struct{})
+	var renders atomic.Int32
+	render := func() ([]byte, error) {
+		renders.Add(1)
+		<-release
+		return []byte("png"), nil
+	}
+
+	// Separate local caches act as separate replicas sharing one Redis
+	var wg sync.WaitGroup
+	results := make(chan string, 5)
+	for range 5 {
+		replica := newImageCache(10, time.Hour)
+		replica.remote = c
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			data, _, err := replica.getOrRender(context.Background(), "k", render)
+			if err != nil {
+				t.Error(err)
+			}
+			results <- string(data)
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	close(results)
+
+	if n := renders.Load(); n != 1 {
+		t.Errorf("rendered %d times, want 1", n)
+	}
+	for data := range results {
+		if data != "png" {
+			t.Errorf("replica got %q, want %q", data, "png")
+		}
+	}
+}
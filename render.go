@@ -0,0 +1,344 @@
+package main
+
+import (
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"image/color"
+	"io"
+	"math"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/skip2/go-qrcode"
+)
+
+// Output formats of rendered codes
+const (
+	formatPNG = "png"
+	formatSVG = "svg"
+)
+
+// Bounds of the size parameter in pixels; large images cost memory and CPU
+// per render
+const (
+	minImageSize = 64
+	maxImageSize = 2048
+)
+
+// maxAccessibleTextLength bounds the title and desc parameters in characters
+const maxAccessibleTextLength = 200
+
+// defaultSVGTitle is the title of SVGs without a title parameter
+const defaultSVGTitle = "QR code"
+
+// minContrastRatio is the WCAG AA contrast between foreground and background
+// below which codes get hard to scan
+const minContrastRatio = 4.5
+
+// darkModeFallback are the colors of dark-mode SVGs whose swapped colors
+// lack contrast: light modules on GitHub's dark background
+var darkModeFallback = struct{ foreground, background color.RGBA }{
+	foreground: color.RGBA{R: 0xe6, G: 0xed, B: 0xf3, A: 0xff},
+	background: color.RGBA{R: 0x0d, G: 0x11, B: 0x17, A: 0xff},
+}
+
+// recoveryLevels maps the ec parameter to error correction levels
+var recoveryLevels = map[string]qrcode.RecoveryLevel{
+	"low":     qrcode.Low,
+	"medium":  qrcode.Medium,
+	"high":    qrcode.High,
+	"highest": qrcode.Highest,
+}
+
+// renderOptions control how a code is rendered. The zero value is not
+// valid; start from defaultRenderOptions.
+type renderOptions struct {
+	size  int
+	level string
+	// mode is the encoding mode of the content, or qrModeAuto to let the
+	// encoder pick
+	mode string
+	// charset is the character set of the content behind an ECI header, or
+	// empty for UTF-8 without one
+	charset    string
+	foreground color.RGBA
+	background color.RGBA
+	format     string
+	// darkMode adds a prefers-color-scheme media query to SVGs that swaps
+	// the colors in dark mode
+	darkMode bool
+	// optimize tries smaller encodings of PNGs, at the cost of encoding
+	// them several times
+	optimize bool
+	// accessible adds a title, a description, and an img role to SVGs so
+	// screen readers can announce them. title and description replace the
+	// defaults: defaultSVGTitle and the content of the code.
+	accessible  bool
+	title       string
+	description string
+}
+
+// defaultRenderOptions render a 256-pixel black-on-white PNG with medium
+// error correction
+var defaultRenderOptions = renderOptions{
+	size:       256,
+	level:      "medium",
+	mode:       qrModeAuto,
+	foreground: color.RGBA{A: 0xff},
+	background: color.RGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff},
+	format:     formatPNG,
+	accessible: true,
+}
+
+// parseRenderOptions reads the size, ec, mode, charset, fg, bg, format,
+// dark_mode, optimize, a11y, title, and desc parameters, each defaulting to
+// defaultRenderOptions
+func parseRenderOptions(query url.Values) (renderOptions, *apiError) {
+	opts := defaultRenderOptions
+	if raw := query.Get("size"); raw != "" {
+		size, err := strconv.Atoi(raw)
+		if err != nil || size < minImageSize || size > maxImageSize {
+			return opts, apiErrorf(errCodeInvalidParam, nil, "Parameter 'size' must be a number of pixels from %d to %d", minImageSize, maxImageSize)
+		}
+		opts.size = size
+	}
+	if raw := query.Get("ec"); raw != "" {
+		if _, ok := recoveryLevels[raw]; !ok {
+			return opts, &apiError{Code: errCodeInvalidParam, Message: "Parameter 'ec' must be low, medium, high, or highest"}
+		}
+		opts.level = raw
+	}
+	if raw := query.Get("mode"); raw != "" {
+		if raw != qrModeAuto && !slices.Contains(qrModes, raw) {
+			return opts, &apiError{Code: errCodeInvalidParam, Message: "Parameter 'mode' must be auto, numeric, alphanumeric, byte, or kanji"}
+		}
+		opts.mode = raw
+	}
+	if raw := query.Get("charset"); raw != "" {
+		charset := strings.ToLower(raw)
+		if _, ok := qrCharsets[charset]; !ok {
+			return opts, &apiError{Code: errCodeInvalidParam, Message: "Parameter 'charset' must be utf-8, iso-8859-1, or shift_jis"}
+		}
+		opts.charset = charset
+	}
+	for _, param := range []struct {
+		name  string
+		color *color.RGBA
+	}{{"fg", &opts.foreground}, {"bg", &opts.background}} {
+		raw := query.Get(param.name)
+		if raw == "" {
+			continue
+		}
+		c, ok := parseHexColor(raw)
+		if !ok {
+			return opts, apiErrorf(errCodeInvalidParam, nil, "Parameter '%s' must be a hex color such as #1a2b3c", param.name)
+		}
+		*param.color = c
+	}
+	if opts.foreground == opts.background {
+		return opts, &apiError{Code: errCodeInvalidParam, Message: "Parameters 'fg' and 'bg' must differ for the code to be scannable"}
+	}
+	switch format := query.Get("format"); format {
+	case "":
+	case formatPNG, formatSVG:
+		opts.format = format
+	default:
+		return opts, &apiError{Code: errCodeInvalidParam, Message: "Parameter 'format' must be png or svg"}
+	}
+	if raw := query.Get("dark_mode"); raw != "" {
+		darkMode, err := strconv.ParseBool(raw)
+		if err != nil {
+			return opts, &apiError{Code: errCodeInvalidParam, Message: "Parameter 'dark_mode' must be true or false"}
+		}
+		if darkMode && opts.format != formatSVG {
+			return opts, &apiError{Code: errCodeInvalidParam, Message: "Parameter 'dark_mode' requires format=svg"}
+		}
+		opts.darkMode = darkMode
+	}
+	if raw := query.Get("optimize"); raw != "" {
+		optimize, err := strconv.ParseBool(raw)
+		if err != nil {
+			return opts, &apiError{Code: errCodeInvalidParam, Message: "Parameter 'optimize' must be true or false"}
+		}
+		if optimize && opts.format != formatPNG {
+			return opts, &apiError{Code: errCodeInvalidParam, Message: "Parameter 'optimize' requires format=png"}
+		}
+		opts.optimize = optimize
+	}
+	if raw := query.Get("a11y"); raw != "" {
+		accessible, err := strconv.ParseBool(raw)
+		if err != nil {
+			return opts, &apiError{Code: errCodeInvalidParam, Message: "Parameter 'a11y' must be true or false"}
+		}
+		opts.accessible = accessible
+	}
+	for _, param := range []struct {
+		name  string
+		value *string
+	}{{"title", &opts.title}, {"desc", &opts.description}} {
+		if !query.Has(param.name) {
+			continue
+		}
+		raw := query.Get(param.name)
+		if opts.format != formatSVG || !opts.accessible {
+			return opts, apiErrorf(errCodeInvalidParam, nil, "Parameter '%s' requires format=svg and a11y=true", param.name)
+		}
+		if raw == "" || !utf8.ValidString(raw) || utf8.RuneCountInString(raw) > maxAccessibleTextLength || strings.IndexFunc(raw, unicode.IsControl) >= 0 {
+			return opts, apiErrorf(errCodeInvalidParam, map[string]any{"max_length": maxAccessibleTextLength},
+				"Parameter '%s' must be 1 to %d printable characters", param.name, maxAccessibleTextLength)
+		}
+		*param.value = raw
+	}
+	return opts, nil
+}
+
+// parseHexColor parses an opaque color written as #rrggbb or rrggbb
+func parseHexColor(s string) (color.RGBA, bool) {
+	s = strings.TrimPrefix(s, "#")
+	if len(s) != 6 {
+		return color.RGBA{}, false
+	}
+	v, err := strconv.ParseUint(s, 16, 32)
+	if err != nil {
+		return color.RGBA{}, false
+	}
+	return color.RGBA{R: uint8(v >> 16), G: uint8(v >> 8), B: uint8(v), A: 0xff}, true
+}
+
+// hexColor formats c as #rrggbb
+func hexColor(c color.Color) string {
+	r, g, b, _ := c.RGBA()
+	return fmt.Sprintf("#%02x%02x%02x", r>>8, g>>8, b>>8)
+}
+
+// cacheKeyParts identifies the options in image cache keys and ETags.
+// Default colors are left out so keys of plain codes stay unchanged.
+func (o renderOptions) cacheKeyParts() []string {
+	parts := []string{o.format, strconv.Itoa(o.size), o.level}
+	if o.foreground != defaultRenderOptions.foreground || o.background != defaultRenderOptions.background {
+		parts = append(parts, hexColor(o.foreground), hexColor(o.background))
+	}
+	if o.mode != qrModeAuto {
+		parts = append(parts, "mode:"+o.mode)
+	}
+	if o.charset != "" {
+		parts = append(parts, "charset:"+o.charset)
+	}
+	if o.darkMode {
+		parts = append(parts, "dark")
+	}
+	if o.optimize {
+		parts = append(parts, "optimized")
+	}
+	if o.format == formatSVG && o.accessible {
+		parts = append(parts, "a11y")
+		if o.title != "" {
+			parts = append(parts, "title:"+o.title)
+		}
+		if o.description != "" {
+			parts = append(parts, "desc:"+o.description)
+		}
+	}
+	return parts
+}
+
+// cacheKey is the image cache key of payload rendered with the options
+func (o renderOptions) cacheKey(payload string) string {
+	return imageCacheKey(payload, append([]string{renderVersion}, o.cacheKeyParts()...)...)
+}
+
+// contentType is the media type of images in the format
+func (o renderOptions) contentType() string {
+	if o.format == formatSVG {
+		return "image/svg+xml"
+	}
+	return "image/png"
+}
+
+// darkModeColors swaps foreground and background for dark mode, falling
+// back to darkModeFallback when the swapped colors lack contrast
+func darkModeColors(foreground, background color.RGBA) (color.RGBA, color.RGBA) {
+	if contrastRatio(background, foreground) < minContrastRatio {
+		return darkModeFallback.foreground, darkModeFallback.background
+	}
+	return background, foreground
+}
+
+// relativeLuminance of an sRGB color as defined by WCAG 2
+func relativeLuminance(c color.RGBA) float64 {
+	channel := func(v uint8) float64 {
+		s := float64(v) / 255
+		if s <= 0.03928 {
+			return s / 12.92
+		}
+		return math.Pow((s+0.055)/1.055, 2.4)
+	}
+	return 0.2126*channel(c.R) + 0.7152*channel(c.G) + 0.0722*channel(c.B)
+}
+
+// contrastRatio of two colors as defined by WCAG 2, from 1 to 21
+func contrastRatio(a, b color.RGBA) float64 {
+	la, lb := relativeLuminance(a), relativeLuminance(b)
+	return (max(la, lb) + 0.05) / (min(la, lb) + 0.05)
+}
+
+// writeSVG renders the code as SVG: a background rectangle and one path of
+// horizontal runs of dark modules, scaled to size pixels. Accessible SVGs
+// lead with a title and a description of the content, and dark-mode SVGs
+// restyle both shapes in a prefers-color-scheme media query.
+func (img *qrImage) writeSVG(w io.Writer) (int64, error) {
+	bitmap := img.bitmap
+	modules := len(bitmap)
+	counter := &countingWriter{w: w}
+	b := bufio.NewWriter(counter)
+	fmt.Fprintf(b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" shape-rendering="crispEdges"`,
+		img.size, img.size, modules, modules)
+	if img.accessible {
+		title, description := img.title, img.description
+		if title == "" {
+			title = defaultSVGTitle
+		}
+		if description == "" {
+			description = "Encodes: " + img.content
+		}
+		b.WriteString(` role="img" aria-label="`)
+		xml.EscapeText(b, []byte(title))
+		b.WriteString(`"><title>`)
+		xml.EscapeText(b, []byte(title))
+		b.WriteString(`</title><desc>`)
+		xml.EscapeText(b, []byte(description))
+		b.WriteString(`</desc>`)
+	} else {
+		b.WriteString(`>`)
+	}
+	// Plain SVGs stay byte-identical to those rendered before dark mode
+	rectClass, pathClass := "", ""
+	if img.darkMode {
+		foreground, background := darkModeColors(color.RGBAModel.Convert(img.foreground).(color.RGBA), color.RGBAModel.Convert(img.background).(color.RGBA))
+		fmt.Fprintf(b, `<style>@media (prefers-color-scheme:dark){.qr-bg{fill:%s}.qr-fg{fill:%s}}</style>`, hexColor(background), hexColor(foreground))
+		rectClass, pathClass = ` class="qr-bg"`, ` class="qr-fg"`
+	}
+	fmt.Fprintf(b, `<rect%s width="%d" height="%d" fill="%s"/><path%s fill="%s" d="`,
+		rectClass, modules, modules, hexColor(img.background), pathClass, hexColor(img.foreground))
+	for y, row := range bitmap {
+		for x := 0; x < modules; {
+			if !row[x] {
+				x++
+				continue
+			}
+			start := x
+			for x < modules && row[x] {
+				x++
+			}
+			fmt.Fprintf(b, "M%d %dh%dv1h-%dz", start, y, x-start, x-start)
+		}
+	}
+	b.WriteString(`"/></svg>`)
+	err := b.Flush()
+	return counter.n, err
+}
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestParseRenderOptions(t *testing.T) {
+	tests := []struct {
+		name    string
+		query   string
+		want    renderOptions
+		wantErr bool
+	}{
+		{name: "defaults", query: "", want: defaultRenderOptions},
+		{
+			name:  "all options",
+			query: "size=512&ec=highest&mode=numeric&fg=%231a2b3c&bg=FFEEDD&format=svg",
+			want: renderOptions{
+				size:       512,
+				level:      "highest",
+				mode:       qrModeNumeric,
+				foreground: color.RGBA{R: 0x1a, G: 0x2b, B: 0x3c, A: 0xff},
+				background: color.RGBA{R: 0xff, G: 0xee, B: 0xdd, A: 0xff},
+				format:     formatSVG,
+				accessible: true,
+			},
+		},
+		{name: "size too small", query: "size=32", wantErr: true},
+		{name: "size too large", query: "size=4096", wantErr: true},
+		{name: "size not a number", query: "size=big", wantErr: true},
+		{name: "unknown level", query: "ec=Q", wantErr: true},
+		{name: "unknown mode", query: "mode=ascii", wantErr: true},
+		{name: "charset", query: "charset=Shift_JIS", want: renderOptions{size: 256, level: "medium", mode: qrModeAuto, charset: "shift_jis", foreground: defaultRenderOptions.foreground, background: defaultRenderOptions.background, format: formatPNG, accessible: true}},
+		{name: "unknown charset", query: "charset=latin-9", wantErr: true},
+		{name: "short color", query: "fg=%23fff", wantErr: true},
+		{name: "named color", query: "bg=white", wantErr: true},
+		{name: "same colors", query: "fg=%23ffffff", wantErr: true},
+		{name: "unknown format", query: "format=jpeg", wantErr: true},
+		{name: "dark mode", query: "format=svg&dark_mode=true", want: renderOptions{size: 256, level: "medium", mode: qrModeAuto, foreground: defaultRenderOptions.foreground, background: defaultRenderOptions.background, format: formatSVG, darkMode: true, accessible: true}},
+		{name: "dark mode off", query: "dark_mode=false", want: defaultRenderOptions},
+		{name: "dark mode PNG", query: "dark_mode=true", wantErr: true},
+		{name: "dark mode not a bool", query: "format=svg&dark_mode=auto", wantErr: true},
+		{name: "optimize", query: "optimize=true", want: renderOptions{size: 256, level: "medium", mode: qrModeAuto, foreground: defaultRenderOptions.foreground, background: defaultRenderOptions.background, format: formatPNG, optimize: true, accessible: true}},
+		{name: "optimize SVG", query: "format=svg&optimize=true", wantErr: true},
+		{name: "optimize not a bool", query: "optimize=smaller", wantErr: true},
+		{name: "accessibility off", query: "format=svg&a11y=false", want: renderOptions{size: 256, level: "medium", mode: qrModeAuto, foreground: defaultRenderOptions.foreground, background: defaultRenderOptions.background, format: formatSVG}},
+		{name: "title and desc", query: "format=svg&title=Ticket&desc=Admits+one", want: renderOptions{size: 256, level: "medium", mode: qrModeAuto, foreground: defaultRenderOptions.foreground, background: defaultRenderOptions.background, format: formatSVG, accessible: true, title: "Ticket", description: "Admits one"}},
+		{name: "a11y not a bool", query: "format=svg&a11y=maybe", wantErr: true},
+		{name: "title of PNG", query: "title=Ticket", wantErr: true},
+		{name: "title without a11y", query: "format=svg&a11y=false&title=Ticket", wantErr: true},
+		{name: "empty desc", query: "format=svg&desc=", wantErr: true},
+		{name: "title with control character", query: "format=svg&title=a%00b", wantErr: true},
+		{name: "title too long", query: "format=svg&title=" + strings.Repeat("x", maxAccessibleTextLength+1), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, _ := url.ParseQuery(tt.query)
+			got, apiErr := parseRenderOptions(query)
+			if (apiErr != nil) != tt.wantErr {
+				t.Fatalf("error = %v, want error %v", apiErr, tt.wantErr)
+			}
+			if apiErr != nil {
+				if apiErr.Code != errCodeInvalidParam {
+					t.Errorf("error code = %q", apiErr.Code)
+				}
+				return
+			}
+			if got != tt.want {
+				t.Errorf("options = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRenderOptions_CacheKeyParts(t *testing.T) {
+	if got := strings.Join(defaultRenderOptions.cacheKeyParts(), ","); got != "png,256,medium" {
+		t.Errorf("default parts = %q, want the keys of plain codes unchanged", got)
+	}
+	colored := defaultRenderOptions
+	colored.foreground = color.RGBA{R: 0x11, G: 0x22, B: 0x33, A: 0xff}
+	if got := strings.Join(colored.cacheKeyParts(), ","); got != "png,256,medium,#112233,#ffffff" {
+		t.Errorf("colored parts = %q", got)
+	}
+	numeric := defaultRenderOptions
+	numeric.mode, numeric.charset = qrModeNumeric, "iso-8859-1"
+	if got := strings.Join(numeric.cacheKeyParts(), ","); got != "png,256,medium,mode:numeric,charset:iso-8859-1" {
+		t.Errorf("numeric mode parts = %q", got)
+	}
+	dark := defaultRenderOptions
+	dark.format, dark.darkMode = formatSVG, true
+	if got := strings.Join(dark.cacheKeyParts(), ","); got != "svg,256,medium,dark,a11y" {
+		t.Errorf("dark mode parts = %q", got)
+	}
+	titled := defaultRenderOptions
+	titled.format, titled.title = formatSVG, "Ticket"
+	if got := strings.Join(titled.cacheKeyParts(), ","); got != "svg,256,medium,a11y,title:Ticket" {
+		t.Errorf("titled parts = %q", got)
+	}
+}
+
+func TestQRImage_DarkModeSVG(t *testing.T) {
+	tests := []struct {
+		name      string
+		fg, bg    color.RGBA
+		darkMode  bool
+		wantStyle string
+	}{
+		{name: "plain", fg: defaultRenderOptions.foreground, bg: defaultRenderOptions.background},
+		{name: "swapped", fg: defaultRenderOptions.foreground, bg: defaultRenderOptions.background, darkMode: true, wantStyle: ".qr-bg{fill:#000000}.qr-fg{fill:#ffffff}"},
+		{name: "swapped brand colors", fg: color.RGBA{G: 0x33, B: 0x66, A: 0xff}, bg: color.RGBA{R: 0xff, G: 0xff, B: 0xcc, A: 0xff}, darkMode: true, wantStyle: ".qr-bg{fill:#003366}.qr-fg{fill:#ffffcc}"},
+		{name: "low contrast falls back", fg: color.RGBA{R: 0x77, G: 0x77, B: 0x77, A: 0xff}, bg: color.RGBA{R: 0x99, G: 0x99, B: 0x99, A: 0xff}, darkMode: true, wantStyle: ".qr-bg{fill:#0d1117}.qr-fg{fill:#e6edf3}"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := defaultRenderOptions
+			opts.format, opts.foreground, opts.background, opts.darkMode = formatSVG, tt.fg, tt.bg, tt.darkMode
+			img, err := (&QRCodeGenerator{}).EncodeWith(context.Background(), "hello", opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var buf bytes.Buffer
+			if _, err := img.WriteTo(&buf); err != nil {
+				t.Fatal(err)
+			}
+			svg := buf.String()
+			if tt.wantStyle == "" {
+				if strings.Contains(svg, "<style>") || strings.Contains(svg, "class=") {
+					t.Errorf("plain SVG has dark mode styles: %.300s", svg)
+				}
+				return
+			}
+			want := "<style>@media (prefers-color-scheme:dark){" + tt.wantStyle + "}</style>"
+			if !strings.Contains(svg, want) {
+				t.Errorf("SVG lacks %q: %.300s", want, svg)
+			}
+			// Outside dark mode the requested colors apply
+			for _, want := range []string{`<rect class="qr-bg" width="29" height="29" fill="` + hexColor(tt.bg) + `"/>`, `<path class="qr-fg" fill="` + hexColor(tt.fg) + `"`} {
+				if !strings.Contains(svg, want) {
+					t.Errorf("SVG lacks %q", want)
+				}
+			}
+		})
+	}
+}
+
+func TestQRImage_SVG(t *testing.T) {
+	opts := defaultRenderOptions
+	opts.format = formatSVG
+	opts.foreground = color.RGBA{R: 0x12, G: 0x34, B: 0x56, A: 0xff}
+	img, err := (&QRCodeGenerator{}).EncodeWith(context.Background(), "hello", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	n, err := img.WriteTo(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	svg := buf.String()
+	if int(n) != len(svg) {
+		t.Errorf("WriteTo returned %d bytes, wrote %d", n, len(svg))
+	}
+	modules := len(img.bitmap)
+	for _, want := range []string{
+		`<svg xmlns="http://www.w3.org/2000/svg" width="256" height="256" viewBox="0 0 29 29"`,
+		`fill="#ffffff"`, `<path fill="#123456" d="M`, `"/></svg>`,
+	} {
+		if !strings.Contains(svg, want) {
+			t.Errorf("SVG lacks %q: %.200s", want, svg)
+		}
+	}
+	if modules != 29 {
+		t.Errorf("hello has %d modules including the quiet zone, want 29", modules)
+	}
+
+	// Every dark module is covered by exactly one run
+	dark := 0
+	for _, row := range img.bitmap {
+		for _, on := range row {
+			if on {
+				dark++
+			}
+		}
+	}
+	covered := 0
+	for _, run := range strings.Split(svg, "M")[1:] {
+		var x, y, width int
+		if _, err := fmt.Sscanf(run, "%d %dh%d", &x, &y, &width); err != nil {
+			t.Fatalf("invalid run %q: %v", run, err)
+		}
+		covered += width
+	}
+	if covered != dark {
+		t.Errorf("runs cover %d modules, want %d", covered, dark)
+	}
+}
+
+func TestQRImage_AccessibleSVG(t *testing.T) {
+	tests := []struct {
+		name        string
+		title       string
+		description string
+		off         bool
+		want        string
+	}{
+		{name: "defaults", want: `shape-rendering="crispEdges" role="img" aria-label="QR code"><title>QR code</title><desc>Encodes: https://example.com/?a=1&amp;b=&lt;2&gt;</desc><rect`},
+		{name: "custom", title: `Ticket "123"`, description: "Admits one", want: `role="img" aria-label="Ticket &#34;123&#34;"><title>Ticket &#34;123&#34;</title><desc>Admits one</desc><rect`},
+		{name: "off", off: true, want: `shape-rendering="crispEdges"><rect`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := defaultRenderOptions
+			opts.format, opts.title, opts.description, opts.accessible = formatSVG, tt.title, tt.description, !tt.off
+			img, err := (&QRCodeGenerator{}).EncodeWith(context.Background(), "https://example.com/?a=1&b=<2>", opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var buf bytes.Buffer
+			if _, err := img.WriteTo(&buf); err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(buf.String(), tt.want) {
+				t.Errorf("SVG lacks %q: %.400s", tt.want, buf.String())
+			}
+		})
+	}
+}
+
+func TestGenerate_RenderOptions(t *testing.T) {
+	tests := []struct {
+		name            string
+		query           string
+		wantStatus      int
+		wantContentType string
+		wantSize        int
+		wantForeground  color.Color
+	}{
+		{name: "default", query: "text=hello", wantStatus: http.StatusOK, wantContentType: "image/png", wantSize: 256, wantForeground: color.Black},
+		{name: "size and colors", query: "text=hello&size=512&fg=%23003366&bg=%23ffffcc&ec=high", wantStatus: http.StatusOK, wantContentType: "image/png", wantSize: 512, wantForeground: color.RGBA{G: 0x33, B: 0x66, A: 0xff}},
+		{name: "SVG", query: "text=hello&format=svg", wantStatus: http.StatusOK, wantContentType: "image/svg+xml"},
+		{name: "invalid option", query: "text=hello&size=1", wantStatus: http.StatusBadRequest},
+		{name: "SVG delivery", query: "text=hello&format=svg&deliver.print=dock-1", wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		for _, cached := range []bool{false, true} {
+			name := tt.name
+			if cached {
+				name += " cached"
+			}
+			t.Run(name, func(t *testing.T) {
+				deps := newTestDeps()
+				if cached {
+					deps.cache = newImageCache(10, 0)
+				}
+				deps.printers = newTestPrinters(t, &fakePrinter{})
+				rec := httptest.NewRecorder()
+				newHandler(deps).ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v1/qr/generate?"+tt.query, nil))
+				if rec.Code != tt.wantStatus {
+					t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+				}
+				if rec.Code != http.StatusOK {
+					var resp struct {
+						Error apiError `json:"error"`
+					}
+					json.Unmarshal(rec.Body.Bytes(), &resp)
+					if resp.Error.Code != errCodeInvalidParam {
+						t.Errorf("error = %+v", resp.Error)
+					}
+					return
+				}
+				if got := rec.Header().Get("Content-Type"); got != tt.wantContentType {
+					t.Fatalf("content type = %q, want %q", got, tt.wantContentType)
+				}
+				if tt.wantSize == 0 {
+					if !strings.HasPrefix(rec.Body.String(), "<svg") {
+						t.Errorf("body = %.100s, want SVG", rec.Body.String())
+					}
+					return
+				}
+				decoded, err := png.Decode(rec.Body)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if decoded.Bounds() != image.Rect(0, 0, tt.wantSize, tt.wantSize) {
+					t.Errorf("bounds = %v, want %d pixels", decoded.Bounds(), tt.wantSize)
+				}
+				// The top-left finder pattern's outer ring is module 4 of 29,
+				// right after the quiet zone
+				corner := tt.wantSize * 9 / 58
+				if !sameColor(decoded.At(corner, corner), tt.wantForeground) {
+					t.Errorf("finder pattern color = %v, want %v", decoded.At(corner, corner), tt.wantForeground)
+				}
+			})
+		}
+	}
+}
+
+// sameColor compares colors by their RGBA values
+func sameColor(a, b color.Color) bool {
+	r1, g1, b1, a1 := a.RGBA()
+	r2, g2, b2, a2 := b.RGBA()
+	return r1 == r2 && g1 == g2 && b1 == b2 && a1 == a2
+}
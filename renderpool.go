@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"sync/atomic"
+)
+
+// errCodeServerBusy is returned when the render queue is full
+const errCodeServerBusy = "server_busy"
+
+// errRenderQueueFull is returned when all workers are busy and the queue is full
+var errRenderQueueFull = errors.New("render queue is full")
+
+// renderPool bounds the number of concurrent renders so a burst of requests
+// queues instead of oversubscribing the pod's CPU limit. Requests beyond the
+// queue are rejected. A nil pool runs renders unbounded.
+type renderPool struct {
+	workers  chan struct{}
+	maxQueue int64
+	queued   atomic.Int64
+}
+
+// newRenderPool creates a pool running up to workers renders at once with up
+// to queue renders waiting. It returns nil when workers is not positive.
+func newRenderPool(workers, queue int) *renderPool {
+	if workers <= 0 {
+		return nil
+	}
+	return &renderPool{workers: make(chan struct{}, workers), maxQueue: int64(max(queue, 0))}
+}
+
+// size returns the number of renders run at once. A nil pool is unbounded and
+// reports GOMAXPROCS, the parallelism that still makes progress.
+func (p *renderPool) size() int {
+	if p == nil {
+		return runtime.GOMAXPROCS(0)
+	}
+	return cap(p.workers)
+}
+
+// run calls render once a worker is free. It returns errRenderQueueFull when
+// the queue is full, or ctx's error when ctx is done while queued.
+func (p *renderPool) run(ctx context.Context, render func() ([]byte, error)) ([]byte, error) {
+	if p == nil {
+		return render()
+	}
+
+	select {
+	case p.workers <- struct{}{}:
+	default:
+		if p.queued.Add(1) > p.maxQueue {
+			p.queued.Add(-1)
+			return nil, errRenderQueueFull
+		}
+		select {
+		case p.workers <- struct{}{}:
+			p.queued.Add(-1)
+		case <-ctx.Done():
+			p.queued.Add(-1)
+			return nil, ctx.Err()
+		}
+	}
+	defer func() { <-p.workers }()
+	return render()
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRenderPool_Run(t *testing.T) {
+	tests := []struct {
+		name    string
+		queue   int
+		timeout time.Duration
+		wantErr error
+	}{
+		{name: "queued until a worker is free", queue: 1},
+		{name: "rejected when the queue is full", queue: 0, wantErr: errRenderQueueFull},
+		{name: "gives up when the request is done", queue: 1, timeout: 10 * time.Millisecond, wantErr: context.DeadlineExceeded},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pool := newRenderPool(1, tt.queue)
+			release := make(chan struct{})
+			busy := make(chan struct{})
+			go pool.run(context.Background(), func() ([]byte, error) {
+				close(busy)
+				<-release
+				return nil, nil
+			})
+			<-busy
+			if tt.wantErr == nil {
+				time.AfterFunc(10*time.Millisecond, func() { close(release) })
+			} else {
+				defer close(release)
+			}
+
+			ctx := context.Background()
+			if tt.timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, tt.timeout)
+				defer cancel()
+			}
+			data, err := pool.run(ctx, func() ([]byte, error) { return []byte("png"), nil })
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("run() error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && string(data) != "png" {
+				t.Errorf("run() = %q, want %q", data, "png")
+			}
+		})
+	}
+}
+
+func TestRenderPool_Disabled(t *testing.T) {
+	if pool := newRenderPool(0, 10); pool != nil {
+		t.Fatalf("newRenderPool(0) = %v, want nil", pool)
+	}
+	var pool *renderPool
+	if data, err := pool.run(context.Background(), func() ([]byte, error) { return []byte("png"), nil }); err != nil || string(data) != "png" {
+		t.Errorf("run() = %q, %v, want unbounded render", data, err)
+	}
+}
+
+func TestGenerate_RenderQueueFull(t *testing.T) {
+	deps := newTestDeps()
+	deps.renderPool = newRenderPool(1, 0)
+	deps.renderPool.workers <- struct{}{} // the only worker is busy
+
+	rec := httptest.NewRecorder()
+	newHandler(deps).ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v1/qr/generate?text=hello", nil))
+
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusTooManyRequests)
+	}
+	if rec.Header().Get("Retry-After") == "" {
+		t.Error("missing Retry-After header")
+	}
+}
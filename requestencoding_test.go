@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDecodeRequestBody(t *testing.T) {
+	compress := func(s string) []byte {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		zw.Write([]byte(s))
+		zw.Close()
+		return buf.Bytes()
+	}
+	small := `{"items": [{"text": "a"}, {"text": "b"}]}`
+	// Compresses to far less than the body limit, but inflates past it
+	large := `{"items": [` + strings.Repeat(`{"text": "a"}, `, 1000) + `{"text": "a"}]}`
+
+	tests := []struct {
+		name       string
+		encoding   string
+		body       []byte
+		wantStatus int
+		wantCode   string
+		// maxBodyBytes caps the compressed body, which is sent without a
+		// length so the cap is hit while it is read; zero uses 1000
+		maxBodyBytes int64
+	}{
+		{name: "uncompressed", body: []byte(small), wantStatus: http.StatusOK},
+		{name: "gzip", encoding: "gzip", body: compress(small), wantStatus: http.StatusOK},
+		{name: "gzip past the decompressed limit", encoding: "gzip", body: compress(large), wantStatus: http.StatusRequestEntityTooLarge, wantCode: errCodeBodyTooLarge},
+		{name: "not gzip", encoding: "gzip", body: []byte(small), wantStatus: http.StatusBadRequest, wantCode: errCodeInvalidPayload},
+		{name: "truncated gzip", encoding: "gzip", body: compress(small)[:30], wantStatus: http.StatusBadRequest, wantCode: errCodeInvalidPayload},
+		{name: "gzip header past the compressed limit", encoding: "gzip", body: compress(small), maxBodyBytes: 8, wantStatus: http.StatusRequestEntityTooLarge, wantCode: errCodeBodyTooLarge},
+		{name: "other encoding", encoding: "br", body: []byte(small), wantStatus: http.StatusUnsupportedMediaType, wantCode: errCodeUnsupportedEncoding},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deps := newTestDeps()
+			deps.flags = mustFeatureFlags("batch")
+			deps.renderPool = newRenderPool(2, 10)
+			deps.maxBodyBytes = 1000
+			deps.maxGzipBodyBytes = 1000
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/qr/batch", bytes.NewReader(tt.body))
+			if tt.maxBodyBytes > 0 {
+				deps.maxBodyBytes = tt.maxBodyBytes
+				req.ContentLength = -1
+			}
+			if tt.encoding != "" {
+				req.Header.Set("Content-Encoding", tt.encoding)
+			}
+			rec := httptest.NewRecorder()
+			newHandler(deps).ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if tt.wantCode != "" && !strings.Contains(rec.Body.String(), `"`+tt.wantCode+`"`) {
+				t.Errorf("body = %s, want code %s", rec.Body.String(), tt.wantCode)
+			}
+			if tt.wantStatus == http.StatusUnsupportedMediaType && rec.Header().Get("Accept-Encoding") != "gzip" {
+				t.Errorf("Accept-Encoding = %q, want gzip", rec.Header().Get("Accept-Encoding"))
+			}
+		})
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"time"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+)
+
+// requestIDHeader is the header used to receive, return, and propagate request IDs
+const requestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength bounds the size of client-provided request IDs
+const maxRequestIDLength = 128
+
+// requestIDKey is the context key for the current request ID
+type requestIDKey struct{}
+
+// requestIDMiddleware honors a well-formed incoming X-Request-ID or generates a new one,
+// stores it in the request context, and echoes it in the response headers
+func requestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+
+		w.Header().Set(requestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(withRequestID(r.Context(), id)))
+	})
+}
+
+// withRequestID returns a copy of ctx carrying the given request ID
+func withRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// requestIDFromContext returns the request ID stored in ctx, or "" if there is none
+func requestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// newRequestID generates a random 128-bit hex request ID
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(b)
+}
+
+// validRequestID reports whether a client-provided ID is safe to log and echo back
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-' || c == '_' || c == '.' || c == ':':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// requestIDTransport is an http.RoundTripper that propagates the request ID
+// from the outgoing request's context to downstream services
+type requestIDTransport struct {
+	Base http.RoundTripper
+}
+
+func (t *requestIDTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	id := requestIDFromContext(req.Context())
+	if id == "" || req.Header.Get(requestIDHeader) != "" {
+		return base.RoundTrip(req)
+	}
+
+	req = req.Clone(req.Context())
+	req.Header.Set(requestIDHeader, id)
+	return base.RoundTrip(req)
+}
+
+// newOutboundClient returns the client for calls to other services. Its
+// transport forwards the request ID and W3C trace context of each call's
+// context, so downstream logs and spans join up with ours.
+func newOutboundClient(timeout time.Duration) *http.Client {
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: &requestIDTransport{Base: otelhttp.NewTransport(http.DefaultTransport)},
+	}
+}
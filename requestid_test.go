@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRequestIDMiddleware(t *testing.T) {
+	tests := []struct {
+		name     string
+		incoming string
+		wantSame bool
+	}{
+		{"honors incoming ID", "abc-123", true},
+		{"generates when missing", "", false},
+		{"replaces unsafe ID", "bad id\nwith newline", false},
+		{"replaces oversized ID", strings.Repeat("a", maxRequestIDLength+1), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var seen string
+			handler := requestIDMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				seen = requestIDFromContext(r.Context())
+			}))
+
+			req := httptest.NewRequest(http.MethodGet, "/health", nil)
+			if tt.incoming != "" {
+				req.Header.Set(requestIDHeader, tt.incoming)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			got := rec.Header().Get(requestIDHeader)
+			if got == "" {
+				t.Fatal("response missing X-Request-ID header")
+			}
+			if got != seen {
+				t.Errorf("context ID %q does not match response header %q", seen, got)
+			}
+			if tt.wantSame && got != tt.incoming {
+				t.Errorf("X-Request-ID = %q, want incoming %q", got, tt.incoming)
+			}
+			if !tt.wantSame && got == tt.incoming {
+				t.Errorf("X-Request-ID = %q, expected a newly generated ID", got)
+			}
+		})
+	}
+}
+
+func TestRequestIDInLogs(t *testing.T) {
+	var buf bytes.Buffer
+	previous := slog.Default()
+	slog.SetDefault(newLogger(&buf, "json", slog.LevelInfo))
+	t.Cleanup(func() { slog.SetDefault(previous) })
+
+	handler := newTestHandler()
+	req := httptest.NewRequest(http.MethodGet, "/health", nil)
+	req.Header.Set(requestIDHeader, "trace-me-42")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	var entry map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("access log is not valid JSON: %v (%s)", err, buf.String())
+	}
+	if entry["request_id"] != "trace-me-42" {
+		t.Errorf("request_id = %v, want %q", entry["request_id"], "trace-me-42")
+	}
+}
+
+func TestRequestIDTransport(t *testing.T) {
+	var received string
+	downstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header.Get(requestIDHeader)
+	}))
+	defer downstream.Close()
+
+	client := &http.Client{Transport: &requestIDTransport{}}
+	req, err := http.NewRequestWithContext(withRequestID(context.Background(), "downstream-1"), http.MethodGet, downstream.URL, nil)
+	if err != nil {
+		t.Fatalf("failed to build request: %v", err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("downstream call failed: %v", err)
+	}
+	resp.Body.Close()
+
+	if received != "downstream-1" {
+		t.Errorf("downstream X-Request-ID = %q, want %q", received, "downstream-1")
+	}
+}
@@ -0,0 +1,325 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// s3EventMaxBytes caps the size of a CSV file read from S3
+const s3EventMaxBytes = 32 << 20
+
+// Names of the files written next to a processed CSV file <name>.csv
+const (
+	s3ResultsSuffix = ".results.csv"
+	s3ErrorSuffix   = ".error.txt"
+)
+
+// errS3ObjectGone reports a file deleted since its notification was sent
+var errS3ObjectGone = errors.New("object no longer exists")
+
+// s3Event is the part of an S3 event notification used here
+type s3Event struct {
+	Records []struct {
+		EventName string `json:"eventName"`
+		S3        struct {
+			Bucket struct {
+				Name string `json:"name"`
+			} `json:"bucket"`
+			Object struct {
+				Key string `json:"key"`
+			} `json:"object"`
+		} `json:"s3"`
+	} `json:"Records"`
+}
+
+// s3Object names an object in a bucket
+type s3Object struct {
+	bucket, key string
+}
+
+// s3EventWorker processes CSV files dropped into S3 buckets. It consumes
+// the bucket's ObjectCreated notifications from an SQS queue, sent directly
+// or through SNS, using the SQS worker's polling and visibility handling.
+// Each CSV file is rendered as a batch: images are written to a directory
+// named after the file, next to it, and a results file with one line per row
+// is written last. A file that fails as a whole, such as one without a text
+// column, gets an error file instead.
+type s3EventWorker struct {
+	queue     *sqsWorker
+	s3        s3API
+	suffix    string
+	processor *jobProcessor
+	// workers is how many rows are rendered in parallel
+	workers int
+	// maxRows caps the rows of a file; zero disables the limit
+	maxRows int
+}
+
+// newS3EventWorker creates a worker with credentials from the default AWS
+// chain. Files are recognized by suffix, typically ".csv".
+func newS3EventWorker(ctx context.Context, queueURL, suffix string, visibility time.Duration, maxReceives, workers, maxRows int, processor *jobProcessor) (*s3EventWorker, error) {
+	cfg, err := awsconfig.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("load AWS configuration: %w", err)
+	}
+	w := &s3EventWorker{
+		queue: &sqsWorker{
+			client:        sqs.NewFromConfig(cfg),
+			queueURL:      queueURL,
+			visibility:    max(visibility, time.Second),
+			maxReceives:   maxReceives,
+			retryInterval: time.Second,
+		},
+		s3:        s3.NewFromConfig(cfg),
+		suffix:    suffix,
+		processor: processor,
+		workers:   workers,
+		maxRows:   maxRows,
+	}
+	w.queue.onMessage = w.handle
+	return w, nil
+}
+
+// run polls for notifications until ctx is done
+func (w *s3EventWorker) run(ctx context.Context) {
+	w.queue.run(ctx)
+}
+
+// check reports whether the queue is reachable, for the health endpoint
+func (w *s3EventWorker) check(ctx context.Context) error {
+	return w.queue.check(ctx)
+}
+
+// handle processes the files of one notification. The message is deleted
+// once every file is done. Otherwise it is left for redelivery after its
+// visibility timeout, up to maxReceives times; a file that fails after that
+// is left to the queue's redrive policy.
+func (w *s3EventWorker) handle(ctx context.Context, msg types.Message) {
+	objects, err := w.objects(aws.ToString(msg.Body))
+	if err != nil {
+		slog.WarnContext(ctx, "ignoring invalid S3 notification", "message_id", aws.ToString(msg.MessageId), "error", err)
+	}
+
+	receives, _ := strconv.Atoi(msg.Attributes[string(types.MessageSystemAttributeNameApproximateReceiveCount)])
+	final := receives >= w.queue.maxReceives
+	stop := w.queue.heartbeat(ctx, msg)
+	for _, object := range objects {
+		err = w.processFile(ctx, object, final)
+		if err != nil {
+			break
+		}
+	}
+	stop()
+	if ctx.Err() != nil {
+		// Shutting down: hand the message to another consumer right away
+		w.queue.setVisibility(context.WithoutCancel(ctx), msg, 0)
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to process S3 file, leaving it for redelivery", "message_id", aws.ToString(msg.MessageId), "error", err)
+		return
+	}
+	if _, err := w.queue.client.DeleteMessage(ctx, &sqs.DeleteMessageInput{QueueUrl: aws.String(w.queue.queueURL), ReceiptHandle: msg.ReceiptHandle}); err != nil {
+		slog.ErrorContext(ctx, "failed to delete SQS message", "message_id", aws.ToString(msg.MessageId), "error", err)
+	}
+}
+
+// objects returns the new files matching the suffix of a notification,
+// unwrapping SNS envelopes. Test events and other objects, including the
+// files the worker writes itself, are skipped.
+func (w *s3EventWorker) objects(body string) ([]s3Object, error) {
+	var envelope struct {
+		Type    string `json:"Type"`
+		Message string `json:"Message"`
+	}
+	if err := json.Unmarshal([]byte(body), &envelope); err != nil {
+		return nil, err
+	}
+	if envelope.Type == "Notification" {
+		body = envelope.Message
+	}
+	var event s3Event
+	if err := json.Unmarshal([]byte(body), &event); err != nil {
+		return nil, err
+	}
+
+	var objects []s3Object
+	for _, record := range event.Records {
+		// Keys are URL-encoded, with spaces as '+'
+		key, err := url.QueryUnescape(record.S3.Object.Key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid object key %q: %w", record.S3.Object.Key, err)
+		}
+		if !strings.HasPrefix(record.EventName, "ObjectCreated:") || !strings.HasSuffix(key, w.suffix) ||
+			strings.HasSuffix(key, s3ResultsSuffix) || strings.HasSuffix(key, s3ErrorSuffix) {
+			continue
+		}
+		objects = append(objects, s3Object{bucket: record.S3.Bucket.Name, key: key})
+	}
+	return objects, nil
+}
+
+// processFile renders the rows of one CSV file. It returns an error when S3
+// fails, ctx is done, or a row failed transiently, in which case the file
+// should be retried. On the final attempt, transient failures are reported
+// in the results file instead.
+func (w *s3EventWorker) processFile(ctx context.Context, object s3Object, final bool) error {
+	base := strings.TrimSuffix(object.key, w.suffix)
+	jobs, rows, apiErr, err := w.read(ctx, object)
+	if errors.Is(err, errS3ObjectGone) {
+		slog.InfoContext(ctx, "S3 file was deleted before it was processed", "bucket", object.bucket, "key", object.key)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if apiErr != nil {
+		slog.WarnContext(ctx, "rejected S3 file", "bucket", object.bucket, "key", object.key, "code", apiErr.Code, "reason", apiErr.Message)
+		return w.put(ctx, object.bucket, base+s3ErrorSuffix, []byte(apiErr.Code+": "+apiErr.Message+"\n"), "text/plain")
+	}
+
+	processor := *w.processor
+	processor.store = &s3Store{client: w.s3, bucket: object.bucket, prefix: base}
+	results := processor.processAll(ctx, w.workers, jobs)
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	failed := 0
+	for i, result := range results {
+		if result.Status != jobSucceeded {
+			failed++
+		}
+		if result.retryable() && !final {
+			return fmt.Errorf("row %d of s3://%s/%s failed: %s", rows[i], object.bucket, object.key, result.Error.Message)
+		}
+	}
+
+	if err := w.put(ctx, object.bucket, base+s3ResultsSuffix, resultsCSV(rows, results), "text/csv"); err != nil {
+		return err
+	}
+	slog.InfoContext(ctx, "processed S3 file", "bucket", object.bucket, "key", object.key, "rows", len(results), "failed", failed)
+	return nil
+}
+
+// read downloads a CSV file and parses it with parseJobsCSV. It returns the
+// jobs and their line numbers, an API error when the file is unusable, or an
+// error when S3 fails.
+func (w *s3EventWorker) read(ctx context.Context, object s3Object) (jobs []generationJob, rows []int, apiErr *apiError, err error) {
+	out, err := w.s3.GetObject(ctx, &s3.GetObjectInput{Bucket: aws.String(object.bucket), Key: aws.String(object.key)})
+	if err != nil {
+		var noSuchKey *s3types.NoSuchKey
+		if errors.As(err, &noSuchKey) {
+			return nil, nil, nil, errS3ObjectGone
+		}
+		return nil, nil, nil, fmt.Errorf("get s3://%s/%s: %w", object.bucket, object.key, err)
+	}
+	defer out.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(out.Body, s3EventMaxBytes+1))
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("get s3://%s/%s: %w", object.bucket, object.key, err)
+	}
+	if len(data) > s3EventMaxBytes {
+		return nil, nil, apiErrorf(errCodeBodyTooLarge, nil, "File exceeds %d bytes", s3EventMaxBytes), nil
+	}
+
+	jobs, rows, apiErr = parseJobsCSV(data, w.maxRows)
+	return jobs, rows, apiErr, nil
+}
+
+// put writes an object next to the processed file
+func (w *s3EventWorker) put(ctx context.Context, bucket, key string, data []byte, contentType string) error {
+	_, err := w.s3.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(bucket),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(data),
+		ContentType: aws.String(contentType),
+	})
+	if err != nil {
+		return fmt.Errorf("put s3://%s/%s: %w", bucket, key, err)
+	}
+	return nil
+}
+
+// parseJobsCSV parses a CSV file with a header row naming a text column and
+// optionally an id column. It returns the jobs and their line numbers, or an
+// API error when the file is unusable. Rows without text are skipped;
+// maxRows caps the remaining rows unless it is zero.
+func parseJobsCSV(data []byte, maxRows int) (jobs []generationJob, rows []int, apiErr *apiError) {
+	reader := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))))
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, nil, &apiError{Code: errCodeInvalidPayload, Message: "File has no header row"}
+	}
+	if err != nil {
+		return nil, nil, &apiError{Code: errCodeInvalidPayload, Message: "Invalid CSV: " + err.Error()}
+	}
+	textColumn, idColumn := -1, -1
+	for i, name := range header {
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "text":
+			textColumn = i
+		case "id":
+			idColumn = i
+		}
+	}
+	if textColumn < 0 {
+		return nil, nil, &apiError{Code: errCodeInvalidPayload, Message: "Header row has no 'text' column"}
+	}
+
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, &apiError{Code: errCodeInvalidPayload, Message: "Invalid CSV: " + err.Error()}
+		}
+		job := generationJob{Text: cell(record, textColumn), ID: strings.TrimSpace(cell(record, idColumn))}
+		if strings.TrimSpace(job.Text) == "" {
+			continue
+		}
+		jobs = append(jobs, job)
+		// Blank lines are skipped by the reader, so take the line of the record
+		line, _ := reader.FieldPos(0)
+		rows = append(rows, line)
+	}
+	if maxRows > 0 && len(jobs) > maxRows {
+		return nil, nil, apiErrorf(errCodePayloadTooLarge, nil, "File has %d rows, the maximum is %d", len(jobs), maxRows)
+	}
+	return jobs, rows, nil
+}
+
+// resultsCSV lists the outcome of each processed row, with the line numbers
+// returned by parseJobsCSV
+func resultsCSV(rows []int, results []jobResult) []byte {
+	var out bytes.Buffer
+	writer := csv.NewWriter(&out)
+	writer.Write([]string{"row", "id", "status", "url", "error_code", "error_message"})
+	for i, result := range results {
+		record := []string{strconv.Itoa(rows[i]), result.ID, result.Status, result.URL, "", ""}
+		if result.Error != nil {
+			record[4], record[5] = result.Error.Code, result.Error.Message
+		}
+		writer.Write(record)
+	}
+	writer.Flush()
+	return out.Bytes()
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// fakeS3 is an in-memory bucket store keyed by "bucket/key". Puts of PNG
+// images fail while failImages is set.
+type fakeS3 struct {
+	mu         sync.Mutex
+	objects    map[string][]byte
+	failImages bool
+}
+
+func (f *fakeS3) GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	data, ok := f.objects[aws.ToString(params.Bucket)+"/"+aws.ToString(params.Key)]
+	if !ok {
+		return nil, &s3types.NoSuchKey{}
+	}
+	return &s3.GetObjectOutput{Body: io.NopCloser(bytes.NewReader(data))}, nil
+}
+
+func (f *fakeS3) HeadBucket(ctx context.Context, params *s3.HeadBucketInput, optFns ...func(*s3.Options)) (*s3.HeadBucketOutput, error) {
+	return &s3.HeadBucketOutput{}, nil
+}
+
+func (f *fakeS3) PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.failImages && strings.HasSuffix(aws.ToString(params.Key), ".png") {
+		return nil, errors.New("service unavailable")
+	}
+	data, _ := io.ReadAll(params.Body)
+	f.objects[aws.ToString(params.Bucket)+"/"+aws.ToString(params.Key)] = data
+	return &s3.PutObjectOutput{}, nil
+}
+
+func newTestS3EventWorker(queue *fakeSQS, bucket *fakeS3) *s3EventWorker {
+	w := &s3EventWorker{
+		queue:     &sqsWorker{client: queue, queueURL: "events", visibility: time.Minute, maxReceives: 3, retryInterval: time.Millisecond},
+		s3:        bucket,
+		suffix:    ".csv",
+		processor: newTestJobProcessor(nil),
+		workers:   2,
+		maxRows:   10,
+	}
+	w.processor.attempts = 1
+	w.queue.onMessage = w.handle
+	return w
+}
+
+// s3Notification returns an ObjectCreated notification for key in bucket
+// "labels", URL-encoded like S3 does
+func s3Notification(key string) string {
+	body, _ := json.Marshal(map[string]any{"Records": []any{map[string]any{
+		"eventName": "ObjectCreated:Put",
+		"s3": map[string]any{
+			"bucket": map[string]any{"name": "labels"},
+			"object": map[string]any{"key": strings.ReplaceAll(key, " ", "+")},
+		},
+	}}})
+	return string(body)
+}
+
+func TestS3EventWorker_Handle(t *testing.T) {
+	sns, _ := json.Marshal(map[string]string{"Type": "Notification", "Message": s3Notification("runs/may labels.csv")})
+	tests := []struct {
+		name        string
+		body        string
+		receives    string
+		file        string
+		failImages  bool
+		wantDeleted bool
+		wantWrites  []string
+	}{
+		{
+			name:        "processed",
+			body:        s3Notification("runs/may labels.csv"),
+			receives:    "1",
+			file:        "id,text\nA-1,https://example.com/a\n,\n../x,hello\nB-2,world\n",
+			wantDeleted: true,
+			wantWrites:  []string{"runs/may labels/A-1.png", "runs/may labels/B-2.png", "runs/may labels.results.csv"},
+		},
+		{
+			name:        "through SNS",
+			body:        string(sns),
+			receives:    "1",
+			file:        "id,text\nA-1,hello\n",
+			wantDeleted: true,
+			wantWrites:  []string{"runs/may labels/A-1.png", "runs/may labels.results.csv"},
+		},
+		{name: "test event", body: `{"Service": "Amazon S3", "Event": "s3:TestEvent"}`, receives: "1", wantDeleted: true},
+		{name: "not a CSV file", body: s3Notification("runs/may labels.xlsx"), receives: "1", wantDeleted: true},
+		{name: "own results file", body: s3Notification("runs/may labels.results.csv"), receives: "1", file: "text\nhello\n", wantDeleted: true},
+		{name: "deleted before processing", body: s3Notification("runs/gone.csv"), receives: "1", wantDeleted: true},
+		{
+			name:        "no text column",
+			body:        s3Notification("runs/may labels.csv"),
+			receives:    "1",
+			file:        "id,url\nA-1,https://example.com/a\n",
+			wantDeleted: true,
+			wantWrites:  []string{"runs/may labels.error.txt"},
+		},
+		{
+			name:        "too many rows",
+			body:        s3Notification("runs/may labels.csv"),
+			receives:    "1",
+			file:        "text\n" + strings.Repeat("row\n", 11),
+			wantDeleted: true,
+			wantWrites:  []string{"runs/may labels.error.txt"},
+		},
+		{name: "storage failure redelivered", body: s3Notification("runs/may labels.csv"), receives: "1", file: "text\nhello\n", failImages: true},
+		{
+			name:        "storage failure reported after max receives",
+			body:        s3Notification("runs/may labels.csv"),
+			receives:    "3",
+			file:        "text\nhello\n",
+			failImages:  true,
+			wantDeleted: true,
+			wantWrites:  []string{"runs/may labels.results.csv"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			queue := &fakeSQS{}
+			bucket := &fakeS3{objects: map[string][]byte{}, failImages: tt.failImages}
+			if tt.file != "" {
+				key := "runs/may labels.csv"
+				if strings.Contains(tt.body, "results.csv") {
+					key = "runs/may labels.results.csv"
+				}
+				bucket.objects["labels/"+key] = []byte(tt.file)
+			}
+			existing := len(bucket.objects)
+
+			w := newTestS3EventWorker(queue, bucket)
+			w.handle(context.Background(), sqsMessage(tt.body, tt.receives))
+
+			if deleted := len(queue.deleted) == 1; deleted != tt.wantDeleted {
+				t.Errorf("deleted = %v, want %v", deleted, tt.wantDeleted)
+			}
+			if len(bucket.objects) != existing+len(tt.wantWrites) {
+				t.Errorf("bucket has %d objects, want %d new: %v", len(bucket.objects)-existing, len(tt.wantWrites), tt.wantWrites)
+			}
+			for _, key := range tt.wantWrites {
+				if _, ok := bucket.objects["labels/"+key]; !ok {
+					t.Errorf("%s was not written", key)
+				}
+			}
+		})
+	}
+}
+
+func TestS3EventWorker_Results(t *testing.T) {
+	queue := &fakeSQS{}
+	bucket := &fakeS3{objects: map[string][]byte{
+		"labels/may.csv": []byte("\xef\xbb\xbfText,ID\nhttps://example.com/a,A-1\n\nhello,../x\n"),
+	}}
+	w := newTestS3EventWorker(queue, bucket)
+	w.handle(context.Background(), sqsMessage(s3Notification("may.csv"), "1"))
+
+	records, err := csv.NewReader(bytes.NewReader(bucket.objects["labels/may.results.csv"])).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{
+		{"row", "id", "status", "url", "error_code", "error_message"},
+		{"2", "A-1", jobSucceeded, "s3://labels/may/A-1.png", "", ""},
+		{"4", "../x", jobFailed, "", errCodeInvalidParam},
+	}
+	if len(records) != len(want) {
+		t.Fatalf("results = %q", records)
+	}
+	for i, record := range want {
+		for j, field := range record {
+			if records[i][j] != field {
+				t.Errorf("results line %d field %d = %q, want %q", i+1, j, records[i][j], field)
+			}
+		}
+	}
+	if !bytes.HasPrefix(bucket.objects["labels/may/A-1.png"], []byte("\x89PNG")) {
+		t.Error("image of A-1 is not a PNG")
+	}
+}
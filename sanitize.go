@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// sanitizeMode selects how payloads with invisible or control characters are handled
+type sanitizeMode string
+
+const (
+	// sanitizeReject rejects payloads containing unsafe characters (default)
+	sanitizeReject sanitizeMode = "reject"
+	// sanitizeNormalize strips unsafe characters and applies Unicode NFC
+	sanitizeNormalize sanitizeMode = "normalize"
+	// sanitizeOff encodes payloads as given
+	sanitizeOff sanitizeMode = "off"
+)
+
+// parseSanitizeMode validates a PAYLOAD_SANITIZE value
+func parseSanitizeMode(value string) (sanitizeMode, error) {
+	switch mode := sanitizeMode(strings.ToLower(value)); mode {
+	case sanitizeReject, sanitizeNormalize, sanitizeOff:
+		return mode, nil
+	}
+	return "", fmt.Errorf("invalid payload sanitize mode %q: use reject, normalize, or off", value)
+}
+
+// unsafeRune reports whether r may make a code scan differently than its text
+// is displayed: control characters other than tab and line breaks, zero-width
+// characters, and bidirectional overrides
+func unsafeRune(r rune) bool {
+	switch {
+	case r == '\t' || r == '\n' || r == '\r':
+		return false
+	case unicode.IsControl(r):
+		return true
+	case r >= 0x200B && r <= 0x200F, // zero-width space/joiners, LRM/RLM
+		r >= 0x202A && r <= 0x202E, // bidi embeddings and overrides
+		r >= 0x2060 && r <= 0x2064, // word joiner, invisible operators
+		r >= 0x2066 && r <= 0x2069, // bidi isolates
+		r == 0x00AD,                // soft hyphen
+		r == 0x180E,                // Mongolian vowel separator
+		r == 0xFEFF:                // zero-width no-break space (BOM)
+		return true
+	}
+	return false
+}
+
+// sanitizePayload applies mode to valid UTF-8 text. In reject mode the first
+// unsafe character is reported; in normalize mode unsafe characters are
+// removed and the text is NFC-normalized.
+func sanitizePayload(text string, mode sanitizeMode) (string, *apiError) {
+	switch mode {
+	case sanitizeOff:
+		return text, nil
+	case sanitizeNormalize:
+		stripped := strings.Map(func(r rune) rune {
+			if unsafeRune(r) {
+				return -1
+			}
+			return r
+		}, text)
+		return norm.NFC.String(stripped), nil
+	}
+
+	position := 0
+	for _, r := range text {
+		if unsafeRune(r) {
+			return "", apiErrorf(errCodeInvalidPayload,
+				map[string]any{"character": fmt.Sprintf("%U", r), "position": position},
+				"Parameter 'text' contains the invisible or control character %U at position %d", r, position)
+		}
+		position++
+	}
+	return text, nil
+}
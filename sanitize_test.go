@@ -0,0 +1,79 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestSanitizePayload(t *testing.T) {
+	tests := []struct {
+		name     string
+		text     string
+		mode     sanitizeMode
+		want     string
+		wantChar string
+	}{
+		{name: "plain text", text: "https://example.com", mode: sanitizeReject, want: "https://example.com"},
+		{name: "line breaks and tabs allowed", text: "BEGIN:VCARD\r\nFN:\tJane\nEND:VCARD", mode: sanitizeReject, want: "BEGIN:VCARD\r\nFN:\tJane\nEND:VCARD"},
+		{name: "zero-width space rejected", text: "https://exa\u200Bmple.com", mode: sanitizeReject, wantChar: "U+200B"},
+		{name: "bidi override rejected", text: "invoice\u202Efdp.exe", mode: sanitizeReject, wantChar: "U+202E"},
+		{name: "control character rejected", text: "a\x07b", mode: sanitizeReject, wantChar: "U+0007"},
+		{name: "C1 control rejected", text: "a\u0085b", mode: sanitizeReject, wantChar: "U+0085"},
+		{name: "default mode rejects", text: "a\uFEFFb", wantChar: "U+FEFF"},
+		{name: "normalize strips", text: "https://exa\u200Bmple.com\u202E", mode: sanitizeNormalize, want: "https://example.com"},
+		{name: "normalize composes", text: "cafe\u0301", mode: sanitizeNormalize, want: "caf\u00E9"},
+		{name: "off keeps everything", text: "a\u200Bb", mode: sanitizeOff, want: "a\u200Bb"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, apiErr := sanitizePayload(tt.text, tt.mode)
+			if tt.wantChar != "" {
+				if apiErr == nil || apiErr.Details["character"] != tt.wantChar {
+					t.Fatalf("sanitizePayload() error = %+v, want %s reported", apiErr, tt.wantChar)
+				}
+				return
+			}
+			if apiErr != nil || got != tt.want {
+				t.Errorf("sanitizePayload() = %q, %+v, want %q", got, apiErr, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseSanitizeMode(t *testing.T) {
+	for value, wantErr := range map[string]bool{"reject": false, "Normalize": false, "off": false, "strict": true, "": true} {
+		if _, err := parseSanitizeMode(value); (err != nil) != wantErr {
+			t.Errorf("parseSanitizeMode(%q) error = %v, wantErr %v", value, err, wantErr)
+		}
+	}
+}
+
+func TestGenerate_Sanitize(t *testing.T) {
+	tests := []struct {
+		name       string
+		mode       sanitizeMode
+		query      string
+		wantStatus int
+	}{
+		{name: "rejected", mode: sanitizeReject, query: "text=" + url.QueryEscape("pay\u200Bpal.com"), wantStatus: http.StatusBadRequest},
+		{name: "override", mode: sanitizeReject, query: "text=" + url.QueryEscape("pay\u200Bpal.com") + "&allow_unsafe_chars=true", wantStatus: http.StatusOK},
+		{name: "normalized", mode: sanitizeNormalize, query: "text=" + url.QueryEscape("pay\u200Bpal.com"), wantStatus: http.StatusOK},
+		{name: "hidden denied scheme caught after normalizing", mode: sanitizeNormalize, query: "text=" + url.QueryEscape("java\u200Bscript:alert(1)"), wantStatus: http.StatusUnprocessableEntity},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deps := newTestDeps()
+			deps.sanitize = tt.mode
+			rec := httptest.NewRecorder()
+			newHandler(deps).ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v1/qr/generate?"+tt.query, nil))
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,702 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/redis/go-redis/v9"
+)
+
+// cronSchedule is a parsed five-field cron expression: minute, hour, day of
+// month, month, and day of week. Each field is a bit set of matching values.
+type cronSchedule struct {
+	minute, hour, dom, month, dow uint64
+	// domAny and dowAny record a "*" day field. When both day fields are
+	// restricted, a day matching either one matches, as in cron.
+	domAny, dowAny bool
+}
+
+// cronAliases are the predefined schedules of cron
+var cronAliases = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// Names accepted for months and days of the week
+var (
+	cronMonths = []string{"JAN", "FEB", "MAR", "APR", "MAY", "JUN", "JUL", "AUG", "SEP", "OCT", "NOV", "DEC"}
+	cronDays   = []string{"SUN", "MON", "TUE", "WED", "THU", "FRI", "SAT"}
+)
+
+// parseCron parses a cron expression such as "0 6 * * MON" or "*/15 8-18 *
+// * 1-5". Fields are lists of values, ranges, and steps; months and days of
+// the week may be given by name, and Sunday is 0 or 7. The aliases @hourly,
+// @daily, @weekly, @monthly, and @yearly are accepted too.
+func parseCron(expr string) (*cronSchedule, error) {
+	if alias, ok := cronAliases[strings.ToLower(strings.TrimSpace(expr))]; ok {
+		expr = alias
+	}
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron expression %q must have 5 fields (minute hour day-of-month month day-of-week)", expr)
+	}
+
+	c := &cronSchedule{domAny: fields[2] == "*", dowAny: fields[4] == "*"}
+	for _, field := range []struct {
+		name   string
+		value  string
+		lo, hi int
+		names  []string
+		bits   *uint64
+	}{
+		{"minute", fields[0], 0, 59, nil, &c.minute},
+		{"hour", fields[1], 0, 23, nil, &c.hour},
+		{"day of month", fields[2], 1, 31, nil, &c.dom},
+		{"month", fields[3], 1, 12, cronMonths, &c.month},
+		{"day of week", fields[4], 0, 7, cronDays, &c.dow},
+	} {
+		bits, err := parseCronField(field.value, field.lo, field.hi, field.names)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s in cron expression %q: %w", field.name, expr, err)
+		}
+		*field.bits = bits
+	}
+	// Sunday is both 0 and 7
+	if c.dow&(1<<7) != 0 {
+		c.dow = c.dow&^(1<<7) | 1
+	}
+	return c, nil
+}
+
+// parseCronField parses a comma-separated list of "*", values, and ranges,
+// each with an optional step, into a bit set. names, if given, are accepted
+// for the values from lo.
+func parseCronField(field string, lo, hi int, names []string) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		span, stepText, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			var err error
+			if step, err = strconv.Atoi(stepText); err != nil || step < 1 {
+				return 0, fmt.Errorf("invalid step %q", stepText)
+			}
+		}
+
+		first, last := lo, hi
+		if span != "*" {
+			from, to, isRange := strings.Cut(span, "-")
+			var err error
+			if first, err = cronValue(from, lo, hi, names); err != nil {
+				return 0, err
+			}
+			switch {
+			case isRange:
+				if last, err = cronValue(to, lo, hi, names); err != nil {
+					return 0, err
+				}
+				if last < first {
+					return 0, fmt.Errorf("range %q ends before it starts", span)
+				}
+			case !hasStep:
+				// A single value; "5/15" runs from 5 to the end of the range
+				last = first
+			}
+		}
+		for v := first; v <= last; v += step {
+			bits |= 1 << v
+		}
+	}
+	return bits, nil
+}
+
+// cronValue parses a number or name between lo and hi
+func cronValue(text string, lo, hi int, names []string) (int, error) {
+	for i, name := range names {
+		if strings.EqualFold(text, name) {
+			return lo + i, nil
+		}
+	}
+	v, err := strconv.Atoi(text)
+	if err != nil || v < lo || v > hi {
+		return 0, fmt.Errorf("value %q is not between %d and %d", text, lo, hi)
+	}
+	return v, nil
+}
+
+// next returns the first minute after t that matches the schedule, in t's
+// location, or the zero time when none falls within five years. Times
+// skipped by a daylight saving change don't run, and times repeated by one
+// run once.
+func (c *cronSchedule) next(t time.Time) time.Time {
+	loc, from := t.Location(), t
+	t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute()+1, 0, 0, loc)
+	for limit := t.AddDate(5, 0, 0); t.Before(limit); {
+		switch {
+		case c.month&(1<<t.Month()) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+		case !c.matchesDay(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+		case c.hour&(1<<t.Hour()) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc)
+		case c.minute&(1<<t.Minute()) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute()+1, 0, 0, loc)
+		case !t.After(from):
+			// A repeated hour, whichever occurrence time.Date picked
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// matchesDay reports whether the day of t matches the day fields
+func (c *cronSchedule) matchesDay(t time.Time) bool {
+	dom := c.dom&(1<<t.Day()) != 0
+	dow := c.dow&(1<<t.Weekday()) != 0
+	if c.domAny || c.dowAny {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// scheduleSource is where a scheduled batch reads its rows from. Exactly one
+// field is set.
+type scheduleSource struct {
+	// CSVURL is an http or https URL of a CSV file with a header row naming
+	// a text and optionally an id column, like the files of S3 events
+	CSVURL string `json:"csv_url,omitempty"`
+	// Sheet is a range of a Google Sheet, as for POST /api/v1/qr/batch/sheet
+	Sheet *sheetBatchRequest `json:"sheet,omitempty"`
+	// Bucket is an s3://bucket/prefix URL; each CSV file below the prefix is
+	// processed like a file from S3 events
+	Bucket string `json:"bucket,omitempty"`
+}
+
+// kind names the source in logs and the status
+func (s scheduleSource) kind() string {
+	switch {
+	case s.CSVURL != "":
+		return "csv_url"
+	case s.Sheet != nil:
+		return "sheet"
+	}
+	return "bucket"
+}
+
+// schedule is a batch regenerated on a cron schedule, such as the signage
+// codes that expire every week
+type schedule struct {
+	// Cron is evaluated in Timezone, an IANA name such as "Europe/Berlin";
+	// the default is UTC
+	Cron     string         `json:"cron"`
+	Timezone string         `json:"timezone"`
+	Source   scheduleSource `json:"source"`
+
+	name     string
+	cron     *cronSchedule
+	location *time.Location
+	// columns are the column indexes of a sheet source
+	columns sheetColumns
+	// bucket and prefix of a bucket source
+	bucket s3Object
+}
+
+// loadSchedules reads a JSON file mapping schedule names to schedules:
+//
+//	{"signage": {"cron": "0 6 * * MON", "timezone": "Europe/Berlin",
+//	             "source": {"csv_url": "https://example.com/signage.csv"}}}
+//
+// Names are used in object keys, so they are restricted like job IDs. It
+// returns nil when path is empty.
+func loadSchedules(path string) ([]*schedule, error) {
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading schedules file: %w", err)
+	}
+	var byName map[string]*schedule
+	if err := json.Unmarshal(data, &byName); err != nil {
+		return nil, fmt.Errorf("parsing schedules file: %w", err)
+	}
+
+	var schedules []*schedule
+	for name, s := range byName {
+		if s == nil {
+			return nil, fmt.Errorf("schedule %q is empty", name)
+		}
+		if err := s.init(name); err != nil {
+			return nil, fmt.Errorf("schedule %q: %w", name, err)
+		}
+		schedules = append(schedules, s)
+	}
+	slices.SortFunc(schedules, func(a, b *schedule) int { return strings.Compare(a.name, b.name) })
+	return schedules, nil
+}
+
+// init validates a schedule from the file and prepares it to run
+func (s *schedule) init(name string) error {
+	if !validJobID.MatchString(name) {
+		return errors.New("name must be 1-128 letters, digits, '.', '_' or '-'")
+	}
+	s.name = name
+
+	var err error
+	if s.cron, err = parseCron(s.Cron); err != nil {
+		return err
+	}
+	if s.location, err = time.LoadLocation(s.Timezone); err != nil {
+		return fmt.Errorf("invalid timezone: %w", err)
+	}
+	if s.cron.next(time.Now().In(s.location)).IsZero() {
+		return fmt.Errorf("cron expression %q never matches", s.Cron)
+	}
+
+	sources := 0
+	if s.Source.CSVURL != "" {
+		sources++
+		u, err := url.Parse(s.Source.CSVURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return errors.New("source csv_url must be an http or https URL")
+		}
+	}
+	if s.Source.Sheet != nil {
+		sources++
+		var apiErr *apiError
+		if s.columns, apiErr = s.Source.Sheet.columns(); apiErr != nil {
+			return fmt.Errorf("source sheet: %s", apiErr.Message)
+		}
+	}
+	if s.Source.Bucket != "" {
+		sources++
+		u, err := url.Parse(s.Source.Bucket)
+		if err != nil || u.Scheme != "s3" || u.Host == "" {
+			return errors.New("source bucket must be an s3://bucket/prefix URL")
+		}
+		s.bucket = s3Object{bucket: u.Host, key: strings.TrimPrefix(u.Path, "/")}
+	}
+	if sources != 1 {
+		return errors.New("source must have exactly one of csv_url, sheet, or bucket")
+	}
+	return nil
+}
+
+// s3Bucket is the part of *s3.Client used to process the files below a
+// bucket prefix
+type s3Bucket interface {
+	s3API
+	ListObjectsV2(ctx context.Context, params *s3.ListObjectsV2Input, optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error)
+}
+
+// prefixedStore stores objects below a key prefix of another store
+type prefixedStore struct {
+	objectStore
+	prefix string
+}
+
+func (s prefixedStore) Put(ctx context.Context, key string, data []byte, contentType string) (string, error) {
+	return s.objectStore.Put(ctx, s.prefix+"/"+key, data, contentType)
+}
+
+// scheduleRun is the outcome of a run of a schedule
+type scheduleRun struct {
+	// Trigger is "schedule" or "manual"
+	Trigger  string    `json:"trigger"`
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished"`
+	Status   string    `json:"status"`
+	Error    string    `json:"error,omitempty"`
+	// Rows and Failed count the rows of a CSV URL or sheet source
+	Rows   int `json:"rows"`
+	Failed int `json:"failed"`
+	// Files counts the processed files of a bucket source, whose rows are
+	// reported in their results files
+	Files int `json:"files,omitempty"`
+}
+
+// scheduleStatus is a schedule as listed by GET /admin/schedules
+type scheduleStatus struct {
+	Name     string       `json:"name"`
+	Cron     string       `json:"cron"`
+	Timezone string       `json:"timezone"`
+	Source   string       `json:"source"`
+	NextRun  time.Time    `json:"next_run"`
+	Running  bool         `json:"running"`
+	LastRun  *scheduleRun `json:"last_run,omitempty"`
+}
+
+// scheduler runs batches on cron schedules, each schedule on its own
+// goroutine, so recurring batches such as expiring signage codes are
+// regenerated without anyone uploading them. Images are written to the
+// object store below schedules/<name>/, with a results.csv of the rows; a
+// sheet source also gets its status and URL columns written back, and the
+// files of a bucket source get their images and results next to them, like
+// S3 event files.
+//
+// With Redis, each scheduled run is claimed by the first replica to reach
+// it; without Redis, every replica runs every schedule, so the schedules file
+// should only be given to one replica. Status is kept per replica.
+type scheduler struct {
+	schedules []*schedule
+	processor *jobProcessor
+	sheets    *sheetsClient
+	s3        s3Bucket
+	client    *http.Client
+	redis     *redis.Client
+	metrics   *metrics
+	// workers is how many rows are rendered in parallel
+	workers int
+	// maxRows caps the rows of a run, or of each file of a bucket source;
+	// zero disables the limit
+	maxRows int
+	now     func() time.Time
+
+	mu       sync.Mutex
+	status   map[string]*scheduleStatus
+	triggers map[string]chan struct{}
+}
+
+// newScheduler creates a scheduler for schedules, whose sheet sources need
+// sheets. Bucket sources use credentials from the default AWS chain. Runs
+// are claimed through the Redis server at redisURL, if not empty.
+func newScheduler(ctx context.Context, schedules []*schedule, processor *jobProcessor, sheets *sheetsClient, redisURL string, workers, maxRows int) (*scheduler, error) {
+	s := &scheduler{
+		schedules: schedules,
+		processor: processor,
+		sheets:    sheets,
+		client:    newOutboundClient(time.Minute),
+		metrics:   processor.deps.metrics,
+		workers:   workers,
+		maxRows:   maxRows,
+		now:       time.Now,
+		status:    map[string]*scheduleStatus{},
+		triggers:  map[string]chan struct{}{},
+	}
+	for _, sched := range schedules {
+		switch sched.Source.kind() {
+		case "sheet":
+			if sheets == nil {
+				return nil, fmt.Errorf("schedule %q reads a sheet, which requires GOOGLE_SHEETS_CREDENTIALS", sched.name)
+			}
+		case "bucket":
+			if s.s3 == nil {
+				cfg, err := awsconfig.LoadDefaultConfig(ctx)
+				if err != nil {
+					return nil, fmt.Errorf("load AWS configuration: %w", err)
+				}
+				s.s3 = s3.NewFromConfig(cfg)
+			}
+		}
+		// NextRun is set here rather than left to loop, so a schedule is
+		// listed with its next run as soon as it is registered
+		s.status[sched.name] = &scheduleStatus{
+			Name:     sched.name,
+			Cron:     sched.Cron,
+			Timezone: sched.location.String(),
+			Source:   sched.Source.kind(),
+			NextRun:  sched.cron.next(s.now().In(sched.location)),
+		}
+		s.triggers[sched.name] = make(chan struct{}, 1)
+	}
+	if redisURL != "" {
+		opts, err := redis.ParseURL(redisURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid REDIS_URL: %w", err)
+		}
+		s.redis = redis.NewClient(opts)
+	}
+	return s, nil
+}
+
+// run runs the schedules until ctx is done. A run in progress on shutdown
+// is cancelled and recorded as failed; it is not resumed.
+func (s *scheduler) run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, sched := range s.schedules {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.loop(ctx, sched)
+		}()
+	}
+	wg.Wait()
+}
+
+// loop runs one schedule at its times and when triggered. Runs of a
+// schedule never overlap: a time reached while it runs is skipped.
+func (s *scheduler) loop(ctx context.Context, sched *schedule) {
+	var last time.Time
+	for {
+		// Never run a time twice, even when the clock is set back
+		from := s.now()
+		if from.Before(last) {
+			from = last
+		}
+		next := sched.cron.next(from.In(sched.location))
+		s.update(sched.name, func(status *scheduleStatus) { status.NextRun = next })
+
+		timer := time.NewTimer(next.Sub(s.now()))
+		if next.IsZero() {
+			// No more times; only manual runs are left
+			timer.Stop()
+		}
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-s.triggers[sched.name]:
+			timer.Stop()
+			s.runOnce(ctx, sched, "manual")
+		case <-timer.C:
+			last = next
+			if s.claim(ctx, sched, next) {
+				s.runOnce(ctx, sched, "schedule")
+			}
+		}
+	}
+}
+
+// claim reports whether this replica runs the scheduled time at. With Redis,
+// only the first replica to claim a time does; Redis errors are logged and
+// the time is run anyway, as running twice only rewrites the same images.
+func (s *scheduler) claim(ctx context.Context, sched *schedule, at time.Time) bool {
+	if s.redis == nil {
+		return true
+	}
+	claimed, err := s.redis.SetNX(ctx, fmt.Sprintf("qr:schedules:%s:%d", sched.name, at.Unix()), s.now().UTC().Format(time.RFC3339), 24*time.Hour).Result()
+	if err != nil {
+		slog.WarnContext(ctx, "failed to claim scheduled run, running it anyway", "schedule", sched.name, "error", err)
+		return true
+	}
+	if !claimed {
+		slog.DebugContext(ctx, "scheduled run claimed by another replica", "schedule", sched.name, "at", at)
+	}
+	return claimed
+}
+
+// trigger requests a run of the named schedule as soon as it is idle. It
+// returns false when there is no such schedule.
+func (s *scheduler) trigger(name string) bool {
+	trigger, ok := s.triggers[name]
+	if !ok {
+		return false
+	}
+	select {
+	case trigger <- struct{}{}:
+	default:
+		// A run is already pending
+	}
+	return true
+}
+
+// runOnce runs a schedule and records its outcome
+func (s *scheduler) runOnce(ctx context.Context, sched *schedule, trigger string) {
+	run := &scheduleRun{Trigger: trigger, Started: s.now()}
+	s.update(sched.name, func(status *scheduleStatus) { status.Running = true })
+	slog.InfoContext(ctx, "scheduled batch started", "schedule", sched.name, "source", sched.Source.kind(), "trigger", trigger)
+
+	err := s.process(ctx, sched, run)
+	run.Finished = s.now()
+	run.Status = jobSucceeded
+	if err != nil {
+		run.Status, run.Error = jobFailed, err.Error()
+		slog.ErrorContext(ctx, "scheduled batch failed", "schedule", sched.name, "error", err)
+	} else {
+		slog.InfoContext(ctx, "scheduled batch finished", "schedule", sched.name, "rows", run.Rows, "failed", run.Failed,
+			"duration", run.Finished.Sub(run.Started))
+	}
+	s.metrics.scheduledRuns.WithLabelValues(sched.name, run.Status).Inc()
+	s.update(sched.name, func(status *scheduleStatus) {
+		status.Running = false
+		status.LastRun = run
+	})
+}
+
+// process reads the rows of a schedule's source and renders them, counting
+// them in run
+func (s *scheduler) process(ctx context.Context, sched *schedule, run *scheduleRun) error {
+	processor := *s.processor
+	processor.store = prefixedStore{objectStore: s.processor.store, prefix: "schedules/" + sched.name}
+
+	switch sched.Source.kind() {
+	case "csv_url":
+		data, err := s.download(ctx, sched.Source.CSVURL)
+		if err != nil {
+			return err
+		}
+		jobs, rows, apiErr := parseJobsCSV(data, s.maxRows)
+		if apiErr != nil {
+			return fmt.Errorf("%s: %s", apiErr.Code, apiErr.Message)
+		}
+		results := processor.processAll(ctx, s.workers, jobs)
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		run.Rows = len(results)
+		for _, result := range results {
+			if result.Status != jobSucceeded {
+				run.Failed++
+			}
+		}
+		_, err = processor.store.Put(ctx, "results.csv", resultsCSV(rows, results), "text/csv")
+		return err
+
+	case "sheet":
+		body := *sched.Source.Sheet
+		sheets := &sheetBatches{client: s.sheets, processor: &processor}
+		rows, err := sheets.read(ctx, &body, sched.columns)
+		if err != nil {
+			return err
+		}
+		if s.maxRows > 0 && len(rows) > s.maxRows {
+			return fmt.Errorf("sheet has %d rows, the maximum is %d", len(rows), s.maxRows)
+		}
+		run.Rows = len(rows)
+		run.Failed = sheets.render(ctx, s.workers, rows)
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if writeBack := body.writeBack(sched.columns, rows); len(writeBack) > 0 {
+			if err := s.sheets.update(ctx, body.SpreadsheetID, writeBack); err != nil {
+				return fmt.Errorf("update sheet: %w", err)
+			}
+		}
+		return nil
+	}
+
+	files := &s3EventWorker{s3: s.s3, suffix: ".csv", processor: s.processor, workers: s.workers, maxRows: s.maxRows}
+	objects, err := s.list(ctx, sched.bucket)
+	if err != nil {
+		return err
+	}
+	for _, object := range objects {
+		if err := files.processFile(ctx, object, true); err != nil {
+			return err
+		}
+		run.Files++
+	}
+	return nil
+}
+
+// download fetches a CSV file. The URL is left out of errors, as it may
+// carry a token.
+func (s *scheduler) download(ctx context.Context, rawURL string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return nil, fmt.Errorf("download CSV file: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("download CSV file: %s", resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, s3EventMaxBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("download CSV file: %w", err)
+	}
+	if len(data) > s3EventMaxBytes {
+		return nil, fmt.Errorf("CSV file exceeds %d bytes", s3EventMaxBytes)
+	}
+	return data, nil
+}
+
+// list returns the CSV files below a bucket prefix, without the results
+// files written next to them
+func (s *scheduler) list(ctx context.Context, prefix s3Object) ([]s3Object, error) {
+	var objects []s3Object
+	paginator := s3.NewListObjectsV2Paginator(s.s3, &s3.ListObjectsV2Input{Bucket: aws.String(prefix.bucket), Prefix: aws.String(prefix.key)})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("list s3://%s/%s: %w", prefix.bucket, prefix.key, err)
+		}
+		for _, object := range page.Contents {
+			key := aws.ToString(object.Key)
+			if strings.HasSuffix(key, ".csv") && !strings.HasSuffix(key, s3ResultsSuffix) {
+				objects = append(objects, s3Object{bucket: prefix.bucket, key: key})
+			}
+		}
+	}
+	return objects, nil
+}
+
+// Close closes the Redis connections
+func (s *scheduler) Close() error {
+	if s.redis == nil {
+		return nil
+	}
+	return s.redis.Close()
+}
+
+// update changes the status of a schedule
+func (s *scheduler) update(name string, change func(*scheduleStatus)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	change(s.status[name])
+}
+
+// statuses returns the status of every schedule, by name
+func (s *scheduler) statuses() []scheduleStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	statuses := make([]scheduleStatus, 0, len(s.schedules))
+	for _, sched := range s.schedules {
+		status := *s.status[sched.name]
+		if status.LastRun != nil {
+			run := *status.LastRun
+			status.LastRun = &run
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+// handleList returns the status of every schedule as JSON (admin listener)
+func (s *scheduler) handleList(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.statuses())
+}
+
+// handleRun starts a run of a schedule now, or once its current run
+// finishes (admin listener)
+func (s *scheduler) handleRun(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if !s.trigger(name) {
+		http.Error(w, fmt.Sprintf("schedule %q not found", name), http.StatusNotFound)
+		return
+	}
+	slog.InfoContext(r.Context(), "scheduled batch triggered", "schedule", name)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]string{"schedule": name, "status": "triggered"})
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestCronSchedule_Next(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip("time zone data not available")
+	}
+
+	tests := []struct {
+		name string
+		cron string
+		from time.Time
+		want time.Time
+	}{
+		{name: "every Monday", cron: "0 6 * * MON", from: time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC), want: time.Date(2026, 10, 19, 6, 0, 0, 0, time.UTC)},
+		{name: "not the same minute", cron: "0 6 * * 1", from: time.Date(2026, 10, 19, 6, 0, 30, 0, time.UTC), want: time.Date(2026, 10, 26, 6, 0, 0, 0, time.UTC)},
+		{name: "step", cron: "*/15 * * * *", from: time.Date(2026, 10, 16, 12, 7, 0, 0, time.UTC), want: time.Date(2026, 10, 16, 12, 15, 0, 0, time.UTC)},
+		{name: "range and list", cron: "30 8-10,14 * * *", from: time.Date(2026, 10, 16, 10, 31, 0, 0, time.UTC), want: time.Date(2026, 10, 16, 14, 30, 0, 0, time.UTC)},
+		{name: "day of month or week", cron: "0 0 1 * FRI", from: time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC), want: time.Date(2026, 10, 23, 0, 0, 0, 0, time.UTC)},
+		{name: "month rollover", cron: "0 0 1 JAN *", from: time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC), want: time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{name: "leap day", cron: "0 0 29 2 *", from: time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC), want: time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{name: "sunday as 7", cron: "@weekly", from: time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC), want: time.Date(2026, 10, 18, 0, 0, 0, 0, time.UTC)},
+		{name: "in a time zone", cron: "0 6 * * MON", from: time.Date(2026, 10, 16, 12, 0, 0, 0, berlin), want: time.Date(2026, 10, 19, 6, 0, 0, 0, berlin)},
+		{name: "skipped by daylight saving", cron: "30 2 * * *", from: time.Date(2026, 3, 29, 1, 0, 0, 0, berlin), want: time.Date(2026, 3, 30, 2, 30, 0, 0, berlin)},
+		{name: "repeated by daylight saving", cron: "30 2 * * *", from: time.Date(2026, 10, 25, 0, 30, 0, 0, time.UTC).In(berlin), want: time.Date(2026, 10, 26, 2, 30, 0, 0, berlin)},
+		{name: "in a repeated hour", cron: "*/20 * * * *", from: time.Date(2026, 10, 25, 1, 10, 0, 0, time.UTC).In(berlin), want: time.Date(2026, 10, 25, 1, 20, 0, 0, time.UTC)},
+		{name: "never", cron: "0 0 30 2 *", from: time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := parseCron(tt.cron)
+			if err != nil {
+				t.Fatalf("parseCron(%q) unexpected error: %v", tt.cron, err)
+			}
+			if got := c.next(tt.from); !got.Equal(tt.want) {
+				t.Errorf("next(%v) = %v, want %v", tt.from, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseCron_Invalid(t *testing.T) {
+	for _, cron := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "* * * 13 *", "* * * * 8", "5-1 * * * *", "*/0 * * * *", "* * * FOO *", "@reboot"} {
+		if _, err := parseCron(cron); err == nil {
+			t.Errorf("parseCron(%q) expected error but got none", cron)
+		}
+	}
+}
+
+func TestLoadSchedules(t *testing.T) {
+	tests := []struct {
+		name       string
+		content    string
+		wantSource string
+		wantErr    bool
+	}{
+		{name: "csv url", content: `{"signage": {"cron": "0 6 * * MON", "timezone": "UTC", "source": {"csv_url": "https://example.com/signage.csv"}}}`, wantSource: "csv_url"},
+		{name: "sheet", content: `{"signage": {"cron": "@daily", "source": {"sheet": {"spreadsheet_id": "abc", "sheet": "Signs", "columns": {"text": "A"}}}}}`, wantSource: "sheet"},
+		{name: "bucket", content: `{"signage": {"cron": "@daily", "source": {"bucket": "s3://labels/signage/"}}}`, wantSource: "bucket"},
+		{name: "invalid name", content: `{"sign age": {"cron": "@daily", "source": {"csv_url": "https://example.com/signage.csv"}}}`, wantErr: true},
+		{name: "invalid cron", content: `{"signage": {"cron": "every monday", "source": {"csv_url": "https://example.com/signage.csv"}}}`, wantErr: true},
+		{name: "never runs", content: `{"signage": {"cron": "0 0 31 2 *", "source": {"csv_url": "https://example.com/signage.csv"}}}`, wantErr: true},
+		{name: "invalid timezone", content: `{"signage": {"cron": "@daily", "timezone": "Mars/Olympus", "source": {"csv_url": "https://example.com/signage.csv"}}}`, wantErr: true},
+		{name: "no source", content: `{"signage": {"cron": "@daily", "source": {}}}`, wantErr: true},
+		{name: "two sources", content: `{"signage": {"cron": "@daily", "source": {"csv_url": "https://example.com/signage.csv", "bucket": "s3://labels/signage/"}}}`, wantErr: true},
+		{name: "file url", content: `{"signage": {"cron": "@daily", "source": {"csv_url": "file:///etc/passwd"}}}`, wantErr: true},
+		{name: "invalid sheet", content: `{"signage": {"cron": "@daily", "source": {"sheet": {"spreadsheet_id": "abc", "sheet": "Signs"}}}}`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "schedules.json")
+			if err := os.WriteFile(path, []byte(tt.content), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			schedules, err := loadSchedules(path)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("loadSchedules() expected error but got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("loadSchedules() unexpected error: %v", err)
+			}
+			if len(schedules) != 1 || schedules[0].name != "signage" {
+				t.Fatalf("schedules = %+v, want one named signage", schedules)
+			}
+			if got := schedules[0].Source.kind(); got != tt.wantSource {
+				t.Errorf("source = %s, want %s", got, tt.wantSource)
+			}
+		})
+	}
+}
+
+// newTestScheduler returns a scheduler of one schedule storing into store
+func newTestScheduler(t *testing.T, source scheduleSource, store objectStore) (*scheduler, *schedule) {
+	t.Helper()
+	sched := &schedule{Cron: "0 6 * * MON", Source: source}
+	if err := sched.init("signage"); err != nil {
+		t.Fatal(err)
+	}
+	processor := newTestJobProcessor(store)
+	processor.attempts = 1
+	s, err := newScheduler(context.Background(), []*schedule{sched}, processor, nil, "", 2, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, sched
+}
+
+func TestScheduler_CSVURL(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/signage.csv" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte("id,text\nlobby,https://example.com/lobby\n,\nbad id,https://example.com/bad\n"))
+	}))
+	defer server.Close()
+
+	store := &memoryStore{}
+	s, sched := newTestScheduler(t, scheduleSource{CSVURL: server.URL + "/signage.csv"}, store)
+	s.runOnce(context.Background(), sched, "manual")
+
+	run := s.statuses()[0].LastRun
+	if run == nil || run.Status != jobSucceeded || run.Rows != 2 || run.Failed != 1 {
+		t.Fatalf("last run = %+v, want succeeded with 2 rows and 1 failed", run)
+	}
+	if _, ok := store.objects["schedules/signage/lobby.png"]; !ok {
+		t.Error("schedules/signage/lobby.png was not stored")
+	}
+	results := string(store.objects["schedules/signage/results.csv"])
+	if !strings.Contains(results, "2,lobby,succeeded,mem://schedules/signage/lobby.png") || !strings.Contains(results, "4,bad id,failed") {
+		t.Errorf("results.csv = %q", results)
+	}
+
+	sched.Source.CSVURL = server.URL + "/missing.csv"
+	s.runOnce(context.Background(), sched, "schedule")
+	if run := s.statuses()[0].LastRun; run.Status != jobFailed || !strings.Contains(run.Error, "404") {
+		t.Errorf("last run = %+v, want failed with 404", run)
+	}
+}
+
+// fakeS3Bucket adds listing to fakeS3
+type fakeS3Bucket struct {
+	*fakeS3
+}
+
+func (f fakeS3Bucket) ListObjectsV2(ctx context.Context, params *s3.ListObjectsV2Input, optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	out := &s3.ListObjectsV2Output{}
+	for key := range f.objects {
+		bucket, key, _ := strings.Cut(key, "/")
+		if bucket == aws.ToString(params.Bucket) && strings.HasPrefix(key, aws.ToString(params.Prefix)) {
+			out.Contents = append(out.Contents, s3types.Object{Key: aws.String(key)})
+		}
+	}
+	return out, nil
+}
+
+func TestScheduler_Bucket(t *testing.T) {
+	bucket := &fakeS3{objects: map[string][]byte{
+		"labels/signage/lobby.csv":         []byte("text\nhttps://example.com/lobby\n"),
+		"labels/signage/old.results.csv":   []byte("row,id,status,url,error_code,error_message\n"),
+		"labels/signage/notes.txt":         []byte("not a batch"),
+		"labels/elsewhere/ignored.csv":     []byte("text\nhttps://example.com/ignored\n"),
+		"other-bucket/signage/ignored.csv": []byte("text\nhttps://example.com/ignored\n"),
+	}}
+	s, sched := newTestScheduler(t, scheduleSource{Bucket: "s3://labels/signage/"}, nil)
+	s.s3 = fakeS3Bucket{bucket}
+	s.runOnce(context.Background(), sched, "manual")
+
+	if run := s.statuses()[0].LastRun; run.Status != jobSucceeded || run.Files != 1 {
+		t.Fatalf("last run = %+v, want succeeded with 1 file", run)
+	}
+	if _, ok := bucket.objects["labels/signage/lobby.results.csv"]; !ok {
+		t.Error("labels/signage/lobby.results.csv was not written")
+	}
+	if _, ok := bucket.objects["labels/signage/old.results.results.csv"]; ok {
+		t.Error("results file was processed as a batch")
+	}
+}
+
+func TestScheduler_Claim(t *testing.T) {
+	server := miniredis.RunT(t)
+	s, sched := newTestScheduler(t, scheduleSource{CSVURL: "https://example.com/signage.csv"}, nil)
+	s.redis = redis.NewClient(&redis.Options{Addr: server.Addr()})
+	other, _ := newTestScheduler(t, scheduleSource{CSVURL: "https://example.com/signage.csv"}, nil)
+	other.redis = redis.NewClient(&redis.Options{Addr: server.Addr()})
+	at := time.Date(2026, 10, 19, 6, 0, 0, 0, time.UTC)
+
+	if !s.claim(context.Background(), sched, at) {
+		t.Fatal("first claim failed")
+	}
+	if other.claim(context.Background(), sched, at) {
+		t.Error("second replica claimed the same run")
+	}
+	if !other.claim(context.Background(), sched, at.AddDate(0, 0, 7)) {
+		t.Error("second replica could not claim the next run")
+	}
+}
+
+func TestScheduler_Admin(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("text\nhttps://example.com/lobby\n"))
+	}))
+	defer server.Close()
+	s, _ := newTestScheduler(t, scheduleSource{CSVURL: server.URL}, &memoryStore{})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		s.run(ctx)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	deps := newTestDeps()
+	deps.adminToken = "secret"
+	deps.schedules = s
+	handler := newAdminHandler(deps)
+	request := func(method, path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := request(http.MethodPost, "/admin/schedules/missing/run"); rec.Code != http.StatusNotFound {
+		t.Errorf("run of unknown schedule: status = %d, want 404", rec.Code)
+	}
+	if rec := request(http.MethodPost, "/admin/schedules/signage/run"); rec.Code != http.StatusAccepted {
+		t.Fatalf("run: status = %d, want 202", rec.Code)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		var statuses []scheduleStatus
+		if err := json.NewDecoder(request(http.MethodGet, "/admin/schedules").Body).Decode(&statuses); err != nil {
+			t.Fatal(err)
+		}
+		if len(statuses) != 1 || statuses[0].NextRun.IsZero() {
+			t.Fatalf("statuses = %+v, want signage with its next run", statuses)
+		}
+		if run := statuses[0].LastRun; run != nil {
+			if run.Trigger != "manual" || run.Status != jobSucceeded || run.Rows != 1 {
+				t.Errorf("last run = %+v, want a succeeded manual run of 1 row", run)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("triggered run did not finish")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
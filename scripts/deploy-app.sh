@@ -79,7 +79,16 @@ build_and_push_image() {
     print_status "Building Docker image for AMD64 architecture..."
     print_status "Note: Building for AMD64 to ensure compatibility with EKS nodes"
 
-    if docker build --platform linux/amd64 -t $ECR_REPO_NAME .; then
+    VERSION=$(git describe --tags --always --dirty 2>/dev/null || echo dev)
+    GIT_COMMIT=$(git rev-parse HEAD 2>/dev/null || echo unknown)
+    BUILD_TIME=$(date -u +%Y-%m-%dT%H:%M:%SZ)
+    print_status "Version: $VERSION ($GIT_COMMIT)"
+
+    if docker build --platform linux/amd64 \
+        --build-arg VERSION="$VERSION" \
+        --build-arg GIT_COMMIT="$GIT_COMMIT" \
+        --build-arg BUILD_TIME="$BUILD_TIME" \
+        -t $ECR_REPO_NAME .; then
         print_success "Docker image built successfully for AMD64"
     else
         print_error "Failed to build Docker image"
@@ -74,7 +74,11 @@ echo -e "${GREEN}✅ kubectl context set to kind-${CLUSTER_NAME}${NC}"
 # Build Docker image
 echo -e "${BLUE}🏗️  Building Docker image...${NC}"
 echo -e "${YELLOW}📦 Building QR generator image...${NC}"
-docker build -t $IMAGE_NAME . >/dev/null
+docker build \
+    --build-arg VERSION="$(git describe --tags --always --dirty 2>/dev/null || echo dev)" \
+    --build-arg GIT_COMMIT="$(git rev-parse HEAD 2>/dev/null || echo unknown)" \
+    --build-arg BUILD_TIME="$(date -u +%Y-%m-%dT%H:%M:%SZ)" \
+    -t $IMAGE_NAME . >/dev/null
 echo -e "${GREEN}✅ Docker image built successfully${NC}"
 
 # Load image into kind cluster
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// secretVar is a configuration value holding a credential
+type secretVar struct {
+	name  string
+	value *string
+}
+
+// secretVars lists the credentials in cfg by environment variable name. Each
+// may be overridden by a Vault secret field of the same name.
+func secretVars(cfg *Config) []secretVar {
+	return []secretVar{
+		{"ADMIN_TOKEN", &cfg.AdminToken},
+		{"AMQP_URL", &cfg.AMQPURL},
+		{"API_KEYS", &cfg.APIKeys},
+		{"GOOGLE_SHEETS_CREDENTIALS", &cfg.GoogleSheetsCredentials},
+		{"HMAC_CLIENTS", &cfg.HMACClients},
+		{"MQTT_BROKER_URL", &cfg.MQTTBrokerURL},
+		{"NATS_URL", &cfg.NATSURL},
+		{"OIDC_CLIENT_SECRET", &cfg.OIDCClientSecret},
+		{"OIDC_SESSION_SECRET", &cfg.OIDCSessionSecret},
+		{"REDIS_URL", &cfg.RedisURL},
+		{"SENTRY_DSN", &cfg.SentryDSN},
+		{"SFTP_URL", &cfg.SFTPURL},
+		{"SIGNING_KEY", &cfg.SigningKey},
+		{"SIMPLE_API_LINK_SECRET", &cfg.SimpleAPILinkSecret},
+		{"SLACK_SIGNING_SECRET", &cfg.SlackSigningSecret},
+		{"SMTP_PASSWORD", &cfg.SMTPPassword},
+		{"VAULT_TOKEN", &cfg.VaultToken},
+		{"WEBHOOK_SECRET", &cfg.WebhookSecret},
+	}
+}
+
+// loadSecretFiles sets each credential from the file named by <NAME>_FILE
+// (typically a mounted Kubernetes Secret), taking precedence over <NAME>.
+// API_KEYS_FILE and HMAC_CLIENTS_FILE are not handled here: their entries are
+// merged with the inline list by loadSecretSpec.
+func loadSecretFiles(cfg *Config) error {
+	for _, secret := range secretVars(cfg) {
+		if secret.name == "API_KEYS" || secret.name == "HMAC_CLIENTS" {
+			continue
+		}
+		path := getEnv(secret.name+"_FILE", "")
+		if path == "" {
+			continue
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to read %s_FILE: %w", secret.name, err)
+		}
+		*secret.value = strings.TrimSpace(string(data))
+	}
+	return nil
+}
+
+// applySecrets overrides the credentials in cfg with the fields of a Vault
+// secret. It returns the names of the values that changed.
+func applySecrets(cfg *Config, values map[string]string) []string {
+	var changed []string
+	for _, secret := range secretVars(cfg) {
+		value, ok := values[secret.name]
+		if !ok || value == *secret.value {
+			continue
+		}
+		*secret.value = value
+		changed = append(changed, secret.name)
+	}
+	return changed
+}
+
+// rotateCredentials applies a re-read Vault secret on top of cfg. API keys and
+// HMAC clients are reloaded in place; other credentials are read once at
+// startup, so changing them only logs that a restart is needed.
+func rotateCredentials(cfg *Config, values map[string]string, apiKeys *apiKeyStore, signatures *hmacVerifier) {
+	for _, name := range applySecrets(cfg, values) {
+		var err error
+		switch {
+		case name == "API_KEYS":
+			var spec string
+			if spec, err = loadSecretSpec(cfg.APIKeys, cfg.APIKeysFile); err == nil {
+				err = apiKeys.Reload(spec)
+			}
+		case name == "HMAC_CLIENTS" && signatures != nil:
+			var spec string
+			if spec, err = loadSecretSpec(cfg.HMACClients, cfg.HMACClientsFile); err == nil {
+				err = signatures.Reload(spec)
+			}
+		default:
+			slog.Warn("secret changed in Vault, restart to apply", "secret", name)
+			continue
+		}
+		if err != nil {
+			slog.Error("failed to apply rotated secret, keeping previous value", "secret", name, "error", err)
+			continue
+		}
+		slog.Info("rotated secret applied", "secret", name)
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadSecretFiles(t *testing.T) {
+	dir := t.TempDir()
+	tokenFile := filepath.Join(dir, "admin-token")
+	if err := os.WriteFile(tokenFile, []byte("from-file\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		env       map[string]string
+		wantToken string
+		wantKeys  string
+		wantErr   bool
+	}{
+		{name: "no files", env: map[string]string{}, wantToken: "from-env", wantKeys: "ci=secret1"},
+		{name: "file overrides env", env: map[string]string{"ADMIN_TOKEN_FILE": tokenFile}, wantToken: "from-file", wantKeys: "ci=secret1"},
+		{name: "list files are left to loadSecretSpec", env: map[string]string{"API_KEYS_FILE": tokenFile}, wantToken: "from-env", wantKeys: "ci=secret1"},
+		{name: "missing file", env: map[string]string{"ADMIN_TOKEN_FILE": filepath.Join(dir, "missing")}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			cfg := Config{AdminToken: "from-env", APIKeys: "ci=secret1"}
+
+			err := loadSecretFiles(&cfg)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("loadSecretFiles() expected error but got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("loadSecretFiles() unexpected error: %v", err)
+			}
+			if cfg.AdminToken != tt.wantToken || cfg.APIKeys != tt.wantKeys {
+				t.Errorf("AdminToken = %q, APIKeys = %q, want %q, %q", cfg.AdminToken, cfg.APIKeys, tt.wantToken, tt.wantKeys)
+			}
+		})
+	}
+}
+
+func TestRotateCredentials(t *testing.T) {
+	cfg := Config{AdminToken: "admin", APIKeys: "ci=secret1", HMACClients: "billing=old"}
+	apiKeys, err := newAPIKeyStore(true, cfg.APIKeys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signatures, err := newHMACVerifier(cfg.HMACClients)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	changed := applySecrets(&Config{AdminToken: "admin"}, map[string]string{"ADMIN_TOKEN": "admin", "OTHER": "x"})
+	if len(changed) != 0 {
+		t.Errorf("applySecrets() changed %v, want nothing", changed)
+	}
+
+	rotateCredentials(&cfg, map[string]string{"API_KEYS": "ci=rotated", "HMAC_CLIENTS": "billing=new", "ADMIN_TOKEN": "rotated"}, apiKeys, signatures)
+
+	if _, ok := apiKeys.Authenticate("rotated"); !ok {
+		t.Error("rotated api key was not applied")
+	}
+	if _, ok := apiKeys.Authenticate("secret1"); ok {
+		t.Error("old api key still accepted")
+	}
+	if string(signatures.secrets["billing"]) != "new" {
+		t.Error("rotated HMAC secret was not applied")
+	}
+	if cfg.AdminToken != "rotated" || !strings.Contains(cfg.APIKeys, "rotated") {
+		t.Errorf("config not updated: %+v", cfg)
+	}
+}
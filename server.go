@@ -0,0 +1,599 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// handlerDeps holds the components the API handlers depend on
+type handlerDeps struct {
+	qrGen    *QRCodeGenerator
+	health   *healthRegistry
+	ready    *readiness
+	pod      podInfo
+	metrics  *metrics
+	flags    *featureFlags
+	reporter errorReporter
+	apiKeys  *apiKeyStore
+	// rateLimiter limits API requests per client IP; nil disables rate limiting
+	rateLimiter *ipRateLimiter
+	// jwt validates bearer tokens on the API routes; nil disables JWT authentication
+	jwt *jwtVerifier
+	// hmac verifies signed requests from machine clients; nil disables HMAC authentication
+	hmac *hmacVerifier
+	// signer signs and verifies JWS payloads; nil disables signed payloads
+	signer *payloadSigner
+	// tenants holds per-tenant settings and quota usage
+	tenants *tenantRegistry
+	// presets holds the saved image options of each tenant
+	presets *presetRegistry
+
+	// logLevel is the level of the running logger, adjustable at runtime
+	logLevel *slog.LevelVar
+	// adminToken authenticates mutating admin endpoints
+	adminToken string
+	// oidc provides SSO login for admin endpoints; nil disables it
+	oidc *oidcAuth
+
+	// requestTimeout bounds each API request; zero disables it
+	requestTimeout time.Duration
+	// maxInFlight caps concurrently served requests; zero disables the limit
+	maxInFlight int
+	// admission sheds anonymous requests first under overload; nil disables it
+	admission *admissionController
+	// compressMinBytes is the smallest known response size compressed; negative disables compression
+	compressMinBytes int
+	// audit records mutating operations; nil disables auditing
+	audit *auditLog
+	// policy restricts which URLs may be encoded
+	policy *contentPolicy
+	// cors configures cross-origin browser access; nil disables CORS
+	cors *corsPolicy
+	// maxBodyBytes caps request bodies; zero disables the limit
+	maxBodyBytes int64
+	// maxGzipBodyBytes caps gzip batch bodies once decompressed; zero disables the limit
+	maxGzipBodyBytes int64
+	// maxPayloadLength is the maximum number of characters encoded; zero disables the check
+	maxPayloadLength int
+	// sanitize handles invisible and control characters in payloads (reject by default)
+	sanitize sanitizeMode
+	// cache holds rendered images by payload; nil disables caching
+	cache *imageCache
+	// imageMaxAge is how long browsers and CDNs may cache deterministic images
+	imageMaxAge time.Duration
+	// deterministicOutput rejects signed and encrypted payloads, so identical
+	// requests always get byte-identical images
+	deterministicOutput bool
+	// renderPool bounds concurrent renders; nil leaves them unbounded
+	renderPool *renderPool
+	// batchMaxItems caps the items of a batch request; zero disables the limit
+	batchMaxItems int
+	// email delivers generated codes by email; nil disables email delivery
+	email *emailSender
+	// mqtt publishes generated codes to devices; nil disables MQTT delivery
+	mqtt *mqttPublisher
+	// printers print generated labels; nil disables printing
+	printers *ippPrinters
+	// sftp uploads batch results; nil disables SFTP delivery
+	sftp *sftpTarget
+	// sheets processes batches read from Google Sheets; nil disables them
+	sheets *sheetBatches
+	// schedules runs recurring batches; nil when none are configured
+	schedules *scheduler
+	// jobs processes jobs submitted to the jobs API; nil disables it
+	jobs *jobQueue
+	// slack answers the Slack slash command; nil disables it
+	slack *slackCommands
+	// simple serves the endpoints for low-code connectors; nil disables them
+	simple *simpleAPI
+	// embed serves the iframe page of other internal tools; nil disables it
+	embed *embedWidget
+	// webhooks sends lifecycle events to subscribers; nil disables webhooks
+	webhooks *webhookDispatcher
+	// deprecations announces deprecated routes; nil deprecates none
+	deprecations *deprecations
+
+	// proxies are trusted to report client IPs, for logs and the audit log,
+	// and the X-Forwarded-Prefix of links
+	proxies *trustedProxies
+	// basePath is the path prefix the service is mounted at; empty is the root
+	basePath string
+	// publicAllowlist and adminAllowlist restrict the public and admin
+	// listeners to client networks; nil allows everyone
+	publicAllowlist *ipAllowlist
+	adminAllowlist  *ipAllowlist
+}
+
+// newHandler builds the HTTP handler serving all API routes. Each route gets
+// an OpenTelemetry server span named after it, continuing incoming W3C trace
+// context, and deprecated routes announce their deprecation and sunset.
+//
+// Middleware, outermost first:
+//   - requestIDMiddleware tags the request with an X-Request-ID
+//   - servedByMiddleware names the serving pod
+//   - clientIPMiddleware resolves the client IP through deps.proxies
+//   - basePathMiddleware strips deps.basePath
+//   - loggingMiddleware writes the access log line
+//   - deps.publicAllowlist rejects clients outside the allowed networks
+//   - concurrencyLimitMiddleware caps requests in flight at deps.maxInFlight
+//   - deps.admission sheds load when the service is overloaded
+//   - deps.cors answers preflights before authentication
+//   - compressMiddleware compresses responses from deps.compressMinBytes
+//   - recoveryMiddleware recovers panics and reports 5xx responses
+//   - bodyLimitMiddleware caps request bodies at deps.maxBodyBytes
+//   - timeoutMiddleware cancels the request context after deps.requestTimeout
+func newHandler(deps handlerDeps) http.Handler {
+	qrGen := deps.qrGen
+
+	mux := http.NewServeMux()
+
+	handle := func(pattern string, handler http.HandlerFunc) {
+		handler = deps.deprecations.wrap(pattern, handler)
+		traced := func(w http.ResponseWriter, r *http.Request) {
+			trace.SpanFromContext(r.Context()).SetAttributes(attribute.String("request.id", requestIDFromContext(r.Context())))
+			handler(w, r)
+		}
+		mux.Handle(pattern, otelhttp.NewHandler(deps.metrics.instrument(pattern, http.HandlerFunc(traced)), pattern))
+	}
+
+	// Health and readiness, also served on the admin listener as /healthz and /readyz
+	// for probes; kept on the public port for existing clients
+	handle("/health", deps.health.ServeHTTP)
+	handle("/ready", deps.ready.ServeHTTP)
+
+	// Build and version information
+	handle("/version", handleVersion)
+
+	// QR code generation endpoint - POST with query parameters
+	handle("/api/v1/qr/generate", deps.rateLimiter.limitRequests(requireAuth(deps, deps.audit.record("qr.generate", deps.tenants.enforceQuota(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		text := r.URL.Query().Get("text")
+		if text == "" {
+			http.Error(w, "Missing required parameter 'text'. Usage: POST /api/v1/qr/generate?text=your-text-here", http.StatusBadRequest)
+			return
+		}
+
+		query, status, apiErr := deps.presets.applyPreset(r.Context(), r.URL.Query())
+		if apiErr != nil {
+			writeAPIError(w, r, status, *apiErr)
+			return
+		}
+		opts, apiErr := parseRenderOptions(query)
+		if apiErr != nil {
+			writeAPIError(w, r, http.StatusBadRequest, *apiErr)
+			return
+		}
+		disposition, apiErr := contentDisposition(query, opts.format)
+		if apiErr != nil {
+			writeAPIError(w, r, http.StatusBadRequest, *apiErr)
+			return
+		}
+		emailBundle, apiErr := parseEmailBundle(query, opts)
+		if apiErr != nil {
+			writeAPIError(w, r, http.StatusBadRequest, *apiErr)
+			return
+		}
+		key, apiErr := requestEncryptionKey(r)
+		if apiErr != nil {
+			writeAPIError(w, r, http.StatusBadRequest, *apiErr)
+			return
+		}
+		sign, signTTL, apiErr := signingOptions(r.URL.Query(), deps.signer)
+		if apiErr != nil {
+			writeAPIError(w, r, http.StatusBadRequest, *apiErr)
+			return
+		}
+		if deps.deterministicOutput && (sign || key != nil) {
+			writeAPIError(w, r, http.StatusBadRequest, apiError{
+				Code:    errCodeInvalidParam,
+				Message: "Signed and encrypted payloads differ on every request and are disabled in deterministic output mode",
+			})
+			return
+		}
+		expectedDigest, apiErr := parseExpectedDigest(r)
+		if apiErr != nil {
+			writeAPIError(w, r, http.StatusBadRequest, *apiErr)
+			return
+		}
+		delivery, apiErr := parseDelivery(r.URL.Query(), deps)
+		if apiErr != nil {
+			writeAPIError(w, r, http.StatusBadRequest, *apiErr)
+			return
+		}
+		if delivery != nil && opts.format != formatPNG {
+			writeAPIError(w, r, http.StatusBadRequest, apiError{Code: errCodeInvalidParam, Message: "Delivered codes are PNG images; parameter 'format' must be png"})
+			return
+		}
+		if delivery != nil && emailBundle {
+			writeAPIError(w, r, http.StatusBadRequest, apiError{Code: errCodeInvalidParam, Message: "Delivered codes are not returned; omit parameter 'response'"})
+			return
+		}
+		options := map[string]string{}
+		if delivery != nil {
+			options["deliver"] = delivery.channels()
+		}
+		if opts.format != formatPNG {
+			options["format"] = opts.format
+		}
+		if opts.darkMode {
+			options["dark_mode"] = "true"
+		}
+		if opts.optimize {
+			options["optimize"] = "true"
+		}
+		if name := query.Get("preset"); name != "" {
+			options["preset"] = name
+		}
+		if key != nil {
+			options["encrypted"] = "true"
+		}
+		if sign {
+			options["signed"] = "true"
+		}
+		if emailBundle {
+			options["response"] = responseEmail
+		}
+		setAuditPayload(r.Context(), text, options)
+
+		if status, apiErr := validatePayload(text, deps.maxPayloadLength); apiErr != nil {
+			writeAPIError(w, r, status, *apiErr)
+			return
+		}
+		// Sanitize before the content policy so invisible characters can't hide a denied URL
+		sanitize := deps.sanitize
+		if r.URL.Query().Get("allow_unsafe_chars") == "true" {
+			sanitize = sanitizeOff
+			options["unsafe_chars"] = "allowed"
+		}
+		if text, apiErr = sanitizePayload(text, sanitize); apiErr != nil {
+			writeAPIError(w, r, http.StatusBadRequest, *apiErr)
+			return
+		}
+		if apiErr := deps.policy.check(text, apiKeyName(r.Context())); apiErr != nil {
+			slog.InfoContext(r.Context(), "content rejected by policy", "reason", apiErr.Message)
+			writeAPIError(w, r, http.StatusUnprocessableEntity, *apiErr)
+			return
+		}
+
+		// Signed payloads are signed before encryption; only the ciphertext is
+		// encoded and the plaintext of encrypted payloads is never logged
+		payload := text
+		if sign {
+			var err error
+			if payload, err = deps.signer.Sign(text, signTTL); err != nil {
+				slog.ErrorContext(r.Context(), "failed to sign payload", "error", err)
+				setRequestError(r.Context(), err)
+				http.Error(w, "Failed to sign payload", http.StatusInternalServerError)
+				return
+			}
+		}
+		if key != nil {
+			var err error
+			if payload, err = encryptPayload(key, payload); err != nil {
+				slog.ErrorContext(r.Context(), "failed to encrypt payload", "error", err)
+				setRequestError(r.Context(), err)
+				http.Error(w, "Failed to encrypt payload", http.StatusInternalServerError)
+				return
+			}
+			addLogAttrs(r.Context(), slog.Bool("encrypted", true))
+		} else {
+			slog.DebugContext(r.Context(), "processing QR code generation request", "content", text)
+		}
+		addLogAttrs(r.Context(), slog.Int("payload_length", len(payload)))
+
+		render := func() ([]byte, error) {
+			return renderImage(r.Context(), deps.renderPool, qrGen, payload, opts)
+		}
+
+		// Signed and encrypted payloads are unique per request, so they are
+		// neither cached here nor by browsers and CDNs
+		deterministic := !sign && key == nil
+		cacheKey := opts.cacheKey(payload)
+		etag := imageETag(cacheKey)
+		if deterministic && delivery == nil && !emailBundle && etagMatches(r.Header.Get("If-None-Match"), etag) {
+			setImageCacheHeaders(w.Header(), etag, deps.imageMaxAge)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		setImageHeaders := func() {
+			if deterministic {
+				setImageCacheHeaders(w.Header(), etag, deps.imageMaxAge)
+				w.Header().Set("Last-Modified", renderModTime.Format(http.TimeFormat))
+			} else {
+				w.Header().Set("Cache-Control", "no-store")
+			}
+			if disposition != "" {
+				w.Header().Set("Content-Disposition", disposition)
+			}
+		}
+
+		var pngBytes []byte
+		var streamed bool
+		var err error
+		if deps.cache != nil && deterministic {
+			var result string
+			pngBytes, result, err = deps.cache.getOrRender(r.Context(), cacheKey, render)
+			if err == nil {
+				deps.metrics.cacheLookups.WithLabelValues(result).Inc()
+				addLogAttrs(r.Context(), slog.String("cache", result))
+			}
+		} else if delivery != nil || emailBundle || expectedDigest != nil {
+			pngBytes, err = render()
+		} else {
+			// Uncached images are streamed to the client while they are encoded
+			// instead of being buffered whole, with their digest in trailers.
+			// Errors after the first byte can only be logged.
+			_, err = deps.renderPool.run(r.Context(), func() ([]byte, error) {
+				ctx, span := tracer().Start(r.Context(), "QRCodeGenerator.GenerateQRCodeBytes",
+					trace.WithAttributes(attribute.Int("qr.payload_length", len(payload))))
+				defer span.End()
+				img, err := qrGen.EncodeWith(ctx, payload, opts)
+				if err != nil {
+					span.RecordError(err)
+					span.SetStatus(codes.Error, "QR code generation failed")
+					return nil, err
+				}
+
+				setImageHeaders()
+				w.Header().Set("Content-Type", opts.contentType())
+				streamed = true
+				dw := newDigestTrailerWriter(w)
+				n, err := img.WriteTo(dw)
+				dw.finish()
+				span.SetAttributes(attribute.Int64("qr.image_bytes", n))
+				if err != nil {
+					span.RecordError(err)
+					slog.WarnContext(ctx, "failed to stream QR code", "error", err, "bytes_written", n)
+				}
+				return nil, nil
+			})
+		}
+		if err != nil {
+			if writeContextError(w, r, err) {
+				return
+			}
+			if errors.Is(err, errRenderQueueFull) {
+				slog.WarnContext(r.Context(), "render queue full, rejecting request")
+				w.Header().Set("Retry-After", "1")
+				writeAPIError(w, r, http.StatusTooManyRequests, apiError{
+					Code:    errCodeServerBusy,
+					Message: "The server is busy, retry later",
+				})
+				return
+			}
+			if errors.Is(err, errPayloadTooLarge) {
+				writeAPIError(w, r, http.StatusRequestEntityTooLarge, apiError{
+					Code:    errCodePayloadTooLarge,
+					Message: "Parameter 'text' is too long to fit in a QR code",
+					Details: map[string]any{"bytes": len(payload)},
+				})
+				return
+			}
+			if errors.Is(err, errTextNotInMode) {
+				writeAPIError(w, r, http.StatusBadRequest, *apiErrorf(errCodeInvalidParam, nil, "Parameter 'text' can't be encoded in %s mode", opts.mode))
+				return
+			}
+			if errors.Is(err, errTextNotInCharset) {
+				writeAPIError(w, r, http.StatusBadRequest, *apiErrorf(errCodeInvalidParam, nil, "Parameter 'text' has characters that %s lacks", opts.charset))
+				return
+			}
+			slog.ErrorContext(r.Context(), "failed to generate QR code", "error", err)
+			setRequestError(r.Context(), err)
+			http.Error(w, "Failed to generate QR code", http.StatusInternalServerError)
+			return
+		}
+		deps.metrics.generatedTotal.Inc()
+		if streamed {
+			return
+		}
+
+		if delivery != nil {
+			delivered, channel, err := delivery.deliver(r.Context(), deps, text, payload, key != nil, pngBytes)
+			if err != nil {
+				if writeContextError(w, r, err) {
+					return
+				}
+				slog.ErrorContext(r.Context(), "failed to deliver QR code", "channel", channel, "error", err)
+				setRequestError(r.Context(), err)
+				writeAPIError(w, r, http.StatusBadGateway, apiError{
+					Code:    errCodeDeliveryFailed,
+					Message: "Failed to deliver the QR code by " + channel,
+					Details: map[string]any{"channel": channel},
+				})
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Cache-Control", "no-store")
+			json.NewEncoder(w).Encode(map[string]any{"delivered": delivered})
+			return
+		}
+
+		if emailBundle {
+			if err := writeEmailBundle(w, pngBytes, opts); err != nil {
+				slog.WarnContext(r.Context(), "failed to write email bundle", "error", err)
+			}
+			return
+		}
+
+		setImageHeaders()
+		writeImage(w, r, pngBytes, opts)
+	})))))
+
+	// Batch generation, gated by the batch feature flag
+	handle("/api/v1/qr/batch", deps.rateLimiter.limitRequests(deps.flags.requireFeature(flagBatch, requireAuth(deps, deps.audit.record("qr.batch", deps.tenants.enforceQuota(decodeRequestBody(deps.maxGzipBodyBytes, handleBatch(deps))))))))
+
+	// Serialized batches: codes numbered from a pattern, as a ZIP with a CSV manifest
+	handle("POST /api/v1/qr/batch/serial", deps.rateLimiter.limitRequests(deps.flags.requireFeature(flagBatch, requireAuth(deps, deps.audit.record("qr.batch.serial", deps.tenants.enforceQuota(decodeRequestBody(deps.maxGzipBodyBytes, handleSerialBatch(deps))))))))
+
+	// Batches laid out on label sheets as a print-ready PDF
+	handle("POST /api/v1/qr/labels", deps.rateLimiter.limitRequests(deps.flags.requireFeature(flagBatch, requireAuth(deps, deps.audit.record("qr.labels", deps.tenants.enforceQuota(decodeRequestBody(deps.maxGzipBodyBytes, handleLabels(deps))))))))
+
+	// Business cards with a QR code of their vCard, as PDF or SVG
+	handle("POST /api/v1/qr/business-card", deps.rateLimiter.limitRequests(requireAuth(deps, deps.audit.record("qr.business_card", deps.tenants.enforceQuota(handleBusinessCard(deps))))))
+
+	// Badges and tickets filled from an SVG template per row, as a PDF
+	handle("POST /api/v1/qr/badges", deps.rateLimiter.limitRequests(deps.flags.requireFeature(flagBatch, requireAuth(deps, deps.audit.record("qr.badges", deps.tenants.enforceQuota(decodeRequestBody(deps.maxGzipBodyBytes, handleBadges(deps))))))))
+
+	// Event signage posters with a headline and a large code, as PDF or PNG
+	handle("POST /api/v1/qr/poster", deps.rateLimiter.limitRequests(requireAuth(deps, deps.audit.record("qr.poster", deps.tenants.enforceQuota(handlePoster(deps))))))
+
+	// Batches read from and written back to Google Sheets
+	if deps.sheets != nil {
+		handle("POST /api/v1/qr/batch/sheet", deps.rateLimiter.limitRequests(deps.flags.requireFeature(flagBatch, requireAuth(deps, deps.audit.record("qr.batch.sheet", deps.tenants.enforceQuota(deps.sheets.handleBatch(deps)))))))
+	}
+
+	// Jobs processed in the background, retried with backoff and dead-lettered
+	// when out of attempts
+	if deps.jobs != nil {
+		handle("POST /api/v1/jobs", deps.rateLimiter.limitRequests(deps.flags.requireFeature(flagBatch, requireAuth(deps, deps.audit.record("jobs.submit", deps.tenants.enforceQuota(decodeRequestBody(deps.maxGzipBodyBytes, deps.jobs.handleSubmit(deps))))))))
+		handle("GET /api/v1/jobs/{id}", deps.rateLimiter.limitRequests(deps.flags.requireFeature(flagBatch, requireAuth(deps, deps.jobs.handleGet))))
+		handle("GET /api/v1/jobs/dead", deps.rateLimiter.limitRequests(deps.flags.requireFeature(flagBatch, requireAuth(deps, deps.jobs.handleDead))))
+		handle("POST /api/v1/jobs/dead/{id}/requeue", deps.rateLimiter.limitRequests(deps.flags.requireFeature(flagBatch, requireAuth(deps, deps.audit.record("jobs.requeue", deps.jobs.handleRequeue)))))
+	}
+
+	// Capacity of QR versions and the smallest version of a payload, without
+	// rendering
+	handle("GET /api/v1/qr/capacity", deps.rateLimiter.limitRequests(requireAuth(deps, handleCapacity(deps))))
+
+	// The version, mode, and printed size of the code of a payload, without
+	// rendering
+	handle("GET /api/v1/qr/info", deps.rateLimiter.limitRequests(requireAuth(deps, handleInfo(deps))))
+
+	// Whether two codes, images or texts with options, hold the same content,
+	// and the modules they differ in
+	handle("POST /api/v1/qr/compare", deps.rateLimiter.limitRequests(requireAuth(deps, handleCompare(deps))))
+
+	// Custom payload types compiled in with RegisterPayloadBuilder
+	for _, b := range registeredPayloadBuilders() {
+		handle("/api/v1/qr/"+b.Type(), deps.rateLimiter.limitRequests(requireAuth(deps, deps.audit.record("qr."+b.Type(), deps.tenants.enforceQuota(handlePayloadType(deps, b))))))
+	}
+
+	// Linear barcodes, Data Matrix, and PDF417 symbols beside the QR API, and
+	// the densest of them all for a payload
+	handle("POST /api/v1/barcode/generate", deps.rateLimiter.limitRequests(requireAuth(deps, deps.audit.record("barcode.generate", deps.tenants.enforceQuota(handleBarcode(deps))))))
+	handle("POST /api/v1/barcode/datamatrix", deps.rateLimiter.limitRequests(requireAuth(deps, deps.audit.record("barcode.datamatrix", deps.tenants.enforceQuota(handleDataMatrix(deps))))))
+	handle("POST /api/v1/barcode/pdf417", deps.rateLimiter.limitRequests(requireAuth(deps, deps.audit.record("barcode.pdf417", deps.tenants.enforceQuota(handlePDF417(deps))))))
+	handle("POST /api/v1/barcode/auto", deps.rateLimiter.limitRequests(requireAuth(deps, deps.audit.record("barcode.auto", deps.tenants.enforceQuota(handleAutoBarcode(deps))))))
+
+	// Tenant-scoped information and activity of the authenticated caller
+	handle("GET /api/v1/tenant", deps.rateLimiter.limitRequests(requireAuth(deps, deps.tenants.handleInfo)))
+	if deps.audit != nil {
+		handle("GET /api/v1/tenant/activity", deps.rateLimiter.limitRequests(requireAuth(deps, deps.audit.handleTenantActivity)))
+	}
+
+	// Saved image options of the caller's tenant, applied with ?preset=
+	if deps.presets != nil {
+		handle("GET /api/v1/presets", deps.rateLimiter.limitRequests(requireAuth(deps, deps.presets.handleList)))
+		handle("GET /api/v1/presets/{name}", deps.rateLimiter.limitRequests(requireAuth(deps, deps.presets.handleGet)))
+		handle("PUT /api/v1/presets/{name}", deps.rateLimiter.limitRequests(requireAuth(deps, deps.audit.record("preset.save", deps.presets.handlePut))))
+		handle("DELETE /api/v1/presets/{name}", deps.rateLimiter.limitRequests(requireAuth(deps, deps.audit.record("preset.delete", deps.presets.handleDelete))))
+	}
+
+	// Verification of signed payloads and the public signing key
+	if deps.signer != nil {
+		handle("/api/v1/qr/verify", deps.rateLimiter.limitRequests(deps.signer.handleVerify))
+		handle("GET /.well-known/jwks.json", deps.signer.handleJWKS)
+	}
+
+	// Slack slash command, authenticated by Slack's request signature, and the
+	// signed image links its responses reference
+	if deps.slack != nil {
+		handle("POST /slack/command", deps.audit.record("qr.slack", deps.slack.handleCommand(deps)))
+		handle("GET /slack/image", deps.rateLimiter.limitRequests(deps.slack.handleImage(deps)))
+	}
+
+	// Flat-field endpoints for low-code connectors, answering with signed image
+	// links instead of binary images
+	if deps.simple != nil {
+		handle("/api/v1/simple/qr", deps.rateLimiter.limitRequests(requireAuth(deps, deps.audit.record("qr.simple", deps.tenants.enforceQuota(deps.simple.handleQR(deps))))))
+		handle("GET /api/v1/simple/image", deps.rateLimiter.limitRequests(deps.simple.handleImage(deps)))
+	}
+
+	// Minimal page with the code for iframes of other internal tools
+	if deps.embed != nil {
+		handle("GET /embed", deps.rateLimiter.limitRequests(requireAuth(deps, deps.audit.record("qr.embed", deps.tenants.enforceQuota(deps.embed.handleEmbed(deps))))))
+	}
+
+	// Generator page for the browser, gated by the ui feature flag and, with
+	// SSO, by a session; the login is served here too, so browsers log in on
+	// the host they reach the page on
+	if deps.oidc != nil {
+		handle("GET /auth/login", deps.oidc.handleLogin)
+		handle("GET /auth/callback", deps.oidc.handleCallback)
+		handle("POST /auth/logout", deps.oidc.handleLogout)
+		handle("GET /auth/me", deps.oidc.handleMe)
+	}
+	handle("GET /ui", deps.flags.requireFeature(flagUI, func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, basePathFromContext(r.Context())+"/ui/", http.StatusMovedPermanently)
+	}))
+	handle("GET /ui/", deps.flags.requireFeature(flagUI, deps.oidc.requireSession(handleUI())))
+	// Live preview of the generator page over a WebSocket
+	handle("GET "+previewPath, deps.rateLimiter.limitRequests(deps.flags.requireFeature(flagUI, deps.oidc.requireSession(previewCredentials(requireAuth(deps, handlePreview(deps)))))))
+
+	// Decryption of encrypted payloads, gated by the decode feature flag
+	handle("/api/v1/qr/decode", deps.rateLimiter.limitRequests(deps.flags.requireFeature(flagDecode, requireAuth(deps, deps.audit.record("qr.decode", handleDecode)))))
+
+	// Root endpoint
+	handle("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprintf(w, "QR Code Generator API")
+	})
+
+	return requestIDMiddleware(servedByMiddleware(deps.pod, clientIPMiddleware(deps.proxies, basePathMiddleware(deps.basePath, deps.proxies, loggingMiddleware(deps.publicAllowlist.middleware(concurrencyLimitMiddleware(deps.maxInFlight, deps.admission.middleware(deps.cors.middleware(compressMiddleware(deps.compressMinBytes, recoveryMiddleware(deps.reporter, bodyLimitMiddleware(deps.maxBodyBytes, timeoutMiddleware(deps.requestTimeout, mux)))))))))))))
+}
+
+// renderPNG renders payload to PNG on the render pool, in a traced span
+func renderPNG(ctx context.Context, pool *renderPool, qrGen *QRCodeGenerator, payload string) ([]byte, error) {
+	return renderImage(ctx, pool, qrGen, payload, defaultRenderOptions)
+}
+
+// renderImage renders payload with opts on the render pool, in a traced span
+func renderImage(ctx context.Context, pool *renderPool, qrGen *QRCodeGenerator, payload string, opts renderOptions) ([]byte, error) {
+	return pool.run(ctx, func() ([]byte, error) {
+		ctx, span := tracer().Start(ctx, "QRCodeGenerator.GenerateQRCodeBytes",
+			trace.WithAttributes(attribute.Int("qr.payload_length", len(payload))))
+		defer span.End()
+		var imageBytes []byte
+		img, err := qrGen.EncodeWith(ctx, payload, opts)
+		if err == nil {
+			imageBytes, err = img.Bytes()
+		}
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "QR code generation failed")
+			return nil, err
+		}
+		span.SetAttributes(attribute.Int("qr.image_bytes", len(imageBytes)))
+		return imageBytes, nil
+	})
+}
+
+// writePNG serves a rendered QR code
+func writePNG(w http.ResponseWriter, r *http.Request, pngBytes []byte) {
+	writeImage(w, r, pngBytes, defaultRenderOptions)
+}
+
+// writeImage serves a QR code rendered with opts
+func writeImage(w http.ResponseWriter, r *http.Request, imageBytes []byte, opts renderOptions) {
+	w.Header().Set("Content-Type", opts.contentType())
+	w.Header().Set("Content-Length", fmt.Sprintf("%d", len(imageBytes)))
+	serveImage(w, r, "qrcode."+opts.format, imageBytes)
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// newTestDeps returns default handler dependencies for tests
+func newTestDeps() handlerDeps {
+	return handlerDeps{
+		qrGen:    &QRCodeGenerator{},
+		health:   newHealthRegistry(),
+		ready:    &readiness{},
+		pod:      podInfo{Name: "test-pod"},
+		metrics:  newMetrics(),
+		flags:    mustFeatureFlags(""),
+		reporter: multiReporter{},
+		apiKeys:  &apiKeyStore{keys: map[string]*apiKey{}},
+
+		logLevel:   new(slog.LevelVar),
+		adminToken: "test-admin-token",
+
+		maxPayloadLength: 2048,
+		audit:            &auditLog{events: make([]auditEvent, 100)},
+		policy:           &contentPolicy{Default: policyRules{DenySchemes: defaultDeniedSchemes}},
+		tenants:          &tenantRegistry{configs: map[string]tenantConfig{}, now: time.Now, usage: map[string]int{}},
+		presets:          &presetRegistry{store: &memoryPresetStore{presets: map[string]map[string]preset{}}},
+	}
+}
+
+// mustFeatureFlags builds feature flags from overrides, panicking on invalid input
+func mustFeatureFlags(overrides string) *featureFlags {
+	flags, err := newFeatureFlags(overrides)
+	if err != nil {
+		panic(err)
+	}
+	return flags
+}
+
+// newTestHandler builds the API handler with default test dependencies
+func newTestHandler() http.Handler {
+	return newHandler(newTestDeps())
+}
+
+func TestHandler_Routes(t *testing.T) {
+	handler := newTestHandler()
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		wantStatus int
+		wantType   string
+	}{
+		{
+			name:       "health",
+			method:     http.MethodGet,
+			path:       "/health",
+			wantStatus: http.StatusOK,
+			wantType:   "application/json",
+		},
+		{
+			name:       "version",
+			method:     http.MethodGet,
+			path:       "/version",
+			wantStatus: http.StatusOK,
+			wantType:   "application/json",
+		},
+		{
+			name:       "generate",
+			method:     http.MethodPost,
+			path:       "/api/v1/qr/generate?text=hello",
+			wantStatus: http.StatusOK,
+			wantType:   "image/png",
+		},
+		{
+			name:       "generate missing text",
+			method:     http.MethodPost,
+			path:       "/api/v1/qr/generate",
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "generate wrong method",
+			method:     http.MethodGet,
+			path:       "/api/v1/qr/generate?text=hello",
+			wantStatus: http.StatusMethodNotAllowed,
+		},
+		{
+			name:       "root",
+			method:     http.MethodGet,
+			path:       "/",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "unknown path",
+			method:     http.MethodGet,
+			path:       "/nope",
+			wantStatus: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			rec := httptest.NewRecorder()
+
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("%s %s status = %d, want %d", tt.method, tt.path, rec.Code, tt.wantStatus)
+			}
+			if tt.wantType != "" && rec.Header().Get("Content-Type") != tt.wantType {
+				t.Errorf("%s %s Content-Type = %q, want %q", tt.method, tt.path, rec.Header().Get("Content-Type"), tt.wantType)
+			}
+		})
+	}
+}
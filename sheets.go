@@ -0,0 +1,472 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// errCodeSheetUnavailable is returned when a spreadsheet can't be read or updated
+const errCodeSheetUnavailable = "sheet_unavailable"
+
+// sheetsScope grants reading and writing spreadsheets
+const sheetsScope = "https://www.googleapis.com/auth/spreadsheets"
+
+// serviceAccountKey is the part of a Google service account key file used here
+type serviceAccountKey struct {
+	Type        string `json:"type"`
+	ClientEmail string `json:"client_email"`
+	PrivateKey  string `json:"private_key"`
+	TokenURI    string `json:"token_uri"`
+}
+
+// sheetsClient is a minimal client of the Google Sheets values API,
+// authenticated as a service account through the JWT bearer grant. Access
+// tokens are cached until shortly before they expire.
+type sheetsClient struct {
+	email    string
+	key      *rsa.PrivateKey
+	tokenURL string
+	apiURL   string
+	client   *http.Client
+	now      func() time.Time
+
+	mu     sync.Mutex
+	token  string
+	expiry time.Time
+}
+
+// newSheetsClient parses a service account key file's JSON. apiURL is the
+// Sheets API base URL. It returns nil when credentials is empty.
+func newSheetsClient(credentials, apiURL string) (*sheetsClient, error) {
+	if credentials == "" {
+		return nil, nil
+	}
+	var sa serviceAccountKey
+	if err := json.Unmarshal([]byte(credentials), &sa); err != nil {
+		return nil, fmt.Errorf("invalid Google service account key: %w", err)
+	}
+	if sa.Type != "service_account" || sa.ClientEmail == "" {
+		return nil, fmt.Errorf("invalid Google service account key: want a service_account key with client_email")
+	}
+	key, err := jwt.ParseRSAPrivateKeyFromPEM([]byte(sa.PrivateKey))
+	if err != nil {
+		return nil, fmt.Errorf("invalid Google service account private key: %w", err)
+	}
+	if sa.TokenURI == "" {
+		sa.TokenURI = "https://oauth2.googleapis.com/token"
+	}
+	return &sheetsClient{
+		email:    sa.ClientEmail,
+		key:      key,
+		tokenURL: sa.TokenURI,
+		apiURL:   strings.TrimSuffix(apiURL, "/"),
+		client:   newOutboundClient(30 * time.Second),
+		now:      time.Now,
+	}, nil
+}
+
+// accessToken returns a cached token or exchanges a signed assertion for a
+// new one
+func (c *sheetsClient) accessToken(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.now()
+	if c.token != "" && now.Before(c.expiry) {
+		return c.token, nil
+	}
+
+	assertion, err := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss":   c.email,
+		"scope": sheetsScope,
+		"aud":   c.tokenURL,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	}).SignedString(c.key)
+	if err != nil {
+		return "", err
+	}
+	form := url.Values{"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"}, "assertion": {assertion}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	var token struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := c.do(req, &token); err != nil {
+		return "", fmt.Errorf("fetching access token: %w", err)
+	}
+	if token.AccessToken == "" {
+		return "", fmt.Errorf("fetching access token: empty token in response")
+	}
+	c.token = token.AccessToken
+	// Refresh a minute early so a token doesn't expire mid-request
+	c.expiry = now.Add(time.Duration(token.ExpiresIn)*time.Second - time.Minute)
+	return c.token, nil
+}
+
+// call sends an authenticated API request with an optional JSON body and
+// decodes the JSON response into out, if not nil
+func (c *sheetsClient) call(ctx context.Context, method, path string, body, out any) error {
+	token, err := c.accessToken(ctx)
+	if err != nil {
+		return err
+	}
+	var reader io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(encoded)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.apiURL+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return c.do(req, out)
+}
+
+// do sends req, turning error statuses into errors
+func (c *sheetsClient) do(req *http.Request, out any) error {
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(message))
+	}
+	if out == nil {
+		io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// values reads a range in A1 notation as rows of formatted cell values.
+// Trailing empty rows and cells are omitted.
+func (c *sheetsClient) values(ctx context.Context, spreadsheetID, a1Range string) ([][]string, error) {
+	var out struct {
+		Values [][]string `json:"values"`
+	}
+	path := "/v4/spreadsheets/" + url.PathEscape(spreadsheetID) + "/values/" + url.PathEscape(a1Range) + "?majorDimension=ROWS&valueRenderOption=FORMATTED_VALUE"
+	if err := c.call(ctx, http.MethodGet, path, nil, &out); err != nil {
+		return nil, err
+	}
+	return out.Values, nil
+}
+
+// sheetValueRange is a range to write. Nil values leave their cell unchanged.
+type sheetValueRange struct {
+	Range  string  `json:"range"`
+	Values [][]any `json:"values"`
+}
+
+// update writes the ranges in one request; values are stored as entered
+func (c *sheetsClient) update(ctx context.Context, spreadsheetID string, data []sheetValueRange) error {
+	body := map[string]any{"valueInputOption": "RAW", "data": data}
+	return c.call(ctx, http.MethodPost, "/v4/spreadsheets/"+url.PathEscape(spreadsheetID)+"/values:batchUpdate", body, nil)
+}
+
+// sheetBatchRequest is the body of POST /api/v1/qr/batch/sheet. Columns are
+// letters such as "A" or "AB".
+type sheetBatchRequest struct {
+	SpreadsheetID string `json:"spreadsheet_id"`
+	// Sheet is the name of the tab to read
+	Sheet string `json:"sheet"`
+	// FirstRow is the first data row; the default 2 skips a header row
+	FirstRow int `json:"first_row"`
+	// LastRow is the last row to read; zero reads to the last row with data
+	LastRow int `json:"last_row"`
+	// Columns maps job fields to the columns holding them
+	Columns struct {
+		Text string `json:"text"`
+		ID   string `json:"id"`
+	} `json:"columns"`
+	// StatusColumn and URLColumn receive each row's outcome; empty skips them
+	StatusColumn string `json:"status_column"`
+	URLColumn    string `json:"url_column"`
+}
+
+// sheetRowResult is the outcome of one row
+type sheetRowResult struct {
+	Row int `json:"row"`
+	jobResult
+	job generationJob
+}
+
+// sheetBatches renders the rows of Google Sheets into the object store and
+// writes each row's status and image URL back, so a spreadsheet of labels
+// can be processed without exporting it first
+type sheetBatches struct {
+	client    *sheetsClient
+	processor *jobProcessor
+}
+
+// handleBatch processes the rows of the requested sheet. Rows without text
+// are skipped and left unchanged. Rows are rendered in parallel by as many
+// goroutines as the render pool has workers, like batch items.
+func (s *sheetBatches) handleBatch(deps handlerDeps) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		var body sheetBatchRequest
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeAPIError(w, r, http.StatusBadRequest, apiError{
+				Code:    errCodeInvalidPayload,
+				Message: `Invalid body. Usage: POST /api/v1/qr/batch/sheet with {"spreadsheet_id": "...", "sheet": "...", "columns": {"text": "A"}}`,
+			})
+			return
+		}
+		columns, apiErr := body.columns()
+		if apiErr != nil {
+			writeAPIError(w, r, http.StatusBadRequest, *apiErr)
+			return
+		}
+
+		jobs, err := s.read(ctx, &body, columns)
+		if err != nil {
+			if ctx.Err() != nil {
+				writeContextError(w, r, ctx.Err())
+				return
+			}
+			slog.WarnContext(ctx, "failed to read spreadsheet", "spreadsheet_id", body.SpreadsheetID, "error", err)
+			writeAPIError(w, r, http.StatusBadGateway, s.unavailable("Failed to read the sheet; check that it is shared with the service account"))
+			return
+		}
+		if deps.batchMaxItems > 0 && len(jobs) > deps.batchMaxItems {
+			writeAPIError(w, r, http.StatusRequestEntityTooLarge, *apiErrorf(errCodePayloadTooLarge,
+				map[string]any{"items": len(jobs), "max_items": deps.batchMaxItems},
+				"Sheet has %d rows, the maximum is %d", len(jobs), deps.batchMaxItems))
+			return
+		}
+		setAuditPayload(ctx, fmt.Sprintf("sheet of %d rows", len(jobs)), map[string]string{"spreadsheet_id": body.SpreadsheetID, "sheet": body.Sheet, "items": fmt.Sprint(len(jobs))})
+
+		failed := s.render(ctx, deps.renderPool.size(), jobs)
+		if err := ctx.Err(); err != nil {
+			writeContextError(w, r, err)
+			return
+		}
+		succeeded := len(jobs) - failed
+		if writeBack := body.writeBack(columns, jobs); len(writeBack) > 0 {
+			if err := s.client.update(ctx, body.SpreadsheetID, writeBack); err != nil {
+				slog.WarnContext(ctx, "failed to update spreadsheet", "spreadsheet_id", body.SpreadsheetID, "error", err)
+				writeAPIError(w, r, http.StatusBadGateway, s.unavailable("Rendered %d rows but failed to update the sheet", succeeded))
+				return
+			}
+		}
+		addLogAttrs(ctx, slog.Int("batch_items", len(jobs)), slog.Int("batch_failed", failed))
+		deps.webhooks.Emit(ctx, eventBatchCompleted, batchCompleted{
+			RequestID: requestIDFromContext(ctx),
+			Tenant:    tenantFromContext(ctx),
+			Items:     len(jobs),
+			Succeeded: succeeded,
+			Failed:    failed,
+		})
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		json.NewEncoder(w).Encode(map[string]any{
+			"rows":      jobs,
+			"succeeded": succeeded,
+			"failed":    failed,
+		})
+	}
+}
+
+// read returns the rows of the requested range that have text, with their
+// jobs
+func (s *sheetBatches) read(ctx context.Context, body *sheetBatchRequest, columns sheetColumns) ([]sheetRowResult, error) {
+	a1Range := fmt.Sprintf("%s!A%d:%s", quoteSheetName(body.Sheet), body.FirstRow, columnName(columns.last))
+	if body.LastRow > 0 {
+		a1Range += strconv.Itoa(body.LastRow)
+	}
+	rows, err := s.client.values(ctx, body.SpreadsheetID, a1Range)
+	if err != nil {
+		return nil, fmt.Errorf("read %s: %w", a1Range, err)
+	}
+
+	var jobs []sheetRowResult
+	for i, row := range rows {
+		job := generationJob{Text: cell(row, columns.text), ID: cell(row, columns.id)}
+		if strings.TrimSpace(job.Text) == "" {
+			continue
+		}
+		jobs = append(jobs, sheetRowResult{Row: body.FirstRow + i, job: job})
+	}
+	return jobs, nil
+}
+
+// render processes the jobs of rows on up to workers goroutines, storing
+// each result in its row, and returns the number of failed rows. Rows not
+// processed because ctx is done are left without a status.
+func (s *sheetBatches) render(ctx context.Context, workers int, rows []sheetRowResult) (failed int) {
+	batch := make([]generationJob, len(rows))
+	for i := range rows {
+		batch[i] = rows[i].job
+	}
+	for i, result := range s.processor.processAll(ctx, workers, batch) {
+		rows[i].jobResult = result
+		if result.Status != jobSucceeded {
+			failed++
+		}
+	}
+	return failed
+}
+
+// unavailable is the error of a failed Sheets API call
+func (s *sheetBatches) unavailable(format string, args ...any) apiError {
+	return *apiErrorf(errCodeSheetUnavailable, map[string]any{"service_account": s.client.email}, format, args...)
+}
+
+// sheetColumns are the zero-based indexes of the requested columns; -1 when
+// not requested
+type sheetColumns struct {
+	text, id, status, url int
+	// last is the rightmost column to read
+	last int
+}
+
+// columns validates the request and returns its column indexes
+func (body *sheetBatchRequest) columns() (sheetColumns, *apiError) {
+	invalid := func(message string) (sheetColumns, *apiError) {
+		return sheetColumns{}, &apiError{Code: errCodeInvalidParam, Message: message}
+	}
+	if body.SpreadsheetID == "" || body.Sheet == "" {
+		return invalid("Fields 'spreadsheet_id' and 'sheet' are required")
+	}
+	if body.FirstRow == 0 {
+		body.FirstRow = 2
+	}
+	if body.FirstRow < 1 || (body.LastRow != 0 && body.LastRow < body.FirstRow) {
+		return invalid("Field 'first_row' must be at least 1 and 'last_row' at least 'first_row'")
+	}
+
+	var c sheetColumns
+	var err error
+	if c.text, err = columnIndex(body.Columns.Text); err != nil || c.text < 0 {
+		return invalid("Field 'columns.text' must be a column letter such as 'A'")
+	}
+	for _, column := range []struct {
+		name  string
+		value string
+		index *int
+	}{
+		{"columns.id", body.Columns.ID, &c.id},
+		{"status_column", body.StatusColumn, &c.status},
+		{"url_column", body.URLColumn, &c.url},
+	} {
+		if *column.index, err = columnIndex(column.value); err != nil {
+			return invalid(fmt.Sprintf("Field '%s' must be a column letter such as 'A'", column.name))
+		}
+	}
+	if c.status >= 0 && (c.status == c.text || c.status == c.id || c.status == c.url) ||
+		c.url >= 0 && (c.url == c.text || c.url == c.id) {
+		return invalid("Fields 'status_column' and 'url_column' must not overwrite the input columns or each other")
+	}
+	c.last = max(c.text, c.id)
+	return c, nil
+}
+
+// writeBack returns the status and URL cells of the processed rows. Skipped
+// rows between them are left unchanged; the URL of a failed row is cleared.
+func (body *sheetBatchRequest) writeBack(c sheetColumns, rows []sheetRowResult) []sheetValueRange {
+	if len(rows) == 0 {
+		return nil
+	}
+	first, last := rows[0].Row, rows[len(rows)-1].Row
+	var data []sheetValueRange
+	for _, column := range []struct {
+		index int
+		value func(sheetRowResult) any
+	}{
+		{c.status, func(row sheetRowResult) any {
+			if row.Error != nil {
+				return row.Status + ": " + row.Error.Message
+			}
+			return row.Status
+		}},
+		{c.url, func(row sheetRowResult) any { return row.URL }},
+	} {
+		if column.index < 0 {
+			continue
+		}
+		values := make([][]any, last-first+1)
+		for i := range values {
+			values[i] = []any{nil}
+		}
+		for _, row := range rows {
+			if row.Status != "" {
+				values[row.Row-first] = []any{column.value(row)}
+			}
+		}
+		name := columnName(column.index)
+		data = append(data, sheetValueRange{
+			Range:  fmt.Sprintf("%s!%s%d:%s%d", quoteSheetName(body.Sheet), name, first, name, last),
+			Values: values,
+		})
+	}
+	return data
+}
+
+// columnIndex converts a column letter such as "A" or "AB" to its zero-based
+// index. It returns -1 for an empty column.
+func columnIndex(column string) (int, error) {
+	if column == "" {
+		return -1, nil
+	}
+	if len(column) > 3 {
+		return 0, errors.New("column out of range")
+	}
+	index := 0
+	for _, r := range strings.ToUpper(column) {
+		if r < 'A' || r > 'Z' {
+			return 0, fmt.Errorf("invalid column %q", column)
+		}
+		index = index*26 + int(r-'A') + 1
+	}
+	return index - 1, nil
+}
+
+// columnName is the inverse of columnIndex
+func columnName(index int) string {
+	var name []byte
+	for index++; index > 0; index = (index - 1) / 26 {
+		name = append([]byte{byte('A' + (index-1)%26)}, name...)
+	}
+	return string(name)
+}
+
+// quoteSheetName quotes a sheet name for A1 notation
+func quoteSheetName(name string) string {
+	return "'" + strings.ReplaceAll(name, "'", "''") + "'"
+}
+
+// cell returns the value at index of row, or "" past its end
+func cell(row []string, index int) string {
+	if index < 0 || index >= len(row) {
+		return ""
+	}
+	return row[index]
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// fakeSheets serves the token endpoint and the values API for one sheet
+type fakeSheets struct {
+	t         *testing.T
+	key       *rsa.PrivateKey
+	server    *httptest.Server
+	values    [][]string
+	readErr   bool
+	updateErr bool
+
+	mu      sync.Mutex
+	tokens  int
+	ranges  []string
+	updates []sheetValueRange
+}
+
+func newFakeSheets(t *testing.T) *fakeSheets {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeSheets{t: t, key: key}
+	f.server = httptest.NewServer(f)
+	t.Cleanup(f.server.Close)
+	return f
+}
+
+// credentials returns a service account key file for the fake
+func (f *fakeSheets) credentials() string {
+	der, err := x509.MarshalPKCS8PrivateKey(f.key)
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	key, _ := json.Marshal(serviceAccountKey{
+		Type:        "service_account",
+		ClientEmail: "qr@project.iam.gserviceaccount.com",
+		PrivateKey:  string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+		TokenURI:    f.server.URL + "/token",
+	})
+	return string(key)
+}
+
+func (f *fakeSheets) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if r.URL.Path == "/token" {
+		claims := jwt.MapClaims{}
+		_, err := jwt.ParseWithClaims(r.FormValue("assertion"), claims, func(*jwt.Token) (any, error) { return &f.key.PublicKey, nil },
+			jwt.WithValidMethods([]string{"RS256"}), jwt.WithAudience(f.server.URL+"/token"))
+		if err != nil || r.FormValue("grant_type") != "urn:ietf:params:oauth:grant-type:jwt-bearer" || claims["scope"] != sheetsScope {
+			http.Error(w, "invalid_grant", http.StatusBadRequest)
+			return
+		}
+		f.tokens++
+		json.NewEncoder(w).Encode(map[string]any{"access_token": "token-1", "expires_in": 3600})
+		return
+	}
+	if r.Header.Get("Authorization") != "Bearer token-1" {
+		http.Error(w, "unauthenticated", http.StatusUnauthorized)
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/v4/spreadsheets/sheet-1/values/"):
+		if f.readErr {
+			http.Error(w, `{"error": {"status": "PERMISSION_DENIED"}}`, http.StatusForbidden)
+			return
+		}
+		f.ranges = append(f.ranges, strings.TrimPrefix(r.URL.Path, "/v4/spreadsheets/sheet-1/values/"))
+		json.NewEncoder(w).Encode(map[string]any{"values": f.values})
+	case r.Method == http.MethodPost && r.URL.Path == "/v4/spreadsheets/sheet-1/values:batchUpdate":
+		if f.updateErr {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		var body struct {
+			ValueInputOption string            `json:"valueInputOption"`
+			Data             []sheetValueRange `json:"data"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.ValueInputOption != "RAW" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		f.updates = append(f.updates, body.Data...)
+		w.Write([]byte(`{}`))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// newSheetDeps returns deps serving sheet batches from f into store
+func newSheetDeps(t *testing.T, f *fakeSheets, store objectStore) handlerDeps {
+	t.Helper()
+	client, err := newSheetsClient(f.credentials(), f.server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deps := newTestDeps()
+	deps.flags = mustFeatureFlags("batch")
+	deps.sheets = &sheetBatches{client: client, processor: newTestJobProcessor(store)}
+	return deps
+}
+
+func postSheetBatch(deps handlerDeps, body string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	newHandler(deps).ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v1/qr/batch/sheet", strings.NewReader(body)))
+	return rec
+}
+
+func TestSheetBatch(t *testing.T) {
+	f := newFakeSheets(t)
+	f.values = [][]string{
+		{"https://example.com/a", "A-1"},
+		{},
+		{"hello", "../etc"},
+		{"world"},
+	}
+	store := &memoryStore{}
+	deps := newSheetDeps(t, f, store)
+
+	rec := postSheetBatch(deps, `{"spreadsheet_id": "sheet-1", "sheet": "Labels", "columns": {"text": "A", "id": "B"}, "status_column": "C", "url_column": "D"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+	}
+	var body struct {
+		Rows      []sheetRowResult `json:"rows"`
+		Succeeded int              `json:"succeeded"`
+		Failed    int              `json:"failed"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if len(body.Rows) != 3 || body.Succeeded != 2 || body.Failed != 1 {
+		t.Fatalf("response = %s, want 2 of 3 rows succeeded", rec.Body.String())
+	}
+	if row := body.Rows[0]; row.Row != 2 || row.Status != jobSucceeded || row.URL != "mem://A-1.png" {
+		t.Errorf("row 2 = %+v", row)
+	}
+	if row := body.Rows[1]; row.Row != 4 || row.Error == nil || row.Error.Code != errCodeInvalidParam {
+		t.Errorf("row 4 = %+v, want an invalid id", row)
+	}
+	if row := body.Rows[2]; row.Row != 5 || row.Status != jobSucceeded {
+		t.Errorf("row 5 = %+v", row)
+	}
+	if len(store.objects) != 2 {
+		t.Errorf("stored %d images, want 2", len(store.objects))
+	}
+
+	if len(f.ranges) != 1 || f.ranges[0] != "'Labels'!A2:B" {
+		t.Errorf("read ranges %q, want 'Labels'!A2:B", f.ranges)
+	}
+	if len(f.updates) != 2 {
+		t.Fatalf("wrote %d ranges, want status and URL", len(f.updates))
+	}
+	status, urls := f.updates[0], f.updates[1]
+	if status.Range != "'Labels'!C2:C5" || urls.Range != "'Labels'!D2:D5" {
+		t.Errorf("wrote ranges %q and %q", status.Range, urls.Range)
+	}
+	want := []any{"succeeded", nil, "failed: " + body.Rows[1].Error.Message, "succeeded"}
+	for i, w := range want {
+		if len(status.Values[i]) != 1 || status.Values[i][0] != w {
+			t.Errorf("status of row %d = %v, want %v", i+2, status.Values[i], w)
+		}
+	}
+	if urls.Values[0][0] != "mem://A-1.png" || urls.Values[1][0] != nil || urls.Values[2][0] != "" {
+		t.Errorf("URLs = %v", urls.Values)
+	}
+
+	// The access token is reused by later batches
+	postSheetBatch(deps, `{"spreadsheet_id": "sheet-1", "sheet": "Labels", "columns": {"text": "A"}}`)
+	if f.tokens != 1 {
+		t.Errorf("fetched %d tokens, want 1", f.tokens)
+	}
+}
+
+func TestSheetBatch_Errors(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		readErr    bool
+		updateErr  bool
+		maxItems   int
+		wantStatus int
+		wantCode   string
+	}{
+		{name: "missing sheet", body: `{"spreadsheet_id": "sheet-1", "columns": {"text": "A"}}`, wantStatus: http.StatusBadRequest, wantCode: errCodeInvalidParam},
+		{name: "missing text column", body: `{"spreadsheet_id": "sheet-1", "sheet": "Labels"}`, wantStatus: http.StatusBadRequest, wantCode: errCodeInvalidParam},
+		{name: "invalid column", body: `{"spreadsheet_id": "sheet-1", "sheet": "Labels", "columns": {"text": "A1"}}`, wantStatus: http.StatusBadRequest, wantCode: errCodeInvalidParam},
+		{name: "status overwrites text", body: `{"spreadsheet_id": "sheet-1", "sheet": "Labels", "columns": {"text": "A"}, "status_column": "a"}`, wantStatus: http.StatusBadRequest, wantCode: errCodeInvalidParam},
+		{name: "last row before first", body: `{"spreadsheet_id": "sheet-1", "sheet": "Labels", "first_row": 5, "last_row": 3, "columns": {"text": "A"}}`, wantStatus: http.StatusBadRequest, wantCode: errCodeInvalidParam},
+		{name: "sheet not shared", body: `{"spreadsheet_id": "sheet-1", "sheet": "Labels", "columns": {"text": "A"}}`, readErr: true, wantStatus: http.StatusBadGateway, wantCode: errCodeSheetUnavailable},
+		{name: "write back fails", body: `{"spreadsheet_id": "sheet-1", "sheet": "Labels", "columns": {"text": "A"}, "status_column": "B"}`, updateErr: true, wantStatus: http.StatusBadGateway, wantCode: errCodeSheetUnavailable},
+		{name: "too many rows", body: `{"spreadsheet_id": "sheet-1", "sheet": "Labels", "columns": {"text": "A"}}`, maxItems: 1, wantStatus: http.StatusRequestEntityTooLarge, wantCode: errCodePayloadTooLarge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeSheets(t)
+			f.values = [][]string{{"a"}, {"b"}}
+			f.readErr, f.updateErr = tt.readErr, tt.updateErr
+			deps := newSheetDeps(t, f, &memoryStore{})
+			deps.batchMaxItems = tt.maxItems
+
+			rec := postSheetBatch(deps, tt.body)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			var body struct {
+				Error apiError `json:"error"`
+			}
+			json.Unmarshal(rec.Body.Bytes(), &body)
+			if body.Error.Code != tt.wantCode {
+				t.Errorf("code = %q, want %q", body.Error.Code, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestColumnIndex(t *testing.T) {
+	tests := []struct {
+		column  string
+		want    int
+		wantErr bool
+	}{
+		{column: "", want: -1},
+		{column: "A", want: 0},
+		{column: "Z", want: 25},
+		{column: "AA", want: 26},
+		{column: "ab", want: 27},
+		{column: "ZZZ", want: 18277},
+		{column: "A1", wantErr: true},
+		{column: "AAAA", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.column, func(t *testing.T) {
+			got, err := columnIndex(tt.column)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, want error %v", err, tt.wantErr)
+			}
+			if err == nil && got != tt.want {
+				t.Errorf("columnIndex(%q) = %d, want %d", tt.column, got, tt.want)
+			}
+			if err == nil && got >= 0 && columnName(got) != strings.ToUpper(tt.column) {
+				t.Errorf("columnName(%d) = %q, want %q", got, columnName(got), strings.ToUpper(tt.column))
+			}
+		})
+	}
+}
+
+func TestNewSheetsClient(t *testing.T) {
+	tests := []struct {
+		name        string
+		credentials string
+	}{
+		{name: "not json", credentials: "key"},
+		{name: "not a service account", credentials: `{"type": "authorized_user", "client_email": "a@b"}`},
+		{name: "invalid private key", credentials: `{"type": "service_account", "client_email": "a@b", "private_key": "nope"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := newSheetsClient(tt.credentials, "https://sheets.googleapis.com"); err == nil {
+				t.Error("invalid credentials accepted")
+			}
+		})
+	}
+	if c, err := newSheetsClient("", "https://sheets.googleapis.com"); c != nil || err != nil {
+		t.Errorf("without credentials = (%v, %v), want disabled", c, err)
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// signedPayloadClaim holds the encoded text inside a signed payload
+const signedPayloadClaim = "data"
+
+// payloadSigner wraps payloads in compact JWS tokens signed with the service
+// key and verifies them. ES256 and EdDSA keep the tokens short enough for QR codes.
+type payloadSigner struct {
+	key    crypto.Signer
+	method jwt.SigningMethod
+	kid    string
+	issuer string
+}
+
+// newPayloadSigner parses a PEM private key (PKCS#8, or SEC 1 for EC keys).
+// It returns nil (signing disabled) when keyPEM is empty.
+func newPayloadSigner(keyPEM, issuer string) (*payloadSigner, error) {
+	if keyPEM == "" {
+		return nil, nil
+	}
+	block, _ := pem.Decode([]byte(keyPEM))
+	if block == nil {
+		return nil, errors.New("signing key is not PEM encoded")
+	}
+
+	var parsed any
+	var err error
+	if block.Type == "EC PRIVATE KEY" {
+		parsed, err = x509.ParseECPrivateKey(block.Bytes)
+	} else {
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid signing key: %w", err)
+	}
+
+	s := &payloadSigner{issuer: issuer}
+	switch key := parsed.(type) {
+	case *ecdsa.PrivateKey:
+		if key.Curve != elliptic.P256() {
+			return nil, errors.New("ECDSA signing keys must use the P-256 curve")
+		}
+		s.key, s.method = key, jwt.SigningMethodES256
+	case ed25519.PrivateKey:
+		s.key, s.method = key, jwt.SigningMethodEdDSA
+	default:
+		return nil, fmt.Errorf("unsupported signing key type %T, use ECDSA P-256 or Ed25519", parsed)
+	}
+
+	der, err := x509.MarshalPKIXPublicKey(s.key.Public())
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(der)
+	s.kid = base64.RawURLEncoding.EncodeToString(sum[:8])
+	return s, nil
+}
+
+// Sign wraps text in a compact JWS. A non-zero ttl adds an expiry.
+func (s *payloadSigner) Sign(text string, ttl time.Duration) (string, error) {
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"iss":              s.issuer,
+		"iat":              now.Unix(),
+		signedPayloadClaim: text,
+	}
+	if ttl != 0 {
+		claims["exp"] = now.Add(ttl).Unix()
+	}
+	token := jwt.NewWithClaims(s.method, claims)
+	token.Header["kid"] = s.kid
+	return token.SignedString(s.key)
+}
+
+// signedPayload is the result of verifying a signed payload
+type signedPayload struct {
+	Valid     bool       `json:"valid"`
+	Reason    string     `json:"reason,omitempty"`
+	Data      string     `json:"data,omitempty"`
+	Issuer    string     `json:"issuer,omitempty"`
+	KeyID     string     `json:"kid,omitempty"`
+	IssuedAt  *time.Time `json:"issued_at,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// Verify checks the signature, issuer, and expiry of a signed payload
+func (s *payloadSigner) Verify(token string) signedPayload {
+	claims := jwt.MapClaims{}
+	parsed, err := jwt.NewParser(
+		jwt.WithValidMethods([]string{s.method.Alg()}),
+		jwt.WithIssuer(s.issuer),
+		jwt.WithLeeway(jwtLeeway),
+	).ParseWithClaims(token, claims, func(t *jwt.Token) (any, error) {
+		if kid, _ := t.Header["kid"].(string); kid != s.kid {
+			return nil, fmt.Errorf("unknown key ID %q", kid)
+		}
+		return s.key.Public(), nil
+	})
+	if err != nil {
+		return signedPayload{Reason: err.Error()}
+	}
+
+	data, ok := claims[signedPayloadClaim].(string)
+	if !ok {
+		return signedPayload{Reason: "token has no " + signedPayloadClaim + " claim"}
+	}
+	result := signedPayload{Valid: true, Data: data, Issuer: s.issuer, KeyID: parsed.Header["kid"].(string)}
+	if iat, err := claims.GetIssuedAt(); err == nil && iat != nil {
+		result.IssuedAt = &iat.Time
+	}
+	if exp, err := claims.GetExpirationTime(); err == nil && exp != nil {
+		result.ExpiresAt = &exp.Time
+	}
+	return result
+}
+
+// jwk returns the public key as a JSON Web Key for offline verification
+func (s *payloadSigner) jwk() map[string]string {
+	key := map[string]string{"kid": s.kid, "alg": s.method.Alg(), "use": "sig"}
+	switch pub := s.key.Public().(type) {
+	case *ecdsa.PublicKey:
+		key["kty"], key["crv"] = "EC", "P-256"
+		key["x"] = base64.RawURLEncoding.EncodeToString(pub.X.FillBytes(make([]byte, 32)))
+		key["y"] = base64.RawURLEncoding.EncodeToString(pub.Y.FillBytes(make([]byte, 32)))
+	case ed25519.PublicKey:
+		key["kty"], key["crv"] = "OKP", "Ed25519"
+		key["x"] = base64.RawURLEncoding.EncodeToString(pub)
+	}
+	return key
+}
+
+// signingOptions reads the sign and expires_in query parameters of a generate request
+func signingOptions(query url.Values, signer *payloadSigner) (bool, time.Duration, *apiError) {
+	var sign bool
+	if value := query.Get("sign"); value != "" {
+		var err error
+		if sign, err = strconv.ParseBool(value); err != nil {
+			return false, 0, &apiError{Code: errCodeInvalidParam, Message: "Parameter 'sign' must be true or false"}
+		}
+	}
+
+	var ttl time.Duration
+	if expiresIn := query.Get("expires_in"); expiresIn != "" {
+		var err error
+		if ttl, err = time.ParseDuration(expiresIn); err != nil || ttl <= 0 || !sign {
+			return false, 0, &apiError{Code: errCodeInvalidParam, Message: "Parameter 'expires_in' must be a positive duration (e.g. 24h) and requires sign=true"}
+		}
+	}
+	if sign && signer == nil {
+		return false, 0, &apiError{Code: errCodeInvalidParam, Message: "Signed payloads are not enabled on this server"}
+	}
+	return sign, ttl, nil
+}
+
+// handleVerify validates a scanned signed payload posted as {"payload": "<jws>"}.
+// Invalid signatures are a normal result: the response is 200 with "valid": false.
+func (s *payloadSigner) handleVerify(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var body struct {
+		Payload string `json:"payload"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Payload == "" {
+		writeAPIError(w, r, http.StatusBadRequest, apiError{
+			Code:    errCodeInvalidPayload,
+			Message: `Invalid body. Usage: POST /api/v1/qr/verify with {"payload": "<scanned content>"}`,
+		})
+		return
+	}
+
+	result := s.Verify(body.Payload)
+	addLogAttrs(r.Context(), slog.Bool("signature_valid", result.Valid))
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(result)
+}
+
+// handleJWKS publishes the public signing key
+func (s *payloadSigner) handleJWKS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{s.jwk()}})
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testSigningKeyPEM returns a PEM-encoded PKCS#8 key of the given type
+func testSigningKeyPEM(t *testing.T, kind string) string {
+	t.Helper()
+	var key any
+	var err error
+	switch kind {
+	case "ec":
+		key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case "ec384":
+		key, err = ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	case "ed25519":
+		_, key, err = ed25519.GenerateKey(rand.Reader)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+}
+
+func TestNewPayloadSigner(t *testing.T) {
+	tests := []struct {
+		name    string
+		keyPEM  string
+		wantAlg string
+		wantErr bool
+	}{
+		{name: "disabled", keyPEM: ""},
+		{name: "ecdsa p-256", keyPEM: testSigningKeyPEM(t, "ec"), wantAlg: "ES256"},
+		{name: "ed25519", keyPEM: testSigningKeyPEM(t, "ed25519"), wantAlg: "EdDSA"},
+		{name: "ecdsa p-384", keyPEM: testSigningKeyPEM(t, "ec384"), wantErr: true},
+		{name: "not pem", keyPEM: "secret", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			signer, err := newPayloadSigner(tt.keyPEM, "qr-generator")
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("newPayloadSigner() expected error but got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("newPayloadSigner() unexpected error: %v", err)
+			}
+			if tt.wantAlg == "" {
+				if signer != nil {
+					t.Error("newPayloadSigner() want nil without a key")
+				}
+				return
+			}
+			if signer.method.Alg() != tt.wantAlg {
+				t.Errorf("alg = %s, want %s", signer.method.Alg(), tt.wantAlg)
+			}
+		})
+	}
+}
+
+func TestPayloadSigner_Verify(t *testing.T) {
+	signer, err := newPayloadSigner(testSigningKeyPEM(t, "ec"), "qr-generator")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := newPayloadSigner(testSigningKeyPEM(t, "ec"), "qr-generator")
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherIssuer, err := newPayloadSigner(testSigningKeyPEM(t, "ec"), "someone-else")
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherIssuer.key, otherIssuer.kid = signer.key, signer.kid
+
+	sign := func(s *payloadSigner, ttl time.Duration) string {
+		token, err := s.Sign("badge:42", ttl)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+	valid := sign(signer, time.Hour)
+	parts := strings.Split(valid, ".")
+
+	tests := []struct {
+		name      string
+		token     string
+		wantValid bool
+	}{
+		{name: "valid", token: valid, wantValid: true},
+		{name: "valid without expiry", token: sign(signer, 0), wantValid: true},
+		{name: "expired", token: sign(signer, -time.Hour)},
+		{name: "forged by another key", token: sign(other, time.Hour)},
+		{name: "wrong issuer", token: sign(otherIssuer, time.Hour)},
+		{name: "tampered claims", token: parts[0] + "." + parts[0] + "." + parts[2]},
+		{name: "not a jws", token: "https://example.com"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := signer.Verify(tt.token)
+			if got.Valid != tt.wantValid {
+				t.Fatalf("Verify() = %+v, want valid %v", got, tt.wantValid)
+			}
+			if tt.wantValid && got.Data != "badge:42" {
+				t.Errorf("Verify() data = %q, want %q", got.Data, "badge:42")
+			}
+		})
+	}
+}
+
+func TestSigningOptions(t *testing.T) {
+	signer := &payloadSigner{}
+	tests := []struct {
+		name     string
+		query    string
+		signer   *payloadSigner
+		wantSign bool
+		wantTTL  time.Duration
+		wantErr  bool
+	}{
+		{name: "unsigned", query: "", signer: signer},
+		{name: "signed", query: "sign=true", signer: signer, wantSign: true},
+		{name: "signed with expiry", query: "sign=true&expires_in=24h", signer: signer, wantSign: true, wantTTL: 24 * time.Hour},
+		{name: "expiry without sign", query: "expires_in=24h", signer: signer, wantErr: true},
+		{name: "invalid sign", query: "sign=maybe", signer: signer, wantErr: true},
+		{name: "signing disabled", query: "sign=true", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, _ := url.ParseQuery(tt.query)
+			sign, ttl, apiErr := signingOptions(query, tt.signer)
+			if (apiErr != nil) != tt.wantErr {
+				t.Fatalf("signingOptions() error = %v, wantErr %v", apiErr, tt.wantErr)
+			}
+			if sign != tt.wantSign || ttl != tt.wantTTL {
+				t.Errorf("signingOptions() = %v, %s, want %v, %s", sign, ttl, tt.wantSign, tt.wantTTL)
+			}
+		})
+	}
+}
+
+func TestVerifyEndpoint(t *testing.T) {
+	signer, err := newPayloadSigner(testSigningKeyPEM(t, "ed25519"), "qr-generator")
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := signer.Sign("badge:42", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deps := newTestDeps()
+	deps.signer = signer
+	handler := newHandler(deps)
+
+	tests := []struct {
+		name      string
+		body      string
+		wantCode  int
+		wantValid bool
+	}{
+		{name: "valid", body: `{"payload":"` + token + `"}`, wantCode: http.StatusOK, wantValid: true},
+		{name: "forged", body: `{"payload":"` + token + `x"}`, wantCode: http.StatusOK},
+		{name: "missing payload", body: `{}`, wantCode: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v1/qr/verify", strings.NewReader(tt.body)))
+
+			if rec.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantCode)
+			}
+			var result signedPayload
+			json.NewDecoder(rec.Body).Decode(&result)
+			if result.Valid != tt.wantValid {
+				t.Errorf("valid = %v, want %v (%s)", result.Valid, tt.wantValid, result.Reason)
+			}
+		})
+	}
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/.well-known/jwks.json", nil))
+	var jwks struct {
+		Keys []map[string]string `json:"keys"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&jwks); err != nil || len(jwks.Keys) != 1 || jwks.Keys[0]["kid"] != signer.kid || jwks.Keys[0]["crv"] != "Ed25519" {
+		t.Errorf("GET /.well-known/jwks.json = %d %+v (err %v)", rec.Code, jwks, err)
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v1/qr/generate?text=badge:42&sign=true", nil))
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "image/png" {
+		t.Errorf("signed generate = %d %s", rec.Code, rec.Header().Get("Content-Type"))
+	}
+}
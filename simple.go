@@ -0,0 +1,151 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// simpleAPI serves endpoints for low-code connectors such as Zapier, Make,
+// and Power Automate, which handle flat fields and JSON far better than
+// binary responses. /api/v1/simple/qr takes the text as a query parameter,
+// form field, or flat JSON object and answers with a link to the image: a
+// URL of /api/v1/simple/image signed with the link secret, which expires
+// after imageTTL.
+type simpleAPI struct {
+	secret    []byte
+	publicURL string
+	imageTTL  time.Duration
+	now       func() time.Time
+}
+
+// newSimpleAPI enables the simple endpoints. publicURL is the service's
+// external base URL that image links point to. It returns nil when
+// publicURL is empty.
+func newSimpleAPI(publicURL, secret string, imageTTL time.Duration) (*simpleAPI, error) {
+	if publicURL == "" {
+		return nil, nil
+	}
+	u, err := url.Parse(publicURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("SIMPLE_API_PUBLIC_URL must be the service's external http(s) URL, got %q", publicURL)
+	}
+	if len(secret) < 32 {
+		return nil, fmt.Errorf("SIMPLE_API_PUBLIC_URL requires SIMPLE_API_LINK_SECRET of at least 32 characters to sign image links")
+	}
+	return &simpleAPI{secret: []byte(secret), publicURL: strings.TrimSuffix(publicURL, "/"), imageTTL: imageTTL, now: time.Now}, nil
+}
+
+// simpleResponse is the answer of /api/v1/simple/qr
+type simpleResponse struct {
+	Text      string `json:"text"`
+	ImageURL  string `json:"image_url"`
+	ExpiresAt string `json:"expires_at"`
+}
+
+// handleQR renders the code of the text field of a GET or POST request, so
+// errors are reported now, and answers with a signed link to it
+func (s *simpleAPI) handleQR(deps handlerDeps) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodPost {
+			w.Header().Set("Allow", "GET, POST")
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		text, apiErr := simpleText(r)
+		if apiErr != nil {
+			writeAPIError(w, r, http.StatusBadRequest, *apiErr)
+			return
+		}
+		setAuditPayload(r.Context(), text, nil)
+		// The image request then hits the cache
+		if _, apiErr := renderText(r.Context(), deps, text); apiErr != nil {
+			writeAPIError(w, r, jobErrorStatus(apiErr), *apiErr)
+			return
+		}
+
+		expires := s.now().Add(s.imageTTL)
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		json.NewEncoder(w).Encode(simpleResponse{
+			Text:      text,
+			ImageURL:  s.imageURL(text, expires),
+			ExpiresAt: expires.UTC().Format(time.RFC3339),
+		})
+	}
+}
+
+// simpleText reads the text field of a GET query, a form body, or a flat
+// JSON object
+func simpleText(r *http.Request) (string, *apiError) {
+	var text string
+	if r.Method == http.MethodPost {
+		mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if mediaType == "application/json" {
+			var body struct {
+				Text string `json:"text"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				return "", &apiError{Code: errCodeInvalidPayload, Message: "Body must be a JSON object with a string field 'text'"}
+			}
+			text = body.Text
+		}
+	}
+	if text == "" {
+		// FormValue reads the query, urlencoded, and multipart bodies
+		text = r.FormValue("text")
+	}
+	if text == "" {
+		return "", &apiError{Code: errCodeInvalidParam, Message: "Missing required field 'text'"}
+	}
+	return text, nil
+}
+
+// imageURL returns the signed link to the code of text
+func (s *simpleAPI) imageURL(text string, expires time.Time) string {
+	expiry := strconv.FormatInt(expires.Unix(), 10)
+	query := url.Values{"text": {text}, "expires": {expiry}, "sig": {s.imageSignature(text, expiry)}}
+	return s.publicURL + "/api/v1/simple/image?" + query.Encode()
+}
+
+// imageSignature authenticates an image link
+func (s *simpleAPI) imageSignature(text, expires string) string {
+	mac := hmac.New(sha256.New, s.secret)
+	fmt.Fprintf(mac, "qr-simple-image:%s:%s", expires, text)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// handleImage serves the code of a signed link until it expires
+func (s *simpleAPI) handleImage(deps handlerDeps) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		text, expires := query.Get("text"), query.Get("expires")
+		expiry, err := strconv.ParseInt(expires, 10, 64)
+		if err != nil || !hmac.Equal([]byte(query.Get("sig")), []byte(s.imageSignature(text, expires))) {
+			http.Error(w, "Invalid image signature", http.StatusForbidden)
+			return
+		}
+		remaining := time.Unix(expiry, 0).Sub(s.now())
+		if remaining <= 0 {
+			http.Error(w, "Image link has expired", http.StatusGone)
+			return
+		}
+
+		pngBytes, apiErr := renderText(r.Context(), deps, text)
+		if apiErr != nil {
+			writeAPIError(w, r, jobErrorStatus(apiErr), *apiErr)
+			return
+		}
+		deps.metrics.generatedTotal.Inc()
+		w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(remaining.Seconds())))
+		writePNG(w, r, pngBytes)
+	}
+}
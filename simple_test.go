@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testSimpleLinkSecret = "0123456789abcdef0123456789abcdef"
+
+func newTestSimpleDeps(t *testing.T, now time.Time) handlerDeps {
+	t.Helper()
+	simple, err := newSimpleAPI("https://qr.example.com/", testSimpleLinkSecret, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	simple.now = func() time.Time { return now }
+	deps := newTestDeps()
+	deps.simple = simple
+	return deps
+}
+
+func TestSimpleQR(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	multipartBody := func() (string, *bytes.Buffer) {
+		var body bytes.Buffer
+		writer := multipart.NewWriter(&body)
+		writer.WriteField("text", "https://example.com/multipart")
+		writer.Close()
+		return writer.FormDataContentType(), &body
+	}
+
+	tests := []struct {
+		name        string
+		method      string
+		query       string
+		contentType string
+		body        string
+		wantStatus  int
+		wantText    string
+		wantCode    string
+	}{
+		{name: "GET", method: http.MethodGet, query: "text=https%3A%2F%2Fexample.com", wantStatus: http.StatusOK, wantText: "https://example.com"},
+		{name: "form", method: http.MethodPost, contentType: "application/x-www-form-urlencoded", body: "text=hello+world", wantStatus: http.StatusOK, wantText: "hello world"},
+		{name: "multipart", method: http.MethodPost, contentType: "multipart/form-data", wantStatus: http.StatusOK, wantText: "https://example.com/multipart"},
+		{name: "JSON", method: http.MethodPost, contentType: "application/json; charset=utf-8", body: `{"text": "order-42"}`, wantStatus: http.StatusOK, wantText: "order-42"},
+		{name: "query on POST", method: http.MethodPost, query: "text=hello", wantStatus: http.StatusOK, wantText: "hello"},
+		{name: "missing text", method: http.MethodGet, wantStatus: http.StatusBadRequest, wantCode: errCodeInvalidParam},
+		{name: "invalid JSON", method: http.MethodPost, contentType: "application/json", body: `{"text": 42}`, wantStatus: http.StatusBadRequest, wantCode: errCodeInvalidPayload},
+		{name: "policy violation", method: http.MethodGet, query: "text=javascript%3Aalert(1)", wantStatus: http.StatusUnprocessableEntity, wantCode: errCodePolicyViolation},
+		{name: "method not allowed", method: http.MethodPut, query: "text=hello", wantStatus: http.StatusMethodNotAllowed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := newHandler(newTestSimpleDeps(t, now))
+			contentType, body := tt.contentType, bytes.NewBufferString(tt.body)
+			if contentType == "multipart/form-data" {
+				contentType, body = multipartBody()
+			}
+			req := httptest.NewRequest(tt.method, "/api/v1/simple/qr?"+tt.query, body)
+			if contentType != "" {
+				req.Header.Set("Content-Type", contentType)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if tt.wantCode != "" {
+				var resp struct {
+					Error apiError `json:"error"`
+				}
+				json.Unmarshal(rec.Body.Bytes(), &resp)
+				if resp.Error.Code != tt.wantCode {
+					t.Errorf("error code = %q, want %q", resp.Error.Code, tt.wantCode)
+				}
+			}
+			if rec.Code != http.StatusOK {
+				return
+			}
+
+			var resp simpleResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			if resp.Text != tt.wantText || resp.ExpiresAt != "2023-11-14T23:13:20Z" {
+				t.Errorf("response = %+v", resp)
+			}
+			if !strings.HasPrefix(resp.ImageURL, "https://qr.example.com/api/v1/simple/image?") {
+				t.Fatalf("image_url = %q, want a link to the service", resp.ImageURL)
+			}
+
+			// The connector fetches the linked image
+			u, _ := url.Parse(resp.ImageURL)
+			rec = httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, u.RequestURI(), nil))
+			if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "image/png" {
+				t.Errorf("image status = %d, content type %q", rec.Code, rec.Header().Get("Content-Type"))
+			}
+		})
+	}
+}
+
+func TestSimpleImage(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	deps := newTestSimpleDeps(t, now)
+	valid, _ := url.Parse(deps.simple.imageURL("hello", now.Add(time.Minute)))
+
+	tampered := valid.Query()
+	tampered.Set("text", "something else")
+	expired := url.Values{"text": {"hello"}, "expires": {strconv.FormatInt(now.Add(-time.Second).Unix(), 10)}}
+	expired.Set("sig", deps.simple.imageSignature("hello", expired.Get("expires")))
+	slack, _ := newSlackCommands(testSimpleLinkSecret, "https://qr.example.com", time.Hour)
+	slackLink := url.Values{"text": {"hello"}, "expires": {valid.Query().Get("expires")}}
+	slackLink.Set("sig", slack.imageSignature("hello", slackLink.Get("expires")))
+
+	tests := []struct {
+		name       string
+		query      string
+		wantStatus int
+	}{
+		{name: "valid", query: valid.RawQuery, wantStatus: http.StatusOK},
+		{name: "tampered text", query: tampered.Encode(), wantStatus: http.StatusForbidden},
+		{name: "unsigned", query: "text=hello", wantStatus: http.StatusForbidden},
+		{name: "expired", query: expired.Encode(), wantStatus: http.StatusGone},
+		{name: "Slack link with the same secret", query: slackLink.Encode(), wantStatus: http.StatusForbidden},
+	}
+
+	handler := newHandler(deps)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/simple/image?"+tt.query, nil))
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestNewSimpleAPI(t *testing.T) {
+	if s, err := newSimpleAPI("", "", time.Hour); s != nil || err != nil {
+		t.Errorf("without public URL = (%v, %v), want disabled", s, err)
+	}
+	if _, err := newSimpleAPI("qr.example.com", testSimpleLinkSecret, time.Hour); err == nil {
+		t.Error("public URL without scheme accepted")
+	}
+	if _, err := newSimpleAPI("https://qr.example.com", "short", time.Hour); err == nil {
+		t.Error("short link secret accepted")
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Headers of signed Slack requests
+const (
+	slackSignatureHeader = "X-Slack-Signature"
+	slackTimestampHeader = "X-Slack-Request-Timestamp"
+)
+
+// slackCommands answers the /qr slash command. Slack messages can't carry
+// image bytes, so the response references the code by URL: a link to
+// /slack/image signed with the signing secret, which only renders texts
+// requested through Slack and expires after imageTTL.
+type slackCommands struct {
+	secret    []byte
+	publicURL string
+	imageTTL  time.Duration
+	now       func() time.Time
+}
+
+// newSlackCommands enables the slash command for requests signed with
+// secret. publicURL is the service's external base URL, which Slack fetches
+// images from. It returns nil when secret is empty.
+func newSlackCommands(secret, publicURL string, imageTTL time.Duration) (*slackCommands, error) {
+	if secret == "" {
+		return nil, nil
+	}
+	u, err := url.Parse(publicURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("SLACK_SIGNING_SECRET requires SLACK_PUBLIC_URL to be the service's external http(s) URL, got %q", publicURL)
+	}
+	return &slackCommands{secret: []byte(secret), publicURL: strings.TrimSuffix(publicURL, "/"), imageTTL: imageTTL, now: time.Now}, nil
+}
+
+// slackMessage is a slash command response
+type slackMessage struct {
+	ResponseType string           `json:"response_type"`
+	Text         string           `json:"text"`
+	Blocks       []map[string]any `json:"blocks,omitempty"`
+}
+
+// handleCommand verifies the Slack signature and answers with the QR code of
+// the command text in the channel, or with an error only the user sees
+func (s *slackCommands) handleCommand(deps handlerDeps) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, "Failed to read request body", http.StatusBadRequest)
+			return
+		}
+		if err := s.verify(r.Header, body); err != nil {
+			slog.InfoContext(r.Context(), "rejected Slack request", "reason", err)
+			http.Error(w, "Invalid Slack request signature", http.StatusUnauthorized)
+			return
+		}
+		form, err := url.ParseQuery(string(body))
+		if err != nil {
+			http.Error(w, "Invalid form body", http.StatusBadRequest)
+			return
+		}
+
+		text := strings.TrimSpace(form.Get("text"))
+		setAuditPayload(r.Context(), text, map[string]string{"slack_team": form.Get("team_id"), "slack_user": form.Get("user_id")})
+		if text == "" {
+			writeSlackMessage(w, slackMessage{ResponseType: "ephemeral", Text: fmt.Sprintf("Usage: `%s https://example.com`", form.Get("command"))})
+			return
+		}
+		// Render now so errors reach the user; the image request then hits the cache
+		if _, apiErr := renderText(r.Context(), deps, text); apiErr != nil {
+			writeSlackMessage(w, slackMessage{ResponseType: "ephemeral", Text: "Could not generate a QR code: " + apiErr.Message})
+			return
+		}
+		deps.metrics.deliveries.WithLabelValues("slack", jobSucceeded).Inc()
+
+		writeSlackMessage(w, slackMessage{
+			ResponseType: "in_channel",
+			Text:         "QR code for " + text,
+			Blocks: []map[string]any{{
+				"type":      "image",
+				"image_url": s.imageURL(text),
+				"alt_text":  "QR code for " + text,
+			}},
+		})
+	}
+}
+
+// verify checks the v0 request signature and rejects requests older than
+// signatureMaxSkew, so captured requests can't be replayed
+func (s *slackCommands) verify(header http.Header, body []byte) error {
+	timestamp := header.Get(slackTimestampHeader)
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return errors.New("missing or invalid timestamp")
+	}
+	if skew := s.now().Sub(time.Unix(seconds, 0)); skew > signatureMaxSkew || skew < -signatureMaxSkew {
+		return errors.New("timestamp outside the allowed window")
+	}
+	mac := hmac.New(sha256.New, s.secret)
+	fmt.Fprintf(mac, "v0:%s:%s", timestamp, body)
+	if !hmac.Equal([]byte(header.Get(slackSignatureHeader)), []byte("v0="+hex.EncodeToString(mac.Sum(nil)))) {
+		return errors.New("signature mismatch")
+	}
+	return nil
+}
+
+// imageURL returns the signed URL Slack fetches the code of text from
+func (s *slackCommands) imageURL(text string) string {
+	expires := strconv.FormatInt(s.now().Add(s.imageTTL).Unix(), 10)
+	query := url.Values{"text": {text}, "expires": {expires}, "sig": {s.imageSignature(text, expires)}}
+	return s.publicURL + "/slack/image?" + query.Encode()
+}
+
+// imageSignature authenticates an image URL, in a domain separate from
+// request signatures
+func (s *slackCommands) imageSignature(text, expires string) string {
+	mac := hmac.New(sha256.New, s.secret)
+	fmt.Fprintf(mac, "qr-image:%s:%s", expires, text)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// handleImage serves the code of a signed image URL until it expires
+func (s *slackCommands) handleImage(deps handlerDeps) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		text, expires := query.Get("text"), query.Get("expires")
+		expiry, err := strconv.ParseInt(expires, 10, 64)
+		if err != nil || !hmac.Equal([]byte(query.Get("sig")), []byte(s.imageSignature(text, expires))) {
+			http.Error(w, "Invalid image signature", http.StatusForbidden)
+			return
+		}
+		remaining := time.Unix(expiry, 0).Sub(s.now())
+		if remaining <= 0 {
+			http.Error(w, "Image link has expired", http.StatusGone)
+			return
+		}
+
+		pngBytes, apiErr := renderText(r.Context(), deps, text)
+		if apiErr != nil {
+			writeAPIError(w, r, jobErrorStatus(apiErr), *apiErr)
+			return
+		}
+		deps.metrics.generatedTotal.Inc()
+		w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(remaining.Seconds())))
+		writePNG(w, r, pngBytes)
+	}
+}
+
+// writeSlackMessage writes a slash command response. Slack only shows
+// responses with status 200, so errors are messages too.
+func writeSlackMessage(w http.ResponseWriter, msg slackMessage) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(msg)
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// signSlackRequest builds a slash command request signed like Slack does
+func signSlackRequest(secret string, timestamp time.Time, form url.Values) *http.Request {
+	body := form.Encode()
+	ts := strconv.FormatInt(timestamp.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("v0:" + ts + ":" + body))
+	req := httptest.NewRequest(http.MethodPost, "/slack/command", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set(slackTimestampHeader, ts)
+	req.Header.Set(slackSignatureHeader, "v0="+hex.EncodeToString(mac.Sum(nil)))
+	return req
+}
+
+func newTestSlackDeps(t *testing.T, now time.Time) handlerDeps {
+	t.Helper()
+	slack, err := newSlackCommands("slack-secret", "https://qr.example.com/", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	slack.now = func() time.Time { return now }
+	deps := newTestDeps()
+	deps.slack = slack
+	return deps
+}
+
+func TestSlackCommand(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	tests := []struct {
+		name         string
+		secret       string
+		timestamp    time.Time
+		text         string
+		wantStatus   int
+		wantResponse string
+	}{
+		{name: "code in channel", secret: "slack-secret", timestamp: now, text: "https://example.com", wantStatus: http.StatusOK, wantResponse: "in_channel"},
+		{name: "usage", secret: "slack-secret", timestamp: now, text: " ", wantStatus: http.StatusOK, wantResponse: "ephemeral"},
+		{name: "policy violation", secret: "slack-secret", timestamp: now, text: "javascript:alert(1)", wantStatus: http.StatusOK, wantResponse: "ephemeral"},
+		{name: "wrong secret", secret: "other", timestamp: now, text: "hello", wantStatus: http.StatusUnauthorized},
+		{name: "replayed", secret: "slack-secret", timestamp: now.Add(-10 * time.Minute), text: "hello", wantStatus: http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := newHandler(newTestSlackDeps(t, now))
+			form := url.Values{"command": {"/qr"}, "text": {tt.text}, "user_id": {"U1"}, "team_id": {"T1"}}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, signSlackRequest(tt.secret, tt.timestamp, form))
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			var msg slackMessage
+			if err := json.Unmarshal(rec.Body.Bytes(), &msg); err != nil {
+				t.Fatal(err)
+			}
+			if msg.ResponseType != tt.wantResponse {
+				t.Errorf("response_type = %q, want %q: %s", msg.ResponseType, tt.wantResponse, msg.Text)
+			}
+			if tt.wantResponse != "in_channel" {
+				return
+			}
+			imageURL, _ := msg.Blocks[0]["image_url"].(string)
+			if !strings.HasPrefix(imageURL, "https://qr.example.com/slack/image?") {
+				t.Fatalf("image_url = %q, want a link to the service", imageURL)
+			}
+
+			// Slack fetches the linked image
+			u, _ := url.Parse(imageURL)
+			rec = httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, u.RequestURI(), nil))
+			if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "image/png" {
+				t.Errorf("image status = %d, content type %q", rec.Code, rec.Header().Get("Content-Type"))
+			}
+		})
+	}
+}
+
+func TestSlackImage(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	deps := newTestSlackDeps(t, now)
+	valid, _ := url.Parse(deps.slack.imageURL("hello"))
+
+	tampered := valid.Query()
+	tampered.Set("text", "something else")
+	expired := url.Values{"text": {"hello"}, "expires": {strconv.FormatInt(now.Add(-time.Second).Unix(), 10)}}
+	expired.Set("sig", deps.slack.imageSignature("hello", expired.Get("expires")))
+
+	tests := []struct {
+		name       string
+		query      string
+		wantStatus int
+	}{
+		{name: "valid", query: valid.RawQuery, wantStatus: http.StatusOK},
+		{name: "tampered text", query: tampered.Encode(), wantStatus: http.StatusForbidden},
+		{name: "unsigned", query: "text=hello", wantStatus: http.StatusForbidden},
+		{name: "expired", query: expired.Encode(), wantStatus: http.StatusGone},
+	}
+
+	handler := newHandler(deps)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/slack/image?"+tt.query, nil))
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestNewSlackCommands(t *testing.T) {
+	if s, err := newSlackCommands("", "", time.Hour); s != nil || err != nil {
+		t.Errorf("without secret = (%v, %v), want disabled", s, err)
+	}
+	if _, err := newSlackCommands("secret", "", time.Hour); err == nil {
+		t.Error("missing public URL accepted")
+	}
+	if _, err := newSlackCommands("secret", "qr.example.com", time.Hour); err == nil {
+		t.Error("public URL without scheme accepted")
+	}
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// sqsAPI is the part of *sqs.Client the worker uses
+type sqsAPI interface {
+	ReceiveMessage(ctx context.Context, params *sqs.ReceiveMessageInput, optFns ...func(*sqs.Options)) (*sqs.ReceiveMessageOutput, error)
+	DeleteMessage(ctx context.Context, params *sqs.DeleteMessageInput, optFns ...func(*sqs.Options)) (*sqs.DeleteMessageOutput, error)
+	ChangeMessageVisibility(ctx context.Context, params *sqs.ChangeMessageVisibilityInput, optFns ...func(*sqs.Options)) (*sqs.ChangeMessageVisibilityOutput, error)
+	SendMessage(ctx context.Context, params *sqs.SendMessageInput, optFns ...func(*sqs.Options)) (*sqs.SendMessageOutput, error)
+	GetQueueAttributes(ctx context.Context, params *sqs.GetQueueAttributesInput, optFns ...func(*sqs.Options)) (*sqs.GetQueueAttributesOutput, error)
+}
+
+// sqsWaitTime is the long-polling wait of a receive, the maximum SQS allows
+const sqsWaitTime = 20
+
+// sqsWorker polls an SQS queue for generation jobs. A message stays invisible
+// to other consumers while it is processed: its visibility timeout is
+// extended until the job is done, and the message is deleted only then.
+// Invalid jobs are poison messages that would fail on every delivery; they
+// are moved to the dead-letter queue right away. Jobs that keep failing
+// transiently are moved there once they were received maxReceives times.
+type sqsWorker struct {
+	client    sqsAPI
+	queueURL  string
+	dlqURL    string // empty leaves failed messages to the queue's redrive policy
+	resultURL string // empty disables completion events
+	processor *jobProcessor
+
+	visibility  time.Duration
+	maxReceives int
+	// retryInterval is the wait after a failed receive
+	retryInterval time.Duration
+	// onMessage replaces handle for queues carrying other messages than jobs
+	onMessage func(ctx context.Context, msg types.Message)
+}
+
+// newSQSWorker creates a worker with credentials from the default AWS chain
+func newSQSWorker(ctx context.Context, queueURL, dlqURL, resultURL string, visibility time.Duration, maxReceives int, processor *jobProcessor) (*sqsWorker, error) {
+	cfg, err := awsconfig.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("load AWS configuration: %w", err)
+	}
+	return &sqsWorker{
+		client:        sqs.NewFromConfig(cfg),
+		queueURL:      queueURL,
+		dlqURL:        dlqURL,
+		resultURL:     resultURL,
+		processor:     processor,
+		visibility:    max(visibility, time.Second),
+		maxReceives:   maxReceives,
+		retryInterval: time.Second,
+	}, nil
+}
+
+// run polls and processes batches of messages until ctx is done. The messages
+// of a batch are processed concurrently through the render pool.
+func (s *sqsWorker) run(ctx context.Context) {
+	for ctx.Err() == nil {
+		out, err := s.client.ReceiveMessage(ctx, &sqs.ReceiveMessageInput{
+			QueueUrl:                    aws.String(s.queueURL),
+			MaxNumberOfMessages:         10,
+			WaitTimeSeconds:             sqsWaitTime,
+			VisibilityTimeout:           int32(s.visibility.Seconds()),
+			MessageSystemAttributeNames: []types.MessageSystemAttributeName{types.MessageSystemAttributeNameApproximateReceiveCount},
+		})
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			slog.ErrorContext(ctx, "failed to receive SQS messages", "error", err)
+			sleepContext(ctx, s.retryInterval)
+			continue
+		}
+
+		handle := s.handle
+		if s.onMessage != nil {
+			handle = s.onMessage
+		}
+		var wg sync.WaitGroup
+		for _, msg := range out.Messages {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				handle(ctx, msg)
+			}()
+		}
+		wg.Wait()
+	}
+}
+
+// handle processes one message, keeping it invisible meanwhile
+func (s *sqsWorker) handle(ctx context.Context, msg types.Message) {
+	stop := s.heartbeat(ctx, msg)
+	result, err := s.processor.process(ctx, []byte(aws.ToString(msg.Body)))
+	stop()
+	if err != nil {
+		// Shutting down: hand the message to another consumer right away
+		s.setVisibility(context.WithoutCancel(ctx), msg, 0)
+		return
+	}
+	// The job is done: settle the message even if shutdown begins meanwhile
+	ctx = context.WithoutCancel(ctx)
+
+	if result.Status == jobFailed {
+		receives, _ := strconv.Atoi(msg.Attributes[string(types.MessageSystemAttributeNameApproximateReceiveCount)])
+		if result.retryable() && receives < s.maxReceives {
+			// Visible again for a later attempt once the timeout expires
+			return
+		}
+		if s.dlqURL == "" {
+			return // the queue's redrive policy moves it to a dead-letter queue
+		}
+		if !s.deadLetter(ctx, msg, result) {
+			return
+		}
+	} else {
+		s.publish(ctx, result)
+	}
+
+	if _, err := s.client.DeleteMessage(ctx, &sqs.DeleteMessageInput{QueueUrl: aws.String(s.queueURL), ReceiptHandle: msg.ReceiptHandle}); err != nil {
+		slog.ErrorContext(ctx, "failed to delete SQS message", "message_id", aws.ToString(msg.MessageId), "error", err)
+	}
+}
+
+// heartbeat extends the message's visibility timeout every half timeout
+// until the returned function is called
+func (s *sqsWorker) heartbeat(ctx context.Context, msg types.Message) (stop func()) {
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(s.visibility / 2)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				s.setVisibility(ctx, msg, s.visibility)
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		wg.Wait()
+	}
+}
+
+// setVisibility changes how long the message stays hidden from consumers
+func (s *sqsWorker) setVisibility(ctx context.Context, msg types.Message, timeout time.Duration) {
+	_, err := s.client.ChangeMessageVisibility(ctx, &sqs.ChangeMessageVisibilityInput{
+		QueueUrl:          aws.String(s.queueURL),
+		ReceiptHandle:     msg.ReceiptHandle,
+		VisibilityTimeout: int32(timeout.Seconds()),
+	})
+	if err != nil {
+		slog.WarnContext(ctx, "failed to change SQS message visibility", "message_id", aws.ToString(msg.MessageId), "error", err)
+	}
+}
+
+// deadLetter copies a failed message to the dead-letter queue with the
+// failure as message attributes, reporting whether it succeeded
+func (s *sqsWorker) deadLetter(ctx context.Context, msg types.Message, result jobResult) bool {
+	_, err := s.client.SendMessage(ctx, &sqs.SendMessageInput{
+		QueueUrl:    aws.String(s.dlqURL),
+		MessageBody: msg.Body,
+		MessageAttributes: map[string]types.MessageAttributeValue{
+			"error_code":    {DataType: aws.String("String"), StringValue: aws.String(result.Error.Code)},
+			"error_message": {DataType: aws.String("String"), StringValue: aws.String(result.Error.Message)},
+			"message_id":    {DataType: aws.String("String"), StringValue: msg.MessageId},
+		},
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to move SQS message to the dead-letter queue", "message_id", aws.ToString(msg.MessageId), "error", err)
+		return false
+	}
+	slog.WarnContext(ctx, "moved SQS message to the dead-letter queue", "message_id", aws.ToString(msg.MessageId), "code", result.Error.Code)
+	return true
+}
+
+// publish sends the completion event of a succeeded job to the result queue
+func (s *sqsWorker) publish(ctx context.Context, result jobResult) {
+	if s.resultURL == "" {
+		return
+	}
+	body, _ := json.Marshal(result)
+	if _, err := s.client.SendMessage(ctx, &sqs.SendMessageInput{QueueUrl: aws.String(s.resultURL), MessageBody: aws.String(string(body))}); err != nil {
+		slog.ErrorContext(ctx, "failed to publish job result", "job_id", result.ID, "error", err)
+	}
+}
+
+// check reports whether the queue is reachable, for the health endpoint
+func (s *sqsWorker) check(ctx context.Context) error {
+	_, err := s.client.GetQueueAttributes(ctx, &sqs.GetQueueAttributesInput{
+		QueueUrl:       aws.String(s.queueURL),
+		AttributeNames: []types.QueueAttributeName{types.QueueAttributeNameQueueArn},
+	})
+	return err
+}
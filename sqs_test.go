@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// fakeSQS records the calls of the worker to an in-memory queue
+type fakeSQS struct {
+	messages chan types.Message
+
+	mu          sync.Mutex
+	deleted     []string
+	visibility  []int32
+	sent        map[string][]*sqs.SendMessageInput
+	attrQueries int
+}
+
+func (f *fakeSQS) ReceiveMessage(ctx context.Context, params *sqs.ReceiveMessageInput, optFns ...func(*sqs.Options)) (*sqs.ReceiveMessageOutput, error) {
+	select {
+	case msg := <-f.messages:
+		return &sqs.ReceiveMessageOutput{Messages: []types.Message{msg}}, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (f *fakeSQS) DeleteMessage(ctx context.Context, params *sqs.DeleteMessageInput, optFns ...func(*sqs.Options)) (*sqs.DeleteMessageOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.deleted = append(f.deleted, aws.ToString(params.ReceiptHandle))
+	return &sqs.DeleteMessageOutput{}, nil
+}
+
+func (f *fakeSQS) ChangeMessageVisibility(ctx context.Context, params *sqs.ChangeMessageVisibilityInput, optFns ...func(*sqs.Options)) (*sqs.ChangeMessageVisibilityOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.visibility = append(f.visibility, params.VisibilityTimeout)
+	return &sqs.ChangeMessageVisibilityOutput{}, nil
+}
+
+func (f *fakeSQS) SendMessage(ctx context.Context, params *sqs.SendMessageInput, optFns ...func(*sqs.Options)) (*sqs.SendMessageOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.sent == nil {
+		f.sent = map[string][]*sqs.SendMessageInput{}
+	}
+	f.sent[aws.ToString(params.QueueUrl)] = append(f.sent[aws.ToString(params.QueueUrl)], params)
+	return &sqs.SendMessageOutput{}, nil
+}
+
+func (f *fakeSQS) GetQueueAttributes(ctx context.Context, params *sqs.GetQueueAttributesInput, optFns ...func(*sqs.Options)) (*sqs.GetQueueAttributesOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.attrQueries++
+	return &sqs.GetQueueAttributesOutput{}, nil
+}
+
+func newTestSQSWorker(client sqsAPI, dlqURL string, store objectStore) *sqsWorker {
+	return &sqsWorker{
+		client:        client,
+		queueURL:      "jobs",
+		dlqURL:        dlqURL,
+		resultURL:     "results",
+		processor:     newTestJobProcessor(store),
+		visibility:    time.Minute,
+		maxReceives:   3,
+		retryInterval: time.Millisecond,
+	}
+}
+
+func sqsMessage(body string, receives string) types.Message {
+	return types.Message{
+		MessageId:     aws.String("m1"),
+		ReceiptHandle: aws.String("r1"),
+		Body:          aws.String(body),
+		Attributes:    map[string]string{string(types.MessageSystemAttributeNameApproximateReceiveCount): receives},
+	}
+}
+
+func TestSQSWorker_Handle(t *testing.T) {
+	tests := []struct {
+		name        string
+		body        string
+		receives    string
+		dlqURL      string
+		storeFails  int
+		wantDeleted bool
+		wantDLQ     bool
+		wantResult  bool
+	}{
+		{name: "success", body: `{"id": "a", "text": "hello"}`, receives: "1", dlqURL: "dlq", wantDeleted: true, wantResult: true},
+		{name: "poison message dead-lettered", body: `not json`, receives: "1", dlqURL: "dlq", wantDeleted: true, wantDLQ: true},
+		{name: "invalid job dead-lettered", body: `{"id": "a", "text": ""}`, receives: "1", dlqURL: "dlq", wantDeleted: true, wantDLQ: true},
+		{name: "poison message left to redrive policy", body: `not json`, receives: "1"},
+		{name: "transient failure redelivered", body: `{"id": "a", "text": "hello"}`, receives: "1", dlqURL: "dlq", storeFails: -1},
+		{name: "transient failure dead-lettered after max receives", body: `{"id": "a", "text": "hello"}`, receives: "3", dlqURL: "dlq", storeFails: -1, wantDeleted: true, wantDLQ: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeSQS{}
+			worker := newTestSQSWorker(fake, tt.dlqURL, &memoryStore{failures: tt.storeFails})
+			worker.handle(context.Background(), sqsMessage(tt.body, tt.receives))
+
+			if deleted := len(fake.deleted) == 1; deleted != tt.wantDeleted {
+				t.Errorf("deleted = %v, want %v", deleted, tt.wantDeleted)
+			}
+			if dlq := len(fake.sent["dlq"]) == 1; dlq != tt.wantDLQ {
+				t.Errorf("dead-lettered = %v, want %v", dlq, tt.wantDLQ)
+			}
+			if tt.wantDLQ {
+				attrs := fake.sent["dlq"][0].MessageAttributes
+				if aws.ToString(fake.sent["dlq"][0].MessageBody) != tt.body || aws.ToString(attrs["error_code"].StringValue) == "" {
+					t.Errorf("dead letter = %q with attributes %v, want the original body and the error", aws.ToString(fake.sent["dlq"][0].MessageBody), attrs)
+				}
+			}
+			if results := len(fake.sent["results"]) == 1; results != tt.wantResult {
+				t.Fatalf("result published = %v, want %v", results, tt.wantResult)
+			}
+			if tt.wantResult {
+				var result jobResult
+				if err := json.Unmarshal([]byte(aws.ToString(fake.sent["results"][0].MessageBody)), &result); err != nil || result.Status != jobSucceeded || result.URL == "" {
+					t.Errorf("result = %+v (%v), want a succeeded job with URL", result, err)
+				}
+			}
+		})
+	}
+}
+
+func TestSQSWorker_ExtendsVisibility(t *testing.T) {
+	fake := &fakeSQS{}
+	worker := newTestSQSWorker(fake, "dlq", &memoryStore{failures: 2})
+	worker.visibility = 10 * time.Millisecond
+	worker.processor.backoff = 40 * time.Millisecond
+
+	worker.handle(context.Background(), sqsMessage(`{"id": "a", "text": "hello"}`, "1"))
+
+	if len(fake.visibility) < 2 {
+		t.Errorf("visibility extended %d times during a slow job, want at least 2", len(fake.visibility))
+	}
+	if len(fake.deleted) != 1 {
+		t.Errorf("%d messages deleted, want 1", len(fake.deleted))
+	}
+}
+
+func TestSQSWorker_ShutdownReleasesMessage(t *testing.T) {
+	fake := &fakeSQS{messages: make(chan types.Message, 1)}
+	fake.messages <- sqsMessage(`{"id": "a", "text": "hello"}`, "1")
+	worker := newTestSQSWorker(fake, "dlq", &memoryStore{failures: -1})
+	worker.processor.backoff = time.Hour
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	worker.run(ctx)
+
+	if len(fake.deleted) != 0 || len(fake.sent) != 0 {
+		t.Errorf("interrupted job was settled: %d deleted, sent %v", len(fake.deleted), fake.sent)
+	}
+	if len(fake.visibility) != 1 || fake.visibility[0] != 0 {
+		t.Errorf("visibility changes = %v, want the message released with timeout 0", fake.visibility)
+	}
+}
+
+func TestSQSWorker_Check(t *testing.T) {
+	fake := &fakeSQS{}
+	if err := newTestSQSWorker(fake, "", &memoryStore{}).check(context.Background()); err != nil || fake.attrQueries != 1 {
+		t.Errorf("check = %v after %d queries, want nil after 1", err, fake.attrQueries)
+	}
+}
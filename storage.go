@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// objectStore stores generated artifacts for asynchronous consumers, who get
+// a URL to fetch them from instead of the bytes
+type objectStore interface {
+	// Put stores data under key and returns its URL
+	Put(ctx context.Context, key string, data []byte, contentType string) (string, error)
+	// Check reports whether the store is reachable, for the health endpoint
+	Check(ctx context.Context) error
+}
+
+// newObjectStore creates the store described by rawURL:
+//
+//	file:///var/lib/qr        files below a local directory (or a mounted volume)
+//	s3://bucket/prefix        objects in S3, credentials from the default AWS chain
+//
+// It returns nil when rawURL is empty.
+func newObjectStore(ctx context.Context, rawURL string) (objectStore, error) {
+	if rawURL == "" {
+		return nil, nil
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid storage URL: %w", err)
+	}
+
+	switch u.Scheme {
+	case "file":
+		if u.Path == "" {
+			return nil, fmt.Errorf("storage URL %q has no directory", rawURL)
+		}
+		return &fileStore{dir: u.Path}, nil
+	case "s3":
+		if u.Host == "" {
+			return nil, fmt.Errorf("storage URL %q has no bucket", rawURL)
+		}
+		cfg, err := awsconfig.LoadDefaultConfig(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("load AWS configuration: %w", err)
+		}
+		return &s3Store{client: s3.NewFromConfig(cfg), bucket: u.Host, prefix: strings.Trim(u.Path, "/")}, nil
+	}
+	return nil, fmt.Errorf("unsupported storage URL scheme %q (want file or s3)", u.Scheme)
+}
+
+// fileStore writes objects as files below dir
+type fileStore struct {
+	dir string
+}
+
+func (s *fileStore) Put(ctx context.Context, key string, data []byte, contentType string) (string, error) {
+	path := filepath.Join(s.dir, filepath.FromSlash(key))
+	if !strings.HasPrefix(path, filepath.Clean(s.dir)+string(filepath.Separator)) {
+		return "", fmt.Errorf("object key %q escapes the storage directory", key)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return "", err
+	}
+	// Write to a temporary file and rename, so readers never see a partial object
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".upload-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return "", err
+	}
+	return (&url.URL{Scheme: "file", Path: path}).String(), nil
+}
+
+// Check reports whether the directory exists or can be created
+func (s *fileStore) Check(ctx context.Context) error {
+	return os.MkdirAll(s.dir, 0o755)
+}
+
+// s3API is the part of *s3.Client the S3 store and event worker use
+type s3API interface {
+	GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error)
+	PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error)
+	HeadBucket(ctx context.Context, params *s3.HeadBucketInput, optFns ...func(*s3.Options)) (*s3.HeadBucketOutput, error)
+}
+
+// s3Store writes objects to an S3 bucket below prefix
+type s3Store struct {
+	client s3API
+	bucket string
+	prefix string
+}
+
+func (s *s3Store) Put(ctx context.Context, key string, data []byte, contentType string) (string, error) {
+	if s.prefix != "" {
+		key = s.prefix + "/" + key
+	}
+	_, err := s.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(s.bucket),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(data),
+		ContentType: aws.String(contentType),
+	})
+	if err != nil {
+		return "", fmt.Errorf("put s3://%s/%s: %w", s.bucket, key, err)
+	}
+	return "s3://" + s.bucket + "/" + key, nil
+}
+
+// Check makes a HEAD request for the bucket
+func (s *s3Store) Check(ctx context.Context) error {
+	if _, err := s.client.HeadBucket(ctx, &s3.HeadBucketInput{Bucket: aws.String(s.bucket)}); err != nil {
+		return fmt.Errorf("head s3://%s: %w", s.bucket, err)
+	}
+	return nil
+}
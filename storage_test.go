@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+func TestNewObjectStore(t *testing.T) {
+	tests := []struct {
+		name    string
+		url     string
+		wantNil bool
+		wantErr bool
+	}{
+		{name: "disabled", url: "", wantNil: true},
+		{name: "directory", url: "file:///var/lib/qr"},
+		{name: "bucket", url: "s3://qr-codes/generated"},
+		{name: "no directory", url: "file://", wantErr: true},
+		{name: "no bucket", url: "s3:///generated", wantErr: true},
+		{name: "unsupported scheme", url: "gs://qr-codes", wantErr: true},
+	}
+
+	t.Setenv("AWS_REGION", "us-east-1")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store, err := newObjectStore(context.Background(), tt.url)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("newObjectStore(%q) error = %v, wantErr %v", tt.url, err, tt.wantErr)
+			}
+			if !tt.wantErr && (store == nil) != tt.wantNil {
+				t.Errorf("newObjectStore(%q) = %v, want nil %v", tt.url, store, tt.wantNil)
+			}
+		})
+	}
+}
+
+func TestFileStore_Put(t *testing.T) {
+	tests := []struct {
+		name    string
+		key     string
+		wantErr bool
+	}{
+		{name: "flat", key: "code.png"},
+		{name: "nested", key: "2025/01/code.png"},
+		{name: "escapes the directory", key: "../code.png", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			store := &fileStore{dir: dir}
+			url, err := store.Put(context.Background(), tt.key, []byte("png"), "image/png")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Put(%q) error = %v, wantErr %v", tt.key, err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			path := filepath.Join(dir, tt.key)
+			if url != "file://"+path {
+				t.Errorf("Put() URL = %q, want %q", url, "file://"+path)
+			}
+			if data, err := os.ReadFile(path); err != nil || string(data) != "png" {
+				t.Errorf("stored file = %q, %v", data, err)
+			}
+		})
+	}
+}
+
+func TestS3Store_Put(t *testing.T) {
+	var gotPath, gotType, gotBody string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut {
+			http.Error(w, "unexpected method", http.StatusMethodNotAllowed)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		gotPath, gotType, gotBody = r.URL.Path, r.Header.Get("Content-Type"), string(body)
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name     string
+		prefix   string
+		wantPath string
+		wantURL  string
+	}{
+		{name: "with prefix", prefix: "generated", wantPath: "/qr-codes/generated/code.png", wantURL: "s3://qr-codes/generated/code.png"},
+		{name: "bucket root", wantPath: "/qr-codes/code.png", wantURL: "s3://qr-codes/code.png"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := &s3Store{
+				client: s3.New(s3.Options{
+					Region:       "us-east-1",
+					BaseEndpoint: aws.String(server.URL),
+					UsePathStyle: true,
+					Credentials:  credentials.NewStaticCredentialsProvider("key", "secret", ""),
+				}),
+				bucket: "qr-codes",
+				prefix: tt.prefix,
+			}
+			url, err := store.Put(context.Background(), "code.png", []byte("png"), "image/png")
+			if err != nil {
+				t.Fatalf("Put() error = %v", err)
+			}
+			if url != tt.wantURL {
+				t.Errorf("Put() URL = %q, want %q", url, tt.wantURL)
+			}
+			if gotPath != tt.wantPath || gotType != "image/png" || !strings.Contains(gotBody, "png") {
+				t.Errorf("request = %s (%s) %q, want %s", gotPath, gotType, gotBody, tt.wantPath)
+			}
+		})
+	}
+}
+
+func TestFileStore_Check(t *testing.T) {
+	dir := t.TempDir()
+	if err := (&fileStore{dir: filepath.Join(dir, "objects")}).Check(context.Background()); err != nil {
+		t.Errorf("Check() of a new directory = %v", err)
+	}
+
+	file := filepath.Join(dir, "file")
+	if err := os.WriteFile(file, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := (&fileStore{dir: file}).Check(context.Background()); err == nil {
+		t.Error("Check() of a file succeeded")
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// defaultTenant owns requests whose credentials carry no tenant
+const defaultTenant = "default"
+
+// errCodeQuotaExceeded is returned when a tenant used up its daily quota
+const errCodeQuotaExceeded = "quota_exceeded"
+
+// tenantFromContext returns the tenant of the authenticated caller, taken
+// from the API key assignment or the JWT tenant claim
+func tenantFromContext(ctx context.Context) string {
+	if p, ok := principalFromContext(ctx); ok && p.Tenant != "" {
+		return p.Tenant
+	}
+	return defaultTenant
+}
+
+// tenantConfig holds the per-tenant settings
+type tenantConfig struct {
+	// DailyQuota caps generation requests per UTC day; zero means unlimited
+	DailyQuota int `json:"daily_quota"`
+}
+
+// tenantRegistry holds tenant settings and tracks quota usage. Usage is
+// counted per replica, so with N replicas a tenant may use up to N times its
+// quota in the worst case.
+type tenantRegistry struct {
+	// configs is keyed by tenant; "*" applies to tenants not listed
+	configs map[string]tenantConfig
+	now     func() time.Time
+
+	mu    sync.Mutex
+	day   string
+	usage map[string]int
+}
+
+// loadTenants reads tenant settings from a JSON file of the form
+// {"acme": {"daily_quota": 10000}, "*": {"daily_quota": 1000}}.
+// Without a file no tenant has a quota.
+func loadTenants(path string) (*tenantRegistry, error) {
+	t := &tenantRegistry{configs: map[string]tenantConfig{}, now: time.Now, usage: map[string]int{}}
+	if path == "" {
+		return t, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tenants file: %w", err)
+	}
+	if err := json.Unmarshal(data, &t.configs); err != nil {
+		return nil, fmt.Errorf("invalid tenants file %s: %w", path, err)
+	}
+	for name, config := range t.configs {
+		if name != "*" && !validAPIKeyName.MatchString(name) {
+			return nil, fmt.Errorf("invalid tenant name %q", name)
+		}
+		if config.DailyQuota < 0 {
+			return nil, fmt.Errorf("tenant %q: daily_quota must not be negative", name)
+		}
+	}
+	return t, nil
+}
+
+// config returns the settings of tenant
+func (t *tenantRegistry) config(tenant string) tenantConfig {
+	if config, ok := t.configs[tenant]; ok {
+		return config
+	}
+	return t.configs["*"]
+}
+
+// usedToday returns how many requests tenant made today
+func (t *tenantRegistry) usedToday(tenant string) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.rollover()
+	return t.usage[tenant]
+}
+
+// take counts a request against tenant's quota, reporting false when the quota is used up
+func (t *tenantRegistry) take(tenant string) bool {
+	quota := t.config(tenant).DailyQuota
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.rollover()
+	if quota > 0 && t.usage[tenant] >= quota {
+		return false
+	}
+	t.usage[tenant]++
+	return true
+}
+
+// rollover resets usage at the start of a UTC day. The caller must hold t.mu.
+func (t *tenantRegistry) rollover() {
+	if day := t.now().UTC().Format(time.DateOnly); day != t.day {
+		t.day = day
+		clear(t.usage)
+	}
+}
+
+// enforceQuota rejects requests of tenants over their daily quota with 429
+// until the next UTC midnight. A nil registry enforces no quotas.
+func (t *tenantRegistry) enforceQuota(next http.HandlerFunc) http.HandlerFunc {
+	if t == nil {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		tenant := tenantFromContext(r.Context())
+		if !t.take(tenant) {
+			apiErr, retryAfter := t.quotaExceeded(tenant)
+			w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter.Seconds())+1))
+			writeAPIError(w, r, http.StatusTooManyRequests, *apiErr)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// quotaExceeded returns the error for tenant having used up its quota, and
+// how long until the quota resets at the next UTC midnight
+func (t *tenantRegistry) quotaExceeded(tenant string) (*apiError, time.Duration) {
+	now := t.now().UTC()
+	reset := now.Truncate(24 * time.Hour).Add(24 * time.Hour)
+	return apiErrorf(errCodeQuotaExceeded,
+		map[string]any{"tenant": tenant, "daily_quota": t.config(tenant).DailyQuota, "resets_at": reset},
+		"Daily quota of tenant %q is used up", tenant), reset.Sub(now)
+}
+
+// handleInfo describes the caller's tenant and its quota usage
+func (t *tenantRegistry) handleInfo(w http.ResponseWriter, r *http.Request) {
+	tenant := tenantFromContext(r.Context())
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"tenant":      tenant,
+		"daily_quota": t.config(tenant).DailyQuota,
+		"used_today":  t.usedToday(tenant),
+	})
+}
+
+// handleTenantActivity lists the caller's tenant's audited API operations,
+// filtered like GET /admin/audit. Other tenants' events are never returned.
+func (a *auditLog) handleTenantActivity(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseAuditFilter(r, 100)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	filter.Tenant = tenantFromContext(r.Context())
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(a.Query(filter))
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLoadTenants(t *testing.T) {
+	tests := []struct {
+		name      string
+		content   string
+		tenant    string
+		wantQuota int
+		wantErr   bool
+	}{
+		{name: "listed tenant", content: `{"acme": {"daily_quota": 10}, "*": {"daily_quota": 3}}`, tenant: "acme", wantQuota: 10},
+		{name: "wildcard fallback", content: `{"acme": {"daily_quota": 10}, "*": {"daily_quota": 3}}`, tenant: "globex", wantQuota: 3},
+		{name: "no fallback", content: `{"acme": {"daily_quota": 10}}`, tenant: "globex", wantQuota: 0},
+		{name: "negative quota", content: `{"acme": {"daily_quota": -1}}`, wantErr: true},
+		{name: "invalid tenant name", content: `{"bad name": {}}`, wantErr: true},
+		{name: "invalid json", content: `{`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "tenants.json")
+			if err := os.WriteFile(path, []byte(tt.content), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			tenants, err := loadTenants(path)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("loadTenants() expected error but got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("loadTenants() unexpected error: %v", err)
+			}
+			if got := tenants.config(tt.tenant).DailyQuota; got != tt.wantQuota {
+				t.Errorf("daily quota of %s = %d, want %d", tt.tenant, got, tt.wantQuota)
+			}
+		})
+	}
+}
+
+func TestTenantRegistry_Take(t *testing.T) {
+	now := time.Date(2024, 5, 1, 23, 59, 0, 0, time.UTC)
+	tenants := &tenantRegistry{configs: map[string]tenantConfig{"acme": {DailyQuota: 2}}, now: func() time.Time { return now }, usage: map[string]int{}}
+
+	for i, want := range []bool{true, true, false} {
+		if got := tenants.take("acme"); got != want {
+			t.Errorf("take #%d = %v, want %v", i+1, got, want)
+		}
+	}
+	if !tenants.take("globex") {
+		t.Error("tenant without quota was limited")
+	}
+
+	now = now.Add(2 * time.Minute)
+	if !tenants.take("acme") {
+		t.Error("quota was not reset at midnight UTC")
+	}
+}
+
+func TestTenantIsolation(t *testing.T) {
+	keys, err := newAPIKeyStore(true, "acme-ci=secret1,globex-ci=secret2,shared=secret3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := keys.SetTenants("acme-ci=acme,globex-ci=globex"); err != nil {
+		t.Fatal(err)
+	}
+	deps := newTestDeps()
+	deps.apiKeys = keys
+	deps.tenants.configs = map[string]tenantConfig{"acme": {DailyQuota: 1}}
+	handler := newHandler(deps)
+
+	do := func(method, path, key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set(apiKeyHeader, key)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	generate := []struct {
+		key      string
+		wantCode int
+	}{
+		{key: "secret1", wantCode: http.StatusOK},
+		{key: "secret1", wantCode: http.StatusTooManyRequests},
+		{key: "secret2", wantCode: http.StatusOK},
+		{key: "secret3", wantCode: http.StatusOK},
+	}
+	for i, tt := range generate {
+		if rec := do(http.MethodPost, "/api/v1/qr/generate?text=hello", tt.key); rec.Code != tt.wantCode {
+			t.Errorf("generate #%d status = %d, want %d", i+1, rec.Code, tt.wantCode)
+		}
+	}
+
+	tests := []struct {
+		key          string
+		wantTenant   string
+		wantUsed     int
+		wantActivity int
+	}{
+		{key: "secret1", wantTenant: "acme", wantUsed: 1, wantActivity: 2},
+		{key: "secret2", wantTenant: "globex", wantUsed: 1, wantActivity: 1},
+		{key: "secret3", wantTenant: defaultTenant, wantUsed: 1, wantActivity: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.wantTenant, func(t *testing.T) {
+			var info struct {
+				Tenant    string `json:"tenant"`
+				UsedToday int    `json:"used_today"`
+			}
+			if err := json.NewDecoder(do(http.MethodGet, "/api/v1/tenant", tt.key).Body).Decode(&info); err != nil {
+				t.Fatal(err)
+			}
+			if info.Tenant != tt.wantTenant || info.UsedToday != tt.wantUsed {
+				t.Errorf("tenant info = %+v, want %s with %d used", info, tt.wantTenant, tt.wantUsed)
+			}
+
+			var events []auditEvent
+			if err := json.NewDecoder(do(http.MethodGet, "/api/v1/tenant/activity", tt.key).Body).Decode(&events); err != nil {
+				t.Fatal(err)
+			}
+			if len(events) != tt.wantActivity {
+				t.Fatalf("activity has %d events, want %d", len(events), tt.wantActivity)
+			}
+			for _, event := range events {
+				if event.Tenant != tt.wantTenant {
+					t.Errorf("activity leaked an event of tenant %q", event.Tenant)
+				}
+			}
+		})
+	}
+}
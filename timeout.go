@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// timeoutMiddleware cancels the request context after timeout so that work on
+// slow or abandoned requests stops. Handlers are expected to pass r.Context()
+// to generation and storage calls and map its error with writeContextError.
+// A zero timeout disables the middleware.
+func timeoutMiddleware(timeout time.Duration, next http.Handler) http.Handler {
+	if timeout <= 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// statusClientClosedRequest is the (nginx) status recorded for requests whose
+// client disconnected before a response was written
+const statusClientClosedRequest = 499
+
+// writeContextError writes the response for a request whose context ended
+// before its work finished and reports whether err was such a context error.
+// Timeouts get a 503; requests cancelled by the client are recorded as 499
+// without a body since nobody is left to read it.
+func writeContextError(w http.ResponseWriter, r *http.Request, err error) bool {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		slog.WarnContext(r.Context(), "request timed out", "error", err)
+		addLogAttrs(r.Context(), slog.Bool("timed_out", true))
+		setRequestError(r.Context(), err)
+		http.Error(w, "Request timed out", http.StatusServiceUnavailable)
+		return true
+	case errors.Is(err, context.Canceled):
+		addLogAttrs(r.Context(), slog.Bool("client_canceled", true))
+		w.WriteHeader(statusClientClosedRequest)
+		return true
+	}
+	return false
+}
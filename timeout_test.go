@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestTimeoutMiddleware(t *testing.T) {
+	tests := []struct {
+		name         string
+		timeout      time.Duration
+		wantDeadline bool
+	}{
+		{name: "sets deadline", timeout: time.Second, wantDeadline: true},
+		{name: "zero disables", timeout: 0, wantDeadline: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotDeadline bool
+			handler := timeoutMiddleware(tt.timeout, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				_, gotDeadline = r.Context().Deadline()
+			}))
+
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if gotDeadline != tt.wantDeadline {
+				t.Errorf("context has deadline = %v, want %v", gotDeadline, tt.wantDeadline)
+			}
+		})
+	}
+}
+
+func TestWriteContextError(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		wantOK     bool
+		wantStatus int
+	}{
+		{name: "deadline exceeded", err: context.DeadlineExceeded, wantOK: true, wantStatus: http.StatusServiceUnavailable},
+		{name: "client canceled", err: context.Canceled, wantOK: true, wantStatus: statusClientClosedRequest},
+		{name: "other error", err: errors.New("boom"), wantOK: false, wantStatus: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			ok := writeContextError(rec, httptest.NewRequest(http.MethodGet, "/", nil), tt.err)
+
+			if ok != tt.wantOK {
+				t.Errorf("writeContextError() = %v, want %v", ok, tt.wantOK)
+			}
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestGenerate_ExpiredContext(t *testing.T) {
+	deps := newTestDeps()
+	deps.requestTimeout = time.Nanosecond
+	handler := newHandler(deps)
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/qr/generate?text=hello", nil)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusServiceUnavailable)
+	}
+}
+
+func TestQRCodeGenerator_CancelledContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := (&QRCodeGenerator{}).GenerateQRCodeBytes(ctx, "hello")
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("GenerateQRCodeBytes() error = %v, want %v", err, context.Canceled)
+	}
+}
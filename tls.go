@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// certReloader serves a certificate/key pair from disk and reloads it when the
+// files change, so rotated certificates (e.g. by cert-manager) are picked up
+// without restarting the process
+type certReloader struct {
+	certFile string
+	keyFile  string
+
+	mu      sync.RWMutex
+	cert    *tls.Certificate
+	modTime time.Time
+}
+
+// newCertReloader loads the initial certificate pair, failing if it is invalid
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	r := &certReloader{certFile: certFile, keyFile: keyFile}
+	if _, err := r.reloadIfChanged(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// GetCertificate implements tls.Config.GetCertificate
+func (r *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert, nil
+}
+
+// reloadIfChanged reloads the pair when either file's modification time changed.
+// It reports whether a new certificate was loaded; on error the current certificate is kept.
+func (r *certReloader) reloadIfChanged() (bool, error) {
+	modTime, err := latestModTime(r.certFile, r.keyFile)
+	if err != nil {
+		return false, err
+	}
+
+	r.mu.RLock()
+	unchanged := r.cert != nil && modTime.Equal(r.modTime)
+	r.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return false, fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+
+	r.mu.Lock()
+	r.cert = &cert
+	r.modTime = modTime
+	r.mu.Unlock()
+	return true, nil
+}
+
+// watch polls the certificate files every interval until ctx is cancelled
+func (r *certReloader) watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			reloaded, err := r.reloadIfChanged()
+			if err != nil {
+				slog.Error("TLS certificate reload failed, keeping previous certificate", "error", err)
+			} else if reloaded {
+				slog.Info("TLS certificate reloaded", "cert_file", r.certFile)
+			}
+		}
+	}
+}
+
+// latestModTime returns the most recent modification time of the given files
+func latestModTime(paths ...string) (time.Time, error) {
+	var latest time.Time
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("failed to stat %s: %w", path, err)
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+// newTLSConfig builds the server TLS configuration backed by the certificate reloader.
+// When clientCAs is non-nil, mutual TLS is enforced: clients must present a
+// certificate that chains to one of the given CAs.
+func newTLSConfig(reloader *certReloader, clientCAs *x509.CertPool) *tls.Config {
+	cfg := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: reloader.GetCertificate,
+	}
+	if clientCAs != nil {
+		cfg.ClientCAs = clientCAs
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return cfg
+}
+
+// loadCertPool reads a PEM bundle of CA certificates
+func loadCertPool(path string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA bundle: %w", err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no valid certificates found in CA bundle %s", path)
+	}
+	return pool, nil
+}
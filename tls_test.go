@@ -0,0 +1,215 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeSelfSignedCert writes a self-signed certificate and key for commonName into dir
+func writeSelfSignedCert(t *testing.T, dir, commonName string) (certFile, keyFile string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IsCA:         true,
+
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("failed to marshal key: %v", err)
+	}
+
+	certFile = filepath.Join(dir, "tls.crt")
+	keyFile = filepath.Join(dir, "tls.key")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatalf("failed to write certificate: %v", err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatalf("failed to write key: %v", err)
+	}
+	return certFile, keyFile
+}
+
+// servedCommonName returns the subject CN of the certificate currently served by the reloader
+func servedCommonName(t *testing.T, r *certReloader) string {
+	t.Helper()
+	cert, err := r.GetCertificate(&tls.ClientHelloInfo{})
+	if err != nil {
+		t.Fatalf("GetCertificate() error: %v", err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatalf("failed to parse served certificate: %v", err)
+	}
+	return leaf.Subject.CommonName
+}
+
+func TestCertReloader_ReloadsOnChange(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeSelfSignedCert(t, dir, "first")
+
+	reloader, err := newCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("newCertReloader() error: %v", err)
+	}
+	if got := servedCommonName(t, reloader); got != "first" {
+		t.Fatalf("served CN = %q, want %q", got, "first")
+	}
+
+	if reloaded, err := reloader.reloadIfChanged(); err != nil || reloaded {
+		t.Errorf("reloadIfChanged() on unchanged files = %v, %v; want false, nil", reloaded, err)
+	}
+
+	writeSelfSignedCert(t, dir, "second")
+	future := time.Now().Add(time.Minute)
+	for _, path := range []string{certFile, keyFile} {
+		if err := os.Chtimes(path, future, future); err != nil {
+			t.Fatalf("failed to bump mtime: %v", err)
+		}
+	}
+
+	reloaded, err := reloader.reloadIfChanged()
+	if err != nil || !reloaded {
+		t.Fatalf("reloadIfChanged() after rotation = %v, %v; want true, nil", reloaded, err)
+	}
+	if got := servedCommonName(t, reloader); got != "second" {
+		t.Errorf("served CN after reload = %q, want %q", got, "second")
+	}
+}
+
+func TestCertReloader_KeepsCertificateOnInvalidUpdate(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeSelfSignedCert(t, dir, "valid")
+
+	reloader, err := newCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("newCertReloader() error: %v", err)
+	}
+
+	if err := os.WriteFile(certFile, []byte("not a certificate"), 0o600); err != nil {
+		t.Fatalf("failed to corrupt certificate: %v", err)
+	}
+	future := time.Now().Add(time.Minute)
+	if err := os.Chtimes(certFile, future, future); err != nil {
+		t.Fatalf("failed to bump mtime: %v", err)
+	}
+
+	if _, err := reloader.reloadIfChanged(); err == nil {
+		t.Error("reloadIfChanged() expected error for invalid certificate")
+	}
+	if got := servedCommonName(t, reloader); got != "valid" {
+		t.Errorf("served CN = %q, want previous %q", got, "valid")
+	}
+}
+
+func TestNewCertReloader_MissingFiles(t *testing.T) {
+	if _, err := newCertReloader("/nonexistent/tls.crt", "/nonexistent/tls.key"); err == nil {
+		t.Error("newCertReloader() expected error for missing files")
+	}
+}
+
+func TestMutualTLS(t *testing.T) {
+	serverCert, serverKey := writeSelfSignedCert(t, t.TempDir(), "server")
+	clientCert, clientKey := writeSelfSignedCert(t, t.TempDir(), "trusted-client")
+	untrustedCert, untrustedKey := writeSelfSignedCert(t, t.TempDir(), "untrusted-client")
+
+	reloader, err := newCertReloader(serverCert, serverKey)
+	if err != nil {
+		t.Fatalf("newCertReloader() error: %v", err)
+	}
+	clientCAs, err := loadCertPool(clientCert)
+	if err != nil {
+		t.Fatalf("loadCertPool() error: %v", err)
+	}
+
+	server := httptest.NewUnstartedServer(newTestHandler())
+	server.TLS = newTLSConfig(reloader, clientCAs)
+	server.StartTLS()
+	defer server.Close()
+
+	serverCAs, err := loadCertPool(serverCert)
+	if err != nil {
+		t.Fatalf("loadCertPool() error: %v", err)
+	}
+
+	newClient := func(certFile, keyFile string) *http.Client {
+		tlsConfig := &tls.Config{RootCAs: serverCAs, ServerName: "localhost"}
+		if certFile != "" {
+			pair, err := tls.LoadX509KeyPair(certFile, keyFile)
+			if err != nil {
+				t.Fatalf("failed to load client certificate: %v", err)
+			}
+			tlsConfig.Certificates = []tls.Certificate{pair}
+		}
+		return &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}
+	}
+
+	tests := []struct {
+		name     string
+		certFile string
+		keyFile  string
+		wantOK   bool
+	}{
+		{"trusted client certificate", clientCert, clientKey, true},
+		{"no client certificate", "", "", false},
+		{"untrusted client certificate", untrustedCert, untrustedKey, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := newClient(tt.certFile, tt.keyFile).Get(server.URL + "/health")
+			if tt.wantOK {
+				if err != nil {
+					t.Fatalf("request failed: %v", err)
+				}
+				resp.Body.Close()
+				if resp.StatusCode != http.StatusOK {
+					t.Errorf("status = %d, want %d", resp.StatusCode, http.StatusOK)
+				}
+				return
+			}
+			if err == nil {
+				resp.Body.Close()
+				t.Error("expected TLS handshake to be rejected")
+			}
+		})
+	}
+}
+
+func TestLoadCertPool_Invalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(path, []byte("garbage"), 0o600); err != nil {
+		t.Fatalf("failed to write bundle: %v", err)
+	}
+	if _, err := loadCertPool(path); err == nil {
+		t.Error("loadCertPool() expected error for bundle without certificates")
+	}
+}
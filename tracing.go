@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName is the instrumentation scope used for spans created by this service
+const tracerName = "github.com/ohav/qr-code-generator-go-k8s"
+
+// tracer returns the service tracer from the globally registered provider
+func tracer() trace.Tracer {
+	return otel.Tracer(tracerName)
+}
+
+// setupTracing installs the global tracer provider and W3C trace context propagator.
+// When no OTLP endpoint is configured spans are still created (so incoming trace
+// context is propagated) but nothing is exported. The returned function flushes
+// and shuts down the provider.
+func setupTracing(ctx context.Context, cfg Config, pod podInfo) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+
+	attrs := []attribute.KeyValue{semconv.ServiceName(cfg.ServiceName), semconv.K8SPodName(pod.Name)}
+	if pod.Namespace != "" {
+		attrs = append(attrs, semconv.K8SNamespaceName(pod.Namespace))
+	}
+	if pod.Node != "" {
+		attrs = append(attrs, semconv.K8SNodeName(pod.Node))
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(semconv.SchemaURL, attrs...))
+	if err != nil {
+		return nil, fmt.Errorf("failed to build trace resource: %w", err)
+	}
+
+	opts := []sdktrace.TracerProviderOption{
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.TraceSampleRatio))),
+	}
+
+	if cfg.OTLPEndpoint != "" {
+		exporterOpts := []otlptracehttp.Option{otlptracehttp.WithEndpointURL(cfg.OTLPEndpoint)}
+		if cfg.OTLPInsecure {
+			exporterOpts = append(exporterOpts, otlptracehttp.WithInsecure())
+		}
+
+		exporter, err := otlptracehttp.New(ctx, exporterOpts...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create OTLP trace exporter: %w", err)
+		}
+		opts = append(opts, sdktrace.WithBatcher(exporter))
+	}
+
+	provider := sdktrace.NewTracerProvider(opts...)
+	otel.SetTracerProvider(provider)
+
+	return provider.Shutdown, nil
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestTracing_GenerateCreatesSpans(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() { _ = provider.Shutdown(context.Background()) })
+
+	handler := newTestHandler()
+
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/qr/generate?text=hello", nil)
+	req.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	rec := httptest.NewRecorder()
+
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	spans := recorder.Ended()
+	names := make(map[string]bool)
+	for _, span := range spans {
+		names[span.Name()] = true
+		if got := span.SpanContext().TraceID().String(); got != traceID {
+			t.Errorf("span %q trace ID = %s, want propagated %s", span.Name(), got, traceID)
+		}
+	}
+
+	for _, want := range []string{"/api/v1/qr/generate", "QRCodeGenerator.GenerateQRCodeBytes"} {
+		if !names[want] {
+			t.Errorf("expected span %q, got %v", want, names)
+		}
+	}
+}
+
+func TestSetupTracing_WithoutExporter(t *testing.T) {
+	shutdown, err := setupTracing(context.Background(), Config{ServiceName: "qr-generator", TraceSampleRatio: 1}, podInfo{Name: "pod-1", Namespace: "ns", Node: "node-1"})
+	if err != nil {
+		t.Fatalf("setupTracing() error: %v", err)
+	}
+	if err := shutdown(context.Background()); err != nil {
+		t.Errorf("shutdown() error: %v", err)
+	}
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+// uiFiles is the generator page: static HTML, CSS, and JavaScript that call
+// the generate endpoint from the browser
+//
+//go:embed ui
+var uiFiles embed.FS
+
+// uiContentSecurityPolicy limits the page to its own scripts and styles,
+// and to previews of generated images
+const uiContentSecurityPolicy = "default-src 'none'; script-src 'self'; style-src 'self'; img-src 'self' blob:; connect-src 'self'; form-action 'none'; frame-ancestors 'none'; base-uri 'none'"
+
+// handleUI serves the generator page under /ui/, so colleagues can create
+// codes from a browser instead of curl
+func handleUI() http.HandlerFunc {
+	root, _ := fs.Sub(uiFiles, "ui")
+	files := http.StripPrefix("/ui/", http.FileServerFS(root))
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Security-Policy", uiContentSecurityPolicy)
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.Header().Set("Cache-Control", "no-cache")
+		files.ServeHTTP(w, r)
+	}
+}
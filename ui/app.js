@@ -0,0 +1,191 @@
+// Live preview of the generator page: every change of the form renders the
+// code, and the result can be downloaded. Renders go over the WebSocket
+// preview channel, which also reports options that hurt scannability; the
+// generate endpoint is the fallback when the channel is unavailable.
+"use strict";
+
+const form = document.getElementById("options");
+const apiKey = document.getElementById("api-key");
+const message = document.getElementById("message");
+const preview = document.getElementById("preview");
+const details = document.getElementById("details");
+const warnings = document.getElementById("warnings");
+const download = document.getElementById("download");
+
+let timer;
+let pending;
+let imageURL;
+let socket;
+let lastID = 0;
+
+apiKey.value = sessionStorage.getItem("qr-api-key") || "";
+apiKey.addEventListener("change", () => {
+  sessionStorage.setItem("qr-api-key", apiKey.value);
+  // The key authenticates the channel's handshake
+  if (socket) {
+    socket.close();
+  }
+  connect();
+});
+
+form.addEventListener("input", () => {
+  clearTimeout(timer);
+  timer = setTimeout(render, 300);
+});
+form.addEventListener("submit", (event) => event.preventDefault());
+
+connect();
+
+// connect opens the preview channel. Browsers can't send headers with the
+// handshake, so the API key travels as a subprotocol.
+function connect() {
+  const protocols = ["qr-preview"];
+  if (apiKey.value) {
+    const key = btoa(String.fromCharCode(...new TextEncoder().encode(apiKey.value)));
+    protocols.push("qr-preview.key." + key.replace(/\+/g, "-").replace(/\//g, "_").replace(/=+$/, ""));
+  }
+  // Relative to the page, so the UI works below the service's base path
+  const url = new URL("ws", location.href);
+  url.protocol = location.protocol === "https:" ? "wss:" : "ws:";
+  const channel = new WebSocket(url, protocols);
+  channel.addEventListener("open", () => {
+    socket = channel;
+  });
+  channel.addEventListener("message", (event) => received(JSON.parse(event.data)));
+  channel.addEventListener("close", () => {
+    if (socket === channel) {
+      socket = undefined;
+    }
+  });
+}
+
+function show(text, isError) {
+  message.textContent = text;
+  message.classList.toggle("error", isError);
+  message.hidden = false;
+}
+
+function clearImage() {
+  preview.hidden = true;
+  details.hidden = true;
+  warnings.hidden = true;
+  download.hidden = true;
+  if (imageURL) {
+    URL.revokeObjectURL(imageURL);
+    imageURL = undefined;
+  }
+}
+
+function showImage(blob, data) {
+  clearImage();
+  imageURL = URL.createObjectURL(blob);
+  preview.src = imageURL;
+  preview.alt = "QR code for " + data.get("text");
+  preview.hidden = false;
+  download.href = imageURL;
+  download.download = "qrcode." + data.get("format");
+  download.hidden = false;
+  message.hidden = true;
+}
+
+function render() {
+  const data = new FormData(form);
+  if (!data.get("text").trim()) {
+    lastID++;
+    clearImage();
+    show("Enter a text to see its QR code.", false);
+    return;
+  }
+  if (socket && socket.readyState === WebSocket.OPEN) {
+    if (pending) {
+      pending.abort();
+      pending = undefined;
+    }
+    lastID++;
+    socket.send(JSON.stringify({ id: lastID, ...Object.fromEntries(data) }));
+    return;
+  }
+  renderWithFetch(data);
+  // Retry the channel for the next change
+  if (!socket) {
+    connect();
+  }
+}
+
+// received shows a preview channel response unless a later change
+// superseded it
+function received(response) {
+  if (response.id !== lastID) {
+    return;
+  }
+  if (response.error) {
+    clearImage();
+    show(response.error.message, true);
+    return;
+  }
+  const bytes = Uint8Array.from(atob(response.image), (c) => c.charCodeAt(0));
+  const data = new FormData(form);
+  showImage(new Blob([bytes], { type: response.content_type }), data);
+
+  details.textContent = `Version ${response.version}, ${response.modules}×${response.modules} modules`;
+  details.hidden = false;
+  warnings.replaceChildren(...(response.warnings || []).map((text) => {
+    const item = document.createElement("li");
+    item.textContent = text;
+    return item;
+  }));
+  warnings.hidden = !response.warnings;
+}
+
+async function renderWithFetch(data) {
+  if (pending) {
+    pending.abort();
+  }
+  const request = new AbortController();
+  pending = request;
+  const headers = {};
+  if (apiKey.value) {
+    headers["X-API-Key"] = apiKey.value;
+  }
+
+  let blob;
+  try {
+    const response = await fetch("../api/v1/qr/generate?" + new URLSearchParams(data), {
+      method: "POST",
+      headers,
+      signal: request.signal,
+    });
+    if (!response.ok) {
+      const text = await errorMessage(response);
+      if (request === pending) {
+        clearImage();
+        show(text, true);
+      }
+      return;
+    }
+    blob = await response.blob();
+  } catch (err) {
+    if (err.name !== "AbortError") {
+      clearImage();
+      show("The service could not be reached.", true);
+    }
+    return;
+  }
+  // A later change superseded this request
+  if (request !== pending) {
+    return;
+  }
+  showImage(blob, data);
+}
+
+async function errorMessage(response) {
+  if (response.status === 401 || response.status === 403) {
+    return "The service requires authentication: enter an API key under Authentication.";
+  }
+  const body = await response.text();
+  try {
+    return JSON.parse(body).error.message;
+  } catch {
+    return body.trim() || response.statusText;
+  }
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestUI(t *testing.T) {
+	tests := []struct {
+		name            string
+		enabled         bool
+		path            string
+		wantStatus      int
+		wantContentType string
+		wantBody        string
+	}{
+		{name: "disabled", path: "/ui/", wantStatus: http.StatusNotFound},
+		{name: "redirect", enabled: true, path: "/ui", wantStatus: http.StatusMovedPermanently},
+		{name: "page", enabled: true, path: "/ui/", wantStatus: http.StatusOK, wantContentType: "text/html; charset=utf-8", wantBody: `<script src="app.js" defer></script>`},
+		{name: "script", enabled: true, path: "/ui/app.js", wantStatus: http.StatusOK, wantContentType: "text/javascript; charset=utf-8", wantBody: "/api/v1/qr/generate?"},
+		{name: "stylesheet", enabled: true, path: "/ui/style.css", wantStatus: http.StatusOK, wantContentType: "text/css; charset=utf-8"},
+		{name: "missing file", enabled: true, path: "/ui/missing.js", wantStatus: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deps := newTestDeps()
+			deps.flags.Set(flagUI, tt.enabled)
+			rec := httptest.NewRecorder()
+			newHandler(deps).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if rec.Code != http.StatusOK {
+				return
+			}
+			if got := rec.Header().Get("Content-Type"); got != tt.wantContentType {
+				t.Errorf("content type = %q, want %q", got, tt.wantContentType)
+			}
+			if csp := rec.Header().Get("Content-Security-Policy"); !strings.Contains(csp, "script-src 'self'") {
+				t.Errorf("Content-Security-Policy = %q", csp)
+			}
+			if !strings.Contains(rec.Body.String(), tt.wantBody) {
+				t.Errorf("body lacks %q", tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestUI_FormMatchesGenerateParameters(t *testing.T) {
+	page, err := uiFiles.ReadFile("ui/index.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The form is sent as the generate endpoint's query parameters
+	for _, param := range []string{"text", "size", "ec", "fg", "bg", "format"} {
+		if !strings.Contains(string(page), `name="`+param+`"`) {
+			t.Errorf("form has no field %q", param)
+		}
+	}
+	for level := range recoveryLevels {
+		if !strings.Contains(string(page), `<option value="`+level+`"`) {
+			t.Errorf("form has no error correction level %q", level)
+		}
+	}
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// serviceAccountTokenFile is the projected token used for Vault's Kubernetes auth method
+const serviceAccountTokenFile = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+
+// vaultClient reads the service's secrets from a Vault KV secret and keeps its
+// token alive. It authenticates with a static token or, when role is set, with
+// the Kubernetes auth method.
+type vaultClient struct {
+	addr       string
+	secretPath string
+	role       string
+	authPath   string
+	jwtFile    string
+	client     *http.Client
+
+	mu        sync.Mutex
+	token     string
+	renewable bool
+	ttl       time.Duration
+}
+
+// newVaultClient creates a client for the Vault settings in cfg
+func newVaultClient(cfg Config) *vaultClient {
+	return &vaultClient{
+		addr:       strings.TrimSuffix(cfg.VaultAddr, "/"),
+		secretPath: strings.Trim(cfg.VaultSecretPath, "/"),
+		role:       cfg.VaultRole,
+		authPath:   strings.Trim(cfg.VaultAuthPath, "/"),
+		jwtFile:    serviceAccountTokenFile,
+		client:     newOutboundClient(10 * time.Second),
+		token:      cfg.VaultToken,
+		renewable:  cfg.VaultRole == "",
+	}
+}
+
+// vaultAuth is the auth block of Vault login and renew responses
+type vaultAuth struct {
+	ClientToken   string `json:"client_token"`
+	LeaseDuration int    `json:"lease_duration"`
+	Renewable     bool   `json:"renewable"`
+}
+
+// login exchanges the service account token for a Vault token (Kubernetes auth)
+func (c *vaultClient) login(ctx context.Context) error {
+	if c.role == "" {
+		if c.token == "" {
+			return errors.New("VAULT_TOKEN or VAULT_ROLE is required")
+		}
+		return nil
+	}
+
+	jwt, err := os.ReadFile(c.jwtFile)
+	if err != nil {
+		return fmt.Errorf("failed to read service account token: %w", err)
+	}
+	var resp struct {
+		Auth vaultAuth `json:"auth"`
+	}
+	body := map[string]string{"role": c.role, "jwt": strings.TrimSpace(string(jwt))}
+	if err := c.do(ctx, http.MethodPost, "auth/"+c.authPath+"/login", body, &resp); err != nil {
+		return fmt.Errorf("vault login failed: %w", err)
+	}
+	c.setAuth(resp.Auth)
+	return nil
+}
+
+// renew extends the lease of the current token, logging in again when the
+// token cannot be renewed (e.g. it reached its max TTL). A static token that
+// turns out not to be renewable is left alone afterwards.
+func (c *vaultClient) renew(ctx context.Context) error {
+	c.mu.Lock()
+	renewable := c.renewable
+	c.mu.Unlock()
+
+	if !renewable && c.role == "" {
+		return nil
+	}
+	if renewable {
+		var resp struct {
+			Auth vaultAuth `json:"auth"`
+		}
+		err := c.do(ctx, http.MethodPost, "auth/token/renew-self", nil, &resp)
+		if err == nil {
+			c.setAuth(resp.Auth)
+			return nil
+		}
+		if c.role == "" {
+			c.mu.Lock()
+			c.renewable = false
+			c.mu.Unlock()
+			return fmt.Errorf("vault token renewal failed: %w", err)
+		}
+		slog.Warn("vault token renewal failed, logging in again", "error", err)
+	}
+	return c.login(ctx)
+}
+
+// setAuth stores the token and lease from a login or renew response
+func (c *vaultClient) setAuth(auth vaultAuth) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if auth.ClientToken != "" {
+		c.token = auth.ClientToken
+	}
+	c.renewable = auth.Renewable
+	c.ttl = time.Duration(auth.LeaseDuration) * time.Second
+}
+
+// Read returns the string fields of the secret. Both KV v1 and v2 responses
+// are supported.
+func (c *vaultClient) Read(ctx context.Context) (map[string]string, error) {
+	var resp struct {
+		Data map[string]any `json:"data"`
+	}
+	if err := c.do(ctx, http.MethodGet, c.secretPath, nil, &resp); err != nil {
+		return nil, fmt.Errorf("failed to read vault secret %s: %w", c.secretPath, err)
+	}
+
+	data := resp.Data
+	if nested, ok := data["data"].(map[string]any); ok {
+		if _, versioned := data["metadata"]; versioned {
+			data = nested
+		}
+	}
+	values := make(map[string]string, len(data))
+	for name, value := range data {
+		if s, ok := value.(string); ok {
+			values[name] = s
+		}
+	}
+	return values, nil
+}
+
+// Load logs in and reads the secret
+func (c *vaultClient) Load(ctx context.Context) (map[string]string, error) {
+	if err := c.login(ctx); err != nil {
+		return nil, err
+	}
+	return c.Read(ctx)
+}
+
+// watch renews the token and re-reads the secret every interval (sooner when
+// the token's lease is shorter) until ctx is cancelled, passing each read to
+// apply. On errors the previous secrets stay in effect.
+func (c *vaultClient) watch(ctx context.Context, interval time.Duration, apply func(map[string]string)) {
+	timer := time.NewTimer(c.nextRefresh(interval))
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+			if err := c.renew(ctx); err != nil {
+				slog.Error("vault token refresh failed", "error", err)
+			}
+			if values, err := c.Read(ctx); err != nil {
+				slog.Error("vault secret refresh failed, keeping previous secrets", "error", err)
+			} else {
+				apply(values)
+			}
+			timer.Reset(c.nextRefresh(interval))
+		}
+	}
+}
+
+// nextRefresh returns interval, shortened to half the token lease so the
+// token is renewed before it expires
+func (c *vaultClient) nextRefresh(interval time.Duration) time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.ttl > 0 && c.ttl/2 < interval {
+		return max(c.ttl/2, time.Second)
+	}
+	return interval
+}
+
+// do sends a request to the Vault API and decodes the JSON response into out
+func (c *vaultClient) do(ctx context.Context, method, path string, body, out any) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.addr+"/v1/"+path, reader)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	if c.token != "" {
+		req.Header.Set("X-Vault-Token", c.token)
+	}
+	c.mu.Unlock()
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var errResp struct {
+			Errors []string `json:"errors"`
+		}
+		json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&errResp)
+		return fmt.Errorf("%s %s: status %d %s", method, path, resp.StatusCode, strings.Join(errResp.Errors, "; "))
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeVault serves Kubernetes login, token renewal, and a KV v2 secret
+type fakeVault struct {
+	server  *httptest.Server
+	secret  atomic.Value
+	renews  atomic.Int32
+	renewOK atomic.Bool
+}
+
+func newFakeVault(t *testing.T) *fakeVault {
+	t.Helper()
+	v := &fakeVault{}
+	v.secret.Store(map[string]string{"ADMIN_TOKEN": "vault-admin", "API_KEYS": "ci=secret1"})
+	v.renewOK.Store(true)
+
+	auth := func(w http.ResponseWriter, token string) {
+		json.NewEncoder(w).Encode(map[string]any{"auth": map[string]any{"client_token": token, "lease_duration": 60, "renewable": true}})
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /v1/auth/kubernetes/login", func(w http.ResponseWriter, r *http.Request) {
+		var body struct{ Role, JWT string }
+		json.NewDecoder(r.Body).Decode(&body)
+		if body.Role != "qr-generator" || body.JWT != "sa-token" {
+			w.WriteHeader(http.StatusForbidden)
+			json.NewEncoder(w).Encode(map[string]any{"errors": []string{"permission denied"}})
+			return
+		}
+		auth(w, "login-token")
+	})
+	mux.HandleFunc("POST /v1/auth/token/renew-self", func(w http.ResponseWriter, r *http.Request) {
+		v.renews.Add(1)
+		if !v.renewOK.Load() {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		auth(w, r.Header.Get("X-Vault-Token"))
+	})
+	mux.HandleFunc("GET /v1/secret/data/qr-generator", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "login-token" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{"data": map[string]any{
+			"data":     v.secret.Load(),
+			"metadata": map[string]any{"version": 1},
+		}})
+	})
+	v.server = httptest.NewServer(mux)
+	t.Cleanup(v.server.Close)
+	return v
+}
+
+func newTestVaultClient(t *testing.T, vault *fakeVault, role string) *vaultClient {
+	t.Helper()
+	jwtFile := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(jwtFile, []byte("sa-token\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	c := newVaultClient(Config{VaultAddr: vault.server.URL + "/", VaultRole: role, VaultAuthPath: "kubernetes", VaultSecretPath: "secret/data/qr-generator"})
+	c.jwtFile = jwtFile
+	return c
+}
+
+func TestVaultClient_Load(t *testing.T) {
+	vault := newFakeVault(t)
+
+	tests := []struct {
+		name      string
+		role      string
+		token     string
+		wantErr   bool
+		wantAdmin string
+	}{
+		{name: "kubernetes auth", role: "qr-generator", wantAdmin: "vault-admin"},
+		{name: "static token", token: "login-token", wantAdmin: "vault-admin"},
+		{name: "wrong role", role: "other", wantErr: true},
+		{name: "no credentials", wantErr: true},
+		{name: "forbidden token", token: "bad-token", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestVaultClient(t, vault, tt.role)
+			c.token = tt.token
+
+			values, err := c.Load(context.Background())
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("Load() expected error but got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Load() unexpected error: %v", err)
+			}
+			if values["ADMIN_TOKEN"] != tt.wantAdmin {
+				t.Errorf("ADMIN_TOKEN = %q, want %q", values["ADMIN_TOKEN"], tt.wantAdmin)
+			}
+		})
+	}
+}
+
+func TestVaultClient_Renew(t *testing.T) {
+	vault := newFakeVault(t)
+	c := newTestVaultClient(t, vault, "qr-generator")
+	if _, err := c.Load(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := c.nextRefresh(5 * time.Minute); got != 30*time.Second {
+		t.Errorf("nextRefresh() = %s, want half the 60s lease", got)
+	}
+
+	if err := c.renew(context.Background()); err != nil || vault.renews.Load() != 1 {
+		t.Fatalf("renew() error = %v after %d renewals", err, vault.renews.Load())
+	}
+
+	// A token that can no longer be renewed is replaced by logging in again
+	vault.renewOK.Store(false)
+	c.token = "expired"
+	if err := c.renew(context.Background()); err != nil {
+		t.Fatalf("renew() with failed renewal error = %v, want re-login", err)
+	}
+	if c.token != "login-token" {
+		t.Errorf("token = %q, want a fresh login token", c.token)
+	}
+}
+
+func TestVaultClient_Watch(t *testing.T) {
+	vault := newFakeVault(t)
+	c := newTestVaultClient(t, vault, "qr-generator")
+	if _, err := c.Load(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	vault.secret.Store(map[string]string{"API_KEYS": "ci=rotated"})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	applied := make(chan map[string]string, 1)
+	go c.watch(ctx, 10*time.Millisecond, func(values map[string]string) {
+		select {
+		case applied <- values:
+		default:
+		}
+	})
+
+	select {
+	case values := <-applied:
+		if values["API_KEYS"] != "ci=rotated" {
+			t.Errorf("watch applied %v, want the rotated secret", values)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("watch did not re-read the secret")
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"testing"
+)
+
+func TestHandleVersion(t *testing.T) {
+	origVersion, origCommit, origTime := version, gitCommit, buildTime
+	version, gitCommit, buildTime = "1.2.3", "abc1234", "2025-01-01T00:00:00Z"
+	t.Cleanup(func() { version, gitCommit, buildTime = origVersion, origCommit, origTime })
+
+	rec := httptest.NewRecorder()
+	handleVersion(rec, httptest.NewRequest(http.MethodGet, "/version", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	var got versionInfo
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatalf("version response is not valid JSON: %v", err)
+	}
+	want := versionInfo{Version: "1.2.3", GitCommit: "abc1234", BuildTime: "2025-01-01T00:00:00Z", GoVersion: runtime.Version()}
+	if got != want {
+		t.Errorf("version = %+v, want %+v", got, want)
+	}
+}
+
+func TestHandleVersion_WrongMethod(t *testing.T) {
+	rec := httptest.NewRecorder()
+	handleVersion(rec, httptest.NewRequest(http.MethodPost, "/version", nil))
+
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// warmupPayloads are generated at boot to exercise the encoder (and fill any
+// render caches) before the pod receives traffic
+var warmupPayloads = []string{
+	"https://example.com",
+	"WARMUP-0123456789",
+	"Warmup payload with mixed content: äöü 世界",
+}
+
+// warmupRetryInterval is the delay between failed warmup attempts
+const warmupRetryInterval = 5 * time.Second
+
+// readiness tracks whether the service has finished warming up and may receive traffic
+type readiness struct {
+	ready atomic.Bool
+}
+
+// SetReady marks the service as ready (or not) to receive traffic
+func (r *readiness) SetReady(ready bool) {
+	r.ready.Store(ready)
+}
+
+// Ready reports whether the service is ready to receive traffic
+func (r *readiness) Ready() bool {
+	return r.ready.Load()
+}
+
+// ServeHTTP reports readiness: 200 once warmed up, 503 before
+func (r *readiness) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if !r.Ready() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprintf(w, `{"status":"warming_up"}`)
+		return
+	}
+	fmt.Fprintf(w, `{"status":"ready"}`)
+}
+
+// warmup generates a few dummy codes and verifies all components are usable
+func warmup(ctx context.Context, qrGen *QRCodeGenerator, health *healthRegistry) error {
+	for _, payload := range warmupPayloads {
+		if _, err := qrGen.GenerateQRCodeBytes(ctx, payload); err != nil {
+			return fmt.Errorf("warmup generation failed: %w", err)
+		}
+	}
+
+	result := health.Check(ctx)
+	if result.Status == statusUnhealthy {
+		for name, component := range result.Components {
+			if component.Status != statusHealthy && component.Critical {
+				return fmt.Errorf("component %s unhealthy: %s", name, component.Error)
+			}
+		}
+		return fmt.Errorf("service unhealthy")
+	}
+	return nil
+}
+
+// warmupUntilReady retries warmup until it succeeds or ctx is cancelled, then marks the service ready
+func warmupUntilReady(ctx context.Context, qrGen *QRCodeGenerator, health *healthRegistry, ready *readiness) {
+	for {
+		start := time.Now()
+		err := warmup(ctx, qrGen, health)
+		if err == nil {
+			ready.SetReady(true)
+			slog.Info("warmup complete, service ready", "duration", time.Since(start))
+			return
+		}
+
+		slog.Warn("warmup failed, retrying", "error", err, "retry_in", warmupRetryInterval)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(warmupRetryInterval):
+		}
+	}
+}
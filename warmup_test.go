@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestReadiness_ServeHTTP(t *testing.T) {
+	ready := &readiness{}
+
+	rec := httptest.NewRecorder()
+	ready.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/ready", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("status before warmup = %d, want %d", rec.Code, http.StatusServiceUnavailable)
+	}
+
+	ready.SetReady(true)
+	rec = httptest.NewRecorder()
+	ready.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/ready", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("status after warmup = %d, want %d", rec.Code, http.StatusOK)
+	}
+}
+
+func TestWarmup(t *testing.T) {
+	t.Run("succeeds with healthy components", func(t *testing.T) {
+		health := newHealthRegistry()
+		health.Register("storage", true, func(context.Context) error { return nil })
+
+		if err := warmup(context.Background(), &QRCodeGenerator{}, health); err != nil {
+			t.Errorf("warmup() unexpected error: %v", err)
+		}
+	})
+
+	t.Run("fails when a critical component is down", func(t *testing.T) {
+		health := newHealthRegistry()
+		health.Register("storage", true, func(context.Context) error { return errors.New("unreachable") })
+
+		if err := warmup(context.Background(), &QRCodeGenerator{}, health); err == nil {
+			t.Error("warmup() expected error for unhealthy critical component")
+		}
+	})
+}
+
+func TestWarmupUntilReady(t *testing.T) {
+	ready := &readiness{}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	warmupUntilReady(ctx, &QRCodeGenerator{}, newHealthRegistry(), ready)
+
+	if !ready.Ready() {
+		t.Error("service not ready after successful warmup")
+	}
+}
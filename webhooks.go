@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Headers of outbound webhook requests
+const (
+	webhookIDHeader        = "X-Webhook-Id"
+	webhookEventHeader     = "X-Webhook-Event"
+	webhookTimestampHeader = "X-Webhook-Timestamp"
+	webhookSignatureHeader = "X-Webhook-Signature"
+)
+
+// Lifecycle events sent to webhooks
+const (
+	eventBatchCompleted = "batch.completed"
+)
+
+// webhookEvent is the JSON body of a webhook request
+type webhookEvent struct {
+	ID        string    `json:"id"`
+	Type      string    `json:"type"`
+	CreatedAt time.Time `json:"created_at"`
+	Data      any       `json:"data"`
+}
+
+// webhookDelivery is an event on its way to one endpoint. It keeps the
+// request ID and span of the request that emitted it, which the delivery
+// passes on to the receiver.
+type webhookDelivery struct {
+	endpoint  string
+	event     string
+	id        string
+	body      []byte
+	requestID string
+	span      trace.SpanContext
+}
+
+// webhookDispatcher sends lifecycle events to the configured endpoints in the
+// background, so emitting never slows down the request that caused the event.
+// Each request is signed with the shared secret. Failed deliveries are retried
+// with exponential backoff; events that arrive while the queue is full are
+// dropped and counted.
+type webhookDispatcher struct {
+	endpoints []string
+	secret    []byte
+	client    *http.Client
+	metrics   *metrics
+	attempts  int
+	backoff   time.Duration
+	now       func() time.Time
+
+	queue chan webhookDelivery
+	wg    sync.WaitGroup
+	// ctx bounds deliveries; stop cancels it once Close gives up draining
+	ctx  context.Context
+	stop context.CancelFunc
+}
+
+// newWebhookDispatcher starts workers delivering to the comma-separated
+// endpoints. It returns nil when there are none.
+func newWebhookDispatcher(endpoints, secret string, timeout time.Duration, attempts int, m *metrics) (*webhookDispatcher, error) {
+	urls := splitList(endpoints)
+	if len(urls) == 0 {
+		return nil, nil
+	}
+	for _, raw := range urls {
+		u, err := url.Parse(raw)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("invalid webhook endpoint %q: want an http(s) URL", raw)
+		}
+	}
+	if secret == "" {
+		return nil, fmt.Errorf("WEBHOOK_ENDPOINTS requires WEBHOOK_SECRET, so receivers can verify events")
+	}
+
+	ctx, stop := context.WithCancel(context.Background())
+	d := &webhookDispatcher{
+		endpoints: urls,
+		secret:    []byte(secret),
+		client:    newOutboundClient(timeout),
+		metrics:   m,
+		attempts:  max(attempts, 1),
+		backoff:   time.Second,
+		now:       time.Now,
+		queue:     make(chan webhookDelivery, 1000),
+		ctx:       ctx,
+		stop:      stop,
+	}
+	for range 4 {
+		d.wg.Add(1)
+		go d.work()
+	}
+	return d, nil
+}
+
+// Emit queues an event for every endpoint. It is a no-op on a nil dispatcher.
+func (d *webhookDispatcher) Emit(ctx context.Context, eventType string, data any) {
+	if d == nil {
+		return
+	}
+	event := webhookEvent{ID: newRequestID(), Type: eventType, CreatedAt: d.now().UTC(), Data: data}
+	body, err := json.Marshal(event)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to encode webhook event", "event", eventType, "error", err)
+		return
+	}
+	for _, endpoint := range d.endpoints {
+		select {
+		case d.queue <- webhookDelivery{endpoint: endpoint, event: eventType, id: event.ID, body: body, requestID: requestIDFromContext(ctx), span: trace.SpanContextFromContext(ctx)}:
+		default:
+			d.metrics.deliveries.WithLabelValues("webhook", "dropped").Inc()
+			slog.WarnContext(ctx, "webhook queue full, dropping event", "event", eventType, "endpoint", endpoint)
+		}
+	}
+}
+
+// work delivers queued events until the queue is closed
+func (d *webhookDispatcher) work() {
+	defer d.wg.Done()
+	for delivery := range d.queue {
+		status := jobSucceeded
+		if !d.deliver(delivery) {
+			status = jobFailed
+		}
+		d.metrics.deliveries.WithLabelValues("webhook", status).Inc()
+	}
+}
+
+// deliver sends one event, retrying network errors, 429, and 5xx responses
+// with exponential backoff. It reports whether the endpoint accepted it.
+func (d *webhookDispatcher) deliver(delivery webhookDelivery) bool {
+	backoff := d.backoff
+	for attempt := 1; ; attempt++ {
+		retry, err := d.send(delivery)
+		if err == nil {
+			return true
+		}
+		if !retry || attempt >= d.attempts {
+			slog.Error("webhook delivery failed", "event", delivery.event, "id", delivery.id, "endpoint", delivery.endpoint, "attempts", attempt, "error", err)
+			return false
+		}
+		slog.Warn("webhook delivery failed, retrying", "event", delivery.event, "id", delivery.id, "endpoint", delivery.endpoint, "attempt", attempt, "error", err)
+		if !sleepContext(d.ctx, backoff) {
+			return false
+		}
+		backoff *= 2
+	}
+}
+
+// send makes one signed delivery attempt, reporting whether a failure is
+// worth retrying
+func (d *webhookDispatcher) send(delivery webhookDelivery) (retry bool, err error) {
+	ctx := trace.ContextWithRemoteSpanContext(withRequestID(d.ctx, delivery.requestID), delivery.span)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.endpoint, bytes.NewReader(delivery.body))
+	if err != nil {
+		return false, err
+	}
+	timestamp := strconv.FormatInt(d.now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "qr-generator-webhooks")
+	req.Header.Set(webhookIDHeader, delivery.id)
+	req.Header.Set(webhookEventHeader, delivery.event)
+	req.Header.Set(webhookTimestampHeader, timestamp)
+	req.Header.Set(webhookSignatureHeader, "sha256="+webhookSignature(d.secret, timestamp, delivery.body))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	resp.Body.Close()
+	switch {
+	case resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return true, fmt.Errorf("endpoint answered %s", resp.Status)
+	}
+	return false, fmt.Errorf("endpoint answered %s", resp.Status)
+}
+
+// webhookSignature is the hex HMAC-SHA256 of "<timestamp>.<body>"; binding
+// the timestamp lets receivers reject replayed events
+func webhookSignature(secret []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	fmt.Fprintf(mac, "%s.%s", timestamp, body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Close delivers the queued events, giving up on pending retries when ctx is
+// done. Events must not be emitted after Close.
+func (d *webhookDispatcher) Close(ctx context.Context) {
+	if d == nil {
+		return
+	}
+	close(d.queue)
+	done := make(chan struct{})
+	go func() {
+		d.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		d.stop()
+		<-done
+	}
+	d.stop()
+}
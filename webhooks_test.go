@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// webhookReceiver is an endpoint answering with statuses in turn and
+// recording the requests it accepted
+type webhookReceiver struct {
+	mu       sync.Mutex
+	statuses []int
+	calls    int
+	accepted []*http.Request
+	bodies   [][]byte
+}
+
+func (rcv *webhookReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	rcv.mu.Lock()
+	defer rcv.mu.Unlock()
+	status := http.StatusNoContent
+	if rcv.calls < len(rcv.statuses) {
+		status = rcv.statuses[rcv.calls]
+	}
+	rcv.calls++
+	if status < 300 {
+		rcv.accepted = append(rcv.accepted, r)
+		rcv.bodies = append(rcv.bodies, body)
+	}
+	w.WriteHeader(status)
+}
+
+func newTestWebhookDispatcher(t *testing.T, endpoints string) *webhookDispatcher {
+	t.Helper()
+	d, err := newWebhookDispatcher(endpoints, "webhook-secret", time.Second, 3, newMetrics())
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.backoff = time.Millisecond
+	return d
+}
+
+func TestWebhookDispatcher(t *testing.T) {
+	tests := []struct {
+		name        string
+		statuses    []int
+		wantCalls   int
+		wantSuccess bool
+	}{
+		{name: "delivered", wantCalls: 1, wantSuccess: true},
+		{name: "retried after server errors", statuses: []int{http.StatusServiceUnavailable, http.StatusTooManyRequests}, wantCalls: 3, wantSuccess: true},
+		{name: "gives up after max attempts", statuses: []int{500, 500, 500, 500}, wantCalls: 3},
+		{name: "client error not retried", statuses: []int{http.StatusBadRequest}, wantCalls: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			receiver := &webhookReceiver{statuses: tt.statuses}
+			server := httptest.NewServer(receiver)
+			defer server.Close()
+
+			d := newTestWebhookDispatcher(t, server.URL+"/hook")
+			d.Emit(context.Background(), eventBatchCompleted, batchCompleted{RequestID: "req-1", Items: 2, Succeeded: 2})
+			d.Close(context.Background())
+
+			if receiver.calls != tt.wantCalls {
+				t.Errorf("%d calls, want %d", receiver.calls, tt.wantCalls)
+			}
+			status := jobFailed
+			if tt.wantSuccess {
+				status = jobSucceeded
+			}
+			if got := testutil.ToFloat64(d.metrics.deliveries.WithLabelValues("webhook", status)); got != 1 {
+				t.Errorf("%s deliveries = %v, want 1", status, got)
+			}
+			if !tt.wantSuccess {
+				return
+			}
+
+			req, body := receiver.accepted[0], receiver.bodies[0]
+			if req.Header.Get(webhookEventHeader) != eventBatchCompleted {
+				t.Errorf("event header = %q", req.Header.Get(webhookEventHeader))
+			}
+			want := "sha256=" + webhookSignature([]byte("webhook-secret"), req.Header.Get(webhookTimestampHeader), body)
+			if req.Header.Get(webhookSignatureHeader) != want {
+				t.Errorf("signature = %q, want %q", req.Header.Get(webhookSignatureHeader), want)
+			}
+			var event struct {
+				webhookEvent
+				Data batchCompleted `json:"data"`
+			}
+			if err := json.Unmarshal(body, &event); err != nil {
+				t.Fatal(err)
+			}
+			if event.Type != eventBatchCompleted || event.ID != req.Header.Get(webhookIDHeader) || event.Data.RequestID != "req-1" || event.Data.Succeeded != 2 {
+				t.Errorf("event = %+v", event)
+			}
+		})
+	}
+}
+
+func TestWebhookDispatcher_PropagatesRequestID(t *testing.T) {
+	receiver := &webhookReceiver{}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	d := newTestWebhookDispatcher(t, server.URL+"/hook")
+	d.Emit(withRequestID(context.Background(), "caller-7"), eventBatchCompleted, batchCompleted{RequestID: "caller-7"})
+	d.Close(context.Background())
+
+	if len(receiver.accepted) != 1 {
+		t.Fatalf("%d deliveries accepted, want 1", len(receiver.accepted))
+	}
+	if got := receiver.accepted[0].Header.Get(requestIDHeader); got != "caller-7" {
+		t.Errorf("delivery X-Request-ID = %q, want %q", got, "caller-7")
+	}
+}
+
+func TestWebhookDispatcher_FanOutAndOverflow(t *testing.T) {
+	receiver := &webhookReceiver{}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	d := newTestWebhookDispatcher(t, server.URL+"/a, "+server.URL+"/b")
+	d.Emit(context.Background(), eventBatchCompleted, batchCompleted{})
+	d.Close(context.Background())
+	if len(receiver.accepted) != 2 {
+		t.Errorf("%d deliveries, want one per endpoint", len(receiver.accepted))
+	}
+
+	// A dispatcher without workers fills its queue
+	full := &webhookDispatcher{endpoints: []string{server.URL}, metrics: newMetrics(), now: time.Now, queue: make(chan webhookDelivery, 1)}
+	full.Emit(context.Background(), eventBatchCompleted, batchCompleted{})
+	full.Emit(context.Background(), eventBatchCompleted, batchCompleted{})
+	if got := testutil.ToFloat64(full.metrics.deliveries.WithLabelValues("webhook", "dropped")); got != 1 {
+		t.Errorf("dropped = %v, want 1", got)
+	}
+}
+
+func TestWebhookDispatcher_CloseGivesUp(t *testing.T) {
+	server := httptest.NewServer(&webhookReceiver{statuses: []int{500, 500, 500}})
+	defer server.Close()
+	d := newTestWebhookDispatcher(t, server.URL)
+	d.backoff = time.Hour
+	d.Emit(context.Background(), eventBatchCompleted, batchCompleted{})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	d.Close(ctx)
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Close took %v while a retry was pending", elapsed)
+	}
+}
+
+func TestNewWebhookDispatcher(t *testing.T) {
+	tests := []struct {
+		name      string
+		endpoints string
+		secret    string
+		wantNil   bool
+		wantErr   bool
+	}{
+		{name: "disabled", wantNil: true},
+		{name: "missing secret", endpoints: "https://hooks.example.com", wantErr: true},
+		{name: "invalid endpoint", endpoints: "hooks.example.com", secret: "s", wantErr: true},
+		{name: "valid", endpoints: "https://hooks.example.com/a,http://receiver:8080/b", secret: "s"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, err := newWebhookDispatcher(tt.endpoints, tt.secret, time.Second, 3, newMetrics())
+			if (err != nil) != tt.wantErr || (d == nil) != (tt.wantNil || tt.wantErr) {
+				t.Fatalf("newWebhookDispatcher = (%v, %v)", d, err)
+			}
+			d.Close(context.Background())
+		})
+	}
+}
+
+func TestBatch_EmitsWebhook(t *testing.T) {
+	receiver := &webhookReceiver{}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	deps := newTestDeps()
+	deps.flags = mustFeatureFlags("batch")
+	deps.webhooks = newTestWebhookDispatcher(t, server.URL)
+	rec := httptest.NewRecorder()
+	newHandler(deps).ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v1/qr/batch", strings.NewReader(`{"items": [{"text": "a"}, {"text": ""}]}`)))
+	deps.webhooks.Close(context.Background())
+
+	if rec.Code != http.StatusOK || len(receiver.bodies) != 1 {
+		t.Fatalf("status %d with %d events, want 200 with 1", rec.Code, len(receiver.bodies))
+	}
+	var event struct {
+		Data batchCompleted `json:"data"`
+	}
+	json.Unmarshal(receiver.bodies[0], &event)
+	if event.Data.Items != 2 || event.Data.Succeeded != 1 || event.Data.Failed != 1 || event.Data.RequestID == "" {
+		t.Errorf("event data = %+v", event.Data)
+	}
+}
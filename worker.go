@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// generationJob is a generation request received from a message queue
+type generationJob struct {
+	// ID identifies the job in its completion event and names the stored
+	// image; when empty, the image is named after its content hash
+	ID   string `json:"id"`
+	Text string `json:"text"`
+}
+
+// jobResult is the completion event published for a job
+type jobResult struct {
+	ID     string    `json:"id,omitempty"`
+	Status string    `json:"status"`
+	URL    string    `json:"url,omitempty"`
+	Error  *apiError `json:"error,omitempty"`
+}
+
+// Statuses of completed jobs, also used as metric label
+const (
+	jobSucceeded = "succeeded"
+	jobFailed    = "failed"
+)
+
+// validJobID restricts job IDs to names safe to use as object keys
+var validJobID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,128}$`)
+
+// errJobRetryable marks failures that may succeed when the job is retried
+var errJobRetryable = errors.New("retryable job failure")
+
+// jobProcessor renders queued generation jobs into an object store. It is
+// shared by the message queue integrations, which only differ in how jobs
+// arrive and how results are acknowledged.
+type jobProcessor struct {
+	deps  handlerDeps
+	store objectStore
+	// source names the integration in logs and metrics
+	source string
+	retryPolicy
+}
+
+// retryPolicy is how often and how late a job failing transiently is tried
+type retryPolicy struct {
+	// attempts is how often a retryable failure is tried before the job
+	// fails for good
+	attempts int
+	// backoff is the wait before the first retry. It doubles with every
+	// retry, up to maxBackoff unless that is zero.
+	backoff    time.Duration
+	maxBackoff time.Duration
+}
+
+// delay returns the wait after the given attempt, counted from 1
+func (p retryPolicy) delay(attempt int) time.Duration {
+	delay := p.backoff
+	for range attempt - 1 {
+		if p.maxBackoff > 0 && delay >= p.maxBackoff {
+			break
+		}
+		delay *= 2
+	}
+	if p.maxBackoff > 0 {
+		delay = min(delay, p.maxBackoff)
+	}
+	return delay
+}
+
+// process renders the job in body, stores the image, and returns the
+// completion event. Invalid jobs fail immediately; retryable failures such as
+// a full render queue or an unavailable store are retried with backoff. It
+// only returns an error when ctx is done, in which case the job should be
+// left for redelivery.
+func (p *jobProcessor) process(ctx context.Context, body []byte) (jobResult, error) {
+	job, apiErr := decodeJob(body)
+	if apiErr != nil {
+		return p.finish(ctx, jobResult{ID: job.ID, Status: jobFailed, Error: apiErr}), nil
+	}
+	return p.processJob(ctx, job)
+}
+
+// processJob is process for a decoded and checked job
+func (p *jobProcessor) processJob(ctx context.Context, job generationJob) (jobResult, error) {
+	for attempt := 1; ; attempt++ {
+		result, err := p.attempt(ctx, job)
+		if err == nil || !errors.Is(err, errJobRetryable) || attempt >= p.attempts {
+			return p.finish(ctx, result), nil
+		}
+		slog.WarnContext(ctx, "job failed, retrying", "source", p.source, "job_id", job.ID, "attempt", attempt, "error", err)
+		p.deps.metrics.jobRetries.WithLabelValues(p.source).Inc()
+		select {
+		case <-ctx.Done():
+			return jobResult{}, ctx.Err()
+		case <-time.After(p.delay(attempt)):
+		}
+	}
+}
+
+// processAll processes jobs in parallel on up to workers goroutines and
+// returns their results in order. Jobs with invalid IDs fail without
+// rendering. It stops handing out jobs once ctx is done; the results of jobs
+// not processed by then are empty.
+func (p *jobProcessor) processAll(ctx context.Context, workers int, jobs []generationJob) []jobResult {
+	results := make([]jobResult, len(jobs))
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for range min(max(workers, 1), len(jobs)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				if apiErr := jobs[i].check(); apiErr != nil {
+					results[i] = p.finish(ctx, jobResult{ID: jobs[i].ID, Status: jobFailed, Error: apiErr})
+					continue
+				}
+				if result, err := p.processJob(ctx, jobs[i]); err == nil {
+					results[i] = result
+				}
+			}
+		}()
+	}
+
+dispatch:
+	for i := range jobs {
+		select {
+		case indexes <- i:
+		case <-ctx.Done():
+			break dispatch
+		}
+	}
+	close(indexes)
+	wg.Wait()
+	return results
+}
+
+// decodeJob parses and checks a job message
+func decodeJob(body []byte) (generationJob, *apiError) {
+	var job generationJob
+	if err := json.Unmarshal(body, &job); err != nil {
+		return generationJob{}, &apiError{Code: errCodeInvalidPayload, Message: "Job is not valid JSON: " + err.Error()}
+	}
+	if apiErr := job.check(); apiErr != nil {
+		return generationJob{}, apiErr
+	}
+	return job, nil
+}
+
+// check rejects IDs that are unsafe as object keys
+func (job generationJob) check() *apiError {
+	if job.ID != "" && !validJobID.MatchString(job.ID) {
+		return &apiError{Code: errCodeInvalidParam, Message: "Job 'id' must be 1-128 letters, digits, '.', '_' or '-'"}
+	}
+	return nil
+}
+
+// attempt renders and stores the job once. Panics are recovered into a
+// retryable failure so one bad job cannot crash the worker.
+func (p *jobProcessor) attempt(ctx context.Context, job generationJob) (result jobResult, err error) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			err = fmt.Errorf("%w: panic: %v", errJobRetryable, recovered)
+			stack := debug.Stack()
+			slog.ErrorContext(ctx, "panic recovered in job", "source", p.source, "job_id", job.ID, "error", err, "stack", string(stack))
+			p.deps.reporter.Report(ctx, errorEvent{Err: err, Panic: true, Stack: stack})
+			result = jobResult{ID: job.ID, Status: jobFailed, Error: &apiError{Code: errCodeRenderFailed, Message: "Failed to generate QR code"}}
+		}
+	}()
+
+	pngBytes, apiErr := renderText(ctx, p.deps, job.Text)
+	if apiErr != nil {
+		result = jobResult{ID: job.ID, Status: jobFailed, Error: apiErr}
+		if result.retryable() {
+			return result, fmt.Errorf("%w: %s", errJobRetryable, apiErr.Message)
+		}
+		return result, nil
+	}
+
+	key := job.ID
+	if key == "" {
+		key = imageCacheKey(job.Text, renderVersion, "png", "256", "medium")
+	}
+	url, err := p.store.Put(ctx, key+".png", pngBytes, "image/png")
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to store job result", "source", p.source, "job_id", job.ID, "error", err)
+		return jobResult{ID: job.ID, Status: jobFailed, Error: &apiError{Code: errCodeRenderFailed, Message: "Failed to store the image"}},
+			fmt.Errorf("%w: %v", errJobRetryable, err)
+	}
+	return jobResult{ID: job.ID, Status: jobSucceeded, URL: url}, nil
+}
+
+// retryable reports whether a failed job may succeed on redelivery
+func (r jobResult) retryable() bool {
+	return r.Error != nil && (r.Error.Code == errCodeServerBusy || r.Error.Code == errCodeRenderFailed)
+}
+
+// finish logs and counts a completed job
+func (p *jobProcessor) finish(ctx context.Context, result jobResult) jobResult {
+	p.deps.metrics.jobsProcessed.WithLabelValues(p.source, result.Status).Inc()
+	if result.Status == jobSucceeded {
+		p.deps.metrics.generatedTotal.Inc()
+		slog.DebugContext(ctx, "job succeeded", "source", p.source, "job_id", result.ID, "url", result.URL)
+	} else {
+		slog.InfoContext(ctx, "job failed", "source", p.source, "job_id", result.ID, "code", result.Error.Code, "reason", result.Error.Message)
+	}
+	return result
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// memoryStore is an in-memory objectStore whose first failures Put calls fail
+type memoryStore struct {
+	mu       sync.Mutex
+	failures int
+	puts     int
+	objects  map[string][]byte
+}
+
+func (s *memoryStore) Put(ctx context.Context, key string, data []byte, contentType string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.puts++
+	if s.failures != 0 {
+		s.failures--
+		return "", errors.New("storage unavailable")
+	}
+	if s.objects == nil {
+		s.objects = map[string][]byte{}
+	}
+	s.objects[key] = data
+	return "mem://" + key, nil
+}
+
+func (s *memoryStore) Check(ctx context.Context) error { return nil }
+
+// newTestJobProcessor returns a processor storing into store with fast retries
+func newTestJobProcessor(store objectStore) *jobProcessor {
+	return &jobProcessor{deps: newTestDeps(), store: store, source: "test", retryPolicy: retryPolicy{attempts: 3, backoff: time.Millisecond}}
+}
+
+func TestJobProcessor_Process(t *testing.T) {
+	tests := []struct {
+		name         string
+		body         string
+		storeFails   int
+		wantStatus   string
+		wantCode     string
+		wantURL      string
+		wantPuts     int
+		wantObjectAt string
+	}{
+		{name: "rendered and stored", body: `{"id": "order-42", "text": "https://example.com"}`, wantStatus: jobSucceeded, wantURL: "mem://order-42.png", wantPuts: 1, wantObjectAt: "order-42.png"},
+		{name: "named after content without id", body: `{"text": "hello"}`, wantStatus: jobSucceeded, wantPuts: 1},
+		{name: "invalid json", body: `{"text":`, wantStatus: jobFailed, wantCode: errCodeInvalidPayload},
+		{name: "unsafe id", body: `{"id": "../etc/passwd", "text": "hello"}`, wantStatus: jobFailed, wantCode: errCodeInvalidParam},
+		{name: "missing text not retried", body: `{"id": "a"}`, wantStatus: jobFailed, wantCode: errCodeInvalidPayload},
+		{name: "policy violation not retried", body: `{"id": "a", "text": "javascript:alert(1)"}`, wantStatus: jobFailed, wantCode: errCodePolicyViolation},
+		{name: "storage recovers on retry", body: `{"id": "a", "text": "hello"}`, storeFails: 2, wantStatus: jobSucceeded, wantURL: "mem://a.png", wantPuts: 3},
+		{name: "storage down after all attempts", body: `{"id": "a", "text": "hello"}`, storeFails: -1, wantStatus: jobFailed, wantCode: errCodeRenderFailed, wantPuts: 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := &memoryStore{failures: tt.storeFails}
+			p := newTestJobProcessor(store)
+			result, err := p.process(context.Background(), []byte(tt.body))
+			if err != nil {
+				t.Fatalf("process() error = %v", err)
+			}
+
+			if result.Status != tt.wantStatus {
+				t.Errorf("status = %q, want %q (error %+v)", result.Status, tt.wantStatus, result.Error)
+			}
+			if tt.wantCode != "" && (result.Error == nil || result.Error.Code != tt.wantCode) {
+				t.Errorf("error = %+v, want code %q", result.Error, tt.wantCode)
+			}
+			if tt.wantURL != "" && result.URL != tt.wantURL {
+				t.Errorf("URL = %q, want %q", result.URL, tt.wantURL)
+			}
+			if tt.wantStatus == jobSucceeded && !strings.HasSuffix(result.URL, ".png") {
+				t.Errorf("URL = %q, want a .png object", result.URL)
+			}
+			if store.puts != tt.wantPuts {
+				t.Errorf("%d Put calls, want %d", store.puts, tt.wantPuts)
+			}
+			if tt.wantObjectAt != "" && len(store.objects[tt.wantObjectAt]) == 0 {
+				t.Errorf("no image stored at %q", tt.wantObjectAt)
+			}
+			if got := testutil.ToFloat64(p.deps.metrics.jobsProcessed.WithLabelValues("test", tt.wantStatus)); got != 1 {
+				t.Errorf("jobs processed metric = %v, want 1", got)
+			}
+		})
+	}
+}
+
+func TestJobProcessor_ProcessCanceled(t *testing.T) {
+	p := newTestJobProcessor(&memoryStore{failures: -1})
+	p.attempts, p.backoff = 10, time.Hour
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	if _, err := p.process(ctx, []byte(`{"id": "a", "text": "hello"}`)); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("process() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestJobProcessor_FileStore(t *testing.T) {
+	dir := t.TempDir()
+	p := newTestJobProcessor(&fileStore{dir: dir})
+	result, err := p.process(context.Background(), []byte(`{"id": "label-1", "text": "hello"}`))
+	if err != nil || result.Status != jobSucceeded {
+		t.Fatalf("process() = %+v, %v", result, err)
+	}
+	data, err := os.ReadFile(dir + "/label-1.png")
+	if err != nil || !strings.HasPrefix(string(data), "\x89PNG") {
+		t.Errorf("stored image is not a PNG: %v", err)
+	}
+}